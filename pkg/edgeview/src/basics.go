@@ -133,6 +133,7 @@ func initOpts() {
 		"top",
 		"volume",
 		"pprof",
+		"pubsub",
 	}
 
 	logdirectory = []string{
@@ -681,6 +682,9 @@ func printHelp(opt string) {
 			helpOn("datastore", "display the device current datastore: EQDN, type, cipher information")
 		case "pprof":
 			helpOn("pprof", "pprof/on to turn on pprof; pprof/off to turn off again")
+		case "pubsub":
+			helpOn("pubsub[/<filter>]", "display the publications and subscriptions of the agents in zedbox with their key count, restart counter, synchronized flag and last change time")
+			helpExample("pubsub/volumemgr", "display the ones owned by or published by volumemgr", true)
 		case "dmesg":
 			helpOn("dmesg", "display the device current dmesg information")
 		case "download":
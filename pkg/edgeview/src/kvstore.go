@@ -0,0 +1,154 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// The agents of zedbox which keep their persistent publications in a
+// single store rather than in files under /persist/status have it here,
+// see pkg/pillar/pubsub/kvdriver for its format.
+const (
+	kvStoreDir       = "/persist/kvstore/"
+	kvStoreMagic     = "EVEKVS1\n"
+	kvRecordHdrSize  = 8
+	kvMaxRecordSize  = 64 * 1024 * 1024
+	kvOpPut          = 1
+	kvOpDelete       = 2
+	kvOpCommit       = 5
+	kvStoreExtension = ".db"
+)
+
+var kvCrcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// readKVStore returns the items of the buckets of the store, as of its
+// last committed transaction
+func readKVStore(path string) (map[string]map[string][]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+	magic := make([]byte, len(kvStoreMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != kvStoreMagic {
+		return nil, errors.New("not a pubsub store")
+	}
+	buckets := make(map[string]map[string][]byte)
+	var pending [][]byte
+	hdr := make([]byte, kvRecordHdrSize)
+	for {
+		// Stops at the end, or at a torn or corrupted record
+		if _, err := io.ReadFull(br, hdr); err != nil {
+			break
+		}
+		length := binary.LittleEndian.Uint32(hdr[0:4])
+		crc := binary.LittleEndian.Uint32(hdr[4:8])
+		if length == 0 || length > kvMaxRecordSize {
+			break
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(br, payload); err != nil ||
+			crc32.Checksum(payload, kvCrcTable) != crc {
+			break
+		}
+		if payload[0] != kvOpCommit {
+			pending = append(pending, payload)
+			continue
+		}
+		for _, p := range pending {
+			r := bytes.NewReader(p[1:])
+			name, err1 := readKVField(r)
+			key, err2 := readKVField(r)
+			val, err3 := readKVField(r)
+			if err1 != nil || err2 != nil || err3 != nil {
+				return nil, fmt.Errorf("bad record in %s", path)
+			}
+			items := buckets[string(name)]
+			if items == nil {
+				items = make(map[string][]byte)
+				buckets[string(name)] = items
+			}
+			switch p[0] {
+			case kvOpPut:
+				items[string(key)] = val
+			case kvOpDelete:
+				delete(items, string(key))
+			}
+		}
+		pending = nil
+	}
+	return buckets, nil
+}
+
+func readKVField(r *bytes.Reader) ([]byte, error) {
+	length, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if length > uint64(r.Len()) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	b := make([]byte, length)
+	_, err = io.ReadFull(r, b)
+	return b, err
+}
+
+// pubsubKVStore prints the persistent publications of the agent kept in
+// its store whose topic contains topicFilter
+func pubsubKVStore(agent, topicFilter string) {
+	path := kvStoreDir + agent + kvStoreExtension
+	buckets, err := readKVStore(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Printf("read store error: %v\n", err)
+		}
+		return
+	}
+	printColor("\n pubsub in: "+path, colorBLUE)
+	names := make([]string, 0, len(buckets))
+	for name := range buckets {
+		topic := strings.TrimPrefix(name, agent+"/")
+		if topicFilter != "" &&
+			!strings.Contains(strings.ToLower(topic), strings.ToLower(topicFilter)) {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	byteCnt := 0
+	for _, name := range names {
+		printColor("  "+name, colorGREEN)
+		items := buckets[name]
+		keys := make([]string, 0, len(items))
+		for key := range items {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Printf("   service: %s.json\n", key)
+			prettyJSON, _ := formatJSON(items[key])
+			byteCnt += len(prettyJSON)
+			if byteCnt > 5000 {
+				closePipe(true)
+				byteCnt = 0
+			}
+			fmt.Println(string(prettyJSON))
+			if isTechSupport {
+				closePipe(true)
+			}
+		}
+	}
+	closePipe(true)
+}
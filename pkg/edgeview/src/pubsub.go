@@ -5,10 +5,14 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"net"
+	"net/http"
 	"os"
 	"strings"
+	"time"
 )
 
 func runPubsub(pubStr string) {
@@ -36,6 +40,7 @@ func runPubsub(pubStr string) {
 			pubsubdir = p
 			subdir = ""
 		}
+		topicFilter := subdir
 
 		for _, sdir := range startdir {
 			if sdir == "/persist/status/" {
@@ -73,6 +78,11 @@ func runPubsub(pubStr string) {
 				pubsubSvs(sdir, pubsubdir, subdir)
 			}
 			closePipe(true)
+			if sdir == "/persist/status/" {
+				// The agents which moved to a store do not write
+				// their persistent publications there anymore
+				pubsubKVStore(pubsubdir, topicFilter)
+			}
 		}
 	}
 }
@@ -134,3 +144,103 @@ func formatJSON(data []byte) ([]byte, error) {
 	}
 	return data, nil
 }
+
+// pubsubSocket is where zedbox serves the publications and subscriptions
+// of its agents, see types.PubSubIntrospectSocket in pillar
+const pubsubSocket = "/run/zedbox-pubsub.sock"
+
+// pubsubTopic is the pubsub.TopicInfo from pillar
+type pubsubTopic struct {
+	Kind           string
+	Agent          string
+	Name           string
+	Persistent     bool
+	Keys           int
+	RestartCounter int
+	Synchronized   bool
+	LastChange     time.Time
+	Coalesced      uint64
+}
+
+// getPubsubTopics - in 'runSystem', lists what the agents in zedbox
+// publish and subscribe to, including the in-memory only topics
+func getPubsubTopics(opt string) {
+	var filter string
+	if strings.HasPrefix(opt, "pubsub/") {
+		filter = strings.ToLower(strings.TrimPrefix(opt, "pubsub/"))
+	}
+	var topics []pubsubTopic
+	if err := zedboxGet("/topics", &topics); err != nil {
+		fmt.Printf("pubsub topics error: %v\n", err)
+		return
+	}
+	printColor(fmt.Sprintf(" %-12s %-12s %-50s %5s %7s %5s %s", "agent", "kind",
+		"name", "keys", "restart", "sync", "last change"), colorCYAN)
+	for _, t := range topics {
+		if filter != "" && !strings.Contains(strings.ToLower(t.Name), filter) &&
+			!strings.Contains(strings.ToLower(t.Agent), filter) {
+			continue
+		}
+		lastChange := "-"
+		if !t.LastChange.IsZero() {
+			lastChange = t.LastChange.UTC().Format(time.RFC3339)
+		}
+		line := fmt.Sprintf(" %-12s %-12s %-50s %5d %7d %5v %s", t.Agent, t.Kind,
+			t.Name, t.Keys, t.RestartCounter, t.Synchronized, lastChange)
+		if t.Coalesced != 0 {
+			line += fmt.Sprintf(" (%d coalesced)", t.Coalesced)
+		}
+		if t.Kind == "subscription" && !t.Synchronized {
+			printColor(line, colorYELLOW)
+		} else {
+			fmt.Println(line)
+		}
+	}
+}
+
+// appBlocker is the objdeps.AppBlocker from pillar
+type appBlocker struct {
+	AppUUID     string
+	AppName     string
+	Description string
+}
+
+// getAppBlockers - in 'getSysApp', what the app instances wait for
+func getAppBlockers() {
+	var blockers []appBlocker
+	if err := zedboxGet("/apps", &blockers); err != nil {
+		fmt.Printf("app blockers error: %v\n", err)
+		return
+	}
+	printColor("\n - app instances blocked by:", colorCYAN)
+	for _, b := range blockers {
+		if b.Description == "" {
+			fmt.Printf("  %s (%s): not blocked\n", b.AppName, b.AppUUID)
+			continue
+		}
+		printColor(fmt.Sprintf("  %s (%s): %s", b.AppName, b.AppUUID, b.Description),
+			colorYELLOW)
+	}
+}
+
+// zedboxGet gets path from the introspection socket of zedbox into v
+func zedboxGet(path string, v interface{}) error {
+	client := http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", pubsubSocket)
+			},
+		},
+		Timeout: 10 * time.Second,
+	}
+	resp, err := client.Get("http://zedbox" + path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
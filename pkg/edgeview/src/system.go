@@ -99,6 +99,8 @@ func runSystem(cmds cmdOpt, sysOpt string) {
 			getTarFile(opt)
 		} else if strings.HasPrefix(opt, "pprof") {
 			togglePprof(opt)
+		} else if strings.HasPrefix(opt, "pubsub/") || opt == "pubsub" {
+			getPubsubTopics(opt)
 		} else {
 			fmt.Printf("opt %s: not supported yet\n", opt)
 		}
@@ -390,6 +392,7 @@ func getSysApp() {
 		fmt.Printf("    VNC enabled: %v, VNC display id: %d, Applog disabled: %v\n",
 			config.EnableVnc, config.VncDisplay, config.DisableLogs)
 	}
+	getAppBlockers()
 }
 
 func getDataStore() {
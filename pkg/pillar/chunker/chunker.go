@@ -0,0 +1,190 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package chunker splits content into content-defined chunks, like casync
+// and desync do, and describes the content with an Index of its chunks.
+// The boundaries of the chunks depend only on the bytes around them, so two
+// versions of an image which differ in a few blocks share most of their
+// chunks, and a device holding one version only needs the chunks of the other
+// it does not have.
+//
+// A boundary is placed after byte i once the chunk is at least MinSize long if
+// the top bits of the gear hash h = h<<1 + gear[data[i]], computed from the
+// MinSize-th byte of the chunk on, are all zero, log2(AvgSize) of them. Chunks
+// are cut at MaxSize otherwise. gear[b] is the big endian uint64 of the first
+// 8 bytes of the sha256 of the single byte b.
+package chunker
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+)
+
+// IndexVersion is the version of the Index format
+const IndexVersion = 1
+
+// IndexMediaType is the media type of the Index in OCI registries
+const IndexMediaType = "application/vnd.lfedge.eve.chunk-index.v1+json"
+
+// Params of the chunking
+type Params struct {
+	MinSize int `json:"minChunkSize"`
+	AvgSize int `json:"avgChunkSize"` // a power of 2
+	MaxSize int `json:"maxChunkSize"`
+}
+
+// DefaultParams suit squashfs images, whose blocks are 128KiB at most
+var DefaultParams = Params{
+	MinSize: 16 << 10,
+	AvgSize: 64 << 10,
+	MaxSize: 256 << 10,
+}
+
+// Validate returns an error if the params cannot be used
+func (p Params) Validate() error {
+	if p.MinSize <= 0 || p.AvgSize <= p.MinSize || p.MaxSize <= p.AvgSize {
+		return fmt.Errorf("invalid chunk sizes %d/%d/%d", p.MinSize, p.AvgSize, p.MaxSize)
+	}
+	if bits.OnesCount(uint(p.AvgSize)) != 1 {
+		return fmt.Errorf("average chunk size %d is not a power of 2", p.AvgSize)
+	}
+	if p.MaxSize > 16<<20 {
+		return fmt.Errorf("maximum chunk size %d over 16MiB", p.MaxSize)
+	}
+	return nil
+}
+
+// Chunk of an Index
+type Chunk struct {
+	Sha256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+}
+
+// Index describes content as the sequence of its chunks
+type Index struct {
+	Version int    `json:"version"`
+	Sha256  string `json:"sha256"`
+	Size    int64  `json:"size"`
+	Params
+	Chunks []Chunk `json:"chunks"`
+}
+
+// Validate returns an error if the index is not consistent
+func (index Index) Validate() error {
+	if index.Version != IndexVersion {
+		return fmt.Errorf("unsupported chunk index version %d", index.Version)
+	}
+	if err := index.Params.Validate(); err != nil {
+		return err
+	}
+	var size int64
+	for _, chunk := range index.Chunks {
+		if raw, err := hex.DecodeString(chunk.Sha256); err != nil || len(raw) != sha256.Size {
+			return fmt.Errorf("invalid chunk sha256 %q", chunk.Sha256)
+		}
+		if chunk.Size <= 0 || chunk.Size > int64(index.MaxSize) {
+			return fmt.Errorf("invalid size %d of chunk %s", chunk.Size, chunk.Sha256)
+		}
+		size += chunk.Size
+	}
+	if size != index.Size {
+		return fmt.Errorf("chunks of %d bytes for %d bytes", size, index.Size)
+	}
+	return nil
+}
+
+var gear [256]uint64
+
+func init() {
+	for i := range gear {
+		sum := sha256.Sum256([]byte{byte(i)})
+		gear[i] = binary.BigEndian.Uint64(sum[:8])
+	}
+}
+
+// boundary returns the length of the chunk at the start of data, which
+// holds at least MaxSize bytes unless it is the end of the content
+func boundary(data []byte, p Params) int {
+	if len(data) <= p.MinSize {
+		return len(data)
+	}
+	end := len(data)
+	if end > p.MaxSize {
+		end = p.MaxSize
+	}
+	zeroBits := bits.TrailingZeros(uint(p.AvgSize))
+	mask := ^uint64(0) << (64 - zeroBits)
+	var h uint64
+	for i := p.MinSize; i < end; i++ {
+		h = h<<1 + gear[data[i]]
+		if h&mask == 0 {
+			return i + 1
+		}
+	}
+	return end
+}
+
+// Split calls f with the offset and the data of each chunk of the content
+// read from r. The data is only valid until f returns.
+func Split(r io.Reader, p Params, f func(offset int64, data []byte) error) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	buf := make([]byte, 2*p.MaxSize)
+	var offset int64
+	start, end := 0, 0
+	eof := false
+	for {
+		if !eof && end-start < p.MaxSize {
+			// Move what is left to the front and fill the buffer
+			end = copy(buf, buf[start:end])
+			start = 0
+			n, err := io.ReadFull(r, buf[end:])
+			end += n
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				eof = true
+			} else if err != nil {
+				return err
+			}
+		}
+		if start == end {
+			return nil
+		}
+		n := boundary(buf[start:end], p)
+		if err := f(offset, buf[start:start+n]); err != nil {
+			return err
+		}
+		offset += int64(n)
+		start += n
+	}
+}
+
+// MakeIndex returns the index of the content read from r
+func MakeIndex(r io.Reader, p Params) (*Index, error) {
+	index := &Index{
+		Version: IndexVersion,
+		Params:  p,
+		Chunks:  []Chunk{},
+	}
+	hash := sha256.New()
+	err := Split(r, p, func(offset int64, data []byte) error {
+		hash.Write(data)
+		sum := sha256.Sum256(data)
+		index.Chunks = append(index.Chunks, Chunk{
+			Sha256: hex.EncodeToString(sum[:]),
+			Size:   int64(len(data)),
+		})
+		index.Size += int64(len(data))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	index.Sha256 = hex.EncodeToString(hash.Sum(nil))
+	return index, nil
+}
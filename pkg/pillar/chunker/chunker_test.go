@@ -0,0 +1,122 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package chunker
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testParams = Params{MinSize: 1 << 10, AvgSize: 4 << 10, MaxSize: 16 << 10}
+
+func testContent(size int, seed int64) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+func TestSplit(t *testing.T) {
+	testMatrix := map[string][]byte{
+		"empty":       {},
+		"short":       testContent(100, 1),
+		"one chunk":   testContent(testParams.MinSize, 2),
+		"random":      testContent(1<<20, 3),
+		"zeroes":      make([]byte, 100<<10),
+		"max size +1": testContent(testParams.MaxSize+1, 4),
+	}
+	for testname, data := range testMatrix {
+		var joined []byte
+		var offsets []int64
+		err := Split(bytes.NewReader(data), testParams, func(offset int64, chunk []byte) error {
+			assert.Equal(t, int64(len(joined)), offset, testname)
+			assert.LessOrEqual(t, len(chunk), testParams.MaxSize, testname)
+			offsets = append(offsets, offset)
+			joined = append(joined, chunk...)
+			return nil
+		})
+		assert.NoError(t, err, testname)
+		assert.Equal(t, len(data), len(joined), testname)
+		assert.True(t, bytes.Equal(data, joined), testname)
+		// Only the last chunk may be shorter than MinSize
+		for i := 0; i+1 < len(offsets); i++ {
+			assert.GreaterOrEqual(t, offsets[i+1]-offsets[i], int64(testParams.MinSize),
+				testname)
+		}
+	}
+}
+
+func TestMakeIndexSharesChunks(t *testing.T) {
+	data := testContent(1<<20, 5)
+	index, err := MakeIndex(bytes.NewReader(data), testParams)
+	assert.NoError(t, err)
+	assert.NoError(t, index.Validate())
+	sum := sha256.Sum256(data)
+	assert.Equal(t, hex.EncodeToString(sum[:]), index.Sha256)
+	assert.Equal(t, int64(len(data)), index.Size)
+	assert.Greater(t, len(index.Chunks), 1<<20/testParams.MaxSize)
+
+	// Insert a few bytes and change a few others, most chunks are unchanged
+	changed := append(append(append([]byte{}, data[:300<<10]...), []byte("inserted")...),
+		data[300<<10:]...)
+	copy(changed[700<<10:], "overwritten")
+	newIndex, err := MakeIndex(bytes.NewReader(changed), testParams)
+	assert.NoError(t, err)
+	chunks := make(map[string]bool)
+	for _, chunk := range index.Chunks {
+		chunks[chunk.Sha256] = true
+	}
+	var shared int64
+	for _, chunk := range newIndex.Chunks {
+		if chunks[chunk.Sha256] {
+			shared += chunk.Size
+		}
+	}
+	assert.Greater(t, shared, newIndex.Size-4*int64(testParams.MaxSize))
+}
+
+func TestValidate(t *testing.T) {
+	index, err := MakeIndex(bytes.NewReader(testContent(64<<10, 6)), testParams)
+	assert.NoError(t, err)
+
+	testMatrix := map[string]struct {
+		modify func(index *Index)
+		valid  bool
+	}{
+		"valid": {
+			modify: func(index *Index) {},
+			valid:  true,
+		},
+		"version": {
+			modify: func(index *Index) { index.Version = 2 },
+		},
+		"average size": {
+			modify: func(index *Index) { index.AvgSize = 5 << 10 },
+		},
+		"sizes": {
+			modify: func(index *Index) { index.MinSize = index.MaxSize },
+		},
+		"chunk sha256": {
+			modify: func(index *Index) { index.Chunks[0].Sha256 = "sha256:0" },
+		},
+		"total size": {
+			modify: func(index *Index) { index.Size++ },
+		},
+	}
+	for testname, test := range testMatrix {
+		modified := *index
+		modified.Chunks = append([]Chunk{}, index.Chunks...)
+		test.modify(&modified)
+		err := modified.Validate()
+		if test.valid {
+			assert.NoError(t, err, testname)
+		} else {
+			assert.Error(t, err, testname)
+		}
+	}
+}
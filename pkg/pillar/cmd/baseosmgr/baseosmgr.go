@@ -15,6 +15,7 @@ import (
 	"github.com/lf-edge/eve/pkg/pillar/agentbase"
 	"github.com/lf-edge/eve/pkg/pillar/agentlog"
 	"github.com/lf-edge/eve/pkg/pillar/base"
+	"github.com/lf-edge/eve/pkg/pillar/persistcache"
 	"github.com/lf-edge/eve/pkg/pillar/pidfile"
 	"github.com/lf-edge/eve/pkg/pillar/pubsub"
 	"github.com/lf-edge/eve/pkg/pillar/types"
@@ -59,7 +60,7 @@ type baseOsMgrContext struct {
 	currentUpdateRetry   uint32    // UpdateRetryCounter from last retry; it will be sent for info
 	configUpdateRetry    uint32    // UpdateRetryCounter from config; to avoid loop after reboot with failed testing
 
-	worker worker.Worker // For background work
+	worker *worker.Durable // For background work
 	// cli options
 	versionPtr *bool
 }
@@ -119,9 +120,22 @@ func Run(ps *pubsub.PubSub, loggerArg *logrus.Logger, logArg *base.LogObject, ar
 	// publish initial zboot partition status
 	updateAndPublishZbootStatusAll(&ctx)
 
-	ctx.worker = worker.NewPool(log, &ctx, 20, map[string]worker.Handler{
+	// The installs are recorded to resume the ones interrupted by a
+	// power loss
+	installCache, err := persistcache.New(types.PersistCacheBaseOsInstall)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx.worker, err = worker.NewDurablePool(log, &ctx, 20, 0, map[string]worker.Handler{
 		workInstall: {Request: installWorker, Response: processInstallWorkResult},
-	})
+	}, installCache)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, record := range ctx.worker.Recovered() {
+		log.Noticef("%s of %s to %s was interrupted by a restart",
+			record.Kind, record.Key, record.Checkpoint)
+	}
 
 	// report other agents, about, zboot status availability
 	ctx.pubZbootStatus.SignalRestarted()
@@ -453,6 +467,7 @@ func initializeVolumemgrHandles(ps *pubsub.PubSub, ctx *baseOsMgrContext) {
 			CreateHandler: handleContentTreeStatusCreate,
 			ModifyHandler: handleContentTreeStatusModify,
 			DeleteHandler: handleContentTreeStatusDelete,
+			SyncHandler:   handleContentTreeStatusSynchronized,
 			WarningTime:   warningTime,
 			ErrorTime:     errorTime,
 		})
@@ -49,3 +49,21 @@ func handleContentTreeStatusImpl(ctxArg interface{}, key string,
 	}
 	log.Functionf("handleContentTreeStatusImpl done for %s", key)
 }
+
+// handleContentTreeStatusSynchronized drops the installs interrupted by a
+// restart whose content tree is gone; the others are submitted again once
+// their content tree is loaded.
+func handleContentTreeStatusSynchronized(ctxArg interface{}, done bool) {
+	ctx := ctxArg.(*baseOsMgrContext)
+	log.Functionf("handleContentTreeStatusSynchronized(%v)", done)
+	if !done {
+		return
+	}
+	for _, record := range ctx.worker.Recovered() {
+		if lookupContentTreeStatus(ctx, record.Key) == nil {
+			log.Noticef("Dropping the interrupted %s of %s",
+				record.Kind, record.Key)
+			ctx.worker.Cancel(record.Key)
+		}
+	}
+}
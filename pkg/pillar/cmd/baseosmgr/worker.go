@@ -4,6 +4,7 @@
 package baseosmgr
 
 import (
+	"encoding/json"
 	"fmt"
 	"time"
 
@@ -22,6 +23,15 @@ type installWorkDescription struct {
 	target string
 }
 
+// installCheckpoint is what an install records as it writes the partition,
+// for a restart to resume the write of the same image to the same partition
+type installCheckpoint struct {
+	Ref    string
+	Target string
+	// Written is how many bytes are written and synced to the partition
+	Written int64
+}
+
 // AddWorkInstall create a Work job to install the provided image to the target path
 func AddWorkInstall(ctx *baseOsMgrContext, key, ref, target string) {
 	d := installWorkDescription{
@@ -56,8 +66,32 @@ func installWorker(ctxPtr interface{}, w worker.Work) worker.WorkResult {
 		return result
 	}
 
+	var offset int64
+	if w.Resume != nil {
+		var resume installCheckpoint
+		if err := json.Unmarshal(w.Resume, &resume); err != nil ||
+			resume.Ref != d.ref || resume.Target != d.target {
+			log.Noticef("installWorker restarts the install of %s to %s interrupted by a restart: checkpoint of another install",
+				d.ref, d.target)
+		} else {
+			offset = resume.Written
+			log.Noticef("installWorker resumes the install of %s to %s interrupted by a restart after %d bytes",
+				d.ref, d.target, offset)
+		}
+	}
+	saveCheckpoint := func(written int64) {
+		b, err := json.Marshal(installCheckpoint{Ref: d.ref, Target: d.target,
+			Written: written})
+		if err == nil {
+			err = w.SaveCheckpoint(b)
+		}
+		if err != nil {
+			log.Errorf("installWorker(%s): SaveCheckpoint failed: %v", w.Key, err)
+		}
+	}
+	saveCheckpoint(offset)
 	log.Functionf("installWorker to install %s to %s", d.ref, d.target)
-	err := zboot.WriteToPartition(log, d.ref, d.target)
+	err := zboot.WriteToPartitionFrom(log, d.ref, d.target, offset, saveCheckpoint)
 	log.Functionf("installWorker DONE install %s to %s: err %v",
 		d.ref, d.target, err)
 
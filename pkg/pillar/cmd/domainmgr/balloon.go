@@ -0,0 +1,234 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package domainmgr
+
+import (
+	"math"
+	"sort"
+
+	"github.com/lf-edge/eve/pkg/pillar/agentlog"
+	"github.com/lf-edge/eve/pkg/pillar/hypervisor"
+	"github.com/lf-edge/eve/pkg/pillar/types"
+	"github.com/lf-edge/eve/pkg/pillar/vault"
+)
+
+const (
+	// Reclaim memory from the guests when less than that percent of the
+	// host memory is free
+	balloonInflateFreePercent = 10
+	// Give memory back to the guests when more than that percent is free
+	balloonDeflateFreePercent = 20
+)
+
+// balloonDomain is a domain whose memory can be resized with a balloon;
+// sizes in kbytes
+type balloonDomain struct {
+	key    string
+	memory int // Memory the balloon lets the guest use at most
+	minMem int
+	target int // Memory the guest currently is asked to use
+}
+
+// balloonTargets returns the new memory targets of the domains whose balloon
+// should change to bring the free host memory between the watermarks. At
+// most unreserved kbytes are given back, the memory of the app instances
+// zedmanager did not start other app instances in.
+func balloonTargets(hm types.HostMemory, domains []balloonDomain,
+	unreserved int) map[string]int {
+	total := int(hm.TotalMemoryMB) << 10
+	free := int(hm.FreeMemoryMB) << 10
+	low := total * balloonInflateFreePercent / 100
+	high := total * balloonDeflateFreePercent / 100
+	// Aim between the watermarks to avoid oscillating
+	aim := (low + high) / 2
+	targets := make(map[string]int)
+	// Bring the guests above their maximum down to it first
+	for i := range domains {
+		if domains[i].target > domains[i].memory {
+			domains[i].target = domains[i].memory
+			targets[domains[i].key] = domains[i].target
+		}
+	}
+	switch {
+	case free < low:
+		// Reclaim first from the guests with the most to give
+		need := aim - free
+		sort.Slice(domains, func(i, j int) bool {
+			return domains[i].target-domains[i].minMem >
+				domains[j].target-domains[j].minMem
+		})
+		for _, d := range domains {
+			if need <= 0 {
+				break
+			}
+			reclaim := d.target - d.minMem
+			if reclaim <= 0 {
+				continue
+			}
+			if reclaim > need {
+				reclaim = need
+			}
+			targets[d.key] = d.target - reclaim
+			need -= reclaim
+		}
+	case free > high:
+		// Give back first to the guests which gave the most
+		spare := free - aim
+		if spare > unreserved {
+			spare = unreserved
+		}
+		sort.Slice(domains, func(i, j int) bool {
+			return domains[i].memory-domains[i].target >
+				domains[j].memory-domains[j].target
+		})
+		for _, d := range domains {
+			if spare <= 0 {
+				break
+			}
+			give := d.memory - d.target
+			if give <= 0 {
+				continue
+			}
+			if give > spare {
+				give = spare
+			}
+			targets[d.key] = d.target + give
+			spare -= give
+		}
+	}
+	return targets
+}
+
+// updateBalloons asks the handlers of the domains with a balloon to resize
+// them based on the host memory pressure
+func updateBalloons(ctx *domainContext) {
+	m, _ := ctx.pubHostMemory.Get("global")
+	if m == nil {
+		return
+	}
+	hm := m.(types.HostMemory)
+	var domains []balloonDomain
+	for _, st := range ctx.pubDomainStatus.GetAll() {
+		status := st.(types.DomainStatus)
+		memory := status.Memory
+		if status.BalloonMaxMem != 0 && status.BalloonMaxMem < memory {
+			memory = status.BalloonMaxMem
+		}
+		if !status.Activated || status.State != types.RUNNING ||
+			status.MinMem == 0 || status.MinMem >= memory {
+			continue
+		}
+		if _, ok := hyper.Task(&status).(hypervisor.BalloonTask); !ok {
+			continue
+		}
+		target := status.BalloonTarget
+		if target == 0 {
+			target = status.Memory
+		}
+		domains = append(domains, balloonDomain{
+			key:    status.Key(),
+			memory: memory,
+			minMem: status.MinMem,
+			target: target,
+		})
+	}
+	unreserved, err := unreservedAppMemory(ctx, hm)
+	if err != nil {
+		log.Errorf("updateBalloons: %v", err)
+		unreserved = 0
+	}
+	for key, target := range balloonTargets(hm, domains, unreserved) {
+		h, ok := handlerMap[key]
+		if !ok {
+			continue
+		}
+		select {
+		case h.balloonChannel <- target:
+		default:
+			log.Warnf("updateBalloons(%s) NOT sent target. Slow handler?", key)
+		}
+	}
+}
+
+// unreservedAppMemory returns the memory in kbytes of the app instances
+// which is not reserved for the activated domains, counted as zedmanager
+// does when it starts app instances: their memory, or what their balloon
+// reserves for them. The memory given back to a ballooned guest has to
+// come from there, zedmanager may have started another app instance in
+// the memory the balloon took back.
+func unreservedAppMemory(ctx *domainContext, hm types.HostMemory) (int, error) {
+	gcp := agentlog.GetGlobalConfig(log, ctx.subGlobalConfig)
+	if gcp.GlobalValueBool(types.IgnoreMemoryCheckForApps) {
+		return math.MaxInt, nil
+	}
+	reservedForEve, err := types.GetMemoryReservedForEve(gcp, vault.ReadPersistType())
+	if err != nil {
+		return 0, err
+	}
+	unreserved := int(hm.TotalMemoryMB<<10) - int(reservedForEve>>10)
+	for _, c := range ctx.subDomainConfig.GetAll() {
+		config := c.(types.DomainConfig)
+		if !config.Activate {
+			continue
+		}
+		reserved := config.Memory
+		if status := lookupDomainStatus(ctx, config.Key()); status != nil &&
+			status.Activated {
+			reserved = status.BalloonReserved()
+		}
+		unreserved -= reserved
+	}
+	if unreserved < 0 {
+		return 0, nil
+	}
+	return unreserved, nil
+}
+
+// setBalloonTarget resizes the memory of the guest to target kbytes
+func setBalloonTarget(ctx *domainContext, status *types.DomainStatus, target int) {
+	if !status.Activated {
+		return
+	}
+	balloon, ok := hyper.Task(status).(hypervisor.BalloonTask)
+	if !ok {
+		return
+	}
+	log.Functionf("setBalloonTarget(%s) from %d to %d kbytes", status.Key(),
+		status.BalloonTarget, target)
+	if err := balloon.SetMemoryTarget(status.DomainName, target); err != nil {
+		log.Errorf("setBalloonTarget(%s) failed: %v", status.Key(), err)
+		return
+	}
+	if target >= status.Memory {
+		target = 0
+	}
+	status.BalloonTarget = target
+	publishDomainStatus(ctx, status)
+}
+
+// updateBalloonActual updates how much memory the balloon actually left to
+// the guest while it is inflated; the guest gives memory back at its pace
+func updateBalloonActual(ctx *domainContext, status *types.DomainStatus) {
+	if !status.Activated || (status.BalloonTarget == 0 && status.BalloonActual == 0) {
+		return
+	}
+	balloon, ok := hyper.Task(status).(hypervisor.BalloonTask)
+	if !ok {
+		return
+	}
+	actual, err := balloon.MemoryActual(status.DomainName)
+	if err != nil {
+		log.Warnf("updateBalloonActual(%s) failed: %v", status.Key(), err)
+		return
+	}
+	if actual >= status.Memory {
+		actual = 0
+	}
+	if actual != status.BalloonActual {
+		log.Functionf("updateBalloonActual(%s) from %d to %d kbytes",
+			status.Key(), status.BalloonActual, actual)
+		status.BalloonActual = actual
+		publishDomainStatus(ctx, status)
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package domainmgr
+
+import (
+	"testing"
+
+	"github.com/lf-edge/eve/pkg/pillar/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBalloonTargets(t *testing.T) {
+	const gb = 1 << 20 // in kbytes
+	// 10 GB host: inflate below 1 GB free, deflate above 2 GB, aim 1.5 GB
+	hm := func(freeMB uint64) types.HostMemory {
+		return types.HostMemory{TotalMemoryMB: 10 << 10, FreeMemoryMB: freeMB}
+	}
+	domains := func() []balloonDomain {
+		return []balloonDomain{
+			{key: "a", memory: 4 * gb, minMem: 2 * gb, target: 4 * gb},
+			{key: "b", memory: 4 * gb, minMem: 3 * gb, target: 3 * gb},
+		}
+	}
+	tests := map[string]struct {
+		hm         types.HostMemory
+		domains    []balloonDomain
+		unreserved int
+		targets    map[string]int
+	}{
+		"no pressure": {
+			hm:         hm(1536),
+			domains:    domains(),
+			unreserved: 10 * gb,
+			targets:    map[string]int{},
+		},
+		"reclaim from the guest with the most to give": {
+			hm:         hm(512),
+			domains:    domains(),
+			unreserved: 10 * gb,
+			targets:    map[string]int{"a": 3 * gb},
+		},
+		"reclaim down to the minimum only": {
+			hm:         hm(0),
+			domains:    []balloonDomain{{key: "a", memory: 4 * gb, minMem: 3 * gb, target: 4 * gb}},
+			unreserved: 10 * gb,
+			targets:    map[string]int{"a": 3 * gb},
+		},
+		"give back to the guest which gave the most": {
+			hm: hm(3 << 10),
+			domains: []balloonDomain{
+				{key: "a", memory: 4 * gb, minMem: 2 * gb, target: 3 * gb},
+				{key: "b", memory: 4 * gb, minMem: 2 * gb, target: 2 * gb},
+			},
+			unreserved: 10 * gb,
+			targets:    map[string]int{"b": 3*gb + gb/2},
+		},
+		"give back only the memory no other app was started in": {
+			hm: hm(3 << 10),
+			domains: []balloonDomain{
+				{key: "a", memory: 4 * gb, minMem: 2 * gb, target: 2 * gb},
+			},
+			unreserved: gb / 2,
+			targets:    map[string]int{"a": 2*gb + gb/2},
+		},
+		"give back nothing if all is reserved": {
+			hm: hm(3 << 10),
+			domains: []balloonDomain{
+				{key: "a", memory: 4 * gb, minMem: 2 * gb, target: 2 * gb},
+			},
+			unreserved: 0,
+			targets:    map[string]int{},
+		},
+		"bring the guest down to its maximum": {
+			hm:         hm(1536),
+			domains:    []balloonDomain{{key: "a", memory: 3 * gb, minMem: 2 * gb, target: 4 * gb}},
+			unreserved: 10 * gb,
+			targets:    map[string]int{"a": 3 * gb},
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, test.targets, balloonTargets(test.hm, test.domains,
+				test.unreserved))
+		})
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package domainmgr
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/lf-edge/eve/pkg/pillar/diskmetrics"
+	"github.com/lf-edge/eve/pkg/pillar/hypervisor"
+	"github.com/lf-edge/eve/pkg/pillar/types"
+)
+
+// checkpointSpaceMargin is the part of the size of the saved state to
+// keep free in /persist on top of it
+const checkpointSpaceMargin = 10
+
+// checkpointDomain saves the running state of the domain into the
+// CheckpointFile of its config, if any, for it not to be shut down.
+// Unless the state is to be restored after a planned reboot, the
+// filesystems of the guest have to be frozen for its disks to be consistent
+// in case it boots from them instead.
+// Returns true if saved, in which case the domain is left paused.
+func checkpointDomain(ctx *domainContext, status *types.DomainStatus) bool {
+	config := lookupDomainConfig(ctx, status.Key())
+	if config == nil || config.CheckpointFile == "" || status.DomainId == 0 {
+		return false
+	}
+	if err := checkCheckpointSpace(*config); err != nil {
+		log.Warnf("checkpointDomain(%s) shutting down instead: %v",
+			status.Key(), err)
+		return false
+	}
+	frozen := freezeDomain(status)
+	if !frozen && !config.Suspend {
+		log.Noticef("checkpointDomain(%s) shutting down instead: guest filesystems not frozen",
+			status.Key())
+		return false
+	}
+	log.Noticef("checkpointDomain(%s) into %s", status.Key(), config.CheckpointFile)
+	if err := hyper.Task(status).Checkpoint(status.DomainName, config.CheckpointFile); err != nil {
+		log.Warnf("checkpointDomain(%s) failed, shutting down instead: %v",
+			status.Key(), err)
+		if frozen {
+			thawDomain(status)
+		}
+		return false
+	}
+	log.Noticef("checkpointDomain(%s) done", status.Key())
+	status.StoppedFrozen = frozen
+	return true
+}
+
+// freezeForSnapshot freezes the filesystems of the guest before the domain
+// is stopped if its volumes are snapshotted then, for the snapshots to be
+// consistent. Returns true if frozen, in which case the guest cannot shut
+// down and the domain has to be destroyed instead.
+func freezeForSnapshot(ctx *domainContext, status *types.DomainStatus) bool {
+	config := lookupDomainConfig(ctx, status.Key())
+	if config == nil || !config.FreezeForSnapshot {
+		return false
+	}
+	if !freezeDomain(status) {
+		log.Noticef("freezeForSnapshot(%s): snapshots will be crash-consistent",
+			status.Key())
+		return false
+	}
+	status.StoppedFrozen = true
+	return true
+}
+
+// checkCheckpointSpace checks that /persist has room for the memory of
+// the domain
+func checkCheckpointSpace(config types.DomainConfig) error {
+	memory := config.Memory
+	if config.MaxMem > memory {
+		memory = config.MaxMem
+	}
+	needed := uint64(memory) << 10
+	needed += needed * checkpointSpaceMargin / 100
+	usage, err := diskmetrics.PersistUsageStat(log)
+	if err != nil {
+		return err
+	}
+	if usage.Free < needed {
+		return fmt.Errorf("%d bytes free in %s, %d needed for the state",
+			usage.Free, types.PersistDir, needed)
+	}
+	return nil
+}
+
+// freezeDomain freezes the filesystems of the guest through its agent, if
+// any; returns true if frozen
+func freezeDomain(status *types.DomainStatus) bool {
+	if !status.GuestAgent {
+		return false
+	}
+	agent, ok := hyper.Task(status).(hypervisor.GuestAgentTask)
+	if !ok {
+		return false
+	}
+	frozen, err := agent.FreezeFilesystems(status.DomainName)
+	if err != nil {
+		log.Warnf("freezeDomain(%s): %v", status.Key(), err)
+		return false
+	}
+	log.Functionf("freezeDomain(%s): %d filesystems frozen", status.Key(), frozen)
+	return true
+}
+
+// thawDomain thaws the filesystems of the guest through its agent, if any,
+// e.g. after it resumed from a state saved while they were frozen
+func thawDomain(status *types.DomainStatus) {
+	if !status.GuestAgent {
+		return
+	}
+	agent, ok := hyper.Task(status).(hypervisor.GuestAgentTask)
+	if !ok {
+		return
+	}
+	thawed, err := agent.ThawFilesystems(status.DomainName)
+	if err != nil {
+		log.Warnf("thawDomain(%s): %v", status.Key(), err)
+		return
+	}
+	log.Functionf("thawDomain(%s): %d filesystems thawed", status.Key(), thawed)
+}
+
+// updateRestoreFile tracks the RestoreFile of the config; a new one is
+// tried even if restoring from the previous one failed
+func updateRestoreFile(status *types.DomainStatus, config types.DomainConfig) {
+	if status.RestoreFile != config.RestoreFile {
+		status.RestoreFile = config.RestoreFile
+		status.RestoreFailed = false
+	}
+}
+
+// getRestoreFile returns the state to resume the domain from when activated,
+// if any
+func getRestoreFile(status *types.DomainStatus, config types.DomainConfig) string {
+	updateRestoreFile(status, config)
+	if config.RestoreFile == "" || status.RestoreFailed {
+		return ""
+	}
+	if _, err := os.Stat(config.RestoreFile); err != nil {
+		log.Warnf("getRestoreFile(%s) booting instead: %v", status.Key(), err)
+		return ""
+	}
+	return config.RestoreFile
+}
@@ -0,0 +1,119 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package domainmgr
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/lf-edge/eve/pkg/pillar/cpuallocator"
+	"github.com/lf-edge/eve/pkg/pillar/types"
+)
+
+const (
+	sysfsPciDevices = "/sys/bus/pci/devices"
+	procIrq         = "/proc/irq"
+)
+
+// newCPUAllocator places the pinned CPUs in the topology of the host if it
+// can be read. Under Xen dom0 only sees its own vCPUs, so it can not.
+func newCPUAllocator(totalCPUs int, numReserved int) (*cpuallocator.CPUAllocator, error) {
+	topology, err := cpuallocator.ReadTopology()
+	if err != nil {
+		log.Warnf("Failed to read the CPU topology, CPUs will be placed regardless: %v", err)
+		return cpuallocator.Init(totalCPUs, numReserved)
+	}
+	if len(topology.CPUs) != totalCPUs {
+		log.Noticef("The CPU topology describes %d CPUs out of %d, CPUs will be placed regardless",
+			len(topology.CPUs), totalCPUs)
+		return cpuallocator.Init(totalCPUs, numReserved)
+	}
+	return cpuallocator.InitWithTopology(topology, numReserved)
+}
+
+// cpuPlacement returns where to allocate the pinned CPUs of the domain
+func cpuPlacement(ctx *domainContext, config *types.DomainConfig) cpuallocator.Placement {
+	placement := cpuallocator.Placement{Policy: ctx.cpuPinningPolicy, Node: -1}
+	if placement.Policy != cpuallocator.PolicyNUMALocal {
+		return placement
+	}
+	for _, pciLong := range adapterPciAddresses(ctx, config.IoAdapterList) {
+		if node := cpuallocator.DeviceNUMANode(pciLong); node >= 0 {
+			placement.Node = node
+			break
+		}
+	}
+	return placement
+}
+
+// adapterPciAddresses returns the PCI addresses of the adapters
+func adapterPciAddresses(ctx *domainContext, adapters []types.IoAdapter) []string {
+	var addresses []string
+	for _, adapter := range adapters {
+		for _, ib := range ctx.assignableAdapters.LookupIoBundleAny(adapter.Name) {
+			if ib != nil && ib.PciLong != "" {
+				addresses = append(addresses, ib.PciLong)
+			}
+		}
+	}
+	return addresses
+}
+
+// setIrqAffinity routes the interrupts of the PCI devices assigned to the
+// domain to the CPUs of the NUMA nodes of its pinned CPUs. MSI vectors only
+// exist once the guest driver enabled them, so this is done again whenever
+// the domain is started.
+func setIrqAffinity(ctx *domainContext, status *types.DomainStatus) {
+	if status.VmConfig.CPUMems == "" {
+		return
+	}
+	nodes, err := cpuallocator.ParseCPUList(status.VmConfig.CPUMems)
+	if err != nil {
+		log.Errorf("setIrqAffinity(%s): %v", status.Key(), err)
+		return
+	}
+	cpus := ""
+	topology := ctx.cpuAllocator.Topology()
+	for _, node := range nodes {
+		for _, cpu := range topology.NodeCPUs(node) {
+			addToMask(cpu, &cpus)
+		}
+	}
+	for _, pciLong := range adapterPciAddresses(ctx, status.IoAdapterList) {
+		for _, irq := range pciDeviceIrqs(pciLong) {
+			path := filepath.Join(procIrq, strconv.Itoa(irq), "smp_affinity_list")
+			if err := os.WriteFile(path, []byte(cpus), 0644); err != nil {
+				// Some interrupts can not be moved, which is harmless
+				log.Functionf("setIrqAffinity(%s): irq %d of %s: %v",
+					status.Key(), irq, pciLong, err)
+			}
+		}
+	}
+	log.Functionf("setIrqAffinity(%s): interrupts routed to CPUs %s",
+		status.Key(), cpus)
+}
+
+// pciDeviceIrqs returns the legacy and the MSI interrupts of the PCI device
+func pciDeviceIrqs(pciLong string) []int {
+	var irqs []int
+	dir := filepath.Join(sysfsPciDevices, pciLong)
+	if data, err := os.ReadFile(filepath.Join(dir, "irq")); err == nil {
+		var irq int
+		if _, err := fmt.Sscanf(string(data), "%d", &irq); err == nil && irq > 0 {
+			irqs = append(irqs, irq)
+		}
+	}
+	entries, err := os.ReadDir(filepath.Join(dir, "msi_irqs"))
+	if err != nil {
+		return irqs
+	}
+	for _, entry := range entries {
+		if irq, err := strconv.Atoi(entry.Name()); err == nil {
+			irqs = append(irqs, irq)
+		}
+	}
+	return irqs
+}
@@ -0,0 +1,197 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package domainmgr
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lf-edge/eve/pkg/pillar/hypervisor"
+	"github.com/lf-edge/eve/pkg/pillar/types"
+	uuid "github.com/satori/go.uuid"
+	"github.com/shirou/gopsutil/disk"
+)
+
+// Dumps are AppCrashDumpDirname/<app UUID>/<unix time>.kdump
+const crashDumpSuffix = ".kdump"
+
+// crashDumpLock serializes the publication and the eviction of the dumps.
+// Not held while dumping, which can take minutes.
+var crashDumpLock sync.Mutex
+
+// dumpCrashedDomain dumps the memory of the domain whose kernel panicked
+// before it is deleted, if there is a quota for the dumps and room for the
+// dump, and publishes the dump for zedagent to report it in the app info
+func dumpCrashedDomain(ctx *domainContext, status *types.DomainStatus) {
+	quota := int64(ctx.crashDumpQuotaMiB) << 20
+	if quota == 0 || status.LastEvent.State != types.BROKEN {
+		return
+	}
+	task, ok := hyper.Task(status).(hypervisor.CrashDumpTask)
+	if !ok {
+		return
+	}
+	usage, err := disk.Usage(types.PersistDir)
+	if err != nil {
+		log.Errorf("dumpCrashedDomain(%s) failed: %v", status.Key(), err)
+		return
+	}
+	// The dump is about as large as the memory of the guest
+	if err := crashDumpFits(int64(status.Memory)<<10, quota,
+		int64(usage.Free)); err != nil {
+		log.Warnf("dumpCrashedDomain(%s) skipped: %v", status.Key(), err)
+		return
+	}
+
+	dump := types.AppCrashDump{
+		AppUUID: status.UUIDandVersion.UUID,
+		Time:    status.LastEvent.Time,
+	}
+	if dump.Time.IsZero() {
+		dump.Time = time.Now()
+	}
+	dump.FileName = filepath.Join(types.AppCrashDumpDirname,
+		dump.AppUUID.String(), strconv.FormatInt(dump.Time.Unix(), 10)+crashDumpSuffix)
+	// The dump only shows up in the directory once complete
+	log.Noticef("dumpCrashedDomain(%s) into %s", status.Key(), dump.FileName)
+	if err := task.DumpGuestMemory(status.DomainName, dump.FileName); err != nil {
+		log.Errorf("dumpCrashedDomain(%s) failed: %v", status.Key(), err)
+		return
+	}
+	info, err := os.Stat(dump.FileName)
+	if err != nil {
+		log.Errorf("dumpCrashedDomain(%s) failed: %v", status.Key(), err)
+		return
+	}
+	dump.Size = info.Size()
+
+	crashDumpLock.Lock()
+	defer crashDumpLock.Unlock()
+	log.Noticef("dumpCrashedDomain(%s) done, %d bytes", status.Key(), dump.Size)
+	ctx.pubAppCrashDump.Publish(dump.Key(), dump)
+	evictCrashDumps(ctx, quota)
+}
+
+// crashDumpFits returns an error unless a dump of size bytes fits in the
+// quota and in the free space, the older dumps being evicted only after
+func crashDumpFits(size, quota, free int64) error {
+	if size > quota {
+		return fmt.Errorf("%d bytes do not fit in the quota of %d bytes",
+			size, quota)
+	}
+	if size > free {
+		return fmt.Errorf("%d bytes do not fit in the %d bytes free",
+			size, free)
+	}
+	return nil
+}
+
+// readCrashDumps returns the dumps in AppCrashDumpDirname
+func readCrashDumps() []types.AppCrashDump {
+	var dumps []types.AppCrashDump
+	files, _ := filepath.Glob(filepath.Join(types.AppCrashDumpDirname,
+		"*", "*"+crashDumpSuffix))
+	for _, file := range files {
+		appUUID, err := uuid.FromString(filepath.Base(filepath.Dir(file)))
+		if err != nil {
+			continue
+		}
+		seconds, err := strconv.ParseInt(
+			strings.TrimSuffix(filepath.Base(file), crashDumpSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+		dumps = append(dumps, types.AppCrashDump{
+			AppUUID:  appUUID,
+			FileName: file,
+			Size:     info.Size(),
+			Time:     time.Unix(seconds, 0),
+		})
+	}
+	return dumps
+}
+
+// crashDumpsToEvict returns the oldest dumps to remove for the others to
+// fit in quota bytes
+func crashDumpsToEvict(dumps []types.AppCrashDump, quota int64) []types.AppCrashDump {
+	sort.Slice(dumps, func(i, j int) bool {
+		return dumps[i].Time.Before(dumps[j].Time)
+	})
+	var total int64
+	for _, dump := range dumps {
+		total += dump.Size
+	}
+	var evict []types.AppCrashDump
+	for _, dump := range dumps {
+		if total <= quota {
+			break
+		}
+		evict = append(evict, dump)
+		total -= dump.Size
+	}
+	return evict
+}
+
+// crashDumpsOfApp returns the dumps of the app
+func crashDumpsOfApp(dumps []types.AppCrashDump, appUUID uuid.UUID) []types.AppCrashDump {
+	var appDumps []types.AppCrashDump
+	for _, dump := range dumps {
+		if uuid.Equal(dump.AppUUID, appUUID) {
+			appDumps = append(appDumps, dump)
+		}
+	}
+	return appDumps
+}
+
+// removeCrashDump removes the file of the dump and unpublishes it
+func removeCrashDump(ctx *domainContext, dump types.AppCrashDump) {
+	log.Noticef("removeCrashDump: removing %s, %d bytes", dump.FileName, dump.Size)
+	if err := os.Remove(dump.FileName); err != nil {
+		log.Errorf("removeCrashDump: %v", err)
+		return
+	}
+	// Removes the directory of the app once empty
+	os.Remove(filepath.Dir(dump.FileName))
+	ctx.pubAppCrashDump.Unpublish(dump.Key())
+}
+
+// evictCrashDumps removes the oldest dumps, possibly all of them, for the
+// others to fit in quota bytes
+func evictCrashDumps(ctx *domainContext, quota int64) {
+	for _, dump := range crashDumpsToEvict(readCrashDumps(), quota) {
+		removeCrashDump(ctx, dump)
+	}
+}
+
+// removeAppCrashDumps removes the dumps of a deleted app
+func removeAppCrashDumps(ctx *domainContext, appUUID uuid.UUID) {
+	crashDumpLock.Lock()
+	defer crashDumpLock.Unlock()
+	for _, dump := range crashDumpsOfApp(readCrashDumps(), appUUID) {
+		removeCrashDump(ctx, dump)
+	}
+}
+
+// updateCrashDumps publishes the dumps kept across reboots and removes the
+// ones beyond the quota
+func updateCrashDumps(ctx *domainContext) {
+	crashDumpLock.Lock()
+	defer crashDumpLock.Unlock()
+	for _, dump := range readCrashDumps() {
+		if err := ctx.pubAppCrashDump.Publish(dump.Key(), dump); err != nil {
+			log.Errorf("updateCrashDumps(%s): %v", dump.FileName, err)
+		}
+	}
+	evictCrashDumps(ctx, int64(ctx.crashDumpQuotaMiB)<<20)
+}
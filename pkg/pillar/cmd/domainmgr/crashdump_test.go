@@ -0,0 +1,54 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package domainmgr
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lf-edge/eve/pkg/pillar/types"
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCrashDumpsToEvict(t *testing.T) {
+	now := time.Now()
+	dump := func(name string, age time.Duration, size int64) types.AppCrashDump {
+		return types.AppCrashDump{FileName: name, Time: now.Add(-age), Size: size}
+	}
+	names := func(dumps []types.AppCrashDump) []string {
+		var names []string
+		for _, dump := range dumps {
+			names = append(names, dump.FileName)
+		}
+		return names
+	}
+	dumps := []types.AppCrashDump{
+		dump("new", time.Minute, 300),
+		dump("old", time.Hour, 200),
+		dump("middle", 2*time.Minute, 100),
+	}
+	assert.Empty(t, crashDumpsToEvict(dumps, 600))
+	assert.Equal(t, []string{"old"}, names(crashDumpsToEvict(dumps, 500)))
+	assert.Equal(t, []string{"old", "middle"}, names(crashDumpsToEvict(dumps, 399)))
+	assert.Equal(t, []string{"old", "middle", "new"}, names(crashDumpsToEvict(dumps, 0)))
+}
+
+func TestCrashDumpFits(t *testing.T) {
+	assert.Nil(t, crashDumpFits(100, 100, 100))
+	assert.NotNil(t, crashDumpFits(101, 100, 200))
+	assert.NotNil(t, crashDumpFits(100, 200, 99))
+}
+
+func TestCrashDumpsOfApp(t *testing.T) {
+	app1 := uuid.FromStringOrNil("6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+	app2 := uuid.FromStringOrNil("6ba7b811-9dad-11d1-80b4-00c04fd430c8")
+	dumps := []types.AppCrashDump{
+		{AppUUID: app1, FileName: "a"},
+		{AppUUID: app2, FileName: "b"},
+		{AppUUID: app1, FileName: "c"},
+	}
+	assert.Equal(t, []types.AppCrashDump{dumps[0], dumps[2]}, crashDumpsOfApp(dumps, app1))
+	assert.Empty(t, crashDumpsOfApp(dumps, uuid.Nil))
+}
@@ -91,6 +91,7 @@ type domainContext struct {
 	pubProcessMetric       pubsub.Publication
 	pubCipherBlockStatus   pubsub.Publication
 	pubCapabilities        pubsub.Publication
+	pubAppCrashDump        pubsub.Publication
 	cipherMetrics          *cipher.AgentMetrics
 	createSema             *sema.Semaphore
 	GCComplete             bool
@@ -112,6 +113,7 @@ type domainContext struct {
 
 	// From global config setting
 	processCloudInitMultiPart bool
+	crashDumpQuotaMiB         uint32
 	publishTicker             flextimer.FlexTickerHandle
 	// cli options
 	versionPtr    *bool
@@ -119,6 +121,7 @@ type domainContext struct {
 	// CPUs management
 	cpuAllocator        *cpuallocator.CPUAllocator
 	cpuPinningSupported bool
+	cpuPinningPolicy    cpuallocator.Policy
 }
 
 // AddAgentSpecificCLIFlags adds CLI options
@@ -242,8 +245,9 @@ func Run(ps *pubsub.PubSub, loggerArg *logrus.Logger, logArg *base.LogObject, ar
 
 	pubDomainMetric, err := ps.NewPublication(
 		pubsub.PublicationOptions{
-			AgentName: agentName,
-			TopicType: types.DomainMetric{},
+			AgentName:      agentName,
+			TopicType:      types.DomainMetric{},
+			CoalesceWindow: pubsub.MetricCoalesceWindow,
 		})
 	if err != nil {
 		log.Fatal(err)
@@ -299,6 +303,15 @@ func Run(ps *pubsub.PubSub, loggerArg *logrus.Logger, logArg *base.LogObject, ar
 	}
 	domainCtx.pubCapabilities = capabilitiesInfoPub
 
+	pubAppCrashDump, err := ps.NewPublication(pubsub.PublicationOptions{
+		AgentName: agentName,
+		TopicType: types.AppCrashDump{},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	domainCtx.pubAppCrashDump = pubAppCrashDump
+
 	// Look for controller certs which will be used for decryption
 	subControllerCert, err := ps.NewSubscription(pubsub.SubscriptionOptions{
 		AgentName:   "zedagent",
@@ -558,7 +571,7 @@ func Run(ps *pubsub.PubSub, loggerArg *logrus.Logger, logArg *base.LogObject, ar
 		log.Warnf("Failed to get reserved CPU number, use 1 by default: %s", err)
 	}
 
-	if domainCtx.cpuAllocator, err = cpuallocator.Init(int(resources.Ncpus), cpusReserved); err != nil {
+	if domainCtx.cpuAllocator, err = newCPUAllocator(int(resources.Ncpus), cpusReserved); err != nil {
 		log.Fatal(err)
 	}
 
@@ -644,6 +657,7 @@ func Run(ps *pubsub.PubSub, loggerArg *logrus.Logger, logArg *base.LogObject, ar
 			ps.CheckMaxTimeTopic(agentName, "publishTimer", start,
 				warningTime, errorTime)
 			publishProcessesHandler(&domainCtx)
+			updateBalloons(&domainCtx)
 
 		case <-stillRunning.C:
 		}
@@ -751,6 +765,8 @@ type Notify struct{}
 type channels struct {
 	configChannel chan<- Notify
 	cpuChannel    chan<- Notify
+	// Memory in kbytes the balloon should let the guest use
+	balloonChannel chan<- int
 }
 
 // We have one goroutine per provisioned domU object.
@@ -808,10 +824,11 @@ func handleDomainCreate(ctxArg interface{}, key string, configArg interface{}) {
 	}
 	hConfig := make(chan Notify, 1)
 	hCPU := make(chan Notify, 1)
-	h1 := channels{configChannel: hConfig, cpuChannel: hCPU}
+	hBalloon := make(chan int, 1)
+	h1 := channels{configChannel: hConfig, cpuChannel: hCPU, balloonChannel: hBalloon}
 	handlerMap[config.Key()] = h1
 	log.Functionf("Creating %s at %s", "runHandler", agentlog.GetMyStack())
-	go runHandler(ctx, key, hConfig, hCPU)
+	go runHandler(ctx, key, hConfig, hCPU, hBalloon)
 	h = h1
 	select {
 	case h.configChannel <- Notify{}:
@@ -838,6 +855,7 @@ func handleDomainDelete(ctxArg interface{}, key string,
 	if ok {
 		log.Functionf("Closing channels")
 		close(h.cpuChannel)
+		close(h.balloonChannel)
 		close(h.configChannel)
 		delete(handlerMap, key)
 	} else {
@@ -849,7 +867,8 @@ func handleDomainDelete(ctxArg interface{}, key string,
 
 // Server for each domU
 // Runs timer every 30 seconds to update status
-func runHandler(ctx *domainContext, key string, configChannel <-chan Notify, cpuChannel <-chan Notify) {
+func runHandler(ctx *domainContext, key string, configChannel <-chan Notify, cpuChannel <-chan Notify,
+	balloonChannel <-chan int) {
 
 	log.Functionf("runHandler starting")
 
@@ -858,6 +877,7 @@ func runHandler(ctx *domainContext, key string, configChannel <-chan Notify, cpu
 	min := max * 0.3
 	ticker := flextimer.NewRangeTicker(time.Duration(min),
 		time.Duration(max))
+	var guestPoll guestAgentPoll
 
 	closed := false
 	for !closed {
@@ -883,6 +903,10 @@ func runHandler(ctx *domainContext, key string, configChannel <-chan Notify, cpu
 				if status != nil {
 					handleDelete(ctx, key, status)
 				}
+				// The app is gone, after any dump of it in this goroutine
+				if appUUID, err := uuid.FromString(key); err == nil {
+					removeAppCrashDumps(ctx, appUUID)
+				}
 				closed = true
 			}
 		case _, ok := <-cpuChannel:
@@ -908,12 +932,21 @@ func runHandler(ctx *domainContext, key string, configChannel <-chan Notify, cpu
 					}
 				}
 			}
+		case target, ok := <-balloonChannel:
+			if ok {
+				status := lookupDomainStatus(ctx, key)
+				if status != nil {
+					setBalloonTarget(ctx, status, target)
+				}
+			}
 		case <-ticker.C:
 			log.Tracef("runHandler(%s) timer", key)
 			status := lookupDomainStatus(ctx, key)
 			if status != nil {
 				verifyStatus(ctx, status)
 				maybeRetry(ctx, status)
+				updateGuestInfo(ctx, status, &guestPoll)
+				updateBalloonActual(ctx, status)
 			}
 		}
 	}
@@ -948,8 +981,15 @@ func verifyStatus(ctx *domainContext, status *types.DomainStatus) {
 			if domainStatus == types.BROKEN {
 				err := fmt.Errorf("one of the %s tasks has crashed (%v)", status.Key(), err)
 				log.Errorf(err.Error())
-				status.SetErrorNow("one of the application's tasks has crashed - please restart application instance")
+				errStr := "one of the application's tasks has crashed"
+				if event, ok := lastDomainEvent(status); ok && event.Error {
+					status.LastEvent = event
+					errStr = event.Detail
+				}
+				status.SetErrorNow(errStr + " - please restart application instance")
 				status.State = types.BROKEN
+				// While its memory is still there
+				dumpCrashedDomain(ctx, status)
 			} else {
 				//schedule for retry boot
 				status.BootFailed = true
@@ -986,6 +1026,8 @@ func verifyStatus(ctx *domainContext, status *types.DomainStatus) {
 				status.DomainId, status.BootTime.Format(time.RFC3339Nano),
 				status.Key())
 			status.Activated = true
+			status.BalloonTarget = 0
+			status.BalloonActual = 0
 			status.State = types.RUNNING
 			publishDomainStatus(ctx, status)
 		} else if domainID != status.DomainId {
@@ -999,9 +1041,48 @@ func verifyStatus(ctx *domainContext, status *types.DomainStatus) {
 				status.Key())
 			publishDomainStatus(ctx, status)
 		}
+		if status.Activated && updateDomainEvent(status, domainStatus) {
+			publishDomainStatus(ctx, status)
+		}
 	}
 }
 
+// lastDomainEvent returns the last event reported by the hypervisor
+// about the domain if it reports events
+func lastDomainEvent(status *types.DomainStatus) (types.DomainEvent, bool) {
+	reporter, ok := hyper.Task(status).(hypervisor.EventReporter)
+	if !ok {
+		return types.DomainEvent{}, false
+	}
+	return reporter.LastEvent(status.DomainName)
+}
+
+// updateDomainEvent updates the state and error of a running domain from
+// the last event reported by the hypervisor; returns true if changed
+func updateDomainEvent(status *types.DomainStatus, domainStatus types.SwState) bool {
+	event, ok := lastDomainEvent(status)
+	if !ok || event.Equal(status.LastEvent) {
+		return false
+	}
+	wasError := status.LastEvent.Error
+	status.LastEvent = event
+	log.Noticef("updateDomainEvent(%s) %s: %s", status.Key(), event.Event,
+		event.Detail)
+	if event.Error {
+		status.SetErrorDescription(types.ErrorDescription{
+			Error:     event.Detail,
+			ErrorTime: event.Time,
+		})
+		if domainStatus == types.PAUSED || domainStatus == types.PAUSING {
+			status.State = domainStatus
+		}
+	} else if wasError && domainStatus == types.RUNNING {
+		status.ClearError()
+		status.State = types.RUNNING
+	}
+	return true
+}
+
 func maybeRetry(ctx *domainContext, status *types.DomainStatus) {
 	maybeRetryConfig(ctx, status)
 	maybeRetryBoot(ctx, status)
@@ -1216,12 +1297,16 @@ func setCgroupCpuset(config *types.DomainConfig, status *types.DomainStatus) err
 		log.Warnf("Failed to find cgroups directory for %s", config.DisplayName)
 		return nil
 	}
-	err = controller.Update(&specs.LinuxResources{CPU: &specs.LinuxCPU{Cpus: status.VmConfig.CPUs}})
+	err = controller.Update(&specs.LinuxResources{CPU: &specs.LinuxCPU{
+		Cpus: status.VmConfig.CPUs,
+		Mems: status.VmConfig.CPUMems,
+	}})
 	if err != nil {
 		log.Warnf("Failed to update CPU set for %s", config.DisplayName)
 		return err
 	}
-	log.Functionf("Adjust the cgroups cpuset of %s to %s", config.DisplayName, status.VmConfig.CPUs)
+	log.Functionf("Adjust the cgroups cpuset of %s to %s (memory nodes %s)",
+		config.DisplayName, status.VmConfig.CPUs, status.VmConfig.CPUMems)
 	return nil
 }
 
@@ -1248,6 +1333,7 @@ func addToMask(cpu int, s *string) {
 
 func updateNonPinnedCPUs(ctx *domainContext, config *types.DomainConfig, status *types.DomainStatus) error {
 	status.VmConfig.CPUs = constructNonPinnedCpumaskString(ctx)
+	status.VmConfig.CPUMems = ""
 	err := setCgroupCpuset(config, status)
 	if err != nil {
 		return errors.New("failed to redistribute CPUs between VMs, can affect the inter-VM isolation")
@@ -1257,15 +1343,24 @@ func updateNonPinnedCPUs(ctx *domainContext, config *types.DomainConfig, status
 
 func assignCPUs(ctx *domainContext, config *types.DomainConfig, status *types.DomainStatus) error {
 	if config.VmConfig.CPUsPinned { // Pin the CPU
-		cpusToAssign, err := ctx.cpuAllocator.Allocate(config.UUIDandVersion.UUID, config.VCpus)
+		placement := cpuPlacement(ctx, config)
+		cpusToAssign, err := ctx.cpuAllocator.AllocatePlaced(config.UUIDandVersion.UUID,
+			config.VCpus, placement)
 		if err != nil {
+			log.Errorf("Failed to allocate %d %s CPUs for %s: %v", config.VCpus,
+				placement.Policy, config.DisplayName, err)
 			return errors.New("failed to allocate necessary amount of CPUs")
 		}
 		for _, cpu := range cpusToAssign {
 			addToMask(cpu, &status.VmConfig.CPUs)
 		}
+		// Have the memory follow the CPUs
+		for _, node := range ctx.cpuAllocator.Topology().NodesOf(cpusToAssign) {
+			addToMask(node, &status.VmConfig.CPUMems)
+		}
 	} else { // VM has no pinned CPUs, assign all the CPUs from the shared set
 		status.VmConfig.CPUs = constructNonPinnedCpumaskString(ctx)
+		status.VmConfig.CPUMems = ""
 	}
 	return nil
 }
@@ -1294,6 +1389,7 @@ func handleCreate(ctx *domainContext, key string, config *types.DomainConfig) {
 	}
 
 	status.VmConfig.CPUs = ""
+	status.VmConfig.CPUMems = ""
 
 	// Note that the -emu interface doesn't exist until after boot of the domU, but we
 	// initialize the VifList here with the VifUsed.
@@ -1442,6 +1538,14 @@ func doActivate(ctx *domainContext, config types.DomainConfig,
 
 	// We now have reserved all of the IoAdapters
 	status.IoAdapterList = config.IoAdapterList
+	if config.MinMem != 0 && adapterPciAddresses(ctx, config.IoAdapterList) != nil {
+		// The memory of a guest with PCI passthrough is pinned for the DMA
+		// of the devices; a balloon would not give any back to the host
+		log.Noticef("doActivate(%s): no balloon with PCI passthrough",
+			config.DisplayName)
+		config.MinMem = 0
+		status.MinMem = 0
+	}
 
 	// Assign any I/O devices
 	if err := doAssignIoAdaptersToDomain(ctx, config, status); err != nil {
@@ -1493,6 +1597,10 @@ func doActivate(ctx *domainContext, config types.DomainConfig,
 	}
 	defer file.Close()
 
+	// Resume from a checkpoint rather than boot if asked to
+	config.RestoreFile = getRestoreFile(status, config)
+	status.RestoredFrom = config.RestoreFile
+
 	globalConfig := agentlog.GetGlobalConfig(log, ctx.subGlobalConfig)
 	if err := hyper.Task(status).Setup(*status, config, ctx.assignableAdapters, globalConfig, file); err != nil {
 		log.Errorf("Failed to create DomainStatus from %v: %s",
@@ -1536,6 +1644,14 @@ func doActivate(ctx *domainContext, config types.DomainConfig,
 			triggerCPUNotification()
 		}
 	}
+	// The cgroup of the task is a new one
+	status.IOLimits = types.IOLimits{}
+	if err := setCgroupIOLimits(&config, status); err != nil {
+		log.Errorf("Failed to limit the block I/O of %s: %s", config.DisplayName, err)
+		errDescription := types.ErrorDescription{Error: err.Error()}
+		status.SetErrorDescription(errDescription)
+		publishDomainStatus(ctx, status)
+	}
 
 	status.BootFailed = false
 	doActivateTail(ctx, status, domainID)
@@ -1553,10 +1669,20 @@ func doActivateTail(ctx *domainContext, status *types.DomainStatus,
 	status.State = types.BOOTING
 	publishDomainStatus(ctx, status)
 
-	err := hyper.Task(status).Start(status.DomainName)
+	var err error
+	if status.RestoredFrom != "" {
+		err = hyper.Task(status).Restore(status.DomainName, status.RestoredFrom)
+	} else {
+		err = hyper.Task(status).Start(status.DomainName)
+	}
 	if err != nil {
 		log.Errorf("domain start for %s: %s", status.DomainName, err)
 		status.SetErrorNow(err.Error())
+		if status.RestoredFrom != "" {
+			// Boot it when retried
+			status.RestoreFailed = true
+			status.RestoredFrom = ""
+		}
 
 		// Delete
 		if err := hyper.Task(status).Delete(status.DomainName); err != nil {
@@ -1575,6 +1701,9 @@ func doActivateTail(ctx *domainContext, status *types.DomainStatus,
 	// The -emu interfaces were most likely created as result of the boot so we
 	// update VifUsed here.
 	status.VifList = checkIfEmu(status.VifList)
+	if ctx.cpuPinningSupported && status.VmConfig.CPUsPinned {
+		setIrqAffinity(ctx, status)
+	}
 
 	status.State = types.RUNNING
 	domainID, state, err := hyper.Task(status).Info(status.DomainName)
@@ -1605,6 +1734,12 @@ func doActivateTail(ctx *domainContext, status *types.DomainStatus,
 			status.Key())
 	}
 	status.Activated = true
+	status.BalloonTarget = 0
+	status.BalloonActual = 0
+	if status.RestoredFrom != "" {
+		// The state may have been saved with the filesystems frozen
+		thawDomain(status)
+	}
 	log.Functionf("doActivateTail(%v) done for %s",
 		status.UUIDandVersion, status.DisplayName)
 }
@@ -1671,6 +1806,14 @@ func doInactivate(ctx *domainContext, status *types.DomainStatus, impatient bool
 		status.State = types.HALTING
 		publishDomainStatus(ctx, status)
 
+		status.StoppedFrozen = false
+		if checkpointDomain(ctx, status) {
+			// Its state is saved, there is nothing for the guest to do
+			doShutdown = false
+		} else if freezeForSnapshot(ctx, status) {
+			// Its disks are consistent, and it cannot shut down frozen
+			doShutdown = false
+		}
 		if doShutdown {
 			// If the Shutdown fails we don't wait; assume failure
 			// was due to no PV tools
@@ -1764,6 +1907,7 @@ func doCleanup(ctx *domainContext, status *types.DomainStatus) {
 			triggerCPUNotification()
 		}
 		status.VmConfig.CPUs = ""
+		status.VmConfig.CPUMems = ""
 	}
 	releaseAdapters(ctx, status.IoAdapterList, status.UUIDandVersion.UUID,
 		status)
@@ -1860,10 +2004,13 @@ func configToStatus(ctx *domainContext, config types.DomainConfig,
 		ds.DisplayName = dc.DisplayName
 		ds.WWN = dc.WWN
 		ds.CustomMeta = dc.CustomMeta
+		ds.Directory = dc.Directory
 		// Generate Devtype for hypervisor package
 		// XXX can hypervisor look at something different?
 		if dc.Target == zconfig.Target_AppCustom {
 			ds.Devtype = "AppCustom"
+		} else if dc.Directory {
+			ds.Devtype = "virtiofs"
 		} else if dc.Format == zconfig.Format_CONTAINER {
 			if i == 0 {
 				ds.MountDir = "/"
@@ -2038,6 +2185,7 @@ func handleModify(ctx *domainContext, key string,
 		status.State.String())
 
 	status.PendingModify = true
+	updateRestoreFile(status, *config)
 	publishDomainStatus(ctx, status)
 
 	changed := false
@@ -2099,6 +2247,27 @@ func handleModify(ctx *domainContext, key string,
 		}
 		updateStatusFromConfig(status, *config)
 		changed = true
+	} else if status.Activated && !status.HotplugFailed && resourcesGrew(config, status) {
+		hotplugResources(ctx, config, status)
+		changed = true
+	}
+	if config.Activate && status.Activated &&
+		(config.BalloonMaxMem != status.BalloonMaxMem ||
+			(status.MinMem != 0 && config.MinMem != 0 && config.MinMem != status.MinMem)) {
+		// The balloon bounds apply to the running domain. A domain started
+		// without a balloon keeps running without one.
+		status.BalloonMaxMem = config.BalloonMaxMem
+		if status.MinMem != 0 && config.MinMem != 0 {
+			status.MinMem = config.MinMem
+		}
+		publishDomainStatus(ctx, status)
+	}
+	if config.Activate && status.Activated && config.IOLimits != status.IOLimits {
+		// e.g. the global settings changed
+		if err := setCgroupIOLimits(config, status); err != nil {
+			log.Errorf("Failed to limit the block I/O of %s: %s", config.DisplayName, err)
+		}
+		publishDomainStatus(ctx, status)
 	}
 	if changed {
 		// XXX could we also have changes in the IoBundle?
@@ -2145,6 +2314,16 @@ func updateStatusFromConfig(status *types.DomainStatus, config types.DomainConfi
 	status.VncDisplay = config.VncDisplay
 	status.VncPasswd = config.VncPasswd
 	status.DisableLogs = config.DisableLogs
+	status.MinMem = config.MinMem
+	status.BalloonMaxMem = config.BalloonMaxMem
+	status.GuestAgent = config.GuestAgent
+	// The domain is started with what the config has, not what it was
+	// created or hot-plugged with
+	status.VCpus = config.VCpus
+	status.MaxCpus = config.MaxCpus
+	status.Memory = config.Memory
+	status.MaxMem = config.MaxMem
+	status.HotplugFailed = false
 }
 
 // fillVifUsed iterates over vifs from received config and fill VifUsed
@@ -2411,6 +2590,13 @@ func handleGlobalConfigImpl(ctxArg interface{}, key string,
 			ctx.metricInterval = metricInterval
 		}
 		ctx.processCloudInitMultiPart = gcp.GlobalValueBool(types.ProcessCloudInitMultiPart)
+		containerd.SetConsoleLogRate(gcp.GlobalValueInt(types.AppConsoleLogRate))
+		crashDumpQuotaMiB := gcp.GlobalValueInt(types.AppCrashDumpQuotaMiB)
+		if crashDumpQuotaMiB != ctx.crashDumpQuotaMiB || !ctx.GCInitialized {
+			ctx.crashDumpQuotaMiB = crashDumpQuotaMiB
+			updateCrashDumps(ctx)
+		}
+		ctx.cpuPinningPolicy = cpuallocator.Policy(gcp.GlobalValueString(types.AppCPUPinningPolicy))
 		ctx.GCInitialized = true
 	}
 	log.Functionf("handleGlobalConfigImpl done for %s. "+
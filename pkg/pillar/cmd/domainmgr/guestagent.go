@@ -0,0 +1,84 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package domainmgr
+
+import (
+	"reflect"
+	"time"
+
+	"github.com/lf-edge/eve/pkg/pillar/hypervisor"
+	"github.com/lf-edge/eve/pkg/pillar/types"
+)
+
+const (
+	// Wait that long before polling again a guest agent which did not answer,
+	// doubled on every failure up to the max, since polling a guest without
+	// agent blocks the handler of the domain until the poll times out
+	guestAgentMinBackoff = time.Minute
+	guestAgentMaxBackoff = 16 * time.Minute
+)
+
+// guestAgentPoll tracks when to poll the guest agent of a domain next
+type guestAgentPoll struct {
+	failures int
+	next     time.Time
+}
+
+// guestAgentBackoff returns how long to wait after that many failed polls
+func guestAgentBackoff(failures int) time.Duration {
+	backoff := guestAgentMinBackoff
+	for i := 1; i < failures && backoff < guestAgentMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > guestAgentMaxBackoff {
+		backoff = guestAgentMaxBackoff
+	}
+	return backoff
+}
+
+// updateGuestInfo polls the guest agent of a running domain, if any, and
+// publishes the status when what it reports changed. Backs off while the
+// agent does not answer.
+func updateGuestInfo(ctx *domainContext, status *types.DomainStatus,
+	poll *guestAgentPoll) {
+	if !status.GuestAgent || !status.Activated || status.State != types.RUNNING {
+		// Poll right away once (re)started
+		*poll = guestAgentPoll{}
+		return
+	}
+	agent, ok := hyper.Task(status).(hypervisor.GuestAgentTask)
+	if !ok {
+		return
+	}
+	now := time.Now()
+	if now.Before(poll.next) {
+		return
+	}
+	info, err := agent.GuestInfo(status.DomainName)
+	if err != nil {
+		poll.failures++
+		backoff := guestAgentBackoff(poll.failures)
+		poll.next = time.Now().Add(backoff)
+		log.Functionf("updateGuestInfo(%s): %v; next poll in %v",
+			status.Key(), err, backoff)
+		// Keep what was last reported
+		info = status.GuestInfo
+		info.Alive = false
+	} else {
+		*poll = guestAgentPoll{}
+	}
+	// Not worth publishing just to update LastSeen
+	old := status.GuestInfo
+	old.LastSeen = info.LastSeen
+	if reflect.DeepEqual(old, info) {
+		status.GuestInfo = info
+		return
+	}
+	if info.Alive != status.GuestInfo.Alive {
+		log.Noticef("updateGuestInfo(%s): guest agent alive %t",
+			status.Key(), info.Alive)
+	}
+	status.GuestInfo = info
+	publishDomainStatus(ctx, status)
+}
@@ -0,0 +1,19 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package domainmgr
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGuestAgentBackoff(t *testing.T) {
+	assert.Equal(t, time.Minute, guestAgentBackoff(1))
+	assert.Equal(t, 2*time.Minute, guestAgentBackoff(2))
+	assert.Equal(t, 8*time.Minute, guestAgentBackoff(4))
+	assert.Equal(t, guestAgentMaxBackoff, guestAgentBackoff(5))
+	assert.Equal(t, guestAgentMaxBackoff, guestAgentBackoff(100))
+}
@@ -0,0 +1,159 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package domainmgr
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/containerd/cgroups"
+	"github.com/lf-edge/eve/pkg/pillar/hypervisor"
+	"github.com/lf-edge/eve/pkg/pillar/types"
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+const (
+	cgroupV1MemoryLimit = "memory.limit_in_bytes"
+	cgroupV2MemoryMax   = "memory.max"
+	cgroupV2CPUMax      = "cpu.max"
+	// cgroupCPUPeriod is the CPU period of the task, set in its OCI spec
+	cgroupCPUPeriod = 100000
+)
+
+// resourcesGrew returns true if the config gives the running domain more
+// vCPUs or memory than it has. zedmanager only lets them grow without a
+// restart, anything else comes with the restart.
+func resourcesGrew(config *types.DomainConfig, status *types.DomainStatus) bool {
+	return config.VCpus > status.VCpus || config.Memory > status.Memory
+}
+
+// hotplugResources adds the vCPUs and memory the config grew by to the
+// running domain, raising the CPU quota and the memory limit of its task
+// cgroup for them first. If it can't, HotplugFailed tells zedmanager to
+// restart the domain for them to take effect.
+func hotplugResources(ctx *domainContext, config *types.DomainConfig, status *types.DomainStatus) {
+	log.Functionf("hotplugResources(%s) from %d vCPUs %d kbytes to %d vCPUs %d kbytes",
+		status.Key(), status.VCpus, status.Memory, config.VCpus, config.Memory)
+	err := errors.New("hot-plug not supported by the hypervisor")
+	if task, ok := hyper.Task(status).(hypervisor.HotplugTask); ok {
+		err = nil
+		if config.VCpus > status.VCpus {
+			err = hotplugCPUs(task, status, config.VCpus)
+		}
+		if err == nil && config.Memory > status.Memory {
+			err = hotplugMemory(task, status, config.Memory)
+		}
+	}
+	if err != nil {
+		log.Warnf("hotplugResources(%s) failed, needs a restart: %v",
+			status.Key(), err)
+		status.HotplugFailed = true
+	} else {
+		log.Noticef("hotplugResources(%s) now %d vCPUs %d kbytes",
+			status.Key(), status.VCpus, status.Memory)
+	}
+	publishDomainStatus(ctx, status)
+}
+
+// hotplugCPUs raises the CPU quota of the task for vcpus vCPUs and plugs
+// them; the quota is put back if they cannot be plugged
+func hotplugCPUs(task hypervisor.HotplugTask, status *types.DomainStatus, vcpus int) error {
+	if err := setCgroupCPUQuota(status.DomainName, vcpus); err != nil {
+		return fmt.Errorf("raising the CPU quota: %w", err)
+	}
+	if err := task.HotplugCPUs(status.DomainName, vcpus); err != nil {
+		if qerr := setCgroupCPUQuota(status.DomainName, status.VCpus); qerr != nil {
+			log.Errorf("hotplugCPUs(%s): putting the CPU quota back: %v",
+				status.Key(), qerr)
+		}
+		return err
+	}
+	status.VCpus = vcpus
+	return nil
+}
+
+// hotplugMemory raises the memory limit of the task by what the domain
+// gets and plugs it; the limit is put back if it cannot be plugged
+func hotplugMemory(task hypervisor.HotplugTask, status *types.DomainStatus, memory int) error {
+	limit, err := readCgroupMemoryLimit(status.DomainName)
+	if err != nil {
+		return fmt.Errorf("reading the memory limit: %w", err)
+	}
+	if limit != 0 {
+		// The limit has the overhead of the VMM on top of the memory
+		newLimit := limit + int64(memory-status.Memory)<<10
+		if err := setCgroupMemoryLimit(status.DomainName, newLimit); err != nil {
+			return fmt.Errorf("raising the memory limit: %w", err)
+		}
+	}
+	if err := task.HotplugMemory(status.DomainName, memory); err != nil {
+		if limit != 0 {
+			if lerr := setCgroupMemoryLimit(status.DomainName, limit); lerr != nil {
+				log.Errorf("hotplugMemory(%s): putting the memory limit back: %v",
+					status.Key(), lerr)
+			}
+		}
+		return err
+	}
+	status.Memory = memory
+	return nil
+}
+
+// readCgroupMemoryLimit returns the memory limit of the task of the domain
+// in bytes, 0 if not limited
+func readCgroupMemoryLimit(domainName string) (int64, error) {
+	path := filepath.Join(cgroupRoot, "memory", domainCgroupName(domainName), cgroupV1MemoryLimit)
+	if isCgroupV2() {
+		path = filepath.Join(cgroupRoot, domainCgroupName(domainName), cgroupV2MemoryMax)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	value := strings.TrimSpace(string(data))
+	if value == "max" {
+		return 0, nil
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
+
+// setCgroupMemoryLimit sets the memory limit of the task of the domain
+func setCgroupMemoryLimit(domainName string, limit int64) error {
+	cgroupName := domainCgroupName(domainName)
+	if isCgroupV2() {
+		return os.WriteFile(filepath.Join(cgroupRoot, cgroupName, cgroupV2MemoryMax),
+			[]byte(strconv.FormatInt(limit, 10)), 0644)
+	}
+	controller, err := cgroups.Load(cgroups.V1, cgroups.StaticPath(cgroupName))
+	if err != nil {
+		return err
+	}
+	return controller.Update(&specs.LinuxResources{Memory: &specs.LinuxMemory{
+		Limit: &limit,
+	}})
+}
+
+// setCgroupCPUQuota sets the CPU quota of the task of the domain for it to
+// run vcpus vCPUs, as its OCI spec does when it starts
+func setCgroupCPUQuota(domainName string, vcpus int) error {
+	cgroupName := domainCgroupName(domainName)
+	period := uint64(cgroupCPUPeriod)
+	quota := int64(cgroupCPUPeriod * vcpus)
+	if isCgroupV2() {
+		return os.WriteFile(filepath.Join(cgroupRoot, cgroupName, cgroupV2CPUMax),
+			[]byte(fmt.Sprintf("%d %d", quota, period)), 0644)
+	}
+	controller, err := cgroups.Load(cgroups.V1, cgroups.StaticPath(cgroupName))
+	if err != nil {
+		return err
+	}
+	return controller.Update(&specs.LinuxResources{CPU: &specs.LinuxCPU{
+		Period: &period,
+		Quota:  &quota,
+	}})
+}
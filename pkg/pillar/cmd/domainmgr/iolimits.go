@@ -0,0 +1,348 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package domainmgr
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/containerd/cgroups"
+	"github.com/lf-edge/eve/pkg/pillar/containerd"
+	"github.com/lf-edge/eve/pkg/pillar/types"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/sys/unix"
+)
+
+// The block I/O limits of a domain are enforced on its task cgroup, so they
+// apply to the I/O done by the VMM or the container of the domain. With Xen
+// it is done by the backend drivers of dom0 instead, and is not limited.
+
+const (
+	cgroupRoot        = "/sys/fs/cgroup"
+	sysfsDevBlock     = "/sys/dev/block"
+	sysfsClassNet     = "/sys/class/net"
+	cgroupV2IOMax     = "io.max"
+	cgroupV2IOWeight  = "io.weight"
+	cgroupV2IOStat    = "io.stat"
+	cgroupV1MinWeight = 10
+	cgroupV1MaxWeight = 1000
+	cgroupV2MaxWeight = 10000
+	// cgroupV2DefaultWeight is the weight of a cgroup left alone
+	cgroupV2DefaultWeight = 100
+)
+
+type blockDevice struct {
+	major int64
+	minor int64
+}
+
+func (dev blockDevice) String() string {
+	return fmt.Sprintf("%d:%d", dev.major, dev.minor)
+}
+
+// isCgroupV2 returns true if the cgroups are the unified hierarchy
+func isCgroupV2() bool {
+	_, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers"))
+	return err == nil
+}
+
+func domainCgroupName(domainName string) string {
+	return filepath.Join(containerd.GetServicesNamespace(), domainName)
+}
+
+// setCgroupIOLimits limits the I/O of the domain on the block devices
+// backing its disks, zvols included, and records them in the status once
+// applied. Files on a ZFS dataset have no block device of their own, the
+// pool does its I/O from its own threads, so their I/O is not limited.
+func setCgroupIOLimits(config *types.DomainConfig, status *types.DomainStatus) error {
+	limits := config.IOLimits
+	if limits.IsZero() && status.IOLimits.IsZero() {
+		return nil
+	}
+	var devices []blockDevice
+	for _, ds := range status.DiskStatusList {
+		dev, err := diskBlockDevice(ds.FileLocation)
+		if err != nil {
+			if !limits.IsZero() {
+				log.Warnf("setCgroupIOLimits(%s): the I/O on %s is not limited: %v",
+					config.DisplayName, ds.FileLocation, err)
+			}
+			continue
+		}
+		if !containsBlockDevice(devices, dev) {
+			devices = append(devices, dev)
+		}
+	}
+	cgroupName := domainCgroupName(status.DomainName)
+	if isCgroupV2() {
+		if err := setCgroupV2IOLimits(filepath.Join(cgroupRoot, cgroupName), limits, devices); err != nil {
+			return err
+		}
+		status.IOLimits = limits
+		log.Functionf("Limited the block I/O of %s to %+v on %v", config.DisplayName, limits, devices)
+		return nil
+	}
+	controller, err := cgroups.Load(cgroups.V1, cgroups.StaticPath(cgroupName))
+	if err != nil {
+		// It's still not an error, since the path may still not exist
+		log.Warnf("Failed to find cgroups directory for %s", config.DisplayName)
+		return nil
+	}
+	if err := controller.Update(&specs.LinuxResources{BlockIO: cgroupV1BlockIO(limits, devices)}); err != nil {
+		log.Warnf("Failed to update block I/O limits for %s", config.DisplayName)
+		return err
+	}
+	status.IOLimits = limits
+	log.Functionf("Limited the block I/O of %s to %+v on %v", config.DisplayName, limits, devices)
+	return nil
+}
+
+func cgroupV1BlockIO(limits types.IOLimits, devices []blockDevice) *specs.LinuxBlockIO {
+	blockIO := &specs.LinuxBlockIO{}
+	if limits.Weight != 0 {
+		weight := uint16(cgroupV1MinWeight + (uint32(limits.Weight)-1)*
+			(cgroupV1MaxWeight-cgroupV1MinWeight)/(cgroupV2MaxWeight-1))
+		blockIO.Weight = &weight
+	}
+	// A rate of 0 removes the throttle, for the limits to be lifted
+	throttle := func(rate uint64) []specs.LinuxThrottleDevice {
+		var throttles []specs.LinuxThrottleDevice
+		for _, dev := range devices {
+			throttle := specs.LinuxThrottleDevice{Rate: rate}
+			throttle.Major = dev.major
+			throttle.Minor = dev.minor
+			throttles = append(throttles, throttle)
+		}
+		return throttles
+	}
+	blockIO.ThrottleReadBpsDevice = throttle(limits.ReadBps)
+	blockIO.ThrottleWriteBpsDevice = throttle(limits.WriteBps)
+	blockIO.ThrottleReadIOPSDevice = throttle(limits.ReadIOPS)
+	blockIO.ThrottleWriteIOPSDevice = throttle(limits.WriteIOPS)
+	return blockIO
+}
+
+func setCgroupV2IOLimits(cgroupPath string, limits types.IOLimits, devices []blockDevice) error {
+	// Back to the default when no longer set
+	weight := limits.Weight
+	if weight == 0 {
+		weight = cgroupV2DefaultWeight
+	}
+	if err := os.WriteFile(filepath.Join(cgroupPath, cgroupV2IOWeight),
+		[]byte(fmt.Sprintf("default %d", weight)), 0644); err != nil {
+		return err
+	}
+	max := func(rate uint64) string {
+		if rate == 0 {
+			return "max"
+		}
+		return strconv.FormatUint(rate, 10)
+	}
+	for _, dev := range devices {
+		line := fmt.Sprintf("%s rbps=%s wbps=%s riops=%s wiops=%s", dev,
+			max(limits.ReadBps), max(limits.WriteBps),
+			max(limits.ReadIOPS), max(limits.WriteIOPS))
+		if err := os.WriteFile(filepath.Join(cgroupPath, cgroupV2IOMax), []byte(line), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// diskBlockDevice returns the whole disk the disk of a domain is on, or is
+func diskBlockDevice(location string) (blockDevice, error) {
+	var st unix.Stat_t
+	if err := unix.Stat(location, &st); err != nil {
+		return blockDevice{}, err
+	}
+	dev := st.Dev
+	if st.Mode&unix.S_IFMT == unix.S_IFBLK {
+		dev = st.Rdev
+	}
+	device := blockDevice{major: int64(unix.Major(dev)), minor: int64(unix.Minor(dev))}
+	sysfsPath := filepath.Join(sysfsDevBlock, device.String())
+	if _, err := os.Stat(sysfsPath); err != nil {
+		return blockDevice{}, fmt.Errorf("%s is not on a block device", location)
+	}
+	// The limits only apply to whole disks
+	if _, err := os.Stat(filepath.Join(sysfsPath, "partition")); err == nil {
+		data, err := os.ReadFile(filepath.Join(sysfsPath, "..", "dev"))
+		if err != nil {
+			return blockDevice{}, err
+		}
+		if _, err := fmt.Sscanf(string(data), "%d:%d", &device.major, &device.minor); err != nil {
+			return blockDevice{}, err
+		}
+	}
+	return device, nil
+}
+
+func containsBlockDevice(devices []blockDevice, dev blockDevice) bool {
+	for _, d := range devices {
+		if d == dev {
+			return true
+		}
+	}
+	return false
+}
+
+// readCgroupIOUsage returns the block I/O of the domain since it started
+func readCgroupIOUsage(domainName string) (types.IOUsage, error) {
+	cgroupName := domainCgroupName(domainName)
+	if isCgroupV2() {
+		return readCgroupV2IOStat(filepath.Join(cgroupRoot, cgroupName, cgroupV2IOStat))
+	}
+	var usage types.IOUsage
+	controller, err := cgroups.Load(cgroups.V1, cgroups.StaticPath(cgroupName))
+	if err != nil {
+		return usage, err
+	}
+	metrics, err := controller.Stat(cgroups.IgnoreNotExist)
+	if err != nil {
+		return usage, err
+	}
+	if metrics.Blkio == nil {
+		return usage, nil
+	}
+	for _, entry := range metrics.Blkio.IoServiceBytesRecursive {
+		switch entry.Op {
+		case "Read":
+			usage.ReadBytes += entry.Value
+		case "Write":
+			usage.WriteBytes += entry.Value
+		}
+	}
+	for _, entry := range metrics.Blkio.IoServicedRecursive {
+		switch entry.Op {
+		case "Read":
+			usage.ReadOps += entry.Value
+		case "Write":
+			usage.WriteOps += entry.Value
+		}
+	}
+	return usage, nil
+}
+
+// readCgroupV2IOStat sums the lines of io.stat for all the devices e.g.,
+// 8:0 rbytes=1459200 wbytes=314773504 rios=192 wios=353 dbytes=0 dios=0
+func readCgroupV2IOStat(path string) (types.IOUsage, error) {
+	var usage types.IOUsage
+	file, err := os.Open(path)
+	if err != nil {
+		return usage, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// A device followed by its counters
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		for _, field := range fields[1:] {
+			key, value, found := strings.Cut(field, "=")
+			if !found {
+				continue
+			}
+			n, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				continue
+			}
+			switch key {
+			case "rbytes":
+				usage.ReadBytes += n
+			case "wbytes":
+				usage.WriteBytes += n
+			case "rios":
+				usage.ReadOps += n
+			case "wios":
+				usage.WriteOps += n
+			}
+		}
+	}
+	return usage, scanner.Err()
+}
+
+// readVifTraffic returns the traffic of the VIFs of the domain since they
+// were created. The VIFs are seen from the host, so what the app sends is
+// what they receive.
+func readVifTraffic(status *types.DomainStatus) []types.VifTraffic {
+	var traffic []types.VifTraffic
+	for _, vif := range status.VifList {
+		vifName := vif.VifUsed
+		if vifName == "" {
+			vifName = vif.Vif
+		}
+		rx, err := readNetStatistic(vifName, "rx_bytes")
+		if err != nil {
+			continue
+		}
+		tx, err := readNetStatistic(vifName, "tx_bytes")
+		if err != nil {
+			continue
+		}
+		traffic = append(traffic, types.VifTraffic{
+			Vif:          vif.Vif,
+			EgressBytes:  rx,
+			IngressBytes: tx,
+			RateLimits:   vif.VifRateLimits,
+		})
+	}
+	return traffic
+}
+
+func readNetStatistic(ifName string, statistic string) (uint64, error) {
+	data, err := os.ReadFile(filepath.Join(sysfsClassNet, ifName, "statistics", statistic))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+}
+
+// updateIOMetric fills the I/O usage and limits of a running domain in its
+// metric, with the rates since the previous metric
+func updateIOMetric(ctx *domainContext, status *types.DomainStatus,
+	dm *types.DomainMetric, now time.Time) {
+	dm.IOLimits = status.IOLimits
+	usage, err := readCgroupIOUsage(status.DomainName)
+	if err != nil {
+		log.Functionf("updateIOMetric(%s): %v", status.Key(), err)
+	}
+	dm.IOUsage = usage
+	dm.VifTraffic = readVifTraffic(status)
+
+	st, _ := ctx.pubDomainMetric.Get(dm.Key())
+	if st == nil {
+		return
+	}
+	previous := st.(types.DomainMetric)
+	elapsed := now.Sub(previous.LastHeard).Seconds()
+	if elapsed <= 0 || !previous.Activated {
+		return
+	}
+	rate := func(current, previous uint64) uint64 {
+		if current < previous {
+			// Restarted
+			return 0
+		}
+		return uint64(float64(current-previous) / elapsed)
+	}
+	dm.IOUsage.ReadBps = rate(usage.ReadBytes, previous.IOUsage.ReadBytes)
+	dm.IOUsage.WriteBps = rate(usage.WriteBytes, previous.IOUsage.WriteBytes)
+	dm.IOUsage.ReadIOPS = rate(usage.ReadOps, previous.IOUsage.ReadOps)
+	dm.IOUsage.WriteIOPS = rate(usage.WriteOps, previous.IOUsage.WriteOps)
+	for i, vif := range dm.VifTraffic {
+		for _, prev := range previous.VifTraffic {
+			if prev.Vif == vif.Vif {
+				dm.VifTraffic[i].EgressBps = 8 * rate(vif.EgressBytes, prev.EgressBytes)
+				dm.VifTraffic[i].IngressBps = 8 * rate(vif.IngressBytes, prev.IngressBytes)
+			}
+		}
+	}
+}
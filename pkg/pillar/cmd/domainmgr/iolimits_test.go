@@ -0,0 +1,94 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package domainmgr
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lf-edge/eve/pkg/pillar/types"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCgroupV1BlockIO(t *testing.T) {
+	devices := []blockDevice{{major: 8, minor: 0}, {major: 230, minor: 16}}
+	throttles := func(rate uint64) []specs.LinuxThrottleDevice {
+		var throttles []specs.LinuxThrottleDevice
+		for _, dev := range devices {
+			throttle := specs.LinuxThrottleDevice{Rate: rate}
+			throttle.Major = dev.major
+			throttle.Minor = dev.minor
+			throttles = append(throttles, throttle)
+		}
+		return throttles
+	}
+	weight := func(w uint16) *uint16 { return &w }
+	tests := map[string]struct {
+		limits   types.IOLimits
+		expected *specs.LinuxBlockIO
+	}{
+		"lowest weight": {
+			limits: types.IOLimits{Weight: 1},
+			expected: &specs.LinuxBlockIO{
+				Weight:                  weight(10),
+				ThrottleReadBpsDevice:   throttles(0),
+				ThrottleWriteBpsDevice:  throttles(0),
+				ThrottleReadIOPSDevice:  throttles(0),
+				ThrottleWriteIOPSDevice: throttles(0),
+			},
+		},
+		"highest weight": {
+			limits: types.IOLimits{Weight: 10000},
+			expected: &specs.LinuxBlockIO{
+				Weight:                  weight(1000),
+				ThrottleReadBpsDevice:   throttles(0),
+				ThrottleWriteBpsDevice:  throttles(0),
+				ThrottleReadIOPSDevice:  throttles(0),
+				ThrottleWriteIOPSDevice: throttles(0),
+			},
+		},
+		"throttles": {
+			limits: types.IOLimits{ReadBps: 1 << 20, WriteIOPS: 100},
+			expected: &specs.LinuxBlockIO{
+				ThrottleReadBpsDevice:   throttles(1 << 20),
+				ThrottleWriteBpsDevice:  throttles(0),
+				ThrottleReadIOPSDevice:  throttles(0),
+				ThrottleWriteIOPSDevice: throttles(100),
+			},
+		},
+		"lifted": {
+			limits: types.IOLimits{},
+			expected: &specs.LinuxBlockIO{
+				ThrottleReadBpsDevice:   throttles(0),
+				ThrottleWriteBpsDevice:  throttles(0),
+				ThrottleReadIOPSDevice:  throttles(0),
+				ThrottleWriteIOPSDevice: throttles(0),
+			},
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, test.expected, cgroupV1BlockIO(test.limits, devices))
+		})
+	}
+}
+
+func TestReadCgroupV2IOStat(t *testing.T) {
+	path := filepath.Join(t.TempDir(), cgroupV2IOStat)
+	stat := "8:0 rbytes=1459200 wbytes=314773504 rios=192 wios=353 dbytes=0 dios=0\n" +
+		"\n" +
+		"230:16 rbytes=800 wbytes=200 rios=2 wios=1 dbytes=0 dios=0\n"
+	assert.Nil(t, os.WriteFile(path, []byte(stat), 0644))
+
+	usage, err := readCgroupV2IOStat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, types.IOUsage{
+		ReadBytes:  1460000,
+		WriteBytes: 314773704,
+		ReadOps:    194,
+		WriteOps:   354,
+	}, usage)
+}
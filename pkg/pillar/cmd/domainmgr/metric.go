@@ -140,6 +140,8 @@ func getAndPublishMetrics(ctx *domainContext, hyper hypervisor.Hypervisor) {
 			dm.UsedMemory = 0
 			dm.MaxUsedMemory = 0
 			dm.UsedMemoryPercent = 0
+		} else if status != nil {
+			updateIOMetric(ctx, status, &dm, now)
 		}
 
 		logWatermarks(ctx, status, &dm)
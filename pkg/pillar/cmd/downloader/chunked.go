@@ -0,0 +1,277 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package downloader
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/lf-edge/eve/pkg/pillar/chunker"
+	"github.com/lf-edge/eve/pkg/pillar/types"
+	"github.com/lf-edge/eve/pkg/pillar/zboot"
+)
+
+const (
+	// Smaller blobs are always downloaded whole
+	minChunkedSize = 16 << 20
+	// The chunk index of the blob with sha256 <hex> is the first layer of
+	// the image tagged sha256-<hex><chunkIndexTagSuffix>
+	chunkIndexTagSuffix = ".chunks"
+	// Bounds the chunk index we read, about 1MiB for 1GiB of content
+	maxChunkIndexSize = 16 << 20
+)
+
+// errNoChunkIndex is returned when the blob has no usable chunk index, it is
+// downloaded whole then
+var errNoChunkIndex = errors.New("no chunk index")
+
+// chunkSource is where a chunk can be read locally
+type chunkSource struct {
+	file   *os.File
+	offset int64
+}
+
+// downloadChunked assembles the blob with sha256 blobSha in locFilename from the
+// chunks of its chunk index, reusing the chunks already in locFilename from
+// a previous attempt and, for the blobs of the base OS image, the ones of the
+// current partition, and downloading the others from the repository of
+// remoteName at the pace of limiters. Returns how many bytes it
+// downloaded, or errNoChunkIndex if the blob has no chunk index.
+func downloadChunked(ctx *downloaderContext, st *PublishStatus,
+	dsCtx *types.DatastoreContext, serverURL, remoteName, ifname string,
+	ipSrc net.IP, blobSha string, locFilename string, isBaseOS bool,
+	limiters []*rateLimiter) (int64, error) {
+
+	blobSha = strings.ToLower(blobSha)
+	ref, err := name.ParseReference(serverURL + "/" + remoteName)
+	if err != nil {
+		return 0, fmt.Errorf("invalid reference %s/%s: %v", serverURL, remoteName, err)
+	}
+	repo := ref.Context()
+	reqCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// Gives up once no chunk comes for maxStalledTime
+	stalled := time.AfterFunc(maxStalledTime, cancel)
+	defer stalled.Stop()
+	opts, err := registryOptions(reqCtx, ctx, dsCtx, serverURL, ifname, ipSrc,
+		limiters)
+	if err != nil {
+		return 0, err
+	}
+	index, err := fetchChunkIndex(repo, blobSha, opts)
+	if err != nil {
+		return 0, err
+	}
+	log.Noticef("downloadChunked(%s): %d chunks", blobSha, len(index.Chunks))
+
+	file, err := os.OpenFile(locFilename, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	existing := info.Size()
+	if err := file.Truncate(index.Size); err != nil {
+		return 0, err
+	}
+
+	var seed *os.File
+	if isBaseOS {
+		// Only another base OS image has chunks in common with it
+		seed = openPartitionSeed(blobSha)
+	}
+	if seed != nil {
+		defer seed.Close()
+	}
+	sources := findChunks(index, seed)
+
+	fetch := func(chunk chunker.Chunk, data []byte) error {
+		layer, err := remote.Layer(repo.Digest("sha256:"+chunk.Sha256), opts...)
+		if err != nil {
+			return err
+		}
+		reader, err := layer.Compressed()
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return fmt.Errorf("chunk %s: %v", chunk.Sha256, err)
+		}
+		stalled.Reset(maxStalledTime)
+		return nil
+	}
+	fetched, err := assembleChunks(index, file, existing, sources, fetch, st)
+	if err != nil {
+		return fetched, err
+	}
+	log.Noticef("downloadChunked(%s): downloaded %d of %d bytes", blobSha,
+		fetched, index.Size)
+	return fetched, nil
+}
+
+// fetchChunkIndex returns the chunk index of the blob with sha256 blobSha in
+// repo
+func fetchChunkIndex(repo name.Repository, blobSha string,
+	opts []remote.Option) (*chunker.Index, error) {
+
+	tag := repo.Tag("sha256-" + blobSha + chunkIndexTagSuffix)
+	img, err := remote.Image(tag, opts...)
+	if err != nil {
+		var terr *transport.Error
+		if errors.As(err, &terr) && terr.StatusCode == http.StatusNotFound {
+			return nil, errNoChunkIndex
+		}
+		return nil, fmt.Errorf("%s: %v", tag, err)
+	}
+	manifest, err := img.Manifest()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", tag, err)
+	}
+	// The other layers are the chunks, for the registry to keep them
+	if len(manifest.Layers) == 0 ||
+		string(manifest.Layers[0].MediaType) != chunker.IndexMediaType ||
+		manifest.Layers[0].Size > maxChunkIndexSize {
+		return nil, fmt.Errorf("%s: %w", tag, errNoChunkIndex)
+	}
+	layer, err := img.LayerByDigest(manifest.Layers[0].Digest)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", tag, err)
+	}
+	reader, err := layer.Compressed()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", tag, err)
+	}
+	defer reader.Close()
+	var index chunker.Index
+	if err := json.NewDecoder(io.LimitReader(reader, maxChunkIndexSize)).Decode(&index); err != nil {
+		return nil, fmt.Errorf("%s: %v: %w", tag, err, errNoChunkIndex)
+	}
+	if err := index.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %v: %w", tag, err, errNoChunkIndex)
+	}
+	if !strings.EqualFold(index.Sha256, blobSha) {
+		return nil, fmt.Errorf("%s: index of %s: %w", tag, index.Sha256, errNoChunkIndex)
+	}
+	return &index, nil
+}
+
+// openPartitionSeed returns the current partition to reuse the chunks of,
+// nil if it cannot be read
+func openPartitionSeed(blobSha string) *os.File {
+	devName := zboot.GetCurrentPartitionDevName()
+	seed, err := os.Open(devName)
+	if err != nil {
+		log.Warnf("downloadChunked(%s): not reusing the current partition: %v",
+			blobSha, err)
+		return nil
+	}
+	return seed
+}
+
+// findChunks returns where the chunks of index are in seed, split the same
+// way as the content of the index
+func findChunks(index *chunker.Index, seed *os.File) map[string]chunkSource {
+	sources := make(map[string]chunkSource)
+	if seed == nil {
+		return sources
+	}
+	wanted := make(map[string]bool)
+	for _, chunk := range index.Chunks {
+		wanted[chunk.Sha256] = true
+	}
+	err := chunker.Split(seed, index.Params, func(offset int64, data []byte) error {
+		sum := sha256.Sum256(data)
+		sha := hex.EncodeToString(sum[:])
+		if _, ok := sources[sha]; !ok && wanted[sha] {
+			sources[sha] = chunkSource{file: seed, offset: offset}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Warnf("findChunks(%s) in %s: %v", index.Sha256, seed.Name(), err)
+	}
+	log.Functionf("findChunks(%s): %d of %d chunks in %s", index.Sha256,
+		len(sources), len(wanted), seed.Name())
+	return sources
+}
+
+// readChunk reads the chunk at offset in file into data and returns true if
+// it has the content of chunk
+func readChunk(file *os.File, offset int64, chunk chunker.Chunk, data []byte) bool {
+	if _, err := file.ReadAt(data, offset); err != nil {
+		return false
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]) == chunk.Sha256
+}
+
+// assembleChunks writes the chunks of index in file, which holds the
+// content of a previous attempt up to existing bytes, copying them from
+// sources or fetching them, and checks the result against the sha256 of the
+// index. Returns how many bytes were fetched.
+func assembleChunks(index *chunker.Index, file *os.File, existing int64,
+	sources map[string]chunkSource, fetch func(chunker.Chunk, []byte) error,
+	st *PublishStatus) (int64, error) {
+
+	buf := make([]byte, index.MaxSize)
+	var offset, fetched int64
+	var progress uint
+	for _, chunk := range index.Chunks {
+		data := buf[:chunk.Size]
+		// A previous attempt may have written it already
+		done := offset+chunk.Size <= existing && readChunk(file, offset, chunk, data)
+		if !done {
+			source, ok := sources[chunk.Sha256]
+			if !ok || !readChunk(source.file, source.offset, chunk, data) {
+				if err := fetch(chunk, data); err != nil {
+					return fetched, err
+				}
+				sum := sha256.Sum256(data)
+				if got := hex.EncodeToString(sum[:]); got != chunk.Sha256 {
+					return fetched, fmt.Errorf("chunk %s: got sha256 %s",
+						chunk.Sha256, got)
+				}
+				fetched += chunk.Size
+			}
+			if _, err := file.WriteAt(data, offset); err != nil {
+				return fetched, err
+			}
+		}
+		offset += chunk.Size
+		if st != nil && index.Size > 0 {
+			if p := uint(offset * 100 / index.Size); p != progress {
+				progress = p
+				st.Progress(progress, offset, index.Size)
+			}
+		}
+	}
+	if err := file.Sync(); err != nil {
+		return fetched, err
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(hash, io.NewSectionReader(file, 0, index.Size)); err != nil {
+		return fetched, err
+	}
+	if got := hex.EncodeToString(hash.Sum(nil)); !strings.EqualFold(got, index.Sha256) {
+		return fetched, fmt.Errorf("assembled sha256 %s instead of %s", got, index.Sha256)
+	}
+	return fetched, nil
+}
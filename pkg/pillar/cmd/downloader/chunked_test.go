@@ -0,0 +1,140 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package downloader
+
+import (
+	"bytes"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lf-edge/eve/pkg/pillar/base"
+	"github.com/lf-edge/eve/pkg/pillar/chunker"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+var testChunkParams = chunker.Params{
+	MinSize: 1 << 10,
+	AvgSize: 4 << 10,
+	MaxSize: 16 << 10,
+}
+
+func randomContent(seed int64, size int) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+// writeTestFile returns the file named name in dir with content
+func writeTestFile(t *testing.T, dir, name string, content []byte) *os.File {
+	fileName := filepath.Join(dir, name)
+	assert.NoError(t, os.WriteFile(fileName, content, 0644))
+	file, err := os.OpenFile(fileName, os.O_RDWR, 0)
+	assert.NoError(t, err)
+	t.Cleanup(func() { file.Close() })
+	return file
+}
+
+// chunkFetcher returns a fetch function of assembleChunks reading the
+// chunks of content split with index, and the count of the fetches
+func chunkFetcher(index *chunker.Index, content []byte) (func(chunker.Chunk, []byte) error, *int) {
+	offsets := make(map[string]int64)
+	var offset int64
+	for _, chunk := range index.Chunks {
+		offsets[chunk.Sha256] = offset
+		offset += chunk.Size
+	}
+	count := 0
+	return func(chunk chunker.Chunk, data []byte) error {
+		count++
+		copy(data, content[offsets[chunk.Sha256]:])
+		return nil
+	}, &count
+}
+
+func TestFindChunks(t *testing.T) {
+	log = base.NewSourceLogObject(logrus.StandardLogger(), "downloader", 0)
+	content := randomContent(1, 1<<20)
+	index, err := chunker.MakeIndex(bytes.NewReader(content), testChunkParams)
+	assert.NoError(t, err)
+
+	assert.Empty(t, findChunks(index, nil), "no seed")
+
+	// A partition holding the first half of the content after other data
+	dir := t.TempDir()
+	seedContent := append(randomContent(2, 100<<10), content[:len(content)/2]...)
+	seed := writeTestFile(t, dir, "seed", seedContent)
+	sources := findChunks(index, seed)
+	assert.NotEmpty(t, sources)
+	assert.Less(t, len(sources), len(index.Chunks))
+	data := make([]byte, index.MaxSize)
+	for _, chunk := range index.Chunks {
+		source, ok := sources[chunk.Sha256]
+		if ok {
+			assert.True(t, readChunk(source.file, source.offset, chunk,
+				data[:chunk.Size]))
+		}
+	}
+
+	unrelated := writeTestFile(t, dir, "unrelated", randomContent(3, 1<<20))
+	assert.Empty(t, findChunks(index, unrelated))
+}
+
+func TestAssembleChunks(t *testing.T) {
+	log = base.NewSourceLogObject(logrus.StandardLogger(), "downloader", 0)
+	content := randomContent(1, 1<<20)
+	index, err := chunker.MakeIndex(bytes.NewReader(content), testChunkParams)
+	assert.NoError(t, err)
+	dir := t.TempDir()
+
+	// Everything is fetched without a seed
+	file := writeTestFile(t, dir, "whole", nil)
+	assert.NoError(t, file.Truncate(index.Size))
+	fetch, count := chunkFetcher(index, content)
+	fetched, err := assembleChunks(index, file, 0, nil, fetch, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, index.Size, fetched)
+	assert.Equal(t, len(index.Chunks), *count)
+	data, err := os.ReadFile(file.Name())
+	assert.NoError(t, err)
+	assert.Equal(t, content, data)
+
+	// The chunks in the seed are copied
+	seed := writeTestFile(t, dir, "seed", content[:len(content)/2])
+	sources := findChunks(index, seed)
+	file = writeTestFile(t, dir, "seeded", nil)
+	assert.NoError(t, file.Truncate(index.Size))
+	fetch, count = chunkFetcher(index, content)
+	fetched, err = assembleChunks(index, file, 0, sources, fetch, nil)
+	assert.NoError(t, err)
+	assert.Less(t, fetched, index.Size)
+	assert.Equal(t, len(index.Chunks)-len(sources), *count)
+	data, err = os.ReadFile(file.Name())
+	assert.NoError(t, err)
+	assert.Equal(t, content, data)
+
+	// A previous attempt is resumed
+	existing := content[:len(content)/2]
+	file = writeTestFile(t, dir, "resumed", existing)
+	assert.NoError(t, file.Truncate(index.Size))
+	fetch, _ = chunkFetcher(index, content)
+	fetched, err = assembleChunks(index, file, int64(len(existing)), nil, fetch, nil)
+	assert.NoError(t, err)
+	assert.Less(t, fetched, index.Size-int64(len(existing))+int64(index.MaxSize))
+	data, err = os.ReadFile(file.Name())
+	assert.NoError(t, err)
+	assert.Equal(t, content, data)
+
+	// A chunk with another content is an error
+	file = writeTestFile(t, dir, "corrupt", nil)
+	assert.NoError(t, file.Truncate(index.Size))
+	corrupt := func(chunk chunker.Chunk, data []byte) error {
+		copy(data, randomContent(4, len(data)))
+		return nil
+	}
+	_, err = assembleChunks(index, file, 0, nil, corrupt, nil)
+	assert.Error(t, err)
+}
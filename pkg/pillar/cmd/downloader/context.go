@@ -27,6 +27,9 @@ type downloaderContext struct {
 	pubCipherBlockStatus     pubsub.Publication
 	subDatastoreConfig       pubsub.Subscription
 	subNetworkInstanceStatus pubsub.Subscription
+	subAppInstanceConfig     pubsub.Subscription
+	subVolumeConfig          pubsub.Subscription
+	subContentTreeStatus     pubsub.Subscription
 	subControllerCert        pubsub.Subscription
 	subEdgeNodeCert          pubsub.Subscription
 	deviceNetworkStatus      types.DeviceNetworkStatus
@@ -39,6 +42,9 @@ type downloaderContext struct {
 	netDumper                *netdump.NetDumper // nil if netdump is disabled
 	netdumpWithPCAP          bool
 	netdumpWithHdrFieldVal   bool
+	peerSharing              peerSharing
+	bandwidth                bandwidthShaper
+	localRegistry            localRegistry
 	// cli options
 	versionPtr *bool
 }
@@ -126,10 +132,16 @@ func (ctx *downloaderContext) registerHandlers(ps *pubsub.PubSub) error {
 
 	// Subscribe to NetworkInstanceStatus from zedagent
 	subNetworkInstanceStatus, err := ps.NewSubscription(pubsub.SubscriptionOptions{
-		AgentName:   "zedrouter",
-		MyAgentName: agentName,
-		TopicImpl:   types.NetworkInstanceStatus{},
-		Activate:    false,
+		AgentName:     "zedrouter",
+		MyAgentName:   agentName,
+		TopicImpl:     types.NetworkInstanceStatus{},
+		Activate:      false,
+		Ctx:           ctx,
+		CreateHandler: handleNetworkInstanceCreate,
+		ModifyHandler: handleNetworkInstanceModify,
+		DeleteHandler: handleNetworkInstanceDelete,
+		WarningTime:   warningTime,
+		ErrorTime:     errorTime,
 	})
 	if err != nil {
 		log.Fatal(err)
@@ -137,6 +149,53 @@ func (ctx *downloaderContext) registerHandlers(ps *pubsub.PubSub) error {
 	ctx.subNetworkInstanceStatus = subNetworkInstanceStatus
 	subNetworkInstanceStatus.Activate()
 
+	// The local registry serves the apps on each network instance the
+	// images of their volumes only
+	subAppInstanceConfig, err := ps.NewSubscription(pubsub.SubscriptionOptions{
+		AgentName:   "zedagent",
+		MyAgentName: agentName,
+		TopicImpl:   types.AppInstanceConfig{},
+		Activate:    false,
+		Ctx:         ctx,
+		WarningTime: warningTime,
+		ErrorTime:   errorTime,
+	})
+	if err != nil {
+		return err
+	}
+	ctx.subAppInstanceConfig = subAppInstanceConfig
+	subAppInstanceConfig.Activate()
+
+	subVolumeConfig, err := ps.NewSubscription(pubsub.SubscriptionOptions{
+		AgentName:   "zedagent",
+		MyAgentName: agentName,
+		TopicImpl:   types.VolumeConfig{},
+		Activate:    false,
+		Ctx:         ctx,
+		WarningTime: warningTime,
+		ErrorTime:   errorTime,
+	})
+	if err != nil {
+		return err
+	}
+	ctx.subVolumeConfig = subVolumeConfig
+	subVolumeConfig.Activate()
+
+	subContentTreeStatus, err := ps.NewSubscription(pubsub.SubscriptionOptions{
+		AgentName:   "volumemgr",
+		MyAgentName: agentName,
+		TopicImpl:   types.ContentTreeStatus{},
+		Activate:    false,
+		Ctx:         ctx,
+		WarningTime: warningTime,
+		ErrorTime:   errorTime,
+	})
+	if err != nil {
+		return err
+	}
+	ctx.subContentTreeStatus = subContentTreeStatus
+	subContentTreeStatus.Activate()
+
 	// Look for DatastoreConfig. We should process this
 	// before any download config. Without DataStore Config,
 	// Image Downloads will run into errors, which requires retries
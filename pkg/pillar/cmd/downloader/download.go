@@ -63,7 +63,8 @@ func download(ctx *downloaderContext, trType zedUpload.SyncTransportType,
 	status Status, syncOp zedUpload.SyncOpType, downloadURL string,
 	auth *zedUpload.AuthInput, dpath, region string, maxsize uint64, ifname string,
 	ipSrc net.IP, filename, locFilename string, certs [][]byte, withNetTracing bool,
-	traceOpts []nettrace.TraceOpt, receiveChan chan<- CancelChannel) (
+	traceOpts []nettrace.TraceOpt, limiters []*rateLimiter,
+	receiveChan chan<- CancelChannel) (
 	reqType string, cancel bool, tracedReq netdump.TracedNetRequest, err error) {
 
 	// create Endpoint
@@ -115,6 +116,19 @@ func download(ctx *downloaderContext, trType zedUpload.SyncTransportType,
 		log.Errorf("Lookup Proxy failed: %s", err)
 		return "", cancel, tracedReq, err
 	}
+	if len(limiters) > 0 {
+		if trType == zedUpload.SyncSftpTr {
+			log.Warnf("%s: bandwidth of SFTP downloads is not capped", filename)
+		} else {
+			var done func()
+			proxyURL, done, err = ctx.bandwidth.proxy(limiters, ipSrc, proxyURL)
+			if err != nil {
+				log.Errorf("Bandwidth shaping failed: %s", err)
+				return "", cancel, tracedReq, err
+			}
+			defer done()
+		}
+	}
 	if proxyURL != nil {
 		log.Functionf("%s: Using proxy %s", trType, proxyURL.String())
 		err = dEndPoint.WithProxy(proxyURL)
@@ -149,6 +149,7 @@ func Run(ps *pubsub.PubSub, loggerArg *logrus.Logger, logArg *base.LogObject, ar
 		types.CountLocalAddrAnyNoLinkLocal(ctx.deviceNetworkStatus))
 
 	ctx.dCtx = downloaderInit(&ctx)
+	updatePeerTrustAnchors(&ctx)
 
 	// run gc every 5 minutes
 	gcInterval := 5 * time.Minute
@@ -174,6 +175,15 @@ func Run(ps *pubsub.PubSub, loggerArg *logrus.Logger, logArg *base.LogObject, ar
 		case change := <-ctx.subNetworkInstanceStatus.MsgChan():
 			ctx.subNetworkInstanceStatus.ProcessChange(change)
 
+		case change := <-ctx.subAppInstanceConfig.MsgChan():
+			ctx.subAppInstanceConfig.ProcessChange(change)
+
+		case change := <-ctx.subVolumeConfig.MsgChan():
+			ctx.subVolumeConfig.ProcessChange(change)
+
+		case change := <-ctx.subContentTreeStatus.MsgChan():
+			ctx.subContentTreeStatus.ProcessChange(change)
+
 		case change := <-ctx.subDownloaderConfig.MsgChan():
 			ctx.subDownloaderConfig.ProcessChange(change)
 
@@ -200,6 +210,10 @@ func Run(ps *pubsub.PubSub, loggerArg *logrus.Logger, logArg *base.LogObject, ar
 		case <-gcTimer.C:
 			start := time.Now()
 			clearInProgressDownloadDirs(&ctx)
+			// Retries to serve on the bridges which had no address yet
+			updateLocalRegistry(&ctx)
+			// Picks up the changes of the peer certificates
+			updatePeerTrustAnchors(&ctx)
 			ps.CheckMaxTimeTopic(agentName, "gcTimer", start,
 				warningTime, errorTime)
 
@@ -305,6 +319,7 @@ func runHandler(ctx *downloaderContext, key string, updateChan <-chan Notify,
 			status := lookupDownloaderStatus(ctx, key)
 			if status != nil {
 				maybeRetryDownload(ctx, status, receiveChan)
+				maybeStartDeferredDownload(ctx, status, receiveChan)
 			}
 		}
 	}
@@ -319,6 +334,10 @@ func maybeRetryDownload(ctx *downloaderContext,
 	if !status.HasError() {
 		return
 	}
+	// A deferred download is started by maybeStartDeferredDownload
+	if !status.DeferredUntil.IsZero() {
+		return
+	}
 	config := lookupDownloaderConfig(ctx, status.Key())
 	if config == nil {
 		log.Functionf("maybeRetryDownload(%s) no config",
@@ -510,6 +529,46 @@ func doDownload(ctx *downloaderContext, config types.DownloaderConfig, status *t
 	status.Target = config.Target
 	publishDownloaderStatus(ctx, status)
 
+	// The other devices on the LAN may already have it
+	if downloadFromPeers(ctx, config, status) {
+		downloadDone(status)
+		publishDownloaderStatus(ctx, status)
+		return
+	}
+
+	// Keep the uplinks of the datastores free outside of the download windows
+	now := time.Now()
+	until, reason := downloadDeferral(ctx, config, status.DeferredSince, now)
+	if !until.IsZero() {
+		if !until.Equal(status.DeferredUntil) {
+			log.Noticef("doDownload(%s): deferred until %v: %s",
+				config.Name, until, reason)
+		}
+		if status.DeferredSince.IsZero() {
+			status.DeferredSince = now
+		}
+		status.DeferredUntil = until
+		status.DeferReason = reason
+		// Not downloading yet; the notice tells the controller why
+		status.State = types.INITIAL
+		status.SetErrorDescription(types.ErrorDescription{
+			Error: fmt.Sprintf("Download deferred until %s: %s",
+				until.UTC().Format(time.RFC3339), reason),
+			ErrorSeverity:       types.ErrorSeverityNotice,
+			ErrorRetryCondition: "Will start when the download window opens or the deferral reaches network.download.window.max.defer.hours",
+		})
+		status.ClearPendingStatus()
+		publishDownloaderStatus(ctx, status)
+		return
+	}
+	if !status.DeferredUntil.IsZero() {
+		status.DeferredUntil = time.Time{}
+		status.DeferReason = ""
+		status.DeferredSince = time.Time{}
+		status.ClearError()
+		publishDownloaderStatus(ctx, status)
+	}
+
 	// Usually the list has only one entry, but in some cases config can have
 	// fallback datastores, which should be used in case of an error.
 	// Iterate over the list and try each one until success, accumulating
@@ -535,13 +594,7 @@ func doDownload(ctx *downloaderContext, config types.DownloaderConfig, status *t
 			continue
 		}
 
-		// We do not clear any status.RetryCount, etc. The caller
-		// should look at State == DOWNLOADED to determine it is done.
-		status.ClearError()
-		status.ModTime = time.Now()
-		status.State = types.DOWNLOADED
-		status.Progress = 100 // Just in case
-		status.ClearPendingStatus()
+		downloadDone(status)
 
 		// All good
 		break
@@ -549,6 +602,17 @@ func doDownload(ctx *downloaderContext, config types.DownloaderConfig, status *t
 	publishDownloaderStatus(ctx, status)
 }
 
+// downloadDone marks the download as successfully completed
+func downloadDone(status *types.DownloaderStatus) {
+	// We do not clear any status.RetryCount, etc. The caller
+	// should look at State == DOWNLOADED to determine it is done.
+	status.ClearError()
+	status.ModTime = time.Now()
+	status.State = types.DOWNLOADED
+	status.Progress = 100 // Just in case
+	status.ClearPendingStatus()
+}
+
 func handleDelete(ctx *downloaderContext, key string,
 	status *types.DownloaderStatus) {
 
@@ -43,6 +43,15 @@ func handleGlobalConfigImpl(ctxArg interface{}, key string,
 		ctx.globalConfig = *gcp
 		ctx.GCInitialized = true
 		reinitNetdumper(ctx)
+		updatePeerSharing(ctx)
+		datastoreCaps, err := types.ParseDatastoreKbps(
+			gcp.GlobalValueString(types.DownloadDatastoreKbps))
+		if err != nil {
+			// Not expected, the global config is validated
+			log.Errorf("handleGlobalConfigImpl: %v", err)
+		}
+		ctx.bandwidth.update(gcp.GlobalValueInt(types.DownloadMaxKbps),
+			gcp.GlobalValueInt(types.DownloadDatastoreMaxKbps), datastoreCaps)
 	}
 	log.Functionf("handleGlobalConfigImpl done for %s", key)
 }
@@ -60,6 +69,9 @@ func handleGlobalConfigDelete(ctxArg interface{}, key string,
 		ctx.CLIParams().DebugOverride, logger)
 	ctx.globalConfig = *types.DefaultConfigItemValueMap()
 	reinitNetdumper(ctx)
+	updatePeerSharing(ctx)
+	ctx.bandwidth.update(0, 0, nil)
+	updateLocalRegistry(ctx)
 	log.Functionf("handleGlobalConfigDelete done for %s", key)
 }
 
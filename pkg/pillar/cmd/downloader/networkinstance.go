@@ -0,0 +1,32 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package downloader
+
+func handleNetworkInstanceCreate(ctxArg interface{}, key string,
+	statusArg interface{}) {
+	handleNetworkInstanceImpl(ctxArg, key, statusArg)
+}
+
+func handleNetworkInstanceModify(ctxArg interface{}, key string,
+	statusArg interface{}, oldStatusArg interface{}) {
+	handleNetworkInstanceImpl(ctxArg, key, statusArg)
+}
+
+func handleNetworkInstanceImpl(ctxArg interface{}, key string,
+	statusArg interface{}) {
+
+	ctx := ctxArg.(*downloaderContext)
+	log.Functionf("handleNetworkInstanceImpl for %s", key)
+	updateLocalRegistry(ctx)
+	log.Functionf("handleNetworkInstanceImpl done for %s", key)
+}
+
+func handleNetworkInstanceDelete(ctxArg interface{}, key string,
+	statusArg interface{}) {
+
+	ctx := ctxArg.(*downloaderContext)
+	log.Functionf("handleNetworkInstanceDelete for %s", key)
+	updateLocalRegistry(ctx)
+	log.Functionf("handleNetworkInstanceDelete done for %s", key)
+}
@@ -0,0 +1,674 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+// github.com/grandcat/zeroconf: under MIT License
+
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/grandcat/zeroconf"
+	"github.com/lf-edge/eve/pkg/pillar/agentlog"
+	"github.com/lf-edge/eve/pkg/pillar/cas"
+	"github.com/lf-edge/eve/pkg/pillar/containerd"
+	"github.com/lf-edge/eve/pkg/pillar/types"
+	"github.com/lf-edge/eve/pkg/pillar/utils/generics"
+	"github.com/lf-edge/eve/pkg/pillar/zedcloud"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// mDNS service the devices sharing the blobs of their CAS advertise
+	peerServiceType = "_eve-blobs._tcp"
+	// GET returns the sha256 of the blobs, GET or HEAD sha256/<hex> a blob
+	peerBlobsPath = "/blobs/"
+	casClientType = "containerd"
+	// How long the peers found and the blobs they have are trusted
+	peerBrowseInterval = time.Minute
+	// How long we wait for the peers to answer mDNS queries
+	peerBrowseTimeout = 3 * time.Second
+	// How long the peers may take to return their blobs
+	peerIndexTimeout = 10 * time.Second
+	// Bounds the blobs of unknown size taken from peers, which can only be
+	// manifests or indexes, to the size the registries accept for those
+	maxUnsizedPeerBlob = 4 << 20
+)
+
+// peer is a device sharing the blobs of its CAS
+type peer struct {
+	addr  string          // host:port
+	blobs map[string]bool // sha256 in hex
+}
+
+// peerSharing shares the blobs of the CAS with the other EVE devices on the
+// LAN of the management ports and finds the peers having the blobs we need
+type peerSharing struct {
+	sync.Mutex
+	ifnames    []string // management ports we share over, none if disabled
+	instance   string   // the name we advertise
+	server     *http.Server
+	mdnsServer *zeroconf.Server
+	client     *http.Client
+	peers      []peer
+	browseTime time.Time
+	browsing   bool // looking for the peers, without the lock
+	// browse returns the addresses of the peers on ifs, browsePeers
+	// unless set
+	browse func(ifs []net.Interface, instance string) []string
+
+	// The certificates the device certificates of the peers are checked
+	// against, loaded from PeerCertsFileName
+	rootsLock   sync.Mutex
+	roots       *x509.CertPool
+	rootsPEM    []byte
+	rootsLoaded bool
+
+	// The CAS client is created when the first peer asks for a blob, the
+	// user containerd is not necessarily running before
+	casLock   sync.Mutex
+	casClient cas.CAS
+}
+
+// peerTrustAnchors returns the certificates in data, nil if none. EVE
+// device certificates are self-signed, hence these are the device
+// certificates of the peers, or the certificates of the CA which issued
+// them if not generated on the devices. The CA certificates the controller
+// sends do not issue device certificates.
+func peerTrustAnchors(data []byte) *x509.CertPool {
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(data) {
+		return nil
+	}
+	return roots
+}
+
+// updatePeerTrustAnchors checks the device certificates of the peers
+// against the certificates in PeerCertsFileName, reloaded when it changed
+func updatePeerTrustAnchors(ctx *downloaderContext) {
+	data, err := os.ReadFile(types.PeerCertsFileName)
+	if err != nil && !os.IsNotExist(err) {
+		log.Errorf("updatePeerTrustAnchors: %v", err)
+		return
+	}
+	p := &ctx.peerSharing
+	p.rootsLock.Lock()
+	if p.rootsLoaded && bytes.Equal(p.rootsPEM, data) {
+		p.rootsLock.Unlock()
+		return
+	}
+	roots := peerTrustAnchors(data)
+	p.roots = roots
+	p.rootsPEM = data
+	p.rootsLoaded = true
+	p.rootsLock.Unlock()
+	if roots == nil {
+		log.Noticef("updatePeerTrustAnchors: no certificate in %s to trust the peers with",
+			types.PeerCertsFileName)
+	}
+	updatePeerSharing(ctx)
+}
+
+// trustAnchors returns the certificates to check the peers against
+func (p *peerSharing) trustAnchors() *x509.CertPool {
+	p.rootsLock.Lock()
+	defer p.rootsLock.Unlock()
+	return p.roots
+}
+
+// verifyPeerCertificate returns a tls.Config.VerifyPeerCertificate accepting
+// the device certificates in the current roots or issued by one of them.
+// Device certificates are not issued for a host name, only their chain is
+// checked.
+func verifyPeerCertificate(roots func() *x509.CertPool,
+	usage x509.ExtKeyUsage) func([][]byte, [][]*x509.Certificate) error {
+
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("no peer certificate")
+		}
+		pool := roots()
+		if pool == nil {
+			return errors.New("no certificate to trust the peer with")
+		}
+		var certs []*x509.Certificate
+		for _, raw := range rawCerts {
+			cert, err := x509.ParseCertificate(raw)
+			if err != nil {
+				return err
+			}
+			certs = append(certs, cert)
+		}
+		intermediates := x509.NewCertPool()
+		for _, cert := range certs[1:] {
+			intermediates.AddCert(cert)
+		}
+		_, err := certs[0].Verify(x509.VerifyOptions{
+			Roots:         pool,
+			Intermediates: intermediates,
+			KeyUsages:     []x509.ExtKeyUsage{usage},
+		})
+		return err
+	}
+}
+
+// peerServerTLSConfig returns the TLS configuration serving the peers
+// presenting a trusted device certificate with cert
+func peerServerTLSConfig(cert tls.Certificate, roots func() *x509.CertPool) *tls.Config {
+	return &tls.Config{
+		Certificates:          []tls.Certificate{cert},
+		ClientAuth:            tls.RequireAnyClientCert,
+		VerifyPeerCertificate: verifyPeerCertificate(roots, x509.ExtKeyUsageClientAuth),
+		MinVersion:            tls.VersionTLS12,
+	}
+}
+
+// peerClientTLSConfig returns the TLS configuration connecting with cert
+// to the peers presenting a trusted device certificate
+func peerClientTLSConfig(cert tls.Certificate, roots func() *x509.CertPool) *tls.Config {
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		// VerifyPeerCertificate checks the certificate instead, there
+		// is no host name to check it against
+		InsecureSkipVerify:    true,
+		VerifyPeerCertificate: verifyPeerCertificate(roots, x509.ExtKeyUsageServerAuth),
+		MinVersion:            tls.VersionTLS12,
+	}
+}
+
+// updatePeerSharing starts or stops sharing the blobs over the management
+// ports we may download over according to the global config, as long as
+// there are certificates to trust the peers with
+func updatePeerSharing(ctx *downloaderContext) {
+	var ifnames []string
+	if ctx.globalConfig.GlobalValueBool(types.DownloadFromPeers) &&
+		ctx.peerSharing.trustAnchors() != nil {
+		for _, ifname := range types.GetMgmtPortsAny(ctx.deviceNetworkStatus, 0) {
+			if types.GetPortCost(ctx.deviceNetworkStatus, ifname) <= ctx.downloadMaxPortCost {
+				ifnames = append(ifnames, ifname)
+			}
+		}
+	}
+	p := &ctx.peerSharing
+	p.Lock()
+	defer p.Unlock()
+	if generics.EqualSets(p.ifnames, ifnames) {
+		return
+	}
+	p.stop()
+	if len(ifnames) == 0 {
+		log.Noticef("updatePeerSharing: not sharing blobs")
+		return
+	}
+	if err := p.start(ifnames); err != nil {
+		log.Errorf("updatePeerSharing: %v", err)
+		p.stop()
+		return
+	}
+	log.Noticef("updatePeerSharing: sharing blobs over %v", ifnames)
+}
+
+// start serves the blobs to the peers and advertises them over ifnames
+func (p *peerSharing) start(ifnames []string) error {
+	cert, err := zedcloud.GetClientCert()
+	if err != nil {
+		return err
+	}
+	var ifs []net.Interface
+	for _, ifname := range ifnames {
+		ifs = append(ifs, net.Interface{Name: ifname, Index: ifIndex(ifname)})
+	}
+	if p.instance, err = os.Hostname(); err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(peerBlobsPath, p.serveBlobs)
+	p.server = &http.Server{
+		Handler:           mux,
+		TLSConfig:         peerServerTLSConfig(cert, p.trustAnchors),
+		ReadHeaderTimeout: peerIndexTimeout,
+	}
+	for _, ifname := range ifnames {
+		listener, err := listenOnInterface(ifname, types.PeerContentPort)
+		if err != nil {
+			return fmt.Errorf("%s: %v", ifname, err)
+		}
+		log.Functionf("Creating %s at %s", "peer server", agentlog.GetMyStack())
+		go func(server *http.Server, listener net.Listener, ifname string) {
+			err := server.ServeTLS(listener, "", "")
+			if err != nil && err != http.ErrServerClosed {
+				log.Errorf("peer server on %s: %v", ifname, err)
+			}
+		}(p.server, listener, ifname)
+	}
+
+	p.mdnsServer, err = zeroconf.Register(p.instance, peerServiceType, "local.",
+		types.PeerContentPort, nil, ifs)
+	if err != nil {
+		return err
+	}
+	p.client = &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig:       peerClientTLSConfig(cert, p.trustAnchors),
+			TLSHandshakeTimeout:   peerIndexTimeout,
+			ResponseHeaderTimeout: peerIndexTimeout,
+		},
+	}
+	p.ifnames = ifnames
+	return nil
+}
+
+// stop stops serving and advertising the blobs and forgets the peers
+func (p *peerSharing) stop() {
+	if p.mdnsServer != nil {
+		p.mdnsServer.Shutdown()
+		p.mdnsServer = nil
+	}
+	if p.server != nil {
+		p.server.Close()
+		p.server = nil
+	}
+	if p.client != nil {
+		p.client.CloseIdleConnections()
+		p.client = nil
+	}
+	p.ifnames = nil
+	p.peers = nil
+	p.browseTime = time.Time{}
+}
+
+// listenOnInterface returns a listener on port which only accepts the
+// connections coming over the interface named ifname
+func listenOnInterface(ifname string, port int) (net.Listener, error) {
+	lc := net.ListenConfig{
+		Control: func(_, _ string, c syscall.RawConn) error {
+			var bindErr error
+			err := c.Control(func(fd uintptr) {
+				bindErr = unix.BindToDevice(int(fd), ifname)
+			})
+			if err != nil {
+				return err
+			}
+			return bindErr
+		},
+	}
+	return lc.Listen(context.Background(), "tcp", fmt.Sprintf(":%d", port))
+}
+
+// ifIndex returns the index of the interface named ifname, 0 if none
+func ifIndex(ifname string) int {
+	iface, err := net.InterfaceByName(ifname)
+	if err != nil {
+		return 0
+	}
+	return iface.Index
+}
+
+// getCAS returns the CAS client, once the user containerd runs
+func (p *peerSharing) getCAS() (cas.CAS, error) {
+	p.casLock.Lock()
+	defer p.casLock.Unlock()
+	if p.casClient != nil {
+		return p.casClient, nil
+	}
+	// cas.NewCAS gives up on the whole agent if containerd is not there
+	ctrdClient, err := containerd.NewContainerdClient(true)
+	if err != nil {
+		return nil, err
+	}
+	ctrdClient.CloseClient()
+	p.casClient, err = cas.NewCAS(casClientType)
+	return p.casClient, err
+}
+
+// serveBlobs returns the sha256 of the blobs of the CAS, or one of them.
+// Only verified blobs are ingested into the CAS.
+func (p *peerSharing) serveBlobs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	casClient, err := p.getCAS()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if r.URL.Path == peerBlobsPath {
+		infos, err := casClient.ListBlobInfo()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		blobs := []string{}
+		for _, info := range infos {
+			if sha, ok := strings.CutPrefix(info.Digest, "sha256:"); ok {
+				blobs = append(blobs, sha)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(blobs)
+		return
+	}
+	sha, ok := strings.CutPrefix(r.URL.Path, peerBlobsPath+"sha256/")
+	if !ok || !validSha256(sha) {
+		http.NotFound(w, r)
+		return
+	}
+	digest := "sha256:" + strings.ToLower(sha)
+	info, err := casClient.GetBlobInfo(digest)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	ctrdCtx, done := casClient.CtrNewUserServicesCtx()
+	defer done()
+	reader, err := casClient.ReadBlob(ctrdCtx, digest)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// The media type of the blobs of the OCI images, none for the others
+	w.Header()["Content-Type"] = nil
+	if mediaTypes, err := casClient.ListBlobsMediaTypes(); err == nil &&
+		mediaTypes[digest] != "" {
+		w.Header().Set("Content-Type", mediaTypes[digest])
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+	if r.Method == http.MethodHead {
+		return
+	}
+	if _, err := io.Copy(w, reader); err != nil {
+		log.Warnf("serveBlobs(%s) to %s: %v", digest, r.RemoteAddr, err)
+		return
+	}
+	log.Functionf("serveBlobs(%s) to %s: %d bytes", digest, r.RemoteAddr, info.Size)
+}
+
+// validSha256 returns true if sha is the hex of a sha256
+func validSha256(sha string) bool {
+	raw, err := hex.DecodeString(sha)
+	return err == nil && len(raw) == sha256.Size
+}
+
+// peersWithBlob returns the addresses of the peers having the blob with sha,
+// looking for the peers and their blobs again once in a while. The lookup
+// is done without the lock, the other callers meanwhile get the peers found
+// before.
+func (p *peerSharing) peersWithBlob(sha string) ([]string, *http.Client) {
+	p.Lock()
+	client := p.client
+	ifnames := p.ifnames
+	instance := p.instance
+	lookup := client != nil && !p.browsing &&
+		time.Since(p.browseTime) > peerBrowseInterval
+	if lookup {
+		p.browsing = true
+	}
+	p.Unlock()
+	if client == nil {
+		return nil, nil
+	}
+	if lookup {
+		peers := p.findPeers(client, ifnames, instance)
+		p.Lock()
+		p.browsing = false
+		// Unless sharing was restarted meanwhile
+		if p.client == client {
+			p.peers = peers
+			p.browseTime = time.Now()
+		}
+		p.Unlock()
+		log.Functionf("peersWithBlob: %d peers", len(peers))
+	}
+	p.Lock()
+	defer p.Unlock()
+	var addrs []string
+	for _, peer := range p.peers {
+		if peer.blobs[sha] {
+			addrs = append(addrs, peer.addr)
+		}
+	}
+	return addrs, client
+}
+
+// findPeers returns the peers advertising their blobs over ifnames and
+// the blobs they have
+func (p *peerSharing) findPeers(client *http.Client, ifnames []string,
+	instance string) []peer {
+
+	var ifs []net.Interface
+	for _, ifname := range ifnames {
+		ifs = append(ifs, net.Interface{Name: ifname, Index: ifIndex(ifname)})
+	}
+	browse := p.browse
+	if browse == nil {
+		browse = browsePeers
+	}
+	var peers []peer
+	for _, addr := range browse(ifs, instance) {
+		blobs, err := fetchPeerIndex(client, addr)
+		if err != nil {
+			log.Warnf("findPeers: %v", err)
+			continue
+		}
+		peers = append(peers, peer{addr: addr, blobs: blobs})
+	}
+	return peers
+}
+
+// browsePeers returns the addresses of the other devices advertising their
+// blobs on ifs
+func browsePeers(ifs []net.Interface, instance string) []string {
+	resolver, err := zeroconf.NewResolver(zeroconf.SelectIfaces(ifs),
+		zeroconf.SelectIPTraffic(zeroconf.IPv4))
+	if err != nil {
+		log.Errorf("browsePeers: Failed to initialize resolver: %v", err)
+		return nil
+	}
+	mctx, cancel := context.WithTimeout(context.Background(), peerBrowseTimeout)
+	defer cancel()
+
+	var addrs []string
+	entries := make(chan *zeroconf.ServiceEntry)
+	done := make(chan struct{})
+	go func() {
+		for entry := range entries {
+			if entry.Instance == instance || len(entry.AddrIPv4) == 0 {
+				continue
+			}
+			addrs = generics.AppendIfNotDuplicate(addrs, net.JoinHostPort(
+				entry.AddrIPv4[0].String(), strconv.Itoa(entry.Port)))
+		}
+		close(done)
+	}()
+	if err := resolver.Browse(mctx, peerServiceType, "local.", entries); err != nil {
+		log.Errorf("browsePeers: resolver error %v", err)
+		return nil
+	}
+	<-done
+	return addrs
+}
+
+// fetchPeerIndex returns the sha256 of the blobs the peer at addr has
+func fetchPeerIndex(client *http.Client, addr string) (map[string]bool, error) {
+	rctx, cancel := context.WithTimeout(context.Background(), peerIndexTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(rctx, http.MethodGet,
+		"https://"+addr+peerBlobsPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", addr, resp.Status)
+	}
+	var list []string
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("%s: %v", addr, err)
+	}
+	blobs := make(map[string]bool)
+	for _, sha := range list {
+		blobs[strings.ToLower(sha)] = true
+	}
+	return blobs, nil
+}
+
+// fetchPeerBlob downloads the blob with sha of at most maxSize bytes from
+// the peer at addr into fileName and returns its size and media type. The
+// content is checked against sha here to try another peer on a mismatch,
+// the verifier checks it again.
+func fetchPeerBlob(client *http.Client, addr, sha, fileName string,
+	maxSize int64, st *PublishStatus) (int64, string, error) {
+
+	rctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// Gives up once no data comes for maxStalledTime
+	stalled := time.AfterFunc(maxStalledTime, cancel)
+	defer stalled.Stop()
+	req, err := http.NewRequestWithContext(rctx, http.MethodGet,
+		"https://"+addr+peerBlobsPath+"sha256/"+sha, nil)
+	if err != nil {
+		return 0, "", err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, "", fmt.Errorf("%s: %s", addr, resp.Status)
+	}
+	if resp.ContentLength > maxSize {
+		return 0, "", fmt.Errorf("%s: %d bytes exceed %d", addr,
+			resp.ContentLength, maxSize)
+	}
+	if err := os.MkdirAll(filepath.Dir(fileName), 0755); err != nil {
+		return 0, "", err
+	}
+	file, err := os.Create(fileName)
+	if err != nil {
+		return 0, "", err
+	}
+	size, err := copyPeerBlob(file, resp.Body, resp.ContentLength, maxSize,
+		sha, stalled, st)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(fileName)
+		return 0, "", fmt.Errorf("%s: %v", addr, err)
+	}
+	return size, resp.Header.Get("Content-Type"), nil
+}
+
+// copyPeerBlob copies the blob of total bytes with sha from reader to
+// writer, reporting the progress and resetting the stalled timer as data
+// comes. It fails as soon as more than maxSize bytes come.
+func copyPeerBlob(writer io.Writer, reader io.Reader, total, maxSize int64,
+	sha string, stalled *time.Timer, st *PublishStatus) (int64, error) {
+
+	// Reading one byte past maxSize tells it was exceeded
+	reader = io.LimitReader(reader, maxSize+1)
+	hash := sha256.New()
+	buf := make([]byte, 256*1024)
+	var size int64
+	var progress uint
+	for {
+		n, err := reader.Read(buf)
+		if n > 0 {
+			stalled.Reset(maxStalledTime)
+			size += int64(n)
+			if size > maxSize {
+				return size, fmt.Errorf("more than %d bytes", maxSize)
+			}
+			if _, err := writer.Write(buf[:n]); err != nil {
+				return size, err
+			}
+			hash.Write(buf[:n])
+			if total > 0 && st != nil {
+				if p := uint(size * 100 / total); p != progress {
+					progress = p
+					st.Progress(progress, size, total)
+				}
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return size, err
+		}
+	}
+	if got := hex.EncodeToString(hash.Sum(nil)); got != sha {
+		return size, fmt.Errorf("got sha256 %s", got)
+	}
+	return size, nil
+}
+
+// downloadFromPeers downloads the blob of config into its target from one
+// of the peers which have it and returns true if it did. Manifests and
+// indexes are left to the datastore when the device checks the image
+// signatures, which only the datastore has.
+func downloadFromPeers(ctx *downloaderContext, config types.DownloaderConfig,
+	status *types.DownloaderStatus) bool {
+
+	sha := strings.ToLower(config.ImageSha256)
+	if !validSha256(sha) {
+		return false
+	}
+	maxSize := int64(config.Size)
+	if maxSize == 0 {
+		maxSize = maxUnsizedPeerBlob
+	}
+	addrs, client := ctx.peerSharing.peersWithBlob(sha)
+	for _, addr := range addrs {
+		log.Functionf("downloadFromPeers(%s) from %s to %s", sha, addr, config.Target)
+		st := &PublishStatus{
+			ctx:    ctx,
+			status: status,
+		}
+		start := time.Now()
+		size, contentType, err := fetchPeerBlob(client, addr, sha, config.Target,
+			maxSize, st)
+		if err != nil {
+			log.Warnf("downloadFromPeers(%s) failed: %v", sha, err)
+			continue
+		}
+		if isImageManifest(contentType) && wantImageSignatures() {
+			// The datastore download fetches the signatures with it
+			log.Functionf("downloadFromPeers(%s): %s from the datastore for its signatures",
+				sha, contentType)
+			os.Remove(config.Target)
+			return false
+		}
+		log.Noticef("downloadFromPeers(%s) from %s: %d bytes in %v", sha, addr,
+			size, time.Since(start))
+		status.Size = uint64(size)
+		status.ContentType = contentType
+		st.Progress(100, size, size)
+		return true
+	}
+	return false
+}
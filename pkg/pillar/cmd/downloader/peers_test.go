@@ -0,0 +1,202 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package downloader
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lf-edge/eve/pkg/pillar/base"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestCert returns a certificate of name, issued by parent signed with
+// parentKey, or self-signed if parent is nil, and its raw form
+func newTestCert(t *testing.T, name string, isCA bool, parent *x509.Certificate,
+	parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, []byte) {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	raw, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	assert.NoError(t, err)
+	cert, err := x509.ParseCertificate(raw)
+	assert.NoError(t, err)
+	return cert, key, raw
+}
+
+func TestVerifyPeerCertificate(t *testing.T) {
+	ca, caKey, _ := newTestCert(t, "enterprise", true, nil, nil)
+	_, _, device := newTestCert(t, "device", false, ca, caKey)
+	_, _, other := newTestCert(t, "other", false, nil, nil)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	verify := verifyPeerCertificate(func() *x509.CertPool { return roots },
+		x509.ExtKeyUsageClientAuth)
+	assert.NoError(t, verify([][]byte{device}, nil))
+	assert.Error(t, verify([][]byte{other}, nil))
+	assert.Error(t, verify(nil, nil))
+
+	// Nothing is trusted without trust anchors
+	verify = verifyPeerCertificate(func() *x509.CertPool { return nil },
+		x509.ExtKeyUsageClientAuth)
+	assert.Error(t, verify([][]byte{device}, nil))
+}
+
+// newTestPeer returns a server sharing blobs like a peer and its address
+func newTestPeer(blobs map[string][]byte) (*httptest.Server, string) {
+	server := httptest.NewTLSServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == peerBlobsPath {
+				list := []string{}
+				for sha := range blobs {
+					list = append(list, sha)
+				}
+				json.NewEncoder(w).Encode(list)
+				return
+			}
+			sha := strings.TrimPrefix(r.URL.Path, peerBlobsPath+"sha256/")
+			content, ok := blobs[sha]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write(content)
+		}))
+	return server, server.Listener.Addr().String()
+}
+
+func testSha256(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestFetchPeerBlob(t *testing.T) {
+	log = base.NewSourceLogObject(logrus.StandardLogger(), "downloader", 0)
+	content := []byte("blob content")
+	sha := testSha256(content)
+	wrongSha := testSha256([]byte("other content"))
+	server, addr := newTestPeer(map[string][]byte{
+		sha:      content,
+		wrongSha: content,
+	})
+	defer server.Close()
+
+	blobs, err := fetchPeerIndex(server.Client(), addr)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{sha: true, wrongSha: true}, blobs)
+
+	fileName := filepath.Join(t.TempDir(), "blob")
+	size, contentType, err := fetchPeerBlob(server.Client(), addr, sha, fileName, 1024, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(content)), size)
+	assert.Equal(t, "application/octet-stream", contentType)
+	data, err := os.ReadFile(fileName)
+	assert.NoError(t, err)
+	assert.Equal(t, content, data)
+
+	// A blob whose content does not match is dropped
+	fileName = filepath.Join(t.TempDir(), "wrong")
+	_, _, err = fetchPeerBlob(server.Client(), addr, wrongSha, fileName, 1024, nil)
+	assert.Error(t, err)
+	assert.NoFileExists(t, fileName)
+
+	_, _, err = fetchPeerBlob(server.Client(), addr, testSha256(nil), fileName, 1024, nil)
+	assert.Error(t, err)
+
+	// A blob larger than expected is dropped
+	fileName = filepath.Join(t.TempDir(), "large")
+	_, _, err = fetchPeerBlob(server.Client(), addr, sha, fileName,
+		int64(len(content)-1), nil)
+	assert.Error(t, err)
+	assert.NoFileExists(t, fileName)
+}
+
+func TestCopyPeerBlobMaxSize(t *testing.T) {
+	content := []byte("blob content")
+	sha := testSha256(content)
+	stalled := time.NewTimer(time.Hour)
+	defer stalled.Stop()
+
+	// Without a content length the copy stops past the maximum size
+	var buf bytes.Buffer
+	_, err := copyPeerBlob(&buf, bytes.NewReader(content), -1,
+		int64(len(content)-1), sha, stalled, nil)
+	assert.Error(t, err)
+	assert.Empty(t, buf.Bytes())
+
+	buf.Reset()
+	size, err := copyPeerBlob(&buf, bytes.NewReader(content), -1,
+		int64(len(content)), sha, stalled, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(content)), size)
+	assert.Equal(t, content, buf.Bytes())
+}
+
+func TestPeersWithBlob(t *testing.T) {
+	log = base.NewSourceLogObject(logrus.StandardLogger(), "downloader", 0)
+	content := []byte("blob content")
+	sha := testSha256(content)
+	server, addr := newTestPeer(map[string][]byte{sha: content})
+	defer server.Close()
+
+	var p peerSharing
+	addrs, client := p.peersWithBlob(sha)
+	assert.Empty(t, addrs)
+	assert.Nil(t, client, "not sharing")
+
+	var browsed atomic.Int32
+	p.client = server.Client()
+	p.instance = "me"
+	p.browse = func(ifs []net.Interface, instance string) []string {
+		browsed.Add(1)
+		assert.Equal(t, "me", instance)
+		// The lookup is done without the lock
+		assert.True(t, p.TryLock())
+		p.Unlock()
+		return []string{addr, "127.0.0.1:1"}
+	}
+	addrs, client = p.peersWithBlob(sha)
+	assert.Equal(t, []string{addr}, addrs)
+	assert.Equal(t, server.Client(), client)
+	addrs, _ = p.peersWithBlob(testSha256(nil))
+	assert.Empty(t, addrs)
+	assert.Equal(t, int32(1), browsed.Load(), "peers found again too soon")
+
+	p.browseTime = time.Now().Add(-peerBrowseInterval - time.Second)
+	p.peersWithBlob(sha)
+	assert.Equal(t, int32(2), browsed.Load())
+}
@@ -0,0 +1,828 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	zconfig "github.com/lf-edge/eve-api/go/config"
+	"github.com/lf-edge/eve/pkg/pillar/types"
+	fileutils "github.com/lf-edge/eve/pkg/pillar/utils/file"
+	uuid "github.com/satori/go.uuid"
+	"github.com/shirou/gopsutil/disk"
+)
+
+const (
+	// The read-only distribution API the apps pull from
+	registryAPIPath = "/v2/"
+	// What the registry fetched from the datastores for the apps, by
+	// repository and sha256
+	registryBlobsDirname = downloaderBasePath + "/registry/blobs"
+	// The digests of the tags last resolved, for when we are offline
+	registryTagsFilename = downloaderBasePath + "/registry/tags.json"
+	// Prefix of the blobs being fetched in registryBlobsDirname
+	registryFetchPrefix = ".fetch-"
+	// How long a request may take to fetch a blob from the datastores
+	registryFetchTimeout = 30 * time.Minute
+	// Manifests larger than this are not served
+	maxManifestSize = 4 << 20
+	// How many blobs are fetched from the datastores at the same time
+	maxRegistryFetches = 4
+)
+
+// localRegistry serves a read-only OCI registry to the apps on the bridges
+// of the local network instances, limited to the repositories of the
+// content trees of the apps on each. The manifests and blobs come from the
+// CAS by digest, or else from a cache on disk filled from the OCI datastores
+// of those content trees.
+type localRegistry struct {
+	sync.Mutex
+	servers map[string]*http.Server // by listen address
+
+	// The digests of the manifests by <repository>:<tag>
+	tagsLock   sync.Mutex
+	tags       map[string]string
+	tagsLoaded bool
+
+	// Held while the cache is trimmed
+	cacheLock sync.Mutex
+
+	// The fetches in progress by cache file name, the bytes they may still
+	// write to the cache, and a slot taken by each
+	fetchLock  sync.Mutex
+	fetches    map[string]*registryFetch
+	reserved   int64
+	fetchSlots chan struct{}
+}
+
+// registryFetch is a fetch in progress, which the requests for the same
+// manifest or blob wait for
+type registryFetch struct {
+	done chan struct{}
+	err  error
+}
+
+// registryRepo is what the apps on a network instance may pull from a
+// repository: the blobs of their content trees from it, and else what the
+// datastores of those content trees have
+type registryRepo struct {
+	datastores []uuid.UUID
+	blobs      map[string]bool // by sha256
+}
+
+// registryError is the error of a request to the registry, with the code of
+// the distribution API
+type registryError struct {
+	status  int
+	code    string
+	message string
+}
+
+func (e *registryError) Error() string {
+	return e.message
+}
+
+// updateLocalRegistry serves the registry on the bridges of the local
+// network instances, or stops serving it, according to the global config
+func updateLocalRegistry(ctx *downloaderContext) {
+	addrs := make(map[string]uuid.UUID)
+	if ctx.globalConfig.GlobalValueBool(types.LocalRegistryEnable) {
+		for _, item := range ctx.subNetworkInstanceStatus.GetAll() {
+			status := item.(types.NetworkInstanceStatus)
+			if status.Type != types.NetworkInstanceTypeLocal ||
+				!status.Activated || status.BridgeIPAddr == nil {
+				continue
+			}
+			addr := net.JoinHostPort(status.BridgeIPAddr.String(),
+				strconv.Itoa(types.LocalRegistryPort))
+			addrs[addr] = status.UUID
+		}
+	}
+	r := &ctx.localRegistry
+	// The quota may have been lowered
+	r.trimCache(registryCacheQuota(ctx) - r.reservedBytes())
+	r.Lock()
+	defer r.Unlock()
+	for addr, server := range r.servers {
+		if _, ok := addrs[addr]; !ok {
+			log.Noticef("updateLocalRegistry: stop serving on %s", addr)
+			server.Close()
+			delete(r.servers, addr)
+		}
+	}
+	for addr, network := range addrs {
+		if r.servers[addr] != nil {
+			continue
+		}
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			// The bridge may not have its address yet
+			log.Warnf("updateLocalRegistry: %v", err)
+			continue
+		}
+		server := &http.Server{
+			Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				serveRegistry(ctx, network, w, req)
+			}),
+			ReadHeaderTimeout: 30 * time.Second,
+		}
+		go func() {
+			err := server.Serve(listener)
+			if err != nil && err != http.ErrServerClosed {
+				log.Errorf("local registry on %s: %v", addr, err)
+			}
+		}()
+		if r.servers == nil {
+			r.servers = make(map[string]*http.Server)
+		}
+		r.servers[addr] = server
+		log.Noticef("updateLocalRegistry: serving on %s", addr)
+	}
+}
+
+// parseRegistryPath returns the repository, "manifests" or "blobs", and the
+// tag or digest of a path of the distribution API
+func parseRegistryPath(path string) (string, string, string, bool) {
+	rest, found := strings.CutPrefix(path, registryAPIPath)
+	if !found {
+		return "", "", "", false
+	}
+	for _, kind := range []string{"manifests", "blobs"} {
+		i := strings.LastIndex(rest, "/"+kind+"/")
+		if i <= 0 {
+			continue
+		}
+		repo, ref := rest[:i], rest[i+len(kind)+2:]
+		if ref == "" || strings.Contains(ref, "/") {
+			return "", "", "", false
+		}
+		return repo, kind, ref, true
+	}
+	return "", "", "", false
+}
+
+// registryTagKey returns the key of the tag of ref in the recorded tags,
+// latest if it has none
+func registryTagKey(ref string) string {
+	if strings.LastIndex(ref, ":") <= strings.LastIndex(ref, "/") {
+		ref += ":latest"
+	}
+	return ref
+}
+
+// registryRepository returns the repository of the name of an image in an
+// OCI datastore, without its tag or digest
+func registryRepository(relativeURL string) string {
+	repo, _, _ := strings.Cut(relativeURL, "@")
+	if i := strings.LastIndex(repo, ":"); i > strings.LastIndex(repo, "/") {
+		repo = repo[:i]
+	}
+	return repo
+}
+
+// findRegistryRepo returns what the apps of appConfigs with an adapter on
+// network may pull from repo, given the volumeConfigs and the
+// contentStatuses, or nil if none of their content trees is from repo
+func findRegistryRepo(network uuid.UUID, repo string, appConfigs,
+	volumeConfigs, contentStatuses map[string]interface{}) *registryRepo {
+
+	volumes := make(map[uuid.UUID]bool)
+	for _, item := range appConfigs {
+		config := item.(types.AppInstanceConfig)
+		onNetwork := false
+		for _, adapter := range config.AppNetAdapterList {
+			if adapter.Network == network {
+				onNetwork = true
+				break
+			}
+		}
+		if !onNetwork {
+			continue
+		}
+		for _, volumeRef := range config.VolumeRefConfigList {
+			volumes[volumeRef.VolumeID] = true
+		}
+	}
+	contents := make(map[uuid.UUID]bool)
+	for _, item := range volumeConfigs {
+		config := item.(types.VolumeConfig)
+		if volumes[config.VolumeID] {
+			contents[config.ContentID] = true
+		}
+	}
+	var found *registryRepo
+	datastores := make(map[uuid.UUID]bool)
+	for _, item := range contentStatuses {
+		status := item.(types.ContentTreeStatus)
+		if !contents[status.ContentID] || !status.IsOCIRegistry ||
+			registryRepository(status.RelativeURL) != repo {
+			continue
+		}
+		if found == nil {
+			found = &registryRepo{blobs: make(map[string]bool)}
+		}
+		for _, sha := range status.Blobs {
+			found.blobs[strings.ToLower(sha)] = true
+		}
+		for _, dsID := range status.DatastoreIDList {
+			if !datastores[dsID] {
+				datastores[dsID] = true
+				found.datastores = append(found.datastores, dsID)
+			}
+		}
+	}
+	if found != nil {
+		sort.Slice(found.datastores, func(i, j int) bool {
+			return found.datastores[i].String() < found.datastores[j].String()
+		})
+	}
+	return found
+}
+
+// lookupRegistryRepo returns what the apps on network may pull from repo,
+// or nil if they may not
+func lookupRegistryRepo(ctx *downloaderContext, network uuid.UUID,
+	repo string) *registryRepo {
+
+	return findRegistryRepo(network, repo, ctx.subAppInstanceConfig.GetAll(),
+		ctx.subVolumeConfig.GetAll(), ctx.subContentTreeStatus.GetAll())
+}
+
+// registryCacheName returns the name of the file of the cache with the
+// manifest or the blob sha fetched from repo. The cache is by repository
+// for a blob not to be served from a repository the datastore would not
+// serve it from.
+func registryCacheName(repo, sha string) string {
+	hash := sha256.Sum256([]byte(repo))
+	return filepath.Join(registryBlobsDirname,
+		hex.EncodeToString(hash[:8])+"-"+sha)
+}
+
+// manifestMediaType returns the media type of the manifest in data
+func manifestMediaType(data []byte) string {
+	var manifest struct {
+		MediaType string          `json:"mediaType"`
+		Manifests json.RawMessage `json:"manifests"`
+	}
+	if err := json.Unmarshal(data, &manifest); err == nil && manifest.MediaType != "" {
+		return manifest.MediaType
+	}
+	if manifest.Manifests != nil {
+		return "application/vnd.oci.image.index.v1+json"
+	}
+	return "application/vnd.oci.image.manifest.v1+json"
+}
+
+// writeRegistryError answers a request with err in the format of the
+// distribution API
+func writeRegistryError(w http.ResponseWriter, err error) {
+	var rerr *registryError
+	if !errors.As(err, &rerr) {
+		rerr = &registryError{
+			status:  http.StatusServiceUnavailable,
+			code:    "UNAVAILABLE",
+			message: err.Error(),
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(rerr.status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"errors": []map[string]string{{
+			"code":    rerr.code,
+			"message": rerr.message,
+		}},
+	})
+}
+
+// serveRegistry answers the GET and HEAD requests of the distribution API
+// for the manifests and the blobs from the apps on network
+func serveRegistry(ctx *downloaderContext, network uuid.UUID,
+	w http.ResponseWriter, req *http.Request) {
+
+	w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		writeRegistryError(w, &registryError{
+			status:  http.StatusMethodNotAllowed,
+			code:    "UNSUPPORTED",
+			message: "the registry is read-only",
+		})
+		return
+	}
+	if req.URL.Path == registryAPIPath {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, "{}")
+		return
+	}
+	repo, kind, ref, ok := parseRegistryPath(req.URL.Path)
+	if !ok {
+		writeRegistryError(w, &registryError{
+			status:  http.StatusNotFound,
+			code:    "NAME_UNKNOWN",
+			message: "unknown path " + req.URL.Path,
+		})
+		return
+	}
+	access := lookupRegistryRepo(ctx, network, repo)
+	if access == nil {
+		log.Functionf("serveRegistry: %s is not used by the apps on %s",
+			repo, network)
+		writeRegistryError(w, &registryError{
+			status:  http.StatusNotFound,
+			code:    "NAME_UNKNOWN",
+			message: "unknown repository " + repo,
+		})
+		return
+	}
+	reqCtx, cancel := context.WithTimeout(req.Context(), registryFetchTimeout)
+	defer cancel()
+	r := &ctx.localRegistry
+	manifest := kind == "manifests"
+	digest := ref
+	if manifest && !strings.Contains(ref, ":") {
+		var err error
+		digest, err = r.resolveTag(reqCtx, ctx, access, repo, ref)
+		if err != nil {
+			writeRegistryError(w, &registryError{
+				status:  http.StatusNotFound,
+				code:    "MANIFEST_UNKNOWN",
+				message: err.Error(),
+			})
+			return
+		}
+	}
+	sha, found := strings.CutPrefix(digest, "sha256:")
+	if !found || !validSha256(sha) {
+		writeRegistryError(w, &registryError{
+			status:  http.StatusBadRequest,
+			code:    "DIGEST_INVALID",
+			message: "unsupported digest " + digest,
+		})
+		return
+	}
+	digest = "sha256:" + strings.ToLower(sha)
+	reader, size, done, err := r.open(reqCtx, ctx, access, repo, digest, manifest)
+	if err != nil {
+		writeRegistryError(w, err)
+		return
+	}
+	defer done()
+
+	w.Header().Set("Docker-Content-Digest", digest)
+	if manifest {
+		data, err := io.ReadAll(io.LimitReader(reader, maxManifestSize))
+		if err != nil {
+			writeRegistryError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", manifestMediaType(data))
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		if req.Method == http.MethodGet {
+			w.Write(data)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	if req.Method == http.MethodHead {
+		return
+	}
+	if _, err := io.Copy(w, reader); err != nil {
+		log.Warnf("serveRegistry(%s) to %s: %v", digest, req.RemoteAddr, err)
+		return
+	}
+	log.Functionf("serveRegistry(%s) to %s: %d bytes", digest, req.RemoteAddr, size)
+}
+
+// open returns a reader of the manifest or the blob with digest in repo,
+// its size and a function to call when done reading. It looks in the CAS
+// for the blobs of the content trees of access, then in the cache and else
+// fetches it from the datastores of access into the cache.
+func (r *localRegistry) open(reqCtx context.Context, ctx *downloaderContext,
+	access *registryRepo, repo, digest string,
+	manifest bool) (io.Reader, int64, func(), error) {
+
+	sha := strings.TrimPrefix(digest, "sha256:")
+	// The CAS has the images of the other apps too
+	if casClient, err := ctx.peerSharing.getCAS(); err == nil && access.blobs[sha] {
+		if info, err := casClient.GetBlobInfo(digest); err == nil {
+			ctrdCtx, done := casClient.CtrNewUserServicesCtx()
+			reader, err := casClient.ReadBlob(ctrdCtx, digest)
+			if err == nil {
+				return reader, info.Size, done, nil
+			}
+			done()
+			log.Warnf("localRegistry: %s in the CAS: %v", digest, err)
+		}
+	}
+	fileName := registryCacheName(repo, sha)
+	file, err := os.Open(fileName)
+	if err != nil {
+		if err := r.fetch(reqCtx, ctx, access, repo, digest, manifest); err != nil {
+			return nil, 0, nil, err
+		}
+		if file, err = os.Open(fileName); err != nil {
+			return nil, 0, nil, err
+		}
+	}
+	// The cache keeps what was used last
+	now := time.Now()
+	os.Chtimes(fileName, now, now)
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, nil, err
+	}
+	r.trimCache(registryCacheQuota(ctx) - r.reservedBytes())
+	return file, info.Size(), func() { file.Close() }, nil
+}
+
+// registryCacheQuota returns how many bytes the cache may hold
+func registryCacheQuota(ctx *downloaderContext) int64 {
+	return int64(ctx.globalConfig.GlobalValueInt(types.LocalRegistryCacheMiB)) << 20
+}
+
+// fetch saves the manifest or the blob with digest in repo from the
+// datastores of access in the cache. The requests for what is already being
+// fetched wait for that fetch, and the others for one of the
+// maxRegistryFetches slots.
+func (r *localRegistry) fetch(reqCtx context.Context, ctx *downloaderContext,
+	access *registryRepo, repo, digest string, manifest bool) error {
+
+	fileName := registryCacheName(repo, strings.TrimPrefix(digest, "sha256:"))
+	r.fetchLock.Lock()
+	if r.fetchSlots == nil {
+		r.fetchSlots = make(chan struct{}, maxRegistryFetches)
+		r.fetches = make(map[string]*registryFetch)
+	}
+	if inProgress := r.fetches[fileName]; inProgress != nil {
+		r.fetchLock.Unlock()
+		select {
+		case <-inProgress.done:
+			return inProgress.err
+		case <-reqCtx.Done():
+			return reqCtx.Err()
+		}
+	}
+	current := &registryFetch{done: make(chan struct{})}
+	r.fetches[fileName] = current
+	r.fetchLock.Unlock()
+	defer func() {
+		r.fetchLock.Lock()
+		delete(r.fetches, fileName)
+		r.fetchLock.Unlock()
+		close(current.done)
+	}()
+
+	select {
+	case r.fetchSlots <- struct{}{}:
+		defer func() { <-r.fetchSlots }()
+	case <-reqCtx.Done():
+		current.err = reqCtx.Err()
+		return current.err
+	}
+	current.err = r.fetchToCache(reqCtx, ctx, access, repo, digest,
+		fileName, manifest)
+	return current.err
+}
+
+// fetchToCache saves the manifest or the blob with digest in repo as
+// fileName from the first of the datastores of access which has it, if it
+// fits in the cache and in the free space
+func (r *localRegistry) fetchToCache(reqCtx context.Context,
+	ctx *downloaderContext, access *registryRepo, repo, digest, fileName string,
+	manifest bool) error {
+
+	if err := os.MkdirAll(registryBlobsDirname, 0700); err != nil {
+		return err
+	}
+	var noRoom error
+	err := fetchFromDatastores(reqCtx, ctx, access.datastores, repo+"@"+digest,
+		func(ref name.Reference, opts []remote.Option) error {
+			var reader io.ReadCloser
+			var size int64
+			if manifest {
+				desc, err := remote.Get(ref, opts...)
+				if err != nil {
+					return err
+				}
+				reader = io.NopCloser(bytes.NewReader(desc.Manifest))
+				size = int64(len(desc.Manifest))
+			} else {
+				digestRef, ok := ref.(name.Digest)
+				if !ok {
+					return fmt.Errorf("%s is not a digest", ref)
+				}
+				layer, err := remote.Layer(digestRef, opts...)
+				if err != nil {
+					return err
+				}
+				if size, err = layer.Size(); err != nil {
+					return err
+				}
+				if reader, err = layer.Compressed(); err != nil {
+					return err
+				}
+			}
+			defer reader.Close()
+			release, err := r.reserve(ctx, size)
+			if err != nil {
+				noRoom = err
+				return err
+			}
+			defer release()
+			return saveRegistryBlob(reader, fileName, digest, size)
+		})
+	if noRoom != nil {
+		return &registryError{
+			status:  http.StatusServiceUnavailable,
+			code:    "UNAVAILABLE",
+			message: noRoom.Error(),
+		}
+	}
+	if err != nil {
+		code := "BLOB_UNKNOWN"
+		if manifest {
+			code = "MANIFEST_UNKNOWN"
+		}
+		return &registryError{
+			status:  http.StatusNotFound,
+			code:    code,
+			message: err.Error(),
+		}
+	}
+	log.Noticef("localRegistry: fetched %s@%s", repo, digest)
+	return nil
+}
+
+// reserve makes room in the cache for size bytes, the other fetches in
+// progress having theirs, and returns the function to call once they are
+// written, or an error if they fit neither in the quota nor in the free space
+func (r *localRegistry) reserve(ctx *downloaderContext,
+	size int64) (func(), error) {
+
+	quota := registryCacheQuota(ctx)
+	r.fetchLock.Lock()
+	defer r.fetchLock.Unlock()
+	if err := registryBlobFits(size, quota-r.reserved, size); err != nil {
+		return nil, err
+	}
+	// What is evicted makes room on the disk too
+	r.trimCache(quota - r.reserved - size)
+	usage, err := disk.Usage(types.PersistDir)
+	if err != nil {
+		return nil, err
+	}
+	if err := registryBlobFits(size, quota-r.reserved,
+		int64(usage.Free)-r.reserved); err != nil {
+		return nil, err
+	}
+	r.reserved += size
+	return func() {
+		r.fetchLock.Lock()
+		r.reserved -= size
+		r.fetchLock.Unlock()
+	}, nil
+}
+
+// reservedBytes returns the bytes the fetches in progress may still write
+func (r *localRegistry) reservedBytes() int64 {
+	r.fetchLock.Lock()
+	defer r.fetchLock.Unlock()
+	return r.reserved
+}
+
+// registryBlobFits returns an error unless a blob of size bytes fits in the
+// quota and in the free space
+func registryBlobFits(size, quota, free int64) error {
+	if size > quota {
+		return fmt.Errorf("%d bytes do not fit in the registry cache of %d bytes",
+			size, quota)
+	}
+	if size > free {
+		return fmt.Errorf("%d bytes do not fit in the %d bytes free",
+			size, free)
+	}
+	return nil
+}
+
+// saveRegistryBlob saves what reader returns as fileName in the cache if
+// it is size bytes with digest
+func saveRegistryBlob(reader io.Reader, fileName, digest string, size int64) error {
+	file, err := os.CreateTemp(registryBlobsDirname, registryFetchPrefix)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	hash := sha256.New()
+	written, err := io.Copy(io.MultiWriter(file, hash),
+		io.LimitReader(reader, size+1))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if written != size {
+		return fmt.Errorf("got %d bytes instead of %d for %s", written, size, digest)
+	}
+	sha := strings.TrimPrefix(digest, "sha256:")
+	if got := hex.EncodeToString(hash.Sum(nil)); got != sha {
+		return fmt.Errorf("got sha256:%s instead of %s", got, digest)
+	}
+	return os.Rename(file.Name(), fileName)
+}
+
+// trimCache removes the blobs of the cache used least recently until it
+// holds at most quota bytes, and the fetches which did not complete
+func (r *localRegistry) trimCache(quota int64) {
+	r.cacheLock.Lock()
+	defer r.cacheLock.Unlock()
+	entries, err := os.ReadDir(registryBlobsDirname)
+	if err != nil {
+		return
+	}
+	var blobs []os.FileInfo
+	var total int64
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || info.IsDir() {
+			continue
+		}
+		if strings.HasPrefix(info.Name(), registryFetchPrefix) {
+			if time.Since(info.ModTime()) > registryFetchTimeout {
+				os.Remove(filepath.Join(registryBlobsDirname, info.Name()))
+			}
+			continue
+		}
+		blobs = append(blobs, info)
+		total += info.Size()
+	}
+	sort.Slice(blobs, func(i, j int) bool {
+		return blobs[i].ModTime().Before(blobs[j].ModTime())
+	})
+	for _, info := range blobs {
+		if total <= quota {
+			break
+		}
+		// Open files remain readable
+		if err := os.Remove(filepath.Join(registryBlobsDirname, info.Name())); err != nil {
+			log.Warnf("localRegistry: trimCache: %v", err)
+			continue
+		}
+		total -= info.Size()
+	}
+}
+
+// resolveTag returns the digest of the manifest with tag in repo, from the
+// datastores of access or else as last resolved
+func (r *localRegistry) resolveTag(reqCtx context.Context, ctx *downloaderContext,
+	access *registryRepo, repo, tag string) (string, error) {
+
+	var digest string
+	err := fetchFromDatastores(reqCtx, ctx, access.datastores, repo+":"+tag,
+		func(ref name.Reference, opts []remote.Option) error {
+			desc, err := remote.Head(ref, opts...)
+			if err != nil {
+				return err
+			}
+			digest = desc.Digest.String()
+			return nil
+		})
+	if err == nil {
+		r.recordTag(repo+":"+tag, digest)
+		return digest, nil
+	}
+	if recorded := r.lookupTag(repo + ":" + tag); recorded != "" {
+		log.Functionf("localRegistry: %s:%s is %s as last resolved: %v",
+			repo, tag, recorded, err)
+		return recorded, nil
+	}
+	return "", err
+}
+
+// loadTags reads the recorded tags once, with tagsLock held
+func (r *localRegistry) loadTags() {
+	if r.tagsLoaded {
+		return
+	}
+	r.tags = make(map[string]string)
+	data, err := os.ReadFile(registryTagsFilename)
+	if err == nil {
+		err = json.Unmarshal(data, &r.tags)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		log.Errorf("localRegistry: %s: %v", registryTagsFilename, err)
+	}
+	r.tagsLoaded = true
+}
+
+// lookupTag returns the digest the tag key last resolved to, if any
+func (r *localRegistry) lookupTag(key string) string {
+	r.tagsLock.Lock()
+	defer r.tagsLock.Unlock()
+	r.loadTags()
+	return r.tags[registryTagKey(key)]
+}
+
+// recordTag records the digest the tag key resolved to, for when the
+// datastores are not reachable
+func (r *localRegistry) recordTag(key, digest string) {
+	key = registryTagKey(key)
+	r.tagsLock.Lock()
+	defer r.tagsLock.Unlock()
+	r.loadTags()
+	if r.tags[key] == digest {
+		return
+	}
+	r.tags[key] = digest
+	data, err := json.Marshal(r.tags)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(registryTagsFilename), 0700)
+	}
+	if err == nil {
+		err = fileutils.WriteRename(registryTagsFilename, data)
+	}
+	if err != nil {
+		log.Errorf("localRegistry: recordTag(%s): %v", key, err)
+	}
+}
+
+// fetchFromDatastores calls f with the reference refName in each of the OCI
+// datastores among dsIDs and the options to access it over each of the
+// management ports we may download over, until it succeeds
+func fetchFromDatastores(reqCtx context.Context, ctx *downloaderContext,
+	dsIDs []uuid.UUID, refName string,
+	f func(name.Reference, []remote.Option) error) error {
+
+	downloadMaxPortCost := ctx.downloadMaxPortCost
+	addrCount := types.CountLocalAddrNoLinkLocalWithCost(ctx.deviceNetworkStatus,
+		downloadMaxPortCost)
+	var errs []string
+	for _, dsID := range dsIDs {
+		item, err := ctx.subDatastoreConfig.Get(dsID.String())
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		dst := item.(types.DatastoreConfig)
+		if dst.DsType != zconfig.DsType_DsContainerRegistry.String() {
+			continue
+		}
+		dsCtx, err := constructDatastoreContext(ctx, refName, false, dst)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		serverURL, remoteName, err := ociRepositorySplit(dsCtx.DownloadURL)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		ref, err := name.ParseReference(serverURL + "/" + remoteName)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		limiters := ctx.bandwidth.limiters(dst.UUID)
+		for addrIndex := 0; addrIndex < addrCount; addrIndex++ {
+			ipSrc, err := types.GetLocalAddrNoLinkLocalWithCost(ctx.deviceNetworkStatus,
+				addrIndex, "", downloadMaxPortCost)
+			if err != nil {
+				continue
+			}
+			ifname := types.GetMgmtPortFromAddr(ctx.deviceNetworkStatus, ipSrc)
+			opts, err := registryOptions(reqCtx, ctx, dsCtx, serverURL, ifname,
+				ipSrc, limiters)
+			if err == nil {
+				err = f(ref, opts)
+			}
+			if err == nil {
+				return nil
+			}
+			errs = append(errs, fmt.Sprintf("%s from %s: %v", ref, ipSrc, err))
+		}
+	}
+	if len(errs) == 0 {
+		return fmt.Errorf("no OCI datastore to fetch %s from", refName)
+	}
+	return errors.New(strings.Join(errs, "; "))
+}
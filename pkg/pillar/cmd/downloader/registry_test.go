@@ -0,0 +1,143 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package downloader
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/lf-edge/eve/pkg/pillar/types"
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistryRepository(t *testing.T) {
+	assert.Equal(t, "library/alpine", registryRepository("library/alpine"))
+	assert.Equal(t, "library/alpine", registryRepository("library/alpine:3.18"))
+	assert.Equal(t, "library/alpine", registryRepository("library/alpine@sha256:"+testSha256(nil)))
+	assert.Equal(t, "library/alpine", registryRepository("library/alpine:3.18@sha256:"+testSha256(nil)))
+	assert.Equal(t, "localhost:5000/app", registryRepository("localhost:5000/app"))
+}
+
+func TestParseRegistryPath(t *testing.T) {
+	repo, kind, ref, ok := parseRegistryPath("/v2/library/alpine/manifests/3.18")
+	assert.True(t, ok)
+	assert.Equal(t, []string{"library/alpine", "manifests", "3.18"}, []string{repo, kind, ref})
+	repo, kind, ref, ok = parseRegistryPath("/v2/blobs/blobs/sha256:abc")
+	assert.True(t, ok)
+	assert.Equal(t, []string{"blobs", "blobs", "sha256:abc"}, []string{repo, kind, ref})
+	_, _, _, ok = parseRegistryPath("/v2/library/alpine/tags/list")
+	assert.False(t, ok)
+	_, _, _, ok = parseRegistryPath("/v2/manifests/latest")
+	assert.False(t, ok)
+}
+
+func TestFindRegistryRepo(t *testing.T) {
+	network, _ := uuid.NewV4()
+	otherNetwork, _ := uuid.NewV4()
+	datastore, _ := uuid.NewV4()
+	otherDatastore, _ := uuid.NewV4()
+	newApp := func(network uuid.UUID, volumes ...uuid.UUID) types.AppInstanceConfig {
+		app := types.AppInstanceConfig{
+			AppNetAdapterList: []types.AppNetAdapterConfig{{Network: network}},
+		}
+		for _, volume := range volumes {
+			app.VolumeRefConfigList = append(app.VolumeRefConfigList,
+				types.VolumeRefConfig{VolumeID: volume})
+		}
+		return app
+	}
+	volumeConfigs := make(map[string]interface{})
+	contentStatuses := make(map[string]interface{})
+	newVolume := func(relativeURL string, datastore uuid.UUID, blobs ...string) uuid.UUID {
+		volume, _ := uuid.NewV4()
+		content, _ := uuid.NewV4()
+		volumeConfigs[volume.String()] = types.VolumeConfig{
+			VolumeID:  volume,
+			ContentID: content,
+		}
+		contentStatuses[content.String()] = types.ContentTreeStatus{
+			ContentID:       content,
+			DatastoreIDList: []uuid.UUID{datastore},
+			IsOCIRegistry:   true,
+			RelativeURL:     relativeURL,
+			Blobs:           blobs,
+		}
+		return volume
+	}
+	alpine := newVolume("library/alpine:3.18", datastore, "a1", "a2")
+	alpineEdge := newVolume("library/alpine:edge", otherDatastore, "a3")
+	nginx := newVolume("library/nginx:latest", datastore, "n1")
+	appConfigs := map[string]interface{}{
+		"app1": newApp(network, alpine),
+		"app2": newApp(network, alpineEdge),
+		"app3": newApp(otherNetwork, nginx),
+	}
+
+	found := findRegistryRepo(network, "library/alpine", appConfigs,
+		volumeConfigs, contentStatuses)
+	if assert.NotNil(t, found) {
+		assert.Equal(t, map[string]bool{"a1": true, "a2": true, "a3": true}, found.blobs)
+		assert.ElementsMatch(t, []uuid.UUID{datastore, otherDatastore}, found.datastores)
+		assert.True(t, found.datastores[0].String() < found.datastores[1].String())
+	}
+
+	// The images of the apps on other network instances are not served
+	assert.Nil(t, findRegistryRepo(network, "library/nginx", appConfigs,
+		volumeConfigs, contentStatuses))
+	found = findRegistryRepo(otherNetwork, "library/nginx", appConfigs,
+		volumeConfigs, contentStatuses)
+	if assert.NotNil(t, found) {
+		assert.Equal(t, map[string]bool{"n1": true}, found.blobs)
+		assert.Equal(t, []uuid.UUID{datastore}, found.datastores)
+	}
+	assert.Nil(t, findRegistryRepo(otherNetwork, "library/alpine", appConfigs,
+		volumeConfigs, contentStatuses))
+	assert.Nil(t, findRegistryRepo(network, "library/busybox", appConfigs,
+		volumeConfigs, contentStatuses))
+}
+
+func TestRegistryCacheName(t *testing.T) {
+	sha := testSha256(nil)
+	assert.Equal(t, registryCacheName("library/alpine", sha),
+		registryCacheName("library/alpine", sha))
+	assert.NotEqual(t, registryCacheName("library/alpine", sha),
+		registryCacheName("library/nginx", sha))
+	assert.Contains(t, registryCacheName("library/alpine", sha), sha)
+}
+
+func TestRegistryBlobFits(t *testing.T) {
+	assert.Nil(t, registryBlobFits(100, 100, 100))
+	assert.NotNil(t, registryBlobFits(101, 100, 200))
+	assert.NotNil(t, registryBlobFits(100, 200, 99))
+}
+
+func TestRegistryFetchInProgress(t *testing.T) {
+	sha := testSha256(nil)
+	r := &localRegistry{
+		fetches:    make(map[string]*registryFetch),
+		fetchSlots: make(chan struct{}, maxRegistryFetches),
+	}
+	inProgress := &registryFetch{done: make(chan struct{})}
+	r.fetches[registryCacheName("library/alpine", sha)] = inProgress
+
+	// The request waits for the fetch in progress and gets its outcome
+	result := make(chan error)
+	go func() {
+		result <- r.fetch(context.Background(), nil, nil, "library/alpine",
+			"sha256:"+sha, false)
+	}()
+	inProgress.err = errors.New("no OCI datastore")
+	close(inProgress.done)
+	assert.Equal(t, inProgress.err, <-result)
+
+	// Or stops waiting when it is canceled
+	inProgress = &registryFetch{done: make(chan struct{})}
+	r.fetches[registryCacheName("library/alpine", sha)] = inProgress
+	reqCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, r.fetch(reqCtx, nil, nil, "library/alpine",
+		"sha256:"+sha, false))
+}
@@ -282,6 +282,10 @@ func resolveTagsToHash(ctx *downloaderContext, rc types.ResolveConfig,
 			}
 			continue
 		}
+		if ctx.globalConfig.GlobalValueBool(types.LocalRegistryEnable) {
+			// For the apps to pull the tag from the local registry offline
+			ctx.localRegistry.recordTag(rc.Name, "sha256:"+strings.ToLower(sha256))
+		}
 		rs.ClearError()
 		rs.ImageSha256 = sha256
 		publishResolveStatus(ctx, rs)
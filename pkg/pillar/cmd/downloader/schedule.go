@@ -0,0 +1,74 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package downloader
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/lf-edge/eve/pkg/pillar/types"
+)
+
+// downloadDeferral returns until when the download of config, deferred
+// since deferredSince if it was, is deferred at now and why, or a zero time
+// if it may start. Downloads of DownloadWindowMinMiB or more wait for the
+// next of the DownloadWindows, unless they are for the base OS image, but
+// for DownloadWindowMaxDeferHours at most.
+func downloadDeferral(ctx *downloaderContext, config types.DownloaderConfig,
+	deferredSince, now time.Time) (time.Time, string) {
+
+	gcp := ctx.globalConfig
+	value := gcp.GlobalValueString(types.DownloadWindows)
+	windows, err := types.ParseDownloadWindows(value)
+	if err != nil {
+		// Not expected, the global config is validated
+		log.Errorf("downloadDeferral(%s): %v", config.Name, err)
+		return time.Time{}, ""
+	}
+	minSize := uint64(gcp.GlobalValueInt(types.DownloadWindowMinMiB)) << 20
+	if len(windows) == 0 || config.Size < minSize || config.IsBaseOS {
+		return time.Time{}, ""
+	}
+	next := types.NextDownloadWindow(windows, now)
+	if !next.After(now) {
+		return time.Time{}, ""
+	}
+	reason := fmt.Sprintf("outside of the download windows %s UTC", value)
+	maxDefer := time.Duration(gcp.GlobalValueInt(types.DownloadWindowMaxDeferHours)) * time.Hour
+	if maxDefer == 0 {
+		return next, reason
+	}
+	if deferredSince.IsZero() {
+		deferredSince = now
+	}
+	deadline := deferredSince.Add(maxDefer)
+	if !deadline.After(now) {
+		return time.Time{}, ""
+	}
+	if deadline.Before(next) {
+		return deadline, fmt.Sprintf("%s, %s at most", reason, maxDefer)
+	}
+	return next, reason
+}
+
+// maybeStartDeferredDownload starts a deferred download once it is time,
+// or once the download windows changed or it is for the base OS image
+func maybeStartDeferredDownload(ctx *downloaderContext,
+	status *types.DownloaderStatus, receiveChan chan<- CancelChannel) {
+
+	if status.DeferredUntil.IsZero() {
+		return
+	}
+	config := lookupDownloaderConfig(ctx, status.Key())
+	if config == nil || config.RefCount == 0 {
+		return
+	}
+	until, _ := downloadDeferral(ctx, *config, status.DeferredSince, time.Now())
+	if until.Equal(status.DeferredUntil) {
+		return
+	}
+	log.Functionf("maybeStartDeferredDownload(%s) deferred until %v",
+		status.Key(), status.DeferredUntil)
+	doDownload(ctx, *config, status, receiveChan)
+}
@@ -0,0 +1,61 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package downloader
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lf-edge/eve/pkg/pillar/base"
+	"github.com/lf-edge/eve/pkg/pillar/types"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDownloadDeferral(t *testing.T) {
+	log = base.NewSourceLogObject(logrus.StandardLogger(), "downloader", 0)
+	ctx := &downloaderContext{globalConfig: *types.DefaultConfigItemValueMap()}
+	config := types.DownloaderConfig{Name: "image", Size: 100 << 20}
+	noon := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	until, _ := downloadDeferral(ctx, config, time.Time{}, noon)
+	assert.True(t, until.IsZero(), "no download windows")
+
+	ctx.globalConfig.SetGlobalValueString(types.DownloadWindows, "22:00-06:00")
+	ctx.globalConfig.SetGlobalValueInt(types.DownloadWindowMinMiB, 10)
+	until, reason := downloadDeferral(ctx, config, time.Time{}, noon)
+	assert.Equal(t, time.Date(2023, 6, 1, 22, 0, 0, 0, time.UTC), until)
+	assert.Contains(t, reason, "22:00-06:00")
+
+	until, _ = downloadDeferral(ctx, config, time.Time{}, noon.Add(11*time.Hour))
+	assert.True(t, until.IsZero(), "in a download window")
+
+	small := config
+	small.Size = 1 << 20
+	until, _ = downloadDeferral(ctx, small, time.Time{}, noon)
+	assert.True(t, until.IsZero(), "smaller than the minimum size")
+
+	baseOS := config
+	baseOS.IsBaseOS = true
+	until, _ = downloadDeferral(ctx, baseOS, time.Time{}, noon)
+	assert.True(t, until.IsZero(), "base OS image")
+
+	ctx.globalConfig.SetGlobalValueString(types.DownloadWindows, "08:00-09:00,18:00-19:00")
+	until, _ = downloadDeferral(ctx, config, time.Time{}, noon)
+	assert.Equal(t, time.Date(2023, 6, 1, 18, 0, 0, 0, time.UTC), until)
+	until, _ = downloadDeferral(ctx, config, time.Time{}, noon.Add(8*time.Hour))
+	assert.Equal(t, time.Date(2023, 6, 2, 8, 0, 0, 0, time.UTC), until)
+
+	ctx.globalConfig.SetGlobalValueInt(types.DownloadWindowMaxDeferHours, 4)
+	until, reason = downloadDeferral(ctx, config, time.Time{}, noon)
+	assert.Equal(t, noon.Add(4*time.Hour), until, "deferred for 4 hours at most")
+	assert.Contains(t, reason, "4h0m0s at most")
+	until, _ = downloadDeferral(ctx, config, noon, noon.Add(3*time.Hour))
+	assert.Equal(t, noon.Add(4*time.Hour), until)
+	until, _ = downloadDeferral(ctx, config, noon, noon.Add(4*time.Hour))
+	assert.True(t, until.IsZero(), "deferred for too long")
+	until, _ = downloadDeferral(ctx, config, time.Time{}, noon.Add(5*time.Hour))
+	assert.Equal(t, time.Date(2023, 6, 1, 18, 0, 0, 0, time.UTC), until,
+		"the download window opens first")
+}
@@ -0,0 +1,322 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package downloader
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// Reads from a shaped connection are cut to this size for the pace to be
+// smooth at low rates
+const shapedReadSize = 16 << 10
+
+// rateLimiter paces the bytes read by all its users to a rate
+type rateLimiter struct {
+	mutex       sync.Mutex
+	bytesPerSec float64 // 0 for no limit
+	next        time.Time
+}
+
+func (l *rateLimiter) setKbps(kbps uint32) {
+	l.mutex.Lock()
+	l.bytesPerSec = float64(kbps) * 1000 / 8
+	l.mutex.Unlock()
+}
+
+// reserve accounts for n bytes just read and returns how long to wait for
+// them to fit in the rate
+func (l *rateLimiter) reserve(n int, now time.Time) time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.bytesPerSec == 0 || n <= 0 {
+		return 0
+	}
+	if l.next.Before(now) {
+		l.next = now
+	}
+	l.next = l.next.Add(time.Duration(float64(n) / l.bytesPerSec * float64(time.Second)))
+	return l.next.Sub(now)
+}
+
+// shapedConn paces the reads from a connection with limiters
+type shapedConn struct {
+	net.Conn
+	limiters []*rateLimiter
+}
+
+func (c *shapedConn) Read(p []byte) (int, error) {
+	if len(p) > shapedReadSize {
+		p = p[:shapedReadSize]
+	}
+	n, err := c.Conn.Read(p)
+	now := time.Now()
+	var delay time.Duration
+	for _, l := range c.limiters {
+		if d := l.reserve(n, now); d > delay {
+			delay = d
+		}
+	}
+	time.Sleep(delay)
+	return n, err
+}
+
+// shapedSession is a download going through the shaping proxy
+type shapedSession struct {
+	dial     func(ctx context.Context, network, addr string) (net.Conn, error)
+	upstream *url.URL // proxy of the management port, if any
+	client   *http.Transport
+}
+
+// bandwidthShaper caps the bandwidth of the downloads, all together and
+// from each datastore. The downloads done by zedUpload go through a proxy
+// on the loopback interface which paces what it reads from the datastores.
+type bandwidthShaper struct {
+	mutex         sync.Mutex
+	global        rateLimiter
+	globalKbps    uint32
+	datastoreKbps uint32               // of the datastores not in datastoreCaps
+	datastoreCaps map[uuid.UUID]uint32 // of given datastores
+	datastores    map[uuid.UUID]*rateLimiter
+	listener      net.Listener
+	sessions      map[string]*shapedSession
+}
+
+// update applies the bandwidth caps of the global config
+func (s *bandwidthShaper) update(globalKbps, datastoreKbps uint32,
+	datastoreCaps map[uuid.UUID]uint32) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.globalKbps != globalKbps || s.datastoreKbps != datastoreKbps ||
+		!reflect.DeepEqual(s.datastoreCaps, datastoreCaps) {
+		log.Noticef("bandwidthShaper: %d kbit/s, %d kbit/s per datastore, %v for given datastores",
+			globalKbps, datastoreKbps, datastoreCaps)
+	}
+	s.globalKbps = globalKbps
+	s.datastoreKbps = datastoreKbps
+	s.datastoreCaps = datastoreCaps
+	s.global.setKbps(globalKbps)
+	for dsID, l := range s.datastores {
+		l.setKbps(s.datastoreCap(dsID))
+	}
+}
+
+// datastoreCap returns the cap of the downloads from the datastore dsID
+func (s *bandwidthShaper) datastoreCap(dsID uuid.UUID) uint32 {
+	if kbps, ok := s.datastoreCaps[dsID]; ok {
+		return kbps
+	}
+	return s.datastoreKbps
+}
+
+// limiters returns the limiters of the downloads from the datastore dsID,
+// or nil if their bandwidth is not capped
+func (s *bandwidthShaper) limiters(dsID uuid.UUID) []*rateLimiter {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.globalKbps == 0 && s.datastoreCap(dsID) == 0 {
+		return nil
+	}
+	if s.datastores == nil {
+		s.datastores = make(map[uuid.UUID]*rateLimiter)
+	}
+	l := s.datastores[dsID]
+	if l == nil {
+		l = &rateLimiter{}
+		l.setKbps(s.datastoreCap(dsID))
+		s.datastores[dsID] = l
+	}
+	return []*rateLimiter{l, &s.global}
+}
+
+// shapedDialer returns a dial function from ipSrc whose connections are
+// paced by limiters
+func shapedDialer(limiters []*rateLimiter,
+	ipSrc net.IP) func(ctx context.Context, network, addr string) (net.Conn, error) {
+
+	dialer := &net.Dialer{
+		LocalAddr: &net.TCPAddr{IP: ipSrc},
+		Timeout:   30 * time.Second,
+	}
+	if len(limiters) == 0 {
+		return dialer.DialContext
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dialer.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &shapedConn{Conn: conn, limiters: limiters}, nil
+	}
+}
+
+// proxy returns the URL of the shaping proxy for a download from ipSrc
+// paced by limiters, through upstream if it is not nil, and a function to
+// call when the download is done
+func (s *bandwidthShaper) proxy(limiters []*rateLimiter, ipSrc net.IP,
+	upstream *url.URL) (*url.URL, func(), error) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.listener == nil {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return nil, nil, err
+		}
+		s.listener = listener
+		s.sessions = make(map[string]*shapedSession)
+		server := &http.Server{
+			Handler:           http.HandlerFunc(s.serveProxy),
+			ReadHeaderTimeout: 30 * time.Second,
+		}
+		go func() {
+			err := server.Serve(listener)
+			log.Errorf("bandwidthShaper: proxy stopped: %v", err)
+		}()
+	}
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, nil, err
+	}
+	token := hex.EncodeToString(raw)
+	session := &shapedSession{
+		dial:     shapedDialer(limiters, ipSrc),
+		upstream: upstream,
+	}
+	session.client = &http.Transport{
+		Proxy:               http.ProxyURL(upstream),
+		DialContext:         session.dial,
+		TLSHandshakeTimeout: 30 * time.Second,
+	}
+	s.sessions[token] = session
+	done := func() {
+		s.mutex.Lock()
+		delete(s.sessions, token)
+		s.mutex.Unlock()
+		session.client.CloseIdleConnections()
+	}
+	// The clients send the user as the Proxy-Authorization
+	proxyURL := &url.URL{
+		Scheme: "http",
+		User:   url.User(token),
+		Host:   s.listener.Addr().String(),
+	}
+	return proxyURL, done, nil
+}
+
+// session returns the session of the Proxy-Authorization of r
+func (s *bandwidthShaper) session(r *http.Request) *shapedSession {
+	encoded, found := strings.CutPrefix(r.Header.Get("Proxy-Authorization"), "Basic ")
+	if !found {
+		return nil
+	}
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil
+	}
+	token, _, _ := strings.Cut(string(decoded), ":")
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.sessions[token]
+}
+
+// serveProxy forwards a request of a download to the datastore, or tunnels
+// its connection for CONNECT, reading the responses at the pace of the
+// limiters of the download
+func (s *bandwidthShaper) serveProxy(w http.ResponseWriter, r *http.Request) {
+	session := s.session(r)
+	if session == nil {
+		http.Error(w, "unknown download", http.StatusProxyAuthRequired)
+		return
+	}
+	r.Header.Del("Proxy-Authorization")
+	if r.Method == http.MethodConnect {
+		s.tunnel(session, w, r)
+		return
+	}
+	req := r.Clone(r.Context())
+	req.RequestURI = ""
+	resp, err := session.client.RoundTrip(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	for key, values := range resp.Header {
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		log.Warnf("bandwidthShaper: %s: %v", r.URL.Redacted(), err)
+	}
+}
+
+// tunnel connects the client to the host of a CONNECT request, through the
+// upstream proxy if any
+func (s *bandwidthShaper) tunnel(session *shapedSession, w http.ResponseWriter,
+	r *http.Request) {
+
+	target := r.Host
+	if session.upstream != nil {
+		target = session.upstream.Host
+		if session.upstream.Port() == "" {
+			port := "80"
+			if session.upstream.Scheme == "https" {
+				port = "443"
+			}
+			target = net.JoinHostPort(session.upstream.Hostname(), port)
+		}
+	}
+	conn, err := session.dial(r.Context(), "tcp", target)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer conn.Close()
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "cannot tunnel", http.StatusInternalServerError)
+		return
+	}
+	client, buffered, err := hijacker.Hijack()
+	if err != nil {
+		log.Errorf("bandwidthShaper: hijack: %v", err)
+		return
+	}
+	defer client.Close()
+	if session.upstream != nil {
+		// The upstream proxy answers the client
+		fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n", r.Host, r.Host)
+		if user := session.upstream.User; user != nil {
+			password, _ := user.Password()
+			auth := base64.StdEncoding.EncodeToString(
+				[]byte(user.Username() + ":" + password))
+			fmt.Fprintf(conn, "Proxy-Authorization: Basic %s\r\n", auth)
+		}
+		fmt.Fprintf(conn, "\r\n")
+	} else {
+		fmt.Fprintf(client, "HTTP/1.1 200 Connection established\r\n\r\n")
+	}
+	go func() {
+		// Reads what the client sent after its request first
+		io.Copy(conn, buffered.Reader)
+		conn.Close()
+	}()
+	io.Copy(client, conn)
+}
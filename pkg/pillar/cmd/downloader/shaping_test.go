@@ -0,0 +1,190 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package downloader
+
+import (
+	"bufio"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lf-edge/eve/pkg/pillar/base"
+	uuid "github.com/satori/go.uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+	var l rateLimiter
+	now := time.Now()
+	assert.Zero(t, l.reserve(1<<20, now), "no limit")
+
+	l.setKbps(80) // 10000 bytes per second
+	assert.Equal(t, 500*time.Millisecond, l.reserve(5000, now))
+	assert.Equal(t, time.Second, l.reserve(5000, now))
+	assert.Equal(t, time.Second, l.reserve(5000, now.Add(500*time.Millisecond)))
+	// The time when nothing was read is not carried over
+	assert.Equal(t, 500*time.Millisecond, l.reserve(5000, now.Add(time.Hour)))
+	assert.Zero(t, l.reserve(0, now.Add(time.Hour)))
+}
+
+func TestBandwidthShaperLimiters(t *testing.T) {
+	log = base.NewSourceLogObject(logrus.StandardLogger(), "downloader", 0)
+	var s bandwidthShaper
+	ds1, _ := uuid.NewV4()
+	ds2, _ := uuid.NewV4()
+	assert.Nil(t, s.limiters(ds1), "not capped")
+
+	s.update(800, 80, nil)
+	limiters1 := s.limiters(ds1)
+	limiters2 := s.limiters(ds2)
+	assert.Len(t, limiters1, 2)
+	assert.Equal(t, limiters1, s.limiters(ds1))
+	assert.NotSame(t, limiters1[0], limiters2[0])
+	assert.Same(t, limiters1[1], limiters2[1], "global limiter")
+	assert.Equal(t, float64(10000), limiters1[0].bytesPerSec)
+	assert.Equal(t, float64(100000), limiters1[1].bytesPerSec)
+
+	s.update(800, 0, nil)
+	assert.Zero(t, limiters1[0].bytesPerSec)
+	assert.Len(t, s.limiters(ds1), 2)
+	s.update(0, 0, nil)
+	assert.Nil(t, s.limiters(ds1))
+
+	// Caps of given datastores
+	s.update(0, 80, map[uuid.UUID]uint32{ds1: 160, ds2: 0})
+	assert.Equal(t, float64(20000), s.limiters(ds1)[0].bytesPerSec)
+	assert.Nil(t, s.limiters(ds2), "not capped")
+	ds3, _ := uuid.NewV4()
+	assert.Equal(t, float64(10000), s.limiters(ds3)[0].bytesPerSec)
+	s.update(0, 80, nil)
+	assert.Equal(t, float64(10000), limiters1[0].bytesPerSec)
+}
+
+// proxyClient returns a client whose requests go through proxyURL and
+// which trusts server
+func proxyClient(proxyURL *url.URL, server *httptest.Server) *http.Client {
+	transport := &http.Transport{Proxy: http.ProxyURL(proxyURL)}
+	if server.TLS != nil {
+		transport.TLSClientConfig = server.Client().Transport.(*http.Transport).TLSClientConfig
+	}
+	return &http.Client{Transport: transport, Timeout: 10 * time.Second}
+}
+
+func getBody(t *testing.T, client *http.Client, target string) (int, []byte) {
+	resp, err := client.Get(target)
+	if !assert.NoError(t, err) {
+		return 0, nil
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	return resp.StatusCode, body
+}
+
+// newUpstreamProxy returns the URL of a proxy which tunnels the CONNECT
+// requests of user and the count of its tunnels
+func newUpstreamProxy(t *testing.T, user *url.Userinfo) (*url.URL, *atomic.Int32) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	password, _ := user.Password()
+	auth := "Basic " + base64.StdEncoding.EncodeToString(
+		[]byte(user.Username()+":"+password))
+	var tunnels atomic.Int32
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				r, err := http.ReadRequest(reader)
+				if err != nil {
+					return
+				}
+				if r.Method != http.MethodConnect ||
+					r.Header.Get("Proxy-Authorization") != auth {
+					io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+					return
+				}
+				target, err := net.Dial("tcp", r.Host)
+				if err != nil {
+					io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+					return
+				}
+				defer target.Close()
+				tunnels.Add(1)
+				io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+				go io.Copy(target, reader)
+				io.Copy(conn, target)
+			}()
+		}
+	}()
+	return &url.URL{Scheme: "http", User: user, Host: listener.Addr().String()}, &tunnels
+}
+
+func TestShapingProxy(t *testing.T) {
+	log = base.NewSourceLogObject(logrus.StandardLogger(), "downloader", 0)
+	content := randomContent(1, 20000)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(content)
+	})
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	tlsServer := httptest.NewTLSServer(handler)
+	defer tlsServer.Close()
+
+	var s bandwidthShaper
+	proxyURL, done, err := s.proxy(nil, nil, nil)
+	assert.NoError(t, err)
+	status, body := getBody(t, proxyClient(proxyURL, server), server.URL)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, content, body)
+
+	// CONNECT is tunneled to the datastore
+	status, body = getBody(t, proxyClient(proxyURL, tlsServer), tlsServer.URL)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, content, body)
+
+	// The sessions are known until they are done
+	done()
+	status, _ = getBody(t, proxyClient(proxyURL, server), server.URL)
+	assert.Equal(t, http.StatusProxyAuthRequired, status)
+	unknown := *proxyURL
+	unknown.User = nil
+	status, _ = getBody(t, proxyClient(&unknown, server), server.URL)
+	assert.Equal(t, http.StatusProxyAuthRequired, status)
+
+	// CONNECT goes through the proxy of the management port
+	upstream, tunnels := newUpstreamProxy(t, url.UserPassword("user", "secret"))
+	proxyURL, done, err = s.proxy(nil, nil, upstream)
+	assert.NoError(t, err)
+	defer done()
+	status, body = getBody(t, proxyClient(proxyURL, tlsServer), tlsServer.URL)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, content, body)
+	assert.Equal(t, int32(1), tunnels.Load())
+
+	// The reads are paced by the limiters
+	var limiter rateLimiter
+	limiter.setKbps(160) // 20000 bytes per second
+	proxyURL, done, err = s.proxy([]*rateLimiter{&limiter}, nil, nil)
+	assert.NoError(t, err)
+	defer done()
+	start := time.Now()
+	status, body = getBody(t, proxyClient(proxyURL, tlsServer), tlsServer.URL)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, content, body)
+	assert.GreaterOrEqual(t, time.Since(start), 900*time.Millisecond)
+}
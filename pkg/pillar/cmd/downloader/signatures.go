@@ -0,0 +1,191 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/lf-edge/eve-libs/zedUpload"
+	"github.com/lf-edge/eve/pkg/pillar/types"
+	fileutils "github.com/lf-edge/eve/pkg/pillar/utils/file"
+	"github.com/lf-edge/eve/pkg/pillar/zedcloud"
+)
+
+const (
+	// Annotation of the layers of cosign signature images holding the
+	// signature of the layer
+	cosignSignatureAnnotation = "dev.cosignproject.cosign/signature"
+	// Bounds the layers of signature and attestation images we read
+	maxSignatureLayerSize = 1 << 20
+	// How long fetching the signatures over one address may take
+	signatureFetchTimeout = 2 * time.Minute
+)
+
+// isImageManifest returns true if a blob of mediaType is an OCI manifest
+// or index, which are what cosign signs
+func isImageManifest(mediaType string) bool {
+	blob := types.BlobStatus{MediaType: mediaType}
+	return blob.IsManifest() || blob.IsIndex()
+}
+
+// wantImageSignatures returns true if the device has keys to verify the
+// signatures with; there is no point in fetching them otherwise
+func wantImageSignatures() bool {
+	_, err := os.Stat(types.ImageSigningKeysFileName)
+	return err == nil
+}
+
+// fetchImageSignatures saves the cosign signatures and attestations of the
+// image with sha256 in the repository of remoteName in ImageSignaturesDirname
+// for the verifier. Unsigned images are not an error.
+func fetchImageSignatures(ctx *downloaderContext, dsCtx *types.DatastoreContext,
+	serverURL, remoteName, ifname string, ipSrc net.IP, sha256 string) error {
+
+	sha256 = strings.ToLower(sha256)
+	ref, err := name.ParseReference(serverURL + "/" + remoteName)
+	if err != nil {
+		return fmt.Errorf("invalid reference %s/%s: %v", serverURL, remoteName, err)
+	}
+	repo := ref.Context()
+	reqCtx, cancel := context.WithTimeout(context.Background(), signatureFetchTimeout)
+	defer cancel()
+	opts, err := registryOptions(reqCtx, ctx, dsCtx, serverURL, ifname, ipSrc, nil)
+	if err != nil {
+		return err
+	}
+
+	sigs := types.ImageSignatures{ImageSha256: sha256}
+	err = readCosignLayers(repo, sha256, ".sig", opts,
+		func(desc v1.Descriptor, content []byte) {
+			sig, ok := desc.Annotations[cosignSignatureAnnotation]
+			if !ok {
+				return
+			}
+			sigs.Signatures = append(sigs.Signatures, types.CosignSignature{
+				Payload:   content,
+				Signature: sig,
+			})
+		})
+	if err != nil {
+		return err
+	}
+	err = readCosignLayers(repo, sha256, ".att", opts,
+		func(desc v1.Descriptor, content []byte) {
+			sigs.Attestations = append(sigs.Attestations, content)
+		})
+	if err != nil {
+		return err
+	}
+	log.Functionf("fetchImageSignatures(%s): %d signatures %d attestations",
+		sha256, len(sigs.Signatures), len(sigs.Attestations))
+
+	fileName := filepath.Join(types.ImageSignaturesDirname, sha256+".json")
+	if len(sigs.Signatures) == 0 && len(sigs.Attestations) == 0 {
+		// Drop what a previous resolution found
+		if err := os.Remove(fileName); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	data, err := json.Marshal(sigs)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(types.ImageSignaturesDirname, 0700); err != nil {
+		return err
+	}
+	return fileutils.WriteRename(fileName, data)
+}
+
+// registryOptions returns the options to access the registry at serverURL
+// with the credentials of dsCtx from ipSrc on ifname, using its proxy, at
+// the pace of limiters
+func registryOptions(reqCtx context.Context, ctx *downloaderContext,
+	dsCtx *types.DatastoreContext, serverURL, ifname string,
+	ipSrc net.IP, limiters []*rateLimiter) ([]remote.Option, error) {
+
+	tr := &http.Transport{
+		DialContext:         shapedDialer(limiters, ipSrc),
+		TLSHandshakeTimeout: 30 * time.Second,
+	}
+	proxyLookupURL := zedcloud.IntfLookupProxyCfg(log, &ctx.deviceNetworkStatus, ifname,
+		serverURL, zedUpload.SyncOCIRegistryTr)
+	proxyURL, err := zedcloud.LookupProxy(log, &ctx.deviceNetworkStatus, ifname, proxyLookupURL)
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL != nil {
+		tr.Proxy = http.ProxyURL(proxyURL)
+	}
+	auth := authn.Anonymous
+	if dsCtx.APIKey != "" || dsCtx.Password != "" {
+		auth = authn.FromConfig(authn.AuthConfig{
+			Username: dsCtx.APIKey,
+			Password: dsCtx.Password,
+		})
+	}
+	return []remote.Option{
+		remote.WithAuth(auth),
+		remote.WithTransport(tr),
+		remote.WithContext(reqCtx),
+	}, nil
+}
+
+// readCosignLayers calls f with the descriptor and the content of each
+// layer of the image cosign stores next to the image with sha256, tagged
+// sha256-<hex><suffix>. An image which does not exist has no layers.
+func readCosignLayers(repo name.Repository, sha256, suffix string,
+	opts []remote.Option, f func(v1.Descriptor, []byte)) error {
+
+	tag := repo.Tag("sha256-" + sha256 + suffix)
+	img, err := remote.Image(tag, opts...)
+	if err != nil {
+		var terr *transport.Error
+		if errors.As(err, &terr) && terr.StatusCode == http.StatusNotFound {
+			return nil
+		}
+		return fmt.Errorf("%s: %v", tag, err)
+	}
+	manifest, err := img.Manifest()
+	if err != nil {
+		return fmt.Errorf("%s: %v", tag, err)
+	}
+	for _, desc := range manifest.Layers {
+		if desc.Size > maxSignatureLayerSize {
+			log.Warnf("readCosignLayers(%s): skipping layer %s of %d bytes",
+				tag, desc.Digest, desc.Size)
+			continue
+		}
+		layer, err := img.LayerByDigest(desc.Digest)
+		if err != nil {
+			return fmt.Errorf("%s: %v", tag, err)
+		}
+		reader, err := layer.Compressed()
+		if err != nil {
+			return fmt.Errorf("%s: %v", tag, err)
+		}
+		content, err := io.ReadAll(io.LimitReader(reader, maxSignatureLayerSize))
+		reader.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %v", tag, err)
+		}
+		f(desc, content)
+	}
+	return nil
+}
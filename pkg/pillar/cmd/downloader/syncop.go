@@ -4,6 +4,7 @@
 package downloader
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"net/url"
@@ -231,6 +232,8 @@ func handleSyncOp(ctx *downloaderContext, key string,
 		}
 	}
 
+	limiters := ctx.bandwidth.limiters(dst.UUID)
+
 	// Loop through all interfaces until a success
 	for addrIndex := 0; addrIndex < addrCount; addrIndex++ {
 		var ifname string
@@ -262,10 +265,36 @@ func handleSyncOp(ctx *downloaderContext, key string,
 			status: status,
 		}
 		downloadStartTime := time.Now()
+		if trType == zedUpload.SyncOCIRegistryTr && config.Size >= minChunkedSize &&
+			config.ImageSha256 != "" {
+			fetched, err := downloadChunked(ctx, st, dsCtx, serverURL,
+				remoteName, ifname, ipSrc, config.ImageSha256, locFilename,
+				config.IsBaseOS, limiters)
+			if err == nil {
+				downloadTime := int64(time.Since(downloadStartTime) / time.Millisecond)
+				status.Size = config.Size
+				ctx.zedcloudMetrics.RecordSuccess(log, ifname,
+					metricsURL, 1024, fetched, downloadTime, false)
+				st.Progress(100, int64(config.Size), int64(config.Size))
+				return handleSyncOpResponse(ctx, config, status,
+					locFilename, key, "", false, cleanOnError)
+			}
+			if !errors.Is(err, errNoChunkIndex) {
+				// Do not fall back to downloading all of it, which the
+				// chunks were meant to avoid, and keep the chunks we
+				// have for the next attempt to resume from
+				cleanOnError = false
+				log.Errorf("Source IP %s failed chunked download: %s", ipSrc, err)
+				ctx.zedcloudMetrics.RecordFailure(log, ifname, metricsURL, 1024, 0, false)
+				errStr = errStr + "\n" + err.Error()
+				continue
+			}
+			log.Functionf("%s: %s, downloading it whole", config.Name, err)
+		}
 		contentType, cancelled, tracedReq, err = download(ctx, trType, st, syncOp,
 			serverURL, auth, dsPath, dsCtx.Region,
 			config.Size, ifname, ipSrc, remoteName, locFilename, dst.DsCertPEM,
-			withNetTracing, traceOpts, receiveChan)
+			withNetTracing, traceOpts, limiters, receiveChan)
 		if withNetTracing {
 			tracedReq.RequestName = fmt.Sprintf("download-addr%d", addrIndex)
 			tracedReqs = append(tracedReqs, tracedReq)
@@ -305,6 +334,16 @@ func handleSyncOp(ctx *downloaderContext, key string,
 			log.Noticef("updated sizes at end to %d/%d",
 				size, size)
 		}
+		if trType == zedUpload.SyncOCIRegistryTr && isImageManifest(contentType) &&
+			config.ImageSha256 != "" && wantImageSignatures() {
+			// Signatures are optional, the verifier reports the image
+			// as unsigned if they cannot be fetched
+			err = fetchImageSignatures(ctx, dsCtx, serverURL, remoteName,
+				ifname, ipSrc, config.ImageSha256)
+			if err != nil {
+				log.Warnf("%s: no signatures: %v", config.Name, err)
+			}
+		}
 		if withNetTracing {
 			publishNetdump(ctx, true, tracedReqs)
 		}
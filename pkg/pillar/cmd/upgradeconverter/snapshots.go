@@ -0,0 +1,17 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package upgradeconverter
+
+import (
+	utils "github.com/lf-edge/eve/pkg/pillar/utils/file"
+)
+
+// moveSnapshotsToVault moves the snapshots of the app instances, which may
+// hold the memory of the apps, to the sealed directory
+func moveSnapshotsToVault(ctxPtr *ucContext) error {
+	log.Noticef("moveSnapshotsToVault()")
+	err := utils.MoveDir(log, ctxPtr.persistDir+"/snapshots", ctxPtr.persistDir+"/vault/snapshots")
+	log.Noticef("moveSnapshotsToVault() DONE")
+	return err
+}
@@ -66,6 +66,10 @@ var postVaultconversionHandlers = []ConversionHandler{
 		description: "Move old files to user containerd",
 		handlerFunc: moveToUserContainerd,
 	},
+	{
+		description: "Move app instance snapshots to /persist/vault",
+		handlerFunc: moveSnapshotsToVault,
+	},
 }
 
 type ucContext struct {
@@ -0,0 +1,290 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package verifier
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/lf-edge/eve/pkg/pillar/types"
+)
+
+const (
+	// Type of the payload of the cosign signatures
+	cosignSignatureType = "cosign container image signature"
+	// Type of the payload of the DSSE envelopes of in-toto attestations
+	inTotoPayloadType = "application/vnd.in-toto+json"
+)
+
+// signingKey is a public key trusted to sign the images
+type signingKey struct {
+	identity string
+	key      crypto.PublicKey
+}
+
+// cosignPayload is the part of the simple signing payload of a cosign
+// signature we check
+type cosignPayload struct {
+	Critical struct {
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+}
+
+// dsseEnvelope is a DSSE envelope of an in-toto attestation
+type dsseEnvelope struct {
+	PayloadType string `json:"payloadType"`
+	Payload     string `json:"payload"`
+	Signatures  []struct {
+		KeyID string `json:"keyid"`
+		Sig   string `json:"sig"`
+	} `json:"signatures"`
+}
+
+// inTotoStatement is the part of an in-toto statement we check
+type inTotoStatement struct {
+	Subject []struct {
+		Name   string            `json:"name"`
+		Digest map[string]string `json:"digest"`
+	} `json:"subject"`
+	PredicateType string `json:"predicateType"`
+}
+
+// parseSigningKeys returns the public keys and the keys of the certificates
+// in PEM data. Certificates are identified by their subject common name,
+// public keys by the sha256 of their DER encoding.
+func parseSigningKeys(data []byte) ([]signingKey, error) {
+	var keys []signingKey
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		switch block.Type {
+		case "PUBLIC KEY":
+			key, err := x509.ParsePKIXPublicKey(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, signingKey{
+				identity: fmt.Sprintf("sha256:%x", sha256.Sum256(block.Bytes)),
+				key:      key,
+			})
+		case "CERTIFICATE":
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			identity := cert.Subject.CommonName
+			if identity == "" {
+				identity = fmt.Sprintf("sha256:%x",
+					sha256.Sum256(cert.RawSubjectPublicKeyInfo))
+			}
+			keys = append(keys, signingKey{identity: identity, key: cert.PublicKey})
+		}
+	}
+	return keys, nil
+}
+
+// loadSigningKeys returns the keys in ImageSigningKeysFileName, none if
+// the device has none
+func loadSigningKeys() ([]signingKey, error) {
+	data, err := os.ReadFile(types.ImageSigningKeysFileName)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseSigningKeys(data)
+}
+
+// verifySignature returns nil if sig is the signature of payload with key
+func verifySignature(key crypto.PublicKey, payload, sig []byte) error {
+	hash := sha256.Sum256(payload)
+	switch key := key.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(key, hash[:], sig) {
+			return errors.New("invalid ECDSA signature")
+		}
+		return nil
+	case *rsa.PublicKey:
+		if rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], sig) == nil {
+			return nil
+		}
+		return rsa.VerifyPSS(key, crypto.SHA256, hash[:], sig, nil)
+	case ed25519.PublicKey:
+		if !ed25519.Verify(key, payload, sig) {
+			return errors.New("invalid Ed25519 signature")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported key type %T", key)
+	}
+}
+
+// findSigner returns the key which signed payload
+func findSigner(keys []signingKey, payload []byte, sig string) (*signingKey, error) {
+	rawSig, err := base64.StdEncoding.DecodeString(sig)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature encoding: %v", err)
+	}
+	for i := range keys {
+		if verifySignature(keys[i].key, payload, rawSig) == nil {
+			return &keys[i], nil
+		}
+	}
+	return nil, errors.New("not signed by any image signing key")
+}
+
+// verifyCosignSignature returns the identity of the key which signed the
+// image with imageSha256 if sig is one of its signatures
+func verifyCosignSignature(keys []signingKey, sig types.CosignSignature,
+	imageSha256 string) (string, error) {
+
+	key, err := findSigner(keys, sig.Payload, sig.Signature)
+	if err != nil {
+		return "", err
+	}
+	var payload cosignPayload
+	if err := json.Unmarshal(sig.Payload, &payload); err != nil {
+		return "", fmt.Errorf("invalid payload: %v", err)
+	}
+	if payload.Critical.Type != cosignSignatureType {
+		return "", fmt.Errorf("unexpected payload type %s", payload.Critical.Type)
+	}
+	if !strings.EqualFold(payload.Critical.Image.DockerManifestDigest,
+		"sha256:"+imageSha256) {
+		return "", fmt.Errorf("signature of %s",
+			payload.Critical.Image.DockerManifestDigest)
+	}
+	return key.identity, nil
+}
+
+// dssePAE returns the pre-authentication encoding of a DSSE payload, which
+// is what is signed
+func dssePAE(payloadType string, payload []byte) []byte {
+	return []byte(fmt.Sprintf("DSSEv1 %d %s %d %s", len(payloadType), payloadType,
+		len(payload), payload))
+}
+
+// verifyAttestation returns the predicate type of the attestation of the
+// image with imageSha256 in envelope if it is signed by one of keys
+func verifyAttestation(keys []signingKey, envelope []byte,
+	imageSha256 string) (string, error) {
+
+	var env dsseEnvelope
+	if err := json.Unmarshal(envelope, &env); err != nil {
+		return "", fmt.Errorf("invalid envelope: %v", err)
+	}
+	if env.PayloadType != inTotoPayloadType {
+		return "", fmt.Errorf("unexpected payload type %s", env.PayloadType)
+	}
+	payload, err := base64.StdEncoding.DecodeString(env.Payload)
+	if err != nil {
+		return "", fmt.Errorf("invalid payload encoding: %v", err)
+	}
+	signed := false
+	pae := dssePAE(env.PayloadType, payload)
+	for _, sig := range env.Signatures {
+		if _, err := findSigner(keys, pae, sig.Sig); err == nil {
+			signed = true
+			break
+		}
+	}
+	if !signed {
+		return "", errors.New("not signed by any image signing key")
+	}
+	var statement inTotoStatement
+	if err := json.Unmarshal(payload, &statement); err != nil {
+		return "", fmt.Errorf("invalid statement: %v", err)
+	}
+	for _, subject := range statement.Subject {
+		if strings.EqualFold(subject.Digest["sha256"], imageSha256) {
+			return statement.PredicateType, nil
+		}
+	}
+	return "", errors.New("attestation of another image")
+}
+
+// checkImageSignatures returns the identity of the key of the first valid
+// signature of the image and the predicate types of its valid attestations
+func checkImageSignatures(keys []signingKey, sigs types.ImageSignatures) (string, []string) {
+	var signer string
+	var attestations []string
+	for _, sig := range sigs.Signatures {
+		identity, err := verifyCosignSignature(keys, sig, sigs.ImageSha256)
+		if err != nil {
+			log.Warnf("checkImageSignatures(%s): ignoring signature: %v",
+				sigs.ImageSha256, err)
+			continue
+		}
+		signer = identity
+		break
+	}
+	for _, envelope := range sigs.Attestations {
+		predicateType, err := verifyAttestation(keys, envelope, sigs.ImageSha256)
+		if err != nil {
+			log.Warnf("checkImageSignatures(%s): ignoring attestation: %v",
+				sigs.ImageSha256, err)
+			continue
+		}
+		attestations = append(attestations, predicateType)
+	}
+	return signer, attestations
+}
+
+// verifyObjectSignature sets the signer and the attestations of the object
+// from the signatures the downloader found for it, if any. Unsigned objects
+// are not an error, volumemgr applies the policy.
+func verifyObjectSignature(status *types.VerifyImageStatus) {
+	status.Signer = ""
+	status.Attestations = nil
+	sha := strings.ToLower(status.ImageSha256)
+	data, err := os.ReadFile(filepath.Join(types.ImageSignaturesDirname, sha+".json"))
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Errorf("verifyObjectSignature(%s): %v", sha, err)
+		}
+		return
+	}
+	var sigs types.ImageSignatures
+	if err := json.Unmarshal(data, &sigs); err != nil {
+		log.Errorf("verifyObjectSignature(%s): %v", sha, err)
+		return
+	}
+	if !strings.EqualFold(sigs.ImageSha256, sha) {
+		log.Errorf("verifyObjectSignature(%s): signatures of %s",
+			sha, sigs.ImageSha256)
+		return
+	}
+	keys, err := loadSigningKeys()
+	if err != nil {
+		log.Errorf("verifyObjectSignature(%s): %s: %v", sha,
+			types.ImageSigningKeysFileName, err)
+		return
+	}
+	if len(keys) == 0 {
+		log.Warnf("verifyObjectSignature(%s): no image signing keys", sha)
+		return
+	}
+	status.Signer, status.Attestations = checkImageSignatures(keys, sigs)
+	log.Noticef("verifyObjectSignature(%s): signer %q attestations %v",
+		sha, status.Signer, status.Attestations)
+}
@@ -0,0 +1,172 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package verifier
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/lf-edge/eve/pkg/pillar/base"
+	"github.com/lf-edge/eve/pkg/pillar/types"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+const testImageSha = "2d5f0e1a2bfc1d2a4b6fb9d3c0b3d8e5e0e3c8f1a7a1f1c6ad0d1ff7f5c1a2b3"
+
+func cosignTestPayload(digest string) []byte {
+	return []byte(fmt.Sprintf(`{"critical":{"identity":{"docker-reference":"example.com/app"},`+
+		`"image":{"docker-manifest-digest":"%s"},"type":"%s"},"optional":null}`,
+		digest, cosignSignatureType))
+}
+
+func signTest(t *testing.T, key crypto.Signer, payload []byte) string {
+	var sig []byte
+	var err error
+	if _, ok := key.(ed25519.PrivateKey); ok {
+		sig, err = key.Sign(rand.Reader, payload, crypto.Hash(0))
+	} else {
+		hash := sha256.Sum256(payload)
+		sig, err = key.Sign(rand.Reader, hash[:], crypto.SHA256)
+	}
+	assert.NoError(t, err)
+	return base64.StdEncoding.EncodeToString(sig)
+}
+
+func attestationTest(t *testing.T, key crypto.Signer, digest string) []byte {
+	statement := fmt.Sprintf(`{"_type":"https://in-toto.io/Statement/v0.1",`+
+		`"subject":[{"name":"example.com/app","digest":{"sha256":"%s"}}],`+
+		`"predicateType":"https://slsa.dev/provenance/v0.2","predicate":{}}`, digest)
+	envelope := map[string]interface{}{
+		"payloadType": inTotoPayloadType,
+		"payload":     base64.StdEncoding.EncodeToString([]byte(statement)),
+		"signatures": []map[string]string{{
+			"sig": signTest(t, key, dssePAE(inTotoPayloadType, []byte(statement))),
+		}},
+	}
+	data, err := json.Marshal(envelope)
+	assert.NoError(t, err)
+	return data
+}
+
+func TestParseSigningKeys(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&ecKey.PublicKey)
+	assert.NoError(t, err)
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "release@example.com"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, &template, &template,
+		&ecKey.PublicKey, ecKey)
+	assert.NoError(t, err)
+	data := append(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})...)
+
+	keys, err := parseSigningKeys(data)
+	assert.NoError(t, err)
+	assert.Len(t, keys, 2)
+	assert.Equal(t, fmt.Sprintf("sha256:%x", sha256.Sum256(der)), keys[0].identity)
+	assert.Equal(t, "release@example.com", keys[1].identity)
+
+	_, err = parseSigningKeys(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY",
+		Bytes: []byte("garbage")}))
+	assert.Error(t, err)
+}
+
+func TestCheckImageSignatures(t *testing.T) {
+	log = base.NewSourceLogObject(logrus.StandardLogger(), "verifier", 0)
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	keys := []signingKey{
+		{identity: "ecdsa", key: ecKey.Public()},
+		{identity: "ed25519", key: edKey.Public()},
+	}
+	payload := cosignTestPayload("sha256:" + testImageSha)
+	otherPayload := cosignTestPayload("sha256:" + testImageSha[1:] + "0")
+
+	testMatrix := map[string]struct {
+		sigs         types.ImageSignatures
+		signer       string
+		attestations []string
+	}{
+		"unsigned": {},
+		"ecdsa": {
+			sigs: types.ImageSignatures{Signatures: []types.CosignSignature{
+				{Payload: payload, Signature: signTest(t, ecKey, payload)},
+			}},
+			signer: "ecdsa",
+		},
+		"ed25519": {
+			sigs: types.ImageSignatures{Signatures: []types.CosignSignature{
+				{Payload: payload, Signature: signTest(t, edKey, payload)},
+			}},
+			signer: "ed25519",
+		},
+		"untrusted key": {
+			sigs: types.ImageSignatures{Signatures: []types.CosignSignature{
+				{Payload: payload, Signature: signTest(t, otherKey, payload)},
+			}},
+		},
+		"tampered payload": {
+			sigs: types.ImageSignatures{Signatures: []types.CosignSignature{
+				{Payload: otherPayload, Signature: signTest(t, ecKey, payload)},
+			}},
+		},
+		"other image": {
+			sigs: types.ImageSignatures{Signatures: []types.CosignSignature{
+				{Payload: otherPayload, Signature: signTest(t, ecKey, otherPayload)},
+			}},
+		},
+		"second signature": {
+			sigs: types.ImageSignatures{Signatures: []types.CosignSignature{
+				{Payload: payload, Signature: signTest(t, otherKey, payload)},
+				{Payload: payload, Signature: signTest(t, edKey, payload)},
+			}},
+			signer: "ed25519",
+		},
+		"attestations": {
+			sigs: types.ImageSignatures{
+				Signatures: []types.CosignSignature{
+					{Payload: payload, Signature: signTest(t, ecKey, payload)},
+				},
+				Attestations: [][]byte{
+					attestationTest(t, ecKey, testImageSha),
+					attestationTest(t, otherKey, testImageSha),
+					attestationTest(t, ecKey, testImageSha[1:]+"0"),
+				},
+			},
+			signer:       "ecdsa",
+			attestations: []string{"https://slsa.dev/provenance/v0.2"},
+		},
+	}
+	for testname, test := range testMatrix {
+		t.Logf("Running test case %s", testname)
+		test.sigs.ImageSha256 = testImageSha
+		signer, attestations := checkImageSignatures(keys, test.sigs)
+		assert.Equal(t, test.signer, signer, testname)
+		assert.Equal(t, test.attestations, attestations, testname)
+	}
+}
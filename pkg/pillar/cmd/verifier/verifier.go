@@ -342,6 +342,7 @@ func handleCreate(ctx *verifierContext,
 		log.Errorf("handleCreate: verifyObjectSha failed for %s", config.Name)
 		return
 	}
+	verifyObjectSignature(&status)
 	publishVerifyImageStatus(ctx, &status)
 
 	markObjectAsVerified(config, &status, tmpID)
@@ -680,6 +681,7 @@ func populateInitialStatusFromVerified(ctx *verifierContext,
 					}
 					continue
 				}
+				verifyObjectSignature(status)
 				publishVerifyImageStatus(ctx, status)
 			}
 		}
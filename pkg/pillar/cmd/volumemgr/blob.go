@@ -12,6 +12,26 @@ import (
 	"github.com/lf-edge/eve/pkg/pillar/types"
 )
 
+// casSignerLabel is the label of the blobs in CAS which records the signer
+// the verifier reported, for it to be known after a reboot
+const casSignerLabel = "eve.signer"
+
+// maybeUpdateBlobBaseOS marks the blob as part of the base OS image if the
+// content tree which has it is. Returns whether or not the BlobStatus has
+// changed.
+func maybeUpdateBlobBaseOS(ctx *volumemgrContext, blob *types.BlobStatus,
+	isBaseOS bool) bool {
+
+	if !isBaseOS || blob.IsBaseOS {
+		return false
+	}
+	blob.IsBaseOS = true
+	if blob.HasDownloaderRef {
+		updateDownloaderFromBlob(ctx, *blob)
+	}
+	return true
+}
+
 // downloadBlob download a blob from a content tree
 // returns whether or not the BlobStatus has changed
 func downloadBlob(ctx *volumemgrContext, blob *types.BlobStatus) bool {
@@ -178,10 +198,26 @@ func updateBlobFromVerifyImageStatus(vs *types.VerifyImageStatus, blob *types.Bl
 		log.Functionf("updateBlobFromVerifyImageStatus(%s): updating Path to %s", blob.Sha256, blob.Path)
 		changed = true
 	}
+	if blob.Signer != vs.Signer {
+		blob.Signer = vs.Signer
+		log.Functionf("updateBlobFromVerifyImageStatus(%s): updating Signer to %s", blob.Sha256, blob.Signer)
+		changed = true
+	}
 
 	return changed
 }
 
+// labelBlobSigner records the signer of the blob loaded in CAS
+func labelBlobSigner(ctx *volumemgrContext, blob types.BlobStatus) error {
+	if blob.Signer == "" {
+		return nil
+	}
+	return ctx.casClient.UpdateBlobInfo(cas.BlobInfo{
+		Digest: "sha256:" + blob.Sha256,
+		Labels: map[string]string{casSignerLabel: blob.Signer},
+	})
+}
+
 // verifyBlob verify a blob, or latch onto an existing VerifyImageStatus.
 // First, check if a VerifyImageStatus exists. If so, check HasVerifierRef,
 // potentially incrementing creating or incrementing the refcount on a
@@ -546,6 +582,7 @@ func populateInitBlobStatus(ctx *volumemgrContext) {
 				TotalSize:              blobInfo.Size,
 				CurrentSize:            blobInfo.Size,
 				Progress:               100,
+				Signer:                 blobInfo.Labels[casSignerLabel],
 				LastRefCountChangeTime: time.Now(),
 				CreateTime:             time.Now(),
 			}
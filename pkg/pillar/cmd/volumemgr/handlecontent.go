@@ -42,6 +42,10 @@ func handleContentTreeModify(ctxArg interface{}, key string,
 	if status == nil {
 		log.Fatalf("Missing ContentTreeStatus for %s", config.Key())
 	}
+	if status.IsBaseOS != config.IsBaseOS {
+		status.IsBaseOS = config.IsBaseOS
+		publishContentTreeStatus(ctx, status)
+	}
 	updateContentTree(ctx, status)
 	log.Functionf("handleContentTree(%s) Done", key)
 }
@@ -180,6 +184,7 @@ func createContentTreeStatus(ctx *volumemgrContext, config types.ContentTreeConf
 			MaxDownloadSize:   config.MaxDownloadSize,
 			GenerationCounter: config.GenerationCounter,
 			DisplayName:       config.DisplayName,
+			IsBaseOS:          config.IsBaseOS,
 			State:             types.INITIAL,
 			Blobs:             []string{},
 			// LastRefCountChangeTime: time.Now(),
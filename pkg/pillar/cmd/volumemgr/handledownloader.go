@@ -64,6 +64,7 @@ func AddOrRefcountDownloaderConfig(ctx *volumemgrContext, blob types.BlobStatus)
 		Size:            size,
 		Target:          locFilename,
 		RefCount:        refCount,
+		IsBaseOS:        blob.IsBaseOS,
 	}
 	log.Functionf("AddOrRefcountDownloaderConfig: DownloaderConfig: %+v", n)
 	publishDownloaderConfig(ctx, &n)
@@ -175,6 +176,19 @@ func handleDownloaderStatusImpl(ctxArg interface{}, key string,
 	log.Functionf("handleDownloaderStatusImpl done for %s", status.ImageSha256)
 }
 
+// updateDownloaderFromBlob publishes the IsBaseOS of the blob in its
+// DownloaderConfig
+func updateDownloaderFromBlob(ctx *volumemgrContext, blob types.BlobStatus) {
+	m := lookupDownloaderConfig(ctx, blob.Sha256)
+	if m == nil {
+		return
+	}
+	log.Functionf("updateDownloaderFromBlob(%s) base OS %t",
+		blob.Sha256, blob.IsBaseOS)
+	m.IsBaseOS = blob.IsBaseOS
+	publishDownloaderConfig(ctx, m)
+}
+
 func lookupDownloaderConfig(ctx *volumemgrContext,
 	key string) *types.DownloaderConfig {
 
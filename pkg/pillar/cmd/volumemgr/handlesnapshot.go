@@ -30,6 +30,13 @@ func createSnapshot(ctx *volumemgrContext, config *types.VolumesSnapshotConfig)
 		SnapshotID:         config.SnapshotID,
 		VolumeSnapshotMeta: make(map[string]interface{}, len(config.VolumeIDs)),
 		AppUUID:            config.AppUUID,
+		GuestFrozen:        config.GuestFrozen,
+	}
+	// domainmgr froze the guest filesystems before stopping the app if its
+	// guest agent could
+	if !config.GuestFrozen {
+		log.Warnf("createSnapshot(%s): guest filesystems not frozen, snapshot is crash-consistent",
+			config.SnapshotID)
 	}
 	// Find the corresponding volume status
 	for _, volumeID := range config.VolumeIDs {
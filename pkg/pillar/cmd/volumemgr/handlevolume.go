@@ -119,12 +119,18 @@ func handleDeferredVolumeCreate(ctx *volumemgrContext, key string, config *types
 		RefCount:                config.RefCount,
 		Target:                  config.Target,
 		CustomMeta:              config.CustomMeta,
+		Directory:               config.Directory,
 		LastRefCountChangeTime:  time.Now(),
 		LastUse:                 time.Now(),
 		State:                   types.INITIAL,
 	}
 	updateVolumeStatusRefCount(ctx, status)
 	status.ContentFormat = volumeFormat[status.Key()]
+	if _, found := volumeFormat[status.Key()]; found {
+		// An existing volume stays a disk or a directory whatever the
+		// config says now, not to lose its content
+		status.Directory = volumeDirectory[status.Key()]
+	}
 
 	created, err := volumehandlers.GetVolumeHandler(log, ctx, status).Populate()
 	if err != nil {
@@ -17,6 +17,11 @@ const (
 	workCreate  = "create"
 	workIngest  = "ingest"
 	workPrepare = "prepare"
+
+	// Work waiting for one of the workers
+	workQueueLength = 100
+	// Rate-limit of the WorkerMetrics
+	workerMetricsWindow = 10 * time.Second
 )
 
 // volumeWorkDescription volume creation/deletion work we feed into the worker go routine.
@@ -66,15 +71,7 @@ func AddWorkCreate(ctx *volumemgrContext, status *types.VolumeStatus) {
 		status: *status,
 	}
 	w := worker.Work{Kind: workCreate, Key: status.Key(), Description: d}
-	// Don't fail on errors to make idempotent (Submit returns an error if
-	// the work was already submitted)
-	done, err := ctx.worker.TrySubmit(w)
-	if err != nil {
-		log.Errorf("TrySubmit %s failed: %s", status.Key(), err)
-	} else if !done {
-		log.Fatalf("Failed to submit work due to queue length for %s",
-			status.Key())
-	}
+	submitWork(ctx, w)
 }
 
 // AddWorkLoad adds a Work job to load an image and blobs into CAS
@@ -83,15 +80,7 @@ func AddWorkLoad(ctx *volumemgrContext, status *types.ContentTreeStatus) {
 		status: *status,
 	}
 	w := worker.Work{Kind: workIngest, Key: status.Key(), Description: d}
-	// Don't fail on errors to make idempotent (Submit returns an error if
-	// the work was already submitted)
-	done, err := ctx.worker.TrySubmit(w)
-	if err != nil {
-		log.Errorf("TrySubmit %s failed: %s", status.Key(), err)
-	} else if !done {
-		log.Fatalf("Failed to submit work due to queue length for %s",
-			status.Key())
-	}
+	submitWork(ctx, w)
 }
 
 // AddWorkPrepare adds a Work job to create a volume
@@ -101,15 +90,37 @@ func AddWorkPrepare(ctx *volumemgrContext, status *types.VolumeStatus) {
 		status:  *status,
 	}
 	w := worker.Work{Kind: workPrepare, Key: status.Key(), Description: d}
+	submitWork(ctx, w)
+}
+
+// submitWork submits the work to the worker
+func submitWork(ctx *volumemgrContext, w worker.Work) {
 	// Don't fail on errors to make idempotent (Submit returns an error if
 	// the work was already submitted)
 	done, err := ctx.worker.TrySubmit(w)
 	if err != nil {
-		log.Errorf("TrySubmit %s failed: %s", status.Key(), err)
+		log.Errorf("TrySubmit %s failed: %s", w.Key, err)
 	} else if !done {
 		log.Fatalf("Failed to submit work due to queue length for %s",
-			status.Key())
+			w.Key)
 	}
+	publishWorkerMetrics(ctx)
+}
+
+// publishWorkerMetrics publishes the number of pending work items
+func publishWorkerMetrics(ctx *volumemgrContext) {
+	metrics := types.WorkerMetrics{
+		AgentName: agentName,
+		Pending: map[string]int{
+			worker.PriorityLow.String():    0,
+			worker.PriorityNormal.String(): 0,
+			worker.PriorityHigh.String():   0,
+		},
+	}
+	for priority, count := range ctx.worker.NumPendingByPriority() {
+		metrics.Pending[priority.String()] = count
+	}
+	ctx.pubWorkerMetrics.Publish(metrics.Key(), metrics)
 }
 
 // DeleteWorkCreate is called by user when work is done
@@ -133,16 +144,10 @@ func AddWorkDestroy(ctx *volumemgrContext, status *types.VolumeStatus) {
 		destroy: true,
 		status:  *status,
 	}
-	w := worker.Work{Kind: workCreate, Key: status.Key(), Description: d}
-	// Don't fail on errors to make idempotent (Submit returns an error if
-	// the work was already submitted)
-	done, err := ctx.worker.TrySubmit(w)
-	if err != nil {
-		log.Errorf("TrySubmit %s failed: %s", status.Key(), err)
-	} else if !done {
-		log.Fatalf("Failed to submit work due to queue length for %s",
-			status.Key())
-	}
+	// Behind the volumes app instances are waiting for
+	w := worker.Work{Kind: workCreate, Key: status.Key(), Description: d,
+		Priority: worker.PriorityLow}
+	submitWork(ctx, w)
 }
 
 // DeleteWorkDestroy cancels a job to destroy a volume
@@ -248,6 +253,9 @@ func casIngestWorker(ctxPtr interface{}, w worker.Work) worker.WorkResult {
 	if err != nil {
 		result.Error = err
 		result.ErrorTime = time.Now()
+	} else if err := labelBlobSigner(ctx, *root); err != nil {
+		log.Errorf("casIngestWorker(%s): labelBlobSigner failed: %v",
+			root.Sha256, err)
 	}
 	return result
 }
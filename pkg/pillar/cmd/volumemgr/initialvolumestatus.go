@@ -39,6 +39,7 @@ func populateExistingVolumesFormatObjects(_ *volumemgrContext, dirName string) {
 			continue
 		}
 		volumeFormat[tempStatus.Key()] = tempStatus.ContentFormat
+		volumeDirectory[tempStatus.Key()] = tempStatus.Directory
 	}
 	log.Functionf("populateExistingVolumesFormatObjects(%s) Done", dirName)
 }
@@ -117,7 +118,7 @@ func gcVolumes(ctx *volumemgrContext, locations []string) {
 }
 
 func getVolumeStatusByLocation(location string) (*types.VolumeStatus, error) {
-	var encrypted bool
+	var encrypted, directory bool
 	var parsedFormat int32
 	var volumeIDAndGeneration string
 
@@ -133,10 +134,14 @@ func getVolumeStatusByLocation(location string) (*types.VolumeStatus, error) {
 			return nil, fmt.Errorf("found unknown format volume %s", location)
 		}
 		volumeIDAndGeneration = keyAndFormat[0]
-		ok := false
-		parsedFormat, ok = zconfig.Format_value[strings.ToUpper(keyAndFormat[1])]
-		if !ok {
-			return nil, fmt.Errorf("found unknown format volume %s", location)
+		if keyAndFormat[1] == types.VolumeDirectoryExtension {
+			directory = true
+		} else {
+			ok := false
+			parsedFormat, ok = zconfig.Format_value[strings.ToUpper(keyAndFormat[1])]
+			if !ok {
+				return nil, fmt.Errorf("found unknown format volume %s", location)
+			}
 		}
 		volumeIDAndGeneration = strings.ReplaceAll(volumeIDAndGeneration, "#", ".")
 	}
@@ -161,6 +166,7 @@ func getVolumeStatusByLocation(location string) (*types.VolumeStatus, error) {
 		GenerationCounter: generationCounter,
 		ContentFormat:     zconfig.Format(parsedFormat),
 		FileLocation:      location,
+		Directory:         directory,
 	}
 	return &vs, nil
 }
@@ -165,6 +165,9 @@ func doUpdateContentTree(ctx *volumemgrContext, status *types.ContentTreeStatus)
 			}
 			totalSize += blob.TotalSize
 			currentSize += blob.CurrentSize
+			if maybeUpdateBlobBaseOS(ctx, blob, status.IsBaseOS) {
+				publishBlobStatus(ctx, blob)
+			}
 
 			// now the type should not be unknown (unless it is in error state)
 			// these calls might update Blob.State hence we check
@@ -266,6 +269,12 @@ func doUpdateContentTree(ctx *volumemgrContext, status *types.ContentTreeStatus)
 			status.FileLocation = rootBlob.Path
 			changed = true
 		}
+		if status.Signer != rootBlob.Signer {
+			log.Functionf("doUpdateContentTree(%s) name %s: signed by %q",
+				status.Key(), status.DisplayName, rootBlob.Signer)
+			status.Signer = rootBlob.Signer
+			changed = true
+		}
 
 		// update errors from blobs to status
 		if len(blobErrors) != 0 {
@@ -533,7 +542,9 @@ func doUpdateVol(ctx *volumemgrContext, status *types.VolumeStatus) (bool, bool)
 			}
 			status.State = types.CREATING_VOLUME
 			status.ReferenceName = "" //set empty for blank volume
-			status.ContentFormat = blankVolumeFormat
+			if !status.Directory {
+				status.ContentFormat = blankVolumeFormat
+			}
 			status.TotalSize = int64(status.MaxVolSize)
 			status.CurrentSize = int64(status.MaxVolSize)
 			changed = true
@@ -603,6 +614,29 @@ func doUpdateVol(ctx *volumemgrContext, status *types.VolumeStatus) (bool, bool)
 				return changed, false
 			}
 
+			if ctx.globalConfig.GlobalValueBool(types.AppRequireSignedContent) &&
+				ctStatus.Signer == "" {
+				description := types.ErrorDescription{}
+				description.Error = fmt.Sprintf("Content tree %s of volume %s is not signed with any image signing key",
+					ctStatus.DisplayName, status.DisplayName)
+				// The signatures are only checked when the blobs are
+				// verified, new keys do not apply to the loaded blobs
+				description.ErrorRetryCondition = fmt.Sprintf("Will retry when %s is disabled; "+
+					"signing keys or signatures added since the download only apply once the content tree is deployed again",
+					types.AppRequireSignedContent)
+				// do not touch time of the error with the same content
+				if status.Error != description.Error {
+					status.SetErrorWithSourceAndDescription(description, types.VerifyImageStatus{})
+					changed = true
+				}
+				return changed, false
+			}
+			if status.IsErrorSource(types.VerifyImageStatus{}) {
+				log.Functionf("doUpdateVol: Clearing signature error %s", status.Error)
+				status.ClearErrorWithSource()
+				changed = true
+			}
+
 			status.State = types.CREATING_VOLUME
 			// first blob is always the root
 			if len(ctStatus.Blobs) < 1 {
@@ -921,3 +955,25 @@ func updateVolumeStatusFromContentID(ctx *volumemgrContext, contentID uuid.UUID)
 		log.Warnf("XXX updateVolumeStatusFromContentID(%s) NOT FOUND", contentID)
 	}
 }
+
+// updateUnsignedVolumes retries the volumes which were not created because
+// their content is not signed
+func updateUnsignedVolumes(ctx *volumemgrContext) {
+
+	log.Functionf("updateUnsignedVolumes")
+	pub := ctx.pubVolumeStatus
+	items := pub.GetAll()
+	for _, st := range items {
+		status := st.(types.VolumeStatus)
+		if !status.IsErrorSource(types.VerifyImageStatus{}) {
+			continue
+		}
+		log.Functionf("updateUnsignedVolumes: updating %s: name %s",
+			status.Key(), status.DisplayName)
+		changed, _ := doUpdateVol(ctx, &status)
+		if changed {
+			publishVolumeStatus(ctx, &status)
+			updateVolumeRefStatus(ctx, &status)
+		}
+	}
+}
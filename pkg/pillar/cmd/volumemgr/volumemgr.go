@@ -45,6 +45,9 @@ var Version = "No version specified"
 
 var volumeFormat = make(map[string]zconfig.Format)
 
+// volumeDirectory is set for the existing volumes created as a directory
+var volumeDirectory = make(map[string]bool)
+
 type volumemgrContext struct {
 	agentbase.AgentBase
 	ps                *pubsub.PubSub
@@ -73,6 +76,7 @@ type volumemgrContext struct {
 	pubVolumeCreatePending  pubsub.Publication
 	subVolumesSnapConfig    pubsub.Subscription
 	pubVolumesSnapStatus    pubsub.Publication
+	pubWorkerMetrics        pubsub.Publication
 	diskMetricsTickerHandle interface{}
 	gc                      *time.Ticker
 	deferDelete             *time.Ticker
@@ -253,7 +257,9 @@ func Run(ps *pubsub.PubSub, loggerArg *logrus.Logger, logArg *base.LogObject, ar
 	populateExistingVolumesFormatObjects(&ctx, volumeClearDirName)
 
 	// Create the background worker
-	ctx.worker = worker.NewPool(log, &ctx, 20, map[string]worker.Handler{
+	// Work waits in the queue of the pool in order of priority when all
+	// the workers are busy
+	ctx.worker = worker.NewPoolWithQueue(log, &ctx, 20, workQueueLength, map[string]worker.Handler{
 		workCreate:  {Request: volumeWorker, Response: processVolumeWorkResult},
 		workIngest:  {Request: casIngestWorker, Response: processCasIngestWorkResult},
 		workPrepare: {Request: volumePrepareWorker, Response: processVolumePrepareResult},
@@ -357,6 +363,18 @@ func Run(ps *pubsub.PubSub, loggerArg *logrus.Logger, logArg *base.LogObject, ar
 	}
 	ctx.pubAppDiskMetric = pubAppDiskMetric
 
+	pubWorkerMetrics, err := ps.NewPublication(
+		pubsub.PublicationOptions{
+			AgentName:      agentName,
+			TopicType:      types.WorkerMetrics{},
+			CoalesceWindow: workerMetricsWindow,
+		},
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx.pubWorkerMetrics = pubWorkerMetrics
+
 	// Look for global config such as log levels
 	subZedAgentStatus, err := ps.NewSubscription(pubsub.SubscriptionOptions{
 		AgentName:     "zedagent",
@@ -587,6 +605,7 @@ func Run(ps *pubsub.PubSub, loggerArg *logrus.Logger, logArg *base.LogObject, ar
 
 		case res := <-ctx.worker.MsgChan():
 			res.Process(&ctx, true)
+			publishWorkerMetrics(&ctx)
 
 		case <-stillRunning.C:
 		}
@@ -675,6 +694,7 @@ func Run(ps *pubsub.PubSub, loggerArg *logrus.Logger, logArg *base.LogObject, ar
 
 		case res := <-ctx.worker.MsgChan():
 			res.Process(&ctx, true)
+			publishWorkerMetrics(&ctx)
 
 		case <-stillRunning.C:
 		}
@@ -722,8 +742,13 @@ func handleGlobalConfigImpl(ctxArg interface{}, key string,
 		ctx.CLIParams().DebugOverride, logger)
 	if gcp != nil {
 		maybeUpdateConfigItems(ctx, gcp)
+		requireSigned := ctx.globalConfig.GlobalValueBool(types.AppRequireSignedContent)
 		ctx.globalConfig = gcp
 		ctx.GCInitialized = true
+		if requireSigned && !gcp.GlobalValueBool(types.AppRequireSignedContent) {
+			// Create the volumes refused because of their unsigned content
+			updateUnsignedVolumes(ctx)
+		}
 	}
 	log.Functionf("handleGlobalConfigImpl done for %s", key)
 }
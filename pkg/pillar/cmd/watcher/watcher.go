@@ -29,11 +29,11 @@ type watcherContext struct {
 	agentbase.AgentBase
 	ps              *pubsub.PubSub
 	subGlobalConfig pubsub.Subscription
-	subHostMemory   pubsub.Subscription
-	subDiskMetric   pubsub.Subscription
+	subHostMemory   *pubsub.TypedSubscription[types.HostMemory]
+	subDiskMetric   *pubsub.TypedSubscription[types.DiskMetric]
 
-	pubMemoryNotification pubsub.Publication
-	pubDiskNotification   pubsub.Publication
+	pubMemoryNotification *pubsub.TypedPublication[types.MemoryNotification]
+	pubDiskNotification   *pubsub.TypedPublication[types.DiskNotification]
 
 	GCInitialized bool
 	// cli options
@@ -96,10 +96,9 @@ func Run(ps *pubsub.PubSub, loggerArg *logrus.Logger, logArg *base.LogObject, ar
 	ctx.subGlobalConfig = subGlobalConfig
 	subGlobalConfig.Activate()
 
-	pubMemNotif, err := ps.NewPublication(
+	pubMemNotif, err := pubsub.NewTypedPublication[types.MemoryNotification](ps,
 		pubsub.PublicationOptions{
 			AgentName: agentName,
-			TopicType: types.MemoryNotification{},
 		},
 	)
 	if err != nil {
@@ -107,10 +106,9 @@ func Run(ps *pubsub.PubSub, loggerArg *logrus.Logger, logArg *base.LogObject, ar
 	}
 	ctx.pubMemoryNotification = pubMemNotif
 
-	pubDiskNotif, err := ps.NewPublication(
+	pubDiskNotif, err := pubsub.NewTypedPublication[types.DiskNotification](ps,
 		pubsub.PublicationOptions{
 			AgentName: agentName,
-			TopicType: types.DiskNotification{},
 		},
 	)
 	if err != nil {
@@ -118,35 +116,39 @@ func Run(ps *pubsub.PubSub, loggerArg *logrus.Logger, logArg *base.LogObject, ar
 	}
 	ctx.pubDiskNotification = pubDiskNotif
 
-	subHostMemory, err := ps.NewSubscription(pubsub.SubscriptionOptions{
-		AgentName:     "domainmgr",
-		MyAgentName:   agentName,
-		TopicImpl:     types.HostMemory{},
-		Activate:      true,
-		Ctx:           &ctx,
-		CreateHandler: handleHostMemoryCreate,
-		ModifyHandler: handleHostMemoryModify,
-		DeleteHandler: handleHostMemoryDelete,
-		WarningTime:   warningTime,
-		ErrorTime:     errorTime,
-	})
+	subHostMemory, err := pubsub.NewTypedSubscription(ps,
+		pubsub.TypedSubscriptionOptions[types.HostMemory]{
+			SubscriptionOptions: pubsub.SubscriptionOptions{
+				AgentName:   "domainmgr",
+				MyAgentName: agentName,
+				Activate:    true,
+				Ctx:         &ctx,
+				WarningTime: warningTime,
+				ErrorTime:   errorTime,
+			},
+			CreateHandler: handleHostMemoryCreate,
+			ModifyHandler: handleHostMemoryModify,
+			DeleteHandler: handleHostMemoryDelete,
+		})
 	if err != nil {
 		log.Fatal(err)
 	}
 	ctx.subHostMemory = subHostMemory
 
-	subDiskMetric, err := ps.NewSubscription(pubsub.SubscriptionOptions{
-		AgentName:     "volumemgr",
-		MyAgentName:   agentName,
-		TopicImpl:     types.DiskMetric{},
-		Activate:      true,
-		Ctx:           &ctx,
-		CreateHandler: handleDiskMetricCreate,
-		ModifyHandler: handleDiskMetricModify,
-		DeleteHandler: handleDiskMetricDelete,
-		WarningTime:   warningTime,
-		ErrorTime:     errorTime,
-	})
+	subDiskMetric, err := pubsub.NewTypedSubscription(ps,
+		pubsub.TypedSubscriptionOptions[types.DiskMetric]{
+			SubscriptionOptions: pubsub.SubscriptionOptions{
+				AgentName:   "volumemgr",
+				MyAgentName: agentName,
+				Activate:    true,
+				Ctx:         &ctx,
+				WarningTime: warningTime,
+				ErrorTime:   errorTime,
+			},
+			CreateHandler: handleDiskMetricCreate,
+			ModifyHandler: handleDiskMetricModify,
+			DeleteHandler: handleDiskMetricDelete,
+		})
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -262,8 +264,7 @@ func getUsageSlab(used uint64, prevUsage uint64, total uint64) uint64 {
 }
 
 func handleHostMemoryCreate(ctxArg interface{}, key string,
-	statusArg interface{}) {
-	status := statusArg.(types.HostMemory)
+	status types.HostMemory) {
 	ctx := ctxArg.(*watcherContext)
 
 	memNotif := makeHostMemoryNotification(status)
@@ -274,8 +275,7 @@ func handleHostMemoryCreate(ctxArg interface{}, key string,
 }
 
 func handleHostMemoryModify(ctxArg interface{}, key string,
-	statusArg interface{}, oldStatusArg interface{}) {
-	status := statusArg.(types.HostMemory)
+	status types.HostMemory, oldStatus types.HostMemory) {
 	ctx := ctxArg.(*watcherContext)
 
 	memNotif := makeHostMemoryNotification(status)
@@ -298,15 +298,14 @@ func handleHostMemoryModify(ctxArg interface{}, key string,
 }
 
 func handleHostMemoryDelete(ctxArg interface{}, key string,
-	statusArg interface{}) {
+	status types.HostMemory) {
 	ctx := ctxArg.(*watcherContext)
 	ctx.pubMemoryNotification.Publish("global", types.MemoryNotification{})
 	log.Functionf("handleHostMemoryDelete:")
 }
 
 func handleDiskMetricCreate(ctxArg interface{}, key string,
-	statusArg interface{}) {
-	status := statusArg.(types.DiskMetric)
+	status types.DiskMetric) {
 	ctx := ctxArg.(*watcherContext)
 
 	if !isPersistMetric(status) {
@@ -322,8 +321,7 @@ func handleDiskMetricCreate(ctxArg interface{}, key string,
 }
 
 func handleDiskMetricModify(ctxArg interface{}, key string,
-	statusArg interface{}, oldStatusArg interface{}) {
-	status := statusArg.(types.DiskMetric)
+	status types.DiskMetric, oldStatus types.DiskMetric) {
 	ctx := ctxArg.(*watcherContext)
 
 	if !isPersistMetric(status) {
@@ -350,8 +348,7 @@ func handleDiskMetricModify(ctxArg interface{}, key string,
 }
 
 func handleDiskMetricDelete(ctxArg interface{}, key string,
-	statusArg interface{}) {
-	status := statusArg.(types.DiskMetric)
+	status types.DiskMetric) {
 	ctx := ctxArg.(*watcherContext)
 
 	if !isPersistMetric(status) {
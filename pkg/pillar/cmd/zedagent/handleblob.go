@@ -30,6 +30,7 @@ func handleBlobStatusImpl(ctxArg interface{}, key string,
 	uuidStr := status.Key()
 	PublishBlobInfoToZedCloud(ctx, uuidStr, &status, ctx.iteration, AllDest)
 	ctx.iteration++
+	republishBlockedApps(ctx)
 }
 
 func handleBlobDelete(ctxArg interface{}, key string, statusArg interface{}) {
@@ -38,4 +39,5 @@ func handleBlobDelete(ctxArg interface{}, key string, statusArg interface{}) {
 	uuidStr := status.Key()
 	PublishBlobInfoToZedCloud(ctx, uuidStr, nil, ctx.iteration, AllDest)
 	ctx.iteration++
+	republishBlockedApps(ctx)
 }
@@ -53,10 +53,12 @@ func parseContentInfoConfig(ctx *getconfigContext,
 
 	log.Tracef("Started parsing content info config")
 	cfgContentTreeList := config.GetContentInfo()
+	baseOSContentTreeUUID := config.GetBaseos().GetContentTreeUuid()
 	h := sha256.New()
 	for _, cfgContentTree := range cfgContentTreeList {
 		computeConfigElementSha(h, cfgContentTree)
 	}
+	h.Write([]byte(baseOSContentTreeUUID))
 	newHash := h.Sum(nil)
 	if bytes.Equal(newHash, contentInfoHash) {
 		return
@@ -96,6 +98,7 @@ func parseContentInfoConfig(ctx *getconfigContext,
 		contentConfig.MaxDownloadSize = cfgContentTree.GetMaxSizeBytes()
 		contentConfig.DisplayName = cfgContentTree.GetDisplayName()
 		contentConfig.CustomMeta = cfgContentTree.GetCustomMetaData()
+		contentConfig.IsBaseOS = cfgContentTree.GetUuid() == baseOSContentTreeUUID
 		publishContentTreeConfig(ctx, *contentConfig)
 	}
 	ctx.pubContentTreeConfig.SignalRestarted()
@@ -143,6 +146,7 @@ func handleContentTreeStatusImpl(ctxArg interface{}, key string,
 	uuidStr := status.Key()
 	PublishContentInfoToZedCloud(ctx, uuidStr, &status, ctx.iteration, AllDest)
 	ctx.iteration++
+	republishBlockedApps(ctx)
 }
 
 func handleContentTreeStatusDelete(ctxArg interface{}, key string,
@@ -152,4 +156,5 @@ func handleContentTreeStatusDelete(ctxArg interface{}, key string,
 	uuidStr := key
 	PublishContentInfoToZedCloud(ctx, uuidStr, nil, ctx.iteration, AllDest)
 	ctx.iteration++
+	republishBlockedApps(ctx)
 }
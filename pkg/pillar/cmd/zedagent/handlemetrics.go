@@ -23,8 +23,10 @@ import (
 	"github.com/lf-edge/eve-api/go/metrics"
 	zmet "github.com/lf-edge/eve-api/go/metrics" // zinfo and zmet here
 	"github.com/lf-edge/eve/pkg/pillar/flextimer"
+	"github.com/lf-edge/eve/pkg/pillar/objdeps"
 	"github.com/lf-edge/eve/pkg/pillar/types"
 	"github.com/lf-edge/eve/pkg/pillar/utils"
+	"github.com/lf-edge/eve/pkg/pillar/utils/generics"
 	"github.com/lf-edge/eve/pkg/pillar/vault"
 	"github.com/lf-edge/eve/pkg/pillar/zedcloud"
 	"github.com/shirou/gopsutil/host"
@@ -391,6 +393,20 @@ func publishMetrics(ctx *zedagentContext, iteration int) {
 	zedboxStats.NumGoRoutines = uint32(runtime.NumGoroutine()) // number of zedbox goroutines
 	ReportDeviceMetric.Zedbox = zedboxStats
 
+	// Queue pressure of the background workers
+	for _, m := range ctx.subWorkerMetrics.GetAll() {
+		wm := m.(types.WorkerMetrics)
+		for priority, count := range wm.Pending {
+			item := metrics.MetricItem{
+				Key:  fmt.Sprintf("%s-worker-pending-%s", wm.AgentName, priority),
+				Type: metrics.MetricItemType_MetricItemGauge,
+			}
+			setMetricAnyValue(&item, uint32(count))
+			ReportDeviceMetric.MetricItems = append(ReportDeviceMetric.MetricItems,
+				&item)
+		}
+	}
+
 	// Transfer to a local copy in since metrics updates are done concurrently
 	cms := types.MetricsMap{}
 	ctx.zedcloudMetrics.AddInto(log, cms)
@@ -1031,6 +1047,147 @@ func encodeProxyStatus(proxyConfig *types.ProxyConfig) *info.ProxyStatus {
 	return status
 }
 
+// appBlocker returns the object in another agent which blocks the app
+// instance with an error, unless the app instance already reports that
+// error. It is looked up in ctx.appDeps if set.
+func appBlocker(ctx *zedagentContext,
+	aiStatus *types.AppInstanceStatus) *objdeps.Object {
+
+	graph := ctx.appDeps
+	if graph == nil {
+		registry := ctx.ps.Registry()
+		if registry == nil {
+			// Not running in zedbox
+			return nil
+		}
+		graph = objdeps.New(registry)
+	}
+	app, err := graph.App(aiStatus.Key())
+	if err != nil {
+		return nil
+	}
+	blocker := objdeps.Blocker(app)
+	if blocker == nil || blocker.Ref == app.Ref || !blocker.HasError() ||
+		blocker.Error.Error == aiStatus.Error {
+		return nil
+	}
+	return blocker
+}
+
+// appBlockerSummary identifies the blocker and its error, to tell when
+// the blocker reported for an app instance is outdated
+func appBlockerSummary(blocker *objdeps.Object) string {
+	if blocker == nil {
+		return ""
+	}
+	return blocker.Ref.String() + ": " + blocker.Error.Error
+}
+
+// appBlockerErrorInfo returns an ErrorInfo naming the object in another agent
+// which blocks the app instance with an error, unless the app instance
+// already reports that error. The blocker is kept in ctx.appBlockers.
+func appBlockerErrorInfo(ctx *zedagentContext,
+	aiStatus *types.AppInstanceStatus) *info.ErrorInfo {
+
+	blocker := appBlocker(ctx, aiStatus)
+	ctx.appBlockers[aiStatus.Key()] = appBlockerSummary(blocker)
+	if blocker == nil {
+		return nil
+	}
+	errDescription := blocker.Error
+	errDescription.Error = "Blocked by " + objdeps.Describe(blocker)
+	if len(errDescription.ErrorEntities) == 0 {
+		if entity := blocker.Entity(); entity != nil {
+			errDescription.ErrorEntities = []*types.ErrorEntity{entity}
+		}
+	}
+	return encodeErrorInfo(errDescription)
+}
+
+// republishBlockedApps republishes the info of the app instances whose
+// blocker changed since their info was last published. The blocker is
+// only computed when the info is published, hence this is called when
+// an object the app instances depend on changes e.g., a volume, a content
+// tree or a blob, whose status reflects the downloads and verifications.
+// The app instances are all looked up in one snapshot of the objects.
+func republishBlockedApps(ctx *zedagentContext) {
+	registry := ctx.ps.Registry()
+	if registry == nil {
+		return
+	}
+	sub := ctx.getconfigCtx.subAppInstanceStatus
+	if sub == nil {
+		return
+	}
+	ctx.appDeps = objdeps.New(registry)
+	defer func() { ctx.appDeps = nil }()
+	for _, st := range sub.GetAll() {
+		aiStatus := st.(types.AppInstanceStatus)
+		uuidStr := aiStatus.Key()
+		summary := appBlockerSummary(appBlocker(ctx, &aiStatus))
+		if summary == ctx.appBlockers[uuidStr] {
+			continue
+		}
+		log.Functionf("republishBlockedApps(%s): blocker now %q",
+			uuidStr, summary)
+		PublishAppInfoToZedCloud(ctx, uuidStr, &aiStatus,
+			ctx.assignableAdapters, ctx.iteration, AllDest)
+		ctx.iteration++
+	}
+}
+
+// appCrashDumpErrorInfo returns a notice of the latest memory dump taken
+// when the kernel of the app instance panicked, if kept, for it to be
+// retrieved e.g. with edge-view
+func appCrashDumpErrorInfo(ctx *zedagentContext,
+	aiStatus *types.AppInstanceStatus) *info.ErrorInfo {
+
+	if ctx.subAppCrashDump == nil {
+		return nil
+	}
+	var latest *types.AppCrashDump
+	for _, d := range ctx.subAppCrashDump.GetAll() {
+		dump := d.(types.AppCrashDump)
+		if dump.AppUUID != aiStatus.UUIDandVersion.UUID {
+			continue
+		}
+		if latest == nil || dump.Time.After(latest.Time) {
+			latest = &dump
+		}
+	}
+	if latest == nil {
+		return nil
+	}
+	return encodeErrorInfo(types.ErrorDescription{
+		Error: fmt.Sprintf("Kernel panicked, memory dump of %d bytes kept in %s",
+			latest.Size, latest.FileName),
+		ErrorTime:     latest.Time,
+		ErrorSeverity: types.ErrorSeverityNotice,
+	})
+}
+
+// guestIPAddrs returns the addresses the guest agent of the app, if alive,
+// reports on its interface with macAddr
+func guestIPAddrs(aiStatus *types.AppInstanceStatus, macAddr net.HardwareAddr) []string {
+	if !aiStatus.GuestInfo.Alive || macAddr == nil {
+		return nil
+	}
+	var ipAddrs []string
+	for _, intf := range aiStatus.GuestInfo.Interfaces {
+		if !strings.EqualFold(intf.MacAddr, macAddr.String()) {
+			continue
+		}
+		for _, ipAddr := range intf.IPAddrs {
+			ip, _, err := net.ParseCIDR(ipAddr)
+			if err != nil || ip.IsLinkLocalUnicast() {
+				continue
+			}
+			ipAddrs = append(ipAddrs, ip.String())
+		}
+	}
+	return ipAddrs
+}
+
 // This function is called per change, hence needs to try over all management ports
 // When aiStatus is nil it means a delete and we send a message
 // containing only the UUID to inform zedcloud about the delete.
@@ -1060,6 +1217,12 @@ func PublishAppInfoToZedCloud(ctx *zedagentContext, uuid string,
 			ReportAppInfo.AppErr = append(ReportAppInfo.AppErr,
 				errInfo)
 		}
+		if errInfo := appBlockerErrorInfo(ctx, aiStatus); errInfo != nil {
+			ReportAppInfo.AppErr = append(ReportAppInfo.AppErr, errInfo)
+		}
+		if errInfo := appCrashDumpErrorInfo(ctx, aiStatus); errInfo != nil {
+			ReportAppInfo.AppErr = append(ReportAppInfo.AppErr, errInfo)
+		}
 
 		if aiStatus.BootTime.IsZero() {
 			// If never booted
@@ -1112,6 +1275,12 @@ func PublishAppInfoToZedCloud(ctx *zedagentContext, uuid string,
 			for _, ipv6Addr := range ipv6Addrs {
 				networkInfo.IPAddrs = append(networkInfo.IPAddrs, ipv6Addr.String())
 			}
+			// Add what the guest agent sees, e.g. static addresses
+			for _, ipAddr := range guestIPAddrs(aiStatus, macAddr) {
+				if !generics.ContainsItem(networkInfo.IPAddrs, ipAddr) {
+					networkInfo.IPAddrs = append(networkInfo.IPAddrs, ipAddr)
+				}
+			}
 			networkInfo.MacAddr = *proto.String(macAddr.String())
 			networkInfo.Ipv4Up = allocated
 			networkInfo.IpAddrMisMatch = ipAddrMismatch
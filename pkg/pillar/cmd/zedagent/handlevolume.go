@@ -103,6 +103,7 @@ func parseVolumeConfig(ctx *getconfigContext,
 		volumeConfig.RefCount = 1
 		volumeConfig.HasNoAppReferences = checkVolumeHasNoAppReferences(ctx, cfgVolume, config)
 		volumeConfig.Target = cfgVolume.GetTarget()
+		volumeConfig.Directory = isDirectoryVolume(ctx, cfgVolume)
 
 		// Add config submitted via local profile server.
 		addLocalVolumeConfig(ctx, volumeConfig)
@@ -115,6 +116,25 @@ func parseVolumeConfig(ctx *getconfigContext,
 	log.Tracef("parsing volume config done\n")
 }
 
+// isDirectoryVolume returns true for blank volumes to access with a file
+// system protocol, which we create as a directory rather than as a disk
+// when enabled with the app.directory.volumes global setting.
+// Note that volumemgr keeps the existing volumes as they were created.
+func isDirectoryVolume(ctx *getconfigContext, cfgVolume *zconfig.Volume) bool {
+	if !ctx.zedagentCtx.globalConfig.GlobalValueBool(types.AppDirectoryVolumes) {
+		return false
+	}
+	if cfgVolume.GetOrigin().GetType() != zconfig.VolumeContentOriginType_VCOT_BLANK {
+		return false
+	}
+	for _, protocol := range cfgVolume.GetProtocols() {
+		if protocol == zconfig.VolumeAccessProtocols_VAP_9P {
+			return true
+		}
+	}
+	return false
+}
+
 func signalVolumeConfigRestarted(ctx *getconfigContext) {
 	log.Trace("signalVolumeConfigRestarted")
 	pub := ctx.pubVolumeConfig
@@ -182,6 +202,7 @@ func handleVolumeStatusImpl(ctxArg interface{}, key string,
 	uuidStr := status.VolumeID.String()
 	PublishVolumeToZedCloud(ctx, uuidStr, &status, ctx.iteration, AllDest)
 	ctx.iteration++
+	republishBlockedApps(ctx)
 }
 
 func handleVolumeStatusDelete(ctxArg interface{},
@@ -192,4 +213,5 @@ func handleVolumeStatusDelete(ctxArg interface{},
 	uuidStr := status.VolumeID.String()
 	PublishVolumeToZedCloud(ctx, uuidStr, nil, ctx.iteration, AllDest)
 	ctx.iteration++
+	republishBlockedApps(ctx)
 }
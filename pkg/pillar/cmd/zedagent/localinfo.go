@@ -747,7 +747,7 @@ func processReceivedDevCommands(getconfigCtx *getconfigContext, cmd *profile.Loc
 	}
 
 	// shutdown the application instances
-	shutdownAppsGlobal(ctx)
+	shutdownAppsGlobal(ctx, false)
 
 	publishZedAgentStatus(getconfigCtx)
 
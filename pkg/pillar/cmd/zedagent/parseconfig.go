@@ -188,7 +188,10 @@ func parseConfig(getconfigCtx *getconfigContext, config *zconfig.EdgeDevConfig,
 // Note that we don't currently wait for the shutdown to complete.
 // If withLocalServer is set we skip the app instances which are running
 // a Local Profile Server, and return the number of Local Profile Server apps
-func shutdownApps(getconfigCtx *getconfigContext, withLocalServer bool) (lpsCount uint) {
+// If suspend is set the app instances are asked to save their running
+// state to resume from after the reboot
+func shutdownApps(getconfigCtx *getconfigContext, withLocalServer bool,
+	suspend bool) (lpsCount uint) {
 	pub := getconfigCtx.pubAppInstanceConfig
 	items := pub.GetAll()
 	for _, c := range items {
@@ -203,6 +206,7 @@ func shutdownApps(getconfigCtx *getconfigContext, withLocalServer bool) (lpsCoun
 			log.Functionf("shutdownApps: clearing Activate for %s uuid %s",
 				config.DisplayName, config.Key())
 			config.Activate = false
+			config.SuspendForReboot = suspend
 			pub.Publish(config.Key(), config)
 		}
 	}
@@ -230,8 +234,8 @@ func countRunningApps(getconfigCtx *getconfigContext) (runningCount uint) {
 
 // Defer shutting down app instances with HasLocalServer until all other app
 // instances has halted
-func shutdownAppsGlobal(ctx *zedagentContext) {
-	lpsCount := shutdownApps(ctx.getconfigCtx, false)
+func shutdownAppsGlobal(ctx *zedagentContext, suspend bool) {
+	lpsCount := shutdownApps(ctx.getconfigCtx, false, suspend)
 	if lpsCount == 0 {
 		log.Noticef("shutDownAppsGlobal: no Local Profile Server apps")
 		return
@@ -249,7 +253,7 @@ func shutdownAppsGlobal(ctx *zedagentContext) {
 			}
 			log.Noticef("shutdownAppsGlobal: defer done after %v",
 				time.Since(startTime))
-			shutdownApps(ctx.getconfigCtx, true)
+			shutdownApps(ctx.getconfigCtx, true, suspend)
 			break
 		}
 	}()
@@ -580,6 +584,7 @@ func parseAppInstanceConfig(getconfigCtx *getconfigContext,
 		appInstance.Service = cfgApp.Service
 		appInstance.CloudInitVersion = cfgApp.CloudInitVersion
 		appInstance.FixedResources.CPUsPinned = cfgApp.Fixedresources.PinCpu
+		parseAppSettings(getconfigCtx, &appInstance)
 
 		// Parse the snapshot related fields
 		if cfgApp.Snapshot != nil {
@@ -2418,6 +2423,10 @@ func parseConfigItems(ctx *getconfigContext, config *zconfig.EdgeDevConfig,
 			cmp.Diff(gcPtr, newGlobalConfig))
 		oldGlobalConfig := *gcPtr
 		*gcPtr = *newGlobalConfig
+		if !cmp.Equal(oldGlobalConfig.AppSettings, newGlobalConfig.AppSettings) {
+			// Force re-parse of the app instances to apply their settings
+			appinstancePrevConfigHash = []byte{}
+		}
 
 		// Set GlobalStatus Values from GlobalConfig.
 		oldConfigInterval := oldGlobalConfig.GlobalValueInt(types.ConfigInterval)
@@ -2509,6 +2518,32 @@ func mergeMaintenanceMode(ctx *zedagentContext) {
 		ctx.apiMaintenanceMode, ctx.localMaintenanceMode)
 }
 
+// parseAppSettings sets the fields of the app instance config which come
+// from its app.<uuid>.* config items
+func parseAppSettings(getconfigCtx *getconfigContext, appInstance *types.AppInstanceConfig) {
+	gc := &getconfigCtx.zedagentCtx.globalConfig
+	uuidStr := appInstance.UUIDandVersion.UUID.String()
+	appInstance.FixedResources.MinMem =
+		int(gc.AppSettingIntValue(uuidStr, types.AppMemoryBalloonMinMiB)) << 10
+	appInstance.FixedResources.BalloonMaxMem =
+		int(gc.AppSettingIntValue(uuidStr, types.AppMemoryBalloonMaxMiB)) << 10
+	appInstance.FixedResources.Hotplug =
+		gc.AppSettingBoolValue(uuidStr, types.AppSettingHotplugEnable)
+	bps := uint64(gc.AppSettingIntValue(uuidStr, types.AppSettingIOMaxMiBps)) << 20
+	iops := uint64(gc.AppSettingIntValue(uuidStr, types.AppSettingIOMaxIOPS))
+	appInstance.IOLimits = types.IOLimits{
+		Weight:    uint16(gc.AppSettingIntValue(uuidStr, types.AppSettingIOWeight)),
+		ReadBps:   bps,
+		WriteBps:  bps,
+		ReadIOPS:  iops,
+		WriteIOPS: iops,
+	}
+	appInstance.NetRateLimits = types.NetRateLimits{
+		EgressBps:  uint64(gc.AppSettingIntValue(uuidStr, types.AppSettingNetMaxEgressKbps)) * 1000,
+		IngressBps: uint64(gc.AppSettingIntValue(uuidStr, types.AppSettingNetMaxIngressKbps)) * 1000,
+	}
+}
+
 func checkAndPublishAppInstanceConfig(getconfigCtx *getconfigContext,
 	config types.AppInstanceConfig) {
 
@@ -2859,7 +2894,7 @@ func handleDeviceOperationCmd(ctxPtr *zedagentContext, infoStr string, op types.
 		return
 	}
 	// shutdown the application instances
-	shutdownAppsGlobal(ctxPtr)
+	shutdownAppsGlobal(ctxPtr, op == types.DeviceOperationReboot)
 	getconfigCtx := ctxPtr.getconfigCtx
 
 	publishZedAgentStatus(getconfigCtx)
@@ -2889,6 +2924,6 @@ func handleDeviceOperation(ctxPtr *zedagentContext, op types.DeviceOperation) {
 		return
 	}
 	// shutdown the application instances
-	shutdownAppsGlobal(ctxPtr)
+	shutdownAppsGlobal(ctxPtr, op == types.DeviceOperationReboot)
 	// nothing else to be done
 }
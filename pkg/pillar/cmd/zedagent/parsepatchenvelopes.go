@@ -21,6 +21,10 @@ func parsePatchEnvelopes(ctx *getconfigContext, config *zconfig.EdgeDevConfig) {
 	parsePatchEnvelopesImpl(ctx, config, types.PersistCachePatchEnvelopes)
 }
 
+// patchEnvelopesQuota bounds the space in /persist used by inline
+// binary blobs of patch envelopes
+const patchEnvelopesQuota = 64 << 20
+
 func parsePatchEnvelopesImpl(ctx *getconfigContext, config *zconfig.EdgeDevConfig,
 	persistCacheFilepath string) {
 	log.Tracef("Parsing patchEnvelope from configuration")
@@ -33,13 +37,17 @@ func parsePatchEnvelopesImpl(ctx *getconfigContext, config *zconfig.EdgeDevConfi
 	}
 
 	// Store list of binary blobs which were created before
-	pc, err := persistcache.New(persistCacheFilepath)
+	pc, err := persistcache.NewWithOptions(persistCacheFilepath,
+		persistcache.Options{Quota: patchEnvelopesQuota})
 	if err != nil {
 		log.Errorf("Failed to load persistCache %v", err)
 		return
 	}
 	blobsBefore := pc.Objects()
 
+	// Store all inline blobs or none of them
+	txn := pc.Begin()
+
 	var blobsAfter []string
 	patchEnvelopes := config.GetPatchEnvelopes()
 	result := types.PatchEnvelopeInfoList{}
@@ -49,7 +57,7 @@ func parsePatchEnvelopesImpl(ctx *getconfigContext, config *zconfig.EdgeDevConfi
 			PatchID:     pe.GetUuid(),
 		}
 		for _, a := range pe.GetArtifacts() {
-			err := addBinaryBlobToPatchEnvelope(&peInfo, a, persistCacheFilepath)
+			err := addBinaryBlobToPatchEnvelope(&peInfo, a, txn)
 			if err != nil {
 				log.Errorf("Failed to compose binary blob for patch envelope %v", err)
 				return
@@ -63,12 +71,23 @@ func parsePatchEnvelopesImpl(ctx *getconfigContext, config *zconfig.EdgeDevConfi
 		}
 	}
 
+	if err := txn.Commit(); err != nil {
+		log.Errorf("Failed to store binary blobs for patch envelopes %v", err)
+		return
+	}
+
 	publishPatchEnvelopes(ctx, result)
 
 	// Provide zedrouter with newest version for description.json and then delete files
 	blobsToDelete, _ := generics.DiffSets(blobsBefore, blobsAfter)
+	cleanup := pc.Begin()
 	for _, blob := range blobsToDelete {
-		pc.Delete(blob)
+		if err := cleanup.Delete(blob); err != nil {
+			log.Errorf("Failed to delete binary blob %s: %v", blob, err)
+		}
+	}
+	if err := cleanup.Commit(); err != nil {
+		log.Errorf("Failed to delete stale binary blobs for patch envelopes %v", err)
 	}
 }
 
@@ -81,7 +100,7 @@ func publishPatchEnvelopes(ctx *getconfigContext, patchEnvelopes types.PatchEnve
 	log.Tracef("publishPatchEnvelopes(%s) done\n", key)
 }
 
-func addBinaryBlobToPatchEnvelope(pe *types.PatchEnvelopeInfo, artifact *zconfig.EveBinaryArtifact, persistCacheFilepath string) error {
+func addBinaryBlobToPatchEnvelope(pe *types.PatchEnvelopeInfo, artifact *zconfig.EveBinaryArtifact, txn *persistcache.Txn) error {
 	format := artifact.GetFormat()
 
 	switch format {
@@ -103,7 +122,7 @@ func addBinaryBlobToPatchEnvelope(pe *types.PatchEnvelopeInfo, artifact *zconfig
 		if inlineArtifact == nil {
 			return fmt.Errorf("InlineOpaqueBase64data is empty, type indicates it should be present")
 		}
-		binaryBlob, err := cacheInlineBase64Artifact(inlineArtifact, persistCacheFilepath)
+		binaryBlob, err := cacheInlineBase64Artifact(inlineArtifact, txn)
 		if err != nil {
 			return err
 		}
@@ -116,19 +135,13 @@ func addBinaryBlobToPatchEnvelope(pe *types.PatchEnvelopeInfo, artifact *zconfig
 }
 
 // cacheInlineBinaryArtifact stores inline artifact as file and
-// returns path to it to be served by HTTP server
-func cacheInlineBase64Artifact(artifact *zconfig.InlineOpaqueBase64Data, persistCacheFilepath string) (*types.BinaryBlobCompleted, error) {
-	pc, err := persistcache.New(persistCacheFilepath)
-
-	if err != nil {
-		return nil, err
-	}
-
+// returns path to it to be served by HTTP server once txn is committed
+func cacheInlineBase64Artifact(artifact *zconfig.InlineOpaqueBase64Data, txn *persistcache.Txn) (*types.BinaryBlobCompleted, error) {
 	metadata := artifact.GetBase64MetaData()
 	data := artifact.GetBase64Data()
 
 	// We want write inline data to a file to serve it from http server
-	url, err := pc.Put(artifact.GetFileNameToUse(), []byte(data))
+	url, err := txn.Put(artifact.GetFileNameToUse(), []byte(data))
 	if err != nil {
 		return nil, err
 	}
@@ -41,6 +41,7 @@ import (
 	"github.com/lf-edge/eve/pkg/pillar/agentlog"
 	"github.com/lf-edge/eve/pkg/pillar/base"
 	"github.com/lf-edge/eve/pkg/pillar/netdump"
+	"github.com/lf-edge/eve/pkg/pillar/objdeps"
 	"github.com/lf-edge/eve/pkg/pillar/pidfile"
 	"github.com/lf-edge/eve/pkg/pillar/pubsub"
 	"github.com/lf-edge/eve/pkg/pillar/types"
@@ -135,6 +136,7 @@ type zedagentContext struct {
 	subAppContainerMetrics    pubsub.Subscription
 	subDiskMetric             pubsub.Subscription
 	subAppDiskMetric          pubsub.Subscription
+	subWorkerMetrics          pubsub.Subscription
 	subCapabilities           pubsub.Subscription
 	subAppInstMetaData        pubsub.Subscription
 	subWwanMetrics            pubsub.Subscription
@@ -143,6 +145,7 @@ type zedagentContext struct {
 	subZFSPoolStatus          pubsub.Subscription
 	subZFSPoolMetrics         pubsub.Subscription
 	subEdgeviewStatus         pubsub.Subscription
+	subAppCrashDump           pubsub.Subscription
 	subNetworkMetrics         pubsub.Subscription
 	subClientMetrics          pubsub.Subscription
 	subLoguploaderMetrics     pubsub.Subscription
@@ -232,6 +235,13 @@ type zedagentContext struct {
 	lastConfigNetdumpPub time.Time // last call to publishConfigNetdump
 	lastInfoNetdumpPub   time.Time // last call to publishInfoNetdump
 	startTime            time.Time
+
+	// What blocked each app instance when its info was last published;
+	// see republishBlockedApps
+	appBlockers map[string]string
+	// The objects the app instances depend on, shared by the app instances
+	// while republishBlockedApps runs, nil otherwise
+	appDeps *objdeps.Graph
 }
 
 // AddAgentSpecificCLIFlags adds CLI options
@@ -550,6 +560,7 @@ func waitUntilInitializedFromNodeAgentStatus(ctx *zedagentContext, running *time
 
 func (zedagentCtx *zedagentContext) init() {
 	zedagentCtx.zedcloudMetrics = zedcloud.NewAgentMetrics()
+	zedagentCtx.appBlockers = make(map[string]string)
 	zedagentCtx.specMap = types.NewConfigItemSpecMap()
 	zedagentCtx.globalConfig = *types.DefaultConfigItemValueMap()
 	zedagentCtx.globalStatus.ConfigItems = make(
@@ -1005,6 +1016,9 @@ func mainEventLoop(zedagentCtx *zedagentContext, stillRunning *time.Ticker) {
 		case change := <-zedagentCtx.subAppDiskMetric.MsgChan():
 			zedagentCtx.subAppDiskMetric.ProcessChange(change)
 
+		case change := <-zedagentCtx.subWorkerMetrics.MsgChan():
+			zedagentCtx.subWorkerMetrics.ProcessChange(change)
+
 		case change := <-zedagentCtx.subCapabilities.MsgChan():
 			zedagentCtx.subCapabilities.ProcessChange(change)
 
@@ -1039,6 +1053,9 @@ func mainEventLoop(zedagentCtx *zedagentContext, stillRunning *time.Ticker) {
 		case change := <-zedagentCtx.subEdgeviewStatus.MsgChan():
 			zedagentCtx.subEdgeviewStatus.ProcessChange(change)
 
+		case change := <-zedagentCtx.subAppCrashDump.MsgChan():
+			zedagentCtx.subAppCrashDump.ProcessChange(change)
+
 		case <-stillRunning.C:
 			// Fault injection
 			if zedagentCtx.fatalFlag {
@@ -1422,6 +1439,21 @@ func initPostOnboardSubs(zedagentCtx *zedagentContext) {
 		log.Fatal(err)
 	}
 
+	zedagentCtx.subAppCrashDump, err = ps.NewSubscription(pubsub.SubscriptionOptions{
+		AgentName:     "domainmgr",
+		MyAgentName:   agentName,
+		TopicImpl:     types.AppCrashDump{},
+		Activate:      true,
+		Ctx:           zedagentCtx,
+		CreateHandler: handleAppCrashDumpCreate,
+		DeleteHandler: handleAppCrashDumpDelete,
+		WarningTime:   warningTime,
+		ErrorTime:     errorTime,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	zedagentCtx.subEdgeviewStatus, err = ps.NewSubscription(pubsub.SubscriptionOptions{
 		AgentName:     "edgeview",
 		MyAgentName:   agentName,
@@ -1634,6 +1666,17 @@ func initPostOnboardSubs(zedagentCtx *zedagentContext) {
 		log.Fatal(err)
 	}
 
+	zedagentCtx.subWorkerMetrics, err = ps.NewSubscription(pubsub.SubscriptionOptions{
+		AgentName:   "volumemgr",
+		MyAgentName: agentName,
+		TopicImpl:   types.WorkerMetrics{},
+		Activate:    true,
+		Ctx:         zedagentCtx,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	zedagentCtx.subDiskMetric, err = ps.NewSubscription(pubsub.SubscriptionOptions{
 		AgentName:     "volumemgr",
 		MyAgentName:   agentName,
@@ -2093,6 +2136,7 @@ func handleAppInstanceStatusDelete(ctxArg interface{}, key string,
 	ctx := ctxArg.(*zedagentContext)
 	uuidStr := key
 	log.Functionf("handleAppInstanceStatusDelete(%s)", key)
+	delete(ctx.appBlockers, key)
 	PublishAppInfoToZedCloud(ctx, uuidStr, nil, ctx.assignableAdapters,
 		ctx.iteration, AllDest)
 	triggerPublishDevInfo(ctx)
@@ -2622,6 +2666,30 @@ func handleEdgeviewStatusImpl(ctxArg interface{}, key string, statusArg interfac
 	PublishEdgeviewToZedCloud(ctx, &status, AllDest)
 }
 
+func handleAppCrashDumpCreate(ctxArg interface{}, key string, statusArg interface{}) {
+	handleAppCrashDumpImpl(ctxArg, key, statusArg)
+}
+
+func handleAppCrashDumpDelete(ctxArg interface{}, key string, statusArg interface{}) {
+	handleAppCrashDumpImpl(ctxArg, key, statusArg)
+}
+
+// handleAppCrashDumpImpl republishes the info of the app whose memory was
+// dumped, which reports the latest dump kept
+func handleAppCrashDumpImpl(ctxArg interface{}, key string, statusArg interface{}) {
+	dump := statusArg.(types.AppCrashDump)
+	ctx := ctxArg.(*zedagentContext)
+	log.Functionf("handleAppCrashDumpImpl(%s)", key)
+	uuidStr := dump.AppUUID.String()
+	st, _ := ctx.getconfigCtx.subAppInstanceStatus.Get(uuidStr)
+	if st == nil {
+		return
+	}
+	status := st.(types.AppInstanceStatus)
+	PublishAppInfoToZedCloud(ctx, uuidStr, &status, ctx.assignableAdapters,
+		ctx.iteration, AllDest)
+}
+
 func reinitNetdumper(ctx *zedagentContext) {
 	gcp := ctx.globalConfig
 	netDumper := ctx.netDumper
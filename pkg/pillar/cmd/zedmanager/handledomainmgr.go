@@ -6,6 +6,7 @@ package zedmanager
 import (
 	"errors"
 	"fmt"
+	"os"
 	"runtime"
 
 	"github.com/lf-edge/eve/pkg/pillar/types"
@@ -49,7 +50,21 @@ func MaybeAddDomainConfig(ctx *zedmanagerContext,
 		MetaDataType:      aiConfig.MetaDataType,
 		Service:           aiConfig.Service,
 		CloudInitVersion:  aiConfig.CloudInitVersion,
+		RestoreFile:       getRestoreFile(aiConfig, aiStatus),
 	}
+	if dc.VirtualizationMode != types.NOHYPER {
+		if dc.MinMem == 0 {
+			percent := int(ctx.globalConfig.GlobalValueInt(types.AppMemoryBalloonMinPercent))
+			dc.MinMem = dc.Memory * percent / 100
+		}
+		if ctx.globalConfig.GlobalValueBool(types.EnableAppGuestAgent) {
+			dc.GuestAgent = true
+		}
+		if ctx.globalConfig.GlobalValueInt(types.AppCrashDumpQuotaMiB) > 0 {
+			dc.PanicDevice = true
+		}
+	}
+	dc.IOLimits = appIOLimits(ctx, aiConfig)
 
 	dc.DiskConfigList = make([]types.DiskConfig, 0, len(aiStatus.VolumeRefStatusList))
 	for _, vrc := range aiConfig.VolumeRefConfigList {
@@ -76,6 +91,7 @@ func MaybeAddDomainConfig(ctx *zedmanagerContext,
 		disk.WWN = vrs.WWN
 		disk.Target = vrs.Target
 		disk.CustomMeta = vrs.CustomMeta
+		disk.Directory = vrs.Directory
 		dc.DiskConfigList = append(dc.DiskConfigList, disk)
 	}
 	// let's fill some of the default values (arguably we may want controller
@@ -119,12 +135,64 @@ func MaybeAddDomainConfig(ctx *zedmanagerContext,
 		dc.VifList = make([]types.VifConfig, adapterCount)
 		for i, adapter := range ns.AppNetAdapterList {
 			dc.VifList[i] = adapter.VifInfo.VifConfig
+			dc.VifList[i].VifRateLimits = adapter.RateLimits
 		}
 	}
 	log.Functionf("MaybeAddDomainConfig done for %s", key)
 	return &dc, nil
 }
 
+// getRestoreFile returns the running state to resume the app instance from
+// when it is activated, if any: the one saved with the snapshot it is rolled
+// back to, or the one saved before a planned reboot of the device
+func getRestoreFile(aiConfig types.AppInstanceConfig,
+	aiStatus types.AppInstanceStatus) string {
+	if aiStatus.SnapStatus.RestoreVMState != "" {
+		return aiStatus.SnapStatus.RestoreVMState
+	}
+	file := types.GetSuspendedAppStateFile(aiConfig.UUIDandVersion)
+	if _, err := os.Stat(file); err == nil {
+		return file
+	}
+	return ""
+}
+
+// appIOLimits returns the limits of the block I/O of the app: its own where
+// set, otherwise the global ones
+func appIOLimits(ctx *zedmanagerContext, aiConfig types.AppInstanceConfig) types.IOLimits {
+	limits := globalIOLimits(ctx)
+	own := aiConfig.IOLimits
+	if own.Weight != 0 {
+		limits.Weight = own.Weight
+	}
+	overrideLimit(&limits.ReadBps, own.ReadBps)
+	overrideLimit(&limits.WriteBps, own.WriteBps)
+	overrideLimit(&limits.ReadIOPS, own.ReadIOPS)
+	overrideLimit(&limits.WriteIOPS, own.WriteIOPS)
+	return limits
+}
+
+// overrideLimit sets limit to own unless own is not set
+func overrideLimit(limit *uint64, own uint64) {
+	if own != 0 {
+		*limit = own
+	}
+}
+
+// globalIOLimits returns the limits of the block I/O of each app from the
+// global settings
+func globalIOLimits(ctx *zedmanagerContext) types.IOLimits {
+	bps := uint64(ctx.globalConfig.GlobalValueInt(types.AppIOMaxMiBps)) << 20
+	iops := uint64(ctx.globalConfig.GlobalValueInt(types.AppIOMaxIOPS))
+	return types.IOLimits{
+		Weight:    uint16(ctx.globalConfig.GlobalValueInt(types.AppIOWeight)),
+		ReadBps:   bps,
+		WriteBps:  bps,
+		ReadIOPS:  iops,
+		WriteIOPS: iops,
+	}
+}
+
 func lookupDomainConfig(ctx *zedmanagerContext, key string) *types.DomainConfig {
 
 	pub := ctx.pubDomainConfig
@@ -178,6 +246,13 @@ func handleDomainStatusCreate(ctxArg interface{}, key string,
 
 func handleDomainStatusModify(ctxArg interface{}, key string,
 	statusArg interface{}, oldStatusArg interface{}) {
+	status := statusArg.(types.DomainStatus)
+	oldStatus := oldStatusArg.(types.DomainStatus)
+	if status.BalloonActual != oldStatus.BalloonActual {
+		// Memory reclaimed by the balloon might let other apps start
+		ctx := ctxArg.(*zedmanagerContext)
+		ctx.checkFreedResources = true
+	}
 	handleDomainStatusImpl(ctxArg, key, statusArg)
 }
 
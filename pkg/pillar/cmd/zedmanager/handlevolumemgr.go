@@ -306,6 +306,11 @@ func reactToSnapshotRollback(ctx *zedmanagerContext, volumesSnapshotStatus types
 		return
 	}
 	appInstanceStatus.SnapStatus.RollbackInProgress = false
+	// Resume the app where it was if its running state was saved with the snapshot
+	vmStateFile := types.GetSnapshotVMStateFile(volumesSnapshotStatus.SnapshotID)
+	if _, err := os.Stat(vmStateFile); err == nil {
+		appInstanceStatus.SnapStatus.RestoreVMState = vmStateFile
+	}
 	// unpublish local app instance config, as the rollback is complete, we do not need to replace the config
 	config := lookupAppInstanceConfig(ctx, appInstanceStatus.Key(), false)
 	if config == nil {
@@ -61,6 +61,12 @@ func MaybeAddAppNetworkConfig(ctx *zedmanagerContext,
 				changed = true
 				break
 			}
+			if appNetRateLimits(ctx, aiConfig) != old.RateLimits {
+				log.Functionf("MaybeAddAppNetworkConfig: RateLimits changed from %+v to %+v",
+					old.RateLimits, appNetRateLimits(ctx, aiConfig))
+				changed = true
+				break
+			}
 		}
 	} else {
 		log.Tracef("appNetwork config add for %s", key)
@@ -81,12 +87,31 @@ func MaybeAddAppNetworkConfig(ctx *zedmanagerContext,
 		for i, ulc := range aiConfig.AppNetAdapterList {
 			ul := &nc.AppNetAdapterList[i]
 			*ul = ulc
+			ul.RateLimits = appNetRateLimits(ctx, aiConfig)
 		}
 		publishAppNetworkConfig(ctx, &nc)
 	}
 	log.Functionf("MaybeAddAppNetworkConfig done for %s", key)
 }
 
+// appNetRateLimits returns the rate limits of the VIFs of the app: its own
+// where set, otherwise the global ones
+func appNetRateLimits(ctx *zedmanagerContext, aiConfig types.AppInstanceConfig) types.NetRateLimits {
+	limits := globalNetRateLimits(ctx)
+	overrideLimit(&limits.EgressBps, aiConfig.NetRateLimits.EgressBps)
+	overrideLimit(&limits.IngressBps, aiConfig.NetRateLimits.IngressBps)
+	return limits
+}
+
+// globalNetRateLimits returns the rate limits of the VIFs of each app from
+// the global settings
+func globalNetRateLimits(ctx *zedmanagerContext) types.NetRateLimits {
+	return types.NetRateLimits{
+		EgressBps:  uint64(ctx.globalConfig.GlobalValueInt(types.AppNetMaxEgressKbps)) * 1000,
+		IngressBps: uint64(ctx.globalConfig.GlobalValueInt(types.AppNetMaxIngressKbps)) * 1000,
+	}
+}
+
 func lookupAppNetworkConfig(ctx *zedmanagerContext, key string) *types.AppNetworkConfig {
 
 	pub := ctx.pubAppNetworkConfig
@@ -17,6 +17,10 @@ import (
 // categories
 // The amount of memory which is used but will soon be freed from halting
 // app instances is returned as a third counter.
+// The memory the balloon of running app instances actually took back from
+// them, as reported by the hypervisor, is counted as remaining unless
+// domainmgr asked the guest to take it back; domainmgr only gives back
+// what remains.
 func getRemainingMemory(ctxPtr *zedmanagerContext) (uint64, uint64, uint64, error) {
 
 	var usedMemorySize uint64    // Sum of Activated || ActivateInprogress
@@ -29,6 +33,10 @@ func getRemainingMemory(ctxPtr *zedmanagerContext) (uint64, uint64, uint64, erro
 	for _, st := range itemsAppInstanceStatus {
 		status := st.(types.AppInstanceStatus)
 		mem := uint64(status.FixedResources.Memory) << 10
+		if ds := lookupDomainStatus(ctxPtr, status.Key()); ds != nil &&
+			ds.Activated && ds.BalloonActual != 0 {
+			mem = uint64(ds.BalloonReserved()) << 10
+		}
 		if status.Activated || status.ActivateInprogress {
 			usedMemorySize += mem
 			accountedApps = append(accountedApps, status.Key())
@@ -40,13 +48,10 @@ func getRemainingMemory(ctxPtr *zedmanagerContext) (uint64, uint64, uint64, erro
 			latentMemorySize += mem
 		}
 	}
-	memoryReservedForEve := uint64(ctxPtr.globalConfig.GlobalValueInt(types.EveMemoryLimitInBytes))
-	if vault.ReadPersistType() == types.PersistZFS {
-		zfsArcMaxLimit, err := types.GetZFSArcMaxSizeInBytes()
-		if err != nil {
-			return 0, 0, 0, fmt.Errorf("failed to get data from zfs_arc_max. error: %v", err)
-		}
-		memoryReservedForEve += zfsArcMaxLimit
+	memoryReservedForEve, err := types.GetMemoryReservedForEve(ctxPtr.globalConfig,
+		vault.ReadPersistType())
+	if err != nil {
+		return 0, 0, 0, err
 	}
 	usedMemorySize += memoryReservedForEve
 	deviceMemorySize, err := sysTotalMemory(ctxPtr)
@@ -76,3 +81,24 @@ func sysTotalMemory(ctx *zedmanagerContext) (uint64, error) {
 	}
 	return 0, fmt.Errorf("Global host memory is empty")
 }
+
+// checkHotplugMemory returns an error if the memory the config adds to the
+// running app instance does not fit in what remains for app instances
+func checkHotplugMemory(ctxPtr *zedmanagerContext, config types.AppInstanceConfig,
+	status *types.AppInstanceStatus) error {
+
+	if config.FixedResources.Memory <= status.FixedResources.Memory ||
+		ctxPtr.globalConfig.GlobalValueBool(types.IgnoreMemoryCheckForApps) {
+		return nil
+	}
+	remaining, _, _, err := getRemainingMemory(ctxPtr)
+	if err != nil {
+		return fmt.Errorf("getRemainingMemory failed: %v", err)
+	}
+	need := uint64(config.FixedResources.Memory-status.FixedResources.Memory) << 10
+	if remaining < need {
+		return fmt.Errorf("Remaining memory bytes %d app instance needs %d more",
+			remaining, need)
+	}
+	return nil
+}
@@ -5,6 +5,8 @@ package zedmanager
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/google/go-cmp/cmp"
@@ -87,6 +89,7 @@ func removeAIStatus(ctx *zedmanagerContext, status *types.AppInstanceStatus) {
 
 	if uninstall {
 		log.Functionf("removeAIStatus(%s) remove done", uuidStr)
+		removeSuspendedAppState(status.UUIDandVersion.UUID)
 		// Write out what we modified to AppInstanceStatus aka delete
 		unpublishAppInstanceStatus(ctx, status)
 		return
@@ -226,6 +229,54 @@ func doUpdate(ctx *zedmanagerContext,
 	return changed
 }
 
+// getCheckpointFile returns where the domain should save its running state
+// when it goes down, and whether the state is to be restored after a planned
+// reboot of the device. Otherwise the state is saved only if the snapshot
+// taken for an update should include it.
+func getCheckpointFile(ctx *zedmanagerContext, dc types.DomainConfig,
+	status *types.AppInstanceStatus) (string, bool) {
+	if dc.VirtualizationModeOrDefault() == types.NOHYPER {
+		return "", false
+	}
+	config := lookupAppInstanceConfig(ctx, status.Key(), true)
+	if config != nil && config.SuspendForReboot &&
+		ctx.globalConfig.GlobalValueBool(types.AppSuspendOnReboot) {
+		return types.GetSuspendedAppStateFile(status.UUIDandVersion), true
+	}
+	if !status.SnapStatus.SnapshotOnUpgrade || len(status.SnapStatus.PreparedVolumesSnapshotConfigs) == 0 {
+		return "", false
+	}
+	if !ctx.globalConfig.GlobalValueBool(types.AppSnapshotVMState) {
+		return "", false
+	}
+	// The state goes with the first snapshot taken for the update
+	return types.GetSnapshotVMStateFile(status.SnapStatus.PreparedVolumesSnapshotConfigs[0].SnapshotID), false
+}
+
+// removeSuspendedAppState removes the running state of any version of the
+// app instance saved across a reboot
+func removeSuspendedAppState(appInstID uuid.UUID) {
+	if err := removeAppStates(types.SuspendedAppsDirname, appInstID); err != nil {
+		log.Errorf("removeSuspendedAppState(%s) failed: %v", appInstID, err)
+	}
+}
+
+// removeAppStates removes the states of any version of the app instance
+// in dir; a state is a file or a directory depending on the hypervisor
+func removeAppStates(dir string, appInstID uuid.UUID) error {
+	states, err := filepath.Glob(filepath.Join(dir, appInstID.String()+".*"))
+	if err != nil {
+		return err
+	}
+	var firstErr error
+	for _, state := range states {
+		if err := os.RemoveAll(state); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
 func triggerSnapshots(ctx *zedmanagerContext, status *types.AppInstanceStatus) {
 	log.Noticef("triggerSnapshots(%s)", status.Key())
 	timeTriggered := time.Now()
@@ -236,9 +287,15 @@ func triggerSnapshots(ctx *zedmanagerContext, status *types.AppInstanceStatus) {
 			snapshot.TimeTriggered = timeTriggered
 		}
 	}
+	// The domain froze the guest filesystems before it stopped if it could
+	guestFrozen := false
+	if ds := lookupDomainStatus(ctx, status.Key()); ds != nil {
+		guestFrozen = ds.StoppedFrozen
+	}
 	// trigger the snapshots. Use the list of prepared VolumeSnapshotConfigs for that
 	for _, volumesSnapshotConfig := range status.SnapStatus.PreparedVolumesSnapshotConfigs {
 		log.Noticef("Triggering snapshot %s", volumesSnapshotConfig.SnapshotID)
+		volumesSnapshotConfig.GuestFrozen = guestFrozen
 		publishVolumesSnapshotConfig(ctx, &volumesSnapshotConfig)
 		removePreparedVolumesSnapshotConfig(status, volumesSnapshotConfig.SnapshotID)
 	}
@@ -740,6 +797,33 @@ func doActivate(ctx *zedmanagerContext, uuidStr string,
 	if c {
 		changed = true
 	}
+	// Resume from the rollback snapshot or the state saved before
+	// the reboot only once
+	if dc.RestoreFile != "" && ds.Activated {
+		if ds.RestoredFrom != "" {
+			log.Noticef("%s resumed from %s", status.Key(), ds.RestoredFrom)
+		} else {
+			log.Warnf("%s booted, failed to resume from %s", status.Key(),
+				dc.RestoreFile)
+		}
+		if status.SnapStatus.RestoreVMState != "" {
+			status.SnapStatus.RestoreVMState = ""
+			changed = true
+		}
+		removeSuspendedAppState(status.UUIDandVersion.UUID)
+		dc.RestoreFile = ""
+	}
+	// Restart for the vCPUs or memory domainmgr could not hot-plug
+	if ds.HotplugFailed && ds.Activated &&
+		status.RestartInprogress == types.NotInprogress &&
+		status.PurgeInprogress == types.NotInprogress {
+		log.Noticef("%s failed to hot-plug vCPUs or memory, restarting",
+			status.Key())
+		status.RestartInprogress = types.BringDown
+		status.State = types.RESTARTING
+		status.RestartStartedAt = time.Now()
+		changed = true
+	}
 	// Are we doing a restart?
 	if status.RestartInprogress == types.BringDown {
 		if dc.Activate {
@@ -794,6 +878,7 @@ func doActivate(ctx *zedmanagerContext, uuidStr string,
 	}
 	// XXX compare with equal before setting changed?
 	status.IoAdapterList = ds.IoAdapterList
+	status.GuestInfo = ds.GuestInfo
 	changed = true
 	if ds.State < types.BOOTING {
 		log.Functionf("Waiting for DomainStatus to BOOTING for %s",
@@ -948,6 +1033,10 @@ func doInactivate(ctx *zedmanagerContext, appInstID uuid.UUID,
 			log.Functionf("doInactivate: Clearing Activate for DomainConfig for %s",
 				uuidStr)
 			dc.Activate = false
+			dc.CheckpointFile, dc.Suspend = getCheckpointFile(ctx, *dc, status)
+			// The volumes are snapshotted once the domain is stopped
+			dc.FreezeForSnapshot = status.SnapStatus.SnapshotOnUpgrade &&
+				len(status.SnapStatus.PreparedVolumesSnapshotConfigs) > 0
 			publishDomainConfig(ctx, dc)
 		}
 	}
@@ -0,0 +1,49 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package zedmanager
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestRemoveAppStates(t *testing.T) {
+	dir := t.TempDir()
+	appInstID := uuid.FromStringOrNil("6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+	otherID := uuid.FromStringOrNil("6ba7b811-9dad-11d1-80b4-00c04fd430c8")
+
+	// kvm saves the state into a file, chv into a directory
+	fileState := filepath.Join(dir, appInstID.String()+".1")
+	if err := os.WriteFile(fileState, []byte("state"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	dirState := filepath.Join(dir, appInstID.String()+".2")
+	if err := os.MkdirAll(filepath.Join(dirState, "snapshot"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"config.json", "state.json", "snapshot/memory-ranges"} {
+		if err := os.WriteFile(filepath.Join(dirState, name), []byte("state"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	otherState := filepath.Join(dir, otherID.String()+".1")
+	if err := os.WriteFile(otherState, []byte("state"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := removeAppStates(dir, appInstID); err != nil {
+		t.Fatalf("removeAppStates failed: %v", err)
+	}
+	for _, state := range []string{fileState, dirState} {
+		if _, err := os.Stat(state); !os.IsNotExist(err) {
+			t.Errorf("%s not removed: %v", state, err)
+		}
+	}
+	if _, err := os.Stat(otherState); err != nil {
+		t.Errorf("state of another app instance removed: %v", err)
+	}
+}
@@ -1184,6 +1184,15 @@ func handleModify(ctxArg interface{}, key string,
 		status.SetError(errStr, time.Now())
 		publishAppInstanceStatus(ctx, status)
 		return
+	} else if !needPurge && !cmp.Equal(config.FixedResources, status.FixedResources) {
+		// More vCPUs or memory for the running app
+		if err := checkHotplugMemory(ctx, config, status); err != nil {
+			log.Errorf("handleModify(%s) failed: %s", status.Key(), err)
+			status.SetError(err.Error(), time.Now())
+			publishAppInstanceStatus(ctx, status)
+			return
+		}
+		status.FixedResources = config.FixedResources
 	}
 
 	if config.PurgeCmd.Counter != oldConfig.PurgeCmd.Counter ||
@@ -1359,18 +1368,58 @@ func quantifyChanges(config types.AppInstanceConfig, oldConfig types.AppInstance
 		needPurge = true
 		purgeReason += str + "\n"
 	}
-	if !cmp.Equal(config.FixedResources, oldConfig.FixedResources) {
+	// The balloon bounds apply to the running app
+	oldResources := withoutBalloonBounds(oldConfig.FixedResources)
+	newResources := withoutBalloonBounds(config.FixedResources)
+	if !cmp.Equal(newResources, oldResources) {
 		str := fmt.Sprintf("FixedResources changed: %v",
-			cmp.Diff(oldConfig.FixedResources, config.FixedResources))
+			cmp.Diff(oldResources, newResources))
 		log.Functionf(str)
-		needRestart = true
-		restartReason += str + "\n"
+		if resourcesHotpluggable(oldResources, newResources) {
+			log.Functionf("quantifyChanges for %s %s: vCPUs and memory will be hot-plugged",
+				config.Key(), config.DisplayName)
+		} else {
+			needRestart = true
+			restartReason += str + "\n"
+		}
 	}
 	log.Functionf("quantifyChanges for %s %s returns %v, %v",
 		config.Key(), config.DisplayName, needPurge, needRestart)
 	return needPurge, needRestart, purgeReason, restartReason
 }
 
+// withoutBalloonBounds returns the resources without the bounds of the
+// balloon, which domainmgr applies to the running app
+func withoutBalloonBounds(resources types.VmConfig) types.VmConfig {
+	resources.MinMem = 0
+	resources.BalloonMaxMem = 0
+	return resources
+}
+
+// resourcesHotpluggable returns true if the only change to the fixed
+// resources is more vCPUs or memory, within MaxCpus and MaxMem, which
+// domainmgr can add to the running app started with Hotplug. It restarts
+// the app if that fails.
+func resourcesHotpluggable(oldResources, newResources types.VmConfig) bool {
+	if !oldResources.Hotplug {
+		return false
+	}
+	if newResources.VCpus < oldResources.VCpus || newResources.Memory < oldResources.Memory {
+		return false
+	}
+	if newResources.VCpus > oldResources.VCpus &&
+		(newResources.CPUsPinned || newResources.VCpus > newResources.MaxCpus) {
+		return false
+	}
+	if newResources.Memory > oldResources.Memory && newResources.Memory > newResources.MaxMem {
+		return false
+	}
+	grown := oldResources
+	grown.VCpus = newResources.VCpus
+	grown.Memory = newResources.Memory
+	return cmp.Equal(grown, newResources)
+}
+
 func handleGlobalConfigCreate(ctxArg interface{}, key string,
 	statusArg interface{}) {
 	handleGlobalConfigImpl(ctxArg, key, statusArg)
@@ -1390,12 +1439,16 @@ func handleGlobalConfigImpl(ctxArg interface{}, key string,
 		return
 	}
 	log.Functionf("handleGlobalConfigImpl for %s", key)
+	oldIOLimits, oldNetRateLimits := globalIOLimits(ctx), globalNetRateLimits(ctx)
 	gcp := agentlog.HandleGlobalConfig(log, ctx.subGlobalConfig, agentName,
 		ctx.CLIParams().DebugOverride, logger)
 	if gcp != nil {
 		ctx.globalConfig = gcp
 		ctx.GCInitialized = true
 	}
+	if globalIOLimits(ctx) != oldIOLimits || globalNetRateLimits(ctx) != oldNetRateLimits {
+		updateBasedOnLimits(ctx)
+	}
 	log.Functionf("handleGlobalConfigImpl done for %s", key)
 }
 
@@ -1499,6 +1552,27 @@ func updateBasedOnProfile(ctx *zedmanagerContext, oldProfile string) {
 	}
 }
 
+// updateBasedOnLimits passes the changed limits of the I/O and the VIFs of
+// the apps on to the running ones
+func updateBasedOnLimits(ctx *zedmanagerContext) {
+	pub := ctx.subAppInstanceConfig
+	items := pub.GetAll()
+	for _, c := range items {
+		config := c.(types.AppInstanceConfig)
+		if localConfig := lookupLocalAppInstanceConfig(ctx, config.Key()); localConfig != nil {
+			config = *localConfig
+		}
+		status := lookupAppInstanceStatus(ctx, config.Key())
+		if status == nil || !status.Activated {
+			continue
+		}
+		log.Functionf("updateBasedOnLimits: update limits for %s", config.Key())
+		if doUpdate(ctx, config, status) {
+			publishAppInstanceStatus(ctx, status)
+		}
+	}
+}
+
 // returns effective Activate status based on Activate from app instance config and current profile
 func effectiveActivateCurrentProfile(config types.AppInstanceConfig, currentProfile string) bool {
 	if currentProfile == "" {
@@ -561,8 +561,9 @@ func (z *zedrouter) initPublications() (err error) {
 	}
 
 	z.pubNetworkInstanceMetrics, err = z.pubSub.NewPublication(pubsub.PublicationOptions{
-		AgentName: agentName,
-		TopicType: types.NetworkInstanceMetrics{},
+		AgentName:      agentName,
+		TopicType:      types.NetworkInstanceMetrics{},
+		CoalesceWindow: pubsub.MetricCoalesceWindow,
 	})
 	if err != nil {
 		return err
@@ -577,16 +578,18 @@ func (z *zedrouter) initPublications() (err error) {
 	}
 
 	z.pubAppContainerStats, err = z.pubSub.NewPublication(pubsub.PublicationOptions{
-		AgentName: agentName,
-		TopicType: types.AppContainerMetrics{},
+		AgentName:      agentName,
+		TopicType:      types.AppContainerMetrics{},
+		CoalesceWindow: pubsub.MetricCoalesceWindow,
 	})
 	if err != nil {
 		return err
 	}
 
 	z.pubNetworkMetrics, err = z.pubSub.NewPublication(pubsub.PublicationOptions{
-		AgentName: agentName,
-		TopicType: types.NetworkMetrics{},
+		AgentName:      agentName,
+		TopicType:      types.NetworkMetrics{},
+		CoalesceWindow: pubsub.MetricCoalesceWindow,
 	})
 	if err != nil {
 		log.Fatal(err)
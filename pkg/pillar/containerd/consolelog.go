@@ -0,0 +1,154 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package containerd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	logutils "github.com/lf-edge/eve/pkg/pillar/utils/logging"
+	"github.com/sirupsen/logrus"
+)
+
+// What the VM domains write to their console, qemu's logfile of the serial
+// console included, goes to their guest_vm-<domainName> log at up to
+// consoleLogRate lines per second; the other lines are dropped. The stdout
+// of containers is not limited.
+
+// Longer lines are cut
+const consoleLineMaxSize = 4096
+
+var consoleLogRate atomic.Uint32
+
+// SetConsoleLogRate sets how many lines of the console output of each
+// domain go into its log every second, 0 for none
+func SetConsoleLogRate(rate uint32) {
+	consoleLogRate.Store(rate)
+}
+
+// consoleRateLimiter lets through up to rate lines every second and counts
+// the ones dropped
+type consoleRateLimiter struct {
+	rate    func() int
+	start   time.Time
+	lines   int
+	dropped int
+}
+
+// allow returns true if a line read at now is let through. When the first
+// line of a second is, it returns how many were dropped in the previous ones.
+func (l *consoleRateLimiter) allow(now time.Time) (bool, int) {
+	dropped := 0
+	if now.Sub(l.start) >= time.Second {
+		l.start = now
+		l.lines = 0
+		dropped = l.dropped
+		l.dropped = 0
+	}
+	if l.lines >= l.rate() {
+		l.dropped++
+		return false, 0
+	}
+	l.lines++
+	return true, dropped
+}
+
+// flush returns how many lines were dropped since the last line let through
+// and starts counting again, for the count to be reported when no line
+// follows
+func (l *consoleRateLimiter) flush() int {
+	dropped := l.dropped
+	l.dropped = 0
+	return dropped
+}
+
+// writeConsoleLines writes the complete lines of data to writer as long as
+// limiter allows and returns what is left of the last line
+func writeConsoleLines(writer io.Writer, data []byte, limiter *consoleRateLimiter) []byte {
+	for {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			if len(data) < consoleLineMaxSize {
+				return data
+			}
+			i = consoleLineMaxSize
+		}
+		line := string(bytes.TrimRight(data[:i], "\r"))
+		if i < len(data) && data[i] == '\n' {
+			i++
+		}
+		data = data[i:]
+		ok, dropped := limiter.allow(time.Now())
+		if dropped != 0 {
+			fmt.Fprintf(writer, "[%d console lines dropped]\n", dropped)
+		}
+		if ok {
+			io.WriteString(writer, line+"\n")
+		}
+	}
+}
+
+// copyConsoleLines copies the lines read from reader to writer as long as
+// limiter allows, until reader ends, and then reports the lines dropped last
+func copyConsoleLines(writer io.Writer, reader io.Reader, limiter *consoleRateLimiter) error {
+	var pending []byte
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := reader.Read(buf)
+		pending = writeConsoleLines(writer, append(pending, buf[:n]...), limiter)
+		if err == io.EOF {
+			if len(pending) != 0 {
+				writeConsoleLines(writer, append(pending, '\n'), limiter)
+			}
+			if dropped := limiter.flush(); dropped != 0 {
+				fmt.Fprintf(writer, "[%d console lines dropped]\n", dropped)
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// ConsolePath returns the name of a FIFO whose lines go to the named log at
+// the console log rate
+func (r *remoteLog) ConsolePath(n string) string {
+	path := filepath.Join(r.fifoDir, n+".log")
+	if err := syscall.Mkfifo(path, 0600); err != nil && err.(syscall.Errno) != syscall.EEXIST {
+		return "/dev/null"
+	}
+	logrus.Infof("Creating %s at %s", "func", logutils.GetMyStack())
+	go func() {
+		// In a goroutine because Open of the FIFO will block until
+		// containerd opens it when the task is started.
+		fifo, err := os.OpenFile(path, os.O_RDONLY, 0)
+		if err != nil {
+			logrus.Printf("failed to open fifo %s: %s", path, err)
+			return
+		}
+		defer fifo.Close()
+		writer, err := r.Open(n)
+		if err != nil {
+			logrus.Printf("failed to open log %s: %s", n, err)
+			// Do not block the domain on its console
+			io.Copy(io.Discard, fifo)
+			return
+		}
+		defer writer.Close()
+		limiter := consoleRateLimiter{
+			rate: func() int { return int(consoleLogRate.Load()) },
+		}
+		if err := copyConsoleLines(writer, fifo, &limiter); err != nil {
+			logrus.Printf("failed to copy fifo %s to log %s: %s", path, n, err)
+		}
+	}()
+	return path
+}
@@ -0,0 +1,57 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package containerd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConsoleRateLimiter(t *testing.T) {
+	now := time.Now()
+	limiter := consoleRateLimiter{rate: func() int { return 2 }}
+	for i, expected := range []bool{true, true, false, false} {
+		ok, dropped := limiter.allow(now)
+		assert.Equal(t, expected, ok, "line %d", i)
+		assert.Zero(t, dropped)
+	}
+	ok, dropped := limiter.allow(now.Add(time.Second))
+	assert.True(t, ok)
+	assert.Equal(t, 2, dropped)
+}
+
+func TestWriteConsoleLines(t *testing.T) {
+	var out bytes.Buffer
+	limiter := consoleRateLimiter{rate: func() int { return 100 }}
+	left := writeConsoleLines(&out, []byte("[    0.000000] Linux version\r\nlogin: "), &limiter)
+	assert.Equal(t, "[    0.000000] Linux version\n", out.String())
+	assert.Equal(t, "login: ", string(left))
+
+	// Lines without end are cut
+	out.Reset()
+	long := strings.Repeat("x", consoleLineMaxSize+10)
+	left = writeConsoleLines(&out, []byte(long), &limiter)
+	assert.Equal(t, long[:consoleLineMaxSize]+"\n", out.String())
+	assert.Equal(t, long[consoleLineMaxSize:], string(left))
+}
+
+func TestCopyConsoleLines(t *testing.T) {
+	var out bytes.Buffer
+	limiter := consoleRateLimiter{rate: func() int { return 0 }}
+	err := copyConsoleLines(&out, strings.NewReader("dropped\nagain\n"), &limiter)
+	assert.NoError(t, err)
+	// The lines dropped last are reported at the end
+	assert.Equal(t, "[2 console lines dropped]\n", out.String())
+
+	// The last line is written even without end
+	out.Reset()
+	limiter = consoleRateLimiter{rate: func() int { return 100 }}
+	err = copyConsoleLines(&out, strings.NewReader("one\ntwo"), &limiter)
+	assert.NoError(t, err)
+	assert.Equal(t, "one\ntwo\n", out.String())
+}
@@ -460,8 +460,9 @@ func (client *Client) CtrContainerInfo(ctx context.Context, name string) (int, i
 	return int(t.Pid()), int(stat.ExitStatus), string(stat.Status), nil
 }
 
-// CtrCreateTask creates (but doesn't start) the default task in a pre-existing container and attaches its logging to memlogd
-func (client *Client) CtrCreateTask(ctx context.Context, domainName string) (int, error) {
+// CtrCreateTask creates (but doesn't start) the default task in a pre-existing container and attaches its logging to memlogd.
+// If console is set the stdout of the task is the console of a VM, logged at the console log rate.
+func (client *Client) CtrCreateTask(ctx context.Context, domainName string, console bool) (int, error) {
 	if err := client.verifyCtr(ctx, true); err != nil {
 		return 0, fmt.Errorf("CtrStartContainer: exception while verifying ctrd client: %s", err.Error())
 	}
@@ -474,6 +475,9 @@ func (client *Client) CtrCreateTask(ctx context.Context, domainName string) (int
 
 	io := func(id string) (cio.IO, error) {
 		stdoutFile := logger.Path("guest_vm-" + domainName)
+		if console {
+			stdoutFile = logger.ConsolePath("guest_vm-" + domainName)
+		}
 		stderrFile := logger.Path("guest_vm_err-" + domainName)
 		return &logio{
 			cio.Config{
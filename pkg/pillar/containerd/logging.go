@@ -46,6 +46,7 @@ func (c *logio) Close() error {
 // Log provides access to a log by path or io.WriteCloser
 type Log interface {
 	Path(string) string                  // Path of the log file (may be a FIFO)
+	ConsolePath(string) string           // Path of the rate limited log of a console
 	Open(string) (io.WriteCloser, error) // Opens a log stream
 	Dump(string)                         // Copies logs to the console
 }
@@ -77,6 +78,11 @@ func (f *nullLog) Path(n string) string {
 	return "/dev/null"
 }
 
+// ConsolePath returns the name of a log file path for the named console.
+func (f *nullLog) ConsolePath(n string) string {
+	return "/dev/null"
+}
+
 // Open a log file for the named service.
 func (f *nullLog) Open(n string) (io.WriteCloser, error) {
 	return nullWriterCloser{io.Discard}, nil
@@ -331,6 +331,9 @@ func (s *ociSpec) UpdateFromDomain(dom *types.DomainConfig, status *types.Domain
 		if status.VmConfig.CPUs != "" {
 			s.Linux.Resources.CPU.Cpus = status.VmConfig.CPUs
 		}
+		if status.VmConfig.CPUMems != "" {
+			s.Linux.Resources.CPU.Mems = status.VmConfig.CPUMems
+		}
 
 		s.Linux.CgroupsPath = fmt.Sprintf("/%s/%s", ctrdServicesNamespace, dom.GetTaskName())
 	}
@@ -451,10 +454,12 @@ func (s *ociSpec) UpdateMounts(disks []types.DiskStatus) error {
 
 		// we may need additional filtering here, but for now assume that
 		// we can bind mount anything aside from FmtUnknown
-		switch disk.Format {
-		case zconfig.Format_FmtUnknown:
+		switch {
+		case disk.Directory:
+			// directory volumes are mounted as they are
+		case disk.Format == zconfig.Format_FmtUnknown:
 			continue
-		case zconfig.Format_CONTAINER:
+		case disk.Format == zconfig.Format_CONTAINER:
 			if path.Clean(disk.MountDir) == "/" {
 				// skipping root volumes for now
 				continue
@@ -468,7 +473,7 @@ func (s *ociSpec) UpdateMounts(disks []types.DiskStatus) error {
 			dests = append(dests, "/dev/eve/volumes/by-name/"+disk.DisplayName)
 		}
 		if dst != "" {
-			if disk.Format != zconfig.Format_CONTAINER {
+			if disk.Format != zconfig.Format_CONTAINER && !disk.Directory {
 				// this is a bit of a hack: we assume that anything but
 				// the container image has to be a file and thus make it
 				// appear *under* destination directory as a file with ID
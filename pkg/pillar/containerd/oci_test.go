@@ -770,6 +770,28 @@ func TestUpdateMounts(t *testing.T) {
 	g.Expect(spec.UpdateMounts(tresAmigos)).To(HaveOccurred())
 }
 
+func TestUpdateMountsDirectory(t *testing.T) {
+	g := NewGomegaWithT(t)
+	spec := ociSpec{
+		name:    "test",
+		volumes: map[string]struct{}{},
+		Spec: specs.Spec{
+			Annotations: map[string]string{},
+		},
+	}
+
+	g.Expect(spec.UpdateMounts([]types.DiskStatus{
+		{MountDir: "/", Format: zconfig.Format_CONTAINER, FileLocation: "/foo/bar"},
+		{MountDir: "/data", DisplayName: "shared", FileLocation: "/foo/shared.dir", Directory: true},
+	})).ToNot(HaveOccurred())
+	g.Expect(spec.Mounts).To(ConsistOf([]specs.Mount{
+		{Destination: "/dev/eve/volumes/by-id/1", Type: "bind", Source: "/foo/shared.dir", Options: []string{"rbind", "rw"}},
+		{Destination: "/dev/eve/volumes/by-name/shared", Type: "bind", Source: "/foo/shared.dir", Options: []string{"rbind", "rw"}},
+		{Destination: "/data", Type: "bind", Source: "/foo/shared.dir", Options: []string{"rbind", "rw"}},
+	}))
+	g.Expect(spec.Annotations).To(Equal(map[string]string{eveOCIMountPointsLabel: ""}))
+}
+
 func TestEnvs(t *testing.T) {
 	g := NewGomegaWithT(t)
 	spec := ociSpec{
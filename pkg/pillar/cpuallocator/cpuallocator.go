@@ -5,6 +5,7 @@ package cpuallocator
 
 import (
 	"fmt"
+	"sort"
 	"sync"
 
 	uuid "github.com/satori/go.uuid"
@@ -23,6 +24,38 @@ func (cpus *cpusList) contains(cpuToCheck int) bool {
 	return false
 }
 
+// Policy of the placement of the CPUs of an allocation in the host topology
+type Policy string
+
+const (
+	// PolicyCompact allocates the lowest numbered free CPUs
+	PolicyCompact Policy = "compact"
+	// PolicyFullCores allocates whole cores only, leaving the SMT
+	// siblings of the allocated CPUs idle
+	PolicyFullCores Policy = "full-cores"
+	// PolicyNUMALocal allocates CPUs from a single NUMA node, the one of
+	// the assigned PCI devices if known
+	PolicyNUMALocal Policy = "numa-local"
+	// PolicySpread spreads the CPUs over the last level caches and the
+	// cores of the host
+	PolicySpread Policy = "spread"
+)
+
+// ParsePolicy checks that policy is a known Policy
+func ParsePolicy(policy string) error {
+	switch Policy(policy) {
+	case PolicyCompact, PolicyFullCores, PolicyNUMALocal, PolicySpread:
+		return nil
+	}
+	return fmt.Errorf("unknown CPU placement policy %s", policy)
+}
+
+// Placement of an allocation
+type Placement struct {
+	Policy Policy
+	Node   int // NUMA node to allocate from with PolicyNUMALocal, -1 if any
+}
+
 // CPUAllocator stores information about the CPUs available in the system
 // and provides interface to allocate and free them, per UUID.
 type CPUAllocator struct {
@@ -30,6 +63,7 @@ type CPUAllocator struct {
 	CPUsUsedByUUIDs   map[uuid.UUID]cpusList // per UUID list of allocated CPUs
 	totalCPUs         int                    // total amount of CPUs in the system
 	numReservedForEVE int                    // amount of the CPUs reserved for the EVE services
+	topology          Topology               // where the CPUs sit in the system
 }
 
 // Init initializes a CPUAllocator instance.
@@ -37,6 +71,13 @@ type CPUAllocator struct {
 // numReserved is the number of CPUs considered to be always free, reserved for
 // the EVE services and VMs with no CPU pinning enabled.
 func Init(totalCPUs int, numReserved int) (*CPUAllocator, error) {
+	return InitWithTopology(FlatTopology(totalCPUs), numReserved)
+}
+
+// InitWithTopology initializes a CPUAllocator instance placing the CPUs
+// it allocates in the topology of the system.
+func InitWithTopology(topology Topology, numReserved int) (*CPUAllocator, error) {
+	totalCPUs := len(topology.CPUs)
 	if totalCPUs <= 0 || numReserved < 0 || numReserved >= totalCPUs {
 		return nil, fmt.Errorf("invalid totalCPUs %d and/or numReserved %d",
 			totalCPUs, numReserved)
@@ -45,21 +86,47 @@ func Init(totalCPUs int, numReserved int) (*CPUAllocator, error) {
 		CPUsUsedByUUIDs:   make(map[uuid.UUID]cpusList),
 		totalCPUs:         totalCPUs,
 		numReservedForEVE: numReserved,
+		topology:          topology,
 	}, nil
 }
 
+// Topology returns the topology of the CPUs of the system
+func (cpuAllocator *CPUAllocator) Topology() Topology {
+	return cpuAllocator.topology
+}
+
 // Allocate a list of CPUs for a given uuid. If the amount of available CPUs is
 // less than the requested amount (numCPUs), return an error and an empty list.
 // If an allocation for a given uuid was already done before, also return an error
 // and an empty list.
 func (cpuAllocator *CPUAllocator) Allocate(uuid uuid.UUID, numCPUs int) ([]int, error) {
+	return cpuAllocator.AllocatePlaced(uuid, numCPUs, Placement{Policy: PolicyCompact, Node: -1})
+}
+
+// AllocatePlaced allocates a list of CPUs for a given uuid like Allocate,
+// choosing them according to placement. With PolicyFullCores the list
+// holds all the SMT siblings of the allocated cores, so it may be longer
+// than numCPUs.
+func (cpuAllocator *CPUAllocator) AllocatePlaced(uuid uuid.UUID, numCPUs int,
+	placement Placement) ([]int, error) {
 	cpuAllocator.Lock()
 	defer cpuAllocator.Unlock()
 	if _, ok := cpuAllocator.CPUsUsedByUUIDs[uuid]; ok {
 		// Already allocated; return error
 		return []int{}, fmt.Errorf("multiple allocations for %s", uuid)
 	}
-	list, err := cpuAllocator.getFree(numCPUs)
+	var list []int
+	var err error
+	switch placement.Policy {
+	case PolicyFullCores:
+		list, err = cpuAllocator.getFreeCores(numCPUs)
+	case PolicyNUMALocal:
+		list, err = cpuAllocator.getFreeInNode(numCPUs, placement.Node)
+	case PolicySpread:
+		list, err = cpuAllocator.getFreeSpread(numCPUs)
+	default:
+		list, err = cpuAllocator.getFree(numCPUs)
+	}
 	if err != nil {
 		return list, err
 	}
@@ -106,6 +173,129 @@ func (cpuAllocator *CPUAllocator) getFree(numCPUsRequested int) ([]int, error) {
 	return result, nil
 }
 
+func (cpuAllocator *CPUAllocator) isFree(cpu int) bool {
+	return cpu >= cpuAllocator.numReservedForEVE && !cpuAllocator.usedByAnyUUID(cpu)
+}
+
+// Find the lowest numbered cores with all their CPUs free, enough of them
+// to hold numCPUsRequested CPUs
+func (cpuAllocator *CPUAllocator) getFreeCores(numCPUsRequested int) ([]int, error) {
+	cores := make(map[int][]int)
+	for cpu, info := range cpuAllocator.topology.CPUs {
+		cores[info.Core] = append(cores[info.Core], cpu)
+	}
+	ids := make([]int, 0, len(cores))
+	for id := range cores {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	result := make([]int, 0, cpuAllocator.totalCPUs)
+	found := 0
+	for _, id := range ids {
+		if found >= numCPUsRequested {
+			break
+		}
+		free := true
+		for _, cpu := range cores[id] {
+			free = free && cpuAllocator.isFree(cpu)
+		}
+		if free {
+			result = append(result, cores[id]...)
+			found += len(cores[id])
+		}
+	}
+	if found < numCPUsRequested {
+		return []int{}, fmt.Errorf("looking for %d CPUs on free cores only found %d",
+			numCPUsRequested, found)
+	}
+	sort.Ints(result)
+	return result, nil
+}
+
+// Find the lowest numbered free CPUs of a NUMA node, the one with the most
+// free CPUs if node is unknown, one per core first
+func (cpuAllocator *CPUAllocator) getFreeInNode(numCPUsRequested int, node int) ([]int, error) {
+	if node < 0 {
+		freeInNode := make(map[int]int)
+		for cpu, info := range cpuAllocator.topology.CPUs {
+			if cpuAllocator.isFree(cpu) {
+				freeInNode[info.Node]++
+			}
+		}
+		for n, free := range freeInNode {
+			if free > freeInNode[node] || (free == freeInNode[node] && n < node) {
+				node = n
+			}
+		}
+	}
+	var free []int
+	for cpu, info := range cpuAllocator.topology.CPUs {
+		if info.Node == node && cpuAllocator.isFree(cpu) {
+			free = append(free, cpu)
+		}
+	}
+	if len(free) < numCPUsRequested {
+		return []int{}, fmt.Errorf("looking for %d CPUs in NUMA node %d only found %d",
+			numCPUsRequested, node, len(free))
+	}
+	result := cpuAllocator.siblingsLast(free)[:numCPUsRequested]
+	sort.Ints(result)
+	return result, nil
+}
+
+// Find free CPUs taking them in turn from each last level cache, one per
+// core first
+func (cpuAllocator *CPUAllocator) getFreeSpread(numCPUsRequested int) ([]int, error) {
+	caches := make(map[int][]int)
+	var ids []int
+	for cpu, info := range cpuAllocator.topology.CPUs {
+		if !cpuAllocator.isFree(cpu) {
+			continue
+		}
+		if _, ok := caches[info.Cache]; !ok {
+			ids = append(ids, info.Cache)
+		}
+		caches[info.Cache] = append(caches[info.Cache], cpu)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		caches[id] = cpuAllocator.siblingsLast(caches[id])
+	}
+	result := make([]int, 0, cpuAllocator.totalCPUs)
+	for turn := 0; len(result) < numCPUsRequested; turn++ {
+		taken := false
+		for _, id := range ids {
+			if turn < len(caches[id]) && len(result) < numCPUsRequested {
+				result = append(result, caches[id][turn])
+				taken = true
+			}
+		}
+		if !taken {
+			return []int{}, fmt.Errorf("looking for %d CPUs only found %d",
+				numCPUsRequested, len(result))
+		}
+	}
+	sort.Ints(result)
+	return result, nil
+}
+
+// siblingsLast orders the CPUs with the first free CPU of each core first
+// and their SMT siblings after them
+func (cpuAllocator *CPUAllocator) siblingsLast(cpus []int) []int {
+	var first, siblings []int
+	seen := make(map[int]bool)
+	for _, cpu := range cpus {
+		core := cpuAllocator.topology.CPUs[cpu].Core
+		if seen[core] {
+			siblings = append(siblings, cpu)
+		} else {
+			seen[core] = true
+			first = append(first, cpu)
+		}
+	}
+	return append(first, siblings...)
+}
+
 // GetAllFree returns all free CPUs (except the reserved ones)
 func (cpuAllocator *CPUAllocator) GetAllFree() []int {
 	cpuAllocator.RLock()
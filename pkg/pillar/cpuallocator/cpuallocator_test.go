@@ -204,3 +204,85 @@ func TestAllocate(t *testing.T) {
 		assert.Equal(t, test.expectAllFree, all)
 	}
 }
+
+// two NUMA nodes of two cores with two SMT threads sharing a last level cache
+var twoNodesTopology = Topology{CPUs: []CPUInfo{
+	{Core: 0, Node: 0, Cache: 0},
+	{Core: 1, Node: 0, Cache: 0},
+	{Core: 2, Node: 1, Cache: 2},
+	{Core: 3, Node: 1, Cache: 2},
+	{Core: 0, Node: 0, Cache: 0},
+	{Core: 1, Node: 0, Cache: 0},
+	{Core: 2, Node: 1, Cache: 2},
+	{Core: 3, Node: 1, Cache: 2},
+}}
+
+func TestAllocatePlaced(t *testing.T) {
+	testMatrix := map[string]struct {
+		placement        Placement
+		allocate         int
+		expectFail       bool
+		expectAllocation []int
+	}{
+		"compact": {
+			placement:        Placement{Policy: PolicyCompact, Node: -1},
+			allocate:         3,
+			expectAllocation: []int{1, 2, 3},
+		},
+		"full cores": {
+			placement:        Placement{Policy: PolicyFullCores, Node: -1},
+			allocate:         2,
+			expectAllocation: []int{1, 5},
+		},
+		"full cores rounded up": {
+			placement:        Placement{Policy: PolicyFullCores, Node: -1},
+			allocate:         3,
+			expectAllocation: []int{1, 2, 5, 6},
+		},
+		"full cores too many": {
+			placement:  Placement{Policy: PolicyFullCores, Node: -1},
+			allocate:   7,
+			expectFail: true,
+		},
+		"numa local": {
+			placement:        Placement{Policy: PolicyNUMALocal, Node: 1},
+			allocate:         2,
+			expectAllocation: []int{2, 3},
+		},
+		"numa local any node": {
+			placement:        Placement{Policy: PolicyNUMALocal, Node: -1},
+			allocate:         3,
+			expectAllocation: []int{2, 3, 6},
+		},
+		"numa local too many": {
+			placement:  Placement{Policy: PolicyNUMALocal, Node: 0},
+			allocate:   4,
+			expectFail: true,
+		},
+		"spread": {
+			placement:        Placement{Policy: PolicySpread, Node: -1},
+			allocate:         4,
+			expectAllocation: []int{1, 2, 3, 4},
+		},
+		"spread too many": {
+			placement:  Placement{Policy: PolicySpread, Node: -1},
+			allocate:   8,
+			expectFail: true,
+		},
+	}
+	for testname, test := range testMatrix {
+		t.Run(testname, func(t *testing.T) {
+			ca, err := InitWithTopology(twoNodesTopology, 1)
+			assert.Nil(t, err)
+			id, _ := uuid.NewV4()
+			cpus, err := ca.AllocatePlaced(id, test.allocate, test.placement)
+			if test.expectFail {
+				assert.NotNil(t, err)
+				assert.Equal(t, 8, len(ca.GetAllFree()))
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, test.expectAllocation, cpus)
+		})
+	}
+}
@@ -0,0 +1,199 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cpuallocator
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	sysfsCPUDir        = "/sys/devices/system/cpu"
+	sysfsNodeDir       = "/sys/devices/system/node"
+	sysfsPciDevicesDir = "/sys/bus/pci/devices"
+)
+
+// CPUInfo describes where a CPU sits in the host topology
+type CPUInfo struct {
+	Core  int // lowest numbered CPU of the core, shared by the SMT siblings
+	Node  int // NUMA node, -1 if unknown
+	Cache int // lowest numbered CPU sharing the last level cache
+}
+
+// Topology of the host CPUs, indexed by CPU number
+type Topology struct {
+	CPUs []CPUInfo
+}
+
+// FlatTopology returns the topology of totalCPUs CPUs with neither SMT
+// siblings nor a known NUMA node
+func FlatTopology(totalCPUs int) Topology {
+	topology := Topology{CPUs: make([]CPUInfo, totalCPUs)}
+	for cpu := range topology.CPUs {
+		topology.CPUs[cpu] = CPUInfo{Core: cpu, Node: -1, Cache: cpu}
+	}
+	return topology
+}
+
+// ReadTopology reads the topology of the host CPUs from sysfs
+func ReadTopology() (Topology, error) {
+	return readTopology(sysfsCPUDir, sysfsNodeDir)
+}
+
+func readTopology(cpuDir string, nodeDir string) (Topology, error) {
+	online, err := readCPUList(filepath.Join(cpuDir, "online"))
+	if err != nil {
+		return Topology{}, err
+	}
+	if len(online) == 0 || online[len(online)-1] != len(online)-1 {
+		return Topology{}, fmt.Errorf("online CPUs %v are not contiguous", online)
+	}
+	topology := FlatTopology(len(online))
+	for cpu := range topology.CPUs {
+		dir := filepath.Join(cpuDir, fmt.Sprintf("cpu%d", cpu))
+		siblings, err := readCPUList(filepath.Join(dir, "topology", "thread_siblings_list"))
+		if err != nil {
+			return Topology{}, err
+		}
+		if len(siblings) != 0 {
+			topology.CPUs[cpu].Core = siblings[0]
+		}
+		// No last level cache is reported on some platforms, keep
+		// each core in a cache domain of its own then
+		topology.CPUs[cpu].Cache = topology.CPUs[cpu].Core
+		if shared, err := readLastLevelCache(dir); err == nil && len(shared) != 0 {
+			topology.CPUs[cpu].Cache = shared[0]
+		}
+	}
+	// Without NUMA support there is no node directory at all
+	nodes, _ := filepath.Glob(filepath.Join(nodeDir, "node[0-9]*"))
+	for _, path := range nodes {
+		node, err := strconv.Atoi(strings.TrimPrefix(filepath.Base(path), "node"))
+		if err != nil {
+			continue
+		}
+		cpus, err := readCPUList(filepath.Join(path, "cpulist"))
+		if err != nil {
+			return Topology{}, err
+		}
+		for _, cpu := range cpus {
+			if cpu < len(topology.CPUs) {
+				topology.CPUs[cpu].Node = node
+			}
+		}
+	}
+	return topology, nil
+}
+
+// readLastLevelCache returns the CPUs sharing the highest level cache of
+// the CPU in dir
+func readLastLevelCache(dir string) ([]int, error) {
+	indexes, err := filepath.Glob(filepath.Join(dir, "cache", "index[0-9]*"))
+	if err != nil || len(indexes) == 0 {
+		return nil, fmt.Errorf("no cache information in %s", dir)
+	}
+	var shared []int
+	bestLevel := -1
+	for _, index := range indexes {
+		level, err := readInt(filepath.Join(index, "level"))
+		if err != nil || level <= bestLevel {
+			continue
+		}
+		cpus, err := readCPUList(filepath.Join(index, "shared_cpu_list"))
+		if err != nil {
+			continue
+		}
+		bestLevel = level
+		shared = cpus
+	}
+	return shared, nil
+}
+
+// DeviceNUMANode returns the NUMA node of the PCI device, -1 if unknown
+func DeviceNUMANode(pciLong string) int {
+	node, err := readInt(filepath.Join(sysfsPciDevicesDir, pciLong, "numa_node"))
+	if err != nil {
+		return -1
+	}
+	return node
+}
+
+// NodeCPUs returns the CPUs of the NUMA node
+func (topology Topology) NodeCPUs(node int) []int {
+	var cpus []int
+	for cpu, info := range topology.CPUs {
+		if info.Node == node {
+			cpus = append(cpus, cpu)
+		}
+	}
+	return cpus
+}
+
+// NodesOf returns the NUMA nodes of the CPUs, nil if unknown
+func (topology Topology) NodesOf(cpus []int) []int {
+	var nodes []int
+	seen := make(map[int]bool)
+	for _, cpu := range cpus {
+		if cpu < 0 || cpu >= len(topology.CPUs) {
+			continue
+		}
+		node := topology.CPUs[cpu].Node
+		if node < 0 || seen[node] {
+			continue
+		}
+		seen[node] = true
+		nodes = append(nodes, node)
+	}
+	sort.Ints(nodes)
+	return nodes
+}
+
+func readInt(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
+
+func readCPUList(path string) ([]int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseCPUList(strings.TrimSpace(string(data)))
+}
+
+// ParseCPUList parses a list of CPUs in the kernel format e.g., "0-3,8,10-11"
+func ParseCPUList(list string) ([]int, error) {
+	var cpus []int
+	if list == "" {
+		return cpus, nil
+	}
+	for _, item := range strings.Split(list, ",") {
+		first, last, isRange := strings.Cut(item, "-")
+		start, err := strconv.Atoi(first)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CPU list %s: %v", list, err)
+		}
+		end := start
+		if isRange {
+			if end, err = strconv.Atoi(last); err != nil {
+				return nil, fmt.Errorf("invalid CPU list %s: %v", list, err)
+			}
+		}
+		if end < start {
+			return nil, fmt.Errorf("invalid CPU list %s", list)
+		}
+		for cpu := start; cpu <= end; cpu++ {
+			cpus = append(cpus, cpu)
+		}
+	}
+	sort.Ints(cpus)
+	return cpus, nil
+}
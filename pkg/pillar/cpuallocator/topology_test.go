@@ -0,0 +1,79 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package cpuallocator
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeSysfs(t *testing.T, root string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReadTopology(t *testing.T) {
+	root := t.TempDir()
+	cpuDir := filepath.Join(root, "cpu")
+	nodeDir := filepath.Join(root, "node")
+	files := map[string]string{
+		"cpu/online":         "0-7",
+		"node/node0/cpulist": "0-1,4-5",
+		"node/node1/cpulist": "2-3,6-7",
+	}
+	for cpu, siblings := range []string{"0,4", "1,5", "2,6", "3,7", "0,4", "1,5", "2,6", "3,7"} {
+		dir := filepath.Join("cpu", fmt.Sprintf("cpu%d", cpu))
+		files[filepath.Join(dir, "topology", "thread_siblings_list")] = siblings
+		files[filepath.Join(dir, "cache", "index0", "level")] = "1"
+		files[filepath.Join(dir, "cache", "index0", "shared_cpu_list")] = siblings
+		files[filepath.Join(dir, "cache", "index3", "level")] = "3"
+		if cpu%4 < 2 {
+			files[filepath.Join(dir, "cache", "index3", "shared_cpu_list")] = "0-1,4-5"
+		} else {
+			files[filepath.Join(dir, "cache", "index3", "shared_cpu_list")] = "2-3,6-7"
+		}
+	}
+	writeSysfs(t, root, files)
+
+	topology, err := readTopology(cpuDir, nodeDir)
+	assert.Nil(t, err)
+	assert.Equal(t, twoNodesTopology, topology)
+	assert.Equal(t, []int{2, 3, 6, 7}, topology.NodeCPUs(1))
+	assert.Equal(t, []int{0, 1}, topology.NodesOf([]int{5, 2, 1}))
+}
+
+func TestReadTopologyNoNUMA(t *testing.T) {
+	root := t.TempDir()
+	writeSysfs(t, root, map[string]string{
+		"cpu/online":                             "0-1",
+		"cpu/cpu0/topology/thread_siblings_list": "0",
+		"cpu/cpu1/topology/thread_siblings_list": "1",
+	})
+
+	topology, err := readTopology(filepath.Join(root, "cpu"), filepath.Join(root, "node"))
+	assert.Nil(t, err)
+	assert.Equal(t, FlatTopology(2), topology)
+	assert.Nil(t, topology.NodesOf([]int{0, 1}))
+}
+
+func TestParseCPUList(t *testing.T) {
+	cpus, err := ParseCPUList("8,0-3,10-11")
+	assert.Nil(t, err)
+	assert.Equal(t, []int{0, 1, 2, 3, 8, 10, 11}, cpus)
+	_, err = ParseCPUList("3-1")
+	assert.NotNil(t, err)
+	_, err = ParseCPUList("a")
+	assert.NotNil(t, err)
+}
@@ -620,9 +620,36 @@ func (r *LinuxDpcReconciler) gcpChanged(newGCP types.ConfigItemValueMap) bool {
 	if prevAllowVNC != newAllowVNC {
 		return true
 	}
+	prevPeers := r.prevArgs.GCP.GlobalValueBool(types.DownloadFromPeers)
+	newPeers := newGCP.GlobalValueBool(types.DownloadFromPeers)
+	if prevPeers != newPeers {
+		return true
+	}
+	prevMaxCost := r.prevArgs.GCP.GlobalValueInt(types.DownloadMaxPortCost)
+	newMaxCost := newGCP.GlobalValueInt(types.DownloadMaxPortCost)
+	if newPeers && prevMaxCost != newMaxCost {
+		return true
+	}
 	return false
 }
 
+// peerContentPorts returns the management ports downloader shares the
+// content with the other EVE devices over, those it may download over
+func peerContentPorts(dpc types.DevicePortConfig,
+	gcp types.ConfigItemValueMap) (ifNames []string) {
+	if !gcp.GlobalValueBool(types.DownloadFromPeers) {
+		return nil
+	}
+	maxCost := gcp.GlobalValueInt(types.DownloadMaxPortCost)
+	for _, port := range dpc.Ports {
+		if !port.IsMgmt || port.IfName == "" || uint32(port.Cost) > maxCost {
+			continue
+		}
+		ifNames = append(ifNames, port.IfName)
+	}
+	return ifNames
+}
+
 func (r *LinuxDpcReconciler) updateCurrentState(args Args) (changed bool) {
 	if r.currentState == nil {
 		// Initialize only subgraphs with external items.
@@ -1673,6 +1700,28 @@ func (r *LinuxDpcReconciler) getIntendedACLs(
 	protoMarkV6Rules := []iptables.Rule{
 		markSSHAndGuacamole, markVnc, markIcmpV6,
 	}
+	// Allow the other EVE devices on the LAN to fetch content from this one
+	// and the mDNS traffic they find each other with, over the management
+	// ports downloader shares the content over.
+	for _, ifName := range peerContentPorts(dpc, gcp) {
+		markPeerContent := iptables.Rule{
+			RuleLabel: "Peer content mark for " + ifName,
+			MatchOpts: []string{"-i", ifName, "-p", "tcp", "--dport",
+				strconv.Itoa(types.PeerContentPort)},
+			Target:      "CONNMARK",
+			TargetOpts:  []string{"--set-mark", iptables.ControlProtocolMarkingIDMap["in_peer_content"]},
+			Description: "Mark ingress traffic of content shared between EVE devices",
+		}
+		markMDNS := iptables.Rule{
+			RuleLabel:   "mDNS mark for " + ifName,
+			MatchOpts:   []string{"-i", ifName, "-p", "udp", "--dport", "5353"},
+			Target:      "CONNMARK",
+			TargetOpts:  []string{"--set-mark", iptables.ControlProtocolMarkingIDMap["in_peer_content"]},
+			Description: "Mark ingress mDNS traffic advertising content shared between EVE devices",
+		}
+		protoMarkV4Rules = append(protoMarkV4Rules, markPeerContent, markMDNS)
+		protoMarkV6Rules = append(protoMarkV6Rules, markPeerContent, markMDNS)
+	}
 
 	// Mark ingress traffic not matched by the rules above with the DROP action.
 	// Create a separate chain for marking.
@@ -862,3 +862,61 @@ func TestVlansAndBonds(test *testing.T) {
 	t.Expect(vlan200.ParentLL).To(BeEquivalentTo("bond-shopfloor"))
 	t.Expect(vlan200.ParentIfName).To(BeEquivalentTo("bond0"))
 }
+
+func TestPeerContentACLs(test *testing.T) {
+	t := initTest(test)
+	dpc := types.DevicePortConfig{
+		Version:      types.DPCIsMgmt,
+		Key:          "zedagent",
+		TimePriority: time.Now(),
+	}
+	for i, cost := range []uint8{0, 10} {
+		ifName := fmt.Sprintf("eth%d", i)
+		networkMonitor.AddOrUpdateInterface(netmonitor.MockInterface{
+			Attrs: netmonitor.IfAttrs{
+				IfIndex:       i + 1,
+				IfName:        ifName,
+				IfType:        "device",
+				WithBroadcast: true,
+				AdminUp:       true,
+				LowerUp:       true,
+			},
+			HwAddr: macAddress(fmt.Sprintf("02:00:00:00:00:0%d", i+1)),
+		})
+		dpc.Ports = append(dpc.Ports, types.NetworkPortConfig{
+			IfName:       ifName,
+			Phylabel:     ifName,
+			Logicallabel: "mock-" + ifName,
+			IsMgmt:       true,
+			IsL3Port:     true,
+			Cost:         cost,
+			DhcpConfig: types.DhcpConfig{
+				Dhcp: types.DhcpTypeClient,
+				Type: types.NetworkTypeIPv4,
+			},
+		})
+	}
+	gcp := types.DefaultConfigItemValueMap()
+	ctx := reconciler.MockRun(context.Background())
+	status := dpcReconciler.Reconcile(ctx, dpcrec.Args{GCP: *gcp, DPC: dpc})
+	t.Expect(status.Error).To(BeNil())
+	t.Expect(itemIsCreatedWithLabel("Peer content mark for eth0")).To(BeFalse())
+
+	// Only open the ports downloader shares the content over
+	gcp = types.DefaultConfigItemValueMap()
+	gcp.SetGlobalValueBool(types.DownloadFromPeers, true)
+	ctx = reconciler.MockRun(context.Background())
+	status = dpcReconciler.Reconcile(ctx, dpcrec.Args{GCP: *gcp, DPC: dpc})
+	t.Expect(status.Error).To(BeNil())
+	t.Expect(itemIsCreatedWithLabel("Peer content mark for eth0")).To(BeTrue())
+	t.Expect(itemIsCreatedWithLabel("mDNS mark for eth0")).To(BeTrue())
+	t.Expect(itemIsCreatedWithLabel("Peer content mark for eth1")).To(BeFalse())
+
+	gcp = types.DefaultConfigItemValueMap()
+	gcp.SetGlobalValueBool(types.DownloadFromPeers, true)
+	gcp.SetGlobalValueInt(types.DownloadMaxPortCost, 10)
+	ctx = reconciler.MockRun(context.Background())
+	status = dpcReconciler.Reconcile(ctx, dpcrec.Args{GCP: *gcp, DPC: dpc})
+	t.Expect(status.Error).To(BeNil())
+	t.Expect(itemIsCreatedWithLabel("Peer content mark for eth1")).To(BeTrue())
+}
@@ -0,0 +1,667 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package hypervisor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	zconfig "github.com/lf-edge/eve-api/go/config"
+	"github.com/lf-edge/eve/pkg/pillar/types"
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+)
+
+// ChvHypervisorName is a name of cloud-hypervisor hypervisor
+const ChvHypervisorName = "cloud-hypervisor"
+
+// cloud-hypervisor is a VMM for modern guests: it only emulates virtio
+// devices, boots kernels directly or with a firmware, and takes a small
+// fraction of the memory qemu needs. It runs in the xen-tools container
+// as qemu does, and is driven through its REST API
+//
+//	https://github.com/cloud-hypervisor/cloud-hypervisor/blob/main/docs/api.md
+//
+// For every domain we maintain the following entry points in the
+// chvStateDir/<domain name> folder:
+//
+//	api         cloud-hypervisor API socket
+//	vm.json     VM config to create the VM from (also in the domainmgr file)
+//	domain.json what else Start needs to know about the domain
+//	fs<N>.sock  virtiofsd socket of each shared directory
+const chvStateDir = "/run/hypervisor/chv/"
+
+const (
+	chvExec       = "/usr/lib/xen/bin/cloud-hypervisor"
+	chvAPITimeout = 10 * time.Second
+	// Directories are shared with a virtio-fs tag as for 9P with qemu
+	chvShareTag = "share_dir"
+)
+
+type chvContext struct {
+	ctrdContext
+	chvExec       string
+	virtiofsdExec string
+	capabilities  *types.Capabilities
+}
+
+func newChv() Hypervisor {
+	ctrdCtx, err := initContainerd()
+	if err != nil {
+		logrus.Fatalf("couldn't initialize containerd (this should not happen): %v. Exiting.", err)
+		return nil // it really never returns on account of above
+	}
+	return chvContext{
+		ctrdContext:   *ctrdCtx,
+		chvExec:       chvExec,
+		virtiofsdExec: "/usr/lib/xen/bin/virtiofsd",
+	}
+}
+
+// chvVMConfig is the subset of the VmConfig of the cloud-hypervisor API
+// we use
+type chvVMConfig struct {
+	Cpus    chvCpus     `json:"cpus"`
+	Memory  chvMemory   `json:"memory"`
+	Payload chvPayload  `json:"payload"`
+	Disks   []chvDisk   `json:"disks,omitempty"`
+	Net     []chvNet    `json:"net,omitempty"`
+	Fs      []chvFs     `json:"fs,omitempty"`
+	Devices []chvDevice `json:"devices,omitempty"`
+	Rng     chvRng      `json:"rng"`
+	Serial  chvConsole  `json:"serial"`
+	Console chvConsole  `json:"console"`
+}
+
+type chvCpus struct {
+	BootVcpus int `json:"boot_vcpus"`
+	MaxVcpus  int `json:"max_vcpus"`
+}
+
+type chvMemory struct {
+	Size int64 `json:"size"` // bytes
+	// Shared is needed by vhost-user devices e.g. virtio-fs
+	Shared bool `json:"shared,omitempty"`
+}
+
+type chvPayload struct {
+	Firmware  string `json:"firmware,omitempty"`
+	Kernel    string `json:"kernel,omitempty"`
+	Initramfs string `json:"initramfs,omitempty"`
+	Cmdline   string `json:"cmdline,omitempty"`
+}
+
+type chvDisk struct {
+	ID       string `json:"id"`
+	Path     string `json:"path"`
+	Readonly bool   `json:"readonly,omitempty"`
+}
+
+type chvNet struct {
+	ID  string `json:"id"`
+	Tap string `json:"tap"`
+	Mac string `json:"mac"`
+}
+
+type chvFs struct {
+	ID        string `json:"id"`
+	Tag       string `json:"tag"`
+	Socket    string `json:"socket"`
+	NumQueues int    `json:"num_queues"`
+	QueueSize int    `json:"queue_size"`
+}
+
+type chvDevice struct {
+	ID   string `json:"id"`
+	Path string `json:"path"`
+}
+
+type chvRng struct {
+	Src string `json:"src"`
+}
+
+type chvConsole struct {
+	Mode string `json:"mode"`
+}
+
+// chvDomain is what Start needs to know about the domain besides its VM
+// config
+type chvDomain struct {
+	// Shares are the directories to serve with virtiofsd
+	Shares []virtiofsShare
+	// Vifs are the taps cloud-hypervisor creates, to add to their bridge
+	Vifs []chvVif
+}
+
+type chvVif struct {
+	Vif    string
+	Bridge string
+}
+
+func getChvAPISocket(domainName string) string {
+	return filepath.Join(chvStateDir, domainName, "api")
+}
+
+func getChvVMConfig(domainName string) string {
+	return filepath.Join(chvStateDir, domainName, "vm.json")
+}
+
+func getChvDomain(domainName string) string {
+	return filepath.Join(chvStateDir, domainName, "domain.json")
+}
+
+// chvAPI calls endpoint of the cloud-hypervisor API of the domain with
+// request (nil for none) and unmarshals the response into response unless nil
+func chvAPI(domainName, method, endpoint string, request interface{}, response interface{}) error {
+	client := http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", getChvAPISocket(domainName))
+			},
+		},
+		Timeout: chvAPITimeout,
+	}
+	var body io.Reader
+	if request != nil {
+		b, err := json.Marshal(request)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, "http://localhost/api/v1/"+endpoint, body)
+	if err != nil {
+		return err
+	}
+	if request != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("chvAPI(%s): %w", endpoint, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("chvAPI(%s): %w", endpoint, err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("chvAPI(%s): %s: %s", endpoint, resp.Status,
+			strings.TrimSpace(string(data)))
+	}
+	if response == nil || len(data) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(data, response); err != nil {
+		return fmt.Errorf("chvAPI(%s): %w", endpoint, err)
+	}
+	return nil
+}
+
+// chvVMInfo is returned by vm.info
+type chvVMInfo struct {
+	// State is Created, Running, Shutdown, Paused or BreakPoint
+	State            string `json:"state"`
+	MemoryActualSize int64  `json:"memory_actual_size"`
+}
+
+func (ctx chvContext) Name() string {
+	return ChvHypervisorName
+}
+
+func (ctx chvContext) Task(status *types.DomainStatus) types.Task {
+	if status.VirtualizationMode == types.NOHYPER {
+		return ctx.ctrdContext
+	}
+	return ctx
+}
+
+func (ctx chvContext) GetCapabilities() (*types.Capabilities, error) {
+	if ctx.capabilities != nil {
+		return ctx.capabilities, nil
+	}
+	vtd, err := kvmContext{}.checkIOVirtualisation()
+	if err != nil {
+		return nil, fmt.Errorf("fail in check IOVirtualization: %v", err)
+	}
+	ctx.capabilities = &types.Capabilities{
+		HWAssistedVirtualization: true,
+		IOVirtualization:         vtd,
+		CPUPinning:               true,
+	}
+	return ctx.capabilities, nil
+}
+
+// cloud-hypervisor itself needs a few megabytes, plus the page tables of
+// the guest memory as for qemu, plus about 1MB per vCPU, plus the
+// virtiofsd of the directory volumes
+func chvVMMOverhead(config types.DomainConfig) int64 {
+	cpus := int64(config.MaxCpus)
+	if cpus == 0 {
+		cpus = int64(config.VCpus)
+	}
+	return 16<<20 + ramVMMOverhead(config) + cpus*(1<<20) + virtiofsdVMMOverhead(config)
+}
+
+func (ctx chvContext) Setup(status types.DomainStatus, config types.DomainConfig,
+	aa *types.AssignableAdapters, globalConfig *types.ConfigItemValueMap, file *os.File) error {
+
+	domainName := status.DomainName
+	if err := os.MkdirAll(chvStateDir+domainName, 0777); err != nil {
+		return logError("failed to create state directory for domain %s: %v", domainName, err)
+	}
+	if err := ctx.CreateDomConfig(domainName, config, status, status.DiskStatusList, aa, file); err != nil {
+		return logError("failed to build domain config: %v", err)
+	}
+	// Start creates the VM from a copy of the config
+	vmConfig, err := os.ReadFile(file.Name())
+	if err != nil {
+		return logError("failed to read domain config: %v", err)
+	}
+	if err := os.WriteFile(getChvVMConfig(domainName), vmConfig, 0644); err != nil {
+		return logError("failed to save domain config: %v", err)
+	}
+	domain, err := json.Marshal(chvDomainFromConfig(domainName, config, status.DiskStatusList))
+	if err != nil {
+		return logError("failed to marshal domain %s: %v", domainName, err)
+	}
+	if err := os.WriteFile(getChvDomain(domainName), domain, 0644); err != nil {
+		return logError("failed to save domain %s: %v", domainName, err)
+	}
+
+	args := []string{ctx.chvExec, "--api-socket", "path=" + getChvAPISocket(domainName)}
+
+	spec, err := ctx.setupSpec(&status, &config, status.OCIConfigDir)
+	if err != nil {
+		return logError("failed to load OCI spec for domain %s: %v", status.DomainName, err)
+	}
+	if err = spec.AddLoader("/containers/services/xen-tools"); err != nil {
+		return logError("failed to add cloud-hypervisor loader to domain %s: %v", status.DomainName, err)
+	}
+	overhead, err := vmmOverhead(domainName, config, globalConfig, func() (int64, error) {
+		return chvVMMOverhead(config), nil
+	})
+	if err != nil {
+		return logError("vmmOverhead() failed for domain %s: %v",
+			status.DomainName, err)
+	}
+	logrus.Debugf("cloud-hypervisor overhead for domain %s is %d bytes", status.DomainName, overhead)
+	spec.AdjustMemLimit(config, overhead)
+	spec.Get().Process.Args = args
+	logrus.Infof("Hypervisor args: %v", args)
+
+	if err := spec.CreateContainer(true); err != nil {
+		return logError("Failed to create container for task %s from %v: %v", status.DomainName, config, err)
+	}
+
+	return nil
+}
+
+// chvDomainFromConfig returns what Start needs to know about the domain
+func chvDomainFromConfig(domainName string, config types.DomainConfig,
+	diskStatusList []types.DiskStatus) chvDomain {
+	domain := chvDomain{}
+	for i, ds := range diskStatusList {
+		switch ds.Devtype {
+		case "9P":
+			share := virtiofsShareOf(filepath.Join(chvStateDir, domainName), i, ds)
+			share.Tag = chvShareTag
+			domain.Shares = append(domain.Shares, share)
+		case "virtiofs":
+			domain.Shares = append(domain.Shares,
+				virtiofsShareOf(filepath.Join(chvStateDir, domainName), i, ds))
+		}
+	}
+	for _, vif := range config.VifList {
+		domain.Vifs = append(domain.Vifs, chvVif{Vif: vif.Vif, Bridge: vif.Bridge})
+	}
+	return domain
+}
+
+// CreateDomConfig writes the cloud-hypervisor VM config of the domain
+func (ctx chvContext) CreateDomConfig(domainName string, config types.DomainConfig, status types.DomainStatus,
+	diskStatusList []types.DiskStatus, aa *types.AssignableAdapters, file *os.File) error {
+
+	if config.IsOCIContainer() {
+		return logError("%s: containers are not supported by cloud-hypervisor", domainName)
+	}
+	if config.VirtualizationModeOrDefault() == types.LEGACY {
+		return logError("%s: legacy devices are not supported by cloud-hypervisor", domainName)
+	}
+	vm := chvVMConfig{
+		Cpus: chvCpus{BootVcpus: config.VCpus, MaxVcpus: config.MaxCpus},
+		Memory: chvMemory{
+			Size: int64(config.Memory) << 10,
+		},
+		Payload: chvPayload{
+			Kernel:    config.Kernel,
+			Initramfs: config.Ramdisk,
+			Cmdline:   config.ExtraArgs,
+		},
+		Rng: chvRng{Src: "/dev/urandom"},
+		// The serial console ends up in the logs of the domain
+		Serial:  chvConsole{Mode: "Tty"},
+		Console: chvConsole{Mode: "Off"},
+	}
+	if vm.Cpus.MaxVcpus < vm.Cpus.BootVcpus {
+		vm.Cpus.MaxVcpus = vm.Cpus.BootVcpus
+	}
+	if config.Kernel == "" {
+		if config.BootLoader == "" {
+			return logError("%s: cloud-hypervisor needs a kernel or a firmware to boot", domainName)
+		}
+		vm.Payload.Firmware = config.BootLoader
+	}
+
+	domain := chvDomainFromConfig(domainName, config, diskStatusList)
+	shares := domain.Shares
+	for i, ds := range diskStatusList {
+		switch ds.Devtype {
+		case "", "AppCustom":
+			// Nothing for the guest to see, see kvm
+			continue
+		case "9P", "virtiofs":
+			vm.Fs = append(vm.Fs, chvFs{
+				ID:        shares[0].ID,
+				Tag:       shares[0].Tag,
+				Socket:    shares[0].Socket,
+				NumQueues: 1,
+				QueueSize: 1024,
+			})
+			shares = shares[1:]
+			vm.Memory.Shared = true
+			continue
+		case "legacy":
+			return logError("%s: legacy disk %s is not supported by cloud-hypervisor",
+				domainName, ds.FileLocation)
+		}
+		if ds.WWN != "" {
+			return logError("%s: vhost-scsi disk %s is not supported by cloud-hypervisor",
+				domainName, ds.WWN)
+		}
+		switch ds.Format {
+		case zconfig.Format_RAW, zconfig.Format_QCOW2, zconfig.Format_VHDX:
+		default:
+			return logError("%s: disk format %s of %s is not supported by cloud-hypervisor",
+				domainName, ds.Format, ds.FileLocation)
+		}
+		vm.Disks = append(vm.Disks, chvDisk{
+			ID:   fmt.Sprintf("disk%d", i),
+			Path: ds.FileLocation,
+			// There is no emulated CD-ROM, ISOs are read-only disks
+			Readonly: ds.ReadOnly || ds.Devtype == "cdrom",
+		})
+	}
+
+	for i, vif := range config.VifList {
+		vm.Net = append(vm.Net, chvNet{
+			ID:  fmt.Sprintf("net%d", i),
+			Tap: vif.Vif,
+			Mac: vif.Mac.String(),
+		})
+	}
+
+	// Only PCI devices can be assigned, through vfio as for kvm
+	for _, adapter := range config.IoAdapterList {
+		list := aa.LookupIoBundleAny(adapter.Name)
+		// We reserved it in handleCreate so nobody could have stolen it
+		if len(list) == 0 {
+			return logError("IoBundle disappeared %d %s for %s",
+				adapter.Type, adapter.Name, domainName)
+		}
+		for _, ib := range list {
+			if ib == nil {
+				continue
+			}
+			if ib.UsedByUUID != config.UUIDandVersion.UUID {
+				return logError("IoBundle not ours %s: %d %s for %s",
+					ib.UsedByUUID, adapter.Type, adapter.Name, domainName)
+			}
+			if ib.PciLong == "" || ib.UsbAddr != "" {
+				logrus.Warnf("%s: cloud-hypervisor can only assign PCI devices, skipping %s",
+					domainName, ib.Phylabel)
+				continue
+			}
+			device := chvDevice{
+				ID:   "dev" + strings.NewReplacer(":", "", ".", "").Replace(ib.PciLong),
+				Path: filepath.Join(sysfsPciDevices, ib.PciLong),
+			}
+			duplicate := false
+			for _, d := range vm.Devices {
+				duplicate = duplicate || d.Path == device.Path
+			}
+			if !duplicate {
+				vm.Devices = append(vm.Devices, device)
+			}
+		}
+	}
+
+	data, err := json.MarshalIndent(vm, "", "  ")
+	if err != nil {
+		return logError("can't marshal config of %s (%v)", domainName, err)
+	}
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		return logError("can't write to config file %s (%v)", file.Name(), err)
+	}
+	return nil
+}
+
+// waitForChvAPI waits for cloud-hypervisor to serve its API
+func waitForChvAPI(domainName string) error {
+	var err error
+	for waited := time.Duration(0); waited < chvAPITimeout; waited += time.Second {
+		if err = chvAPI(domainName, http.MethodGet, "vmm.ping", nil, nil); err == nil {
+			return nil
+		}
+		time.Sleep(time.Second)
+	}
+	return logError("cloud-hypervisor API of %s not available: %v", domainName, err)
+}
+
+func (ctx chvContext) Start(domainName string) error {
+	return ctx.start(domainName, "")
+}
+
+// Restore starts the domain from the state saved into stateFile by Checkpoint
+func (ctx chvContext) Restore(domainName string, stateFile string) error {
+	return ctx.start(domainName, stateFile)
+}
+
+// start starts cloud-hypervisor then creates and boots the VM, or restores
+// it from stateFile if set
+func (ctx chvContext) start(domainName string, stateFile string) error {
+	logrus.Infof("starting cloud-hypervisor domain %s", domainName)
+	var domain chvDomain
+	data, err := os.ReadFile(getChvDomain(domainName))
+	if err != nil {
+		return logError("failed to read domain %s: %v", domainName, err)
+	}
+	if err := json.Unmarshal(data, &domain); err != nil {
+		return logError("failed to unmarshal domain %s: %v", domainName, err)
+	}
+	if err := ctx.ctrdContext.Start(domainName); err != nil {
+		logrus.Errorf("couldn't start task for domain %s: %v", domainName, err)
+		return err
+	}
+	if err := waitForChvAPI(domainName); err != nil {
+		return err
+	}
+
+	if err := startVirtiofsd(ctx.ctrdClient, domainName, ctx.virtiofsdExec, domain.Shares); err != nil {
+		return logError("domain %s: %v", domainName, err)
+	}
+
+	if stateFile != "" {
+		logrus.Infof("restoring cloud-hypervisor domain %s from %s", domainName, stateFile)
+		if err := chvAPI(domainName, http.MethodPut, "vm.restore", struct {
+			SourceURL string `json:"source_url"`
+		}{SourceURL: "file://" + stateFile}, nil); err != nil {
+			return logError("failed to restore domain %s from %s: %v", domainName, stateFile, err)
+		}
+	} else {
+		vmConfig, err := os.ReadFile(getChvVMConfig(domainName))
+		if err != nil {
+			return logError("failed to read config of domain %s: %v", domainName, err)
+		}
+		if err := chvAPI(domainName, http.MethodPut, "vm.create", json.RawMessage(vmConfig), nil); err != nil {
+			return logError("failed to create domain %s: %v", domainName, err)
+		}
+	}
+
+	// cloud-hypervisor created the taps
+	for _, vif := range domain.Vifs {
+		if err := attachVif(vif); err != nil {
+			return logError("failed to attach %s of domain %s: %v", vif.Vif, domainName, err)
+		}
+	}
+
+	endpoint := "vm.boot"
+	if stateFile != "" {
+		endpoint = "vm.resume"
+	}
+	if err := chvAPI(domainName, http.MethodPut, endpoint, nil, nil); err != nil {
+		return logError("failed to start domain %s: %v", domainName, err)
+	}
+	return nil
+}
+
+// attachVif adds the tap of the vif to its bridge as the qemu-ifup script
+// does for qemu
+func attachVif(vif chvVif) error {
+	link, err := netlink.LinkByName(vif.Vif)
+	if err != nil {
+		return err
+	}
+	bridge, err := netlink.LinkByName(vif.Bridge)
+	if err != nil {
+		return err
+	}
+	if err := netlink.LinkSetMaster(link, bridge); err != nil {
+		return err
+	}
+	return netlink.LinkSetUp(link)
+}
+
+// Checkpoint pauses the domain and saves its device and memory state into
+// the stateFile directory, leaving it paused. The domain is resumed if the
+// state cannot be saved, for it to be shut down instead.
+func (ctx chvContext) Checkpoint(domainName string, stateFile string) error {
+	if err := chvAPI(domainName, http.MethodPut, "vm.pause", nil, nil); err != nil {
+		return logError("Checkpoint(%s): failed to pause: %v", domainName, err)
+	}
+	if err := chvSnapshot(domainName, stateFile); err != nil {
+		if resumeErr := chvAPI(domainName, http.MethodPut, "vm.resume", nil, nil); resumeErr != nil {
+			logrus.Errorf("Checkpoint(%s): failed to resume: %v",
+				domainName, resumeErr)
+		}
+		return logError("Checkpoint(%s): %v", domainName, err)
+	}
+	return nil
+}
+
+// chvSnapshot saves the state of the paused domain into the stateFile
+// directory
+func chvSnapshot(domainName string, stateFile string) error {
+	// Do not leave a truncated state behind on failure
+	tmpDir := stateFile + ".tmp"
+	os.RemoveAll(tmpDir)
+	if err := os.MkdirAll(tmpDir, 0700); err != nil {
+		return err
+	}
+	logrus.Infof("chvSnapshot(%s): saving state into %s", domainName, stateFile)
+	if err := chvAPI(domainName, http.MethodPut, "vm.snapshot", struct {
+		DestinationURL string `json:"destination_url"`
+	}{DestinationURL: "file://" + tmpDir}, nil); err != nil {
+		os.RemoveAll(tmpDir)
+		return err
+	}
+	os.RemoveAll(stateFile)
+	if err := os.Rename(tmpDir, stateFile); err != nil {
+		os.RemoveAll(tmpDir)
+		return err
+	}
+	return nil
+}
+
+func (ctx chvContext) Stop(domainName string, force bool) error {
+	endpoint := "vm.power-button"
+	if force {
+		endpoint = "vm.shutdown"
+	}
+	if err := chvAPI(domainName, http.MethodPut, endpoint, nil, nil); err != nil {
+		return logError("Stop: failed to stop domain %s: %v", domainName, err)
+	}
+	return nil
+}
+
+func (ctx chvContext) Delete(domainName string) error {
+	// The VM may well be gone already
+	if err := chvAPI(domainName, http.MethodPut, "vm.shutdown", nil, nil); err != nil {
+		logrus.Infof("Delete(%s): %v", domainName, err)
+	}
+	if err := chvAPI(domainName, http.MethodPut, "vmm.shutdown", nil, nil); err != nil {
+		return logError("failed to shut cloud-hypervisor down for domain %s: %v", domainName, err)
+	}
+	if err := os.RemoveAll(chvStateDir + domainName); err != nil {
+		return logError("failed to clean up domain state directory %s (%v)", domainName, err)
+	}
+	return nil
+}
+
+func (ctx chvContext) Info(domainName string) (int, types.SwState, error) {
+	// first we ask for the task status
+	effectiveDomainID, effectiveDomainState, err := ctx.ctrdContext.Info(domainName)
+	if err != nil || effectiveDomainState != types.RUNNING {
+		return effectiveDomainID, effectiveDomainState, err
+	}
+
+	// if task is alive, we augment task status with the state of the VM
+	stateMap := map[string]types.SwState{
+		"Created":    types.BOOTING,
+		"Running":    types.RUNNING,
+		"Paused":     types.PAUSED,
+		"BreakPoint": types.PAUSED,
+		"Shutdown":   types.HALTING,
+	}
+	var info chvVMInfo
+	if err := chvAPI(domainName, http.MethodGet, "vm.info", nil, &info); err != nil {
+		return effectiveDomainID, types.BROKEN, logError("couldn't retrieve status for domain %s: %v", domainName, err)
+	}
+	state, matched := stateMap[info.State]
+	if !matched {
+		return effectiveDomainID, types.BROKEN, logError("domain %s reported to be in unexpected state %s", domainName, info.State)
+	}
+	return effectiveDomainID, state, nil
+}
+
+func (ctx chvContext) Cleanup(domainName string) error {
+	if err := ctx.ctrdContext.Cleanup(domainName); err != nil {
+		return fmt.Errorf("couldn't cleanup task %s: %v", domainName, err)
+	}
+	return nil
+}
+
+// PCIReserve binds the device to vfio-pci as for kvm
+func (ctx chvContext) PCIReserve(long string) error {
+	return kvmContext{}.PCIReserve(long)
+}
+
+// PCIRelease gives the device back to its driver as for kvm
+func (ctx chvContext) PCIRelease(long string) error {
+	return kvmContext{}.PCIRelease(long)
+}
+
+func (ctx chvContext) PCISameController(id1 string, id2 string) bool {
+	return kvmContext{}.PCISameController(id1, id2)
+}
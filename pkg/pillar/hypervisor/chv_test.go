@@ -0,0 +1,279 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package hypervisor
+
+import (
+	"net"
+	"os"
+	"testing"
+
+	zconfig "github.com/lf-edge/eve-api/go/config"
+	"github.com/lf-edge/eve/pkg/pillar/types"
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestChvCreateDomConfig(t *testing.T) {
+	id, err := uuid.NewV4()
+	if err != nil {
+		t.Errorf("NewV4 failed: %v", err)
+	}
+	config := types.DomainConfig{
+		UUIDandVersion: types.UUIDandVersion{UUID: id, Version: "1.0"},
+		VmConfig: types.VmConfig{
+			Kernel:             "/boot/kernel",
+			Ramdisk:            "/boot/ramdisk",
+			ExtraArgs:          "init=/bin/sh",
+			Memory:             1024 * 1024 * 10,
+			VCpus:              2,
+			VirtualizationMode: types.HVM,
+		},
+		VifList: []types.VifConfig{
+			{Bridge: "bn0", Mac: net.HardwareAddr{0x6a, 0x00, 0x03, 0x61, 0xa6, 0x90}, Vif: "nbu1x1"},
+			{Bridge: "bn0", Mac: net.HardwareAddr{0x6a, 0x00, 0x03, 0x61, 0xa6, 0x91}, Vif: "nbu1x2"},
+		},
+		IoAdapterList: []types.IoAdapter{
+			{Type: types.IoNetEth, Name: "eth0"},
+			{Type: types.IoUSB, Name: "USB1"},
+		},
+	}
+	firmware := types.DomainConfig{
+		UUIDandVersion: config.UUIDandVersion,
+		VmConfig: types.VmConfig{
+			BootLoader:         "/usr/lib/xen/boot/hypervisor-fw",
+			Memory:             512 * 1024,
+			VCpus:              1,
+			MaxCpus:            4,
+			VirtualizationMode: types.HVM,
+		},
+	}
+	disks := []types.DiskStatus{
+		{Format: zconfig.Format_QCOW2, FileLocation: "/foo/bar.qcow2", Devtype: "hdd"},
+		{Format: zconfig.Format_CONTAINER, FileLocation: "/foo/container", Devtype: "9P"},
+		{Format: zconfig.Format_RAW, FileLocation: "/foo/bar.raw", Devtype: "hdd"},
+		{Format: zconfig.Format_RAW, FileLocation: "/foo/cd.iso", Devtype: "cdrom"},
+		{Format: zconfig.Format_CONTAINER, FileLocation: "/foo/volume", Devtype: ""},
+	}
+	aa := types.AssignableAdapters{
+		Initialized: true,
+		IoBundleList: []types.IoBundle{
+			{
+				Type:            types.IoNetEth,
+				AssignmentGroup: "eth0-1",
+				Phylabel:        "eth0",
+				Ifname:          "eth0",
+				PciLong:         "0000:f3:00.0",
+				UsedByUUID:      config.UUIDandVersion.UUID,
+			},
+			{
+				Type:            types.IoUSB,
+				AssignmentGroup: "USB1",
+				Phylabel:        "USB1:1",
+				UsbAddr:         "1:1",
+				UsedByUUID:      config.UUIDandVersion.UUID,
+			},
+		},
+	}
+	legacy := config
+	legacy.VirtualizationMode = types.LEGACY
+	noKernel := config
+	noKernel.Kernel = ""
+
+	tests := []struct {
+		name     string
+		config   types.DomainConfig
+		disks    []types.DiskStatus
+		expected string
+		fails    bool
+	}{
+		{
+			name:   "kernel",
+			config: config,
+			disks:  disks,
+			expected: `{
+  "cpus": {
+    "boot_vcpus": 2,
+    "max_vcpus": 2
+  },
+  "memory": {
+    "size": 10737418240,
+    "shared": true
+  },
+  "payload": {
+    "kernel": "/boot/kernel",
+    "initramfs": "/boot/ramdisk",
+    "cmdline": "init=/bin/sh"
+  },
+  "disks": [
+    {
+      "id": "disk0",
+      "path": "/foo/bar.qcow2"
+    },
+    {
+      "id": "disk2",
+      "path": "/foo/bar.raw"
+    },
+    {
+      "id": "disk3",
+      "path": "/foo/cd.iso",
+      "readonly": true
+    }
+  ],
+  "net": [
+    {
+      "id": "net0",
+      "tap": "nbu1x1",
+      "mac": "6a:00:03:61:a6:90"
+    },
+    {
+      "id": "net1",
+      "tap": "nbu1x2",
+      "mac": "6a:00:03:61:a6:91"
+    }
+  ],
+  "fs": [
+    {
+      "id": "fs1",
+      "tag": "share_dir",
+      "socket": "/run/hypervisor/chv/test/fs1.sock",
+      "num_queues": 1,
+      "queue_size": 1024
+    }
+  ],
+  "devices": [
+    {
+      "id": "dev0000f3000",
+      "path": "/sys/bus/pci/devices/0000:f3:00.0"
+    }
+  ],
+  "rng": {
+    "src": "/dev/urandom"
+  },
+  "serial": {
+    "mode": "Tty"
+  },
+  "console": {
+    "mode": "Off"
+  }
+}
+`,
+		},
+		{
+			name:   "firmware",
+			config: firmware,
+			disks: []types.DiskStatus{
+				{Format: zconfig.Format_RAW, FileLocation: "/foo/bar.raw", Devtype: "hdd", ReadOnly: true},
+			},
+			expected: `{
+  "cpus": {
+    "boot_vcpus": 1,
+    "max_vcpus": 4
+  },
+  "memory": {
+    "size": 536870912
+  },
+  "payload": {
+    "firmware": "/usr/lib/xen/boot/hypervisor-fw"
+  },
+  "disks": [
+    {
+      "id": "disk0",
+      "path": "/foo/bar.raw",
+      "readonly": true
+    }
+  ],
+  "rng": {
+    "src": "/dev/urandom"
+  },
+  "serial": {
+    "mode": "Tty"
+  },
+  "console": {
+    "mode": "Off"
+  }
+}
+`,
+		},
+		{
+			name:   "legacy mode",
+			config: legacy,
+			disks:  disks,
+			fails:  true,
+		},
+		{
+			name:   "no kernel nor firmware",
+			config: noKernel,
+			disks:  disks,
+			fails:  true,
+		},
+		{
+			name:   "legacy disk",
+			config: config,
+			disks: []types.DiskStatus{
+				{Format: zconfig.Format_RAW, FileLocation: "/foo/bar.raw", Devtype: "legacy"},
+			},
+			fails: true,
+		},
+		{
+			name:   "vhost-scsi disk",
+			config: config,
+			disks: []types.DiskStatus{
+				{Format: zconfig.Format_RAW, WWN: "naa.000000000000000a", Devtype: "hdd"},
+			},
+			fails: true,
+		},
+		{
+			name:   "vmdk disk",
+			config: config,
+			disks: []types.DiskStatus{
+				{Format: zconfig.Format_VMDK, FileLocation: "/foo/bar.vmdk", Devtype: "hdd"},
+			},
+			fails: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conf, err := os.CreateTemp("/tmp", "config")
+			if err != nil {
+				t.Fatalf("Can't create config file for a domain %v", err)
+			}
+			defer os.Remove(conf.Name())
+
+			err = chvContext{}.CreateDomConfig("test", test.config, types.DomainStatus{}, test.disks, &aa, conf)
+			if test.fails {
+				if err == nil {
+					t.Errorf("CreateDomConfig succeeded for an unsupported config")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CreateDomConfig failed %v", err)
+			}
+
+			result, err := os.ReadFile(conf.Name())
+			if err != nil {
+				t.Errorf("reading conf file failed %v", err)
+			}
+			if string(result) != test.expected {
+				t.Errorf("got an unexpected resulting config %s", string(result))
+			}
+		})
+	}
+}
+
+func TestChvVMMOverhead(t *testing.T) {
+	config := types.DomainConfig{
+		VmConfig: types.VmConfig{
+			Memory: 512 * 1024,
+			VCpus:  2,
+		},
+	}
+	if overhead := chvVMMOverhead(config); overhead != 16<<20+ramVMMOverhead(config)+2<<20 {
+		t.Errorf("unexpected overhead %d", overhead)
+	}
+	config.MaxCpus = 4
+	if overhead := chvVMMOverhead(config); overhead != 16<<20+ramVMMOverhead(config)+4<<20 {
+		t.Errorf("unexpected overhead %d with MaxCpus", overhead)
+	}
+}
@@ -129,7 +129,9 @@ func (ctx ctrdContext) Create(domainName string, cfgFilename string, config *typ
 	defer done()
 	_ = ctx.ctrdClient.CtrStopContainer(ctrdCtx, domainName, true)
 
-	return ctx.ctrdClient.CtrCreateTask(ctrdCtx, domainName)
+	// Containers log their stdout as they always did
+	console := config.VirtualizationModeOrDefault() != types.NOHYPER
+	return ctx.ctrdClient.CtrCreateTask(ctrdCtx, domainName, console)
 }
 
 func (ctx ctrdContext) Start(domainName string) error {
@@ -182,6 +184,16 @@ func (ctx ctrdContext) Cleanup(domainName string) error {
 	return ctx.Delete(domainName)
 }
 
+// Checkpoint is not supported for containers
+func (ctx ctrdContext) Checkpoint(domainName string, _ string) error {
+	return fmt.Errorf("checkpoint of domain %s is not supported", domainName)
+}
+
+// Restore is not supported for containers
+func (ctx ctrdContext) Restore(domainName string, _ string) error {
+	return fmt.Errorf("restore of domain %s is not supported", domainName)
+}
+
 func (ctx ctrdContext) Annotations(domainName string) (map[string]string, error) {
 	ctrdCtx, done := ctx.ctrdClient.CtrNewUserServicesCtx()
 	defer done()
@@ -10,6 +10,7 @@ import (
 	"github.com/shirou/gopsutil/mem"
 	"github.com/sirupsen/logrus"
 	"os"
+	"path/filepath"
 )
 
 // Hypervisor provides methods for manipulating domains on the host
@@ -27,14 +28,69 @@ type Hypervisor interface {
 	GetCapabilities() (*types.Capabilities, error)
 }
 
+// EventReporter is implemented by the tasks which learn about the
+// domains from events sent by the hypervisor e.g., QMP events for kvm
+type EventReporter interface {
+	// LastEvent returns the last event about the domain if any
+	LastEvent(domainName string) (types.DomainEvent, bool)
+}
+
+// BalloonTask is implemented by the tasks which can resize the memory
+// of a running domain with a balloon
+type BalloonTask interface {
+	// SetMemoryTarget asks the guest to use memory kbytes
+	SetMemoryTarget(domainName string, memory int) error
+	// MemoryActual returns the memory in kbytes the guest currently has,
+	// which lags behind the target while the guest gives memory back
+	MemoryActual(domainName string) (int, error)
+}
+
+// HotplugTask is implemented by the tasks which can add vCPUs and memory
+// to a running domain
+type HotplugTask interface {
+	// HotplugCPUs adds vCPUs for the domain to have vcpus of them
+	HotplugCPUs(domainName string, vcpus int) error
+	// HotplugMemory adds memory for the domain to have memory kbytes
+	HotplugMemory(domainName string, memory int) error
+}
+
+// CrashDumpTask is implemented by the tasks which can dump the memory of
+// a domain for its crash to be analyzed
+type CrashDumpTask interface {
+	// DumpGuestMemory writes the memory of the domain into dumpFile
+	DumpGuestMemory(domainName string, dumpFile string) error
+}
+
+// GuestAgentTask is implemented by the tasks which can talk to an agent
+// running in the guest
+type GuestAgentTask interface {
+	// GuestInfo returns what the guest agent reports about the guest
+	GuestInfo(domainName string) (types.GuestInfo, error)
+	// FreezeFilesystems freezes the filesystems of the guest; returns the
+	// number of filesystems frozen
+	FreezeFilesystems(domainName string) (int, error)
+	// ThawFilesystems thaws the filesystems of the guest; returns the
+	// number of filesystems thawed
+	ThawFilesystems(domainName string) (int, error)
+}
+
 type hypervisorDesc struct {
 	constructor func() Hypervisor
 	dom0handle  string
+	// executable, if set, is the program running the domains which has to
+	// be shipped in the xen-tools container for the hypervisor to be
+	// available
+	executable string
 }
 
+// xenToolsRootfs is where the root filesystem of the xen-tools container,
+// which the domains run from, is found
+const xenToolsRootfs = "/containers/services/xen-tools/rootfs"
+
 var knownHypervisors = map[string]hypervisorDesc{
 	XenHypervisorName:        {constructor: newXen, dom0handle: "/proc/xen"},
 	KVMHypervisorName:        {constructor: newKvm, dom0handle: "/dev/kvm"},
+	ChvHypervisorName:        {constructor: newChv, dom0handle: "/dev/kvm", executable: chvExec},
 	ACRNHypervisorName:       {constructor: newAcrn, dom0handle: "/dev/acrn"},
 	ContainerdHypervisorName: {constructor: newContainerd, dom0handle: "/run/containerd/containerd.sock"},
 	NullHypervisorName:       {constructor: newNull, dom0handle: "/"},
@@ -42,7 +98,7 @@ var knownHypervisors = map[string]hypervisorDesc{
 
 // this is a priority order to pick a default hypervisor if multiple are available (more to less likely)
 var hypervisorPriority = []string{
-	XenHypervisorName, KVMHypervisorName, ACRNHypervisorName, ContainerdHypervisorName, NullHypervisorName,
+	XenHypervisorName, KVMHypervisorName, ChvHypervisorName, ACRNHypervisorName, ContainerdHypervisorName, NullHypervisorName,
 }
 
 // GetHypervisor returns a particular hypervisor implementation
@@ -60,9 +116,16 @@ func GetHypervisor(hint string) (Hypervisor, error) {
 func GetAvailableHypervisors() (all []string, enabled []string) {
 	all = hypervisorPriority
 	for _, v := range all {
-		if _, err := os.Stat(knownHypervisors[v].dom0handle); err == nil {
-			enabled = append(enabled, v)
+		desc := knownHypervisors[v]
+		if _, err := os.Stat(desc.dom0handle); err != nil {
+			continue
+		}
+		if desc.executable != "" {
+			if _, err := os.Stat(filepath.Join(xenToolsRootfs, desc.executable)); err != nil {
+				continue
+			}
 		}
+		enabled = append(enabled, v)
 	}
 	return
 }
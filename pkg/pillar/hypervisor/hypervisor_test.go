@@ -5,6 +5,8 @@ package hypervisor
 
 import (
 	"github.com/lf-edge/eve/pkg/pillar/types"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 )
@@ -24,7 +26,7 @@ func TestGetHypervisor(t *testing.T) {
 
 func TestGetAvailableHypervisors(t *testing.T) {
 	all, enabled := GetAvailableHypervisors()
-	expected := []string{"xen", "kvm", "acrn", "containerd", "null"}
+	expected := []string{"xen", "kvm", "cloud-hypervisor", "acrn", "containerd", "null"}
 
 	if !reflect.DeepEqual(all, expected) {
 		t.Errorf("wrong list of available hypervisors: %+q vs. %+q", all, expected)
@@ -41,3 +43,15 @@ func TestGetAvailableHypervisors(t *testing.T) {
 	}
 	t.Errorf("null is not in the list of enabled hypervisors")
 }
+
+func TestGetAvailableHypervisorsWithoutExecutable(t *testing.T) {
+	if _, err := os.Stat(filepath.Join(xenToolsRootfs, chvExec)); err == nil {
+		t.Skip("cloud-hypervisor is shipped on this system")
+	}
+	_, enabled := GetAvailableHypervisors()
+	for _, v := range enabled {
+		if v == ChvHypervisorName {
+			t.Errorf("%s enabled without %s", v, chvExec)
+		}
+	}
+}
@@ -4,6 +4,8 @@
 package hypervisor
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -60,6 +62,9 @@ const qemuConfTemplate = `# This file is automatically generated by domainmgr
 {{- if .DomainConfig.CPUsPinned }}
   cpu-pin = "on"
 {{- end -}}
+{{- if .SharedMemory }}
+  memory-backend = "mem"
+{{- end -}}
 {{- if eq .Machine "virt" }}
   accel = "kvm:tcg"
   gic-version = "host"
@@ -107,7 +112,13 @@ const qemuConfTemplate = `# This file is automatically generated by domainmgr
 [device]
   driver = "intel-iommu"
   caching-mode = "on"
-{{ end }}
+{{if .DomainConfig.PanicDevice}}
+[device]
+  driver = "pvpanic"
+{{end}}{{else}}{{if .DomainConfig.PanicDevice}}
+[device]
+  driver = "pvpanic-pci"
+{{end}}{{ end }}
 [realtime]
   mlock = "off"
 
@@ -133,11 +144,30 @@ const qemuConfTemplate = `# This file is automatically generated by domainmgr
 
 [memory]
   size = "{{.DomainConfig.Memory}}"
-
+{{- if and .DomainConfig.Hotplug (gt .DomainConfig.MaxMem .DomainConfig.Memory) }}
+  slots = "8"
+  maxmem = "{{.DomainConfig.MaxMem}}M"
+{{- end}}
+{{if .SharedMemory}}
+[object "mem"]
+  qom-type = "memory-backend-memfd"
+  size = "{{.DomainConfig.Memory}}M"
+  share = "on"
+{{end}}{{if and .DomainConfig.MinMem (lt .DomainConfig.MinMem .DomainConfig.Memory)}}
+[device "balloon0"]
+  driver = "virtio-balloon-pci"
+  deflate-on-oom = "on"
+{{end}}
 [smp-opts]
   cpus = "{{.DomainConfig.VCpus}}"
+{{- if and .DomainConfig.Hotplug (ne .Machine "virt") (gt .DomainConfig.MaxCpus .DomainConfig.VCpus) }}
+  maxcpus = "{{.DomainConfig.MaxCpus}}"
+  sockets = "1"
+  cores = "{{.DomainConfig.MaxCpus}}"
+{{- else}}
   sockets = "1"
   cores = "{{.DomainConfig.VCpus}}"
+{{- end}}
   threads = "1"
 
 [device]
@@ -157,7 +187,18 @@ const qemuConfTemplate = `# This file is automatically generated by domainmgr
   driver = "virtconsole"
   chardev = "charserial0"
   name = "org.lfedge.eve.console.0"
+{{if .DomainConfig.GuestAgent}}
+[chardev "charqga"]
+  backend = "socket"
+  path = "` + kvmStateDir + `{{.DomainConfig.DisplayName}}/qga"
+  server = "on"
+  wait = "off"
 
+[device "qga0"]
+  driver = "virtserialport"
+  chardev = "charqga"
+  name = "org.qemu.guest_agent.0"
+{{end}}
 {{if .DomainConfig.EnableVnc}}
 [vnc "default"]
   vnc = "0.0.0.0:{{if .DomainConfig.VncDisplay}}{{.DomainConfig.VncDisplay}}{{else}}0{{end}}"
@@ -246,6 +287,13 @@ const qemuDiskTemplate = `
   fsdev = "fsdev{{.DiskID}}"
   mount_tag = "share_dir"
   addr = "{{printf "0x%x" .PCIId}}"
+{{else if eq .Devtype "virtiofs"}}
+[device "pci.{{.FsID}}"]
+  driver = "pcie-root-port"
+  port = "1{{.PCIId}}"
+  chassis = "{{.PCIId}}"
+  bus = "pcie.0"
+  addr = "{{printf "0x%x" .PCIId}}"
 {{else}}
 [device "pci.{{.PCIId}}"]
   driver = "pcie-root-port"
@@ -359,6 +407,19 @@ const qemuUsbHostTemplate = `
 `
 
 const kvmStateDir = "/run/hypervisor/kvm/"
+
+// Saving or loading the state of a domain is bound by the disk bandwidth
+// and the size of its memory
+const kvmMigrationTimeout = 10 * time.Minute
+
+// So is dumping its memory
+const kvmDumpTimeout = 10 * time.Minute
+
+// shellQuote quotes s for the commands qemu runs with exec: migration URIs
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
 const sysfsVfioPciBind = "/sys/bus/pci/drivers/vfio-pci/bind"
 const sysfsPciDriversProbe = "/sys/bus/pci/drivers_probe"
 const vfioDriverPath = "/sys/bus/pci/drivers/vfio-pci"
@@ -370,6 +431,8 @@ const vfioDriverPath = "/sys/bus/pci/drivers/vfio-pci"
 //	 pid - contains PID of the anchor process
 //	 qmp - UNIX domain socket that allows us to talk to anchor process
 //	cons - symlink to /dev/pts/X that allows us to talk to the serial console of the domain
+//	virtiofs.json - directory volumes to share with virtio-fs, see virtiofs.go
+//	fsN.sock - virtiofsd socket of each of them
 //
 // In addition to that, we also maintain DOMAIN_NAME -> PID mapping in kvmContext, so we don't
 // have to look things up in the filesystem all the time (this also allows us to filter domains
@@ -383,6 +446,9 @@ type kvmContext struct {
 	dmCPUArgs    []string
 	dmFmlCPUArgs []string
 	capabilities *types.Capabilities
+	// virtiofsd serves directory volumes, devices for them are added
+	// once it runs since qemu can not wait for it to
+	virtiofsdExec string
 }
 
 func newKvm() Hypervisor {
@@ -398,22 +464,24 @@ func newKvm() Hypervisor {
 	switch runtime.GOARCH {
 	case "arm64":
 		return kvmContext{
-			ctrdContext:  *ctrdCtx,
-			devicemodel:  "virt",
-			dmExec:       "/usr/lib/xen/bin/qemu-system-aarch64",
-			dmArgs:       []string{"-display", "none", "-S", "-no-user-config", "-nodefaults", "-no-shutdown", "-overcommit", "mem-lock=on", "-overcommit", "cpu-pm=on", "-serial", "chardev:charserial0"},
-			dmCPUArgs:    []string{"-cpu", "host"},
-			dmFmlCPUArgs: []string{"-cpu", "host"},
+			ctrdContext:   *ctrdCtx,
+			devicemodel:   "virt",
+			dmExec:        "/usr/lib/xen/bin/qemu-system-aarch64",
+			dmArgs:        []string{"-display", "none", "-S", "-no-user-config", "-nodefaults", "-no-shutdown", "-overcommit", "mem-lock=on", "-overcommit", "cpu-pm=on", "-serial", "chardev:charserial0"},
+			dmCPUArgs:     []string{"-cpu", "host"},
+			dmFmlCPUArgs:  []string{"-cpu", "host"},
+			virtiofsdExec: "/usr/lib/xen/bin/virtiofsd",
 		}
 	case "amd64":
 		return kvmContext{
 			//nolint:godox // FIXME: Removing "-overcommit", "mem-lock=on", "-overcommit" for now, revisit it later as part of resource partitioning
-			ctrdContext:  *ctrdCtx,
-			devicemodel:  "pc-q35-3.1",
-			dmExec:       "/usr/lib/xen/bin/qemu-system-x86_64",
-			dmArgs:       []string{"-display", "none", "-S", "-no-user-config", "-nodefaults", "-no-shutdown", "-serial", "chardev:charserial0", "-no-hpet"},
-			dmCPUArgs:    []string{"-cpu", "host"},
-			dmFmlCPUArgs: []string{"-cpu", "host,hv_time,hv_relaxed,hv_vendor_id=eveitis,hypervisor=off,kvm=off"},
+			ctrdContext:   *ctrdCtx,
+			devicemodel:   "pc-q35-3.1",
+			dmExec:        "/usr/lib/xen/bin/qemu-system-x86_64",
+			dmArgs:        []string{"-display", "none", "-S", "-no-user-config", "-nodefaults", "-no-shutdown", "-serial", "chardev:charserial0", "-no-hpet"},
+			dmCPUArgs:     []string{"-cpu", "host"},
+			dmFmlCPUArgs:  []string{"-cpu", "host,hv_time,hv_relaxed,hv_vendor_id=eveitis,hypervisor=off,kvm=off"},
+			virtiofsdExec: "/usr/lib/xen/bin/virtiofsd",
 		}
 	}
 	return nil
@@ -471,7 +539,8 @@ func estimatedVMMOverhead(domainName string, config types.DomainConfig, aa *type
 			domainName, err)
 	}
 	overhead = undefinedVMMOverhead() + ramVMMOverhead(config) +
-		qemuVMMOverhead() + cpuVMMOverhead(config) + mmioOverhead
+		qemuVMMOverhead() + cpuVMMOverhead(config) + mmioOverhead +
+		virtiofsdVMMOverhead(config)
 
 	return overhead, nil
 }
@@ -588,9 +657,11 @@ func undefinedVMMOverhead() int64 {
 	return 350 << 20 // Mb in bytes
 }
 
+// vmmOverhead returns the memory the VMM of the domain may use on top of
+// the memory of the guest; estimate is used if not configured
 func vmmOverhead(domainName string, config types.DomainConfig,
 	globalConfig *types.ConfigItemValueMap,
-	aa *types.AssignableAdapters) (int64, error) {
+	estimate func() (int64, error)) (int64, error) {
 	var overhead int64
 
 	// Fetch VMM max memory setting (aka vmm overhead)
@@ -608,9 +679,9 @@ func vmmOverhead(domainName string, config types.DomainConfig,
 	}
 
 	if overhead == 0 {
-		overhead, err := estimatedVMMOverhead(domainName, config, aa)
+		overhead, err := estimate()
 		if err != nil {
-			return 0, logError("estimatedVMMOverhead() failed for domain %s: %v",
+			return 0, logError("estimating VMM overhead failed for domain %s: %v",
 				domainName, err)
 		}
 		return overhead, nil
@@ -642,7 +713,15 @@ func (ctx kvmContext) Setup(status types.DomainStatus, config types.DomainConfig
 		dmArgs = append(dmArgs, "-smbios", "type=1,product=OpenStack Compute")
 	}
 
+	if config.RestoreFile != "" {
+		// Wait for Restore to load the state
+		dmArgs = append(dmArgs, "-incoming", "defer")
+	}
+
 	os.MkdirAll(kvmStateDir+domainName, 0777)
+	if err := saveVirtiofsShares(domainName, diskStatusList); err != nil {
+		return logError("failed to save virtio-fs shares of domain %s: %v", domainName, err)
+	}
 
 	args := []string{ctx.dmExec}
 	args = append(args, dmArgs...)
@@ -658,7 +737,9 @@ func (ctx kvmContext) Setup(status types.DomainStatus, config types.DomainConfig
 	if err = spec.AddLoader("/containers/services/xen-tools"); err != nil {
 		return logError("failed to add kvm hypervisor loader to domain %s: %v", status.DomainName, err)
 	}
-	overhead, err := vmmOverhead(domainName, config, globalConfig, aa)
+	overhead, err := vmmOverhead(domainName, config, globalConfig, func() (int64, error) {
+		return estimatedVMMOverhead(domainName, config, aa)
+	})
 	if err != nil {
 		return logError("vmmOverhead() failed for domain %s: %v",
 			status.DomainName, err)
@@ -678,11 +759,18 @@ func (ctx kvmContext) Setup(status types.DomainStatus, config types.DomainConfig
 func (ctx kvmContext) CreateDomConfig(domainName string, config types.DomainConfig, status types.DomainStatus,
 	diskStatusList []types.DiskStatus, aa *types.AssignableAdapters, file *os.File) error {
 	tmplCtx := struct {
-		Machine string
+		Machine      string
+		SharedMemory bool
 		types.DomainConfig
 		types.DomainStatus
-	}{ctx.devicemodel, config, status}
+	}{ctx.devicemodel, false, config, status}
+	// vhost-user devices need the guest memory to be shared with virtiofsd
+	for _, ds := range diskStatusList {
+		tmplCtx.SharedMemory = tmplCtx.SharedMemory || ds.Devtype == "virtiofs"
+	}
 	tmplCtx.DomainConfig.Memory = (config.Memory + 1023) / 1024
+	tmplCtx.DomainConfig.MinMem = config.MinMem / 1024
+	tmplCtx.DomainConfig.MaxMem = (config.MaxMem + 1023) / 1024
 	tmplCtx.DomainConfig.DisplayName = domainName
 
 	// render global device model settings
@@ -696,13 +784,14 @@ func (ctx kvmContext) CreateDomConfig(domainName string, config types.DomainConf
 		Machine                          string
 		PCIId, DiskID, SATAId, NumQueues int
 		AioType                          string
+		FsID                             string
 		types.DiskStatus
 	}{Machine: ctx.devicemodel, PCIId: 4, DiskID: 0, SATAId: 0, AioType: "io_uring", NumQueues: config.VCpus}
 
 	t, _ = template.New("qemuDisk").
 		Funcs(template.FuncMap{"Fmt": func(f zconfig.Format) string { return strings.ToLower(f.String()) }}).
 		Parse(qemuDiskTemplate)
-	for _, ds := range diskStatusList {
+	for i, ds := range diskStatusList {
 		if ds.Devtype == "" {
 			continue
 		}
@@ -712,6 +801,8 @@ func (ctx kvmContext) CreateDomConfig(domainName string, config types.DomainConf
 			continue
 		}
 		diskContext.DiskStatus = ds
+		// the device is added on the port by start, see virtiofs.go
+		diskContext.FsID = virtiofsShareOf(kvmStateDir+domainName, i, ds).ID
 		if err := t.Execute(file, diskContext); err != nil {
 			return logError("can't write to config file %s (%v)", file.Name(), err)
 		}
@@ -887,6 +978,17 @@ func waitForQmp(domainName string, available bool) error {
 }
 
 func (ctx kvmContext) Start(domainName string) error {
+	return ctx.start(domainName, "")
+}
+
+// Restore starts the domain from the state saved into stateFile by Checkpoint
+func (ctx kvmContext) Restore(domainName string, stateFile string) error {
+	return ctx.start(domainName, stateFile)
+}
+
+// start starts qemu then lets the domain run, after loading its state from
+// stateFile if set
+func (ctx kvmContext) start(domainName string, stateFile string) error {
 	logrus.Infof("starting KVM domain %s", domainName)
 	if err := ctx.ctrdContext.Start(domainName); err != nil {
 		logrus.Errorf("couldn't start task for domain %s: %v", domainName, err)
@@ -903,7 +1005,22 @@ func (ctx kvmContext) Start(domainName string) error {
 
 	logrus.Debugf("starting qmpEventHandler")
 	logrus.Infof("Creating %s at %s", "qmpEventHandler", agentlog.GetMyStack())
-	go qmpEventHandler(getQmpListenerSocket(domainName), getQmpExecutorSocket(domainName))
+	forgetDomainEvents(domainName)
+	go qmpEventHandler(domainName, getQmpListenerSocket(domainName), getQmpExecutorSocket(domainName))
+
+	if err := ctx.addVirtiofsDevices(domainName, qmpFile); err != nil {
+		return logError("failed to share directories with domain %s: %v", domainName, err)
+	}
+
+	if stateFile != "" {
+		logrus.Infof("restoring KVM domain %s from %s", domainName, stateFile)
+		if err := execMigrateIncoming(qmpFile, "exec:cat "+shellQuote(stateFile)); err != nil {
+			return logError("failed to restore domain %s from %s: %v", domainName, stateFile, err)
+		}
+		if err := waitForIncomingMigration(qmpFile, kvmMigrationTimeout); err != nil {
+			return logError("failed to restore domain %s from %s: %v", domainName, stateFile, err)
+		}
+	}
 
 	annotations, err := ctx.ctrdContext.Annotations(domainName)
 	if err != nil {
@@ -927,6 +1044,124 @@ func (ctx kvmContext) Start(domainName string) error {
 	return nil
 }
 
+func getVirtiofsSharesFile(domainName string) string {
+	return kvmStateDir + domainName + "/virtiofs.json"
+}
+
+// saveVirtiofsShares saves the directory volumes of the domain for start
+// to share them once qemu runs
+func saveVirtiofsShares(domainName string, diskStatusList []types.DiskStatus) error {
+	shares := virtiofsShares(kvmStateDir+domainName, diskStatusList)
+	if len(shares) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(shares)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(getVirtiofsSharesFile(domainName), data, 0644)
+}
+
+// addVirtiofsDevices starts virtiofsd for the directory volumes of the
+// domain then adds their vhost-user-fs devices while qemu is still stopped
+// i.e., before the guest starts looking for devices
+func (ctx kvmContext) addVirtiofsDevices(domainName string, qmpFile string) error {
+	data, err := os.ReadFile(getVirtiofsSharesFile(domainName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var shares []virtiofsShare
+	if err := json.Unmarshal(data, &shares); err != nil {
+		return err
+	}
+	if err := startVirtiofsd(ctx.ctrdClient, domainName, ctx.virtiofsdExec, shares); err != nil {
+		return err
+	}
+	for _, share := range shares {
+		if err := execChardevAddSocket(qmpFile, "char"+share.ID, share.Socket); err != nil {
+			return fmt.Errorf("chardev for %s: %w", share.Dir, err)
+		}
+		if err := execDeviceAdd(qmpFile, qmpVhostUserFs{
+			Driver:  "vhost-user-fs-pci",
+			ID:      share.ID,
+			Chardev: "char" + share.ID,
+			Tag:     share.Tag,
+			Bus:     "pci." + share.ID,
+		}); err != nil {
+			return fmt.Errorf("device for %s: %w", share.Dir, err)
+		}
+	}
+	return nil
+}
+
+// Checkpoint pauses the domain and saves its device and memory state into
+// stateFile, leaving it paused. The domain is resumed if the state cannot
+// be saved, for it to be shut down instead.
+func (ctx kvmContext) Checkpoint(domainName string, stateFile string) error {
+	qmpFile := getQmpExecutorSocket(domainName)
+	if err := execStop(qmpFile); err != nil {
+		return logError("Checkpoint(%s): failed to pause: %v", domainName, err)
+	}
+	if err := saveVMState(qmpFile, stateFile); err != nil {
+		if contErr := execContinue(qmpFile); contErr != nil {
+			logrus.Errorf("Checkpoint(%s): failed to resume: %v",
+				domainName, contErr)
+		}
+		return logError("Checkpoint(%s): %v", domainName, err)
+	}
+	return nil
+}
+
+// saveVMState migrates the paused domain into stateFile
+func saveVMState(qmpFile string, stateFile string) error {
+	if err := os.MkdirAll(filepath.Dir(stateFile), 0700); err != nil {
+		return err
+	}
+	// Do not leave a truncated state behind on failure
+	tmpFile := stateFile + ".tmp"
+	logrus.Infof("saveVMState: saving state into %s", stateFile)
+	if err := execMigrate(qmpFile, "exec:cat > "+shellQuote(tmpFile)); err != nil {
+		return err
+	}
+	if err := waitForMigration(qmpFile, kvmMigrationTimeout); err != nil {
+		os.Remove(tmpFile)
+		return err
+	}
+	if err := os.Rename(tmpFile, stateFile); err != nil {
+		os.Remove(tmpFile)
+		return err
+	}
+	return nil
+}
+
+// DumpGuestMemory writes the memory of the domain, e.g. after its kernel
+// panicked, into dumpFile in the compressed kdump format read by crash
+func (ctx kvmContext) DumpGuestMemory(domainName string, dumpFile string) error {
+	qmpFile := getQmpExecutorSocket(domainName)
+	if err := os.MkdirAll(filepath.Dir(dumpFile), 0700); err != nil {
+		return logError("DumpGuestMemory(%s): %v", domainName, err)
+	}
+	// Do not leave a truncated dump behind on failure
+	tmpFile := dumpFile + ".tmp"
+	logrus.Infof("DumpGuestMemory(%s): dumping memory into %s", domainName, dumpFile)
+	if err := execDumpGuestMemory(qmpFile, tmpFile); err != nil {
+		os.Remove(tmpFile)
+		return logError("DumpGuestMemory(%s): %v", domainName, err)
+	}
+	if err := waitForDump(qmpFile, kvmDumpTimeout); err != nil {
+		os.Remove(tmpFile)
+		return logError("DumpGuestMemory(%s): %v", domainName, err)
+	}
+	if err := os.Rename(tmpFile, dumpFile); err != nil {
+		os.Remove(tmpFile)
+		return logError("DumpGuestMemory(%s): %v", domainName, err)
+	}
+	return nil
+}
+
 func (ctx kvmContext) Stop(domainName string, _ bool) error {
 	if err := execShutdown(getQmpExecutorSocket(domainName)); err != nil {
 		return logError("Stop: failed to execute shutdown command %v", err)
@@ -944,6 +1179,8 @@ func (ctx kvmContext) Delete(domainName string) (result error) {
 	if err := os.RemoveAll(kvmStateDir + domainName); err != nil {
 		return logError("failed to clean up domain state directory %s (%v)", domainName, err)
 	}
+	forgetDomainEvents(domainName)
+	forgetQgaSocket(domainName)
 
 	return nil
 }
@@ -971,6 +1208,9 @@ func (ctx kvmContext) Info(domainName string) (int, types.SwState, error) {
 		"watchdog":       types.PAUSING,
 		"colo":           types.PAUSED,
 		"preconfig":      types.PAUSED,
+		"io-error":       types.PAUSED,
+		"guest-panicked": types.BROKEN,
+		"internal-error": types.BROKEN,
 	}
 	res, err := getQemuStatus(getQmpExecutorSocket(domainName))
 	if err != nil {
@@ -979,11 +1219,131 @@ func (ctx kvmContext) Info(domainName string) (int, types.SwState, error) {
 
 	if effectiveDomainState, matched := stateMap[res]; !matched {
 		return effectiveDomainID, types.BROKEN, logError("domain %s reported to be in unexpected state %s", domainName, res)
+	} else if res == "io-error" {
+		recordBlockIOStatus(domainName, getQmpExecutorSocket(domainName))
+		return effectiveDomainID, effectiveDomainState, nil
+	} else if effectiveDomainState == types.BROKEN {
+		if event, ok := lastDomainEvent(domainName); ok && event.Error {
+			return effectiveDomainID, types.BROKEN, logError("domain %s %s", domainName, event.Detail)
+		}
+		return effectiveDomainID, types.BROKEN, logError("domain %s reported to be in state %s", domainName, res)
 	} else {
 		return effectiveDomainID, effectiveDomainState, nil
 	}
 }
 
+// SetMemoryTarget inflates or deflates the balloon of the domain for the
+// guest to use memory kbytes
+func (ctx kvmContext) SetMemoryTarget(domainName string, memory int) error {
+	socket := getQmpExecutorSocket(domainName)
+	target := int64(memory) << 10
+	balloon, err := queryBalloon(socket)
+	if err != nil {
+		return logError("SetMemoryTarget(%s): no balloon: %v", domainName, err)
+	}
+	if balloon.Actual == target {
+		return nil
+	}
+	logrus.Infof("SetMemoryTarget(%s): from %d to %d bytes", domainName, balloon.Actual, target)
+	if err := execBalloon(socket, target); err != nil {
+		return logError("SetMemoryTarget(%s): %v", domainName, err)
+	}
+	return nil
+}
+
+// MemoryActual returns the memory in kbytes the balloon currently leaves to
+// the domain
+func (ctx kvmContext) MemoryActual(domainName string) (int, error) {
+	balloon, err := queryBalloon(getQmpExecutorSocket(domainName))
+	if err != nil {
+		return 0, logError("MemoryActual(%s): no balloon: %v", domainName, err)
+	}
+	return int(balloon.Actual >> 10), nil
+}
+
+// HotplugCPUs plugs vCPUs into the running domain until it has vcpus of
+// them, up to the maxcpus it was started with
+func (ctx kvmContext) HotplugCPUs(domainName string, vcpus int) error {
+	socket := getQmpExecutorSocket(domainName)
+	cpus, err := queryHotpluggableCPUs(socket)
+	if err != nil {
+		return logError("HotplugCPUs(%s): %v", domainName, err)
+	}
+	devices, err := cpuDevicesToPlug(cpus, vcpus)
+	if err != nil {
+		return logError("HotplugCPUs(%s): %v", domainName, err)
+	}
+	for _, device := range devices {
+		logrus.Infof("HotplugCPUs(%s): adding %v", domainName, device)
+		if err := execDeviceAdd(socket, device); err != nil {
+			return logError("HotplugCPUs(%s): %s: %v", domainName, device["id"], err)
+		}
+	}
+	return nil
+}
+
+// HotplugMemory plugs a DIMM into the running domain for it to have memory
+// kbytes, up to the maxmem it was started with
+func (ctx kvmContext) HotplugMemory(domainName string, memory int) error {
+	socket := getQmpExecutorSocket(domainName)
+	summary, err := queryMemorySizeSummary(socket)
+	if err != nil {
+		return logError("HotplugMemory(%s): %v", domainName, err)
+	}
+	// Memory is given to qemu in Mbytes
+	size := (int64(memory)<<10 + 1<<20 - 1) &^ (1<<20 - 1)
+	size -= summary.BaseMemory + summary.PluggedMemory
+	if size <= 0 {
+		return nil
+	}
+	devices, err := queryMemoryDevices(socket)
+	if err != nil {
+		return logError("HotplugMemory(%s): %v", domainName, err)
+	}
+	dimm := qmpDimm{Driver: "pc-dimm", ID: fmt.Sprintf("dimm%d", len(devices))}
+	dimm.Memdev = "mem-" + dimm.ID
+	backend := qmpMemoryBackend{QomType: "memory-backend-ram", ID: dimm.Memdev, Size: size}
+	// The memory of the domain is shared with virtiofsd if any
+	if _, err := os.Stat(getVirtiofsSharesFile(domainName)); err == nil {
+		backend.QomType = "memory-backend-memfd"
+		backend.Share = true
+	}
+	logrus.Infof("HotplugMemory(%s): adding %d bytes as %s", domainName, size, dimm.ID)
+	if err := execObjectAdd(socket, backend); err != nil {
+		return logError("HotplugMemory(%s): %s: %v", domainName, backend.ID, err)
+	}
+	if err := execDeviceAdd(socket, dimm); err != nil {
+		if err := execObjectDel(socket, backend.ID); err != nil {
+			logrus.Errorf("HotplugMemory(%s): failed to remove %s: %v",
+				domainName, backend.ID, err)
+		}
+		return logError("HotplugMemory(%s): %s: %v", domainName, dimm.ID, err)
+	}
+	return nil
+}
+
+// GuestInfo returns what the qemu-guest-agent reports about the guest
+func (ctx kvmContext) GuestInfo(domainName string) (types.GuestInfo, error) {
+	return queryGuestInfo(getQgaSocket(domainName))
+}
+
+// FreezeFilesystems asks the qemu-guest-agent to freeze the filesystems
+// of the guest
+func (ctx kvmContext) FreezeFilesystems(domainName string) (int, error) {
+	return freezeGuestFilesystems(getQgaSocket(domainName))
+}
+
+// ThawFilesystems asks the qemu-guest-agent to thaw the filesystems of
+// the guest
+func (ctx kvmContext) ThawFilesystems(domainName string) (int, error) {
+	return thawGuestFilesystems(getQgaSocket(domainName))
+}
+
+// LastEvent returns the last QMP event explaining the state of the domain
+func (ctx kvmContext) LastEvent(domainName string) (types.DomainEvent, bool) {
+	return lastDomainEvent(domainName)
+}
+
 func (ctx kvmContext) Cleanup(domainName string) error {
 	if err := ctx.ctrdContext.Cleanup(domainName); err != nil {
 		return fmt.Errorf("couldn't cleanup task %s: %v", domainName, err)
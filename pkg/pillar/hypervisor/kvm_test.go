@@ -4,6 +4,7 @@ import (
 	"net"
 	"os"
 	"os/exec"
+	"strings"
 	"testing"
 
 	zconfig "github.com/lf-edge/eve-api/go/config"
@@ -872,6 +873,8 @@ func TestCreateDomConfig(t *testing.T) {
 			VCpus:      2,
 			VncDisplay: 5,
 			VncPasswd:  "rosebud",
+			// for the memory dumps of crashed apps
+			PanicDevice: true,
 		},
 		GPUConfig: "legacy",
 		VifList: []types.VifConfig{
@@ -985,6 +988,9 @@ func TestCreateDomConfig(t *testing.T) {
   driver = "intel-iommu"
   caching-mode = "on"
 
+[device]
+  driver = "pvpanic"
+
 [realtime]
   mlock = "off"
 
@@ -1280,6 +1286,9 @@ func TestCreateDomConfig(t *testing.T) {
   driver = "intel-iommu"
   caching-mode = "on"
 
+[device]
+  driver = "pvpanic"
+
 [realtime]
   mlock = "off"
 
@@ -1595,6 +1604,9 @@ func TestCreateDomConfig(t *testing.T) {
   driver = "intel-iommu"
   caching-mode = "on"
 
+[device]
+  driver = "pvpanic"
+
 [realtime]
   mlock = "off"
 
@@ -1880,6 +1892,9 @@ func TestCreateDomConfig(t *testing.T) {
   append = "init=/bin/sh"
 
 
+[device]
+  driver = "pvpanic-pci"
+
 [realtime]
   mlock = "off"
 
@@ -2273,3 +2288,69 @@ func TestCreateDom(t *testing.T) {
 		t.Errorf("can't read stat dir for test domain or state dir is not empty after all domains are gone %v", err)
 	}
 }
+
+func TestCreateDomConfigHotplug(t *testing.T) {
+	initTest(t)
+	config := types.DomainConfig{
+		VmConfig: types.VmConfig{
+			Memory:  1024 * 1024,
+			MaxMem:  4 * 1024 * 1024,
+			VCpus:   2,
+			MaxCpus: 4,
+		},
+	}
+	conf, err := os.CreateTemp("/tmp", "config")
+	if err != nil {
+		t.Fatalf("Can't create config file for a domain %v", err)
+	}
+	defer os.Remove(conf.Name())
+	render := func(config types.DomainConfig) string {
+		conf.Seek(0, 0)
+		os.Truncate(conf.Name(), 0)
+		if err := kvmIntel.CreateDomConfig("test", config, types.DomainStatus{}, nil,
+			&types.AssignableAdapters{}, conf); err != nil {
+			t.Fatalf("CreateDomConfig failed %v", err)
+		}
+		result, err := os.ReadFile(conf.Name())
+		if err != nil {
+			t.Fatalf("reading conf file failed %v", err)
+		}
+		return string(result)
+	}
+
+	// maxmem and maxcpus change nothing without Hotplug
+	result := render(config)
+	for _, s := range []string{"maxmem", "maxcpus", "slots"} {
+		if strings.Contains(result, s) {
+			t.Errorf("%s without Hotplug:\n%s", s, result)
+		}
+	}
+	if !strings.Contains(result, `cores = "2"`) {
+		t.Errorf("not 2 cores without Hotplug:\n%s", result)
+	}
+
+	config.Hotplug = true
+	result = render(config)
+	for _, s := range []string{`maxmem = "4096M"`, `maxcpus = "4"`, `cores = "4"`} {
+		if !strings.Contains(result, s) {
+			t.Errorf("no %s with Hotplug:\n%s", s, result)
+		}
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	for _, s := range []string{
+		"/persist/snapshots/8b2a7c51-1e3f-4a3c-9a67-4d2f0d4c3b1a/vmstate",
+		"with space",
+		"it's",
+		"$(reboot) `reboot` \"; reboot",
+	} {
+		out, err := exec.Command("sh", "-c", "printf %s "+shellQuote(s)).Output()
+		if err != nil {
+			t.Fatalf("sh failed for %s: %v", s, err)
+		}
+		if string(out) != s {
+			t.Errorf("got %s instead of %s", out, s)
+		}
+	}
+}
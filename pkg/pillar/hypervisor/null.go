@@ -122,6 +122,16 @@ func (ctx nullContext) Cleanup(_ string) error {
 	return nil
 }
 
+// Checkpoint is not supported for null hypervisor
+func (ctx nullContext) Checkpoint(domainName string, _ string) error {
+	return fmt.Errorf("checkpoint of null domain %s is not supported", domainName)
+}
+
+// Restore is not supported for null hypervisor
+func (ctx nullContext) Restore(domainName string, _ string) error {
+	return fmt.Errorf("restore of null domain %s is not supported", domainName)
+}
+
 func (ctx nullContext) Info(domainName string) (int, types.SwState, error) {
 	if dom, found := ctx.doms[domainName]; found {
 		logrus.Infof("Null Domain %s is %v and has the following config %s\n", domainName, dom.state, dom.config)
@@ -0,0 +1,231 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package hypervisor
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/lf-edge/eve/pkg/pillar/types"
+	"github.com/sirupsen/logrus"
+)
+
+// this file implements a client for a subset of
+//     https://qemu.readthedocs.io/en/latest/interop/qemu-ga-ref.html
+
+const (
+	qgaTimeout = 10 * time.Second
+	// Freezing can take a while when there is a lot to flush
+	qgaFreezeTimeout = time.Minute
+)
+
+// qemu accepts a single client on the guest agent socket, hence the
+// callers in this agent take turns on each socket. The clients in other
+// agents wait in the backlog of the socket until the client is done.
+var qgaLocks = struct {
+	sync.Mutex
+	sockets map[string]*sync.Mutex
+}{sockets: make(map[string]*sync.Mutex)}
+
+// lockQgaSocket locks socket for a client and returns the locked lock
+func lockQgaSocket(socket string) *sync.Mutex {
+	qgaLocks.Lock()
+	lock, ok := qgaLocks.sockets[socket]
+	if !ok {
+		lock = &sync.Mutex{}
+		qgaLocks.sockets[socket] = lock
+	}
+	qgaLocks.Unlock()
+	lock.Lock()
+	return lock
+}
+
+// forgetQgaSocket is called when a domain is deleted
+func forgetQgaSocket(domainName string) {
+	qgaLocks.Lock()
+	defer qgaLocks.Unlock()
+	delete(qgaLocks.sockets, getQgaSocket(domainName))
+}
+
+func getQgaSocket(domainName string) string {
+	return filepath.Join(kvmStateDir, domainName, "qga")
+}
+
+// qgaResponse is what the guest agent returns for a command
+type qgaResponse struct {
+	Return json.RawMessage `json:"return"`
+	Error  *struct {
+		Class string `json:"class"`
+		Desc  string `json:"desc"`
+	} `json:"error"`
+}
+
+// execQga runs command with arguments (nil for none) in the guest agent
+// and unmarshals what it returns into result unless nil
+func execQga(socket string, timeout time.Duration, command string, arguments interface{}, result interface{}) error {
+	defer lockQgaSocket(socket).Unlock()
+
+	conn, err := net.DialTimeout("unix", socket, timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+	reader := bufio.NewReader(conn)
+	encoder := json.NewEncoder(conn)
+
+	// Skip what a previous client left unread
+	id := rand.Int63()
+	if err := encoder.Encode(qmpCommand{Execute: "guest-sync",
+		Arguments: struct {
+			ID int64 `json:"id"`
+		}{ID: id}}); err != nil {
+		return err
+	}
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			return fmt.Errorf("execQga(%s): guest-sync: %w", command, err)
+		}
+		var synced struct {
+			Return int64 `json:"return"`
+		}
+		if json.Unmarshal(line, &synced) == nil && synced.Return == id {
+			break
+		}
+	}
+
+	if err := encoder.Encode(qmpCommand{Execute: command, Arguments: arguments}); err != nil {
+		return err
+	}
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		return fmt.Errorf("execQga(%s): %w", command, err)
+	}
+	var response qgaResponse
+	if err := json.Unmarshal(line, &response); err != nil {
+		return fmt.Errorf("execQga(%s): %w", command, err)
+	}
+	if response.Error != nil {
+		return fmt.Errorf("execQga(%s): %s: %s", command, response.Error.Class,
+			response.Error.Desc)
+	}
+	if result == nil {
+		return nil
+	}
+	if err := json.Unmarshal(response.Return, result); err != nil {
+		return fmt.Errorf("execQga(%s): %w", command, err)
+	}
+	return nil
+}
+
+// qgaOSInfo is returned by guest-get-osinfo
+type qgaOSInfo struct {
+	ID            string `json:"id"`
+	Name          string `json:"name"`
+	PrettyName    string `json:"pretty-name"`
+	Version       string `json:"version"`
+	VersionID     string `json:"version-id"`
+	KernelRelease string `json:"kernel-release"`
+	KernelVersion string `json:"kernel-version"`
+	Machine       string `json:"machine"`
+}
+
+// qgaInterface is an element returned by guest-network-get-interfaces
+type qgaInterface struct {
+	Name            string `json:"name"`
+	HardwareAddress string `json:"hardware-address"`
+	IPAddresses     []struct {
+		IPAddressType string `json:"ip-address-type"`
+		IPAddress     string `json:"ip-address"`
+		Prefix        int    `json:"prefix"`
+	} `json:"ip-addresses"`
+}
+
+// qgaFilesystem is an element returned by guest-get-fsinfo
+type qgaFilesystem struct {
+	Name       string `json:"name"`
+	Mountpoint string `json:"mountpoint"`
+	Type       string `json:"type"`
+	UsedBytes  uint64 `json:"used-bytes"`
+	TotalBytes uint64 `json:"total-bytes"`
+}
+
+// queryGuestInfo asks the guest agent on socket about the guest
+func queryGuestInfo(socket string) (types.GuestInfo, error) {
+	info := types.GuestInfo{}
+	if err := execQga(socket, qgaTimeout, "guest-ping", nil, nil); err != nil {
+		return info, err
+	}
+	info.Alive = true
+	info.LastSeen = time.Now()
+
+	// The agent may not implement or allow all the commands
+	var osInfo qgaOSInfo
+	if err := execQga(socket, qgaTimeout, "guest-get-osinfo", nil, &osInfo); err != nil {
+		logrus.Debugf("queryGuestInfo(%s): %v", socket, err)
+	} else {
+		info.OSName = osInfo.PrettyName
+		if info.OSName == "" {
+			info.OSName = osInfo.Name
+		}
+		info.OSVersion = osInfo.Version
+		info.KernelRelease = osInfo.KernelRelease
+	}
+	var interfaces []qgaInterface
+	if err := execQga(socket, qgaTimeout, "guest-network-get-interfaces", nil, &interfaces); err != nil {
+		logrus.Debugf("queryGuestInfo(%s): %v", socket, err)
+	}
+	for _, intf := range interfaces {
+		if intf.Name == "lo" {
+			continue
+		}
+		guestIntf := types.GuestInterface{
+			Name:    intf.Name,
+			MacAddr: intf.HardwareAddress,
+		}
+		for _, addr := range intf.IPAddresses {
+			guestIntf.IPAddrs = append(guestIntf.IPAddrs,
+				fmt.Sprintf("%s/%d", addr.IPAddress, addr.Prefix))
+		}
+		info.Interfaces = append(info.Interfaces, guestIntf)
+	}
+	var filesystems []qgaFilesystem
+	if err := execQga(socket, qgaTimeout, "guest-get-fsinfo", nil, &filesystems); err != nil {
+		logrus.Debugf("queryGuestInfo(%s): %v", socket, err)
+	}
+	for _, fs := range filesystems {
+		info.Filesystems = append(info.Filesystems, types.GuestFilesystem{
+			Mountpoint: fs.Mountpoint,
+			Type:       fs.Type,
+			TotalBytes: fs.TotalBytes,
+			UsedBytes:  fs.UsedBytes,
+		})
+	}
+	return info, nil
+}
+
+// freezeGuestFilesystems freezes the filesystems of the guest; returns
+// the number of filesystems frozen
+func freezeGuestFilesystems(socket string) (int, error) {
+	var frozen int
+	err := execQga(socket, qgaFreezeTimeout, "guest-fsfreeze-freeze", nil, &frozen)
+	return frozen, err
+}
+
+// thawGuestFilesystems thaws the filesystems of the guest; returns the
+// number of filesystems thawed
+func thawGuestFilesystems(socket string) (int, error) {
+	var thawed int
+	err := execQga(socket, qgaTimeout, "guest-fsfreeze-thaw", nil, &thawed)
+	return thawed, err
+}
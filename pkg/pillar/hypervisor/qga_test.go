@@ -0,0 +1,137 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package hypervisor
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lf-edge/eve/pkg/pillar/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeQga answers the commands on socket with the canned returns, after
+// leaving a stale response around as a previous client could have
+func fakeQga(t *testing.T, socket string, returns map[string]string) {
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				fmt.Fprintln(conn, `{"return": {}}`)
+				decoder := json.NewDecoder(bufio.NewReader(conn))
+				for {
+					var cmd struct {
+						Execute   string          `json:"execute"`
+						Arguments json.RawMessage `json:"arguments"`
+					}
+					if err := decoder.Decode(&cmd); err != nil {
+						return
+					}
+					if cmd.Execute == "guest-sync" {
+						var args struct {
+							ID int64 `json:"id"`
+						}
+						json.Unmarshal(cmd.Arguments, &args)
+						fmt.Fprintf(conn, "{\"return\": %d}\n", args.ID)
+						continue
+					}
+					ret, ok := returns[cmd.Execute]
+					if !ok {
+						fmt.Fprintln(conn, `{"error": {"class": "CommandNotFound", "desc": "not supported"}}`)
+						continue
+					}
+					// The agent sends a response per line
+					var compact bytes.Buffer
+					if err := json.Compact(&compact, []byte(ret)); err != nil {
+						t.Error(err)
+						return
+					}
+					fmt.Fprintf(conn, "{\"return\": %s}\n", compact.String())
+				}
+			}(conn)
+		}
+	}()
+}
+
+func TestQueryGuestInfo(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "qga")
+	fakeQga(t, socket, map[string]string{
+		"guest-ping": `{}`,
+		"guest-get-osinfo": `{"name": "Ubuntu", "pretty-name": "Ubuntu 22.04.3 LTS",
+			"version": "22.04.3 LTS (Jammy Jellyfish)", "kernel-release": "5.15.0-88-generic"}`,
+		"guest-network-get-interfaces": `[
+			{"name": "lo", "hardware-address": "00:00:00:00:00:00",
+			 "ip-addresses": [{"ip-address-type": "ipv4", "ip-address": "127.0.0.1", "prefix": 8}]},
+			{"name": "eth0", "hardware-address": "02:16:3e:5e:6c:00",
+			 "ip-addresses": [{"ip-address-type": "ipv4", "ip-address": "10.1.0.2", "prefix": 24},
+			                  {"ip-address-type": "ipv6", "ip-address": "fe80::16:3eff:fe5e:6c00", "prefix": 64}]}]`,
+		// guest-get-fsinfo is not allowed
+	})
+
+	info, err := queryGuestInfo(socket)
+	assert.NoError(t, err)
+	assert.True(t, info.Alive)
+	assert.False(t, info.LastSeen.IsZero())
+	assert.Equal(t, "Ubuntu 22.04.3 LTS", info.OSName)
+	assert.Equal(t, "22.04.3 LTS (Jammy Jellyfish)", info.OSVersion)
+	assert.Equal(t, "5.15.0-88-generic", info.KernelRelease)
+	assert.Equal(t, []types.GuestInterface{{
+		Name:    "eth0",
+		MacAddr: "02:16:3e:5e:6c:00",
+		IPAddrs: []string{"10.1.0.2/24", "fe80::16:3eff:fe5e:6c00/64"},
+	}}, info.Interfaces)
+	assert.Empty(t, info.Filesystems)
+}
+
+func TestFreezeGuestFilesystems(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "qga")
+	fakeQga(t, socket, map[string]string{
+		"guest-fsfreeze-freeze": `2`,
+		"guest-fsfreeze-thaw":   `2`,
+	})
+
+	frozen, err := freezeGuestFilesystems(socket)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, frozen)
+	thawed, err := thawGuestFilesystems(socket)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, thawed)
+
+	_, err = queryGuestInfo(socket)
+	assert.ErrorContains(t, err, "CommandNotFound")
+}
+
+func TestLockQgaSocket(t *testing.T) {
+	lock := lockQgaSocket("/run/hypervisor/kvm/one/qga")
+	// Another socket is not held up by the client of the first one
+	done := make(chan struct{})
+	go func() {
+		lockQgaSocket("/run/hypervisor/kvm/two/qga").Unlock()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("lockQgaSocket blocked on another socket")
+	}
+	lock.Unlock()
+	lockQgaSocket("/run/hypervisor/kvm/one/qga").Unlock()
+	forgetQgaSocket("one")
+	forgetQgaSocket("two")
+}
@@ -5,8 +5,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/digitalocean/go-qemu/qmp"
+	"github.com/lf-edge/eve/pkg/pillar/types"
 	"github.com/sirupsen/logrus"
 	"os"
+	"sort"
+	"sync"
 	"time"
 )
 
@@ -41,50 +44,585 @@ func execRawCmd(socket, cmd string) ([]byte, error) {
 	return monitor.Run([]byte(cmd))
 }
 
+// qmpCommand is a QMP command with its arguments if any
+type qmpCommand struct {
+	Execute   string      `json:"execute"`
+	Arguments interface{} `json:"arguments,omitempty"`
+}
+
+// execCmd runs command with arguments (nil for none) and unmarshals
+// what it returns into result unless nil
+func execCmd(socket, command string, arguments interface{}, result interface{}) error {
+	cmd, err := json.Marshal(qmpCommand{Execute: command, Arguments: arguments})
+	if err != nil {
+		return err
+	}
+	raw, err := execRawCmd(socket, string(cmd))
+	if err != nil {
+		return err
+	}
+	if result == nil {
+		return nil
+	}
+	response := struct {
+		Return interface{} `json:"return"`
+	}{Return: result}
+	if err := json.Unmarshal(raw, &response); err != nil {
+		return fmt.Errorf("execCmd(%s): %w", command, err)
+	}
+	return nil
+}
+
 func execContinue(socket string) error {
-	_, err := execRawCmd(socket, `{ "execute": "cont" }`)
-	return err
+	return execCmd(socket, "cont", nil, nil)
 }
 
 func execStop(socket string) error {
-	_, err := execRawCmd(socket, `{ "execute": "stop" }`)
-	return err
+	return execCmd(socket, "stop", nil, nil)
 }
 
 func execShutdown(socket string) error {
-	_, err := execRawCmd(socket, `{ "execute": "system_powerdown" }`)
-	return err
+	return execCmd(socket, "system_powerdown", nil, nil)
 }
 
 func execQuit(socket string) error {
-	_, err := execRawCmd(socket, `{ "execute": "quit" }`)
-	return err
+	return execCmd(socket, "quit", nil, nil)
 }
 
 func execVNCPassword(socket string, password string) error {
-	vncSetPwd := fmt.Sprintf(`{ "execute": "change-vnc-password", "arguments": { "password": "%s" } }`, password)
-	_, err := execRawCmd(socket, vncSetPwd)
-	return err
+	return execCmd(socket, "change-vnc-password",
+		struct {
+			Password string `json:"password"`
+		}{Password: password}, nil)
+}
+
+// execChardevAddSocket adds chardev id connected to the unix socket path
+func execChardevAddSocket(socket, id, path string) error {
+	type qmpSocketAddress struct {
+		Type string `json:"type"`
+		Data struct {
+			Path string `json:"path"`
+		} `json:"data"`
+	}
+	type qmpChardevSocket struct {
+		Addr   qmpSocketAddress `json:"addr"`
+		Server bool             `json:"server"`
+	}
+	chardev := struct {
+		ID      string `json:"id"`
+		Backend struct {
+			Type string           `json:"type"`
+			Data qmpChardevSocket `json:"data"`
+		} `json:"backend"`
+	}{ID: id}
+	chardev.Backend.Type = "socket"
+	chardev.Backend.Data.Addr.Type = "unix"
+	chardev.Backend.Data.Addr.Data.Path = path
+	return execCmd(socket, "chardev-add", chardev, nil)
+}
+
+// qmpVhostUserFs are the arguments of device_add for a virtio-fs device
+type qmpVhostUserFs struct {
+	Driver  string `json:"driver"`
+	ID      string `json:"id"`
+	Chardev string `json:"chardev"`
+	Tag     string `json:"tag"`
+	Bus     string `json:"bus"`
+}
+
+// execDeviceAdd adds the device described by arguments
+func execDeviceAdd(socket string, arguments interface{}) error {
+	return execCmd(socket, "device_add", arguments, nil)
+}
+
+// QmpStatus is returned by query-status
+type QmpStatus struct {
+	Running    bool   `json:"running"`
+	Singlestep bool   `json:"singlestep"`
+	Status     string `json:"status"`
+}
+
+// QmpBlockInfo is an element returned by query-block
+type QmpBlockInfo struct {
+	Device    string `json:"device"`
+	Qdev      string `json:"qdev"`
+	Removable bool   `json:"removable"`
+	Locked    bool   `json:"locked"`
+	// IoStatus is ok, failed or nospace; only set if werror/rerror
+	// is set to stop
+	IoStatus string `json:"io-status"`
+	Inserted *struct {
+		File     string `json:"file"`
+		NodeName string `json:"node-name"`
+		Ro       bool   `json:"ro"`
+		Drv      string `json:"drv"`
+	} `json:"inserted"`
+}
+
+// QmpBalloonInfo is returned by query-balloon
+type QmpBalloonInfo struct {
+	// Actual is the memory size of the guest in bytes
+	Actual int64 `json:"actual"`
+}
+
+func queryStatus(socket string) (QmpStatus, error) {
+	var status QmpStatus
+	err := execCmd(socket, "query-status", nil, &status)
+	return status, err
+}
+
+func queryBlock(socket string) ([]QmpBlockInfo, error) {
+	var blocks []QmpBlockInfo
+	err := execCmd(socket, "query-block", nil, &blocks)
+	return blocks, err
+}
+
+func queryBalloon(socket string) (QmpBalloonInfo, error) {
+	var balloon QmpBalloonInfo
+	err := execCmd(socket, "query-balloon", nil, &balloon)
+	return balloon, err
+}
+
+// execBalloon asks the guest to use size bytes of memory
+func execBalloon(socket string, size int64) error {
+	return execCmd(socket, "balloon",
+		struct {
+			Value int64 `json:"value"`
+		}{Value: size}, nil)
+}
+
+// QmpHotpluggableCPU is an element returned by query-hotpluggable-cpus
+type QmpHotpluggableCPU struct {
+	Type       string `json:"type"`
+	VcpusCount int    `json:"vcpus-count"`
+	// Props are the socket-id, core-id etc. to give device_add to plug it
+	Props map[string]interface{} `json:"props"`
+	// QomPath is only set if the CPU is plugged
+	QomPath string `json:"qom-path"`
+}
+
+// QmpMemorySizeSummary is returned by query-memory-size-summary
+type QmpMemorySizeSummary struct {
+	BaseMemory    int64 `json:"base-memory"`
+	PluggedMemory int64 `json:"plugged-memory"`
+}
+
+// QmpMemoryDevice is an element returned by query-memory-devices
+type QmpMemoryDevice struct {
+	Type string `json:"type"`
+}
+
+// qmpMemoryBackend are the arguments of object-add for the memory of a DIMM
+type qmpMemoryBackend struct {
+	QomType string `json:"qom-type"`
+	ID      string `json:"id"`
+	Size    int64  `json:"size"`
+	Share   bool   `json:"share,omitempty"`
+}
+
+// qmpDimm are the arguments of device_add for a DIMM
+type qmpDimm struct {
+	Driver string `json:"driver"`
+	ID     string `json:"id"`
+	Memdev string `json:"memdev"`
+}
+
+func queryHotpluggableCPUs(socket string) ([]QmpHotpluggableCPU, error) {
+	var cpus []QmpHotpluggableCPU
+	err := execCmd(socket, "query-hotpluggable-cpus", nil, &cpus)
+	return cpus, err
+}
+
+func queryMemorySizeSummary(socket string) (QmpMemorySizeSummary, error) {
+	var summary QmpMemorySizeSummary
+	err := execCmd(socket, "query-memory-size-summary", nil, &summary)
+	return summary, err
+}
+
+func queryMemoryDevices(socket string) ([]QmpMemoryDevice, error) {
+	var devices []QmpMemoryDevice
+	err := execCmd(socket, "query-memory-devices", nil, &devices)
+	return devices, err
+}
+
+// execObjectAdd adds the QOM object described by arguments
+func execObjectAdd(socket string, arguments interface{}) error {
+	return execCmd(socket, "object-add", arguments, nil)
+}
+
+func execObjectDel(socket, id string) error {
+	return execCmd(socket, "object-del",
+		struct {
+			ID string `json:"id"`
+		}{ID: id}, nil)
+}
+
+// cpuDevicesToPlug returns the arguments of device_add for the CPUs to plug
+// for the domain to have vcpus, lowest socket, core and thread first
+func cpuDevicesToPlug(cpus []QmpHotpluggableCPU, vcpus int) ([]map[string]interface{}, error) {
+	plugged := 0
+	var unplugged []QmpHotpluggableCPU
+	for _, cpu := range cpus {
+		if cpu.QomPath != "" {
+			plugged += cpu.VcpusCount
+		} else {
+			unplugged = append(unplugged, cpu)
+		}
+	}
+	// query-hotpluggable-cpus lists the highest CPUs first
+	propsKey := func(cpu QmpHotpluggableCPU) []float64 {
+		var key []float64
+		for _, prop := range []string{"node-id", "socket-id", "die-id", "core-id", "thread-id"} {
+			id, _ := cpu.Props[prop].(float64)
+			key = append(key, id)
+		}
+		return key
+	}
+	sort.SliceStable(unplugged, func(i, j int) bool {
+		ki, kj := propsKey(unplugged[i]), propsKey(unplugged[j])
+		for n := range ki {
+			if ki[n] != kj[n] {
+				return ki[n] < kj[n]
+			}
+		}
+		return false
+	})
+	var devices []map[string]interface{}
+	for _, cpu := range unplugged {
+		if plugged >= vcpus {
+			break
+		}
+		device := map[string]interface{}{
+			"driver": cpu.Type,
+			"id":     fmt.Sprintf("vcpu%d", plugged),
+		}
+		for prop, value := range cpu.Props {
+			device[prop] = value
+		}
+		devices = append(devices, device)
+		plugged += cpu.VcpusCount
+	}
+	if plugged < vcpus {
+		return nil, fmt.Errorf("only %d vCPUs out of %d can be plugged", plugged, vcpus)
+	}
+	return devices, nil
+}
+
+// QmpMigrationInfo is returned by query-migrate
+type QmpMigrationInfo struct {
+	// Status is e.g. active, completed or failed
+	Status    string `json:"status"`
+	ErrorDesc string `json:"error-desc"`
+}
+
+func queryMigrate(socket string) (QmpMigrationInfo, error) {
+	var migration QmpMigrationInfo
+	err := execCmd(socket, "query-migrate", nil, &migration)
+	return migration, err
+}
+
+// execMigrate starts saving the state of the domain to uri
+func execMigrate(socket, uri string) error {
+	return execCmd(socket, "migrate",
+		struct {
+			URI string `json:"uri"`
+		}{URI: uri}, nil)
+}
+
+// execMigrateIncoming starts loading the state of the domain, started with
+// -incoming defer, from uri
+func execMigrateIncoming(socket, uri string) error {
+	return execCmd(socket, "migrate-incoming",
+		struct {
+			URI string `json:"uri"`
+		}{URI: uri}, nil)
+}
+
+// waitForMigration waits for the migration started with execMigrate to end
+func waitForMigration(socket string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		migration, err := queryMigrate(socket)
+		if err != nil {
+			return err
+		}
+		switch migration.Status {
+		case "completed":
+			return nil
+		case "failed", "cancelled":
+			return fmt.Errorf("migration %s: %s", migration.Status, migration.ErrorDesc)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("migration still %s after %v", migration.Status, timeout)
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// QmpDumpInfo is returned by query-dump
+type QmpDumpInfo struct {
+	// Status is none, active, completed or failed
+	Status    string `json:"status"`
+	Completed int64  `json:"completed"`
+	Total     int64  `json:"total"`
+}
+
+func queryDump(socket string) (QmpDumpInfo, error) {
+	var dump QmpDumpInfo
+	err := execCmd(socket, "query-dump", nil, &dump)
+	return dump, err
+}
+
+// execDumpGuestMemory starts dumping the memory of the domain into file,
+// compressed with zlib
+func execDumpGuestMemory(socket, file string) error {
+	return execCmd(socket, "dump-guest-memory",
+		struct {
+			Paging   bool   `json:"paging"`
+			Protocol string `json:"protocol"`
+			Detach   bool   `json:"detach"`
+			Format   string `json:"format"`
+		}{Protocol: "file:" + file, Detach: true, Format: "kdump-zlib"}, nil)
+}
+
+// waitForDump waits for the dump started with execDumpGuestMemory to end
+func waitForDump(socket string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		dump, err := queryDump(socket)
+		if err != nil {
+			return err
+		}
+		switch dump.Status {
+		case "completed":
+			return nil
+		case "failed", "none":
+			return fmt.Errorf("dump %s", dump.Status)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("dump still %s after %v, %d bytes out of %d",
+				dump.Status, timeout, dump.Completed, dump.Total)
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// waitForIncomingMigration waits for the state loaded with
+// execMigrateIncoming to be in
+func waitForIncomingMigration(socket string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		status, err := getQemuStatus(socket)
+		if err != nil {
+			// qemu exits when it fails to load the state
+			return err
+		}
+		if status != "inmigrate" {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("migration still incoming after %v", timeout)
+		}
+		time.Sleep(time.Second)
+	}
 }
 
 func getQemuStatus(socket string) (string, error) {
-	if raw, err := execRawCmd(socket, `{ "execute": "query-status" }`); err == nil {
-		var result struct {
-			ID     string `json:"id"`
-			Return struct {
-				Running    bool   `json:"running"`
-				Singlestep bool   `json:"singlestep"`
-				Status     string `json:"status"`
-			} `json:"return"`
+	status, err := queryStatus(socket)
+	return status.Status, err
+}
+
+// QMP events we turn into domain events
+const (
+	qmpEventShutdown      = "SHUTDOWN"
+	qmpEventPowerdown     = "POWERDOWN"
+	qmpEventReset         = "RESET"
+	qmpEventStop          = "STOP"
+	qmpEventResume        = "RESUME"
+	qmpEventWatchdog      = "WATCHDOG"
+	qmpEventGuestPanicked = "GUEST_PANICKED"
+	qmpEventBlockIOError  = "BLOCK_IO_ERROR"
+)
+
+// qmpBlockIOError is the data of BLOCK_IO_ERROR
+type qmpBlockIOError struct {
+	Device    string `json:"device"`
+	NodeName  string `json:"node-name"`
+	Operation string `json:"operation"`
+	Action    string `json:"action"`
+	NoSpace   bool   `json:"nospace"`
+	Reason    string `json:"reason"`
+}
+
+// qmpWatchdog is the data of WATCHDOG
+type qmpWatchdog struct {
+	Action string `json:"action"`
+}
+
+// qmpGuestPanicked is the data of GUEST_PANICKED
+type qmpGuestPanicked struct {
+	Action string `json:"action"`
+	Info   *struct {
+		Type string `json:"type"`
+		// Hyper-V
+		Arg1 uint64 `json:"arg1"`
+		// s390
+		Reason string `json:"reason"`
+	} `json:"info"`
+}
+
+// decodeEventData unmarshals the data of event into data
+func decodeEventData(event qmp.Event, data interface{}) error {
+	b, err := json.Marshal(event.Data)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, data)
+}
+
+// domainEventFromQmp returns the domain event for a QMP event,
+// false if it does not tell anything about the state of the domain
+func domainEventFromQmp(event qmp.Event) (types.DomainEvent, bool) {
+	domainEvent := types.DomainEvent{
+		Event: event.Event,
+		Time: time.Unix(event.Timestamp.Seconds,
+			event.Timestamp.Microseconds*int64(time.Microsecond)),
+	}
+	switch event.Event {
+	case qmpEventShutdown:
+		domainEvent.State = types.HALTING
+		domainEvent.Detail = "guest shut down"
+	case qmpEventPowerdown:
+		domainEvent.State = types.HALTING
+		domainEvent.Detail = "guest requested to power down"
+	case qmpEventReset:
+		domainEvent.State = types.RUNNING
+		domainEvent.Detail = "guest reset"
+	case qmpEventStop:
+		domainEvent.State = types.PAUSED
+		domainEvent.Detail = "guest paused"
+	case qmpEventResume:
+		domainEvent.State = types.RUNNING
+		domainEvent.Detail = "guest resumed"
+	case qmpEventWatchdog:
+		var data qmpWatchdog
+		if err := decodeEventData(event, &data); err != nil {
+			logrus.Warnf("domainEventFromQmp: bad %s data %v: %v", event.Event, event.Data, err)
+		}
+		domainEvent.Error = true
+		domainEvent.Detail = fmt.Sprintf("guest watchdog fired, action %s", data.Action)
+		switch data.Action {
+		case "pause":
+			domainEvent.State = types.PAUSED
+		case "reset":
+			domainEvent.State = types.RUNNING
+		default:
+			domainEvent.State = types.HALTING
+		}
+	case qmpEventGuestPanicked:
+		var data qmpGuestPanicked
+		if err := decodeEventData(event, &data); err != nil {
+			logrus.Warnf("domainEventFromQmp: bad %s data %v: %v", event.Event, event.Data, err)
+		}
+		domainEvent.Error = true
+		domainEvent.State = types.BROKEN
+		domainEvent.Detail = "guest kernel panicked"
+		if data.Info != nil {
+			switch {
+			case data.Info.Reason != "":
+				domainEvent.Detail += ": " + data.Info.Reason
+			case data.Info.Type == "hyper-v":
+				domainEvent.Detail += fmt.Sprintf(": bugcheck %#x", data.Info.Arg1)
+			}
 		}
-		err = json.Unmarshal(raw, &result)
-		return result.Return.Status, err
-	} else {
-		return "", err
+	case qmpEventBlockIOError:
+		var data qmpBlockIOError
+		if err := decodeEventData(event, &data); err != nil {
+			logrus.Warnf("domainEventFromQmp: bad %s data %v: %v", event.Event, event.Data, err)
+		}
+		if data.Action != "stop" {
+			// Reported to or ignored by the guest
+			return domainEvent, false
+		}
+		disk := data.Device
+		if disk == "" {
+			disk = data.NodeName
+		}
+		reason := data.Reason
+		if data.NoSpace {
+			reason = "no space left on device"
+		}
+		domainEvent.Error = true
+		domainEvent.State = types.PAUSED
+		domainEvent.Detail = fmt.Sprintf("paused on %s error on disk %s: %s",
+			data.Operation, disk, reason)
+	default:
+		return domainEvent, false
+	}
+	return domainEvent, true
+}
+
+// qmpDomainEvents is the last event of each domain
+var qmpDomainEvents = struct {
+	sync.Mutex
+	events map[string]types.DomainEvent
+}{events: make(map[string]types.DomainEvent)}
+
+// recordDomainEvent records event as the last one of the domain. An error
+// is not replaced by a following event which is not an error unless the
+// domain is running again, e.g. BLOCK_IO_ERROR is followed by STOP.
+func recordDomainEvent(domainName string, event types.DomainEvent) {
+	qmpDomainEvents.Lock()
+	defer qmpDomainEvents.Unlock()
+	last, ok := qmpDomainEvents.events[domainName]
+	if ok && last.Error && !event.Error && event.State != types.RUNNING {
+		return
 	}
+	qmpDomainEvents.events[domainName] = event
 }
 
-func qmpEventHandler(listenerSocket, executorSocket string) {
+// lastDomainEvent returns the event recorded by recordDomainEvent
+func lastDomainEvent(domainName string) (types.DomainEvent, bool) {
+	qmpDomainEvents.Lock()
+	defer qmpDomainEvents.Unlock()
+	event, ok := qmpDomainEvents.events[domainName]
+	return event, ok
+}
+
+// forgetDomainEvents is called when a domain is started or deleted
+func forgetDomainEvents(domainName string) {
+	qmpDomainEvents.Lock()
+	defer qmpDomainEvents.Unlock()
+	delete(qmpDomainEvents.events, domainName)
+}
+
+// recordBlockIOStatus records a domain paused on a disk I/O error from
+// query-block, in case the BLOCK_IO_ERROR event was missed
+func recordBlockIOStatus(domainName, socket string) {
+	if event, ok := lastDomainEvent(domainName); ok && event.Error {
+		return
+	}
+	blocks, err := queryBlock(socket)
+	if err != nil {
+		logrus.Warnf("recordBlockIOStatus(%s): %v", domainName, err)
+		return
+	}
+	for _, block := range blocks {
+		if block.IoStatus == "" || block.IoStatus == "ok" {
+			continue
+		}
+		recordDomainEvent(domainName, types.DomainEvent{
+			Event:  qmpEventBlockIOError,
+			Time:   time.Now(),
+			State:  types.PAUSED,
+			Error:  true,
+			Detail: fmt.Sprintf("paused on I/O error on disk %s: %s", block.Device, block.IoStatus),
+		})
+		return
+	}
+}
+
+func qmpEventHandler(domainName, listenerSocket, executorSocket string) {
 	monitor, err := qmp.NewSocketMonitor("unix", listenerSocket, sockTimeout)
 	if err != nil {
 		logrus.Errorf("qmpEventHandler: Exception while getting monitor of listenerSocket: %s. %s", listenerSocket, err.Error())
@@ -106,20 +644,27 @@ func qmpEventHandler(listenerSocket, executorSocket string) {
 			logrus.Errorf("qmpEventHandler: Exception while accessing listenerSocket: %s. %s", listenerSocket, err.Error())
 			return
 		}
-		select {
-		case event := <-eventChan:
-			switch event.Event {
-			case "SHUTDOWN":
-				logrus.Infof("qmpEventHandler: Received event: %s event details: %v. Calling quit on socket: %s", event.Event, event.Data, executorSocket)
-				if err := execStop(executorSocket); err != nil {
-					logrus.Errorf("qmpEventHandler: Exception while stopping domain with socket: %s. %s", executorSocket, err.Error())
-				}
-				if err := execQuit(executorSocket); err != nil {
-					logrus.Errorf("qmpEventHandler: Exception while quitting domain with socket: %s. %s", executorSocket, err.Error())
-				}
-			default:
-				//Not handling the following events: RESUME, NIC_RX_FILTER_CHANGED, RTC_CHANGE, POWERDOWN, STOP
-				logrus.Debugf("qmpEventHandler: Unhandled event: %s from listenerSocket: %s", event.Event, listenerSocket)
+		event, ok := <-eventChan
+		if !ok {
+			logrus.Infof("qmpEventHandler: listenerSocket %s closed", listenerSocket)
+			return
+		}
+		domainEvent, ok := domainEventFromQmp(event)
+		if !ok {
+			//Not handling the following events: NIC_RX_FILTER_CHANGED, RTC_CHANGE and the like
+			logrus.Debugf("qmpEventHandler: Unhandled event: %s from listenerSocket: %s", event.Event, listenerSocket)
+			continue
+		}
+		logrus.Infof("qmpEventHandler: Received event: %s event details: %v for domain %s: %s",
+			event.Event, event.Data, domainName, domainEvent.Detail)
+		recordDomainEvent(domainName, domainEvent)
+		if event.Event == qmpEventShutdown {
+			logrus.Infof("qmpEventHandler: Calling quit on socket: %s", executorSocket)
+			if err := execStop(executorSocket); err != nil {
+				logrus.Errorf("qmpEventHandler: Exception while stopping domain with socket: %s. %s", executorSocket, err.Error())
+			}
+			if err := execQuit(executorSocket); err != nil {
+				logrus.Errorf("qmpEventHandler: Exception while quitting domain with socket: %s. %s", executorSocket, err.Error())
 			}
 		}
 	}
@@ -0,0 +1,133 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package hypervisor
+
+import (
+	"testing"
+
+	"github.com/digitalocean/go-qemu/qmp"
+	"github.com/lf-edge/eve/pkg/pillar/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDomainEventFromQmp(t *testing.T) {
+	tests := map[string]struct {
+		event  qmp.Event
+		ok     bool
+		state  types.SwState
+		err    bool
+		detail string
+	}{
+		"block io error stopping the guest": {
+			event: qmp.Event{Event: "BLOCK_IO_ERROR", Data: map[string]interface{}{
+				"device": "drive-virtio-disk0", "operation": "write",
+				"action": "stop", "nospace": true, "reason": "No space left on device"}},
+			ok:     true,
+			state:  types.PAUSED,
+			err:    true,
+			detail: "paused on write error on disk drive-virtio-disk0: no space left on device",
+		},
+		"block io error reported to the guest": {
+			event: qmp.Event{Event: "BLOCK_IO_ERROR", Data: map[string]interface{}{
+				"device": "drive-virtio-disk0", "operation": "read",
+				"action": "report", "reason": "Input/output error"}},
+			ok: false,
+		},
+		"guest panicked": {
+			event: qmp.Event{Event: "GUEST_PANICKED", Data: map[string]interface{}{
+				"action": "pause"}},
+			ok:     true,
+			state:  types.BROKEN,
+			err:    true,
+			detail: "guest kernel panicked",
+		},
+		"watchdog": {
+			event: qmp.Event{Event: "WATCHDOG", Data: map[string]interface{}{
+				"action": "pause"}},
+			ok:     true,
+			state:  types.PAUSED,
+			err:    true,
+			detail: "guest watchdog fired, action pause",
+		},
+		"resume": {
+			event: qmp.Event{Event: "RESUME"},
+			ok:    true,
+			state: types.RUNNING,
+		},
+		"unhandled": {
+			event: qmp.Event{Event: "RTC_CHANGE"},
+			ok:    false,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			event, ok := domainEventFromQmp(test.event)
+			assert.Equal(t, test.ok, ok)
+			if !ok {
+				return
+			}
+			assert.Equal(t, test.event.Event, event.Event)
+			assert.Equal(t, test.state, event.State)
+			assert.Equal(t, test.err, event.Error)
+			if test.detail != "" {
+				assert.Equal(t, test.detail, event.Detail)
+			}
+		})
+	}
+}
+
+func TestRecordDomainEvent(t *testing.T) {
+	const domainName = "test-qmp-events"
+	defer forgetDomainEvents(domainName)
+
+	ioError := types.DomainEvent{Event: "BLOCK_IO_ERROR", State: types.PAUSED, Error: true}
+	stop := types.DomainEvent{Event: "STOP", State: types.PAUSED}
+	resume := types.DomainEvent{Event: "RESUME", State: types.RUNNING}
+
+	_, ok := lastDomainEvent(domainName)
+	assert.False(t, ok)
+	recordDomainEvent(domainName, ioError)
+	// STOP following the error does not hide it
+	recordDomainEvent(domainName, stop)
+	event, ok := lastDomainEvent(domainName)
+	assert.True(t, ok)
+	assert.Equal(t, ioError, event)
+	// Resuming does
+	recordDomainEvent(domainName, resume)
+	event, _ = lastDomainEvent(domainName)
+	assert.Equal(t, resume, event)
+	forgetDomainEvents(domainName)
+	_, ok = lastDomainEvent(domainName)
+	assert.False(t, ok)
+}
+
+func TestCpuDevicesToPlug(t *testing.T) {
+	cpu := func(socket, core float64, plugged bool) QmpHotpluggableCPU {
+		c := QmpHotpluggableCPU{Type: "qemu64-x86_64-cpu", VcpusCount: 1,
+			Props: map[string]interface{}{"socket-id": socket, "core-id": core, "thread-id": float64(0)}}
+		if plugged {
+			c.QomPath = "/machine/unattached/device[0]"
+		}
+		return c
+	}
+	// As listed by qemu, highest CPUs first
+	cpus := []QmpHotpluggableCPU{
+		cpu(0, 3, false), cpu(0, 2, false), cpu(0, 1, true), cpu(0, 0, true),
+	}
+
+	devices, err := cpuDevicesToPlug(cpus, 3)
+	assert.NoError(t, err)
+	if assert.Len(t, devices, 1) {
+		assert.Equal(t, "qemu64-x86_64-cpu", devices[0]["driver"])
+		assert.Equal(t, "vcpu2", devices[0]["id"])
+		assert.Equal(t, float64(2), devices[0]["core-id"])
+	}
+
+	devices, err = cpuDevicesToPlug(cpus, 2)
+	assert.NoError(t, err)
+	assert.Empty(t, devices)
+
+	_, err = cpuDevicesToPlug(cpus, 5)
+	assert.Error(t, err)
+}
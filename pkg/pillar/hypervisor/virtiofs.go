@@ -0,0 +1,133 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package hypervisor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/lf-edge/eve/pkg/pillar/containerd"
+	"github.com/lf-edge/eve/pkg/pillar/types"
+)
+
+// Directory volumes are shared with VMs with virtio-fs: a virtiofsd serves
+// each of them on a vhost-user socket the VMM connects to. virtiofsd runs
+// in the task container of the domain next to the VMM and exits when the
+// VMM disconnects i.e., with the domain.
+
+// The guest can not mount tags longer than that
+const virtiofsMaxTagLen = 36
+
+// virtiofsdTimeout is how long virtiofsd may take to serve its socket
+const virtiofsdTimeout = 10 * time.Second
+
+// virtiofsShare is a host directory shared with a VM by a virtiofsd
+type virtiofsShare struct {
+	ID       string
+	Dir      string
+	Socket   string
+	Tag      string
+	ReadOnly bool
+}
+
+// virtiofsShareOf returns the share of disk i of the domain, with its
+// virtiofsd socket in stateDir
+func virtiofsShareOf(stateDir string, i int, ds types.DiskStatus) virtiofsShare {
+	id := fmt.Sprintf("fs%d", i)
+	return virtiofsShare{
+		ID:       id,
+		Dir:      ds.FileLocation,
+		Socket:   filepath.Join(stateDir, id+".sock"),
+		Tag:      virtiofsTag(i, ds),
+		ReadOnly: ds.ReadOnly,
+	}
+}
+
+// virtiofsShares returns the shares of the directory volumes of the domain
+func virtiofsShares(stateDir string, diskStatusList []types.DiskStatus) []virtiofsShare {
+	var shares []virtiofsShare
+	for i, ds := range diskStatusList {
+		if ds.Devtype == "virtiofs" {
+			shares = append(shares, virtiofsShareOf(stateDir, i, ds))
+		}
+	}
+	return shares
+}
+
+// virtiofsTag returns the tag the guest mounts the directory volume of disk
+// i with: its display name unless it does not fit
+func virtiofsTag(i int, ds types.DiskStatus) string {
+	if ds.DisplayName != "" && len(ds.DisplayName) <= virtiofsMaxTagLen {
+		return ds.DisplayName
+	}
+	return fmt.Sprintf("vol%d", i)
+}
+
+// each virtiofsd needs about 16MB of memory in the task container
+func virtiofsdVMMOverhead(config types.DomainConfig) int64 {
+	var overhead int64
+	for _, dc := range config.DiskConfigList {
+		if dc.Directory {
+			overhead += 16 << 20 // Mb in bytes
+		}
+	}
+	return overhead
+}
+
+// virtiofsdCommand returns the shell command starting virtiofsd in the
+// background for share. virtiofsd confines itself to the shared directory
+// in its own namespaces so that the guest can not reach the rest of the
+// host through symbolic links.
+func virtiofsdCommand(virtiofsdExec string, share virtiofsShare) string {
+	readOnly := ""
+	if share.ReadOnly {
+		readOnly = " --readonly"
+	}
+	return fmt.Sprintf("%s --socket-path=%s --shared-dir=%s --cache=auto --sandbox=namespace%s >/dev/null 2>&1 &",
+		virtiofsdExec, shellQuote(share.Socket), shellQuote(share.Dir), readOnly)
+}
+
+// startVirtiofsd starts a virtiofsd for each share in the running task of
+// the domain and waits for them to serve their socket
+func startVirtiofsd(ctrdClient *containerd.Client, domainName string,
+	virtiofsdExec string, shares []virtiofsShare) error {
+	if len(shares) == 0 {
+		return nil
+	}
+	ctrdCtx, done := ctrdClient.CtrNewUserServicesCtx()
+	defer done()
+	for _, share := range shares {
+		// Do not mistake the socket of a previous run for the new one
+		os.Remove(share.Socket)
+		if _, stderr, err := ctrdClient.CtrExec(ctrdCtx, domainName,
+			[]string{"/bin/sh", "-c", virtiofsdCommand(virtiofsdExec, share)}); err != nil {
+			return fmt.Errorf("failed to start virtiofsd for %s: %v %s", share.Dir, err, stderr)
+		}
+	}
+	for _, share := range shares {
+		if err := waitForSocket(share.Socket, virtiofsdTimeout); err != nil {
+			return fmt.Errorf("virtiofsd for %s not available: %v", share.Dir, err)
+		}
+	}
+	return nil
+}
+
+// waitForSocket waits for the unix socket to be created. virtiofsd serves
+// a single connection, so we can not try to connect to it
+func waitForSocket(socket string, timeout time.Duration) error {
+	var err error
+	for waited := time.Duration(0); waited < timeout; waited += time.Second / 10 {
+		var fi os.FileInfo
+		if fi, err = os.Stat(socket); err == nil {
+			if fi.Mode()&os.ModeSocket != 0 {
+				return nil
+			}
+			err = fmt.Errorf("%s is not a socket", socket)
+		}
+		time.Sleep(time.Second / 10)
+	}
+	return err
+}
@@ -0,0 +1,42 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package hypervisor
+
+import (
+	"testing"
+
+	"github.com/lf-edge/eve/pkg/pillar/types"
+)
+
+func TestVirtiofsShares(t *testing.T) {
+	disks := []types.DiskStatus{
+		{FileLocation: "/foo/bar.qcow2", Devtype: "hdd"},
+		{FileLocation: "/foo/data.dir", Devtype: "virtiofs", DisplayName: "data"},
+		{FileLocation: "/foo/logs.dir", Devtype: "virtiofs", ReadOnly: true,
+			DisplayName: "a-display-name-too-long-to-be-a-virtiofs-tag"},
+	}
+	expected := []virtiofsShare{
+		{ID: "fs1", Dir: "/foo/data.dir", Socket: "/run/test/fs1.sock", Tag: "data"},
+		{ID: "fs2", Dir: "/foo/logs.dir", Socket: "/run/test/fs2.sock", Tag: "vol2", ReadOnly: true},
+	}
+
+	shares := virtiofsShares("/run/test", disks)
+	if len(shares) != len(expected) {
+		t.Fatalf("expected %d shares, got %v", len(expected), shares)
+	}
+	for i := range expected {
+		if shares[i] != expected[i] {
+			t.Errorf("expected share %v, got %v", expected[i], shares[i])
+		}
+	}
+}
+
+func TestVirtiofsdCommand(t *testing.T) {
+	share := virtiofsShare{Dir: "/foo/it's.dir", Socket: "/run/test/fs0.sock", ReadOnly: true}
+	expected := "/bin/virtiofsd --socket-path='/run/test/fs0.sock' --shared-dir='/foo/it'\\''s.dir'" +
+		" --cache=auto --sandbox=namespace --readonly >/dev/null 2>&1 &"
+	if cmd := virtiofsdCommand("/bin/virtiofsd", share); cmd != expected {
+		t.Errorf("expected %s, got %s", expected, cmd)
+	}
+}
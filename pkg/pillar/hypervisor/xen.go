@@ -293,6 +293,10 @@ func (ctx xenContext) CreateDomConfig(domainName string, config types.DomainConf
 		case "9P":
 			p9Strings = append(p9Strings,
 				fmt.Sprintf("'tag=share_dir,security_model=none,path=%s'", ds.FileLocation))
+		case "virtiofs":
+			// there is no virtio-fs with xen, 9P is the closest
+			p9Strings = append(p9Strings,
+				fmt.Sprintf("'tag=%s,security_model=none,path=%s'", virtiofsTag(i, ds), ds.FileLocation))
 		default:
 			access := "rw"
 			if ds.ReadOnly {
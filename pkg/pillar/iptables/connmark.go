@@ -56,6 +56,10 @@ var ControlProtocolMarkingIDMap = map[string]string{
 	"app_icmpv6": "12",
 	// for Kubernetes DNS, allowing coreDNS to talk to external DNS servers
 	"in_dns": "13",
+	// Content shared between EVE devices and the mDNS traffic advertising it
+	"in_peer_content": "14",
+	// App initiated requests towards the OCI registry running in dom0
+	"app_registry": "15",
 }
 
 // GetConnmark : create connection mark corresponding to the given attributes.
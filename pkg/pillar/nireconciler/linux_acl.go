@@ -94,7 +94,7 @@ func matchVifIfName(vif vifInfo) string {
 }
 
 func getEssentialIPv4Protos(niType types.NetworkInstanceType,
-	bridgeIP net.IP) (protos []essentialProto) {
+	bridgeIP net.IP, registryEnabled bool) (protos []essentialProto) {
 	switch niType {
 	case types.NetworkInstanceTypeSwitch:
 		protos = append(protos, essentialProto{
@@ -150,6 +150,15 @@ func getEssentialIPv4Protos(niType types.NetworkInstanceType,
 				mark:          iptables.ControlProtocolMarkingIDMap["app_dns"],
 				markChainName: "dns",
 			})
+			if registryEnabled {
+				protos = append(protos, essentialProto{
+					label: "OCI registry",
+					egressMatch: []string{"-d", bridgeIP.String(),
+						"-p", "tcp", "--dport", strconv.Itoa(types.LocalRegistryPort)},
+					mark:          iptables.ControlProtocolMarkingIDMap["app_registry"],
+					markChainName: "registry",
+				})
+			}
 		}
 	}
 	return
@@ -562,7 +571,8 @@ func (r *LinuxNIReconciler) getIntendedAppConnRawIptables(vif vifInfo,
 	if ipv6 {
 		essentialProtos = getEssentialIPv6Protos(ni.config.Type, bridgeIP)
 	} else {
-		essentialProtos = getEssentialIPv4Protos(ni.config.Type, bridgeIP)
+		essentialProtos = getEssentialIPv4Protos(ni.config.Type, bridgeIP,
+			r.localRegistryEnabled)
 	}
 	for _, proto := range essentialProtos {
 		aclRules = append(aclRules, iptables.Rule{
@@ -705,7 +715,8 @@ func (r *LinuxNIReconciler) getIntendedAppConnFilterIptables(vif vifInfo,
 	if ipv6 {
 		essentialProtos = getEssentialIPv6Protos(ni.config.Type, bridgeIP)
 	} else {
-		essentialProtos = getEssentialIPv4Protos(ni.config.Type, bridgeIP)
+		essentialProtos = getEssentialIPv4Protos(ni.config.Type, bridgeIP,
+			r.localRegistryEnabled)
 	}
 	for _, proto := range essentialProtos {
 		if proto.ingressMatch == nil {
@@ -897,7 +908,8 @@ func (r *LinuxNIReconciler) getIntendedAppConnMangleIptables(vif vifInfo,
 	if ipv6 {
 		essentialProtos = getEssentialIPv6Protos(ni.config.Type, bridgeIP)
 	} else {
-		essentialProtos = getEssentialIPv4Protos(ni.config.Type, bridgeIP)
+		essentialProtos = getEssentialIPv4Protos(ni.config.Type, bridgeIP,
+			r.localRegistryEnabled)
 	}
 
 	// Put mangle/PREROUTING rules for this VIF into a separate table.
@@ -138,10 +138,11 @@ import (
 //  |   |   |       |   (external)  |   |  (for L2 NI)  |        |   |   |
 //  |   |   |       +---------------+   +---------------+        |   |   |
 //  |   |   |                                                    |   |   |
-//  |   |   |                    +----------+                    |   |   |
-//  |   |   |                    |  IPSet   |                    |   |   |
-//  |   |   |                    |  (eids)  |                    |   |   |
-//  |   |   |                    +----------+                    |   |   |
+//  |   |   |     +----------+       +-------------------+       |   |   |
+//  |   |   |     |  IPSet   |       |   VIFRateLimit    |       |   |   |
+//  |   |   |     |  (eids)  |       | (if bandwidth is  |       |   |   |
+//  |   |   |     +----------+       |     limited)      |       |   |   |
+//  |   |   |                        +-------------------+       |   |   |
 //  |   |   |                                                    |   |   |
 //  |   |   |   +--------------------------------------------+   |   |   |
 //  |   |   |   |                   ACLs                     |   |   |   |
@@ -984,6 +985,13 @@ func (r *LinuxNIReconciler) getIntendedAppConnCfg(niID uuid.UUID,
 			VLANConfig: vlanConfig,
 		}, nil)
 	}
+	if ul.RateLimits != (types.NetRateLimits{}) {
+		intendedAppConnCfg.PutItem(linux.VIFRateLimit{
+			VIFIfName:  vif.hostIfName,
+			EgressBps:  ul.RateLimits.EgressBps,
+			IngressBps: ul.RateLimits.IngressBps,
+		}, nil)
+	}
 	// Create ipset with all the addresses from the DNSNameToIPList plus the VIF IP itself.
 	var ips []net.IP
 	for _, staticEntry := range ni.config.DnsNameToIPList {
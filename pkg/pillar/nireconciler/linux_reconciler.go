@@ -55,6 +55,7 @@ type LinuxNIReconciler struct {
 
 	// From GCP
 	disableAllOnesNetmask bool
+	localRegistryEnabled  bool
 
 	reconcileMu   sync.Mutex
 	currentState  dg.Graph
@@ -760,19 +761,36 @@ func (r *LinuxNIReconciler) ApplyUpdatedGCP(ctx context.Context,
 	contWatcher := r.pauseWatcher()
 	defer contWatcher()
 	disableAllOnesNetmask := newGCP.GlobalValueBool(types.DisableDHCPAllOnesNetMask)
-	if r.disableAllOnesNetmask == disableAllOnesNetmask {
+	localRegistryEnabled := newGCP.GlobalValueBool(types.LocalRegistryEnable)
+	if r.disableAllOnesNetmask == disableAllOnesNetmask &&
+		r.localRegistryEnabled == localRegistryEnabled {
 		// No change in GCP relevant for network instances.
 		return
 	}
-	r.disableAllOnesNetmask = disableAllOnesNetmask
-	for niID, ni := range r.nis {
-		if ni.config.Type == types.NetworkInstanceTypeSwitch {
-			// Not running DHCP server for switch NI inside EVE.
-			continue
+	if r.disableAllOnesNetmask != disableAllOnesNetmask {
+		r.disableAllOnesNetmask = disableAllOnesNetmask
+		for niID, ni := range r.nis {
+			if ni.config.Type == types.NetworkInstanceTypeSwitch {
+				// Not running DHCP server for switch NI inside EVE.
+				continue
+			}
+			r.scheduleNICfgRebuild(niID,
+				fmt.Sprintf("global config property %s changed to %t",
+					types.DisableDHCPAllOnesNetMask, r.disableAllOnesNetmask))
+		}
+	}
+	if r.localRegistryEnabled != localRegistryEnabled {
+		r.localRegistryEnabled = localRegistryEnabled
+		for niID, ni := range r.nis {
+			if ni.config.Type != types.NetworkInstanceTypeLocal {
+				// Local registry is only served on local NI bridges.
+				continue
+			}
+			// App ACLs are part of the NI config and get rebuilt with it.
+			r.scheduleNICfgRebuild(niID,
+				fmt.Sprintf("global config property %s changed to %t",
+					types.LocalRegistryEnable, r.localRegistryEnabled))
 		}
-		r.scheduleNICfgRebuild(niID,
-			fmt.Sprintf("global config property %s changed to %t",
-				types.DisableDHCPAllOnesNetMask, r.disableAllOnesNetmask))
 	}
 	updates := r.reconcile(ctx)
 	r.publishReconcilerUpdates(updates...)
@@ -1052,7 +1070,8 @@ func (r *LinuxNIReconciler) getSubgraphState(intSG, currSG dg.GraphR, forApp boo
 	itemIsForApp := func(item dg.Item) bool {
 		// XXX Better would be to check if item is inside AppConn-* subgraph
 		// but depgraph API does not allow to do that.
-		if item.Type() == generic.VIFTypename {
+		if item.Type() == generic.VIFTypename ||
+			item.Type() == linux.VIFRateLimitTypename {
 			return true
 		}
 		if item.Type() == linux.VLANPortTypename {
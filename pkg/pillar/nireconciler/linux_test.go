@@ -667,6 +667,10 @@ var (
 					},
 				},
 				AccessVlanID: 10,
+				RateLimits: types.NetRateLimits{
+					EgressBps:  10000000,
+					IngressBps: 20000000,
+				},
 			},
 			{
 				Name:      "adapter3",
@@ -1353,6 +1357,11 @@ func TestIPv4LocalAndSwitchNIs(test *testing.T) {
 				VIFIfName: "nbu2x2",
 			}}))).To(BeTrue())
 
+	t.Expect(itemIsCreated(dg.Reference(
+		linuxitems.VIFRateLimit{VIFIfName: "nbu2x2"}))).To(BeTrue())
+	t.Expect(itemIsCreated(dg.Reference(
+		linuxitems.VIFRateLimit{VIFIfName: "nbu3x2"}))).To(BeFalse())
+
 	vif2Eidset := itemDescription(dg.Reference(linuxitems.IPSet{SetName: "ipv4.eids.nbu2x2"}))
 	t.Expect(vif2Eidset).To(ContainSubstring("entries: []"))
 	vif3Eidset := itemDescription(dg.Reference(linuxitems.IPSet{SetName: "ipv4.eids.nbu3x2"}))
@@ -1439,6 +1448,55 @@ func TestDisableAllOnesMask(test *testing.T) {
 	t.Expect(err).ToNot(HaveOccurred())
 }
 
+func TestLocalRegistryACL(test *testing.T) {
+	t := initTest(test)
+	networkMonitor.AddOrUpdateInterface(eth0)
+	networkMonitor.UpdateRoutes(eth0Routes)
+	ctx := reconciler.MockRun(context.Background())
+	updatesCh := niReconciler.WatchReconcilerUpdates()
+	niReconciler.RunInitialReconcile(ctx)
+
+	// Create local network instance and connect application into it.
+	_, err := niReconciler.AddNI(ctx, ni1Config, ni1Bridge)
+	t.Expect(err).ToNot(HaveOccurred())
+	var recUpdate nirec.ReconcilerUpdate
+	t.Eventually(updatesCh).Should(Receive(&recUpdate))
+	t.Expect(recUpdate.UpdateType).To(Equal(nirec.NIReconcileStatusChanged))
+	networkMonitor.AddOrUpdateInterface(ni1BridgeIf)
+	_, err = niReconciler.ConnectApp(ctx, app1NetConfig, app1Num, app1VIFs)
+	t.Expect(err).ToNot(HaveOccurred())
+	t.Eventually(updatesCh).Should(Receive(&recUpdate))
+	t.Expect(recUpdate.UpdateType).To(Equal(nirec.AppConnReconcileStatusChanged))
+
+	// Application is not allowed to access the local registry by default.
+	registryRule := iptables.Rule{
+		RuleLabel: "Allow OCI registry",
+		Table:     "raw",
+		ChainName: "PREROUTING-nbu1x1",
+	}
+	_, _, _, found := niReconciler.GetIntendedState().Item(dg.Reference(registryRule))
+	t.Expect(found).To(BeFalse())
+
+	// Update global config to enable the local registry.
+	gcp := types.DefaultConfigItemValueMap()
+	gcp.SetGlobalValueBool(types.LocalRegistryEnable, true)
+	niReconciler.ApplyUpdatedGCP(ctx, *gcp)
+	_, _, _, found = niReconciler.GetIntendedState().Item(dg.Reference(registryRule))
+	t.Expect(found).To(BeTrue())
+
+	// Disable the local registry again.
+	gcp.SetGlobalValueBool(types.LocalRegistryEnable, false)
+	niReconciler.ApplyUpdatedGCP(ctx, *gcp)
+	_, _, _, found = niReconciler.GetIntendedState().Item(dg.Reference(registryRule))
+	t.Expect(found).To(BeFalse())
+
+	// Disconnect the application and delete network instance.
+	_, err = niReconciler.DisconnectApp(ctx, app1UUID.UUID)
+	t.Expect(err).ToNot(HaveOccurred())
+	_, err = niReconciler.DelNI(ctx, ni1UUID.UUID)
+	t.Expect(err).ToNot(HaveOccurred())
+}
+
 func TestUplinkFailover(test *testing.T) {
 	t := initTest(test)
 	networkMonitor.AddOrUpdateInterface(eth0)
@@ -14,4 +14,6 @@ const (
 	VLANBridgeTypename = "VLANBridge"
 	// VLANPortTypename : typename for bridged port with configured VLAN(s).
 	VLANPortTypename = "VLANPort"
+	// VIFRateLimitTypename : typename for bandwidth limits of a VIF.
+	VIFRateLimitTypename = "VIFRateLimit"
 )
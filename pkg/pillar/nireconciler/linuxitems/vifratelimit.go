@@ -0,0 +1,187 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package linuxitems
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	dg "github.com/lf-edge/eve-libs/depgraph"
+	"github.com/lf-edge/eve/pkg/pillar/base"
+	generic "github.com/lf-edge/eve/pkg/pillar/nireconciler/genericitems"
+)
+
+const (
+	tcCmd = "tc"
+	// Traffic above the rate may be sent in bursts of up to 20ms worth of
+	// the rate, but not less than this.
+	minTcBurst = 16 * 1024
+)
+
+// VIFRateLimit : bandwidth limits of a VIF enforced with tc.
+// The traffic to the app is shaped by a token bucket filter on the egress
+// of the VIF, the traffic from the app is policed on its ingress.
+type VIFRateLimit struct {
+	// VIFIfName : host-side interface name of the VIF.
+	VIFIfName string
+	// EgressBps : bandwidth of the traffic from the app in bits per second,
+	// 0 for no limit.
+	EgressBps uint64
+	// IngressBps : bandwidth of the traffic to the app in bits per second,
+	// 0 for no limit.
+	IngressBps uint64
+}
+
+// Name returns the interface name of the VIF
+// (there can be at most one instance of VIFRateLimit associated with a given VIF).
+func (v VIFRateLimit) Name() string {
+	return v.VIFIfName
+}
+
+// Label for VIFRateLimit.
+func (v VIFRateLimit) Label() string {
+	return v.VIFIfName + " (rate limit)"
+}
+
+// Type of the item.
+func (v VIFRateLimit) Type() string {
+	return VIFRateLimitTypename
+}
+
+// Equal compares two VIFRateLimit instances.
+func (v VIFRateLimit) Equal(other dg.Item) bool {
+	v2, isVIFRateLimit := other.(VIFRateLimit)
+	if !isVIFRateLimit {
+		return false
+	}
+	return v == v2
+}
+
+// External returns false.
+func (v VIFRateLimit) External() bool {
+	return false
+}
+
+// String describes VIFRateLimit.
+func (v VIFRateLimit) String() string {
+	return fmt.Sprintf("VIFRateLimit: {vifIfName: %s, egressBps: %d, ingressBps: %d}",
+		v.VIFIfName, v.EgressBps, v.IngressBps)
+}
+
+// Dependencies returns the VIF as the only dependency.
+func (v VIFRateLimit) Dependencies() (deps []dg.Dependency) {
+	return []dg.Dependency{
+		{
+			RequiredItem: dg.ItemRef{
+				ItemType: generic.VIFTypename,
+				ItemName: v.VIFIfName,
+			},
+			Description: "VIF must exist",
+			Attributes: dg.DependencyAttributes{
+				AutoDeletedByExternal: true,
+			},
+		},
+	}
+}
+
+// VIFRateLimitConfigurator implements Configurator interface (libs/reconciler)
+// for VIF bandwidth limits.
+type VIFRateLimitConfigurator struct {
+	Log *base.LogObject
+}
+
+// Create installs the qdiscs limiting the bandwidth of the VIF.
+func (c *VIFRateLimitConfigurator) Create(ctx context.Context, item dg.Item) error {
+	rateLimit, isVIFRateLimit := item.(VIFRateLimit)
+	if !isVIFRateLimit {
+		return fmt.Errorf("invalid item type %T, expected VIFRateLimit", item)
+	}
+	return c.apply(VIFRateLimit{VIFIfName: rateLimit.VIFIfName}, rateLimit)
+}
+
+// Modify replaces the qdiscs of the VIF with ones for the new limits.
+func (c *VIFRateLimitConfigurator) Modify(ctx context.Context, oldItem, newItem dg.Item) error {
+	oldRateLimit, isVIFRateLimit := oldItem.(VIFRateLimit)
+	if !isVIFRateLimit {
+		return fmt.Errorf("invalid item type %T, expected VIFRateLimit", oldItem)
+	}
+	newRateLimit, isVIFRateLimit := newItem.(VIFRateLimit)
+	if !isVIFRateLimit {
+		return fmt.Errorf("invalid item type %T, expected VIFRateLimit", newItem)
+	}
+	return c.apply(oldRateLimit, newRateLimit)
+}
+
+// Delete removes the qdiscs limiting the bandwidth of the VIF.
+func (c *VIFRateLimitConfigurator) Delete(ctx context.Context, item dg.Item) error {
+	rateLimit, isVIFRateLimit := item.(VIFRateLimit)
+	if !isVIFRateLimit {
+		return fmt.Errorf("invalid item type %T, expected VIFRateLimit", item)
+	}
+	return c.apply(rateLimit, VIFRateLimit{VIFIfName: rateLimit.VIFIfName})
+}
+
+// NeedsRecreate returns false - Modify is able to apply any change.
+func (c *VIFRateLimitConfigurator) NeedsRecreate(oldItem, newItem dg.Item) (recreate bool) {
+	return false
+}
+
+func (c *VIFRateLimitConfigurator) apply(oldRateLimit, newRateLimit VIFRateLimit) error {
+	ifName := newRateLimit.VIFIfName
+	if oldRateLimit.IngressBps != newRateLimit.IngressBps {
+		if newRateLimit.IngressBps == 0 {
+			if err := c.tc("qdisc", "del", "dev", ifName, "root"); err != nil {
+				return err
+			}
+		} else {
+			rate, burst := tcRateAndBurst(newRateLimit.IngressBps)
+			if err := c.tc("qdisc", "replace", "dev", ifName, "root", "tbf",
+				"rate", rate, "burst", burst, "latency", "50ms"); err != nil {
+				return err
+			}
+		}
+	}
+	if oldRateLimit.EgressBps != newRateLimit.EgressBps {
+		// Drop the previous police filter with the ingress qdisc
+		if oldRateLimit.EgressBps != 0 {
+			if err := c.tc("qdisc", "del", "dev", ifName, "ingress"); err != nil {
+				return err
+			}
+		}
+		if newRateLimit.EgressBps != 0 {
+			rate, burst := tcRateAndBurst(newRateLimit.EgressBps)
+			if err := c.tc("qdisc", "add", "dev", ifName, "handle", "ffff:", "ingress"); err != nil {
+				return err
+			}
+			if err := c.tc("filter", "add", "dev", ifName, "parent", "ffff:",
+				"protocol", "all", "prio", "1", "u32", "match", "u32", "0", "0",
+				"police", "rate", rate, "burst", burst, "drop", "flowid", ":1"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (c *VIFRateLimitConfigurator) tc(args ...string) error {
+	output, err := base.Exec(c.Log, tcCmd, args...).CombinedOutput()
+	if err != nil {
+		err = fmt.Errorf("%s %s failed: %s (%w)", tcCmd,
+			strings.Join(args, " "), strings.TrimSpace(string(output)), err)
+		c.Log.Error(err)
+		return err
+	}
+	return nil
+}
+
+// tcRateAndBurst returns the tc arguments for the rate in bits per second
+// and its burst.
+func tcRateAndBurst(bps uint64) (rate string, burst string) {
+	burstBytes := bps / 8 / 50
+	if burstBytes < minTcBurst {
+		burstBytes = minTcBurst
+	}
+	return fmt.Sprintf("%dbit", bps), fmt.Sprintf("%d", burstBytes)
+}
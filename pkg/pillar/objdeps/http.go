@@ -0,0 +1,121 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package objdeps
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/lf-edge/eve/pkg/pillar/types"
+)
+
+// AppBlocker is what an app instance is waiting for
+type AppBlocker struct {
+	AppUUID string
+	AppName string `json:",omitempty"`
+	// Blocker is nil if nothing blocks the app instance.
+	// Its dependencies are left out.
+	Blocker     *Object `json:",omitempty"`
+	Description string  `json:",omitempty"`
+}
+
+// AppGraph is an app instance with all its dependencies
+type AppGraph struct {
+	AppBlocker
+	Graph *Object
+}
+
+// appBlocker returns the AppBlocker for app
+func appBlocker(app *Object, appName string) AppBlocker {
+	result := AppBlocker{AppUUID: app.Key, AppName: appName}
+	if blocker := Blocker(app); blocker != nil {
+		withoutDeps := *blocker
+		withoutDeps.Deps = nil
+		result.Blocker = &withoutDeps
+		result.Description = Describe(blocker)
+	}
+	return result
+}
+
+// Entity returns the entity to report in an error about obj
+func (obj *Object) Entity() *types.ErrorEntity {
+	switch obj.Topic {
+	case "VolumeStatus":
+		// The key is the volume UUID and its generation counter
+		return &types.ErrorEntity{EntityType: types.ErrorEntityVolume,
+			EntityID: strings.Split(obj.Key, "#")[0]}
+	case "ContentTreeStatus":
+		return &types.ErrorEntity{EntityType: types.ErrorEntityContentTree,
+			EntityID: obj.Key}
+	case "BlobStatus", "DownloaderStatus", "VerifyImageStatus":
+		return &types.ErrorEntity{EntityType: types.ErrorEntityContentBlob,
+			EntityID: obj.Key}
+	case "AppInstanceStatus", "AppInstanceConfig", "DomainStatus":
+		return &types.ErrorEntity{EntityType: types.ErrorEntityAppInstance,
+			EntityID: obj.Key}
+	default:
+		return nil
+	}
+}
+
+// Handler serves the blockers of the app instances over http:
+//
+//	GET /apps
+//		list of AppBlocker for all the app instances
+//	GET /apps?uuid=<uuid>
+//		AppGraph of the app instance
+type Handler struct {
+	Source Source
+}
+
+// ServeHTTP serves the requests
+func (h Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "read-only", http.StatusMethodNotAllowed)
+		return
+	}
+	g := New(h.Source)
+	var v interface{}
+	if appUUID := req.URL.Query().Get("uuid"); appUUID != "" {
+		app, err := g.App(appUUID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		v = AppGraph{AppBlocker: appBlocker(app, appName(g, app)), Graph: app}
+	} else {
+		var blockers []AppBlocker
+		for key := range h.Source.Items("zedagent/AppInstanceConfig") {
+			app, err := g.App(key)
+			if err != nil {
+				continue
+			}
+			blockers = append(blockers, appBlocker(app, appName(g, app)))
+		}
+		sort.Slice(blockers, func(i, j int) bool {
+			return blockers[i].AppUUID < blockers[j].AppUUID
+		})
+		v = blockers
+	}
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+func appName(g *Graph, app *Object) string {
+	switch item := g.lookup(app.Ref).(type) {
+	case types.AppInstanceStatus:
+		return item.DisplayName
+	case types.AppInstanceConfig:
+		return item.DisplayName
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,182 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package objdeps builds the graph of the dependencies between the objects
+// published by the agents, following the cross-references between them,
+// e.g. from an app instance to its volumes, from a volume to its content
+// tree and from a content tree to its blobs. It is used to find out what
+// an app instance is waiting for: the deepest object which blocks it,
+// preferably one with an error.
+package objdeps
+
+import (
+	"fmt"
+
+	"github.com/lf-edge/eve/pkg/pillar/types"
+)
+
+// Source gives the items published by the agents; pubsub.Registry is one
+type Source interface {
+	// Items returns the items of the publication name i.e. agent/topic,
+	// or nil if there is no such publication
+	Items(name string) map[string]interface{}
+}
+
+// Ref identifies a published object
+type Ref struct {
+	Agent string
+	Topic string
+	Key   string
+}
+
+// Name of the publication of the object
+func (ref Ref) Name() string {
+	return ref.Agent + "/" + ref.Topic
+}
+
+// String returns agent/topic/key
+func (ref Ref) String() string {
+	return ref.Name() + "/" + ref.Key
+}
+
+// Object is a published object with the objects it depends on
+type Object struct {
+	Ref
+	// Missing is set if the object is referenced but not published
+	Missing bool `json:",omitempty"`
+	State   types.SwState
+	Error   types.ErrorDescription
+	// Blocking is set if the object is missing, has an error, or has not
+	// yet reached the state the objects depending on it wait for
+	Blocking bool
+	Deps     []*Object `json:",omitempty"`
+}
+
+// HasError returns true if the object has an error
+func (obj *Object) HasError() bool {
+	return obj.Error.Error != ""
+}
+
+// Graph of the objects reachable from the ones asked for. The objects are
+// looked up from the Source when first needed and then cached, hence
+// a Graph is a snapshot meant for the queries of one pass, e.g. one for
+// each app instance.
+type Graph struct {
+	src     Source
+	items   map[string]map[string]interface{}
+	objects map[Ref]*Object
+}
+
+// New returns an empty Graph on top of src
+func New(src Source) *Graph {
+	return &Graph{
+		src:     src,
+		items:   make(map[string]map[string]interface{}),
+		objects: make(map[Ref]*Object),
+	}
+}
+
+// lookup returns the item for ref, or nil
+func (g *Graph) lookup(ref Ref) interface{} {
+	name := ref.Name()
+	items, ok := g.items[name]
+	if !ok {
+		items = g.src.Items(name)
+		g.items[name] = items
+	}
+	return items[ref.Key]
+}
+
+// Object returns the object for ref with its dependencies
+func (g *Graph) Object(ref Ref) *Object {
+	if obj, ok := g.objects[ref]; ok {
+		return obj
+	}
+	obj := &Object{Ref: ref}
+	// Register first to stop on cycles
+	g.objects[ref] = obj
+	item := g.lookup(ref)
+	if item == nil {
+		obj.Missing = true
+		obj.Blocking = true
+		return obj
+	}
+	rule, ok := rules[ref.Name()]
+	if !ok {
+		return obj
+	}
+	desc := rule.describe(g, item)
+	obj.State = desc.state
+	obj.Error = desc.err
+	obj.Blocking = obj.HasError() ||
+		(rule.done != 0 && obj.State < rule.done)
+	for _, dep := range desc.deps {
+		if dep.optional && g.lookup(dep.ref) == nil {
+			continue
+		}
+		obj.Deps = append(obj.Deps, g.Object(dep.ref))
+	}
+	return obj
+}
+
+// App returns the app instance appUUID with its dependencies
+func (g *Graph) App(appUUID string) (*Object, error) {
+	ref := Ref{Agent: "zedmanager", Topic: "AppInstanceStatus", Key: appUUID}
+	if g.lookup(ref) == nil {
+		// Not yet handled by zedmanager
+		ref = Ref{Agent: "zedagent", Topic: "AppInstanceConfig", Key: appUUID}
+		if g.lookup(ref) == nil {
+			return nil, fmt.Errorf("App(%s): unknown app instance", appUUID)
+		}
+	}
+	return g.Object(ref), nil
+}
+
+// Blocker returns the deepest blocking object which obj depends on,
+// preferring the ones with an error. Returns obj itself if it has an
+// error and none of its dependencies do, and nil if nothing blocks.
+func Blocker(obj *Object) *Object {
+	return blocker(obj, make(map[*Object]bool))
+}
+
+func blocker(obj *Object, visited map[*Object]bool) *Object {
+	visited[obj] = true
+	var deepest *Object
+	for _, dep := range obj.Deps {
+		if !dep.Blocking || visited[dep] {
+			continue
+		}
+		b := blocker(dep, visited)
+		if b == nil {
+			continue
+		}
+		if b.HasError() {
+			return b
+		}
+		if deepest == nil {
+			deepest = b
+		}
+	}
+	if obj.HasError() {
+		return obj
+	}
+	if deepest != nil {
+		return deepest
+	}
+	if obj.Blocking {
+		return obj
+	}
+	return nil
+}
+
+// Describe returns a one line description of why obj is blocking
+func Describe(obj *Object) string {
+	switch {
+	case obj.Missing:
+		return fmt.Sprintf("%s: not published", obj.Ref)
+	case obj.HasError():
+		return fmt.Sprintf("%s in %s: %s", obj.Ref, obj.State, obj.Error.Error)
+	default:
+		return fmt.Sprintf("%s in %s", obj.Ref, obj.State)
+	}
+}
@@ -0,0 +1,223 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package objdeps_test
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lf-edge/eve/pkg/pillar/objdeps"
+	"github.com/lf-edge/eve/pkg/pillar/types"
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+type source map[string]map[string]interface{}
+
+func (s source) Items(name string) map[string]interface{} {
+	return s[name]
+}
+
+func (s source) add(name, key string, item interface{}) {
+	if s[name] == nil {
+		s[name] = make(map[string]interface{})
+	}
+	s[name][key] = item
+}
+
+var (
+	appUUID     = uuid.FromStringOrNil("6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+	volumeUUID  = uuid.FromStringOrNil("6ba7b811-9dad-11d1-80b4-00c04fd430c8")
+	blankUUID   = uuid.FromStringOrNil("6ba7b812-9dad-11d1-80b4-00c04fd430c8")
+	contentUUID = uuid.FromStringOrNil("6ba7b814-9dad-11d1-80b4-00c04fd430c8")
+)
+
+const (
+	indexSha    = "1111111111111111111111111111111111111111111111111111111111111111"
+	manifestSha = "2222222222222222222222222222222222222222222222222222222222222222"
+)
+
+func newSource() source {
+	src := make(source)
+	config := types.AppInstanceConfig{
+		UUIDandVersion: types.UUIDandVersion{UUID: appUUID},
+		DisplayName:    "app",
+		VolumeRefConfigList: []types.VolumeRefConfig{
+			{VolumeID: volumeUUID},
+			{VolumeID: blankUUID},
+		},
+	}
+	src.add("zedagent/AppInstanceConfig", config.Key(), config)
+	status := types.AppInstanceStatus{
+		UUIDandVersion: config.UUIDandVersion,
+		DisplayName:    "app",
+		State:          types.DOWNLOADING,
+	}
+	src.add("zedmanager/AppInstanceStatus", status.Key(), status)
+	volume := types.VolumeStatus{VolumeID: volumeUUID, ContentID: contentUUID,
+		State: types.DOWNLOADING}
+	src.add("volumemgr/VolumeStatus", volume.Key(), volume)
+	blank := types.VolumeStatus{VolumeID: blankUUID, State: types.CREATED_VOLUME}
+	src.add("volumemgr/VolumeStatus", blank.Key(), blank)
+	tree := types.ContentTreeStatus{ContentID: contentUUID,
+		Blobs: []string{indexSha, manifestSha}, State: types.DOWNLOADING}
+	src.add("volumemgr/ContentTreeStatus", tree.Key(), tree)
+	index := types.BlobStatus{Sha256: indexSha, State: types.VERIFIED}
+	src.add("volumemgr/BlobStatus", index.Key(), index)
+	manifest := types.BlobStatus{Sha256: manifestSha, State: types.DOWNLOADING,
+		HasDownloaderRef: true, HasVerifierRef: true}
+	src.add("volumemgr/BlobStatus", manifest.Key(), manifest)
+	download := types.DownloaderStatus{ImageSha256: manifestSha,
+		State: types.DOWNLOADING}
+	download.SetErrorNow("connection refused")
+	src.add("downloader/DownloaderStatus", download.Key(), download)
+	return src
+}
+
+func TestBlocker(t *testing.T) {
+	src := newSource()
+	g := objdeps.New(src)
+	app, err := g.App(appUUID.String())
+	assert.NoError(t, err)
+	assert.Equal(t, "zedmanager/AppInstanceStatus/"+appUUID.String(), app.String())
+	// Two volumes; no DomainStatus since not activated
+	assert.Len(t, app.Deps, 2)
+	assert.True(t, app.Deps[0].Blocking)
+	assert.False(t, app.Deps[1].Blocking)
+
+	blocker := objdeps.Blocker(app)
+	if assert.NotNil(t, blocker) {
+		assert.Equal(t, "downloader/DownloaderStatus/"+manifestSha, blocker.String())
+		assert.Equal(t, "connection refused", blocker.Error.Error)
+		assert.Equal(t, &types.ErrorEntity{EntityType: types.ErrorEntityContentBlob,
+			EntityID: manifestSha}, blocker.Entity())
+	}
+
+	// Without error the deepest blocking object is the blob
+	download := src["downloader/DownloaderStatus"][manifestSha].(types.DownloaderStatus)
+	download.ClearError()
+	src.add("downloader/DownloaderStatus", manifestSha, download)
+	blocker = objdeps.Blocker(objdeps.New(src).Object(app.Ref))
+	if assert.NotNil(t, blocker) {
+		assert.Equal(t, "downloader/DownloaderStatus/"+manifestSha, blocker.String())
+		assert.False(t, blocker.HasError())
+	}
+
+	// A volume zedmanager did not get yet
+	delete(src["volumemgr/VolumeStatus"], blankUUID.String()+"#0")
+	blocker = objdeps.Blocker(objdeps.New(src).Object(app.Ref))
+	if assert.NotNil(t, blocker) {
+		assert.Equal(t, "downloader/DownloaderStatus/"+manifestSha, blocker.String())
+	}
+	delete(src["volumemgr/VolumeStatus"], volumeUUID.String()+"#0")
+	blocker = objdeps.Blocker(objdeps.New(src).Object(app.Ref))
+	if assert.NotNil(t, blocker) {
+		assert.True(t, blocker.Missing)
+		assert.Equal(t, "volumemgr/VolumeStatus/"+volumeUUID.String()+"#0: not published",
+			objdeps.Describe(blocker))
+	}
+
+	_, err = objdeps.New(src).App(volumeUUID.String())
+	assert.Error(t, err)
+}
+
+// countingSource counts the lookups of each publication
+type countingSource struct {
+	source
+	lookups map[string]int
+}
+
+func (s countingSource) Items(name string) map[string]interface{} {
+	s.lookups[name]++
+	return s.source.Items(name)
+}
+
+func TestGraphOfSeveralApps(t *testing.T) {
+	src := countingSource{source: newSource(), lookups: make(map[string]int)}
+	otherUUID := uuid.FromStringOrNil("6ba7b815-9dad-11d1-80b4-00c04fd430c8")
+	other := types.AppInstanceConfig{
+		UUIDandVersion:      types.UUIDandVersion{UUID: otherUUID},
+		DisplayName:         "other",
+		VolumeRefConfigList: []types.VolumeRefConfig{{VolumeID: volumeUUID}},
+	}
+	src.add("zedagent/AppInstanceConfig", other.Key(), other)
+
+	graph := objdeps.New(src)
+	for _, app := range []uuid.UUID{appUUID, otherUUID} {
+		obj, err := graph.App(app.String())
+		assert.NoError(t, err)
+		blocker := objdeps.Blocker(obj)
+		if assert.NotNil(t, blocker) {
+			assert.Equal(t, "downloader/DownloaderStatus/"+manifestSha,
+				blocker.String())
+		}
+	}
+	// Each publication is looked up once for both
+	for name, count := range src.lookups {
+		assert.Equal(t, 1, count, name)
+	}
+}
+
+func TestNothingBlocks(t *testing.T) {
+	src := newSource()
+	for _, name := range []string{"volumemgr/VolumeStatus",
+		"volumemgr/ContentTreeStatus", "volumemgr/BlobStatus"} {
+		for key, item := range src[name] {
+			switch item := item.(type) {
+			case types.VolumeStatus:
+				item.State = types.CREATED_VOLUME
+				src.add(name, key, item)
+			case types.ContentTreeStatus:
+				item.State = types.LOADED
+				src.add(name, key, item)
+			case types.BlobStatus:
+				item.State = types.VERIFIED
+				src.add(name, key, item)
+			}
+		}
+	}
+	app, err := objdeps.New(src).App(appUUID.String())
+	assert.NoError(t, err)
+	assert.Nil(t, objdeps.Blocker(app))
+
+	// The error of a running domain is reported
+	domain := types.DomainStatus{UUIDandVersion: types.UUIDandVersion{UUID: appUUID},
+		State: types.RUNNING}
+	domain.SetError("qemu crashed", time.Now())
+	src.add("domainmgr/DomainStatus", domain.Key(), domain)
+	app, err = objdeps.New(src).App(appUUID.String())
+	assert.NoError(t, err)
+	blocker := objdeps.Blocker(app)
+	if assert.NotNil(t, blocker) {
+		assert.Equal(t, "domainmgr/DomainStatus/"+appUUID.String(), blocker.String())
+	}
+}
+
+func TestHandler(t *testing.T) {
+	handler := objdeps.Handler{Source: newSource()}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/apps", nil))
+	var blockers []objdeps.AppBlocker
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &blockers))
+	if assert.Len(t, blockers, 1) {
+		assert.Equal(t, appUUID.String(), blockers[0].AppUUID)
+		assert.Equal(t, "app", blockers[0].AppName)
+		assert.Equal(t, "downloader/DownloaderStatus/"+manifestSha,
+			blockers[0].Blocker.String())
+		assert.Contains(t, blockers[0].Description, "connection refused")
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET",
+		"/apps?uuid="+appUUID.String(), nil))
+	var graph objdeps.AppGraph
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &graph))
+	assert.Len(t, graph.Graph.Deps, 2)
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/apps?uuid=foo", nil))
+	assert.Equal(t, 404, rec.Code)
+}
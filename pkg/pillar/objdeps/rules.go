@@ -0,0 +1,154 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package objdeps
+
+import (
+	"github.com/lf-edge/eve/pkg/pillar/types"
+	uuid "github.com/satori/go.uuid"
+)
+
+// rule describes the objects of a publication
+type rule struct {
+	// done is the state from which an object no longer blocks the objects
+	// depending on it. Zero if only an error makes it block.
+	done     types.SwState
+	describe func(g *Graph, item interface{}) description
+}
+
+type description struct {
+	state types.SwState
+	err   types.ErrorDescription
+	deps  []dep
+}
+
+// dep is a reference to another object. An optional one is skipped if
+// the object is not published e.g., the DomainStatus of an app instance
+// which is not activated.
+type dep struct {
+	ref      Ref
+	optional bool
+}
+
+func volumeRef(key string) Ref {
+	return Ref{Agent: "volumemgr", Topic: "VolumeStatus", Key: key}
+}
+
+// rules for the publications of the objects an app instance depends on,
+// keyed by publication name.
+// The blobs of a content tree include those found when parsing its
+// indexes and manifests, hence the BlobStatus do not need to refer to
+// their children.
+var rules = map[string]rule{
+	"zedagent/AppInstanceConfig": {
+		describe: func(g *Graph, item interface{}) description {
+			config := item.(types.AppInstanceConfig)
+			var deps []dep
+			for _, vrc := range config.VolumeRefConfigList {
+				deps = append(deps, dep{ref: volumeRef(vrc.Key())})
+			}
+			return description{deps: deps}
+		},
+	},
+	"zedmanager/AppInstanceStatus": {
+		describe: func(g *Graph, item interface{}) description {
+			status := item.(types.AppInstanceStatus)
+			var deps []dep
+			// The config has the volumes zedmanager is still to handle
+			config, ok := g.lookup(Ref{Agent: "zedagent",
+				Topic: "AppInstanceConfig", Key: status.Key()}).(types.AppInstanceConfig)
+			if ok {
+				for _, vrc := range config.VolumeRefConfigList {
+					deps = append(deps, dep{ref: volumeRef(vrc.Key())})
+				}
+			} else {
+				for _, vrs := range status.VolumeRefStatusList {
+					deps = append(deps, dep{ref: volumeRef(vrs.Key())})
+				}
+			}
+			deps = append(deps, dep{
+				ref: Ref{Agent: "domainmgr", Topic: "DomainStatus",
+					Key: status.Key()},
+				optional: true,
+			})
+			return description{
+				state: status.State,
+				err:   status.ErrorDescription,
+				deps:  deps,
+			}
+		},
+	},
+	"domainmgr/DomainStatus": {
+		describe: func(g *Graph, item interface{}) description {
+			status := item.(types.DomainStatus)
+			return description{state: status.State, err: status.ErrorDescription}
+		},
+	},
+	"volumemgr/VolumeStatus": {
+		done: types.CREATED_VOLUME,
+		describe: func(g *Graph, item interface{}) description {
+			status := item.(types.VolumeStatus)
+			var deps []dep
+			// Blank volumes have no content tree
+			if status.ContentID != uuid.Nil {
+				deps = append(deps, dep{ref: Ref{Agent: "volumemgr",
+					Topic: "ContentTreeStatus", Key: status.ContentID.String()}})
+			}
+			return description{
+				state: status.State,
+				err:   status.ErrorDescription,
+				deps:  deps,
+			}
+		},
+	},
+	"volumemgr/ContentTreeStatus": {
+		done: types.LOADED,
+		describe: func(g *Graph, item interface{}) description {
+			status := item.(types.ContentTreeStatus)
+			var deps []dep
+			for _, sha := range status.Blobs {
+				deps = append(deps, dep{ref: Ref{Agent: "volumemgr",
+					Topic: "BlobStatus", Key: sha}})
+			}
+			return description{
+				state: status.State,
+				err:   status.ErrorDescription,
+				deps:  deps,
+			}
+		},
+	},
+	"volumemgr/BlobStatus": {
+		done: types.VERIFIED,
+		describe: func(g *Graph, item interface{}) description {
+			status := item.(types.BlobStatus)
+			var deps []dep
+			if status.HasDownloaderRef {
+				deps = append(deps, dep{ref: Ref{Agent: "downloader",
+					Topic: "DownloaderStatus", Key: status.Sha256}, optional: true})
+			}
+			if status.HasVerifierRef {
+				deps = append(deps, dep{ref: Ref{Agent: "verifier",
+					Topic: "VerifyImageStatus", Key: status.Sha256}, optional: true})
+			}
+			return description{
+				state: status.State,
+				err:   status.ErrorDescription,
+				deps:  deps,
+			}
+		},
+	},
+	"downloader/DownloaderStatus": {
+		done: types.DOWNLOADED,
+		describe: func(g *Graph, item interface{}) description {
+			status := item.(types.DownloaderStatus)
+			return description{state: status.State, err: status.ErrorDescription}
+		},
+	},
+	"verifier/VerifyImageStatus": {
+		done: types.VERIFIED,
+		describe: func(g *Graph, item interface{}) description {
+			status := item.(types.VerifyImageStatus)
+			return description{state: status.State, err: status.ErrorDescription}
+		},
+	},
+}
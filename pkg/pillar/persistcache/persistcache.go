@@ -6,12 +6,15 @@ package persistcache
 import (
 	"bytes"
 	"crypto/sha256"
+	"fmt"
 	"io/fs"
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	fileutils "github.com/lf-edge/eve/pkg/pillar/utils/file"
 )
@@ -20,17 +23,90 @@ import (
 // in-memory and on file system
 type PersistCache struct {
 	sync.Mutex
-	cache map[string]objectWrapper
-	root  string
+	cache   map[string]objectWrapper
+	root    string
+	options Options
+	// used is the sum of the sizes of the objects
+	used        int64
+	subscribers map[int]chan Change
+	nextSubID   int
+	// pending is the transaction to complete before the next write
+	pending *pendingCommit
 }
 
 type objectWrapper struct {
 	Val []byte
 	Sha []byte
+	// Size of Val, known even before Val is loaded
+	Size int64
+	// Modified is when the object was last written
+	Modified time.Time
+	// Used is when the object was last written or read
+	Used time.Time
+}
+
+// EvictionPolicy tells what to do when a write would exceed Options.Quota
+type EvictionPolicy int
+
+const (
+	// EvictNone fails the write with a QuotaExceededError
+	EvictNone EvictionPolicy = iota
+	// EvictLRU removes the least recently used objects to make room
+	EvictLRU
+)
+
+// Options of a PersistCache; the zero value means no limits
+type Options struct {
+	// Quota is the maximum total size in bytes of the values; 0 for no limit
+	Quota int64
+	// Eviction is what to do when a write would exceed Quota
+	Eviction EvictionPolicy
+	// TTL if set, objects which are not written for that long are removed
+	TTL time.Duration
+}
+
+// ChangeKind tells how an object changed
+type ChangeKind int
+
+const (
+	// ChangePut the object was created or updated
+	ChangePut ChangeKind = iota
+	// ChangeDelete the object was deleted
+	ChangeDelete
+	// ChangeEvicted the object was removed to stay within the quota
+	ChangeEvicted
+	// ChangeExpired the object was removed since its TTL passed
+	ChangeExpired
+)
+
+// String returns the name of the kind of change
+func (k ChangeKind) String() string {
+	switch k {
+	case ChangePut:
+		return "put"
+	case ChangeDelete:
+		return "delete"
+	case ChangeEvicted:
+		return "evicted"
+	case ChangeExpired:
+		return "expired"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(k))
+	}
+}
+
+// Change is sent to the subscribers when an object changes
+type Change struct {
+	Key  string
+	Kind ChangeKind
 }
 
 const fileMask = 0700
 
+// subscriberBuffer is the number of changes buffered for a subscriber;
+// further changes are dropped until it catches up
+const subscriberBuffer = 64
+
 // InvalidKeyError returned when Get or Put are
 // called with key which cannot be written or read
 type InvalidKeyError struct{}
@@ -49,11 +125,34 @@ func (e *InvalidValueError) Error() string {
 	return "Value is invalid"
 }
 
+// QuotaExceededError returned when a write would exceed
+// the quota and eviction could not make enough room
+type QuotaExceededError struct {
+	Quota int64
+	Size  int64
+}
+
+// Error returns error description string
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("Quota of %d bytes exceeded: %d bytes needed",
+		e.Quota, e.Size)
+}
+
 // New loads values from cache or creates path if there's none
 func New(path string) (*PersistCache, error) {
+	return NewWithOptions(path, Options{})
+}
+
+// NewWithOptions is like New with a quota and eviction policy.
+// Objects past their TTL are removed. Objects already over the quota
+// are evicted with EvictLRU; with EvictNone they are kept and the writes
+// fail until enough is deleted.
+func NewWithOptions(path string, options Options) (*PersistCache, error) {
 	pc := &PersistCache{}
 	pc.root = path
+	pc.options = options
 	pc.cache = make(map[string]objectWrapper)
+	pc.subscribers = make(map[int]chan Change)
 
 	if _, err := os.Stat(pc.root); os.IsNotExist(err) {
 		if err := os.MkdirAll(pc.root, fileMask); err != nil {
@@ -62,32 +161,64 @@ func New(path string) (*PersistCache, error) {
 		return pc, nil
 	}
 
+	// Complete a transaction interrupted by a power loss
+	if err := pc.replayJournal(); err != nil {
+		return nil, err
+	}
+
 	walkErr := filepath.WalkDir(pc.root, func(path string, di fs.DirEntry, err error) error {
+		// if there is any problem with path we stop
+		if err != nil {
+			return err
+		}
+
 		// We skip all directories
 		if di.IsDir() {
 			return nil
 		}
 
-		// if there is any problem with path we stop
-		if err != nil {
-			return err
+		if di.Name() == journalName {
+			return nil
 		}
 
 		sha, err := fileutils.ComputeShaFile(path)
 		if err != nil {
 			return err
 		}
+		info, err := di.Info()
+		if err != nil {
+			return err
+		}
 
 		// lazy initialization
 		pc.cache[di.Name()] = objectWrapper{
-			Val: []byte{},
-			Sha: sha,
+			Val:      []byte{},
+			Sha:      sha,
+			Size:     info.Size(),
+			Modified: info.ModTime(),
+			Used:     info.ModTime(),
 		}
+		pc.used += info.Size()
 
 		return nil
 	})
+	if walkErr != nil {
+		return pc, walkErr
+	}
+
+	pc.expire()
+	if pc.options.Quota != 0 && pc.options.Eviction == EvictLRU &&
+		pc.used > pc.options.Quota {
+		evict, err := pc.evictFor(pc.used-pc.options.Quota, nil)
+		if err != nil {
+			return pc, err
+		}
+		if err := pc.commit(nil, nil, evict); err != nil {
+			return pc, err
+		}
+	}
 
-	return pc, walkErr
+	return pc, nil
 }
 
 // Get value from cache
@@ -95,6 +226,8 @@ func (pc *PersistCache) Get(key string) ([]byte, error) {
 	pc.Lock()
 	defer pc.Unlock()
 
+	pc.expireKey(key)
+
 	if len(pc.cache[key].Val) == 0 {
 		if err := pc.loadObject(key); err != nil {
 			return []byte{}, err
@@ -102,6 +235,8 @@ func (pc *PersistCache) Get(key string) ([]byte, error) {
 	}
 
 	obj := pc.cache[key]
+	obj.Used = time.Now()
+	pc.cache[key] = obj
 
 	return obj.Val, nil
 }
@@ -117,6 +252,9 @@ func (pc *PersistCache) Put(key string, val []byte) (string, error) {
 	if !isValidValue(val) {
 		return "", &InvalidValueError{}
 	}
+	if err := pc.completePending(); err != nil {
+		return "", err
+	}
 
 	hash := sha256.New()
 	hash.Write(val)
@@ -138,12 +276,25 @@ func (pc *PersistCache) Delete(key string) error {
 	pc.Lock()
 	defer pc.Unlock()
 
+	if err := pc.completePending(); err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(pc.root, key)); err != nil {
+		return err
+	}
+	pc.used -= pc.cache[key].Size
 	delete(pc.cache, key)
-	return os.Remove(filepath.Join(pc.root, key))
+	pc.notify(key, ChangeDelete)
+	return nil
 }
 
 // Objects returns list objects stored in PersistCache
 func (pc *PersistCache) Objects() []string {
+	pc.Lock()
+	defer pc.Unlock()
+
+	pc.expire()
+
 	answer := make([]string, 0, len(pc.cache))
 
 	for key := range pc.cache {
@@ -153,6 +304,54 @@ func (pc *PersistCache) Objects() []string {
 	return answer
 }
 
+// Usage returns the total size in bytes of the stored values
+func (pc *PersistCache) Usage() int64 {
+	pc.Lock()
+	defer pc.Unlock()
+
+	return pc.used
+}
+
+// Expire removes the objects past their TTL. They are also removed
+// when accessed, hence calling Expire is only needed to reclaim space.
+func (pc *PersistCache) Expire() {
+	pc.Lock()
+	defer pc.Unlock()
+
+	pc.expire()
+}
+
+// Subscribe returns a channel receiving the changes of the objects, and a
+// function to call to stop receiving them. Changes are dropped if the
+// channel is full.
+func (pc *PersistCache) Subscribe() (<-chan Change, func()) {
+	pc.Lock()
+	defer pc.Unlock()
+
+	id := pc.nextSubID
+	pc.nextSubID++
+	ch := make(chan Change, subscriberBuffer)
+	pc.subscribers[id] = ch
+
+	return ch, func() {
+		pc.Lock()
+		defer pc.Unlock()
+		if _, ok := pc.subscribers[id]; ok {
+			delete(pc.subscribers, id)
+			close(ch)
+		}
+	}
+}
+
+func (pc *PersistCache) notify(key string, kind ChangeKind) {
+	for _, ch := range pc.subscribers {
+		select {
+		case ch <- Change{Key: key, Kind: kind}:
+		default:
+		}
+	}
+}
+
 func (pc *PersistCache) loadObject(objName string) error {
 	path := filepath.Join(pc.root, objName)
 
@@ -166,10 +365,15 @@ func (pc *PersistCache) loadObject(objName string) error {
 		return err
 	}
 
-	pc.cache[filepath.Base(path)] = objectWrapper{
-		Val: val,
-		Sha: sha,
+	obj := pc.cache[filepath.Base(path)]
+	pc.used += int64(len(val)) - obj.Size
+	obj.Val = val
+	obj.Sha = sha
+	obj.Size = int64(len(val))
+	if obj.Modified.IsZero() {
+		obj.Modified = time.Now()
 	}
+	pc.cache[filepath.Base(path)] = obj
 
 	return nil
 }
@@ -183,21 +387,103 @@ func (pc *PersistCache) update(key string, obj objectWrapper) (string, error) {
 }
 
 func (pc *PersistCache) create(key string, obj objectWrapper) (string, error) {
-	filepath := filepath.Join(pc.root, key)
-
-	if err := fileutils.WriteRename(filepath, obj.Val); err != nil {
+	puts := map[string][]byte{key: obj.Val}
+	evict, err := pc.makeRoom(puts, nil)
+	if err != nil {
+		return "", err
+	}
+	if err := pc.commit(puts, nil, evict); err != nil {
 		return "", err
 	}
 
-	pc.cache[key] = obj
+	return filepath.Join(pc.root, key), nil
+}
+
+// makeRoom returns the keys to evict so that puts and deletes do not
+// exceed the quota
+func (pc *PersistCache) makeRoom(puts map[string][]byte, deletes map[string]struct{}) ([]string, error) {
+	if pc.options.Quota == 0 {
+		return nil, nil
+	}
+	pc.expire()
+	used := pc.used
+	for key, val := range puts {
+		used += int64(len(val)) - pc.cache[key].Size
+	}
+	for key := range deletes {
+		used -= pc.cache[key].Size
+	}
+	if used <= pc.options.Quota {
+		return nil, nil
+	}
+	if pc.options.Eviction != EvictLRU {
+		return nil, &QuotaExceededError{Quota: pc.options.Quota, Size: used}
+	}
+	protected := make(map[string]struct{}, len(puts)+len(deletes))
+	for key := range puts {
+		protected[key] = struct{}{}
+	}
+	for key := range deletes {
+		protected[key] = struct{}{}
+	}
+	return pc.evictFor(used-pc.options.Quota, protected)
+}
+
+// evictFor returns the least recently used keys not in protected
+// whose sizes add up to at least need
+func (pc *PersistCache) evictFor(need int64, protected map[string]struct{}) ([]string, error) {
+	var candidates []string
+	for key := range pc.cache {
+		if _, ok := protected[key]; !ok {
+			candidates = append(candidates, key)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return pc.cache[candidates[i]].Used.Before(pc.cache[candidates[j]].Used)
+	})
+	var evict []string
+	var freed int64
+	for _, key := range candidates {
+		if freed >= need {
+			break
+		}
+		evict = append(evict, key)
+		freed += pc.cache[key].Size
+	}
+	if freed < need {
+		return nil, &QuotaExceededError{Quota: pc.options.Quota,
+			Size: pc.options.Quota + need - freed}
+	}
+	return evict, nil
+}
 
-	return filepath, nil
+// expire removes the objects past their TTL
+func (pc *PersistCache) expire() {
+	if pc.options.TTL == 0 {
+		return
+	}
+	for key := range pc.cache {
+		pc.expireKey(key)
+	}
+}
+
+// expireKey removes the object if past its TTL
+func (pc *PersistCache) expireKey(key string) {
+	obj, ok := pc.cache[key]
+	if !ok || pc.options.TTL == 0 || time.Since(obj.Modified) < pc.options.TTL {
+		return
+	}
+	pc.used -= obj.Size
+	delete(pc.cache, key)
+	// Nothing better to do than retry at the next restart
+	_ = os.Remove(filepath.Join(pc.root, key))
+	pc.notify(key, ChangeExpired)
 }
 
 func isValidKey(key string) bool {
 	key = path.Clean(key)
 
-	return !strings.Contains(key, "/")
+	return !strings.Contains(key, "/") && key != journalName
 }
 
 func isValidValue(val []byte) bool {
@@ -7,6 +7,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/lf-edge/eve/pkg/pillar/persistcache"
 	"github.com/onsi/gomega"
@@ -100,6 +101,14 @@ func TestDelete(test *testing.T) {
 	val, err = pc.Get(obj1.Name)
 	g.Expect(err).NotTo(gomega.BeNil())
 	g.Expect(val).To(gomega.BeEquivalentTo(""))
+
+	// An object whose file cannot be removed is kept
+	g.Expect(os.Remove(filepath.Join(persistCacheFolder, obj2.Name))).To(gomega.BeNil())
+	g.Expect(os.MkdirAll(filepath.Join(persistCacheFolder, obj2.Name, "dir"), 0700)).To(gomega.BeNil())
+	err = pc.Delete(obj2.Name)
+	g.Expect(err).NotTo(gomega.BeNil())
+	g.Expect(pc.Objects()).To(gomega.ConsistOf(obj2.Name))
+	g.Expect(pc.Usage()).To(gomega.BeEquivalentTo(len(obj2.Val)))
 }
 
 func TestLoad(test *testing.T) {
@@ -150,3 +159,193 @@ func TestLoad(test *testing.T) {
 	g.Expect(err).To(gomega.BeNil())
 	g.Expect(val).To(gomega.BeEquivalentTo(obj2.Val))
 }
+
+func TestTxn(test *testing.T) {
+	test.Parallel()
+
+	g := gomega.NewGomegaWithT(test)
+
+	path, err := os.MkdirTemp("", "testFolder")
+	g.Expect(err).To(gomega.BeNil())
+
+	persistCacheFolder := filepath.Join(path, "testPersist/")
+	defer os.RemoveAll(path)
+
+	pc, err := persistcache.New(persistCacheFolder)
+	g.Expect(err).To(gomega.BeNil())
+	changes, unsubscribe := pc.Subscribe()
+	defer unsubscribe()
+
+	_, err = pc.Put("object1", []byte("123"))
+	g.Expect(err).To(gomega.BeNil())
+	g.Expect(<-changes).To(gomega.Equal(persistcache.Change{Key: "object1", Kind: persistcache.ChangePut}))
+
+	txn := pc.Begin()
+	_, err = txn.Put("object2", []byte("456"))
+	g.Expect(err).To(gomega.BeNil())
+	_, err = txn.Put("object3", []byte("789"))
+	g.Expect(err).To(gomega.BeNil())
+	g.Expect(txn.Delete("object1")).To(gomega.BeNil())
+	_, err = txn.Put("../object4", []byte("789"))
+	g.Expect(err).To(gomega.BeEquivalentTo(&persistcache.InvalidKeyError{}))
+
+	// Nothing visible before the commit
+	g.Expect(pc.Objects()).To(gomega.ConsistOf("object1"))
+	g.Expect(txn.Commit()).To(gomega.BeNil())
+	g.Expect(pc.Objects()).To(gomega.ConsistOf("object2", "object3"))
+	g.Expect(pc.Usage()).To(gomega.BeEquivalentTo(6))
+	g.Expect([]persistcache.Change{<-changes, <-changes, <-changes}).To(gomega.ConsistOf(
+		persistcache.Change{Key: "object1", Kind: persistcache.ChangeDelete},
+		persistcache.Change{Key: "object2", Kind: persistcache.ChangePut},
+		persistcache.Change{Key: "object3", Kind: persistcache.ChangePut}))
+
+	// A transaction interrupted after being recorded is completed on load
+	err = os.WriteFile(filepath.Join(persistCacheFolder, ".journal"),
+		[]byte(`{"Puts":{"object4":"YWJj"},"Deletes":["object2"]}`), 0600)
+	g.Expect(err).To(gomega.BeNil())
+	pc2, err := persistcache.New(persistCacheFolder)
+	g.Expect(err).To(gomega.BeNil())
+	g.Expect(pc2.Objects()).To(gomega.ConsistOf("object3", "object4"))
+	val, err := pc2.Get("object4")
+	g.Expect(err).To(gomega.BeNil())
+	g.Expect(val).To(gomega.BeEquivalentTo("abc"))
+}
+
+func TestTxnPending(test *testing.T) {
+	test.Parallel()
+
+	g := gomega.NewGomegaWithT(test)
+
+	path, err := os.MkdirTemp("", "testFolder")
+	g.Expect(err).To(gomega.BeNil())
+
+	persistCacheFolder := filepath.Join(path, "testPersist/")
+	defer os.RemoveAll(path)
+
+	pc, err := persistcache.New(persistCacheFolder)
+	g.Expect(err).To(gomega.BeNil())
+
+	// A directory in the way of object2 fails the commit past its journal
+	blocker := filepath.Join(persistCacheFolder, "object2")
+	g.Expect(os.MkdirAll(filepath.Join(blocker, "x"), 0700)).To(gomega.Succeed())
+	txn := pc.Begin()
+	_, err = txn.Put("object1", []byte("123"))
+	g.Expect(err).To(gomega.BeNil())
+	_, err = txn.Put("object2", []byte("456"))
+	g.Expect(err).To(gomega.BeNil())
+	g.Expect(txn.Commit()).ToNot(gomega.Succeed())
+	g.Expect(filepath.Join(persistCacheFolder, ".journal")).To(gomega.BeARegularFile())
+
+	// The next writes fail rather than replace the journal
+	txn = pc.Begin()
+	_, err = txn.Put("object3", []byte("789"))
+	g.Expect(err).To(gomega.BeNil())
+	_, err = txn.Put("object4", []byte("abc"))
+	g.Expect(err).To(gomega.BeNil())
+	g.Expect(txn.Commit()).ToNot(gomega.Succeed())
+	_, err = pc.Put("object5", []byte("def"))
+	g.Expect(err).ToNot(gomega.BeNil())
+	journal, err := os.ReadFile(filepath.Join(persistCacheFolder, ".journal"))
+	g.Expect(err).To(gomega.BeNil())
+	g.Expect(string(journal)).To(gomega.ContainSubstring("object2"))
+
+	// Once possible the pending transaction is completed first
+	g.Expect(os.RemoveAll(blocker)).To(gomega.Succeed())
+	g.Expect(txn.Commit()).To(gomega.Succeed())
+	g.Expect(pc.Objects()).To(gomega.ConsistOf("object1", "object2", "object3", "object4"))
+	g.Expect(pc.Usage()).To(gomega.BeEquivalentTo(12))
+	g.Expect(filepath.Join(persistCacheFolder, ".journal")).ToNot(gomega.BeAnExistingFile())
+	val, err := pc.Get("object2")
+	g.Expect(err).To(gomega.BeNil())
+	g.Expect(val).To(gomega.BeEquivalentTo("456"))
+}
+
+func TestQuota(test *testing.T) {
+	test.Parallel()
+
+	g := gomega.NewGomegaWithT(test)
+
+	path, err := os.MkdirTemp("", "testFolder")
+	g.Expect(err).To(gomega.BeNil())
+
+	persistCacheFolder := filepath.Join(path, "testPersist/")
+	defer os.RemoveAll(path)
+
+	pc, err := persistcache.NewWithOptions(persistCacheFolder,
+		persistcache.Options{Quota: 10})
+	g.Expect(err).To(gomega.BeNil())
+
+	_, err = pc.Put("object1", []byte("12345"))
+	g.Expect(err).To(gomega.BeNil())
+	_, err = pc.Put("object2", []byte("12345"))
+	g.Expect(err).To(gomega.BeNil())
+	_, err = pc.Put("object3", []byte("1"))
+	g.Expect(err).To(gomega.BeAssignableToTypeOf(&persistcache.QuotaExceededError{}))
+	// Replacing a value by one of the same size fits
+	_, err = pc.Put("object2", []byte("54321"))
+	g.Expect(err).To(gomega.BeNil())
+
+	// With LRU eviction the least recently used objects make room
+	pc, err = persistcache.NewWithOptions(persistCacheFolder,
+		persistcache.Options{Quota: 10, Eviction: persistcache.EvictLRU})
+	g.Expect(err).To(gomega.BeNil())
+	changes, unsubscribe := pc.Subscribe()
+	defer unsubscribe()
+	_, err = pc.Get("object1")
+	g.Expect(err).To(gomega.BeNil())
+	_, err = pc.Put("object3", []byte("1"))
+	g.Expect(err).To(gomega.BeNil())
+	g.Expect(pc.Objects()).To(gomega.ConsistOf("object1", "object3"))
+	g.Expect(pc.Usage()).To(gomega.BeEquivalentTo(6))
+	g.Expect([]persistcache.Change{<-changes, <-changes}).To(gomega.ConsistOf(
+		persistcache.Change{Key: "object2", Kind: persistcache.ChangeEvicted},
+		persistcache.Change{Key: "object3", Kind: persistcache.ChangePut}))
+
+	// Never fits
+	_, err = pc.Put("object4", []byte("12345678901"))
+	g.Expect(err).To(gomega.BeAssignableToTypeOf(&persistcache.QuotaExceededError{}))
+	g.Expect(pc.Objects()).To(gomega.ConsistOf("object1", "object3"))
+
+	// Without eviction the objects over a lowered quota are kept on load
+	pc, err = persistcache.NewWithOptions(persistCacheFolder,
+		persistcache.Options{Quota: 1})
+	g.Expect(err).To(gomega.BeNil())
+	g.Expect(pc.Objects()).To(gomega.ConsistOf("object1", "object3"))
+	_, err = pc.Put("object4", []byte("1"))
+	g.Expect(err).To(gomega.BeAssignableToTypeOf(&persistcache.QuotaExceededError{}))
+
+	// With LRU eviction they are evicted on load
+	pc, err = persistcache.NewWithOptions(persistCacheFolder,
+		persistcache.Options{Quota: 1, Eviction: persistcache.EvictLRU})
+	g.Expect(err).To(gomega.BeNil())
+	g.Expect(pc.Usage()).To(gomega.BeNumerically("<=", 1))
+}
+
+func TestTTL(test *testing.T) {
+	test.Parallel()
+
+	g := gomega.NewGomegaWithT(test)
+
+	path, err := os.MkdirTemp("", "testFolder")
+	g.Expect(err).To(gomega.BeNil())
+
+	persistCacheFolder := filepath.Join(path, "testPersist/")
+	defer os.RemoveAll(path)
+
+	const ttl = 100 * time.Millisecond
+	pc, err := persistcache.NewWithOptions(persistCacheFolder,
+		persistcache.Options{TTL: ttl})
+	g.Expect(err).To(gomega.BeNil())
+	changes, unsubscribe := pc.Subscribe()
+	defer unsubscribe()
+
+	_, err = pc.Put("object1", []byte("123"))
+	g.Expect(err).To(gomega.BeNil())
+	g.Expect(<-changes).To(gomega.Equal(persistcache.Change{Key: "object1", Kind: persistcache.ChangePut}))
+	time.Sleep(2 * ttl)
+	_, err = pc.Get("object1")
+	g.Expect(err).NotTo(gomega.BeNil())
+	g.Expect(<-changes).To(gomega.Equal(persistcache.Change{Key: "object1", Kind: persistcache.ChangeExpired}))
+	_, err = os.Stat(filepath.Join(persistCacheFolder, "object1"))
+	g.Expect(os.IsNotExist(err)).To(gomega.BeTrue())
+}
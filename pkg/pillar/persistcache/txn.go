@@ -0,0 +1,220 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package persistcache
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	fileutils "github.com/lf-edge/eve/pkg/pillar/utils/file"
+)
+
+// journalName is the file recording a transaction being committed.
+// It is not a valid key.
+const journalName = ".journal"
+
+// journal is what is recorded in journalName
+type journal struct {
+	Puts    map[string][]byte
+	Deletes []string
+}
+
+// Txn is a set of puts and deletes committed atomically: after a power
+// loss either all or none of them are visible
+type Txn struct {
+	pc      *PersistCache
+	puts    map[string][]byte
+	deletes map[string]struct{}
+}
+
+// Begin starts a transaction
+func (pc *PersistCache) Begin() *Txn {
+	return &Txn{
+		pc:      pc,
+		puts:    make(map[string][]byte),
+		deletes: make(map[string]struct{}),
+	}
+}
+
+// Put adds a create or update of key to the transaction; returns
+// the path where the value will be stored once committed
+func (txn *Txn) Put(key string, val []byte) (string, error) {
+	if !isValidKey(key) {
+		return "", &InvalidKeyError{}
+	}
+	if !isValidValue(val) {
+		return "", &InvalidValueError{}
+	}
+	delete(txn.deletes, key)
+	txn.puts[key] = val
+	return filepath.Join(txn.pc.root, key), nil
+}
+
+// Delete adds a delete of key to the transaction. Unlike
+// PersistCache.Delete it is not an error if there is no such key.
+func (txn *Txn) Delete(key string) error {
+	if !isValidKey(key) {
+		return &InvalidKeyError{}
+	}
+	delete(txn.puts, key)
+	txn.deletes[key] = struct{}{}
+	return nil
+}
+
+// Commit applies the transaction. If it fails nothing is changed,
+// unless the failure is past the point where the transaction is
+// recorded, in which case it is completed before the next write, which
+// fails as long as it cannot be, or at the next New.
+func (txn *Txn) Commit() error {
+	pc := txn.pc
+	pc.Lock()
+	defer pc.Unlock()
+
+	if err := pc.completePending(); err != nil {
+		return err
+	}
+	evict, err := pc.makeRoom(txn.puts, txn.deletes)
+	if err != nil {
+		return err
+	}
+	deletes := make([]string, 0, len(txn.deletes))
+	for key := range txn.deletes {
+		deletes = append(deletes, key)
+	}
+	return pc.commit(txn.puts, deletes, evict)
+}
+
+// pendingCommit is a commit which failed after its journal was written
+type pendingCommit struct {
+	puts    map[string][]byte
+	deletes []string
+	evict   []string
+}
+
+// commit writes puts and removes deletes and evict, using the journal
+// unless it is a single operation which is atomic anyway
+func (pc *PersistCache) commit(puts map[string][]byte, deletes []string, evict []string) error {
+	if len(puts)+len(deletes)+len(evict) == 0 {
+		return nil
+	}
+	j := journal{Puts: puts, Deletes: append(append([]string{}, deletes...), evict...)}
+	if len(puts)+len(j.Deletes) == 1 {
+		if err := pc.apply(j); err != nil {
+			return err
+		}
+		pc.committed(puts, deletes, evict)
+		return nil
+	}
+	b, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+	if err := fileutils.WriteRename(filepath.Join(pc.root, journalName), b); err != nil {
+		return err
+	}
+	// The transaction is recorded; it has to be completed before anything
+	// else is written, or the journal of the next one would replace it
+	pc.pending = &pendingCommit{puts: puts, deletes: deletes, evict: evict}
+	return pc.completePending()
+}
+
+// completePending completes the commit whose journal is written, if any
+func (pc *PersistCache) completePending() error {
+	p := pc.pending
+	if p == nil {
+		return nil
+	}
+	j := journal{Puts: p.puts, Deletes: append(append([]string{}, p.deletes...), p.evict...)}
+	if err := pc.apply(j); err != nil {
+		return fmt.Errorf("completing the pending transaction: %w", err)
+	}
+	if err := os.Remove(filepath.Join(pc.root, journalName)); err != nil {
+		return fmt.Errorf("completing the pending transaction: %w", err)
+	}
+	if err := fileutils.DirSync(pc.root); err != nil {
+		return fmt.Errorf("completing the pending transaction: %w", err)
+	}
+	pc.pending = nil
+	pc.committed(p.puts, p.deletes, p.evict)
+	return nil
+}
+
+// committed updates the cache once puts, deletes and evict are written
+func (pc *PersistCache) committed(puts map[string][]byte, deletes []string, evict []string) {
+	now := time.Now()
+	for key, val := range puts {
+		hash := sha256.Sum256(val)
+		pc.used += int64(len(val)) - pc.cache[key].Size
+		pc.cache[key] = objectWrapper{
+			Val:      val,
+			Sha:      hash[:],
+			Size:     int64(len(val)),
+			Modified: now,
+			Used:     now,
+		}
+		pc.notify(key, ChangePut)
+	}
+	for _, key := range deletes {
+		if _, ok := pc.cache[key]; !ok {
+			continue
+		}
+		pc.used -= pc.cache[key].Size
+		delete(pc.cache, key)
+		pc.notify(key, ChangeDelete)
+	}
+	for _, key := range evict {
+		pc.used -= pc.cache[key].Size
+		delete(pc.cache, key)
+		pc.notify(key, ChangeEvicted)
+	}
+}
+
+// apply writes the files of the journal; it can be repeated
+func (pc *PersistCache) apply(j journal) error {
+	for key, val := range j.Puts {
+		if err := fileutils.WriteRename(filepath.Join(pc.root, key), val); err != nil {
+			return err
+		}
+	}
+	for _, key := range j.Deletes {
+		err := os.Remove(filepath.Join(pc.root, key))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// replayJournal completes the transaction recorded in the journal if any
+func (pc *PersistCache) replayJournal() error {
+	journalPath := filepath.Join(pc.root, journalName)
+	b, err := os.ReadFile(journalPath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var j journal
+	if err := json.Unmarshal(b, &j); err != nil {
+		return fmt.Errorf("replayJournal(%s): %w", journalPath, err)
+	}
+	for key := range j.Puts {
+		if !isValidKey(key) {
+			return fmt.Errorf("replayJournal(%s): invalid key %s", journalPath, key)
+		}
+	}
+	for _, key := range j.Deletes {
+		if !isValidKey(key) {
+			return fmt.Errorf("replayJournal(%s): invalid key %s", journalPath, key)
+		}
+	}
+	if err := pc.apply(j); err != nil {
+		return err
+	}
+	return os.Remove(journalPath)
+}
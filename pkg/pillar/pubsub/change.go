@@ -1,5 +1,7 @@
 package pubsub
 
+import "fmt"
+
 // Operation type for a single change operation
 type Operation byte
 
@@ -14,6 +16,22 @@ const (
 	Modify
 )
 
+// String returns the name of the operation
+func (op Operation) String() string {
+	switch op {
+	case Restart:
+		return "restart"
+	case Sync:
+		return "sync"
+	case Delete:
+		return "delete"
+	case Modify:
+		return "modify"
+	default:
+		return fmt.Sprintf("operation(%d)", op)
+	}
+}
+
 // Change the message to go into a change channel
 type Change struct {
 	// Operation which operation is performed by this change
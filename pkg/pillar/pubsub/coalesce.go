@@ -0,0 +1,163 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package pubsub
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// coalescer rate-limits the publication of the changes of each key of
+// a publication created with a CoalesceWindow. The first change of a key
+// in a window is published right away; the later ones are kept in pending
+// and only stored in the local collection, and handed to the driver and
+// the subscribers, at the end of the window. Hence only the latest of them
+// is published. Get and GetAll of the publisher return the pending change
+// if any.
+//
+// The lock is held while publishing to serialize the publications done
+// by the agent with the ones done when a window ends. Those run on the
+// goroutine of the timer of the window, not on the one of the agent. They
+// only store the item, notify the updaters, which never blocks, count the
+// change in the registry, which has its own lock, and call Publish of the
+// driver, which the drivers allow from any goroutine as long as the calls
+// for a publication are serialized. TestCoalesceConcurrent checks this with
+// the race detector.
+type coalescer struct {
+	sync.Mutex
+	window  time.Duration
+	keys    map[string]*coalescedKey
+	pending map[string]interface{}
+	dropped uint64
+}
+
+type coalescedKey struct {
+	lastPublished time.Time
+	// timer is set if a change waits for the end of the window
+	timer *time.Timer
+	// generation tells a stale timer from the current one
+	generation uint64
+}
+
+func newCoalescer(window time.Duration) *coalescer {
+	return &coalescer{
+		window:  window,
+		keys:    make(map[string]*coalescedKey),
+		pending: make(map[string]interface{}),
+	}
+}
+
+// postpone returns true if the change of key to item has to wait for the
+// end of the window of key, in which case it is kept in pending.
+// The lock must be held.
+func (c *coalescer) postpone(pub *PublicationImpl, key string, item interface{}) bool {
+	ck, ok := c.keys[key]
+	if !ok {
+		ck = &coalescedKey{}
+		c.keys[key] = ck
+	}
+	if ck.timer != nil {
+		newItem := deepCopy(pub.log, item)
+		if m, ok := c.pending[key]; ok {
+			if cmp.Equal(m, newItem) {
+				return true
+			}
+			// Replaces the change which was waiting
+			c.dropped++
+			pub.state.coalesced()
+		}
+		c.pending[key] = newItem
+		return true
+	}
+	elapsed := time.Since(ck.lastPublished)
+	if elapsed >= c.window {
+		return false
+	}
+	if m, ok := pub.km.key.Load(key); ok && cmp.Equal(m, item) {
+		// Unchanged, nothing to wait for
+		return false
+	}
+	c.pending[key] = deepCopy(pub.log, item)
+	ck.generation++
+	generation := ck.generation
+	ck.timer = time.AfterFunc(c.window-elapsed, func() {
+		c.flush(pub, key, generation)
+	})
+	return true
+}
+
+// flush publishes the change to key which waited for the end of its window
+func (c *coalescer) flush(pub *PublicationImpl, key string, generation uint64) {
+	c.Lock()
+	defer c.Unlock()
+	ck, ok := c.keys[key]
+	if !ok || ck.timer == nil || ck.generation != generation {
+		// Published or unpublished meanwhile
+		return
+	}
+	ck.timer = nil
+	c.publishPending(pub, key)
+}
+
+// flushAll publishes all the changes waiting for the end of their window.
+// The lock must be held.
+func (c *coalescer) flushAll(pub *PublicationImpl) {
+	for key, ck := range c.keys {
+		if ck.timer == nil {
+			continue
+		}
+		ck.timer.Stop()
+		ck.timer = nil
+		c.publishPending(pub, key)
+	}
+}
+
+// publishPending moves the pending change of key to the local collection
+// and publishes it. The lock must be held.
+func (c *coalescer) publishPending(pub *PublicationImpl, key string) {
+	item, ok := c.pending[key]
+	if !ok {
+		return
+	}
+	delete(c.pending, key)
+	name := pub.nameString()
+	if !pub.storeItem(name, key, item) {
+		return
+	}
+	c.keys[key].lastPublished = time.Now()
+	if err := pub.publishStored(name, key); err != nil {
+		pub.log.Errorf("publishPending(%s/%s) failed: %v", name, key, err)
+	}
+}
+
+// published records that key was published other than through postpone,
+// which replaces any pending change. The lock must be held.
+func (c *coalescer) published(key string) {
+	ck, ok := c.keys[key]
+	if !ok {
+		ck = &coalescedKey{}
+		c.keys[key] = ck
+	}
+	c.cancel(key)
+	ck.lastPublished = time.Now()
+}
+
+// cancel drops the pending change of key, if any.
+// The lock must be held.
+func (c *coalescer) cancel(key string) {
+	if ck, ok := c.keys[key]; ok && ck.timer != nil {
+		ck.timer.Stop()
+		ck.timer = nil
+	}
+	delete(c.pending, key)
+}
+
+// forget drops the state of key once it is unpublished.
+// The lock must be held.
+func (c *coalescer) forget(key string) {
+	c.cancel(key)
+	delete(c.keys, key)
+}
@@ -0,0 +1,223 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package pubsub_test
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lf-edge/eve/pkg/pillar/base"
+	"github.com/lf-edge/eve/pkg/pillar/pubsub"
+	"github.com/lf-edge/eve/pkg/pillar/pubsub/socketdriver"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// receivedItem is what the subscriber got
+type receivedItem struct {
+	key     string
+	value   string
+	deleted bool
+}
+
+func TestCoalesce(t *testing.T) {
+	logger := logrus.StandardLogger()
+	log := base.NewSourceLogObject(logger, "test", 1234)
+	driver := socketdriver.SocketDriver{
+		Logger:  logger,
+		Log:     log,
+		RootDir: t.TempDir(),
+	}
+	const window = 500 * time.Millisecond
+	registry := pubsub.NewRegistry()
+	ps := pubsub.New(&driver, logger, log)
+	ps.SetRegistry(registry, "testagent")
+	pub, err := ps.NewPublication(pubsub.PublicationOptions{
+		AgentName:      "testagent",
+		TopicType:      item{},
+		CoalesceWindow: window,
+	})
+	assert.NoError(t, err)
+
+	received := make(chan receivedItem, 10)
+	changed := func(ctx interface{}, key string, status interface{}) {
+		received <- receivedItem{key: key, value: status.(item).FieldA}
+	}
+	sub, err := ps.NewSubscription(pubsub.SubscriptionOptions{
+		AgentName:     "testagent",
+		TopicImpl:     item{},
+		Activate:      true,
+		CreateHandler: changed,
+		ModifyHandler: func(ctx interface{}, key string, status, oldStatus interface{}) {
+			changed(ctx, key, status)
+		},
+		DeleteHandler: func(ctx interface{}, key string, status interface{}) {
+			received <- receivedItem{key: key, deleted: true}
+		},
+	})
+	assert.NoError(t, err)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case change := <-sub.MsgChan():
+				sub.ProcessChange(change)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	expect := func(expected receivedItem) {
+		select {
+		case r := <-received:
+			assert.Equal(t, expected, r)
+		case <-time.After(10 * window):
+			t.Fatalf("%+v not received", expected)
+		}
+	}
+	expectNothing := func() {
+		select {
+		case r := <-received:
+			t.Fatalf("unexpected %+v", r)
+		case <-time.After(window / 5):
+		}
+	}
+
+	// The first change is published right away, the others at the end
+	// of the window
+	start := time.Now()
+	assert.NoError(t, pub.Publish("one", item{FieldA: "a"}))
+	expect(receivedItem{key: "one", value: "a"})
+	assert.NoError(t, pub.Publish("one", item{FieldA: "b"}))
+	assert.NoError(t, pub.Publish("one", item{FieldA: "c"}))
+	// The subscribers get the changes of the other keys, and only these
+	assert.NoError(t, pub.Publish("two", item{FieldA: "a"}))
+	expect(receivedItem{key: "two", value: "a"})
+	expectNothing()
+	assert.NoError(t, pub.Publish("one", item{FieldA: "d"}))
+	assert.NoError(t, pub.Publish("one", item{FieldA: "d"}))
+	// Get and GetAll of the publisher return the latest change even if
+	// not yet published
+	value, err := pub.Get("one")
+	assert.NoError(t, err)
+	assert.Equal(t, "d", value.(item).FieldA)
+	assert.Equal(t, "d", pub.GetAll()["one"].(item).FieldA)
+	value, err = sub.Get("one")
+	assert.NoError(t, err)
+	assert.Equal(t, "a", value.(item).FieldA)
+	expect(receivedItem{key: "one", value: "d"})
+	assert.True(t, time.Since(start) >= window)
+	// b and c were replaced, the second d is not a change
+	assert.Equal(t, uint64(2), pub.Coalesced())
+	assert.Equal(t, uint64(2),
+		registry.Topics("", "", pubsub.KindPublication)[0].Coalesced)
+
+	// A change waiting for the end of the window is dropped if the key
+	// is unpublished
+	assert.NoError(t, pub.Publish("two", item{FieldA: "b"}))
+	expect(receivedItem{key: "two", value: "b"})
+	assert.NoError(t, pub.Publish("two", item{FieldA: "c"}))
+	assert.NoError(t, pub.Unpublish("two"))
+	expect(receivedItem{key: "two", deleted: true})
+	time.Sleep(2 * window)
+	expectNothing()
+
+	// A batch replaces the change waiting for the end of the window
+	assert.NoError(t, pub.Publish("one", item{FieldA: "e"}))
+	expect(receivedItem{key: "one", value: "e"})
+	assert.NoError(t, pub.Publish("one", item{FieldA: "f"}))
+	assert.NoError(t, pub.PublishBatch(
+		map[string]interface{}{"one": item{FieldA: "e"}}, nil))
+	time.Sleep(2 * window)
+	expectNothing()
+	value, err = pub.Get("one")
+	assert.NoError(t, err)
+	assert.Equal(t, "e", value.(item).FieldA)
+}
+
+// The changes which waited for the end of their window are published on
+// the goroutines of the timers, concurrently with the changes published by
+// the agent. Meant to be run with the race detector.
+func TestCoalesceConcurrent(t *testing.T) {
+	logger := logrus.StandardLogger()
+	log := base.NewSourceLogObject(logger, "test", 1234)
+	driver := socketdriver.SocketDriver{
+		Logger:  logger,
+		Log:     log,
+		RootDir: t.TempDir(),
+	}
+	const window = time.Millisecond
+	registry := pubsub.NewRegistry()
+	ps := pubsub.New(&driver, logger, log)
+	ps.SetRegistry(registry, "testagent")
+	pub, err := ps.NewPublication(pubsub.PublicationOptions{
+		AgentName:      "testagent",
+		TopicType:      item{},
+		CoalesceWindow: window,
+	})
+	assert.NoError(t, err)
+
+	var lock sync.Mutex
+	received := make(map[string]string)
+	changed := func(ctx interface{}, key string, status interface{}) {
+		lock.Lock()
+		received[key] = status.(item).FieldA
+		lock.Unlock()
+	}
+	sub, err := ps.NewSubscription(pubsub.SubscriptionOptions{
+		AgentName:     "testagent",
+		TopicImpl:     item{},
+		Activate:      true,
+		CreateHandler: changed,
+		ModifyHandler: func(ctx interface{}, key string, status, oldStatus interface{}) {
+			changed(ctx, key, status)
+		},
+	})
+	assert.NoError(t, err)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case change := <-sub.MsgChan():
+				sub.ProcessChange(change)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	keys := []string{"one", "two", "three"}
+	expected := make(map[string]string)
+	for i := 0; i < 300; i++ {
+		key := keys[i%len(keys)]
+		value := strconv.Itoa(i)
+		if i%30 == 0 {
+			assert.NoError(t, pub.PublishBatch(
+				map[string]interface{}{key: item{FieldA: value}}, nil))
+		} else {
+			assert.NoError(t, pub.Publish(key, item{FieldA: value}))
+		}
+		expected[key] = value
+		if i%10 == 0 {
+			time.Sleep(window)
+		}
+	}
+	// The subscriber ends up with the latest change of each key
+	assert.Eventually(t, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return assert.ObjectsAreEqual(expected, received)
+	}, 10*time.Second, 10*time.Millisecond)
+	for key, value := range expected {
+		published, err := pub.Get(key)
+		assert.NoError(t, err)
+		assert.Equal(t, value, published.(item).FieldA)
+	}
+	assert.NoError(t, pub.Close())
+}
@@ -29,6 +29,10 @@
 //
 // see the documentation for each element to understand its usage.
 //
+// `NewTypedPublication` and `NewTypedSubscription` return generic wrappers
+// which take and return items of the topic type instead of `interface{}`,
+// and call typed create/modify/delete handlers.
+//
 // For example:
 //
 //	import (
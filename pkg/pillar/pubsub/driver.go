@@ -61,7 +61,10 @@ type DriverPublisher interface {
 	// stored: disk, databases, or vellum. All it cares about is that it gets
 	// a key-value list.
 	Load() (map[string][]byte, int, error)
-	// Publish a key-value pair to all subscribers and optionally persistence
+	// Publish a key-value pair to all subscribers and optionally persistence.
+	// It may be called from another goroutine than the one which created
+	// the publisher, e.g. at the end of a CoalesceWindow, but the calls for
+	// a publisher are never concurrent.
 	Publish(key string, item []byte) error
 	// Unpublish a key, i.e. delete it and publish its deletion to all subscribers
 	Unpublish(key string) error
@@ -79,6 +82,23 @@ type DriverPublisher interface {
 	LargeDirName() string
 }
 
+// DriverBatchPublisher optional interface for a `DriverPublisher` which can
+// persist several changes atomically. Drivers which do not implement it get
+// the changes one at a time through Publish and Unpublish.
+type DriverBatchPublisher interface {
+	// PublishBatch publish the key-value pairs and delete the keys as
+	// a single update of the persistence.
+	PublishBatch(items map[string][]byte, deletes []string) error
+}
+
+// DriverChangeObserver optional interface for a `DriverSubscriber` which
+// needs to know when the agent processes each of its changes, e.g. to
+// record them in the order the handlers run.
+type DriverChangeObserver interface {
+	// Processing is called by ProcessChange before the change is handled
+	Processing(change Change)
+}
+
 // Restarted interface that lets you determine if a Publication has been restarted
 // Returns zero if not; the count indicates the number of times it has restarted.
 type Restarted interface {
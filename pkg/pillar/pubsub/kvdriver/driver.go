@@ -0,0 +1,104 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package kvdriver implements a pubsub driver which keeps the persistent
+// publications of an agent in a single embedded transactional store
+// instead of one json file per key.
+// IPC between publishers and subscribers, as well as non-persistent
+// publications, are handled by the wrapped SocketDriver.
+package kvdriver
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/lf-edge/eve/pkg/pillar/pubsub"
+	"github.com/lf-edge/eve/pkg/pillar/pubsub/socketdriver"
+)
+
+// KVDriver driver for pubsub using SocketDriver for IPC and a per-agent
+// store file for persistence
+type KVDriver struct {
+	socketdriver.SocketDriver
+	// PublishToStore selects whether persistent publications are written
+	// to the store. If not set, the driver behaves as SocketDriver except
+	// for persistent subscriptions, which load from the store of the
+	// publishing agent if it has one.
+	PublishToStore bool
+}
+
+// Publisher return an implementation of `pubsub.DriverPublisher` for
+// `KVDriver`
+func (k *KVDriver) Publisher(global bool, name, topic string, persistent bool, updaterList *pubsub.Updaters, restarted pubsub.Restarted, differ pubsub.Differ) (pubsub.DriverPublisher, error) {
+	if !persistent || !k.PublishToStore {
+		return k.SocketDriver.Publisher(global, name, topic, persistent,
+			updaterList, restarted, differ)
+	}
+	// Items published before the agent was switched to the store are
+	// imported on first use; the socket driver knows how to read them
+	// (including recovery from backup files).
+	legacyDir := k.legacyDirName(name)
+	_, err := os.Stat(legacyDir)
+	hasLegacy := err == nil
+	socketPub, err := k.SocketDriver.Publisher(global, name, topic, hasLegacy,
+		updaterList, restarted, differ)
+	if err != nil {
+		return nil, err
+	}
+	store, err := AcquireStore(k.storeName(name), k.Log)
+	if err != nil {
+		return nil, fmt.Errorf("Publisher(%s): %w", name, err)
+	}
+	pub := &Publisher{
+		DriverPublisher: socketPub,
+		store:           store,
+		name:            name,
+		topic:           topic,
+		log:             k.Log,
+	}
+	if !store.HasBucket(name) {
+		if err := pub.importLegacy(hasLegacy, legacyDir); err != nil {
+			store.Release()
+			if !hasLegacy {
+				return nil, err
+			}
+			// Keep publishing to the files, the import is retried
+			// when the agent restarts
+			k.Log.Errorf("Publisher(%s): %v", name, err)
+			return socketPub, nil
+		}
+	}
+	return pub, nil
+}
+
+// Subscriber return an implementation of `pubsub.DriverSubscriber` for
+// `KVDriver`
+func (k *KVDriver) Subscriber(global bool, name, topic string, persistent bool, C chan pubsub.Change) (pubsub.DriverSubscriber, error) {
+	socketSub, err := k.SocketDriver.Subscriber(global, name, topic,
+		persistent, C)
+	if err != nil {
+		return nil, err
+	}
+	if !persistent || global {
+		return socketSub, nil
+	}
+	return &Subscriber{
+		DriverSubscriber: socketSub,
+		storeName:        k.storeName(name),
+		name:             name,
+		log:              k.Log,
+	}, nil
+}
+
+// storeName returns the store file of the agent owning the publication.
+// The name is of the form agent/topic or agent/scope/topic.
+func (k *KVDriver) storeName(name string) string {
+	agentName := strings.Split(name, "/")[0]
+	return fmt.Sprintf("%s/persist/kvstore/%s.db", k.RootDir, agentName)
+}
+
+// legacyDirName where SocketDriver keeps persistent publications
+func (k *KVDriver) legacyDirName(name string) string {
+	return fmt.Sprintf("%s/persist/status/%s", k.RootDir, name)
+}
@@ -0,0 +1,87 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package kvdriver_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lf-edge/eve/pkg/pillar/base"
+	"github.com/lf-edge/eve/pkg/pillar/pubsub"
+	"github.com/lf-edge/eve/pkg/pillar/pubsub/kvdriver"
+	"github.com/lf-edge/eve/pkg/pillar/pubsub/socketdriver"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+type item struct {
+	Field string
+}
+
+func TestImportAndLoad(t *testing.T) {
+	rootPath := t.TempDir()
+	logger := logrus.StandardLogger()
+	log := base.NewSourceLogObject(logger, "test", 1234)
+	socketDriver := socketdriver.SocketDriver{
+		Logger:  logger,
+		Log:     log,
+		RootDir: rootPath,
+	}
+
+	// Publish with the socket driver first
+	ps := pubsub.New(&socketDriver, logger, log)
+	pub, err := ps.NewPublication(pubsub.PublicationOptions{
+		AgentName:  "kvtest",
+		TopicType:  item{},
+		Persistent: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, pub.Publish("key1", item{Field: "one"}))
+	assert.NoError(t, pub.Publish("key2", item{Field: "two"}))
+	pub.Close()
+	legacyDir := filepath.Join(rootPath, "persist", "status", "kvtest", "item")
+	_, err = os.Stat(legacyDir)
+	assert.NoError(t, err)
+
+	// Switching to the store imports the items
+	kvDriver := kvdriver.KVDriver{
+		SocketDriver:   socketDriver,
+		PublishToStore: true,
+	}
+	ps = pubsub.New(&kvDriver, logger, log)
+	pub, err = ps.NewPublication(pubsub.PublicationOptions{
+		AgentName:  "kvtest",
+		TopicType:  item{},
+		Persistent: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, pub.GetAll(), 2)
+	// Kept for an older EVE to fall back to
+	_, err = os.Stat(legacyDir)
+	assert.NoError(t, err)
+
+	assert.NoError(t, pub.PublishBatch(
+		map[string]interface{}{"key3": item{Field: "three"}},
+		[]string{"key1"}))
+	pub.Close()
+
+	// A persistent subscription of an agent not using the store itself
+	// loads from the store
+	subDriver := kvdriver.KVDriver{SocketDriver: socketDriver}
+	sub, err := subDriver.Subscriber(false, "kvtest/item", "item", true,
+		make(chan pubsub.Change))
+	if err != nil {
+		t.Fatal(err)
+	}
+	items, _, err := sub.Load()
+	assert.NoError(t, err)
+	assert.Len(t, items, 2)
+	assert.Contains(t, items, "key2")
+	assert.Contains(t, items, "key3")
+}
@@ -0,0 +1,108 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package kvdriver
+
+import (
+	"fmt"
+
+	"github.com/lf-edge/eve/pkg/pillar/base"
+	"github.com/lf-edge/eve/pkg/pillar/pubsub"
+)
+
+// Publisher implementation of `pubsub.DriverPublisher` for `KVDriver`.
+// The embedded socketdriver publisher serves the subscribers while
+// persistence goes to the store, in a bucket named after the publication.
+type Publisher struct {
+	pubsub.DriverPublisher
+	store *Store
+	name  string
+	topic string
+	log   *base.LogObject
+}
+
+// Publish persist a key-value pair
+func (p *Publisher) Publish(key string, item []byte) error {
+	if len(item) == 0 {
+		return fmt.Errorf("empty content published for %s/%s", p.name, key)
+	}
+	var batch Batch
+	batch.Put(p.name, key, pubsub.StampSchema(p.topic, item))
+	return p.store.Commit(&batch)
+}
+
+// PublishBatch persist several changes in a single transaction
+func (p *Publisher) PublishBatch(items map[string][]byte, deletes []string) error {
+	var batch Batch
+	for key, item := range items {
+		if len(item) == 0 {
+			return fmt.Errorf("empty content published for %s/%s", p.name, key)
+		}
+		batch.Put(p.name, key, pubsub.StampSchema(p.topic, item))
+	}
+	for _, key := range deletes {
+		batch.Delete(p.name, key)
+	}
+	return p.store.Commit(&batch)
+}
+
+// Unpublish delete a key from the store
+func (p *Publisher) Unpublish(key string) error {
+	if !p.store.Has(p.name, key) {
+		return fmt.Errorf("Unpublish(%s/%s): failed: no such key", p.name, key)
+	}
+	var batch Batch
+	batch.Delete(p.name, key)
+	return p.store.Commit(&batch)
+}
+
+// Load load entire persisted data set into a map
+func (p *Publisher) Load() (map[string][]byte, int, error) {
+	p.log.Tracef("Load(%s)\n", p.name)
+	items, restartCounter := p.store.Items(p.name)
+	return items, restartCounter, nil
+}
+
+// Restart set the restart counter of the topic
+func (p *Publisher) Restart(restartCounter int) error {
+	var batch Batch
+	batch.SetRestartCounter(p.name, restartCounter)
+	return p.store.Commit(&batch)
+}
+
+// Stop publishing and release the store
+func (p *Publisher) Stop() error {
+	err := p.DriverPublisher.Stop()
+	if err2 := p.store.Release(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// importLegacy creates the bucket for the publication and copies into it
+// whatever the socket driver has persisted in legacyDir, stamping the items
+// which predate the schema versioning as version 1. Nothing is committed if
+// the items cannot be read. legacyDir is left in place for the older EVE of
+// the other partition to find its items if the device falls back to it.
+func (p *Publisher) importLegacy(hasLegacy bool, legacyDir string) error {
+	var batch Batch
+	batch.CreateBucket(p.name)
+	if hasLegacy {
+		items, restartCounter, err := p.DriverPublisher.Load()
+		if err != nil {
+			return fmt.Errorf("importLegacy(%s): %w", p.name, err)
+		}
+		for key, item := range items {
+			batch.Put(p.name, key, pubsub.StampLegacySchema(p.topic, item))
+		}
+		if restartCounter != 0 {
+			batch.SetRestartCounter(p.name, restartCounter)
+		}
+		p.log.Noticef("importLegacy(%s): importing %d items from %s",
+			p.name, len(items), legacyDir)
+	}
+	if err := p.store.Commit(&batch); err != nil {
+		return fmt.Errorf("importLegacy(%s): %w", p.name, err)
+	}
+	return nil
+}
@@ -0,0 +1,535 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package kvdriver
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/lf-edge/eve/pkg/pillar/base"
+	fileutils "github.com/lf-edge/eve/pkg/pillar/utils/file"
+	"golang.org/x/sys/unix"
+)
+
+// The store is a single append-only file:
+//
+//	header: storeMagic
+//	record: uint32 payload length | uint32 crc32c(payload) | payload
+//	payload: op | uvarint len | bucket | uvarint len | key | uvarint len | value
+//
+// Records are grouped in transactions terminated by an opCommit record, and
+// every transaction is written with a single write followed by fsync.
+// When the file is replayed, records of a transaction are applied only once
+// its commit record has been read. A torn or corrupted tail (power loss in
+// the middle of a write) is thus discarded, and the store comes back in the
+// state of the last committed transaction. The writer truncates such a tail
+// when it opens the store.
+// The file is rewritten (compacted) once it has grown to twice the size it
+// had after the previous compaction.
+const (
+	storeMagic     = "EVEKVS1\n"
+	recordHdrSize  = 8
+	maxRecordSize  = 64 * 1024 * 1024
+	compactMinSize = 1024 * 1024
+)
+
+type op byte
+
+const (
+	opPut op = iota + 1
+	opDelete
+	opRestart
+	opBucket
+	opCommit
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+type bucket struct {
+	items          map[string][]byte
+	restartCounter int
+}
+
+// Store is an embedded transactional key-value store kept in a single file.
+// Keys are grouped in buckets, e.g. one bucket per publication.
+// Only a single process can open a Store for writing; see OpenStore.
+type Store struct {
+	sync.Mutex
+	path          string
+	file          *os.File // nil if opened read-only
+	size          int64
+	compactedSize int64
+	buckets       map[string]*bucket
+	refCount      int
+	// broken is set if a failed commit could not be rolled back; nothing
+	// more is written until the store is opened again
+	broken error
+	log    *base.LogObject // nil unless acquired
+}
+
+// Batch collects changes which are committed atomically by Store.Commit
+type Batch struct {
+	buf bytes.Buffer
+	ops int
+	// size of the largest record payload in the batch
+	maxPayload int
+}
+
+var (
+	storesLock sync.Mutex
+	stores     = make(map[string]*Store)
+)
+
+// AcquireStore returns the Store for the given path, opening it for writing
+// if this process does not have it open yet. Each call must be paired with
+// a call to Release. log reports the errors which do not fail a commit.
+func AcquireStore(path string, log *base.LogObject) (*Store, error) {
+	storesLock.Lock()
+	defer storesLock.Unlock()
+	if s, ok := stores[path]; ok {
+		s.refCount++
+		return s, nil
+	}
+	s, err := OpenStore(path)
+	if err != nil {
+		return nil, err
+	}
+	s.refCount = 1
+	s.log = log
+	stores[path] = s
+	return s, nil
+}
+
+// Release drops a reference obtained by AcquireStore and closes the store
+// once the last one is gone.
+func (s *Store) Release() error {
+	storesLock.Lock()
+	defer storesLock.Unlock()
+	s.refCount--
+	if s.refCount > 0 {
+		return nil
+	}
+	delete(stores, s.path)
+	return s.Close()
+}
+
+// OpenStore opens or creates the store file for writing.
+// An exclusive lock is held on the file until Close to make sure a single
+// process writes to it.
+func OpenStore(path string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("OpenStore(%s): %w", path, err)
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("OpenStore(%s): %w", path, err)
+	}
+	if err := unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("OpenStore(%s): already in use: %w", path, err)
+	}
+	s := &Store{path: path, file: f, buckets: make(map[string]*bucket)}
+	validSize, err := s.replay(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("OpenStore(%s): %w", path, err)
+	}
+	if validSize == 0 {
+		// New store
+		if _, err := f.Write([]byte(storeMagic)); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("OpenStore(%s): %w", path, err)
+		}
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("OpenStore(%s): %w", path, err)
+		}
+		validSize = int64(len(storeMagic))
+	}
+	// Discard any uncommitted or corrupted tail
+	if err := f.Truncate(validSize); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("OpenStore(%s): truncate: %w", path, err)
+	}
+	if _, err := f.Seek(validSize, io.SeekStart); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("OpenStore(%s): seek: %w", path, err)
+	}
+	s.size = validSize
+	s.compactedSize = validSize
+	return s, nil
+}
+
+// ReadStore returns a read-only snapshot of the store file, typically one
+// written by another process. It returns an error satisfying os.IsNotExist
+// if there is no such store.
+func ReadStore(path string) (*Store, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	s := &Store{path: path, buckets: make(map[string]*bucket)}
+	if _, err := s.replay(f); err != nil {
+		return nil, fmt.Errorf("ReadStore(%s): %w", path, err)
+	}
+	return s, nil
+}
+
+// Close the store
+func (s *Store) Close() error {
+	s.Lock()
+	defer s.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// HasBucket reports whether the bucket has been created
+func (s *Store) HasBucket(name string) bool {
+	s.Lock()
+	defer s.Unlock()
+	_, ok := s.buckets[name]
+	return ok
+}
+
+// Buckets returns the sorted names of all buckets
+func (s *Store) Buckets() []string {
+	s.Lock()
+	defer s.Unlock()
+	var names []string
+	for name := range s.buckets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Items returns a copy of the key-value pairs and the restart counter of
+// a bucket.
+func (s *Store) Items(name string) (map[string][]byte, int) {
+	s.Lock()
+	defer s.Unlock()
+	items := make(map[string][]byte)
+	b, ok := s.buckets[name]
+	if !ok {
+		return items, 0
+	}
+	for key, val := range b.items {
+		items[key] = append([]byte(nil), val...)
+	}
+	return items, b.restartCounter
+}
+
+// Get returns a copy of the value of a key in a bucket
+func (s *Store) Get(name, key string) ([]byte, bool) {
+	s.Lock()
+	defer s.Unlock()
+	b, ok := s.buckets[name]
+	if !ok {
+		return nil, false
+	}
+	val, ok := b.items[key]
+	if !ok {
+		return nil, false
+	}
+	return append([]byte(nil), val...), true
+}
+
+// Has returns true if a bucket has a key, without copying its value
+func (s *Store) Has(name, key string) bool {
+	s.Lock()
+	defer s.Unlock()
+	b, ok := s.buckets[name]
+	if !ok {
+		return false
+	}
+	_, ok = b.items[key]
+	return ok
+}
+
+// CreateBucket adds the bucket to the batch
+func (b *Batch) CreateBucket(name string) {
+	b.add(opBucket, name, "", nil)
+}
+
+// Put adds setting key to val to the batch
+func (b *Batch) Put(name, key string, val []byte) {
+	b.add(opPut, name, key, val)
+}
+
+// Delete adds deleting the key to the batch
+func (b *Batch) Delete(name, key string) {
+	b.add(opDelete, name, key, nil)
+}
+
+// SetRestartCounter adds setting the restart counter of the bucket to the batch
+func (b *Batch) SetRestartCounter(name string, restartCounter int) {
+	b.add(opRestart, name, "", []byte(fmt.Sprintf("%d", restartCounter)))
+}
+
+// Empty reports whether the batch has no changes
+func (b *Batch) Empty() bool {
+	return b.ops == 0
+}
+
+func (b *Batch) add(o op, name, key string, val []byte) {
+	var payload bytes.Buffer
+	payload.WriteByte(byte(o))
+	writeField(&payload, []byte(name))
+	writeField(&payload, []byte(key))
+	writeField(&payload, val)
+	writeRecord(&b.buf, payload.Bytes())
+	if payload.Len() > b.maxPayload {
+		b.maxPayload = payload.Len()
+	}
+	if o != opCommit {
+		b.ops++
+	}
+}
+
+// Commit writes the batch to the file and applies it to the in-memory state.
+// Either all or none of the changes in the batch survive a crash. The batch
+// is left as it is. A batch with a record larger than the replay accepts
+// is refused, since the replay would stop at it and drop every transaction
+// committed after it.
+func (s *Store) Commit(b *Batch) error {
+	if b.Empty() {
+		return nil
+	}
+	if b.maxPayload > maxRecordSize {
+		return fmt.Errorf("Commit(%s): record of %d bytes exceeds %d bytes",
+			s.path, b.maxPayload, maxRecordSize)
+	}
+	s.Lock()
+	defer s.Unlock()
+	if s.file == nil {
+		return fmt.Errorf("Commit(%s): store not open for writing", s.path)
+	}
+	if s.broken != nil {
+		return fmt.Errorf("Commit(%s): %w", s.path, s.broken)
+	}
+	var txn Batch
+	txn.buf.Write(b.buf.Bytes())
+	txn.add(opCommit, "", "", nil)
+	data := txn.buf.Bytes()
+	if _, err := s.file.Write(data); err != nil {
+		if rbErr := s.rollback(); rbErr != nil {
+			return fmt.Errorf("Commit(%s): %v; %w", s.path, err, rbErr)
+		}
+		return fmt.Errorf("Commit(%s): %w", s.path, err)
+	}
+	if err := s.file.Sync(); err != nil {
+		if rbErr := s.rollback(); rbErr != nil {
+			return fmt.Errorf("Commit(%s): sync: %v; %w", s.path, err, rbErr)
+		}
+		return fmt.Errorf("Commit(%s): sync: %w", s.path, err)
+	}
+	s.size += int64(len(data))
+	if _, err := s.replay(bytes.NewReader(append([]byte(storeMagic), data...))); err != nil {
+		return fmt.Errorf("Commit(%s): %w", s.path, err)
+	}
+	if s.size > compactMinSize && s.size > 2*s.compactedSize {
+		// The batch is committed whether or not the file is compacted;
+		// a failed compaction is retried once it has doubled again
+		if err := s.compact(); err != nil {
+			s.compactedSize = s.size
+			if s.log != nil {
+				s.log.Errorf("Commit(%s): compact: %v", s.path, err)
+			}
+		}
+	}
+	return nil
+}
+
+// rollback drops what a failed commit may have written past the last
+// committed transaction. If it cannot, the store is marked broken since
+// the next commits would be written after a torn record, which the replay
+// stops at. Called with the lock held.
+func (s *Store) rollback() error {
+	err := s.file.Truncate(s.size)
+	if err == nil {
+		_, err = s.file.Seek(s.size, io.SeekStart)
+	}
+	if err != nil {
+		s.broken = fmt.Errorf("rollback: %w", err)
+		return s.broken
+	}
+	return nil
+}
+
+// compact rewrites the file with only the current state.
+// Called with the lock held.
+func (s *Store) compact() error {
+	var snapshot Batch
+	for name, b := range s.buckets {
+		snapshot.CreateBucket(name)
+		if b.restartCounter != 0 {
+			snapshot.SetRestartCounter(name, b.restartCounter)
+		}
+		for key, val := range b.items {
+			snapshot.Put(name, key, val)
+		}
+	}
+	snapshot.add(opCommit, "", "", nil)
+
+	tmpName := s.path + ".tmp"
+	tmp, err := os.OpenFile(tmpName, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if err := unix.Flock(int(tmp.Fd()), unix.LOCK_EX|unix.LOCK_NB); err != nil {
+		tmp.Close()
+		return err
+	}
+	data := append([]byte(storeMagic), snapshot.buf.Bytes()...)
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmpName)
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpName)
+		return err
+	}
+	if err := os.Rename(tmpName, s.path); err != nil {
+		tmp.Close()
+		os.Remove(tmpName)
+		return err
+	}
+	// The path is the new file now, whether or not the rename is durable
+	s.file.Close()
+	s.file = tmp
+	s.size = int64(len(data))
+	s.compactedSize = s.size
+	return fileutils.DirSync(filepath.Dir(s.path))
+}
+
+// replay applies the committed transactions read from r and returns the
+// offset just past the last commit record; zero for an empty input.
+func (s *Store) replay(r io.Reader) (int64, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(storeMagic))
+	n, err := io.ReadFull(br, magic)
+	if n == 0 && (err == io.EOF || err == io.ErrUnexpectedEOF) {
+		return 0, nil
+	}
+	if err != nil || string(magic) != storeMagic {
+		return 0, errors.New("not a kvdriver store")
+	}
+	offset := int64(len(storeMagic))
+	validSize := offset
+	var pending [][]byte
+	hdr := make([]byte, recordHdrSize)
+	for {
+		if _, err := io.ReadFull(br, hdr); err != nil {
+			// EOF or torn record header
+			break
+		}
+		length := binary.LittleEndian.Uint32(hdr[0:4])
+		crc := binary.LittleEndian.Uint32(hdr[4:8])
+		if length == 0 || length > maxRecordSize {
+			break
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(br, payload); err != nil {
+			break
+		}
+		if crc32.Checksum(payload, crcTable) != crc {
+			break
+		}
+		offset += recordHdrSize + int64(length)
+		if op(payload[0]) != opCommit {
+			pending = append(pending, payload)
+			continue
+		}
+		for _, p := range pending {
+			if err := s.apply(p); err != nil {
+				return validSize, err
+			}
+		}
+		pending = nil
+		validSize = offset
+	}
+	return validSize, nil
+}
+
+func (s *Store) apply(payload []byte) error {
+	o := op(payload[0])
+	r := bytes.NewReader(payload[1:])
+	name, err := readField(r)
+	if err != nil {
+		return err
+	}
+	key, err := readField(r)
+	if err != nil {
+		return err
+	}
+	val, err := readField(r)
+	if err != nil {
+		return err
+	}
+	b, ok := s.buckets[string(name)]
+	if !ok {
+		b = &bucket{items: make(map[string][]byte)}
+		s.buckets[string(name)] = b
+	}
+	switch o {
+	case opBucket:
+	case opPut:
+		b.items[string(key)] = val
+	case opDelete:
+		delete(b.items, string(key))
+	case opRestart:
+		if _, err := fmt.Sscanf(string(val), "%d", &b.restartCounter); err != nil {
+			return fmt.Errorf("bad restart counter %q: %w", val, err)
+		}
+	default:
+		return fmt.Errorf("unknown record op %d", o)
+	}
+	return nil
+}
+
+func writeField(w *bytes.Buffer, b []byte) {
+	var lenBuf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(lenBuf[:], uint64(len(b)))
+	w.Write(lenBuf[:n])
+	w.Write(b)
+}
+
+func readField(r *bytes.Reader) ([]byte, error) {
+	length, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if length > uint64(r.Len()) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	b := make([]byte, length)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func writeRecord(w *bytes.Buffer, payload []byte) {
+	var hdr [recordHdrSize]byte
+	binary.LittleEndian.PutUint32(hdr[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(hdr[4:8], crc32.Checksum(payload, crcTable))
+	w.Write(hdr[:])
+	w.Write(payload)
+}
@@ -0,0 +1,214 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package kvdriver
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStoreCommitAndReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	store, err := OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var batch Batch
+	batch.CreateBucket("a/topic")
+	batch.Put("a/topic", "key1", []byte("val1"))
+	batch.Put("a/topic", "key2", []byte("val2"))
+	batch.SetRestartCounter("a/topic", 3)
+	assert.NoError(t, store.Commit(&batch))
+
+	var batch2 Batch
+	batch2.Delete("a/topic", "key1")
+	batch2.Put("a/topic", "key2", []byte("val2b"))
+	assert.NoError(t, store.Commit(&batch2))
+	assert.NoError(t, store.Close())
+
+	store, err = OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	items, restartCounter := store.Items("a/topic")
+	assert.Equal(t, map[string][]byte{"key2": []byte("val2b")}, items)
+	assert.Equal(t, 3, restartCounter)
+	assert.False(t, store.HasBucket("b/topic"))
+	assert.True(t, store.Has("a/topic", "key2"))
+	assert.False(t, store.Has("a/topic", "key1"))
+	assert.False(t, store.Has("b/topic", "key2"))
+
+	// A second writer is refused
+	_, err = OpenStore(path)
+	assert.Error(t, err)
+}
+
+func TestStoreCommitKeepsBatch(t *testing.T) {
+	dir := t.TempDir()
+	var batch Batch
+	batch.Put("a/topic", "key1", []byte("val1"))
+	size := batch.buf.Len()
+	for _, name := range []string{"one.db", "two.db"} {
+		store, err := OpenStore(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.NoError(t, store.Commit(&batch))
+		assert.Equal(t, size, batch.buf.Len())
+		items, _ := store.Items("a/topic")
+		assert.Equal(t, map[string][]byte{"key1": []byte("val1")}, items)
+		assert.NoError(t, store.Close())
+	}
+}
+
+func TestStoreTornWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	store, err := OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var batch Batch
+	batch.Put("a/topic", "key1", []byte("val1"))
+	assert.NoError(t, store.Commit(&batch))
+	committedSize := store.size
+
+	var batch2 Batch
+	batch2.Put("a/topic", "key1", []byte("changed"))
+	batch2.Put("a/topic", "key2", []byte("val2"))
+	assert.NoError(t, store.Commit(&batch2))
+	assert.NoError(t, store.Close())
+
+	// Simulate power loss in the middle of the second transaction
+	for _, cut := range []int64{1, recordHdrSize, 20} {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		torn := filepath.Join(t.TempDir(), "torn.db")
+		if err := os.WriteFile(torn, data[:committedSize+cut], 0600); err != nil {
+			t.Fatal(err)
+		}
+		reader, err := ReadStore(torn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		items, _ := reader.Items("a/topic")
+		assert.Equal(t, map[string][]byte{"key1": []byte("val1")}, items)
+
+		writer, err := OpenStore(torn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, committedSize, writer.size)
+		var batch3 Batch
+		batch3.Put("a/topic", "key3", []byte("val3"))
+		assert.NoError(t, writer.Commit(&batch3))
+		assert.NoError(t, writer.Close())
+
+		reader, err = ReadStore(torn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		items, _ = reader.Items("a/topic")
+		assert.Equal(t, map[string][]byte{"key1": []byte("val1"),
+			"key3": []byte("val3")}, items)
+	}
+}
+
+func TestStoreCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	store, err := OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	val := make([]byte, 4096)
+	for i := 0; i < 1000; i++ {
+		var batch Batch
+		batch.Put("a/topic", fmt.Sprintf("key%d", i%10), val)
+		assert.NoError(t, store.Commit(&batch))
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Less(t, info.Size(), int64(3*compactMinSize))
+
+	reader, err := ReadStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	items, _ := reader.Items("a/topic")
+	assert.Len(t, items, 10)
+}
+
+func TestStoreCompactFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	store, err := OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	// The compacted file cannot be created
+	assert.NoError(t, os.Mkdir(path+".tmp", 0700))
+	val := make([]byte, 4096)
+	for i := 0; i < 1000; i++ {
+		var batch Batch
+		batch.Put("a/topic", fmt.Sprintf("key%d", i%10), val)
+		assert.NoError(t, store.Commit(&batch))
+	}
+	reader, err := ReadStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	items, _ := reader.Items("a/topic")
+	assert.Len(t, items, 10)
+}
+
+func TestStoreBrokenRollback(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	store, err := OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	var batch Batch
+	batch.Put("a/topic", "key1", []byte("val1"))
+	assert.NoError(t, store.Commit(&batch))
+
+	// Neither the write nor the rollback can be done
+	store.file.Close()
+	assert.Error(t, store.Commit(&batch))
+	assert.Error(t, store.broken)
+	assert.ErrorIs(t, store.Commit(&batch), store.broken)
+}
+
+func TestStoreRecordTooLarge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	store, err := OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var batch Batch
+	batch.Put("a/topic", "key1", make([]byte, maxRecordSize))
+	assert.Error(t, store.Commit(&batch))
+
+	// The next commits survive a reopen
+	var batch2 Batch
+	batch2.Put("a/topic", "key2", []byte("val2"))
+	assert.NoError(t, store.Commit(&batch2))
+	assert.NoError(t, store.Close())
+	store, err = OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	items, _ := store.Items("a/topic")
+	assert.Equal(t, map[string][]byte{"key2": []byte("val2")}, items)
+}
@@ -0,0 +1,40 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package kvdriver
+
+import (
+	"os"
+
+	"github.com/lf-edge/eve/pkg/pillar/base"
+	"github.com/lf-edge/eve/pkg/pillar/pubsub"
+)
+
+// Subscriber implementation of `pubsub.DriverSubscriber` for `KVDriver`.
+// Updates come over the embedded socketdriver subscriber; only the initial
+// Load of a persistent subscription reads the store of the publisher.
+type Subscriber struct {
+	pubsub.DriverSubscriber
+	storeName string
+	name      string
+	log       *base.LogObject
+}
+
+// Load load entire persisted data set into a map.
+// Falls back to the files of the socket driver if the publishing agent
+// does not use a store, or has not published this topic to it yet.
+func (s *Subscriber) Load() (map[string][]byte, int, error) {
+	s.log.Tracef("Load(%s)\n", s.name)
+	store, err := ReadStore(s.storeName)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			s.log.Errorf("Load(%s): %v", s.name, err)
+		}
+		return s.DriverSubscriber.Load()
+	}
+	if !store.HasBucket(s.name) {
+		return s.DriverSubscriber.Load()
+	}
+	items, restartCounter := store.Items(s.name)
+	return items, restartCounter, nil
+}
@@ -55,6 +55,8 @@ type PublicationImpl struct {
 	persistent  bool
 	logger      *logrus.Logger
 	log         *base.LogObject
+	state       *topicState // Set if there is a Registry
+	coalesce    *coalescer  // Set if there is a CoalesceWindow
 
 	driver DriverPublisher
 }
@@ -98,23 +100,157 @@ func (pub *PublicationImpl) CheckMaxSize(key string, item interface{}) error {
 
 // Publish publish a key-value pair
 func (pub *PublicationImpl) Publish(key string, item interface{}) error {
-	topic := TypeToName(item)
 	name := pub.nameString()
-	if topic != pub.topic {
-		errStr := fmt.Sprintf("Publish(%s): item is wrong topic %s",
-			name, topic)
-		pub.log.Fatalln(errStr)
+	if pub.coalesce != nil {
+		pub.coalesce.Lock()
+		defer pub.coalesce.Unlock()
+		pub.checkItem(name, item)
+		if pub.coalesce.postpone(pub, key, item) {
+			return nil
+		}
 	}
-	val := reflect.ValueOf(item)
-	if val.Kind() == reflect.Ptr {
-		pub.log.Fatalf("Publish got a pointer for %s", name)
+	if !pub.storeItem(name, key, item) {
+		return nil
+	}
+	if pub.coalesce != nil {
+		pub.coalesce.published(key)
+	}
+	return pub.publishStored(name, key)
+}
+
+// publishStored hands the item stored for key to the subscribers and the
+// driver
+func (pub *PublicationImpl) publishStored(name, key string) error {
+	item, ok := pub.km.key.Load(key)
+	if !ok {
+		return nil
+	}
+	if pub.logger.GetLevel() == logrus.TraceLevel {
+		pub.dump("after Publish")
+	}
+	pub.updatersNotify(name)
+	// marshal to json bytes to send to the driver
+	b, err := json.Marshal(item)
+	if err != nil {
+		pub.log.Fatal("json Marshal in Publish", err)
+	}
+	pub.state.changed(Modify, key, len(b))
+
+	// We pass the full json to the driver including any pubsub-large
+	// items to have a complete checkpoint.
+	return pub.driver.Publish(key, b)
+}
+
+// Unpublish delete a key from the key-value map
+func (pub *PublicationImpl) Unpublish(key string) error {
+	name := pub.nameString()
+	if pub.coalesce != nil {
+		pub.coalesce.Lock()
+		defer pub.coalesce.Unlock()
+		pub.coalesce.forget(key)
+	}
+	if err := pub.removeItem(name, key); err != nil {
+		return err
+	}
+	if pub.logger.GetLevel() == logrus.TraceLevel {
+		pub.dump("after Unpublish")
+	}
+	pub.updatersNotify(name)
+	pub.state.changed(Delete, key, 0)
+
+	return pub.driver.Unpublish(key)
+}
+
+// PublishBatch publish several key-value pairs and delete several keys with
+// a single notification of the subscribers. If the driver implements
+// DriverBatchPublisher the changes are persisted atomically.
+func (pub *PublicationImpl) PublishBatch(items map[string]interface{}, deletes []string) error {
+	name := pub.nameString()
+	if pub.coalesce != nil {
+		pub.coalesce.Lock()
+		defer pub.coalesce.Unlock()
+	}
+	// Check the deletes upfront to not apply half of the batch
+	for _, key := range deletes {
+		if _, ok := items[key]; ok {
+			errStr := fmt.Sprintf("PublishBatch(%s/%s): key both published and deleted",
+				name, key)
+			pub.log.Errorf("%s\n", errStr)
+			return errors.New(errStr)
+		}
+		if _, ok := pub.km.key.Load(key); !ok {
+			errStr := fmt.Sprintf("PublishBatch(%s/%s): key does not exist",
+				name, key)
+			pub.log.Errorf("%s\n", errStr)
+			return errors.New(errStr)
+		}
+	}
+	changed := make(map[string][]byte)
+	for key, item := range items {
+		if pub.coalesce != nil {
+			// The batch replaces any change waiting for its window
+			pub.coalesce.cancel(key)
+		}
+		if !pub.storeItem(name, key, item) {
+			continue
+		}
+		b, err := json.Marshal(item)
+		if err != nil {
+			pub.log.Fatal("json Marshal in PublishBatch", err)
+		}
+		changed[key] = b
+	}
+	for _, key := range deletes {
+		if err := pub.removeItem(name, key); err != nil {
+			return err
+		}
+	}
+	if len(changed) == 0 && len(deletes) == 0 {
+		return nil
+	}
+	if pub.logger.GetLevel() == logrus.TraceLevel {
+		pub.dump("after PublishBatch")
+	}
+	pub.updatersNotify(name)
+	for key, b := range changed {
+		if pub.coalesce != nil {
+			pub.coalesce.published(key)
+		}
+		pub.state.changed(Modify, key, len(b))
 	}
+	for _, key := range deletes {
+		if pub.coalesce != nil {
+			pub.coalesce.forget(key)
+		}
+		pub.state.changed(Delete, key, 0)
+	}
+
+	if batchDriver, ok := pub.driver.(DriverBatchPublisher); ok {
+		return batchDriver.PublishBatch(changed, deletes)
+	}
+	for key, b := range changed {
+		if err := pub.driver.Publish(key, b); err != nil {
+			return err
+		}
+	}
+	for _, key := range deletes {
+		if err := pub.driver.Unpublish(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// storeItem adds or replaces the item in the key-value map.
+// Returns false if the item is unchanged.
+func (pub *PublicationImpl) storeItem(name, key string, item interface{}) bool {
+	pub.checkItem(name, item)
 	// Perform a deepCopy in case the caller might change a map etc
 	newItem := deepCopy(pub.log, item)
 	if m, ok := pub.km.key.Load(key); ok {
 		if cmp.Equal(m, newItem) {
 			pub.log.Tracef("Publish(%s/%s) unchanged\n", name, key)
-			return nil
+			return false
 		}
 		// DO NOT log Values. They may contain sensitive information.
 		pub.log.Tracef("Publish(%s/%s) replacing due to diff\n",
@@ -133,25 +269,25 @@ func (pub *PublicationImpl) Publish(key string, item interface{}) error {
 		}
 	}
 	pub.km.key.Store(key, newItem)
+	return true
+}
 
-	if pub.logger.GetLevel() == logrus.TraceLevel {
-		pub.dump("after Publish")
+// checkItem verifies that the item can be published
+func (pub *PublicationImpl) checkItem(name string, item interface{}) {
+	topic := TypeToName(item)
+	if topic != pub.topic {
+		errStr := fmt.Sprintf("Publish(%s): item is wrong topic %s",
+			name, topic)
+		pub.log.Fatalln(errStr)
 	}
-	pub.updatersNotify(name)
-	// marshal to json bytes to send to the driver
-	b, err := json.Marshal(item)
-	if err != nil {
-		pub.log.Fatal("json Marshal in Publish", err)
+	val := reflect.ValueOf(item)
+	if val.Kind() == reflect.Ptr {
+		pub.log.Fatalf("Publish got a pointer for %s", name)
 	}
-
-	// We pass the full json to the driver including any pubsub-large
-	// items to have a complete checkpoint.
-	return pub.driver.Publish(key, b)
 }
 
-// Unpublish delete a key from the key-value map
-func (pub *PublicationImpl) Unpublish(key string) error {
-	name := pub.nameString()
+// removeItem deletes the key from the key-value map
+func (pub *PublicationImpl) removeItem(name, key string) error {
 	if m, ok := pub.km.key.Load(key); ok {
 		// DO NOT log Values. They may contain sensitive information.
 		pub.log.Tracef("Unpublish(%s/%s) removing Item", name, key)
@@ -166,12 +302,7 @@ func (pub *PublicationImpl) Unpublish(key string) error {
 		return errors.New(errStr)
 	}
 	pub.km.key.Delete(key)
-	if pub.logger.GetLevel() == logrus.TraceLevel {
-		pub.dump("after Unpublish")
-	}
-	pub.updatersNotify(name)
-
-	return pub.driver.Unpublish(key)
+	return nil
 }
 
 // SignalRestarted signal that a publication is restarted one more time
@@ -186,8 +317,17 @@ func (pub *PublicationImpl) ClearRestarted() error {
 	return pub.restartImpl(false)
 }
 
-// Get the value for a given key
+// Get the value for a given key, including a change waiting for the end
+// of its CoalesceWindow
 func (pub *PublicationImpl) Get(key string) (interface{}, error) {
+	if pub.coalesce != nil {
+		pub.coalesce.Lock()
+		m, ok := pub.coalesce.pending[key]
+		pub.coalesce.Unlock()
+		if ok {
+			return deepCopy(pub.log, m), nil
+		}
+	}
 	m, ok := pub.km.key.Load(key)
 	if ok {
 		newIntf := deepCopy(pub.log, m)
@@ -199,8 +339,22 @@ func (pub *PublicationImpl) Get(key string) (interface{}, error) {
 	}
 }
 
-// GetAll enumerate all the key-value pairs for the collection
+// GetAll enumerate all the key-value pairs for the collection, including
+// the changes waiting for the end of their CoalesceWindow
 func (pub *PublicationImpl) GetAll() map[string]interface{} {
+	result := pub.getPublished()
+	if pub.coalesce != nil {
+		pub.coalesce.Lock()
+		for key, val := range pub.coalesce.pending {
+			result[key] = deepCopy(pub.log, val)
+		}
+		pub.coalesce.Unlock()
+	}
+	return result
+}
+
+// getPublished enumerate the key-value pairs handed to the subscribers
+func (pub *PublicationImpl) getPublished() map[string]interface{} {
 	result := make(map[string]interface{})
 	assigner := func(key string, val interface{}) bool {
 		newVal := deepCopy(pub.log, val)
@@ -213,11 +367,35 @@ func (pub *PublicationImpl) GetAll() map[string]interface{} {
 
 // Iterate - performs some callback function on all items
 func (pub *PublicationImpl) Iterate(function base.StrMapFunc) {
-	pub.km.key.Range(function)
+	if pub.coalesce == nil {
+		pub.km.key.Range(function)
+		return
+	}
+	for key, val := range pub.GetAll() {
+		if !function(key, val) {
+			return
+		}
+	}
+}
+
+// Coalesced returns the number of changes which were not published since
+// replaced by a later change in the same CoalesceWindow
+func (pub *PublicationImpl) Coalesced() uint64 {
+	if pub.coalesce == nil {
+		return 0
+	}
+	pub.coalesce.Lock()
+	defer pub.coalesce.Unlock()
+	return pub.coalesce.dropped
 }
 
 // Close the publisher
 func (pub *PublicationImpl) Close() error {
+	if pub.coalesce != nil {
+		pub.coalesce.Lock()
+		pub.coalesce.flushAll(pub)
+		pub.coalesce.Unlock()
+	}
 	items := pub.GetAll()
 	if !pub.persistent {
 		for key := range items {
@@ -228,6 +406,7 @@ func (pub *PublicationImpl) Close() error {
 	}
 	pub.ClearRestarted()
 	pub.driver.Stop()
+	pub.state.unregister()
 	return nil
 }
 
@@ -267,6 +446,13 @@ func (pub *PublicationImpl) populate() {
 		return
 	}
 	for key, itemB := range pairs {
+		// Items persisted by an older release might need a migration.
+		// They are written back in the current version when next published.
+		itemB, err = migrateSchema(pub.log, pub.topic, key, itemB)
+		if err != nil {
+			pub.log.Error(err)
+			continue
+		}
 		// Just in case large items were stored separately
 		itemB, err = readAddLarge(pub.log, itemB)
 		if err != nil {
@@ -295,7 +481,9 @@ func (pub *PublicationImpl) publisher() {
 func (pub *PublicationImpl) DetermineDiffs(localCollection LocalCollection) []string {
 	var keys []string
 	name := pub.nameString()
-	items := pub.GetAll()
+	// The changes waiting for the end of their CoalesceWindow are not
+	// handed to the subscribers yet
+	items := pub.getPublished()
 	dirname := fmt.Sprintf("%s/%s", pub.driver.LargeDirName(), pub.nameString())
 	// Look for deleted
 	for localKey := range localCollection {
@@ -359,6 +547,11 @@ func (pub *PublicationImpl) nameString() string {
 // Record the restarted state and send over socket/file.
 func (pub *PublicationImpl) restartImpl(restarted bool) error {
 	name := pub.nameString()
+	if pub.coalesce != nil {
+		// Serialized with the changes published at the end of a window
+		pub.coalesce.Lock()
+		defer pub.coalesce.Unlock()
+	}
 	pub.log.Functionf("pub.restartImpl(%s, %v)\n", name, restarted)
 	var restartCounter int
 	if restarted {
@@ -373,6 +566,7 @@ func (pub *PublicationImpl) restartImpl(restarted bool) error {
 		return nil
 	}
 	pub.km.restartCounter = restartCounter
+	pub.state.restarted(restartCounter)
 	// XXX lock on restarted to make sure it gets noticed?
 	// XXX bug?
 	// Implicit in updaters lock??
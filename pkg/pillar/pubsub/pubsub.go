@@ -68,6 +68,9 @@ type PubSub struct {
 	updaterList *Updaters
 	logger      *logrus.Logger
 	log         *base.LogObject
+	// Set by SetRegistry
+	registry  *Registry
+	agentName string
 }
 
 // New create a new `PubSub` with a given `Driver`.
@@ -129,6 +132,8 @@ func (p *PubSub) NewSubscription(options SubscriptionOptions) (Subscription, err
 		return sub, err
 	}
 	sub.driver = driver
+	sub.state = p.register(KindSubscription, name, topic,
+		options.Persistent, &sub.km, false)
 
 	sub.log.Functionf("Subscribe(%s)\n", name)
 	if options.Activate {
@@ -149,8 +154,20 @@ type PublicationOptions struct {
 	AgentScope string
 	TopicType  interface{}
 	Persistent bool
+	// CoalesceWindow if set rate-limits the changes published for each
+	// key to one per window, publishing only the latest one. Meant for
+	// metrics-like topics which change more often than their subscribers
+	// care about.
+	CoalesceWindow time.Duration
 }
 
+// MetricCoalesceWindow is the CoalesceWindow of the metrics the agents
+// publish on a ticker at 4 times the default metricInterval (60s) zedagent
+// reports them at: at most one change per key per period, as the randomized
+// tickers fire down to 30% of their period and a metricInterval made shorter
+// does not publish more often.
+const MetricCoalesceWindow = 60 * time.Second / 4
+
 // NewPublication creates a new Publication with given options
 func (p *PubSub) NewPublication(options PublicationOptions) (Publication, error) {
 	if options.TopicType == nil {
@@ -186,11 +203,16 @@ func (p *PubSub) NewPublication(options PublicationOptions) (Publication, error)
 		return pub, err
 	}
 	pub.driver = driver
+	if options.CoalesceWindow > 0 {
+		pub.coalesce = newCoalescer(options.CoalesceWindow)
+	}
 
 	pub.populate()
 	if pub.logger.GetLevel() == logrus.TraceLevel {
 		pub.dump("after populate")
 	}
+	pub.state = p.register(KindPublication, name, topic,
+		options.Persistent, &pub.km, true)
 	pub.log.Tracef("Publish(%s)\n", name)
 
 	pub.publisher()
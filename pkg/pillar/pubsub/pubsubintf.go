@@ -22,6 +22,9 @@ type Publication interface {
 	Publish(key string, item interface{}) error
 	// Unpublish - Delete / UnPublish an object
 	Unpublish(key string) error
+	// PublishBatch - Publish and Unpublish several objects. The changes
+	// are persisted atomically if the driver supports it.
+	PublishBatch(items map[string]interface{}, deletes []string) error
 	// SignalRestarted - Signal the publisher has started one more time
 	SignalRestarted() error
 	// ClearRestarted clear the restarted flag
@@ -32,6 +35,8 @@ type Publication interface {
 	GetAll() map[string]interface{}
 	// Iterate - Perform some action on all items
 	Iterate(function base.StrMapFunc)
+	// Coalesced - Number of changes not published due to the CoalesceWindow
+	Coalesced() uint64
 	// Close - delete the publisher
 	Close() error
 }
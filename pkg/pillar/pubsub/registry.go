@@ -0,0 +1,477 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package pubsub
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lf-edge/eve/pkg/pillar/base"
+)
+
+const (
+	// KindPublication TopicInfo.Kind of a publication
+	KindPublication = "publication"
+	// KindSubscription TopicInfo.Kind of a subscription
+	KindSubscription = "subscription"
+
+	// watchBufferSize is how many changes can be queued for a watcher
+	// before changes are dropped
+	watchBufferSize = 256
+)
+
+// Registry keeps track of the publications and subscriptions of the agents
+// running in a process, for a read-only introspection of what they hold.
+// It is an http.Handler; zedbox serves it over a Unix socket.
+//
+// Endpoints:
+//
+//	GET /topics[?agent=<agent>][&name=<name>][&kind=<kind>]
+//		list of TopicInfo
+//	GET /items?name=<name>[&agent=<agent>][&kind=<kind>]
+//		list of ItemInfo of the first matching publication or subscription
+//	GET /stream?name=<name>[&agent=<agent>][&kind=<kind>]
+//		TopicChange events of the matching ones, one json per line,
+//		until the client disconnects
+//
+// where name is the name of the publication i.e. agent/topic or
+// agent/scope/topic, and agent is the agent owning the publication or the
+// subscription.
+// The values of the items are never served since they may contain
+// sensitive information.
+type Registry struct {
+	sync.Mutex
+	entries map[*topicState]struct{}
+}
+
+// TopicInfo describes a publication or a subscription in a Registry
+type TopicInfo struct {
+	Kind string
+	// Agent owning the publication or subscription
+	Agent string
+	// Name of the publication i.e. agent/topic or agent/scope/topic
+	Name           string
+	Topic          string
+	Persistent     bool
+	Keys           int
+	RestartCounter int
+	// Synchronized is always set for a publication
+	Synchronized bool
+	// LastChange is zero if there was no change since the start
+	LastChange time.Time
+	// Coalesced is the number of changes of a publication which were
+	// replaced by a later one within its CoalesceWindow
+	Coalesced uint64 `json:",omitempty"`
+}
+
+// ItemInfo describes an item of a publication or a subscription without
+// its value
+type ItemInfo struct {
+	Key string
+	// Size of the json encoding of the value
+	Size int
+	// Seq is the sequence number of the last change of the item in its
+	// publication or subscription. Zero if it did not change since the
+	// start.
+	Seq uint64 `json:",omitempty"`
+	// LastChange is zero if the item did not change since the start
+	LastChange time.Time
+}
+
+// TopicChange is a change to a publication or a subscription.
+// Size is set for a Modify.
+type TopicChange struct {
+	Time      time.Time
+	Seq       uint64
+	Kind      string
+	Agent     string
+	Name      string
+	Operation string
+	Key       string `json:",omitempty"`
+	Size      int    `json:",omitempty"`
+}
+
+// itemChange is what a topicState keeps of the last change of an item
+type itemChange struct {
+	seq  uint64
+	size int
+	time time.Time
+}
+
+// topicState is the state a Registry has for a publication or a subscription.
+// The publication or subscription reports its changes by calling changed.
+type topicState struct {
+	registry   *Registry
+	kind       string
+	agent      string
+	name       string
+	topic      string
+	persistent bool
+	km         *keyMap
+	log        *base.LogObject
+
+	lock           sync.Mutex
+	restartCounter int
+	synchronized   bool
+	lastChange     time.Time
+	seq            uint64
+	itemChanges    map[string]itemChange
+	watchers       map[chan TopicChange]struct{}
+	dropped        int
+	coalescedCount uint64
+}
+
+// NewRegistry returns an empty Registry
+func NewRegistry() *Registry {
+	return &Registry{entries: make(map[*topicState]struct{})}
+}
+
+// SetRegistry makes the publications and subscriptions created from now
+// on by p visible in registry as owned by agentName
+func (p *PubSub) SetRegistry(registry *Registry, agentName string) {
+	p.registry = registry
+	p.agentName = agentName
+}
+
+// Registry returns the Registry set by SetRegistry, if any
+func (p *PubSub) Registry() *Registry {
+	return p.registry
+}
+
+// register adds a publication or a subscription to the Registry.
+// Returns nil if p has no Registry.
+func (p *PubSub) register(kind, name, topic string, persistent bool,
+	km *keyMap, synchronized bool) *topicState {
+
+	if p.registry == nil {
+		return nil
+	}
+	state := &topicState{
+		registry:       p.registry,
+		kind:           kind,
+		agent:          p.agentName,
+		name:           name,
+		topic:          topic,
+		persistent:     persistent,
+		km:             km,
+		log:            p.log,
+		restartCounter: km.restartCounter,
+		synchronized:   synchronized,
+		itemChanges:    make(map[string]itemChange),
+		watchers:       make(map[chan TopicChange]struct{}),
+	}
+	p.registry.Lock()
+	p.registry.entries[state] = struct{}{}
+	p.registry.Unlock()
+	return state
+}
+
+// unregister removes the state from its Registry and ends its watchers
+func (state *topicState) unregister() {
+	if state == nil {
+		return
+	}
+	state.registry.Lock()
+	delete(state.registry.entries, state)
+	state.registry.Unlock()
+	state.lock.Lock()
+	for ch := range state.watchers {
+		close(ch)
+	}
+	state.watchers = make(map[chan TopicChange]struct{})
+	state.lock.Unlock()
+}
+
+// changed records a change. size is the size of the json encoding of the
+// value for a Modify.
+func (state *topicState) changed(op Operation, key string, size int) {
+	if state == nil {
+		return
+	}
+	now := time.Now()
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	state.lastChange = now
+	state.seq++
+	switch op {
+	case Modify:
+		state.itemChanges[key] = itemChange{seq: state.seq, size: size, time: now}
+	case Delete:
+		delete(state.itemChanges, key)
+	}
+	if len(state.watchers) == 0 {
+		return
+	}
+	change := TopicChange{
+		Time:      now,
+		Seq:       state.seq,
+		Kind:      state.kind,
+		Agent:     state.agent,
+		Name:      state.name,
+		Operation: op.String(),
+		Key:       key,
+		Size:      size,
+	}
+	for ch := range state.watchers {
+		select {
+		case ch <- change:
+		default:
+			// Do not slow down the agent for a slow watcher
+			state.dropped++
+		}
+	}
+}
+
+// restarted records a new restart counter
+func (state *topicState) restarted(restartCounter int) {
+	if state == nil {
+		return
+	}
+	state.lock.Lock()
+	state.restartCounter = restartCounter
+	state.lock.Unlock()
+	state.changed(Restart, strconv.Itoa(restartCounter), 0)
+}
+
+// coalesced records a change which will not be published
+func (state *topicState) coalesced() {
+	if state == nil {
+		return
+	}
+	state.lock.Lock()
+	state.coalescedCount++
+	state.lock.Unlock()
+}
+
+// setSynchronized records a change of the synchronized flag
+func (state *topicState) setSynchronized(synchronized bool) {
+	if state == nil {
+		return
+	}
+	state.lock.Lock()
+	state.synchronized = synchronized
+	state.lock.Unlock()
+	state.changed(Sync, strconv.FormatBool(synchronized), 0)
+}
+
+func (state *topicState) info() TopicInfo {
+	keys := 0
+	state.km.key.Range(func(key string, val interface{}) bool {
+		keys++
+		return true
+	})
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	return TopicInfo{
+		Kind:           state.kind,
+		Agent:          state.agent,
+		Name:           state.name,
+		Topic:          state.topic,
+		Persistent:     state.persistent,
+		Keys:           keys,
+		RestartCounter: state.restartCounter,
+		Synchronized:   state.synchronized,
+		LastChange:     state.lastChange,
+		Coalesced:      state.coalescedCount,
+	}
+}
+
+// items returns the current items. The values are not copied since they
+// are replaced rather than modified by the publication or subscription.
+func (state *topicState) items() map[string]interface{} {
+	items := make(map[string]interface{})
+	state.km.key.Range(func(key string, val interface{}) bool {
+		items[key] = val
+		return true
+	})
+	return items
+}
+
+// itemInfos returns the ItemInfo of the current items sorted by key.
+// The size of the items which did not change since the start is computed
+// from their value.
+func (state *topicState) itemInfos() []ItemInfo {
+	infos := []ItemInfo{}
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	state.km.key.Range(func(key string, val interface{}) bool {
+		info := ItemInfo{Key: key}
+		if change, ok := state.itemChanges[key]; ok {
+			info.Size = change.size
+			info.Seq = change.seq
+			info.LastChange = change.time
+		} else if b, err := json.Marshal(val); err == nil {
+			info.Size = len(b)
+		}
+		infos = append(infos, info)
+		return true
+	})
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Key < infos[j].Key
+	})
+	return infos
+}
+
+func (state *topicState) watch() chan TopicChange {
+	ch := make(chan TopicChange, watchBufferSize)
+	state.lock.Lock()
+	state.watchers[ch] = struct{}{}
+	state.lock.Unlock()
+	return ch
+}
+
+func (state *topicState) unwatch(ch chan TopicChange) {
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	if _, ok := state.watchers[ch]; ok {
+		delete(state.watchers, ch)
+		close(ch)
+	}
+	if state.dropped != 0 {
+		state.log.Warnf("unwatch(%s): dropped %d changes for slow watchers",
+			state.name, state.dropped)
+		state.dropped = 0
+	}
+}
+
+// match returns the states matching the non-empty filters, sorted by
+// name, kind and agent
+func (r *Registry) match(agent, name, kind string) []*topicState {
+	r.Lock()
+	var states []*topicState
+	for state := range r.entries {
+		if (agent == "" || state.agent == agent) &&
+			(name == "" || state.name == name) &&
+			(kind == "" || state.kind == kind) {
+			states = append(states, state)
+		}
+	}
+	r.Unlock()
+	sort.Slice(states, func(i, j int) bool {
+		if states[i].name != states[j].name {
+			return states[i].name < states[j].name
+		}
+		if states[i].kind != states[j].kind {
+			return states[i].kind < states[j].kind
+		}
+		return states[i].agent < states[j].agent
+	})
+	return states
+}
+
+// Topics returns the publications and subscriptions matching the non-empty
+// filters
+func (r *Registry) Topics(agent, name, kind string) []TopicInfo {
+	var infos []TopicInfo
+	for _, state := range r.match(agent, name, kind) {
+		infos = append(infos, state.info())
+	}
+	return infos
+}
+
+// Items returns the items of the publication name i.e. agent/topic or
+// agent/scope/topic, or nil if there is no such publication.
+// The items must not be modified.
+func (r *Registry) Items(name string) map[string]interface{} {
+	states := r.match("", name, KindPublication)
+	if len(states) == 0 {
+		return nil
+	}
+	return states[0].items()
+}
+
+// ServeHTTP serves the introspection endpoints
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "read-only", http.StatusMethodNotAllowed)
+		return
+	}
+	query := req.URL.Query()
+	agent := query.Get("agent")
+	name := query.Get("name")
+	kind := query.Get("kind")
+	switch req.URL.Path {
+	case "/topics":
+		writeJSON(w, r.Topics(agent, name, kind))
+	case "/items":
+		states := r.match(agent, name, kind)
+		if name == "" || len(states) == 0 {
+			http.Error(w, "no such name", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, states[0].itemInfos())
+	case "/stream":
+		states := r.match(agent, name, kind)
+		if name == "" || len(states) == 0 {
+			http.Error(w, "no such name", http.StatusNotFound)
+			return
+		}
+		r.stream(w, req, states)
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+// stream sends the changes of the states until the client disconnects or
+// the states are unregistered
+func (r *Registry) stream(w http.ResponseWriter, req *http.Request, states []*topicState) {
+	merged := make(chan TopicChange, watchBufferSize)
+	var wg sync.WaitGroup
+	for _, state := range states {
+		ch := state.watch()
+		defer state.unwatch(ch)
+		wg.Add(1)
+		go func(ch chan TopicChange) {
+			defer wg.Done()
+			for change := range ch {
+				select {
+				case merged <- change:
+				case <-req.Context().Done():
+					return
+				}
+			}
+		}(ch)
+	}
+	go func() {
+		wg.Wait()
+		close(merged)
+	}()
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	if flusher != nil {
+		flusher.Flush()
+	}
+	enc := json.NewEncoder(w)
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case change, ok := <-merged:
+			if !ok {
+				return
+			}
+			if err := enc.Encode(change); err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
@@ -0,0 +1,120 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package pubsub
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lf-edge/eve/pkg/pillar/base"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry(t *testing.T) {
+	logger := logrus.StandardLogger()
+	log := base.NewSourceLogObject(logger, "test", 1234)
+	registry := NewRegistry()
+
+	pubPs := New(&EmptyDriver{}, logger, log)
+	pubPs.SetRegistry(registry, agentName)
+	pub, err := pubPs.NewPublication(PublicationOptions{
+		AgentName: agentName,
+		TopicType: typedItem{},
+	})
+	assert.NoError(t, err)
+
+	subPs := New(&EmptyDriver{}, logger, log)
+	subPs.SetRegistry(registry, "watcher")
+	sub, err := subPs.NewSubscription(SubscriptionOptions{
+		AgentName: agentName,
+		TopicImpl: typedItem{},
+		Activate:  true,
+	})
+	assert.NoError(t, err)
+
+	assert.NoError(t, pub.Publish("one", typedItem{Name: "one"}))
+	assert.NoError(t, pub.SignalRestarted())
+	b, err := json.Marshal(typedItem{Name: "two"})
+	assert.NoError(t, err)
+	sub.ProcessChange(Change{Operation: Modify, Key: "two", Value: b})
+	sub.ProcessChange(Change{Operation: Sync})
+
+	name := agentName + "/typedItem"
+	topics := registry.Topics("", name, "")
+	assert.Len(t, topics, 2)
+	for _, info := range topics {
+		assert.False(t, info.LastChange.IsZero())
+		info.LastChange = time.Time{}
+		switch info.Kind {
+		case KindPublication:
+			assert.Equal(t, TopicInfo{Kind: KindPublication, Agent: agentName,
+				Name: name, Topic: "typedItem", Keys: 1, RestartCounter: 1,
+				Synchronized: true}, info)
+		case KindSubscription:
+			assert.Equal(t, TopicInfo{Kind: KindSubscription, Agent: "watcher",
+				Name: name, Topic: "typedItem", Keys: 1, Synchronized: true}, info)
+		}
+	}
+
+	server := httptest.NewServer(registry)
+	defer server.Close()
+	get := func(path string, v interface{}) {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(v))
+	}
+	topics = nil
+	get("/topics?agent=watcher", &topics)
+	assert.Len(t, topics, 1)
+	assert.Equal(t, KindSubscription, topics[0].Kind)
+	var items []ItemInfo
+	get("/items?kind=publication&name="+name, &items)
+	if assert.Len(t, items, 1) {
+		assert.Equal(t, "one", items[0].Key)
+		assert.Equal(t, len(`{"Name":"one","Count":0}`), items[0].Size)
+		assert.NotZero(t, items[0].Seq)
+		assert.False(t, items[0].LastChange.IsZero())
+	}
+
+	resp, err := http.Get(server.URL + "/stream?kind=publication&name=" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	// The watcher is registered once the headers are sent
+	assert.NoError(t, pub.Publish("three", typedItem{Name: "three"}))
+	assert.NoError(t, pub.Unpublish("one"))
+	reader := bufio.NewReader(resp.Body)
+	var changes []TopicChange
+	for len(changes) < 2 {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The values are never served
+		assert.NotContains(t, string(line), `"Count"`)
+		var change TopicChange
+		assert.NoError(t, json.Unmarshal(line, &change))
+		changes = append(changes, change)
+	}
+	assert.Equal(t, "modify", changes[0].Operation)
+	assert.Equal(t, "three", changes[0].Key)
+	assert.Equal(t, len(`{"Name":"three","Count":0}`), changes[0].Size)
+	assert.Equal(t, "delete", changes[1].Operation)
+	assert.Equal(t, "one", changes[1].Key)
+	assert.Equal(t, changes[0].Seq+1, changes[1].Seq)
+
+	sub.Close()
+	pub.Close()
+	assert.Empty(t, registry.Topics("", "", ""))
+}
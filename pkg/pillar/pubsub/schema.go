@@ -0,0 +1,192 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package pubsub
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/lf-edge/eve/pkg/pillar/base"
+)
+
+// Schema versioning of persisted items.
+// The drivers stamp each item they persist with the name of the topic and
+// the current schema version of the topic, using StampSchema. When the
+// items are loaded back, possibly by a newer EVE release, the items with
+// an older version are passed through the migrations registered for the
+// topic before being parsed.
+// Items persisted before the stamping was introduced are version 1.
+//
+// Changing the json of a persisted type hence goes with registering
+// a migration for it, typically from an init() in the package defining
+// the type:
+//
+//	func init() {
+//		pubsub.RegisterSchemaMigration(VolumeStatus{}, 1,
+//			func(item []byte) ([]byte, error) {
+//				// rename a field, set a new one from the old ones, ...
+//			})
+//	}
+//
+// The migration of version N converts the json of version N to N+1;
+// the current version of a topic is one more than the highest registered
+// migration.
+
+const (
+	// SchemaTypeField is the json field holding the topic of a persisted item
+	SchemaTypeField = "pubsub-schema-type"
+	// SchemaVersionField is the json field holding the schema version of
+	// a persisted item
+	SchemaVersionField = "pubsub-schema-version"
+)
+
+// SchemaMigration converts the json of an item from one schema version
+// to the next one
+type SchemaMigration func(item []byte) ([]byte, error)
+
+var schemaRegistry = struct {
+	sync.RWMutex
+	migrations map[string]map[int]SchemaMigration
+}{migrations: make(map[string]map[int]SchemaMigration)}
+
+// RegisterSchemaMigration registers the migration of the items of
+// topicType from version to version+1. Versions start at 1.
+// Panics on a duplicate registration since that is a programming error.
+func RegisterSchemaMigration(topicType interface{}, version int, migrate SchemaMigration) {
+	topic := TypeToName(topicType)
+	if version < 1 {
+		panic(fmt.Sprintf("RegisterSchemaMigration(%s): bad version %d",
+			topic, version))
+	}
+	schemaRegistry.Lock()
+	defer schemaRegistry.Unlock()
+	migrations, ok := schemaRegistry.migrations[topic]
+	if !ok {
+		migrations = make(map[int]SchemaMigration)
+		schemaRegistry.migrations[topic] = migrations
+	}
+	if _, ok := migrations[version]; ok {
+		panic(fmt.Sprintf("RegisterSchemaMigration(%s): duplicate version %d",
+			topic, version))
+	}
+	migrations[version] = migrate
+}
+
+// SchemaVersion returns the current schema version of the topic
+func SchemaVersion(topic string) int {
+	schemaRegistry.RLock()
+	defer schemaRegistry.RUnlock()
+	version := 1
+	for from := range schemaRegistry.migrations[topic] {
+		if from+1 > version {
+			version = from + 1
+		}
+	}
+	return version
+}
+
+// StampSchema returns the json object item with the topic and its current
+// schema version added. Anything else than a json object is returned as is.
+func StampSchema(topic string, item []byte) []byte {
+	return stampSchema(topic, item, SchemaVersion(topic))
+}
+
+// StampLegacySchema returns the json object item as is if it is already
+// stamped, and otherwise stamped with version 1 which is the version of the
+// items persisted before the stamping was introduced. It is used when
+// importing items persisted by another driver or release, which still have
+// to go through the migrations when they are loaded.
+func StampLegacySchema(topic string, item []byte) []byte {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(item, &fields); err != nil {
+		return item
+	}
+	if _, ok := fields[SchemaVersionField]; ok {
+		return item
+	}
+	return stampSchema(topic, item, 1)
+}
+
+func stampSchema(topic string, item []byte, version int) []byte {
+	item = bytes.TrimSpace(item)
+	if len(item) < 2 || item[0] != '{' {
+		return item
+	}
+	topicB, err := json.Marshal(topic)
+	if err != nil {
+		return item
+	}
+	var buf bytes.Buffer
+	buf.Grow(len(item) + len(SchemaTypeField) + len(SchemaVersionField) +
+		len(topicB) + 16)
+	fmt.Fprintf(&buf, `{"%s":%s,"%s":%d`, SchemaTypeField, topicB,
+		SchemaVersionField, version)
+	rest := bytes.TrimSpace(item[1:])
+	if len(rest) != 0 && rest[0] != '}' {
+		buf.WriteByte(',')
+	}
+	buf.Write(rest)
+	return buf.Bytes()
+}
+
+// migrateSchema runs the migrations needed to bring a loaded item of the
+// topic to its current schema version. Items of an unknown, newer version
+// e.g., after an EVE downgrade, are returned as is.
+func migrateSchema(log *base.LogObject, topic string, key string, item []byte) ([]byte, error) {
+	current := SchemaVersion(topic)
+	if current == 1 {
+		// Nothing to migrate; the stamp is ignored when parsing
+		return item, nil
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(item, &fields); err != nil {
+		// Let parsing report the problem
+		return item, nil
+	}
+	version := 1
+	if raw, ok := fields[SchemaVersionField]; ok {
+		v, err := strconv.Atoi(string(raw))
+		if err != nil || v < 1 {
+			return nil, fmt.Errorf("migrateSchema(%s/%s): bad version %s",
+				topic, key, raw)
+		}
+		version = v
+	}
+	if version == current {
+		return item, nil
+	}
+	if version > current {
+		log.Warnf("migrateSchema(%s/%s): version %d is newer than %d",
+			topic, key, version, current)
+		return item, nil
+	}
+	schemaRegistry.RLock()
+	migrations := schemaRegistry.migrations[topic]
+	schemaRegistry.RUnlock()
+	// Drop the stamp so that the migrations only see the item itself
+	delete(fields, SchemaTypeField)
+	delete(fields, SchemaVersionField)
+	item, err := json.Marshal(fields)
+	if err != nil {
+		return nil, fmt.Errorf("migrateSchema(%s/%s): %w", topic, key, err)
+	}
+	for ; version < current; version++ {
+		migrate, ok := migrations[version]
+		if !ok {
+			return nil, fmt.Errorf("migrateSchema(%s/%s): no migration from version %d",
+				topic, key, version)
+		}
+		item, err = migrate(item)
+		if err != nil {
+			return nil, fmt.Errorf("migrateSchema(%s/%s): from version %d: %w",
+				topic, key, version, err)
+		}
+		log.Functionf("migrateSchema(%s/%s): migrated to version %d",
+			topic, key, version+1)
+	}
+	return item, nil
+}
@@ -0,0 +1,158 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package pubsub
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/lf-edge/eve/pkg/pillar/base"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// schemaItem is at version 3: version 1 had Size in KBytes, version 2
+// renamed Size to MaxVolSize
+type schemaItem struct {
+	Name       string
+	MaxVolSize uint64
+}
+
+func init() {
+	RegisterSchemaMigration(schemaItem{}, 1, func(item []byte) ([]byte, error) {
+		var fields map[string]interface{}
+		if err := json.Unmarshal(item, &fields); err != nil {
+			return nil, err
+		}
+		size, ok := fields["Size"].(float64)
+		if !ok {
+			return nil, fmt.Errorf("no Size")
+		}
+		fields["Size"] = size * 1024
+		return json.Marshal(fields)
+	})
+	RegisterSchemaMigration(schemaItem{}, 2, func(item []byte) ([]byte, error) {
+		var fields map[string]interface{}
+		if err := json.Unmarshal(item, &fields); err != nil {
+			return nil, err
+		}
+		fields["MaxVolSize"] = fields["Size"]
+		delete(fields, "Size")
+		return json.Marshal(fields)
+	})
+}
+
+// loadDriver returns the same items from the Load of its publishers and
+// subscribers
+type loadDriver struct {
+	EmptyDriver
+	items map[string][]byte
+}
+
+type loadPublisher struct {
+	EmptyDriverPublisher
+	items map[string][]byte
+}
+
+type loadSubscriber struct {
+	EmptyDriverSubscriber
+	items map[string][]byte
+}
+
+func (d *loadDriver) Publisher(global bool, name, topic string, persistent bool, updaterList *Updaters, restarted Restarted, differ Differ) (DriverPublisher, error) {
+	return &loadPublisher{items: d.items}, nil
+}
+
+func (d *loadDriver) Subscriber(global bool, name, topic string, persistent bool, C chan Change) (DriverSubscriber, error) {
+	return &loadSubscriber{items: d.items}, nil
+}
+
+func (p *loadPublisher) Load() (map[string][]byte, int, error) {
+	return p.items, 0, nil
+}
+
+func (s *loadSubscriber) Load() (map[string][]byte, int, error) {
+	return s.items, 0, nil
+}
+
+func TestStampSchema(t *testing.T) {
+	topic := TypeToName(schemaItem{})
+	assert.Equal(t, 3, SchemaVersion(topic))
+	assert.Equal(t, 1, SchemaVersion("unknownTopic"))
+
+	b, err := json.Marshal(schemaItem{Name: "one", MaxVolSize: 10})
+	assert.NoError(t, err)
+	stamped := StampSchema(topic, b)
+	var fields map[string]interface{}
+	assert.NoError(t, json.Unmarshal(stamped, &fields))
+	assert.Equal(t, map[string]interface{}{
+		SchemaTypeField:    topic,
+		SchemaVersionField: float64(3),
+		"Name":             "one",
+		"MaxVolSize":       float64(10),
+	}, fields)
+	var item schemaItem
+	assert.NoError(t, json.Unmarshal(stamped, &item))
+	assert.Equal(t, schemaItem{Name: "one", MaxVolSize: 10}, item)
+
+	assert.Equal(t, fmt.Sprintf(`{"%s":"empty","%s":1}`,
+		SchemaTypeField, SchemaVersionField),
+		string(StampSchema("empty", []byte("{ }"))))
+	assert.Equal(t, `"string"`, string(StampSchema("string", []byte(`"string"`))))
+
+	// Legacy items are stamped as version 1, stamped ones are left as is
+	legacy := StampLegacySchema(topic, b)
+	assert.NoError(t, json.Unmarshal(legacy, &fields))
+	assert.Equal(t, float64(1), fields[SchemaVersionField])
+	assert.Equal(t, stamped, StampLegacySchema(topic, stamped))
+}
+
+func TestSchemaMigration(t *testing.T) {
+	logger := logrus.StandardLogger()
+	log := base.NewSourceLogObject(logger, "test", 1234)
+	topic := TypeToName(schemaItem{})
+
+	items := map[string][]byte{
+		// before versioning
+		"v1": []byte(`{"Name":"v1","Size":2}`),
+		"v2": []byte(fmt.Sprintf(`{"%s":"%s","%s":2,"Name":"v2","Size":4096}`,
+			SchemaTypeField, topic, SchemaVersionField)),
+		"v3": StampSchema(topic, []byte(`{"Name":"v3","MaxVolSize":8192}`)),
+		// from a newer release; used as is
+		"v4": []byte(fmt.Sprintf(`{"%s":"%s","%s":4,"Name":"v4","MaxVolSize":1}`,
+			SchemaTypeField, topic, SchemaVersionField)),
+		// failing migration; dropped
+		"bad": []byte(`{"Name":"bad"}`),
+	}
+	expected := map[string]interface{}{
+		"v1": schemaItem{Name: "v1", MaxVolSize: 2048},
+		"v2": schemaItem{Name: "v2", MaxVolSize: 4096},
+		"v3": schemaItem{Name: "v3", MaxVolSize: 8192},
+		"v4": schemaItem{Name: "v4", MaxVolSize: 1},
+	}
+
+	ps := New(&loadDriver{items: items}, logger, log)
+	pub, err := ps.NewPublication(PublicationOptions{
+		AgentName:  agentName,
+		TopicType:  schemaItem{},
+		Persistent: true,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, expected, pub.GetAll())
+
+	var created []string
+	sub, err := ps.NewSubscription(SubscriptionOptions{
+		AgentName:  agentName,
+		TopicImpl:  schemaItem{},
+		Persistent: true,
+		Activate:   true,
+		CreateHandler: func(ctx interface{}, key string, status interface{}) {
+			created = append(created, key)
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, expected, sub.GetAll())
+	assert.ElementsMatch(t, []string{"v1", "v2", "v3", "v4"}, created)
+}
@@ -259,12 +259,13 @@ func GetBuffer() ([]byte, func()) {
 var lastLoggedAllocated uint32
 
 func maybeLogAllocated(log *base.LogObject) {
-	if lastLoggedAllocated == allocated {
+	current := atomic.LoadUint32(&allocated)
+	last := atomic.SwapUint32(&lastLoggedAllocated, current)
+	if last == current {
 		return
 	}
 	log.Functionf("pubsub buffer allocation changed from %d to  %d",
-		lastLoggedAllocated, allocated)
-	lastLoggedAllocated = allocated
+		last, current)
 }
 
 // Poll to check if we should go away
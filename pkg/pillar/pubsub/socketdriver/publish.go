@@ -60,6 +60,7 @@ func (s *Publisher) Publish(key string, item []byte) error {
 
 	var err error
 	if s.persistent {
+		item = pubsub.StampSchema(s.topic, item)
 		err = fileutils.WriteRenameWithBackup(fileName, item)
 	} else {
 		err = fileutils.WriteRename(fileName, item)
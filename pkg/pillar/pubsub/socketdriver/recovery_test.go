@@ -92,7 +92,9 @@ func TestRecovery(t *testing.T) {
 	}
 	assert.Len(t, items, 1)
 	assert.Contains(t, items, "global")
-	assert.Equal(t, items["global"], []byte(`{"field":"123456"}`))
+	// Persisted items are stamped with their schema version
+	assert.Equal(t, items["global"],
+		pubsub.StampSchema("item", []byte(`{"field":"123456"}`)))
 
 	// Simulate reboot and the persisted file getting lost.
 	err = os.Remove(filePath)
@@ -111,7 +113,8 @@ func TestRecovery(t *testing.T) {
 	}
 	assert.Len(t, items, 1)
 	assert.Contains(t, items, "global")
-	assert.Equal(t, items["global"], []byte(`{"field":"abcdef"}`))
+	assert.Equal(t, items["global"],
+		pubsub.StampSchema("item", []byte(`{"field":"abcdef"}`)))
 
 	// Simulate reboot and the persisted file getting emptied.
 	err = os.WriteFile(filePath, nil, file.Mode())
@@ -130,7 +133,8 @@ func TestRecovery(t *testing.T) {
 	}
 	assert.Len(t, items, 1)
 	assert.Contains(t, items, "global")
-	assert.Equal(t, items["global"], []byte(`{"field":"abcdef"}`))
+	assert.Equal(t, items["global"],
+		pubsub.StampSchema("item", []byte(`{"field":"abcdef"}`)))
 
 	// Un-publish - backup file should be also removed.
 	err = publisher.Unpublish("global")
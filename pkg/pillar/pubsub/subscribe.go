@@ -41,6 +41,7 @@ type SubscriptionImpl struct {
 	log          *base.LogObject
 	myAgentName  string // For logging
 	ps           *PubSub
+	state        *topicState // Set if there is a Registry
 }
 
 // MsgChan return the Message Channel for the Subscription.
@@ -67,6 +68,7 @@ func (sub *SubscriptionImpl) Close() error {
 	}
 	handleRestart(sub, 0)
 	handleSynchronized(sub, false)
+	sub.state.unregister()
 	return nil
 }
 
@@ -94,6 +96,11 @@ func (sub *SubscriptionImpl) populate() {
 	}
 	for key, itemB := range pairs {
 		sub.log.Functionf("populate(%s) key %s", name, key)
+		itemB, err = migrateSchema(sub.log, sub.topic, key, itemB)
+		if err != nil {
+			sub.log.Error(err)
+			continue
+		}
 		handleModify(sub, key, itemB)
 	}
 	if restartCounter != 0 {
@@ -111,6 +118,9 @@ func (sub *SubscriptionImpl) populate() {
 func (sub *SubscriptionImpl) ProcessChange(change Change) {
 	start := time.Now()
 	sub.log.Tracef("ProcessChange agentName(%s) agentScope(%s) topic(%s): %#v", sub.agentName, sub.agentScope, sub.topic, change)
+	if observer, ok := sub.driver.(DriverChangeObserver); ok {
+		observer.Processing(change)
+	}
 
 	switch change.Operation {
 	case Restart:
@@ -259,6 +269,7 @@ func handleModify(ctxArg interface{}, key string, itemcb []byte) {
 		}
 	}
 	sub.km.key.Store(key, item)
+	sub.state.changed(Modify, key, len(itemcb))
 	if sub.logger.GetLevel() == logrus.TraceLevel {
 		sub.dump("after handleModify")
 	}
@@ -294,6 +305,7 @@ func handleDelete(ctxArg interface{}, key string) {
 	// DO NOT log Values. They may contain sensitive information.
 	sub.log.Tracef("pubsub.handleDelete(%s) key %s", name, key)
 	sub.km.key.Delete(key)
+	sub.state.changed(Delete, key, 0)
 	if sub.logger.GetLevel() == logrus.TraceLevel {
 		sub.dump("after handleDelete")
 	}
@@ -313,6 +325,7 @@ func handleRestart(ctxArg interface{}, restartCounter int) {
 		return
 	}
 	sub.km.restartCounter = restartCounter
+	sub.state.restarted(restartCounter)
 	if sub.RestartHandler != nil {
 		(sub.RestartHandler)(sub.userCtx, restartCounter)
 	}
@@ -329,6 +342,7 @@ func handleSynchronized(ctxArg interface{}, synchronized bool) {
 		return
 	}
 	sub.synchronized = synchronized
+	sub.state.setSynchronized(synchronized)
 	if sub.SynchronizedHandler != nil {
 		(sub.SynchronizedHandler)(sub.userCtx, synchronized)
 	}
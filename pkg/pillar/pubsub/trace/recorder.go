@@ -0,0 +1,155 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package trace
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/lf-edge/eve/pkg/pillar/base"
+	"github.com/lf-edge/eve/pkg/pillar/pubsub"
+)
+
+// Prefixes pubsub adds to the json names of the fields kept in files
+var largeFieldPrefixes = []string{"pubsub-large-", "pubsub-file-"}
+
+// RecordingDriver wraps a pubsub.Driver and records the changes processed
+// by the subscriptions to the topics in Topics to Writer, in the order the
+// agent handles them. The other subscriptions and the publications are
+// passed through. Close closes the Writer once the agent is done.
+// The fields named in RedactFields are recorded as null, at any depth of
+// the values and whether or not pubsub keeps them in files; the values are
+// recorded as they are otherwise, hence every field carrying credentials
+// or other sensitive information in Topics must be named there.
+type RecordingDriver struct {
+	pubsub.Driver
+	Writer       *Writer
+	Topics       map[string]bool
+	RedactFields map[string]bool
+	Log          *base.LogObject
+
+	writeFailed atomic.Bool
+}
+
+// Close the Writer; the changes processed afterwards are not recorded
+func (d *RecordingDriver) Close() error {
+	return d.Writer.Close()
+}
+
+// Subscriber return a `pubsub.DriverSubscriber` from the wrapped driver
+// with its changes recorded if the topic is in Topics
+func (d *RecordingDriver) Subscriber(global bool, name, topic string, persistent bool, C chan pubsub.Change) (pubsub.DriverSubscriber, error) {
+	sub, err := d.Driver.Subscriber(global, name, topic, persistent, C)
+	if err != nil || !d.Topics[topic] {
+		return sub, err
+	}
+	return &RecordingSubscriber{
+		DriverSubscriber: sub,
+		name:             name,
+		driver:           d,
+	}, nil
+}
+
+// RecordingSubscriber implementation of `pubsub.DriverSubscriber` which
+// records what the agent processes from the wrapped subscriber.
+type RecordingSubscriber struct {
+	pubsub.DriverSubscriber
+	name   string
+	driver *RecordingDriver
+}
+
+// Load from the wrapped subscriber and record the result
+func (s *RecordingSubscriber) Load() (map[string][]byte, int, error) {
+	items, restartCounter, err := s.DriverSubscriber.Load()
+	if err != nil {
+		return items, restartCounter, err
+	}
+	now := time.Now()
+	for key, val := range items {
+		s.record(Record{Time: now, Name: s.name, Operation: pubsub.Modify,
+			Key: key, Value: val, Loaded: true})
+	}
+	if restartCounter != 0 {
+		s.record(Record{Time: now, Name: s.name, Operation: pubsub.Restart,
+			Key: strconv.Itoa(restartCounter), Loaded: true})
+	}
+	return items, restartCounter, nil
+}
+
+// Processing records the change the agent is about to handle. It is called
+// from the loop of the agent, hence the records of all the subscriptions
+// are in the order of the handlers.
+func (s *RecordingSubscriber) Processing(change pubsub.Change) {
+	s.record(Record{Time: time.Now(), Name: s.name,
+		Operation: change.Operation, Key: change.Key, Value: change.Value})
+}
+
+func (s *RecordingSubscriber) record(rec Record) {
+	d := s.driver
+	if len(rec.Value) != 0 && len(d.RedactFields) != 0 {
+		value, err := redactValue(rec.Value, d.RedactFields)
+		if err != nil {
+			// Never record a value which could not be redacted
+			d.Log.Errorf("record(%s): not recording %s: %v",
+				s.name, rec.Key, err)
+			return
+		}
+		rec.Value = value
+	}
+	if err := d.Writer.Write(rec); err != nil {
+		// Keep the agent going; the trace is incomplete from here on
+		if d.writeFailed.CompareAndSwap(false, true) {
+			d.Log.Errorf("record(%s): the trace is incomplete from here on: %v",
+				s.name, err)
+		} else {
+			d.Log.Tracef("record(%s): %v", s.name, err)
+		}
+	}
+}
+
+// redactValue returns the json value with the fields named in fields set
+// to null, at any depth
+func redactValue(value []byte, fields map[string]bool) ([]byte, error) {
+	var tree interface{}
+	dec := json.NewDecoder(bytes.NewReader(value))
+	// Keep the numbers as they are
+	dec.UseNumber()
+	if err := dec.Decode(&tree); err != nil {
+		return nil, err
+	}
+	redactTree(tree, fields)
+	return json.Marshal(tree)
+}
+
+func redactTree(tree interface{}, fields map[string]bool) {
+	switch tree := tree.(type) {
+	case map[string]interface{}:
+		for name, value := range tree {
+			if fields[fieldName(name)] {
+				tree[name] = nil
+			} else {
+				redactTree(value, fields)
+			}
+		}
+	case []interface{}:
+		for _, value := range tree {
+			redactTree(value, fields)
+		}
+	}
+}
+
+// fieldName returns the name of the field without the prefix pubsub adds
+// if it keeps the field in a file
+func fieldName(name string) string {
+	for _, prefix := range largeFieldPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return strings.TrimPrefix(name, prefix)
+		}
+	}
+	return name
+}
@@ -0,0 +1,277 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package trace
+
+import (
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lf-edge/eve/pkg/pillar/base"
+	"github.com/lf-edge/eve/pkg/pillar/pubsub"
+	logutils "github.com/lf-edge/eve/pkg/pillar/utils/logging"
+)
+
+const defaultWaitTimeout = 10 * time.Second
+
+// ReplayDriver implementation of `pubsub.Driver` which feeds the records
+// of a trace into the subscriptions of the agent using it.
+// The records are delivered one at a time in the recorded order: the next
+// record is sent only once the agent has taken the previous one from
+// its subscription channel. Since agents process changes from a single
+// loop, the handlers run in the same order as when recording.
+// Items published by the agent are kept in memory; see Published.
+// Note that fields which socketdriver moved to separate files as pubsub
+// "large" are replayed as the file references that were recorded.
+type ReplayDriver struct {
+	// Paced makes the replay wait for the recorded time between
+	// records; otherwise they are sent as fast as the agent takes them.
+	Paced bool
+	// WaitTimeout is how long to wait for the agent to start the
+	// subscription a record is for before dropping the record.
+	WaitTimeout time.Duration
+
+	records     []Record
+	log         *base.LogObject
+	lock        sync.Mutex
+	subscribers map[string]*ReplaySubscriber
+	startChans  map[string]chan struct{}
+	published   map[string]map[string][]byte
+	feeding     bool
+	doneChan    chan struct{}
+}
+
+// NewReplayDriver returns a ReplayDriver for the records
+func NewReplayDriver(log *base.LogObject, records []Record) *ReplayDriver {
+	return &ReplayDriver{
+		WaitTimeout: defaultWaitTimeout,
+		records:     records,
+		log:         log,
+		subscribers: make(map[string]*ReplaySubscriber),
+		startChans:  make(map[string]chan struct{}),
+		published:   make(map[string]map[string][]byte),
+		doneChan:    make(chan struct{}),
+	}
+}
+
+// Publisher return an implementation of `pubsub.DriverPublisher` which
+// keeps what is published in memory
+func (d *ReplayDriver) Publisher(global bool, name, topic string, persistent bool, updaterList *pubsub.Updaters, restarted pubsub.Restarted, differ pubsub.Differ) (pubsub.DriverPublisher, error) {
+	d.lock.Lock()
+	if _, ok := d.published[name]; !ok {
+		d.published[name] = make(map[string][]byte)
+	}
+	d.lock.Unlock()
+	return &ReplayPublisher{driver: d, name: name}, nil
+}
+
+// Subscriber return an implementation of `pubsub.DriverSubscriber` which
+// receives the records for name from the trace
+func (d *ReplayDriver) Subscriber(global bool, name, topic string, persistent bool, C chan pubsub.Change) (pubsub.DriverSubscriber, error) {
+	sub := &ReplaySubscriber{
+		driver:   d,
+		name:     name,
+		C:        C,
+		doneChan: make(chan struct{}),
+	}
+	d.lock.Lock()
+	d.subscribers[name] = sub
+	d.lock.Unlock()
+	return sub, nil
+}
+
+// DefaultName default name for an agent when none is provided
+func (d *ReplayDriver) DefaultName() string {
+	return pubsub.Global
+}
+
+// Done returns a channel which is closed once all the records have been
+// taken by the agent, or dropped.
+func (d *ReplayDriver) Done() <-chan struct{} {
+	return d.doneChan
+}
+
+// Published returns a copy of what the agent has currently published for
+// the publication name i.e. agent/topic or agent/scope/topic
+func (d *ReplayDriver) Published(name string) map[string][]byte {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	items := make(map[string][]byte)
+	for key, val := range d.published[name] {
+		items[key] = val
+	}
+	return items
+}
+
+// startChan returns the channel closed when the subscription name starts.
+// Called with the lock held.
+func (d *ReplayDriver) startChan(name string) chan struct{} {
+	ch, ok := d.startChans[name]
+	if !ok {
+		ch = make(chan struct{})
+		d.startChans[name] = ch
+	}
+	return ch
+}
+
+func (d *ReplayDriver) started(sub *ReplaySubscriber) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	startChan := d.startChan(sub.name)
+	select {
+	case <-startChan:
+		// Subscribed again after a Close
+	default:
+		close(startChan)
+	}
+	if !d.feeding {
+		d.feeding = true
+		d.log.Functionf("Creating %s at %s", "d.feed", logutils.GetMyStack())
+		go d.feed()
+	}
+}
+
+func (d *ReplayDriver) feed() {
+	defer close(d.doneChan)
+	var prevTime time.Time
+	for _, rec := range d.records {
+		if rec.Loaded {
+			continue
+		}
+		if d.Paced && !prevTime.IsZero() && rec.Time.After(prevTime) {
+			time.Sleep(rec.Time.Sub(prevTime))
+		}
+		prevTime = rec.Time
+		d.lock.Lock()
+		startChan := d.startChan(rec.Name)
+		d.lock.Unlock()
+		select {
+		case <-startChan:
+		case <-time.After(d.WaitTimeout):
+			d.log.Warnf("feed: dropping %v for %s key %s: not subscribed",
+				rec.Operation, rec.Name, rec.Key)
+			continue
+		}
+		d.lock.Lock()
+		sub := d.subscribers[rec.Name]
+		d.lock.Unlock()
+		sub.deliver(pubsub.Change{Operation: rec.Operation, Key: rec.Key,
+			Value: rec.Value})
+	}
+}
+
+// ReplaySubscriber implementation of `pubsub.DriverSubscriber` for
+// `ReplayDriver`
+type ReplaySubscriber struct {
+	driver   *ReplayDriver
+	name     string
+	C        chan pubsub.Change
+	doneChan chan struct{}
+	stopOnce sync.Once
+}
+
+// Start receiving records
+func (s *ReplaySubscriber) Start() error {
+	s.driver.started(s)
+	return nil
+}
+
+// Load returns the items recorded from the initial Load
+func (s *ReplaySubscriber) Load() (map[string][]byte, int, error) {
+	items := make(map[string][]byte)
+	restartCounter := 0
+	for _, rec := range s.driver.records {
+		if !rec.Loaded || rec.Name != s.name {
+			continue
+		}
+		switch rec.Operation {
+		case pubsub.Modify:
+			items[rec.Key] = rec.Value
+		case pubsub.Restart:
+			restartCounter, _ = strconv.Atoi(rec.Key)
+		}
+	}
+	return items, restartCounter, nil
+}
+
+// Stop receiving records
+func (s *ReplaySubscriber) Stop() error {
+	s.stopOnce.Do(func() { close(s.doneChan) })
+	return nil
+}
+
+// LargeDirName where to put large fields
+func (s *ReplaySubscriber) LargeDirName() string {
+	return os.TempDir()
+}
+
+// deliver sends the change and waits for the agent to take it
+func (s *ReplaySubscriber) deliver(change pubsub.Change) {
+	select {
+	case s.C <- change:
+	case <-s.doneChan:
+		return
+	}
+	for len(s.C) != 0 {
+		select {
+		case <-s.doneChan:
+			return
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
+
+// ReplayPublisher implementation of `pubsub.DriverPublisher` for
+// `ReplayDriver`
+type ReplayPublisher struct {
+	driver *ReplayDriver
+	name   string
+}
+
+// Start function
+func (p *ReplayPublisher) Start() error {
+	return nil
+}
+
+// Load function
+func (p *ReplayPublisher) Load() (map[string][]byte, int, error) {
+	return make(map[string][]byte), 0, nil
+}
+
+// Publish keeps the item in memory
+func (p *ReplayPublisher) Publish(key string, item []byte) error {
+	p.driver.lock.Lock()
+	defer p.driver.lock.Unlock()
+	p.driver.published[p.name][key] = item
+	return nil
+}
+
+// Unpublish removes the item from memory
+func (p *ReplayPublisher) Unpublish(key string) error {
+	p.driver.lock.Lock()
+	defer p.driver.lock.Unlock()
+	delete(p.driver.published[p.name], key)
+	return nil
+}
+
+// Restart function
+func (p *ReplayPublisher) Restart(restartCounter int) error {
+	return nil
+}
+
+// Stop function
+func (p *ReplayPublisher) Stop() error {
+	return nil
+}
+
+// CheckMaxSize function
+func (p *ReplayPublisher) CheckMaxSize(key string, val []byte) error {
+	return nil
+}
+
+// LargeDirName where to put large fields
+func (p *ReplayPublisher) LargeDirName() string {
+	return os.TempDir()
+}
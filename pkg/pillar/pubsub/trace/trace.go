@@ -0,0 +1,161 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package trace records the pubsub changes received by the subscriptions
+// of an agent, and replays such a recording into the agent, e.g. to turn
+// an issue seen on a device into a reproducible unit test.
+//
+// Recording is done by RecordingDriver, which wraps the driver of the
+// agent (usually socketdriver) and writes every change of a subscription
+// to a trace file when the agent processes it. Replaying is done by
+// ReplayDriver, which is passed to pubsub.New instead of the real driver
+// and feeds the changes from the trace into the subscriptions of the
+// agent, in the recorded order.
+package trace
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lf-edge/eve/pkg/pillar/pubsub"
+)
+
+// Record is one change processed by a subscription.
+// A trace file has one json encoded Record per line.
+type Record struct {
+	// Time when the change was processed
+	Time time.Time `json:"t"`
+	// Name of the subscription i.e. agent/topic or agent/scope/topic
+	Name string `json:"n"`
+	// Operation, Key and Value as in pubsub.Change; the value is the json
+	// of the item as is
+	Operation pubsub.Operation `json:"o"`
+	Key       string           `json:"k,omitempty"`
+	Value     json.RawMessage  `json:"v,omitempty"`
+	// Loaded is set for the items returned by the initial Load of
+	// a persistent subscription rather than sent over the channel.
+	// The restart counter from the Load is recorded as a Restart.
+	Loaded bool `json:"l,omitempty"`
+}
+
+// ErrTraceFull is returned once a Writer has reached its maximum size
+var ErrTraceFull = errors.New("trace file full")
+
+// traceFileSuffix is the suffix of the files created by CreateFile
+const traceFileSuffix = ".trace"
+
+// Writer writes records to a trace
+type Writer struct {
+	sync.Mutex
+	w       *bufio.Writer
+	closer  io.Closer
+	size    int64
+	maxSize int64
+	closed  bool
+}
+
+// NewWriter returns a Writer which stops writing after maxSize bytes;
+// zero means no limit. w is closed by Close if it is an io.Closer.
+func NewWriter(w io.Writer, maxSize int64) *Writer {
+	writer := &Writer{w: bufio.NewWriter(w), maxSize: maxSize}
+	if closer, ok := w.(io.Closer); ok {
+		writer.closer = closer
+	}
+	return writer
+}
+
+// Write a record. Each record is flushed to make sure the trace is
+// complete up to the moment an agent crashes.
+func (w *Writer) Write(rec Record) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	w.Lock()
+	defer w.Unlock()
+	if w.closed {
+		return os.ErrClosed
+	}
+	if w.maxSize != 0 && w.size+int64(len(b)) > w.maxSize {
+		return ErrTraceFull
+	}
+	if _, err := w.w.Write(b); err != nil {
+		return err
+	}
+	w.size += int64(len(b))
+	return w.w.Flush()
+}
+
+// Close flushes and closes the underlying writer
+func (w *Writer) Close() error {
+	w.Lock()
+	defer w.Unlock()
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	err := w.w.Flush()
+	if w.closer != nil {
+		if err2 := w.closer.Close(); err == nil {
+			err = err2
+		}
+	}
+	return err
+}
+
+// CreateFile creates a trace file in dir named after the current time, and
+// removes the oldest ones to keep at most maxFiles of them in dir
+func CreateFile(dir string, maxFiles int) (*os.File, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), traceFileSuffix) {
+			names = append(names, entry.Name())
+		}
+	}
+	// The names sort in the order the files were created
+	sort.Strings(names)
+	for len(names) >= maxFiles && len(names) != 0 {
+		if err := os.Remove(filepath.Join(dir, names[0])); err != nil {
+			return nil, err
+		}
+		names = names[1:]
+	}
+	name := time.Now().UTC().Format("20060102T150405") + traceFileSuffix
+	return os.Create(filepath.Join(dir, name))
+}
+
+// ReadTrace reads all the records of a trace
+func ReadTrace(r io.Reader) ([]Record, error) {
+	var records []Record
+	dec := json.NewDecoder(r)
+	for {
+		var rec Record
+		err := dec.Decode(&rec)
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			// The last line can be truncated if the agent crashed
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				return records, nil
+			}
+			return records, fmt.Errorf("ReadTrace: record %d: %w",
+				len(records)+1, err)
+		}
+		records = append(records, rec)
+	}
+}
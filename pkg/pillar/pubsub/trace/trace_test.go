@@ -0,0 +1,264 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package trace_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lf-edge/eve/pkg/pillar/base"
+	"github.com/lf-edge/eve/pkg/pillar/pubsub"
+	"github.com/lf-edge/eve/pkg/pillar/pubsub/trace"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+type itemA struct {
+	Value int
+}
+
+type itemB struct {
+	Value int
+}
+
+// fakeDriver delivers the changes set for a subscription name on Start
+type fakeDriver struct {
+	pubsub.EmptyDriver
+	changes map[string][]pubsub.Change
+	loaded  map[string]map[string][]byte
+}
+
+type fakeSubscriber struct {
+	pubsub.EmptyDriverSubscriber
+	changes []pubsub.Change
+	loaded  map[string][]byte
+	C       chan pubsub.Change
+}
+
+func (d *fakeDriver) Subscriber(global bool, name, topic string, persistent bool, C chan pubsub.Change) (pubsub.DriverSubscriber, error) {
+	return &fakeSubscriber{changes: d.changes[name], loaded: d.loaded[name], C: C}, nil
+}
+
+func (s *fakeSubscriber) Start() error {
+	go func() {
+		for _, change := range s.changes {
+			s.C <- change
+		}
+	}()
+	return nil
+}
+
+func (s *fakeSubscriber) Load() (map[string][]byte, int, error) {
+	return s.loaded, 0, nil
+}
+
+func modify(t *testing.T, key string, item interface{}) pubsub.Change {
+	b, err := json.Marshal(item)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pubsub.Change{Operation: pubsub.Modify, Key: key, Value: b}
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	logger := logrus.StandardLogger()
+	log := base.NewSourceLogObject(logger, "test", 1234)
+
+	// Record
+	var buf bytes.Buffer
+	recorder := &trace.RecordingDriver{
+		Driver: &fakeDriver{
+			changes: map[string][]pubsub.Change{
+				"agentA/itemA": {
+					modify(t, "a1", itemA{1}),
+					{Operation: pubsub.Sync, Key: "done"},
+					modify(t, "a1", itemA{2}),
+				},
+				"agentB/itemB": {
+					modify(t, "b1", itemB{1}),
+					{Operation: pubsub.Delete, Key: "b0"},
+				},
+			},
+			loaded: map[string]map[string][]byte{
+				"agentB/itemB": {"b0": []byte(`{"Value":0}`)},
+			},
+		},
+		Writer: trace.NewWriter(&buf, 0),
+		Topics: map[string]bool{"itemA": true, "itemB": true},
+		Log:    log,
+	}
+	var recorded []string
+	subscribe := func(ps *pubsub.PubSub, agentName string, topic interface{},
+		persistent bool) pubsub.Subscription {
+		sub, err := ps.NewSubscription(pubsub.SubscriptionOptions{
+			AgentName:  agentName,
+			TopicImpl:  topic,
+			Activate:   true,
+			Persistent: persistent,
+			CreateHandler: func(ctx interface{}, key string, status interface{}) {
+				recorded = append(recorded, fmt.Sprintf("create %s %v", key, status))
+			},
+			ModifyHandler: func(ctx interface{}, key string, status, old interface{}) {
+				recorded = append(recorded, fmt.Sprintf("modify %s %v", key, status))
+			},
+			DeleteHandler: func(ctx interface{}, key string, status interface{}) {
+				recorded = append(recorded, fmt.Sprintf("delete %s %v", key, status))
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sub
+	}
+	ps := pubsub.New(recorder, logger, log)
+	subA := subscribe(ps, "agentA", itemA{}, false)
+	subB := subscribe(ps, "agentB", itemB{}, true)
+	for i := 0; i < 5; i++ {
+		select {
+		case change := <-subA.MsgChan():
+			subA.ProcessChange(change)
+		case change := <-subB.MsgChan():
+			subB.ProcessChange(change)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for changes")
+		}
+	}
+	handled := recorded
+	subA.Close()
+	subB.Close()
+
+	// The values are readable in the trace
+	assert.Contains(t, buf.String(), `"v":{"Value":0}`)
+	records, err := trace.ReadTrace(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, records, 6)
+	assert.True(t, records[0].Loaded)
+
+	// Replay; the handlers have to run in the recorded order
+	recorded = nil
+	replay := trace.NewReplayDriver(log, records)
+	ps = pubsub.New(replay, logger, log)
+	subA = subscribe(ps, "agentA", itemA{}, false)
+	subB = subscribe(ps, "agentB", itemB{}, true)
+	done := false
+	for !done {
+		select {
+		case change := <-subA.MsgChan():
+			subA.ProcessChange(change)
+		case change := <-subB.MsgChan():
+			subB.ProcessChange(change)
+		case <-replay.Done():
+			done = true
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for replay")
+		}
+	}
+	assert.Equal(t, handled, recorded)
+}
+
+func TestRecordOnlyTopics(t *testing.T) {
+	logger := logrus.StandardLogger()
+	log := base.NewSourceLogObject(logger, "test", 1234)
+	recorder := &trace.RecordingDriver{
+		Driver: &fakeDriver{},
+		Writer: trace.NewWriter(&bytes.Buffer{}, 0),
+		Topics: map[string]bool{"itemA": true},
+		Log:    log,
+	}
+	C := make(chan pubsub.Change)
+	sub, err := recorder.Subscriber(false, "agentA/itemA", "itemA", false, C)
+	assert.NoError(t, err)
+	assert.IsType(t, &trace.RecordingSubscriber{}, sub)
+	sub, err = recorder.Subscriber(false, "agentB/itemB", "itemB", false, C)
+	assert.NoError(t, err)
+	assert.IsType(t, &fakeSubscriber{}, sub)
+}
+
+func TestCreateFile(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"20230101T000000.trace",
+		"20230102T000000.trace", "20230103T000000.trace", "other"} {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name), nil, 0644))
+	}
+	f, err := trace.CreateFile(dir, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer := trace.NewWriter(f, 0)
+	assert.NoError(t, writer.Write(trace.Record{Name: "agentA/itemA"}))
+	assert.NoError(t, writer.Close())
+	assert.Error(t, writer.Write(trace.Record{Name: "agentA/itemA"}))
+	assert.NoError(t, writer.Close())
+
+	// The oldest traces are removed, the other files are kept
+	matches, err := filepath.Glob(filepath.Join(dir, "*.trace"))
+	assert.NoError(t, err)
+	assert.Len(t, matches, 3)
+	assert.NoFileExists(t, filepath.Join(dir, "20230101T000000.trace"))
+	assert.FileExists(t, filepath.Join(dir, "other"))
+	assert.Contains(t, matches, f.Name())
+}
+
+type secretItem struct {
+	Name      string
+	VncPasswd string
+	UserData  *string `json:"pubsub-large-UserData"`
+	Cipher    struct {
+		CipherData []byte
+		IsCipher   bool
+	}
+	Count uint64
+}
+
+func TestRecordRedacted(t *testing.T) {
+	logger := logrus.StandardLogger()
+	log := base.NewSourceLogObject(logger, "test", 1234)
+	var buf bytes.Buffer
+	recorder := &trace.RecordingDriver{
+		Driver: &fakeDriver{},
+		Writer: trace.NewWriter(&buf, 0),
+		Topics: map[string]bool{"secretItem": true},
+		RedactFields: map[string]bool{"VncPasswd": true, "UserData": true,
+			"CipherData": true},
+		Log: log,
+	}
+	C := make(chan pubsub.Change)
+	sub, err := recorder.Subscriber(false, "agentA/secretItem", "secretItem", false, C)
+	assert.NoError(t, err)
+	userData := "#cloud-config"
+	item := secretItem{Name: "app", VncPasswd: "hunter2", UserData: &userData,
+		Count: 1<<63 + 1}
+	item.Cipher.CipherData = []byte("encrypted")
+	item.Cipher.IsCipher = true
+	sub.(*trace.RecordingSubscriber).Processing(modify(t, "s1", item))
+	// The file reference pubsub records instead of a large field
+	sub.(*trace.RecordingSubscriber).Processing(pubsub.Change{
+		Operation: pubsub.Modify, Key: "s2",
+		Value: []byte(`{"Name":"app","pubsub-file-UserData":"/run/x"}`)})
+
+	assert.NotContains(t, buf.String(), "hunter2")
+	assert.NotContains(t, buf.String(), "cloud-config")
+	assert.NotContains(t, buf.String(), "/run/x")
+	records, err := trace.ReadTrace(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, records, 2)
+	// The redacted values still decode, with the other fields as they were
+	var replayed secretItem
+	assert.NoError(t, json.Unmarshal(records[0].Value, &replayed))
+	assert.Equal(t, "app", replayed.Name)
+	assert.Empty(t, replayed.VncPasswd)
+	assert.Nil(t, replayed.UserData)
+	assert.Nil(t, replayed.Cipher.CipherData)
+	assert.True(t, replayed.Cipher.IsCipher)
+	assert.Equal(t, item.Count, replayed.Count)
+}
@@ -0,0 +1,202 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package pubsub
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Typed wrappers around Publication and Subscription for agents which
+// prefer to deal with their own types rather than with interface{}.
+// The item type is checked against the topic when the publication or the
+// subscription is created, hence the type assertions done by the wrappers
+// cannot fail at runtime.
+//
+// Usage:
+//
+//	sub, err := pubsub.NewTypedSubscription(ps,
+//		pubsub.TypedSubscriptionOptions[types.HostMemory]{
+//			SubscriptionOptions: pubsub.SubscriptionOptions{
+//				AgentName: "domainmgr",
+//				Activate:  true,
+//				Ctx:       &ctx,
+//			},
+//			CreateHandler: handleHostMemoryCreate,
+//		})
+//	...
+//	hostMemory, err := sub.Get("global")
+
+// TypedSubCreateHandler is a handler to handle creates of items of type T
+type TypedSubCreateHandler[T any] func(ctx interface{}, key string, status T)
+
+// TypedSubModifyHandler is a handler for modify notifications of items
+// of type T which carries the oldStatus
+type TypedSubModifyHandler[T any] func(ctx interface{}, key string, status T,
+	oldStatus T)
+
+// TypedSubDeleteHandler is a handler to handle deletes of items of type T
+type TypedSubDeleteHandler[T any] func(ctx interface{}, key string, status T)
+
+// TypedSubscriptionOptions options to pass when creating a TypedSubscription.
+// TopicImpl can be left unset; if set it must be of type T.
+// The untyped item handlers of the embedded SubscriptionOptions must not
+// be set.
+type TypedSubscriptionOptions[T any] struct {
+	SubscriptionOptions
+	CreateHandler TypedSubCreateHandler[T]
+	ModifyHandler TypedSubModifyHandler[T]
+	DeleteHandler TypedSubDeleteHandler[T]
+}
+
+// TypedPublication a Publication of items of type T
+type TypedPublication[T any] struct {
+	Publication
+}
+
+// TypedSubscription a Subscription to items of type T
+type TypedSubscription[T any] struct {
+	Subscription
+}
+
+// checkTopicType returns the type T after making sure topicImpl, if set,
+// is a T and that T can be a topic.
+func checkTopicType[T any](topicImpl interface{}) (reflect.Type, error) {
+	var zero T
+	topicType := reflect.TypeOf(zero)
+	if topicType == nil {
+		return nil, fmt.Errorf("cannot use an interface type as topic")
+	}
+	if topicType.Kind() == reflect.Ptr {
+		return nil, fmt.Errorf("cannot use pointer type %s as topic",
+			topicType)
+	}
+	if topicImpl != nil && reflect.TypeOf(topicImpl) != topicType {
+		return nil, fmt.Errorf("topic type %T does not match %s",
+			topicImpl, topicType)
+	}
+	return topicType, nil
+}
+
+// NewTypedPublication creates a new Publication of items of type T.
+// options.TopicType can be left unset.
+func NewTypedPublication[T any](ps *PubSub, options PublicationOptions) (*TypedPublication[T], error) {
+	if _, err := checkTopicType[T](options.TopicType); err != nil {
+		return nil, fmt.Errorf("NewTypedPublication: %w", err)
+	}
+	var zero T
+	options.TopicType = zero
+	pub, err := ps.NewPublication(options)
+	if err != nil {
+		return nil, err
+	}
+	return &TypedPublication[T]{Publication: pub}, nil
+}
+
+// Publish publish a key-value pair
+func (pub *TypedPublication[T]) Publish(key string, item T) error {
+	return pub.Publication.Publish(key, item)
+}
+
+// PublishBatch publish and unpublish several items
+func (pub *TypedPublication[T]) PublishBatch(items map[string]T, deletes []string) error {
+	untyped := make(map[string]interface{}, len(items))
+	for key, item := range items {
+		untyped[key] = item
+	}
+	return pub.Publication.PublishBatch(untyped, deletes)
+}
+
+// CheckMaxSize returns an error if the item is too large
+func (pub *TypedPublication[T]) CheckMaxSize(key string, item T) error {
+	return pub.Publication.CheckMaxSize(key, item)
+}
+
+// Get the item for a given key
+func (pub *TypedPublication[T]) Get(key string) (T, error) {
+	return typedGet[T](pub.Publication, key)
+}
+
+// GetAll enumerate all the items of the collection
+func (pub *TypedPublication[T]) GetAll() map[string]T {
+	return typedGetAll[T](pub.Publication.GetAll())
+}
+
+// Iterate performs some callback function on all items
+func (pub *TypedPublication[T]) Iterate(function func(key string, item T) bool) {
+	pub.Publication.Iterate(func(key string, val interface{}) bool {
+		return function(key, val.(T))
+	})
+}
+
+// NewTypedSubscription creates a new Subscription to items of type T
+func NewTypedSubscription[T any](ps *PubSub, options TypedSubscriptionOptions[T]) (*TypedSubscription[T], error) {
+	topicType, err := checkTopicType[T](options.TopicImpl)
+	if err != nil {
+		return nil, fmt.Errorf("NewTypedSubscription: %w", err)
+	}
+	untyped := options.SubscriptionOptions
+	if untyped.CreateHandler != nil || untyped.ModifyHandler != nil ||
+		untyped.DeleteHandler != nil {
+		return nil, fmt.Errorf("NewTypedSubscription: untyped handlers set for %s",
+			topicType)
+	}
+	var zero T
+	untyped.TopicImpl = zero
+	if options.CreateHandler != nil {
+		untyped.CreateHandler = func(ctx interface{}, key string, status interface{}) {
+			options.CreateHandler(ctx, key, status.(T))
+		}
+	}
+	if options.ModifyHandler != nil {
+		untyped.ModifyHandler = func(ctx interface{}, key string, status interface{},
+			oldStatus interface{}) {
+			options.ModifyHandler(ctx, key, status.(T), oldStatus.(T))
+		}
+	}
+	if options.DeleteHandler != nil {
+		untyped.DeleteHandler = func(ctx interface{}, key string, status interface{}) {
+			options.DeleteHandler(ctx, key, status.(T))
+		}
+	}
+	sub, err := ps.NewSubscription(untyped)
+	if sub == nil {
+		return nil, err
+	}
+	return &TypedSubscription[T]{Subscription: sub}, err
+}
+
+// Get the item for a given key
+func (sub *TypedSubscription[T]) Get(key string) (T, error) {
+	return typedGet[T](sub.Subscription, key)
+}
+
+// GetAll enumerate all the items of the collection
+func (sub *TypedSubscription[T]) GetAll() map[string]T {
+	return typedGetAll[T](sub.Subscription.GetAll())
+}
+
+// Iterate performs some callback function on all items
+func (sub *TypedSubscription[T]) Iterate(function func(key string, item T) bool) {
+	sub.Subscription.Iterate(func(key string, val interface{}) bool {
+		return function(key, val.(T))
+	})
+}
+
+func typedGet[T any](getter Getter, key string) (T, error) {
+	var zero T
+	item, err := getter.Get(key)
+	if err != nil {
+		return zero, err
+	}
+	return item.(T), nil
+}
+
+func typedGetAll[T any](items map[string]interface{}) map[string]T {
+	result := make(map[string]T, len(items))
+	for key, item := range items {
+		result[key] = item.(T)
+	}
+	return result
+}
@@ -0,0 +1,129 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package pubsub
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/lf-edge/eve/pkg/pillar/base"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+type typedItem struct {
+	Name  string
+	Count int
+}
+
+func TestTypedSubscription(t *testing.T) {
+	logger := logrus.StandardLogger()
+	log := base.NewSourceLogObject(logger, "test", 1234)
+	ps := New(&EmptyDriver{}, logger, log)
+
+	var created, modified, deleted []typedItem
+	sub, err := NewTypedSubscription(ps, TypedSubscriptionOptions[typedItem]{
+		SubscriptionOptions: SubscriptionOptions{
+			AgentName: agentName,
+			Activate:  true,
+		},
+		CreateHandler: func(ctx interface{}, key string, status typedItem) {
+			created = append(created, status)
+		},
+		ModifyHandler: func(ctx interface{}, key string, status, oldStatus typedItem) {
+			modified = append(modified, oldStatus, status)
+		},
+		DeleteHandler: func(ctx interface{}, key string, status typedItem) {
+			deleted = append(deleted, status)
+		},
+	})
+	if err != nil {
+		t.Fatalf("unable to subscribe: %v", err)
+	}
+
+	change := func(key string, item typedItem) Change {
+		b, err := json.Marshal(item)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return Change{Operation: Modify, Key: key, Value: b}
+	}
+	sub.ProcessChange(change("one", typedItem{Name: "one", Count: 1}))
+	sub.ProcessChange(change("two", typedItem{Name: "two", Count: 2}))
+	sub.ProcessChange(change("one", typedItem{Name: "one", Count: 11}))
+	sub.ProcessChange(Change{Operation: Delete, Key: "two"})
+
+	assert.Equal(t, []typedItem{{"one", 1}, {"two", 2}}, created)
+	assert.Equal(t, []typedItem{{"one", 1}, {"one", 11}}, modified)
+	assert.Equal(t, []typedItem{{"two", 2}}, deleted)
+
+	item, err := sub.Get("one")
+	assert.NoError(t, err)
+	assert.Equal(t, typedItem{"one", 11}, item)
+	_, err = sub.Get("two")
+	assert.Error(t, err)
+	assert.Equal(t, map[string]typedItem{"one": {"one", 11}}, sub.GetAll())
+}
+
+func TestTypedMismatch(t *testing.T) {
+	logger := logrus.StandardLogger()
+	log := base.NewSourceLogObject(logger, "test", 1234)
+	ps := New(&EmptyDriver{}, logger, log)
+
+	_, err := NewTypedSubscription(ps, TypedSubscriptionOptions[typedItem]{
+		SubscriptionOptions: SubscriptionOptions{
+			AgentName: agentName,
+			TopicImpl: Item{},
+		},
+	})
+	assert.Error(t, err)
+
+	_, err = NewTypedSubscription(ps, TypedSubscriptionOptions[typedItem]{
+		SubscriptionOptions: SubscriptionOptions{
+			AgentName:     agentName,
+			CreateHandler: func(interface{}, string, interface{}) {},
+		},
+	})
+	assert.Error(t, err)
+
+	_, err = NewTypedPublication[*typedItem](ps, PublicationOptions{
+		AgentName: agentName,
+	})
+	assert.Error(t, err)
+
+	_, err = NewTypedPublication[typedItem](ps, PublicationOptions{
+		AgentName: agentName,
+		TopicType: Item{},
+	})
+	assert.Error(t, err)
+}
+
+func TestTypedPublication(t *testing.T) {
+	logger := logrus.StandardLogger()
+	log := base.NewSourceLogObject(logger, "test", 1234)
+	ps := New(&EmptyDriver{}, logger, log)
+
+	pub, err := NewTypedPublication[typedItem](ps, PublicationOptions{
+		AgentName: agentName,
+	})
+	if err != nil {
+		t.Fatalf("unable to publish: %v", err)
+	}
+	assert.NoError(t, pub.Publish("one", typedItem{"one", 1}))
+	assert.NoError(t, pub.PublishBatch(map[string]typedItem{
+		"two":   {"two", 2},
+		"three": {"three", 3},
+	}, []string{"one"}))
+	item, err := pub.Get("two")
+	assert.NoError(t, err)
+	assert.Equal(t, typedItem{"two", 2}, item)
+
+	count := 0
+	pub.Iterate(func(key string, item typedItem) bool {
+		count += item.Count
+		return true
+	})
+	assert.Equal(t, 5, count)
+	assert.Len(t, pub.GetAll(), 2)
+}
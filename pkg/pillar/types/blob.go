@@ -45,6 +45,11 @@ type BlobStatus struct {
 	CurrentSize            int64 // current total downloaded size as reported by the downloader
 	// Progress percentage downloaded 0-100, defined by CurrentSize/TotalSize
 	Progress uint
+	// Signer identifies the image signing key which signed this blob, as
+	// reported by the verifier; empty if it is not signed
+	Signer string
+	// IsBaseOS is set if the content tree of the base OS image has this blob
+	IsBaseOS bool
 	// ErrorAndTimeWithSource provide common error handling capabilities
 	ErrorAndTimeWithSource
 }
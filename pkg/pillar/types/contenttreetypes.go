@@ -26,6 +26,8 @@ type ContentTreeConfig struct {
 	GenerationCounter int64
 	DisplayName       string
 	CustomMeta        string
+	// IsBaseOS is set for the content tree of the base OS image
+	IsBaseOS bool
 }
 
 // Key is content info UUID which will be unique
@@ -131,6 +133,11 @@ type ContentTreeStatus struct {
 	NameIsURL    bool
 	// Blobs the sha256 hashes of the blobs that are in this tree, the first of which always is the root
 	Blobs []string
+	// Signer identifies the image signing key which signed the root blob;
+	// empty if it is not signed
+	Signer string
+	// IsBaseOS copied from the ContentTreeConfig
+	IsBaseOS bool
 
 	ErrorAndTimeWithSource
 }
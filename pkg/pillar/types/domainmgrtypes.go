@@ -54,6 +54,75 @@ type DomainConfig struct {
 	// once the version is changed cloud-init tool restarts in a guest.
 	// See getCloudInitVersion() and createCloudInitISO() for details.
 	CloudInitVersion uint32
+
+	// CheckpointFile, if set, is where to save the running state of the
+	// domain when it is deactivated, instead of shutting it down
+	CheckpointFile string
+	// Suspend is set if the state saved into CheckpointFile is restored
+	// when the domain is activated again, after a planned reboot of the
+	// device. Otherwise the state is only saved if the guest agent freezes
+	// the guest filesystems, for the disks to be consistent when the domain
+	// boots from them instead.
+	Suspend bool
+	// RestoreFile, if set, is a state saved by a checkpoint to resume the
+	// domain from when it is activated, instead of booting it
+	RestoreFile string
+	// FreezeForSnapshot is set if the volumes of the domain are snapshotted
+	// once it is deactivated; the guest agent then freezes the guest
+	// filesystems before it is stopped, for the snapshots to be consistent
+	FreezeForSnapshot bool
+
+	// IOLimits caps the block I/O of the domain
+	IOLimits IOLimits
+}
+
+// IOLimits caps the block I/O of a domain, enforced on the devices backing
+// its disks. 0 is for no limit.
+type IOLimits struct {
+	// Weight is the share of the I/O bandwidth of the domain relative to
+	// the other ones when the devices are contended, between 1 and 10000
+	Weight    uint16
+	ReadBps   uint64 // in bytes per second
+	WriteBps  uint64 // in bytes per second
+	ReadIOPS  uint64
+	WriteIOPS uint64
+}
+
+// IsZero returns true if there is no limit
+func (limits IOLimits) IsZero() bool {
+	return limits == IOLimits{}
+}
+
+// IOUsage is the block I/O of a domain
+type IOUsage struct {
+	// Since the domain started
+	ReadBytes  uint64
+	WriteBytes uint64
+	ReadOps    uint64
+	WriteOps   uint64
+	// Over the last metric interval
+	ReadBps   uint64 // in bytes per second
+	WriteBps  uint64 // in bytes per second
+	ReadIOPS  uint64
+	WriteIOPS uint64
+}
+
+// NetRateLimits caps the bandwidth of a VIF, 0 for no limit
+type NetRateLimits struct {
+	EgressBps  uint64 // traffic from the app, in bits per second
+	IngressBps uint64 // traffic to the app, in bits per second
+}
+
+// VifTraffic is the traffic of a VIF of a domain
+type VifTraffic struct {
+	Vif string
+	// Since the domain started
+	EgressBytes  uint64 // from the app
+	IngressBytes uint64 // to the app
+	// Over the last metric interval, in bits per second
+	EgressBps  uint64
+	IngressBps uint64
+	RateLimits NetRateLimits
 }
 
 // MetaDataType of metadata service for app
@@ -212,6 +281,8 @@ type VmConfig struct {
 	BootLoader string // default ""
 	// For CPU pinning
 	CPUs string // default "", list of "1,2"
+	// NUMA nodes of the pinned CPUs, the memory is allocated from
+	CPUMems string // default "", list of "0,1"
 	// Needed for device passthru
 	DeviceTree string // default ""; sets device_tree
 	// Example: device_tree="guest-gpio.dtb"
@@ -227,6 +298,20 @@ type VmConfig struct {
 	VncPasswd          string
 	CPUsPinned         bool
 	VMMMaxMem          int // in kbytes
+	// MinMem is the memory in kbytes a balloon may reclaim the memory of
+	// the VM down to under host memory pressure; 0 for no balloon
+	MinMem int
+	// BalloonMaxMem is the memory in kbytes the balloon lets the VM use at
+	// most, below Memory; 0 for Memory. Ignored without a balloon.
+	BalloonMaxMem int
+	// Hotplug gives the VM the room to hot-plug vCPUs up to MaxCpus and
+	// memory up to MaxMem; without it MaxCpus and MaxMem are ignored
+	Hotplug bool
+	// GuestAgent attaches a channel for a qemu-guest-agent in the VM
+	GuestAgent bool
+	// PanicDevice attaches a pvpanic device for the VM to report the panics
+	// of its kernel, for its memory to be dumped
+	PanicDevice bool
 }
 
 type VmMode uint8
@@ -250,6 +335,11 @@ type Task interface {
 	Delete(string) error
 	Info(string) (int, SwState, error)
 	Cleanup(string) error
+	// Checkpoint pauses the domain and saves its state into the file
+	Checkpoint(string, string) error
+	// Restore starts the domain, set up with a RestoreFile, from the
+	// state saved into the file
+	Restore(string, string) error
 }
 
 type DomainStatus struct {
@@ -282,12 +372,98 @@ type DomainStatus struct {
 	EnvVariables   map[string]string // List of environment variables to be set in container
 	VmConfig                         // From DomainConfig
 	Service        bool
+	// BalloonTarget is the memory in kbytes the balloon asks the guest to
+	// use; 0 when not ballooned i.e. the guest has all its Memory
+	BalloonTarget int
+	// BalloonActual is the memory in kbytes the guest has with the balloon
+	// inflated, as reported by the hypervisor; 0 when the guest has all its
+	// Memory. Only that memory is actually given back to the host.
+	BalloonActual int
+	// GuestInfo is reported by the guest agent if any
+	GuestInfo GuestInfo
+	// LastEvent is the last event reported by the hypervisor which
+	// explains the state of the domain, if any
+	LastEvent DomainEvent
+	// RestoreFile is the RestoreFile of the config last handled
+	RestoreFile string
+	// RestoredFrom is the state the domain was resumed from if any
+	RestoredFrom string
+	// RestoreFailed is set if resuming from RestoreFile failed, in which
+	// case the domain boots instead
+	RestoreFailed bool
+	// StoppedFrozen is set if the guest filesystems were frozen when the
+	// domain was last stopped, i.e. its disks are consistent
+	StoppedFrozen bool
+	// HotplugFailed is set if the vCPUs or memory added to the config could
+	// not be added to the running domain, which has to be restarted for them
+	HotplugFailed bool
+	// IOLimits are the limits of the block I/O applied to the running domain
+	IOLimits IOLimits
+}
+
+// GuestInfo is what an agent running in the guest reports about it
+type GuestInfo struct {
+	// Alive is set if the agent answered when last polled
+	Alive         bool
+	LastSeen      time.Time
+	OSName        string
+	OSVersion     string
+	KernelRelease string
+	Interfaces    []GuestInterface
+	Filesystems   []GuestFilesystem
+}
+
+// GuestInterface is a network interface as seen in the guest
+type GuestInterface struct {
+	Name    string
+	MacAddr string
+	IPAddrs []string // With prefix length e.g. 10.1.0.2/24
+}
+
+// GuestFilesystem is a mounted filesystem as seen in the guest
+type GuestFilesystem struct {
+	Mountpoint string
+	Type       string
+	TotalBytes uint64
+	UsedBytes  uint64
+}
+
+// DomainEvent is an event reported by the hypervisor about a domain,
+// e.g. it paused on a disk I/O error
+type DomainEvent struct {
+	Event  string // As named by the hypervisor e.g. BLOCK_IO_ERROR
+	Time   time.Time
+	State  SwState // State of the domain after the event
+	Error  bool    // Whether the event is an error to report to the user
+	Detail string  // What happened, readable by the user
+}
+
+// Equal compares the events; the times are compared with time.Time.Equal
+// since they lose their location when the DomainStatus is copied
+func (event DomainEvent) Equal(other DomainEvent) bool {
+	return event.Event == other.Event && event.Time.Equal(other.Time) &&
+		event.State == other.State && event.Error == other.Error &&
+		event.Detail == other.Detail
 }
 
 func (status DomainStatus) Key() string {
 	return status.UUIDandVersion.UUID.String()
 }
 
+// BalloonReserved returns the memory in kbytes reserved for the domain:
+// what the balloon actually left to the guest, or what it asks the guest to
+// use if more since the guest takes that back at its pace, or all its
+// Memory without a balloon
+func (status DomainStatus) BalloonReserved() int {
+	if status.BalloonActual == 0 || status.BalloonTarget == 0 {
+		return status.Memory
+	}
+	if status.BalloonTarget > status.BalloonActual {
+		return status.BalloonTarget
+	}
+	return status.BalloonActual
+}
+
 func (status DomainStatus) Pending() bool {
 	return status.PendingAdd || status.PendingModify || status.PendingDelete
 }
@@ -378,6 +554,8 @@ type VifConfig struct {
 	Bridge string
 	Vif    string
 	Mac    net.HardwareAddr
+	// VifRateLimits is from the AppNetAdapterConfig
+	VifRateLimits NetRateLimits
 }
 
 // VifInfo store info about vif
@@ -401,6 +579,7 @@ type DiskConfig struct {
 	WWN          string
 	Target       zconfig.Target
 	CustomMeta   string
+	Directory    bool // Shared with the app as a file system
 }
 
 type DiskStatus struct {
@@ -414,9 +593,10 @@ type DiskStatus struct {
 	Vdev         string // Allocated
 	WWN          string
 	CustomMeta   string
+	Directory    bool // From DiskConfig
 }
 
-// DomainMetric carries CPU and memory usage. UUID=devUUID for the dom0/host metrics overhead
+// DomainMetric carries CPU, memory and I/O usage. UUID=devUUID for the dom0/host metrics overhead
 type DomainMetric struct {
 	UUIDandVersion    UUIDandVersion
 	CPUTotalNs        uint64 // Nanoseconds since Domain boot scaled by #CPUs
@@ -428,6 +608,9 @@ type DomainMetric struct {
 	UsedMemoryPercent float64
 	LastHeard         time.Time
 	Activated         bool
+	IOUsage           IOUsage
+	IOLimits          IOLimits
+	VifTraffic        []VifTraffic
 }
 
 // Key returns the key for pubsub
@@ -473,6 +656,20 @@ func (metric DomainMetric) LogKey() string {
 	return string(base.DomainMetricLogType) + "-" + metric.Key()
 }
 
+// AppCrashDump is a memory dump of an app instance taken by domainmgr when
+// its kernel panicked, kept in AppCrashDumpDirname and reported in the app info
+type AppCrashDump struct {
+	AppUUID  uuid.UUID
+	FileName string
+	Size     int64 // In bytes
+	Time     time.Time
+}
+
+// Key returns the key for pubsub
+func (dump AppCrashDump) Key() string {
+	return fmt.Sprintf("%s.%d", dump.AppUUID, dump.Time.Unix())
+}
+
 // HostMemory reports global stats. Published under "global" key
 // Note that Ncpus is the set of physical CPUs which is different
 // than the set of CPUs assigned to dom0
@@ -0,0 +1,43 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package types
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDomainEventEqual(t *testing.T) {
+	event := DomainEvent{Event: "BLOCK_IO_ERROR", Time: time.Now(),
+		State: PAUSED, Error: true, Detail: "I/O error on disk0"}
+
+	// As it comes back from pubsub
+	b, err := json.Marshal(event)
+	assert.NoError(t, err)
+	var copied DomainEvent
+	assert.NoError(t, json.Unmarshal(b, &copied))
+	assert.True(t, event.Equal(copied))
+
+	copied.State = RUNNING
+	assert.False(t, event.Equal(copied))
+	copied = event
+	copied.Time = event.Time.Add(time.Second)
+	assert.False(t, event.Equal(copied))
+}
+
+func TestBalloonReserved(t *testing.T) {
+	status := DomainStatus{VmConfig: VmConfig{Memory: 4096}}
+	assert.Equal(t, 4096, status.BalloonReserved(), "no balloon")
+	status.BalloonTarget = 2048
+	status.BalloonActual = 3072
+	assert.Equal(t, 3072, status.BalloonReserved(), "guest giving memory back")
+	status.BalloonTarget = 3072
+	status.BalloonActual = 2048
+	assert.Equal(t, 3072, status.BalloonReserved(), "guest taking memory back")
+	status.BalloonTarget = 0
+	assert.Equal(t, 4096, status.BalloonReserved(), "balloon deflated")
+}
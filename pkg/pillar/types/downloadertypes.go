@@ -13,6 +13,15 @@ import (
 	uuid "github.com/satori/go.uuid"
 )
 
+// PeerContentPort is the TCP port on which the downloader shares the blobs
+// of the CAS with the other EVE devices when DownloadFromPeers is enabled
+const PeerContentPort = 8446
+
+// LocalRegistryPort is the TCP port of the read-only OCI registry the
+// downloader serves on the bridges of the local network instances when
+// LocalRegistryEnable is set
+const LocalRegistryPort = 5000
+
 // The key/index to this is the ImageSha256 which is allocated by the controller or resolver.
 type DownloaderConfig struct {
 	ImageSha256     string
@@ -23,6 +32,10 @@ type DownloaderConfig struct {
 	Size            uint64 // In bytes
 	FinalObjDir     string // final Object Store
 	RefCount        uint
+	// IsBaseOS is set for the blobs of the base OS image, whose chunks
+	// may be in the current partition and which do not wait for a
+	// download window
+	IsBaseOS bool
 }
 
 func (config DownloaderConfig) Key() string {
@@ -122,6 +135,14 @@ type DownloaderStatus struct {
 	RetryCount int
 	// We save the original error when we do a retry
 	OrigError string
+	// DeferredUntil is when a deferred download will start; zero if it is
+	// not deferred
+	DeferredUntil time.Time
+	// DeferReason says why the download is deferred
+	DeferReason string
+	// DeferredSince is when the download was first deferred; it is not
+	// deferred past DownloadWindowMaxDeferHours after that
+	DeferredSince time.Time
 }
 
 func (status DownloaderStatus) Key() string {
@@ -0,0 +1,136 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package types
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// DownloadWindow is a daily time range in UTC during which large downloads
+// may run. It spans midnight if End is before Start.
+type DownloadWindow struct {
+	Start time.Duration // since midnight
+	End   time.Duration // since midnight
+}
+
+func (w DownloadWindow) String() string {
+	format := func(d time.Duration) string {
+		return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60)
+	}
+	return format(w.Start) + "-" + format(w.End)
+}
+
+// contains returns true if the time of day d is in the window
+func (w DownloadWindow) contains(d time.Duration) bool {
+	if w.Start < w.End {
+		return d >= w.Start && d < w.End
+	}
+	return d >= w.Start || d < w.End
+}
+
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q, expected HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// ParseDownloadWindows parses comma separated HH:MM-HH:MM time ranges in
+// UTC, e.g. "22:00-06:00,12:00-13:00". An empty string means no windows.
+func ParseDownloadWindows(s string) ([]DownloadWindow, error) {
+	var windows []DownloadWindow
+	if strings.TrimSpace(s) == "" {
+		return windows, nil
+	}
+	for _, item := range strings.Split(s, ",") {
+		start, end, found := strings.Cut(item, "-")
+		if !found {
+			return nil, fmt.Errorf("invalid download window %q, expected HH:MM-HH:MM",
+				item)
+		}
+		var w DownloadWindow
+		var err error
+		if w.Start, err = parseTimeOfDay(start); err != nil {
+			return nil, err
+		}
+		if w.End, err = parseTimeOfDay(end); err != nil {
+			return nil, err
+		}
+		if w.Start == w.End {
+			return nil, fmt.Errorf("empty download window %q", item)
+		}
+		windows = append(windows, w)
+	}
+	return windows, nil
+}
+
+// validateDownloadWindows is the validator of DownloadWindows
+func validateDownloadWindows(s string) error {
+	_, err := ParseDownloadWindows(s)
+	return err
+}
+
+// NextDownloadWindow returns now if it is in one of the windows, or else
+// when the next one starts. It returns now if there are no windows.
+func NextDownloadWindow(windows []DownloadWindow, now time.Time) time.Time {
+	if len(windows) == 0 {
+		return now
+	}
+	now = now.UTC()
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	timeOfDay := now.Sub(midnight)
+	var next time.Time
+	for _, w := range windows {
+		if w.contains(timeOfDay) {
+			return now
+		}
+		start := midnight.Add(w.Start)
+		if start.Before(now) {
+			start = start.AddDate(0, 0, 1)
+		}
+		if next.IsZero() || start.Before(next) {
+			next = start
+		}
+	}
+	return next
+}
+
+// ParseDatastoreKbps parses comma separated <datastore UUID>:<kbps> caps
+// of the bandwidth of the downloads from the datastores, 0 kbps for no cap.
+// An empty string means no caps.
+func ParseDatastoreKbps(s string) (map[uuid.UUID]uint32, error) {
+	caps := make(map[uuid.UUID]uint32)
+	if strings.TrimSpace(s) == "" {
+		return caps, nil
+	}
+	for _, item := range strings.Split(s, ",") {
+		id, kbps, found := strings.Cut(item, ":")
+		if !found {
+			return nil, fmt.Errorf("invalid datastore cap %q, expected <datastore UUID>:<kbps>",
+				item)
+		}
+		dsID, err := uuid.FromString(strings.TrimSpace(id))
+		if err != nil {
+			return nil, fmt.Errorf("invalid datastore UUID in %q: %v", item, err)
+		}
+		value, err := strconv.ParseUint(strings.TrimSpace(kbps), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid kbps in %q: %v", item, err)
+		}
+		caps[dsID] = uint32(value)
+	}
+	return caps, nil
+}
+
+// validateDatastoreKbps is the validator of DownloadDatastoreKbps
+func validateDatastoreKbps(s string) error {
+	_, err := ParseDatastoreKbps(s)
+	return err
+}
@@ -0,0 +1,148 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package types
+
+import (
+	"testing"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDownloadWindows(t *testing.T) {
+	testMatrix := map[string]struct {
+		windows  string
+		expected []DownloadWindow
+		fail     bool
+	}{
+		"empty": {
+			windows: " ",
+		},
+		"one": {
+			windows:  "01:30-05:00",
+			expected: []DownloadWindow{{Start: 90 * time.Minute, End: 5 * time.Hour}},
+		},
+		"over midnight and spaces": {
+			windows: "22:00-06:00, 12:00 - 13:15",
+			expected: []DownloadWindow{
+				{Start: 22 * time.Hour, End: 6 * time.Hour},
+				{Start: 12 * time.Hour, End: 13*time.Hour + 15*time.Minute},
+			},
+		},
+		"no end": {
+			windows: "22:00",
+			fail:    true,
+		},
+		"invalid time": {
+			windows: "22:00-25:00",
+			fail:    true,
+		},
+		"empty window": {
+			windows: "10:00-10:00",
+			fail:    true,
+		},
+	}
+	for testname, test := range testMatrix {
+		windows, err := ParseDownloadWindows(test.windows)
+		if test.fail {
+			assert.Error(t, err, testname)
+			continue
+		}
+		assert.NoError(t, err, testname)
+		assert.Equal(t, test.expected, windows, testname)
+	}
+}
+
+func TestNextDownloadWindow(t *testing.T) {
+	windows, err := ParseDownloadWindows("22:00-06:00,12:00-13:00")
+	assert.NoError(t, err)
+	assert.Equal(t, "22:00-06:00", windows[0].String())
+	day := time.Date(2023, 5, 10, 0, 0, 0, 0, time.UTC)
+
+	testMatrix := map[string]struct {
+		windows  []DownloadWindow
+		now      time.Time
+		expected time.Time
+	}{
+		"no windows": {
+			now:      day.Add(15 * time.Hour),
+			expected: day.Add(15 * time.Hour),
+		},
+		"after midnight": {
+			windows:  windows,
+			now:      day.Add(time.Hour),
+			expected: day.Add(time.Hour),
+		},
+		"morning": {
+			windows:  windows,
+			now:      day.Add(6 * time.Hour),
+			expected: day.Add(12 * time.Hour),
+		},
+		"noon": {
+			windows:  windows,
+			now:      day.Add(12*time.Hour + 59*time.Minute),
+			expected: day.Add(12*time.Hour + 59*time.Minute),
+		},
+		"afternoon": {
+			windows:  windows,
+			now:      day.Add(15 * time.Hour),
+			expected: day.Add(22 * time.Hour),
+		},
+		"tomorrow": {
+			windows:  windows[1:],
+			now:      day.Add(15 * time.Hour),
+			expected: day.Add(36 * time.Hour),
+		},
+		"other time zone": {
+			windows:  windows,
+			now:      day.Add(15 * time.Hour).In(time.FixedZone("UTC+2", 2*3600)),
+			expected: day.Add(22 * time.Hour),
+		},
+	}
+	for testname, test := range testMatrix {
+		next := NextDownloadWindow(test.windows, test.now)
+		assert.True(t, test.expected.Equal(next), "%s: %v", testname, next)
+	}
+}
+
+func TestParseDatastoreKbps(t *testing.T) {
+	ds1 := uuid.FromStringOrNil("6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+	ds2 := uuid.FromStringOrNil("6ba7b811-9dad-11d1-80b4-00c04fd430c8")
+	testMatrix := map[string]struct {
+		caps     string
+		expected map[uuid.UUID]uint32
+		fail     bool
+	}{
+		"empty": {
+			caps:     " ",
+			expected: map[uuid.UUID]uint32{},
+		},
+		"two and spaces": {
+			caps:     ds1.String() + ":8000, " + ds2.String() + " : 0",
+			expected: map[uuid.UUID]uint32{ds1: 8000, ds2: 0},
+		},
+		"no kbps": {
+			caps: ds1.String(),
+			fail: true,
+		},
+		"invalid UUID": {
+			caps: "datastore:8000",
+			fail: true,
+		},
+		"invalid kbps": {
+			caps: ds1.String() + ":-1",
+			fail: true,
+		},
+	}
+	for testname, test := range testMatrix {
+		caps, err := ParseDatastoreKbps(test.caps)
+		if test.fail {
+			assert.Error(t, err, testname)
+			continue
+		}
+		assert.NoError(t, err, testname)
+		assert.Equal(t, test.expected, caps, testname)
+	}
+}
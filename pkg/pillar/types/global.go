@@ -9,6 +9,7 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/lf-edge/eve/pkg/pillar/cpuallocator"
 	"github.com/sirupsen/logrus" // OK for logrus.Fatal
 )
 
@@ -135,6 +136,13 @@ func (gs *GlobalStatus) UpdateItemValuesFromGlobalConfig(gc ConfigItemValueMap)
 			gs.setItemValue(key, value.StringValue())
 		}
 	}
+
+	for appUUID, appSettingMap := range gc.AppSettings {
+		for setting, value := range appSettingMap {
+			key := "app." + appUUID + "." + string(setting)
+			gs.setItemValue(key, value.StringValue())
+		}
+	}
 }
 
 // GlobalConfig is used for log levels and timer values which are preserved
@@ -217,6 +225,37 @@ const (
 	// how the EVE microservices will use free and non-free (e.g., WWAN)
 	// ports for image downloads.
 	DownloadMaxPortCost GlobalSettingKey = "network.download.max.cost"
+	// DownloadFromPeers makes the downloader fetch the blobs from the other
+	// EVE devices on the same LAN first and share the blobs in its CAS
+	DownloadFromPeers GlobalSettingKey = "network.download.peers"
+	// DownloadWindows are the daily UTC time ranges, e.g. 22:00-06:00,
+	// outside of which the downloads of DownloadWindowMinMiB or more are
+	// deferred; empty to download at any time
+	DownloadWindows GlobalSettingKey = "network.download.windows"
+	// DownloadWindowMinMiB is the size from which downloads wait for
+	// DownloadWindows
+	DownloadWindowMinMiB GlobalSettingKey = "network.download.window.min.MiB"
+	// DownloadWindowMaxDeferHours is the deadline of the downloads waiting
+	// for DownloadWindows: once deferred that long they start anyway; 0 to
+	// wait for the windows
+	DownloadWindowMaxDeferHours GlobalSettingKey = "network.download.window.max.defer.hours"
+	// DownloadMaxKbps caps the bandwidth of all the downloads together in
+	// kbit/s; 0 for no limit
+	DownloadMaxKbps GlobalSettingKey = "network.download.max.kbps"
+	// DownloadDatastoreMaxKbps caps the bandwidth of the downloads from
+	// each datastore in kbit/s; 0 for no limit
+	DownloadDatastoreMaxKbps GlobalSettingKey = "network.download.datastore.max.kbps"
+	// DownloadDatastoreKbps caps the bandwidth of the downloads from given
+	// datastores in kbit/s, e.g. <datastore UUID>:<kbps>,..., instead of
+	// DownloadDatastoreMaxKbps
+	DownloadDatastoreKbps GlobalSettingKey = "network.download.datastore.kbps"
+	// LocalRegistryEnable makes the downloader serve the OCI images of the
+	// CAS to the apps on the bridges of the local network instances, and
+	// fetch the others from the OCI datastores
+	LocalRegistryEnable GlobalSettingKey = "network.local.registry.enable"
+	// LocalRegistryCacheMiB is how much of the content fetched for the apps
+	// which is not in the CAS the local registry keeps on disk
+	LocalRegistryCacheMiB GlobalSettingKey = "network.local.registry.cache.MiB"
 
 	// Bool Items
 	// UsbAccess global setting key
@@ -225,10 +264,47 @@ const (
 	VgaAccess GlobalSettingKey = "debug.enable.vga"
 	// AllowAppVnc global setting key
 	AllowAppVnc GlobalSettingKey = "app.allow.vnc"
+	// EnableAppGuestAgent global setting key
+	EnableAppGuestAgent GlobalSettingKey = "app.enable.guest.agent"
+	// AppSnapshotVMState global setting key
+	AppSnapshotVMState GlobalSettingKey = "app.snapshot.vm.state"
+	// AppSuspendOnReboot global setting key
+	AppSuspendOnReboot GlobalSettingKey = "app.suspend.on.reboot"
+	// AppDirectoryVolumes global setting key
+	AppDirectoryVolumes GlobalSettingKey = "app.directory.volumes"
+	// AppCPUPinningPolicy global setting key
+	AppCPUPinningPolicy GlobalSettingKey = "app.cpu.pinning.policy"
+	// AppIOWeight is the share of the block I/O bandwidth of each app
+	// relative to the other ones; 0 to leave it alone
+	AppIOWeight GlobalSettingKey = "app.io.weight"
+	// AppIOMaxMiBps caps the block I/O of each app in MiB per second, in
+	// each direction; 0 for no limit
+	AppIOMaxMiBps GlobalSettingKey = "app.io.max.MiBps"
+	// AppIOMaxIOPS caps the I/O operations of each app per second, in
+	// each direction; 0 for no limit
+	AppIOMaxIOPS GlobalSettingKey = "app.io.max.iops"
+	// AppNetMaxEgressKbps caps the traffic from each app VIF in kbit/s; 0
+	// for no limit
+	AppNetMaxEgressKbps GlobalSettingKey = "app.net.max.egress.kbps"
+	// AppNetMaxIngressKbps caps the traffic to each app VIF in kbit/s; 0
+	// for no limit
+	AppNetMaxIngressKbps GlobalSettingKey = "app.net.max.ingress.kbps"
+	// AppConsoleLogRate is how many lines per second of the console of each
+	// VM app go into its log; 0 not to log the console
+	AppConsoleLogRate GlobalSettingKey = "app.console.log.rate"
+	// AppCrashDumpQuotaMiB bounds the memory dumps of the apps whose kernel
+	// panicked kept on the device; 0 not to dump them
+	AppCrashDumpQuotaMiB GlobalSettingKey = "app.crashdump.quota.MiB"
+	// AppRequireSignedContent refuses to create the volumes of the apps from
+	// content not signed by one of the image signing keys of the device
+	AppRequireSignedContent GlobalSettingKey = "app.require.signed.content"
 	// EveMemoryLimitInBytes global setting key
 	EveMemoryLimitInBytes GlobalSettingKey = "memory.eve.limit.bytes"
 	// How much memory overhead is allowed for VMM needs
 	VmmMemoryLimitInMiB GlobalSettingKey = "memory.vmm.limit.MiB"
+	// AppMemoryBalloonMinPercent is the percent of the memory of a VM down
+	// to which a balloon may reclaim it; 0 for no balloon
+	AppMemoryBalloonMinPercent GlobalSettingKey = "memory.apps.balloon.min.percent"
 	// IgnoreMemoryCheckForApps global setting key
 	IgnoreMemoryCheckForApps GlobalSettingKey = "memory.apps.ignore.check"
 	// IgnoreDiskCheckForApps global setting key
@@ -296,9 +372,36 @@ const (
 	RemoteLogLevel AgentSettingKey = "debug.remote.loglevel"
 )
 
+// AppSettingKey - keys for per-app instance settings
+type AppSettingKey string
+
+const (
+	// AppMemoryBalloonMinMiB app setting key; overrides
+	// AppMemoryBalloonMinPercent for the app instance
+	AppMemoryBalloonMinMiB AppSettingKey = "memory.balloon.min.MiB"
+	// AppMemoryBalloonMaxMiB app setting key
+	AppMemoryBalloonMaxMiB AppSettingKey = "memory.balloon.max.MiB"
+	// AppSettingIOWeight app setting key; overrides AppIOWeight
+	AppSettingIOWeight AppSettingKey = "io.weight"
+	// AppSettingIOMaxMiBps app setting key; overrides AppIOMaxMiBps
+	AppSettingIOMaxMiBps AppSettingKey = "io.max.MiBps"
+	// AppSettingIOMaxIOPS app setting key; overrides AppIOMaxIOPS
+	AppSettingIOMaxIOPS AppSettingKey = "io.max.iops"
+	// AppSettingNetMaxEgressKbps app setting key; overrides
+	// AppNetMaxEgressKbps
+	AppSettingNetMaxEgressKbps AppSettingKey = "net.max.egress.kbps"
+	// AppSettingNetMaxIngressKbps app setting key; overrides
+	// AppNetMaxIngressKbps
+	AppSettingNetMaxIngressKbps AppSettingKey = "net.max.ingress.kbps"
+	// AppSettingHotplugEnable app setting key; gives the VM the room to
+	// hot-plug vCPUs up to its maxcpus and memory up to its maxmem
+	AppSettingHotplugEnable AppSettingKey = "hotplug.enable"
+)
+
 const (
 	agentSettingKeyPattern       = `^agent\.([0-9A-Za-z_]+)\.([0-9A-Za-z_.]+)$`
 	legacyAgentSettingKeyPattern = `^debug\.([0-9A-Za-z_]+)\.([0-9A-Za-z_.]+)$`
+	appSettingKeyPattern         = `^app\.([0-9a-fA-F-]{36})\.([0-9A-Za-z_.]+)$`
 )
 
 // ConfigItemType - Defines what type of item we are storing
@@ -359,6 +462,8 @@ type ConfigItemSpecMap struct {
 	GlobalSettings map[GlobalSettingKey]ConfigItemSpec
 	// AgentSettingKey - Map Key: AgentSettingKey, ConfigItemValue.Key: AgentSettingKey
 	AgentSettings map[AgentSettingKey]ConfigItemSpec
+	// AppSettings - Map Key: AppSettingKey, ConfigItemValue.Key: AppSettingKey
+	AppSettings map[AppSettingKey]ConfigItemSpec
 }
 
 // AddIntItem - Adds integer item to specMap
@@ -414,6 +519,33 @@ func (specMap *ConfigItemSpecMap) AddTriStateItem(key GlobalSettingKey, defaultT
 	specMap.GlobalSettings[key] = configItem
 }
 
+// AddAppSettingIntItem - Adds integer item for a per-app instance setting
+func (specMap *ConfigItemSpecMap) AddAppSettingIntItem(key AppSettingKey,
+	defaultInt uint32, min uint32, max uint32) {
+	if defaultInt < min || defaultInt > max {
+		logrus.Fatalf("Adding app setting int item %s failed. Value does not meet given min/max criteria", key)
+	}
+	configItem := ConfigItemSpec{
+		ItemType:   ConfigItemTypeInt,
+		Key:        string(key),
+		IntDefault: defaultInt,
+		IntMin:     min,
+		IntMax:     max,
+	}
+	specMap.AppSettings[key] = configItem
+}
+
+// AddAppSettingBoolItem - Adds boolean item for a per-app instance setting
+func (specMap *ConfigItemSpecMap) AddAppSettingBoolItem(key AppSettingKey,
+	defaultBool bool) {
+	configItem := ConfigItemSpec{
+		ItemType:    ConfigItemTypeBool,
+		Key:         string(key),
+		BoolDefault: defaultBool,
+	}
+	specMap.AppSettings[key] = configItem
+}
+
 // AddAgentSettingStringItem - Adds string item for a per-agent setting
 func (specMap *ConfigItemSpecMap) AddAgentSettingStringItem(key AgentSettingKey,
 	defaultString string, validator Validator) {
@@ -486,6 +618,50 @@ func (specMap *ConfigItemSpecMap) parseAgentItem(
 	return val, err
 }
 
+// parseAppSettingKey
+//
+//	Returns the app instance UUID, AppSettingKey, error ( nil if success )
+func parseAppSettingKey(key string) (string, AppSettingKey, error) {
+	re := regexp.MustCompile(appSettingKeyPattern)
+	if re.MatchString(key) {
+		parsedStrings := re.FindStringSubmatch(key)
+		return strings.ToLower(parsedStrings[1]), AppSettingKey(parsedStrings[2]), nil
+	}
+	err := fmt.Errorf("parseAppSettingKey: Key %s Doesn't match app "+
+		"Setting Key Pattern", key)
+	return "", "", err
+}
+
+func (specMap *ConfigItemSpecMap) parseAppItem(
+	newConfigMap *ConfigItemValueMap, oldConfigMap *ConfigItemValueMap,
+	key string, value string) (ConfigItemValue, error) {
+	appUUID, asKey, err := parseAppSettingKey(key)
+	if err != nil {
+		return ConfigItemValue{}, err
+	}
+	itemSpec, ok := specMap.AppSettings[asKey]
+	if !ok {
+		err := fmt.Errorf("Cannot find key (%s) in AppSettings. asKey: %s",
+			key, asKey)
+		return ConfigItemValue{}, err
+	}
+	val, err := itemSpec.parseValue(value)
+	if err == nil {
+		newConfigMap.setAppSettingValue(appUUID, asKey, val)
+		return val, nil
+	}
+	// Parse Error. Get the Value from old config
+	if oldVal, ok := oldConfigMap.AppSettings[appUUID][asKey]; ok {
+		newConfigMap.setAppSettingValue(appUUID, asKey, oldVal)
+		err := fmt.Errorf("ParseItem: Invalid Value for app Setting - "+
+			"app: %s, Key: %s. Err: %s. Using Existing Value: %+v",
+			appUUID, key, err, oldVal)
+		return oldVal, err
+	}
+	// No Existing Value for the app. It will use the default value.
+	return itemSpec.DefaultValue(), err
+}
+
 // ParseItem - Parses the Key/Value pair into a ConfigItem and updates
 //
 //	newConfigMap. If there is a Parse error, it copies the corresponding value
@@ -498,7 +674,11 @@ func (specMap *ConfigItemSpecMap) ParseItem(newConfigMap *ConfigItemValueMap,
 	gsKey := GlobalSettingKey(key)
 	itemSpec, ok := specMap.GlobalSettings[gsKey]
 	if !ok {
-		// Not a Global Setting. Check if this is a per-agent setting
+		// Not a Global Setting. Check if this is a per-app or a per-agent
+		// setting
+		if _, _, err := parseAppSettingKey(key); err == nil {
+			return specMap.parseAppItem(newConfigMap, oldConfigMap, key, value)
+		}
 		return specMap.parseAgentItem(newConfigMap, oldConfigMap, key, value)
 	}
 	// Global Setting
@@ -556,6 +736,8 @@ type ConfigItemValueMap struct {
 	GlobalSettings map[GlobalSettingKey]ConfigItemValue
 	// AgentSettings - Map Outer Key: agentName, Map Inner Key: AgentSettingKey ConfigItemValue.Key: AgentSettingKey
 	AgentSettings map[string]map[AgentSettingKey]ConfigItemValue
+	// AppSettings - Map Outer Key: app instance UUID, Map Inner Key: AppSettingKey ConfigItemValue.Key: AppSettingKey
+	AppSettings map[string]map[AppSettingKey]ConfigItemValue `json:",omitempty"`
 }
 
 func (configPtr *ConfigItemValueMap) globalConfigItemValue(
@@ -645,6 +827,53 @@ func (configPtr *ConfigItemValueMap) GlobalValueBool(key GlobalSettingKey) bool
 	}
 }
 
+// AppSettingIntValue - Gets the value of a per-app instance setting, the
+// default if it is not set for the app instance
+func (configPtr *ConfigItemValueMap) AppSettingIntValue(appUUID string, key AppSettingKey) uint32 {
+	val := configPtr.appSettingValue(appUUID, key)
+	if val.ItemType != ConfigItemTypeInt {
+		logrus.Fatalf("App setting is not of type int. app %s, appSettingKey %s",
+			appUUID, string(key))
+	}
+	return val.IntValue
+}
+
+// AppSettingBoolValue - Gets the value of a boolean per-app instance
+// setting, the default if it is not set for the app instance
+func (configPtr *ConfigItemValueMap) AppSettingBoolValue(appUUID string, key AppSettingKey) bool {
+	val := configPtr.appSettingValue(appUUID, key)
+	if val.ItemType != ConfigItemTypeBool {
+		logrus.Fatalf("App setting is not of type bool. app %s, appSettingKey %s",
+			appUUID, string(key))
+	}
+	return val.BoolValue
+}
+
+func (configPtr *ConfigItemValueMap) appSettingValue(appUUID string, key AppSettingKey) ConfigItemValue {
+	val, ok := configPtr.AppSettings[strings.ToLower(appUUID)][key]
+	if !ok {
+		specMap := NewConfigItemSpecMap()
+		spec, ok := specMap.AppSettings[key]
+		if !ok {
+			logrus.Fatalf("appSettingValue - Invalid key: %s", key)
+		}
+		val = spec.DefaultValue()
+	}
+	return val
+}
+
+// setAppSettingValue - Sets an app instance value for a certain key
+func (configPtr *ConfigItemValueMap) setAppSettingValue(
+	appUUID string, key AppSettingKey, value ConfigItemValue) {
+	if configPtr.AppSettings == nil {
+		configPtr.AppSettings = make(map[string]map[AppSettingKey]ConfigItemValue)
+	}
+	if _, ok := configPtr.AppSettings[appUUID]; !ok {
+		configPtr.AppSettings[appUUID] = make(map[AppSettingKey]ConfigItemValue)
+	}
+	configPtr.AppSettings[appUUID][key] = value
+}
+
 // setAgentSettingValue - Sets an agent value for a certain key and agent name
 func (configPtr *ConfigItemValueMap) setAgentSettingValue(
 	agentName string, key AgentSettingKey, value ConfigItemValue) {
@@ -790,6 +1019,7 @@ func NewConfigItemSpecMap() ConfigItemSpecMap {
 	var configItemSpecMap ConfigItemSpecMap
 	configItemSpecMap.GlobalSettings = make(map[GlobalSettingKey]ConfigItemSpec)
 	configItemSpecMap.AgentSettings = make(map[AgentSettingKey]ConfigItemSpec)
+	configItemSpecMap.AppSettings = make(map[AppSettingKey]ConfigItemSpec)
 
 	// timer.config.interval(seconds)
 	// MaxValue needs to be limited. If configured too high, the device will wait
@@ -840,14 +1070,34 @@ func NewConfigItemSpecMap() ConfigItemSpecMap {
 		uint32(eveMemoryLimitInBytes), 0xFFFFFFFF)
 	// Limit manual vmm overhead override to 1 PiB
 	configItemSpecMap.AddIntItem(VmmMemoryLimitInMiB, 0, 0, uint32(1024*1024*1024))
+	configItemSpecMap.AddIntItem(AppMemoryBalloonMinPercent, 0, 0, 99)
+	configItemSpecMap.AddIntItem(AppIOWeight, 0, 0, 10000)
+	configItemSpecMap.AddIntItem(AppIOMaxMiBps, 0, 0, 0xFFFFFFFF)
+	configItemSpecMap.AddIntItem(AppIOMaxIOPS, 0, 0, 0xFFFFFFFF)
+	configItemSpecMap.AddIntItem(AppNetMaxEgressKbps, 0, 0, 0xFFFFFFFF)
+	configItemSpecMap.AddIntItem(AppNetMaxIngressKbps, 0, 0, 0xFFFFFFFF)
+	configItemSpecMap.AddIntItem(AppConsoleLogRate, 100, 0, 10000)
+	configItemSpecMap.AddIntItem(AppCrashDumpQuotaMiB, 0, 0, 0xFFFFFFFF)
 	// LogRemainToSendMBytes - Default is 2 Gbytes, minimum is 10 Mbytes
 	configItemSpecMap.AddIntItem(LogRemainToSendMBytes, 2048, 10, 0xFFFFFFFF)
 	configItemSpecMap.AddIntItem(DownloadMaxPortCost, 0, 0, 255)
+	configItemSpecMap.AddIntItem(DownloadWindowMinMiB, 0, 0, 0xFFFFFFFF)
+	configItemSpecMap.AddIntItem(DownloadWindowMaxDeferHours, 0, 0, 0xFFFFFFFF)
+	configItemSpecMap.AddIntItem(DownloadMaxKbps, 0, 0, 0xFFFFFFFF)
+	configItemSpecMap.AddIntItem(DownloadDatastoreMaxKbps, 0, 0, 0xFFFFFFFF)
+	configItemSpecMap.AddIntItem(LocalRegistryCacheMiB, 1024, 0, 0xFFFFFFFF)
 
 	// Add Bool Items
 	configItemSpecMap.AddBoolItem(UsbAccess, true) // Controller likely default to false
 	configItemSpecMap.AddBoolItem(VgaAccess, true) // Controller likely default to false
 	configItemSpecMap.AddBoolItem(AllowAppVnc, false)
+	configItemSpecMap.AddBoolItem(EnableAppGuestAgent, false)
+	configItemSpecMap.AddBoolItem(AppSnapshotVMState, false)
+	configItemSpecMap.AddBoolItem(AppSuspendOnReboot, false)
+	configItemSpecMap.AddBoolItem(AppDirectoryVolumes, false)
+	configItemSpecMap.AddBoolItem(AppRequireSignedContent, false)
+	configItemSpecMap.AddBoolItem(DownloadFromPeers, false)
+	configItemSpecMap.AddBoolItem(LocalRegistryEnable, false)
 	configItemSpecMap.AddBoolItem(IgnoreMemoryCheckForApps, false)
 	configItemSpecMap.AddBoolItem(IgnoreDiskCheckForApps, false)
 	configItemSpecMap.AddBoolItem(AllowLogFastupload, false)
@@ -865,11 +1115,25 @@ func NewConfigItemSpecMap() ConfigItemSpecMap {
 	configItemSpecMap.AddStringItem(SSHAuthorizedKeys, "", blankValidator)
 	configItemSpecMap.AddStringItem(DefaultLogLevel, "info", parseLevel)
 	configItemSpecMap.AddStringItem(DefaultRemoteLogLevel, "info", parseLevel)
+	configItemSpecMap.AddStringItem(AppCPUPinningPolicy, string(cpuallocator.PolicyCompact),
+		cpuallocator.ParsePolicy)
+	configItemSpecMap.AddStringItem(DownloadWindows, "", validateDownloadWindows)
+	configItemSpecMap.AddStringItem(DownloadDatastoreKbps, "", validateDatastoreKbps)
 
 	// Add Agent Settings
 	configItemSpecMap.AddAgentSettingStringItem(LogLevel, "info", parseLevel)
 	configItemSpecMap.AddAgentSettingStringItem(RemoteLogLevel, "info", parseLevel)
 
+	// Add Per-app instance settings
+	configItemSpecMap.AddAppSettingIntItem(AppMemoryBalloonMinMiB, 0, 0, 0xFFFFFFFF)
+	configItemSpecMap.AddAppSettingIntItem(AppMemoryBalloonMaxMiB, 0, 0, 0xFFFFFFFF)
+	configItemSpecMap.AddAppSettingIntItem(AppSettingIOWeight, 0, 0, 10000)
+	configItemSpecMap.AddAppSettingIntItem(AppSettingIOMaxMiBps, 0, 0, 0xFFFFFFFF)
+	configItemSpecMap.AddAppSettingIntItem(AppSettingIOMaxIOPS, 0, 0, 0xFFFFFFFF)
+	configItemSpecMap.AddAppSettingIntItem(AppSettingNetMaxEgressKbps, 0, 0, 0xFFFFFFFF)
+	configItemSpecMap.AddAppSettingIntItem(AppSettingNetMaxIngressKbps, 0, 0, 0xFFFFFFFF)
+	configItemSpecMap.AddAppSettingBoolItem(AppSettingHotplugEnable, false)
+
 	// Add NetDump settings
 	configItemSpecMap.AddBoolItem(NetDumpEnable, true)
 	configItemSpecMap.AddIntItem(NetDumpTopicPreOnboardInterval, HourInSec, 60, 0xFFFFFFFF)
@@ -926,6 +1190,12 @@ func (configPtr *ConfigItemValueMap) UpdateItemValues(source *ConfigItemValueMap
 			configPtr.AgentSettings[agentName][setting] = value
 		}
 	}
+
+	for appUUID, appSettingMap := range source.AppSettings {
+		for setting, value := range appSettingMap {
+			configPtr.setAppSettingValue(appUUID, setting, value)
+		}
+	}
 }
 
 func agentSettingKeyFromLegacyKey(key string) string {
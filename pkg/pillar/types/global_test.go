@@ -176,13 +176,34 @@ func TestNewConfigItemSpecMap(t *testing.T) {
 		ForceFallbackCounter,
 		LogRemainToSendMBytes,
 		DownloadMaxPortCost,
+		DownloadWindowMinMiB,
+		DownloadWindowMaxDeferHours,
+		DownloadMaxKbps,
+		DownloadDatastoreMaxKbps,
+		LocalRegistryCacheMiB,
 		// Bool Items
 		UsbAccess,
 		VgaAccess,
 		ConsoleAccess,
 		AllowAppVnc,
+		EnableAppGuestAgent,
+		AppSnapshotVMState,
+		AppSuspendOnReboot,
+		AppDirectoryVolumes,
+		AppCPUPinningPolicy,
 		EveMemoryLimitInBytes,
 		VmmMemoryLimitInMiB,
+		AppMemoryBalloonMinPercent,
+		AppIOWeight,
+		AppIOMaxMiBps,
+		AppIOMaxIOPS,
+		AppNetMaxEgressKbps,
+		AppNetMaxIngressKbps,
+		AppConsoleLogRate,
+		AppCrashDumpQuotaMiB,
+		AppRequireSignedContent,
+		DownloadFromPeers,
+		LocalRegistryEnable,
 		IgnoreMemoryCheckForApps,
 		IgnoreDiskCheckForApps,
 		AllowLogFastupload,
@@ -195,6 +216,8 @@ func TestNewConfigItemSpecMap(t *testing.T) {
 		SSHAuthorizedKeys,
 		DefaultLogLevel,
 		DefaultRemoteLogLevel,
+		DownloadWindows,
+		DownloadDatastoreKbps,
 		DisableDHCPAllOnesNetMask,
 		ProcessCloudInitMultiPart,
 		NetDumpEnable,
@@ -231,6 +254,239 @@ func TestNewConfigItemSpecMap(t *testing.T) {
 		}
 	}
 
+	// Check all expected AppSettingKeys present in SpecMap
+	appKeys := []AppSettingKey{
+		AppMemoryBalloonMinMiB,
+		AppMemoryBalloonMaxMiB,
+		AppSettingIOWeight,
+		AppSettingIOMaxMiBps,
+		AppSettingIOMaxIOPS,
+		AppSettingNetMaxEgressKbps,
+		AppSettingNetMaxIngressKbps,
+		AppSettingHotplugEnable,
+	}
+	if len(specMap.AppSettings) != len(appKeys) {
+		t.Errorf("AppSettings has more (%d) than expected keys (%d)",
+			len(specMap.AppSettings), len(appKeys))
+	}
+	for _, key := range appKeys {
+		_, ok := specMap.AppSettings[key]
+		if !ok {
+			t.Errorf("Key %s not present in SpecMap.AppSettings", key)
+		}
+	}
+}
+
+func TestParseAppSettings(t *testing.T) {
+	specMap := NewConfigItemSpecMap()
+	app := "6ba7b810-9dad-11d1-80b4-00c04fd430c8"
+	oldGlobalConfig := DefaultConfigItemValueMap()
+	newGlobalConfig := DefaultConfigItemValueMap()
+
+	val, err := specMap.ParseItem(newGlobalConfig, oldGlobalConfig,
+		"app."+strings.ToUpper(app)+".memory.balloon.min.MiB", "512")
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(512), val.IntValue)
+	assert.Equal(t, uint32(512),
+		newGlobalConfig.AppSettingIntValue(app, AppMemoryBalloonMinMiB))
+	assert.Zero(t, newGlobalConfig.AppSettingIntValue(app, AppMemoryBalloonMaxMiB))
+	assert.Zero(t, newGlobalConfig.AppSettingIntValue(
+		"d9e4e6c6-1f5e-4a4b-9a6f-5c8d6a3b2e10", AppMemoryBalloonMinMiB))
+
+	// An invalid value keeps the existing one
+	_, err = specMap.ParseItem(oldGlobalConfig, DefaultConfigItemValueMap(),
+		"app."+app+".memory.balloon.max.MiB", "1024")
+	assert.NoError(t, err)
+	_, err = specMap.ParseItem(newGlobalConfig, oldGlobalConfig,
+		"app."+app+".memory.balloon.max.MiB", "lots")
+	assert.Error(t, err)
+	assert.Equal(t, uint32(1024),
+		newGlobalConfig.AppSettingIntValue(app, AppMemoryBalloonMaxMiB))
+
+	_, err = specMap.ParseItem(newGlobalConfig, oldGlobalConfig,
+		"app."+app+".unknown", "1")
+	assert.Error(t, err)
+
+	status := NewGlobalStatus()
+	status.UpdateItemValuesFromGlobalConfig(*newGlobalConfig)
+	assert.Equal(t, "512",
+		status.ConfigItems["app."+app+".memory.balloon.min.MiB"].Value)
+}
+
+func TestParseAppSettingKey(t *testing.T) {
+	app := "6ba7b810-9dad-11d1-80b4-00c04fd430c8"
+	testMatrix := map[string]struct {
+		key         string
+		expectError bool
+		appUUID     string
+		asKey       AppSettingKey
+	}{
+		"Int setting": {
+			key:     "app." + app + ".io.weight",
+			appUUID: app,
+			asKey:   AppSettingIOWeight,
+		},
+		"Upper case UUID": {
+			key:     "app." + strings.ToUpper(app) + ".hotplug.enable",
+			appUUID: app,
+			asKey:   AppSettingHotplugEnable,
+		},
+		"Unknown setting has a valid key": {
+			key:     "app." + app + ".unknown",
+			appUUID: app,
+			asKey:   "unknown",
+		},
+		"Short UUID": {
+			key:         "app." + app[1:] + ".io.weight",
+			expectError: true,
+		},
+		"Not a UUID": {
+			key:         "app.zedmanager-9dad-11d1-80b4-00c04fd430c8x.io.weight",
+			expectError: true,
+		},
+		"No setting": {
+			key:         "app." + app + ".",
+			expectError: true,
+		},
+		"Agent setting": {
+			key:         "agent.zedmanager.debug.loglevel",
+			expectError: true,
+		},
+	}
+	for testname, test := range testMatrix {
+		t.Run(testname, func(t *testing.T) {
+			appUUID, asKey, err := parseAppSettingKey(test.key)
+			if test.expectError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.appUUID, appUUID)
+			assert.Equal(t, test.asKey, asKey)
+		})
+	}
+}
+
+func TestParseAppItem(t *testing.T) {
+	specMap := NewConfigItemSpecMap()
+	app := "6ba7b810-9dad-11d1-80b4-00c04fd430c8"
+	testMatrix := map[string]struct {
+		item          configItemStruct
+		oldValue      string
+		expectError   bool
+		expectedValue string
+		expectStored  bool
+	}{
+		"Int setting": {
+			item:          configItemStruct{key: "app." + app + ".io.weight", value: "500"},
+			expectedValue: "500",
+			expectStored:  true,
+		},
+		"Int setting at its maximum": {
+			item:          configItemStruct{key: "app." + app + ".io.weight", value: "10000"},
+			expectedValue: "10000",
+			expectStored:  true,
+		},
+		"Bool setting": {
+			item:          configItemStruct{key: "app." + app + ".hotplug.enable", value: "true"},
+			expectedValue: "true",
+			expectStored:  true,
+		},
+		// Error Cases for App Settings
+		"Int setting above its maximum - Default Value used": {
+			item:          configItemStruct{key: "app." + app + ".io.weight", value: "10001"},
+			expectError:   true,
+			expectedValue: "0",
+		},
+		"Int setting not a number - Old Value should be retained": {
+			item:          configItemStruct{key: "app." + app + ".io.weight", value: "heavy"},
+			oldValue:      "200",
+			expectError:   true,
+			expectedValue: "200",
+			expectStored:  true,
+		},
+		"Bool setting not a bool - Default Value used": {
+			item:          configItemStruct{key: "app." + app + ".hotplug.enable", value: "maybe"},
+			expectError:   true,
+			expectedValue: "false",
+		},
+		"Unknown setting": {
+			item:        configItemStruct{key: "app." + app + ".unknown", value: "1"},
+			expectError: true,
+		},
+		"Invalid app UUID": {
+			item:        configItemStruct{key: "app.zedmanager.io.weight", value: "1"},
+			expectError: true,
+		},
+	}
+	for testname, test := range testMatrix {
+		t.Run(testname, func(t *testing.T) {
+			// oldGlobalConfig is used as the Current Setting. In case of
+			// invalid values, values from this are retained.
+			oldGlobalConfig := DefaultConfigItemValueMap()
+			if test.oldValue != "" {
+				_, err := specMap.ParseItem(oldGlobalConfig,
+					DefaultConfigItemValueMap(), test.item.key, test.oldValue)
+				assert.NoError(t, err)
+			}
+			newGlobalConfig := DefaultConfigItemValueMap()
+			itemVal, err := specMap.ParseItem(newGlobalConfig, oldGlobalConfig,
+				test.item.key, test.item.value)
+			if test.expectError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			if test.expectedValue != "" {
+				assert.Equal(t, test.expectedValue, itemVal.StringValue())
+			}
+			if test.expectStored {
+				// Verify the value has been set in newGlobalConfig
+				_, asKey, _ := parseAppSettingKey(test.item.key)
+				assert.Equal(t, test.expectedValue,
+					newGlobalConfig.AppSettings[app][asKey].StringValue())
+			} else {
+				assert.Empty(t, newGlobalConfig.AppSettings[app])
+			}
+		})
+	}
+}
+
+func TestAppSettingValue(t *testing.T) {
+	specMap := NewConfigItemSpecMap()
+	app := "6ba7b810-9dad-11d1-80b4-00c04fd430c8"
+	otherApp := "d9e4e6c6-1f5e-4a4b-9a6f-5c8d6a3b2e10"
+	gc := DefaultConfigItemValueMap()
+
+	// The defaults until set
+	assert.Zero(t, gc.AppSettingIntValue(app, AppSettingIOWeight))
+	assert.False(t, gc.AppSettingBoolValue(app, AppSettingHotplugEnable))
+
+	for key, value := range map[string]string{
+		"app." + app + ".io.weight":      "300",
+		"app." + app + ".hotplug.enable": "true",
+	} {
+		_, err := specMap.ParseItem(gc, DefaultConfigItemValueMap(), key, value)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, uint32(300), gc.AppSettingIntValue(app, AppSettingIOWeight))
+	assert.Equal(t, uint32(300),
+		gc.AppSettingIntValue(strings.ToUpper(app), AppSettingIOWeight))
+	assert.True(t, gc.AppSettingBoolValue(app, AppSettingHotplugEnable))
+	// Only for that app
+	assert.Zero(t, gc.AppSettingIntValue(otherApp, AppSettingIOWeight))
+	assert.False(t, gc.AppSettingBoolValue(otherApp, AppSettingHotplugEnable))
+
+	// Copied with the other settings
+	copied := DefaultConfigItemValueMap()
+	copied.UpdateItemValues(gc)
+	assert.Equal(t, gc.AppSettings, copied.AppSettings)
+
+	// A setting no longer in the config is back to its default
+	next := DefaultConfigItemValueMap()
+	_, err := specMap.ParseItem(next, gc, "app."+app+".io.weight", "300")
+	assert.NoError(t, err)
+	assert.False(t, next.AppSettingBoolValue(app, AppSettingHotplugEnable))
 }
 
 type configItemStruct struct {
@@ -25,22 +25,41 @@ const (
 	ClearDirName = PersistDir + "/clear"
 	// VolumeClearDirName - Not encrypted directory used to store volumes
 	VolumeClearDirName = ClearDirName + "/volumes"
+	// VolumeEncryptedSnapshotsDirName - sealed directory used to store
+	// the snapshots of directory volumes
+	VolumeEncryptedSnapshotsDirName = SealedDirName + "/volume-snapshots"
+	// VolumeClearSnapshotsDirName - Not encrypted directory used to store
+	// the snapshots of directory volumes
+	VolumeClearSnapshotsDirName = ClearDirName + "/volume-snapshots"
 	// PersistDebugDir - Location for service specific debug/traces
 	PersistDebugDir = PersistDir + "/agentdebug"
 	// PersistInstallerDir - location for installer output
 	PersistInstallerDir = PersistDir + "/installer"
 	// IngestedDirname - location for shas of files we pulled from /config
 	IngestedDirname = PersistDir + "/ingested"
+	// AppCrashDumpDirname - location for the memory dumps of crashed apps
+	AppCrashDumpDirname = SealedDirName + "/crashdumps"
+	// ImageSignaturesDirname - location for the signatures and attestations
+	// of the OCI images found by the downloader, <sha256>.json
+	ImageSignaturesDirname = SealedDirName + "/signatures"
 	// SnapshotsDirname - location for snapshots
-	SnapshotsDirname = PersistDir + "/snapshots"
+	SnapshotsDirname = SealedDirName + "/snapshots"
+	// SuspendedAppsDirname - location for the running state of the app
+	// instances suspended across a reboot
+	SuspendedAppsDirname = SealedDirName + "/suspended-apps"
 	// SnapshotAppInstanceConfigFilename - file to store snapshot-related app instance config
 	SnapshotAppInstanceConfigFilename = "appInstanceConfig.json"
 	// SnapshotVolumesSnapshotStatusFilename - file to store volume snapshot status
 	SnapshotVolumesSnapshotStatusFilename = "volumesSnapshotStatus.json"
 	// SnapshotInstanceStatusFilename - file to store SnapshotInstanceStatus
 	SnapshotInstanceStatusFilename = "snapshotInstanceStatus.json"
+	// SnapshotVMStateFilename - file to store the running state of the app
+	SnapshotVMStateFilename = "vmstate"
 	// PersistCachePatchEnvelopes - folder to store inline patch envelopes
 	PersistCachePatchEnvelopes = PersistDir + "/patchEnvelopesCache"
+	// PersistCacheBaseOsInstall - folder to record the base OS installs
+	// in progress
+	PersistCacheBaseOsInstall = PersistDir + "/baseOsInstallCache"
 
 	// IdentityDirname - Config dir
 	IdentityDirname = "/config"
@@ -56,6 +75,12 @@ const (
 	OnboardKeyName = IdentityDirname + "/onboard.key.pem"
 	// RootCertFileName - what we trust for signatures and object encryption
 	RootCertFileName = IdentityDirname + "/root-certificate.pem"
+	// ImageSigningKeysFileName - public keys and certificates trusted to sign
+	// the OCI images of the apps
+	ImageSigningKeysFileName = IdentityDirname + "/image-signing-keys.pem"
+	// PeerCertsFileName - device certificates, or CA certificates of device
+	// certificates, of the devices we share content with
+	PeerCertsFileName = IdentityDirname + "/peer-certs.pem"
 	// V2TLSCertShaFilename - find TLS root cert for API V2 based on this sha
 	V2TLSCertShaFilename = CertificateDirname + "/v2tlsbaseroot-certificates.sha256"
 	// V2TLSBaseFile is where the initial file
@@ -83,6 +108,9 @@ const (
 	ITokenFile = "/run/eve.integrity_token"
 	//EveVersionFile contains the running version of EVE
 	EveVersionFile = "/run/eve-release"
+	// PubSubIntrospectSocket is where zedbox serves the read-only view of
+	// the publications and subscriptions of its agents
+	PubSubIntrospectSocket = "/run/zedbox-pubsub.sock"
 	//DefaultVaultName is the name of the default vault
 	DefaultVaultName = "Application Data Store"
 
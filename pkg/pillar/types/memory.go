@@ -4,11 +4,27 @@
 package types
 
 import (
+	"fmt"
 	"os"
 	"strconv"
 	"strings"
 )
 
+// GetMemoryReservedForEve returns the memory in bytes the app instances
+// cannot use: the memory limit of EVE and, with ZFS, the maximum size of
+// its ARC
+func GetMemoryReservedForEve(gcp *ConfigItemValueMap, persistType PersistType) (uint64, error) {
+	reserved := uint64(gcp.GlobalValueInt(EveMemoryLimitInBytes))
+	if persistType == PersistZFS {
+		zfsArcMaxLimit, err := GetZFSArcMaxSizeInBytes()
+		if err != nil {
+			return 0, fmt.Errorf("failed to get data from zfs_arc_max. error: %v", err)
+		}
+		reserved += zfsArcMaxLimit
+	}
+	return reserved, nil
+}
+
 // GetEveMemoryLimitInBytes returns memory limit
 // reserved for eve in bytes
 func GetEveMemoryLimitInBytes() (uint64, error) {
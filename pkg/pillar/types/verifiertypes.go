@@ -99,6 +99,12 @@ type VerifyImageStatus struct {
 	ErrorAndTime
 	RefCount uint
 	Expired  bool // Used in delete handshake
+	// Signer identifies the image signing key whose signature of the
+	// image was verified; empty if the image is not signed with any
+	Signer string
+	// Attestations are the predicate types of the in-toto attestations
+	// of the image signed with one of the image signing keys
+	Attestations []string
 }
 
 // Key returns the pubsub Key
@@ -118,6 +124,7 @@ func (status VerifyImageStatus) LogCreate(logBase *base.LogObject) {
 		AddField("expired-bool", status.Expired).
 		AddField("size-int64", status.Size).
 		AddField("filelocation", status.FileLocation).
+		AddField("signer", status.Signer).
 		Noticef("VerifyImage status create")
 }
 
@@ -134,7 +141,8 @@ func (status VerifyImageStatus) LogModify(logBase *base.LogObject, old interface
 		oldStatus.RefCount != status.RefCount ||
 		oldStatus.Expired != status.Expired ||
 		oldStatus.Size != status.Size ||
-		oldStatus.FileLocation != status.FileLocation {
+		oldStatus.FileLocation != status.FileLocation ||
+		oldStatus.Signer != status.Signer {
 
 		logObject.CloneAndAddField("state", status.State.String()).
 			AddField("refcount-int64", status.RefCount).
@@ -146,6 +154,8 @@ func (status VerifyImageStatus) LogModify(logBase *base.LogObject, old interface
 			AddField("old-size-int64", oldStatus.Size).
 			AddField("filelocation", status.FileLocation).
 			AddField("old-filelocation", oldStatus.FileLocation).
+			AddField("signer", status.Signer).
+			AddField("old-signer", oldStatus.Signer).
 			Noticef("VerifyImage status modify")
 	} else {
 		// XXX remove?
@@ -184,3 +194,19 @@ func (status VerifyImageStatus) LogKey() string {
 func (status VerifyImageStatus) Pending() bool {
 	return status.PendingAdd || status.PendingModify || status.PendingDelete
 }
+
+// ImageSignatures are the cosign signatures and the in-toto attestations of
+// an OCI image the downloader found in its registry. The downloader saves
+// them in ImageSignaturesDirname for the verifier, which is not connected
+// to the network.
+type ImageSignatures struct {
+	ImageSha256  string // sha256 of the signed manifest
+	Signatures   []CosignSignature
+	Attestations [][]byte // DSSE envelopes
+}
+
+// CosignSignature is a layer of a cosign signature image
+type CosignSignature struct {
+	Payload   []byte // simple signing payload
+	Signature string // base64 of the signature of the payload
+}
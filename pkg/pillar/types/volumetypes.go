@@ -29,6 +29,9 @@ type VolumeConfig struct {
 	HasNoAppReferences      bool
 	Target                  zconfig.Target
 	CustomMeta              string
+	// Directory is set for blank volumes created as a directory to share
+	// with the app rather than as an image file
+	Directory bool
 }
 
 // Key is volume UUID which will be unique
@@ -137,6 +140,7 @@ type VolumeStatus struct {
 	WWN                     string
 	Target                  zconfig.Target
 	CustomMeta              string
+	Directory               bool // See VolumeConfig
 
 	ErrorAndTimeWithSource
 }
@@ -156,6 +160,17 @@ func (status VolumeStatus) IsContainer() bool {
 	return false
 }
 
+// VolumeDirectoryExtension is the extension of the path of directory volumes
+// in place of their format
+const VolumeDirectoryExtension = "dir"
+
+func volumeExtension(format zconfig.Format, directory bool) string {
+	if directory {
+		return VolumeDirectoryExtension
+	}
+	return strings.ToLower(format.String())
+}
+
 // PathName returns the path of the volume
 func (status VolumeStatus) PathName() string {
 	baseDir := VolumeClearDirName
@@ -164,7 +179,7 @@ func (status VolumeStatus) PathName() string {
 	}
 	return fmt.Sprintf("%s/%s#%d.%s", baseDir, status.VolumeID.String(),
 		status.GenerationCounter+status.LocalGenerationCounter,
-		strings.ToLower(status.ContentFormat.String()))
+		volumeExtension(status.ContentFormat, status.Directory))
 }
 
 // LogCreate :
@@ -276,6 +291,10 @@ type VolumesSnapshotConfig struct {
 	VolumeIDs []uuid.UUID
 	// AppUUID used as a backlink to the app
 	AppUUID uuid.UUID
+	// GuestFrozen is set if the guest filesystems were frozen when the app
+	// was stopped, i.e. the snapshot is consistent for the app and not
+	// just crash-consistent
+	GuestFrozen bool
 }
 
 // Key returns unique key for the snapshot
@@ -297,6 +316,8 @@ type VolumesSnapshotStatus struct {
 	RefCount int
 	// ResultOfAction is the type of action that was performed on the snapshot that resulted in this status
 	ResultOfAction VolumesSnapshotAction
+	// GuestFrozen is the GuestFrozen of the config the snapshot was created from
+	GuestFrozen bool
 	// ErrorAndTimeWithSource provides SetErrorNow() and ClearError()
 	ErrorAndTimeWithSource
 }
@@ -398,6 +419,7 @@ type VolumeRefStatus struct {
 	VerifyOnly             bool
 	Target                 zconfig.Target
 	CustomMeta             string
+	Directory              bool
 
 	ErrorAndTimeWithSource
 }
@@ -513,6 +535,7 @@ type VolumeCreatePending struct {
 	LocalGenerationCounter int64
 	ContentFormat          zconfig.Format
 	Encrypted              bool
+	Directory              bool
 }
 
 // Key : VolumeCreatePending unique key
@@ -534,7 +557,7 @@ func (status VolumeCreatePending) PathName() string {
 	}
 	return fmt.Sprintf("%s/%s#%d.%s", baseDir, status.VolumeID.String(),
 		status.GenerationCounter+status.LocalGenerationCounter,
-		strings.ToLower(status.ContentFormat.String()))
+		volumeExtension(status.ContentFormat, status.Directory))
 }
 
 // IsContainer will return true if content tree attached
@@ -551,6 +574,7 @@ func VolumeCreatePendingFromVolumeStatus(status VolumeStatus) VolumeCreatePendin
 		LocalGenerationCounter: status.LocalGenerationCounter,
 		ContentFormat:          status.ContentFormat,
 		Encrypted:              status.Encrypted,
+		Directory:              status.Directory,
 	}
 }
 
@@ -0,0 +1,16 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package types
+
+// WorkerMetrics is the queue pressure of the background worker of an agent
+type WorkerMetrics struct {
+	AgentName string
+	// Pending is the number of pending jobs keyed by worker.Priority name
+	Pending map[string]int
+}
+
+// Key returns the key for pubsub
+func (metrics WorkerMetrics) Key() string {
+	return metrics.AgentName
+}
@@ -102,8 +102,12 @@ type AppInstanceConfig struct {
 	// Error
 	//	If this is set, do not process further.. Just set the status to error
 	//	so the cloud gets it.
-	Errors              []string
-	FixedResources      VmConfig // CPU etc
+	Errors         []string
+	FixedResources VmConfig // CPU etc
+	// IOLimits and NetRateLimits of the app override the global ones
+	// where set, field by field
+	IOLimits            IOLimits
+	NetRateLimits       NetRateLimits
 	DisableLogs         bool
 	VolumeRefConfigList []VolumeRefConfig
 	Activate            bool //EffectiveActivate in AppInstanceStatus must be used for the actual activation
@@ -114,6 +118,10 @@ type AppInstanceConfig struct {
 	LocalRestartCmd     AppInstanceOpsCmd
 	LocalPurgeCmd       AppInstanceOpsCmd
 	HasLocalServer      bool // Set if localServerAddr matches
+	// SuspendForReboot is set along with clearing Activate when the device
+	// goes through a planned reboot, for the running state of the app
+	// instance to be saved and restored after the reboot
+	SuspendForReboot bool
 	// XXX: to be deprecated, use CipherBlockStatus instead
 	CloudInitUserData *string `json:"pubsub-large-CloudInitUserData"`
 	RemoteConsole     bool
@@ -232,6 +240,9 @@ type SnapshottingStatus struct {
 	// RollbackInProgress indicates whether a rollback is in progress for the app instance.
 	// Set to true when a rollback is triggered, set to false when the rollback is completed.
 	RollbackInProgress bool
+	// RestoreVMState is the running state to resume the app instance from once rolled back, if the snapshot has one.
+	// Cleared once the domain is activated.
+	RestoreVMState string
 }
 
 // Indexed by UUIDandVersion as above
@@ -246,6 +257,7 @@ type AppInstanceStatus struct {
 	AppNetAdapters      []AppNetAdapterStatus
 	BootTime            time.Time
 	IoAdapterList       []IoAdapter // Report what was actually used
+	GuestInfo           GuestInfo   // From the guest agent if any
 	RestartInprogress   Inprogress
 	RestartStartedAt    time.Time
 	PurgeInprogress     Inprogress
@@ -466,6 +478,18 @@ func GetVolumesSnapshotStatusFile(snapshotID string) string {
 	return filepath.Join(GetSnapshotDir(snapshotID), SnapshotVolumesSnapshotStatusFilename)
 }
 
+// GetSnapshotVMStateFile returns the file for the running state of the app for the given snapshot ID
+func GetSnapshotVMStateFile(snapshotID string) string {
+	return filepath.Join(GetSnapshotDir(snapshotID), SnapshotVMStateFilename)
+}
+
+// GetSuspendedAppStateFile returns the file for the running state of the
+// given version of the app instance saved across a reboot
+func GetSuspendedAppStateFile(uuidAndVersion UUIDandVersion) string {
+	return filepath.Join(SuspendedAppsDirname,
+		uuidAndVersion.UUID.String()+"."+uuidAndVersion.Version)
+}
+
 // GetSnapshotInstanceStatusFile returns the instance status file for the given snapshot ID
 func GetSnapshotInstanceStatusFile(snapshotID string) string {
 	return filepath.Join(GetSnapshotDir(snapshotID), SnapshotInstanceStatusFilename)
@@ -284,6 +284,9 @@ type AppNetAdapterConfig struct {
 	ACLs         []ACE
 	AccessVlanID uint32
 	IfIdx        uint32 // If we have multiple interfaces on that network, we will increase the index
+	// RateLimits are set by zedmanager from the app instance config and
+	// the global settings
+	RateLimits NetRateLimits
 }
 
 // ACEDirection determines rule direction.
@@ -48,7 +48,7 @@ func (status VolumeCreatePending) ZVolName() string {
 
 // UseZVolDisk returns true if we should use zvol for the provided VolumeStatus and PersistType
 func (status VolumeStatus) UseZVolDisk(persistType PersistType) bool {
-	if status.IsContainer() {
+	if status.IsContainer() || status.Directory {
 		return false
 	}
 	if status.ContentFormat == zconfig.Format_ISO {
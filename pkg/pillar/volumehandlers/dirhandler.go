@@ -0,0 +1,279 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package volumehandlers
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/lf-edge/eve/pkg/pillar/base"
+	"github.com/lf-edge/eve/pkg/pillar/diskmetrics"
+	"github.com/lf-edge/eve/pkg/pillar/types"
+	utils "github.com/lf-edge/eve/pkg/pillar/utils/file"
+)
+
+// volumeHandlerDir handles blank volumes which are a directory shared with
+// the app, with virtio-fs for VMs and as a bind mount for containers.
+// MaxVolSize is enforced by loop mounting on the directory an ext4 image of
+// that size, kept in the directory itself under the mount.
+type volumeHandlerDir struct {
+	commonVolumeHandler
+}
+
+// dirVolumeImage is the name of the image mounted on the directory
+const dirVolumeImage = ".volume.img"
+
+func (handler *volumeHandlerDir) HandleCreated() (bool, error) {
+	updateVolumeSizes(handler.log, handler, handler.status)
+	return true, nil
+}
+
+func (handler *volumeHandlerDir) GetVolumeDetails() (uint64, uint64, string, bool, error) {
+	_, err := os.Stat(handler.status.FileLocation)
+	if err != nil {
+		return 0, 0, "", false, fmt.Errorf("GetVolumeSize failed for %s: %v",
+			handler.status.FileLocation, err)
+	}
+	size, err := diskmetrics.SizeFromDir(handler.log, handler.status.FileLocation)
+	return size, handler.status.MaxVolSize, "DIRECTORY", false, err
+}
+
+func (handler *volumeHandlerDir) CreateVolume() (string, error) {
+	handler.log.Functionf("CreateVolume(%s) as a directory", handler.status.Key())
+	fileLocation := handler.status.PathName()
+	if _, err := os.Stat(fileLocation); err == nil {
+		errStr := fmt.Sprintf("Can not create %s for %s: exists",
+			fileLocation, handler.status.Key())
+		handler.log.Error(errStr)
+		return "", errors.New(errStr)
+	}
+	// The app decides who may access what inside
+	if err := os.Mkdir(fileLocation, 0777); err != nil {
+		handler.log.Errorf("Failed to create directory. Error %s", err)
+		return fileLocation, err
+	}
+	if size := handler.status.MaxVolSize; size != 0 {
+		if err := createDirImage(handler.log, fileLocation, size); err != nil {
+			handler.log.Errorf("Failed to create image. Error %s", err)
+			return fileLocation, err
+		}
+		if err := mountDirImage(handler.log, fileLocation); err != nil {
+			handler.log.Errorf("Failed to mount image. Error %s", err)
+			return fileLocation, err
+		}
+		if err := os.Chmod(fileLocation, 0777); err != nil {
+			handler.log.Errorf("Failed to chmod directory. Error %s", err)
+			return fileLocation, err
+		}
+	}
+	if err := utils.DirSync(fileLocation); err != nil {
+		handler.log.Errorf("Failed to sync directory. Error %s", err)
+		return fileLocation, err
+	}
+	handler.log.Functionf("CreateVolume(%s) DONE", handler.status.Key())
+	return fileLocation, nil
+}
+
+func (handler *volumeHandlerDir) DestroyVolume() (string, error) {
+	fileLocation := handler.status.FileLocation
+	handler.log.Functionf("Removing directory volume %s", fileLocation)
+	if isMountPoint(fileLocation) {
+		if err := unmountDirImage(handler.log, fileLocation); err != nil {
+			handler.log.Error(err)
+			return fileLocation, err
+		}
+	}
+	if err := os.RemoveAll(fileLocation); err != nil {
+		handler.log.Error(err)
+		return fileLocation, err
+	}
+	handler.log.Functionf("destroyVolume(%s) DONE", handler.status.Key())
+	return "", nil
+}
+
+func (handler *volumeHandlerDir) Populate() (bool, error) {
+	fileLocation := handler.status.PathName()
+	if fi, err := os.Stat(fileLocation); err != nil || !fi.IsDir() {
+		return false, nil
+	}
+	// The image is not mounted anymore after a reboot
+	_, err := os.Stat(filepath.Join(fileLocation, dirVolumeImage))
+	if err == nil && !isMountPoint(fileLocation) {
+		if err := mountDirImage(handler.log, fileLocation); err != nil {
+			handler.log.Errorf("Populate: %s", err)
+			return false, err
+		}
+	}
+	handler.status.FileLocation = fileLocation
+	return true, nil
+}
+
+// CreateSnapshot copies the directory, returns the name of the copy as
+// snapshot metadata
+func (handler *volumeHandlerDir) CreateSnapshot() (interface{}, time.Time, error) {
+	handler.log.Noticef("CreateSnapshot for a directory volume (%s)", handler.status.FileLocation)
+	snapshotName := handler.status.Key() + "-snapshot-" + time.Now().Format("20060102150405")
+	snapshotPath := handler.snapshotPath(snapshotName)
+	if err := os.MkdirAll(filepath.Dir(snapshotPath), 0700); err != nil {
+		handler.log.Errorf("CreateSnapshot: %s", err)
+		return "", time.Time{}, err
+	}
+	if err := copyDirectory(handler.log, handler.status.FileLocation, snapshotPath); err != nil {
+		handler.log.Errorf("CreateSnapshot: error copying directory: %s", err)
+		os.RemoveAll(snapshotPath)
+		return "", time.Time{}, err
+	}
+	timeCreated := time.Now()
+	handler.log.Noticef("CreateSnapshot: created snapshot %s %s", snapshotName, timeCreated.Format("02.01.2006 at 15:04:05"))
+	return snapshotName, timeCreated, nil
+}
+
+// RollbackToSnapshot replaces the directory with a copy of the snapshot
+func (handler *volumeHandlerDir) RollbackToSnapshot(snapshotMeta interface{}) error {
+	snapshotName, ok := snapshotMeta.(string)
+	if !ok {
+		errStr := fmt.Sprintf("RollbackToSnapshot: snapshotMeta is not a string")
+		handler.log.Error(errStr)
+		return errors.New(errStr)
+	}
+	fileLocation := handler.status.FileLocation
+	if isMountPoint(fileLocation) {
+		// The content has to stay in the image
+		return handler.rollbackInPlace(snapshotName)
+	}
+	// Keep the current content until the snapshot is copied back
+	tmpLocation := fileLocation + ".tmp"
+	os.RemoveAll(tmpLocation)
+	if err := copyDirectory(handler.log, handler.snapshotPath(snapshotName), tmpLocation); err != nil {
+		errStr := fmt.Sprintf("RollbackToSnapshot: error copying snapshot: %s", err)
+		handler.log.Error(errStr)
+		os.RemoveAll(tmpLocation)
+		return errors.New(errStr)
+	}
+	if err := os.RemoveAll(fileLocation); err != nil {
+		handler.log.Errorf("RollbackToSnapshot: %s", err)
+		return err
+	}
+	if err := os.Rename(tmpLocation, fileLocation); err != nil {
+		handler.log.Errorf("RollbackToSnapshot: %s", err)
+		return err
+	}
+	handler.log.Noticef("RollbackToSnapshot for a directory volume (%s) to snapshot (%s)", fileLocation, snapshotName)
+	return nil
+}
+
+// rollbackInPlace replaces the content of the directory with a copy of the
+// content of the snapshot
+func (handler *volumeHandlerDir) rollbackInPlace(snapshotName string) error {
+	fileLocation := handler.status.FileLocation
+	entries, err := os.ReadDir(fileLocation)
+	if err != nil {
+		handler.log.Errorf("RollbackToSnapshot: %s", err)
+		return err
+	}
+	for _, entry := range entries {
+		if err := os.RemoveAll(filepath.Join(fileLocation, entry.Name())); err != nil {
+			handler.log.Errorf("RollbackToSnapshot: %s", err)
+			return err
+		}
+	}
+	// Copy what is in the snapshot, not the snapshot
+	if err := copyDirectory(handler.log, handler.snapshotPath(snapshotName)+"/.", fileLocation); err != nil {
+		errStr := fmt.Sprintf("RollbackToSnapshot: error copying snapshot: %s", err)
+		handler.log.Error(errStr)
+		return errors.New(errStr)
+	}
+	handler.log.Noticef("RollbackToSnapshot for a directory volume (%s) to snapshot (%s)", fileLocation, snapshotName)
+	return nil
+}
+
+// DeleteSnapshot removes the copy of the directory
+func (handler *volumeHandlerDir) DeleteSnapshot(snapshotMeta interface{}) error {
+	snapshotName, ok := snapshotMeta.(string)
+	if !ok {
+		errStr := fmt.Sprintf("DeleteSnapshot: snapshotMeta is not a string")
+		handler.log.Error(errStr)
+		return errors.New(errStr)
+	}
+	if err := os.RemoveAll(handler.snapshotPath(snapshotName)); err != nil {
+		handler.log.Errorf("DeleteSnapshot: error deleting snapshot: %s", err)
+		return err
+	}
+	return nil
+}
+
+// snapshotPath returns where the snapshot of the directory is kept, out of
+// the directories of the volumes but as encrypted as the volume
+func (handler *volumeHandlerDir) snapshotPath(snapshotName string) string {
+	baseDir := types.VolumeClearSnapshotsDirName
+	if handler.status.Encrypted {
+		baseDir = types.VolumeEncryptedSnapshotsDirName
+	}
+	return filepath.Join(baseDir, snapshotName)
+}
+
+// copyDirectory copies the src directory into dst preserving the owners,
+// modes and symbolic links the app made in it
+func copyDirectory(log *base.LogObject, src string, dst string) error {
+	output, err := base.Exec(log, "cp", "-a", src, dst).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("cp -a %s %s failed: %s: %w", src, dst, output, err)
+	}
+	return nil
+}
+
+// createDirImage creates in dir a sparse ext4 image of size bytes
+func createDirImage(log *base.LogObject, dir string, size uint64) error {
+	image := filepath.Join(dir, dirVolumeImage)
+	f, err := os.Create(image)
+	if err != nil {
+		return err
+	}
+	err = f.Truncate(int64(size))
+	f.Close()
+	if err != nil {
+		return err
+	}
+	// No blocks reserved for root, the app may use all of it
+	output, err := base.Exec(log, "mkfs.ext4", "-q", "-F", "-m", "0", image).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("mkfs.ext4 %s failed: %s: %w", image, output, err)
+	}
+	return nil
+}
+
+// mountDirImage loop mounts the image in dir on dir. The loop device keeps
+// the image open once it is hidden under the mount.
+func mountDirImage(log *base.LogObject, dir string) error {
+	image := filepath.Join(dir, dirVolumeImage)
+	output, err := base.Exec(log, "mount", "-o", "loop", image, dir).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("mount %s failed: %s: %w", image, output, err)
+	}
+	return nil
+}
+
+// unmountDirImage unmounts the image mounted on dir, which frees its loop
+// device
+func unmountDirImage(log *base.LogObject, dir string) error {
+	output, err := base.Exec(log, "umount", dir).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("umount %s failed: %s: %w", dir, output, err)
+	}
+	return nil
+}
+
+// isMountPoint returns true if something is mounted on dir
+func isMountPoint(dir string) bool {
+	var st, parentSt syscall.Stat_t
+	if syscall.Stat(dir, &st) != nil ||
+		syscall.Stat(filepath.Dir(dir), &parentSt) != nil {
+		return false
+	}
+	return st.Dev != parentSt.Dev
+}
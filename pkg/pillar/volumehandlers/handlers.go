@@ -65,6 +65,9 @@ func GetVolumeHandler(log *base.LogObject, volumeManager VolumeMgr, status *type
 	if status.IsContainer() {
 		return &volumeHandlerContainer{common}
 	}
+	if status.Directory {
+		return &volumeHandlerDir{common}
+	}
 	if status.UseZVolDisk(vault.ReadPersistType()) {
 		return &volumeHandlerZVol{commonVolumeHandler: common, useVHost: useVhost(log, volumeManager)}
 	}
@@ -25,4 +25,15 @@
 //
 // This gives you the option to process responses asynchronously via the response handler, synchronously
 // by retrieving via key, or both.
+//
+// Queued work is started in order of its Priority, and in order of submission for the same Priority.
+// Work which is given a Deadline and is not started by then is not performed; its WorkResult instead
+// has a DeadlineExceededError. Cancel removes queued work, which is then not performed. A Pool created
+// with NewPoolWithQueue queues the work submitted while all its workers are busy, rather than rejecting it.
+//
+// A Durable created with NewDurablePool records the work with a Key in a persistcache until it is done,
+// whether it failed or not, so that the work interrupted by a restart is known. The handler can attach
+// data to the record with Work.SaveCheckpoint, and gets it back in Work.Resume when the same Key is
+// submitted again. baseosmgr records its installs this way, checkpointing how much of the image is
+// written to the partition, and an interrupted install resumes writing from there.
 package worker
@@ -0,0 +1,232 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package worker
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/lf-edge/eve/pkg/pillar/persistcache"
+)
+
+// Durable is a Pool which records the work submitted to it in a PersistCache
+// until the work is performed, with or without error, or cancelled. The
+// records hence only survive a restart which interrupts the work, e.g. a
+// power loss. While performing the work, the handler can attach data to its
+// record with Work.SaveCheckpoint. When work with the same Key is submitted
+// again, the handler gets the latest data in Work.Resume. Whether the work
+// can be resumed from it is up to the handler; Durable only keeps the data.
+//
+// Only work with a Key is recorded. The agent is still expected to submit the
+// work again from its intent; Recovered tells which work was in flight, and
+// the agent should Cancel the recovered work its intent no longer asks for.
+type Durable struct {
+	Worker
+	log   Logger
+	cache *persistcache.PersistCache
+	lock  sync.Mutex // Serializes the updates of the records
+	// Work recorded before a restart and not yet submitted again
+	recovered map[string]WorkRecord
+}
+
+// WorkRecord is what a Durable records about a Work
+type WorkRecord struct {
+	Kind     string
+	Key      string
+	Priority Priority
+	// Submitted is the first time the work was submitted
+	Submitted time.Time
+	// Checkpoint is the latest data saved with Work.SaveCheckpoint
+	Checkpoint []byte `json:",omitempty"`
+	// Checkpointed is when Checkpoint was saved
+	Checkpointed time.Time `json:",omitempty"`
+}
+
+// NewDurablePool constructs a Pool as NewPoolWithQueue whose work is recorded
+// in cache. cache should not be used for anything else.
+func NewDurablePool(log Logger, ctx interface{}, maxWorkers int, queueLength int,
+	handlers map[string]Handler, cache *persistcache.PersistCache) (*Durable, error) {
+
+	d := &Durable{
+		log:       log,
+		cache:     cache,
+		recovered: make(map[string]WorkRecord),
+	}
+	for _, name := range cache.Objects() {
+		record, err := d.load(name)
+		if err != nil {
+			return nil, fmt.Errorf("NewDurablePool: %w", err)
+		}
+		d.recovered[record.Key] = *record
+	}
+	wrapped := make(map[string]Handler, len(handlers))
+	for kind, handler := range handlers {
+		wrapped[kind] = Handler{
+			Request:  d.wrapRequest(handler.Request),
+			Response: handler.Response,
+		}
+	}
+	d.Worker = NewPoolWithQueue(log, ctx, maxWorkers, queueLength, wrapped)
+	return d, nil
+}
+
+// wrapRequest removes the record of the work once performed. The checkpoint
+// of failed work is dropped as well since the failure may come from the
+// data it describes.
+func (d *Durable) wrapRequest(request WorkFunction) WorkFunction {
+	return func(ctx interface{}, work Work) WorkResult {
+		result := request(ctx, work)
+		if work.Key != "" {
+			d.lock.Lock()
+			d.remove(work.Key)
+			d.lock.Unlock()
+		}
+		return result
+	}
+}
+
+// Submit records the work and passes it to the pool
+func (d *Durable) Submit(work Work) error {
+	_, err := d.submitImpl(work, true)
+	return err
+}
+
+// TrySubmit records the work and passes it to the pool if there is room
+func (d *Durable) TrySubmit(work Work) (bool, error) {
+	return d.submitImpl(work, false)
+}
+
+func (d *Durable) submitImpl(work Work, wait bool) (bool, error) {
+	if work.Key == "" {
+		if wait {
+			return true, d.Worker.Submit(work)
+		}
+		return d.Worker.TrySubmit(work)
+	}
+	d.lock.Lock()
+	record, err := d.load(cacheKey(work.Key))
+	existed := err == nil
+	if !existed {
+		record = &WorkRecord{Key: work.Key, Submitted: time.Now()}
+	}
+	record.Kind = work.Kind
+	record.Priority = work.Priority
+	if err := d.store(record); err != nil {
+		d.lock.Unlock()
+		return false, err
+	}
+	delete(d.recovered, work.Key)
+	d.lock.Unlock()
+
+	work.Resume = record.Checkpoint
+	work.durable = d
+	var done bool
+	if wait {
+		err = d.Worker.Submit(work)
+		done = err == nil
+	} else {
+		done, err = d.Worker.TrySubmit(work)
+	}
+	if !done && !existed {
+		d.lock.Lock()
+		d.remove(work.Key)
+		d.lock.Unlock()
+	}
+	return done, err
+}
+
+// Cancel cancels a pending job and removes its record
+func (d *Durable) Cancel(key string) {
+	d.Worker.Cancel(key)
+	if key == "" {
+		return
+	}
+	d.lock.Lock()
+	d.remove(key)
+	delete(d.recovered, key)
+	d.lock.Unlock()
+}
+
+// Recovered returns the work recorded before a restart which was not yet
+// submitted again, sorted by Key
+func (d *Durable) Recovered() []WorkRecord {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	records := make([]WorkRecord, 0, len(d.recovered))
+	for _, record := range d.recovered {
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Key < records[j].Key
+	})
+	return records
+}
+
+// saveCheckpoint updates the checkpoint data of the record of key
+func (d *Durable) saveCheckpoint(key string, data []byte) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	record, err := d.load(cacheKey(key))
+	if err != nil {
+		// Cancelled meanwhile
+		return nil
+	}
+	record.Checkpoint = data
+	record.Checkpointed = time.Now()
+	return d.store(record)
+}
+
+// load reads the record stored under name
+func (d *Durable) load(name string) (*WorkRecord, error) {
+	b, err := d.cache.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	var record WorkRecord
+	if err := json.Unmarshal(b, &record); err != nil {
+		return nil, fmt.Errorf("load(%s): %w", name, err)
+	}
+	return &record, nil
+}
+
+// store writes the record; expects lock held
+func (d *Durable) store(record *WorkRecord) error {
+	b, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if _, err := d.cache.Put(cacheKey(record.Key), b); err != nil {
+		return fmt.Errorf("store(%s): %w", record.Key, err)
+	}
+	return nil
+}
+
+// remove deletes the record of key if any; expects lock held
+func (d *Durable) remove(key string) {
+	if _, err := d.cache.Get(cacheKey(key)); err != nil {
+		return
+	}
+	if err := d.cache.Delete(cacheKey(key)); err != nil {
+		d.log.Tracef("remove(%s) failed: %v", key, err)
+	}
+}
+
+// cacheKey returns the PersistCache key i.e. file name for a work key
+func cacheKey(key string) string {
+	return url.PathEscape(key)
+}
+
+// SaveCheckpoint records data which is passed in Work.Resume if the work is
+// submitted again before it is done, e.g. after a power loss. Does nothing
+// unless the work was submitted to a Durable.
+func (w Work) SaveCheckpoint(data []byte) error {
+	if w.durable == nil || w.Key == "" {
+		return nil
+	}
+	return w.durable.saveCheckpoint(w.Key, data)
+}
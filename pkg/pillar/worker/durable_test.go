@@ -0,0 +1,128 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package worker_test
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lf-edge/eve/pkg/pillar/base"
+	"github.com/lf-edge/eve/pkg/pillar/persistcache"
+	"github.com/lf-edge/eve/pkg/pillar/worker"
+	"github.com/sirupsen/logrus"
+)
+
+// TestDurable verifies that the work and its checkpoint survive a restart
+func TestDurable(t *testing.T) {
+	dir := t.TempDir()
+	// The content of dir when the power was lost
+	crashDir := t.TempDir()
+	logger := logrus.StandardLogger()
+	logObject := base.NewSourceLogObject(logger, "test", 1234)
+	ctx := dummyContext{contextName: "testContext"}
+	var resumed []byte
+	// Saves a checkpoint then fails unless resumed
+	request := func(ctx interface{}, w worker.Work) worker.WorkResult {
+		resumed = w.Resume
+		if w.Resume != nil {
+			return worker.WorkResult{Key: w.Key}
+		}
+		if err := w.SaveCheckpoint([]byte("half")); err != nil {
+			return worker.WorkResult{Key: w.Key, Error: err}
+		}
+		if err := copyDir(dir, crashDir); err != nil {
+			return worker.WorkResult{Key: w.Key, Error: err}
+		}
+		return worker.WorkResult{Key: w.Key, Error: errors.New("interrupted")}
+	}
+	handlers := map[string]worker.Handler{"test": {Request: request}}
+	newPool := func(dir string) *worker.Durable {
+		cache, err := persistcache.New(dir)
+		assert.NoError(t, err)
+		d, err := worker.NewDurablePool(logObject, &ctx, 1, 2, handlers, cache)
+		assert.NoError(t, err)
+		return d
+	}
+
+	d := newPool(dir)
+	assert.Empty(t, d.Recovered())
+	done, err := d.TrySubmit(worker.Work{Kind: "test", Key: "app/volume",
+		Priority: worker.PriorityHigh})
+	assert.True(t, done)
+	assert.NoError(t, err)
+	proc := <-d.MsgChan()
+	proc.Process(&ctx, true)
+	assert.Error(t, d.Pop("app/volume").Error)
+	assert.Nil(t, resumed)
+	d.Done()
+
+	// Failed work is forgotten
+	d = newPool(dir)
+	assert.Empty(t, d.Recovered())
+	d.Done()
+
+	// After a power loss the work is recovered and resumed from the
+	// checkpoint
+	cancelDir := t.TempDir()
+	assert.NoError(t, copyDir(crashDir, cancelDir))
+	d = newPool(crashDir)
+	recovered := d.Recovered()
+	if assert.Len(t, recovered, 1) {
+		assert.Equal(t, "test", recovered[0].Kind)
+		assert.Equal(t, "app/volume", recovered[0].Key)
+		assert.Equal(t, worker.PriorityHigh, recovered[0].Priority)
+		assert.Equal(t, []byte("half"), recovered[0].Checkpoint)
+	}
+	done, err = d.TrySubmit(worker.Work{Kind: "test", Key: "app/volume"})
+	assert.True(t, done)
+	assert.NoError(t, err)
+	assert.Empty(t, d.Recovered())
+	proc = <-d.MsgChan()
+	proc.Process(&ctx, true)
+	assert.NoError(t, d.Pop("app/volume").Error)
+	assert.Equal(t, []byte("half"), resumed)
+	d.Done()
+
+	// Work done without error is forgotten
+	d = newPool(crashDir)
+	assert.Empty(t, d.Recovered())
+	d.Done()
+
+	// Cancelled recovered work is forgotten
+	d = newPool(cancelDir)
+	assert.Len(t, d.Recovered(), 1)
+	d.Cancel("app/volume")
+	assert.Empty(t, d.Recovered())
+	d.Done()
+	d = newPool(cancelDir)
+	assert.Empty(t, d.Recovered())
+	d.Done()
+}
+
+// copyDir copies the files of src to dst
+func copyDir(src, dst string) error {
+	if err := os.RemoveAll(dst); err != nil {
+		return err
+	}
+	return filepath.WalkDir(src, func(path string, di fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, strings.TrimPrefix(path, src))
+		if di.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(target, b, 0644)
+	})
+}
@@ -3,6 +3,11 @@
 
 package worker
 
+import (
+	"fmt"
+	"time"
+)
+
 // JobInProgressError indicates a job in progress
 type JobInProgressError struct {
 	s string
@@ -11,3 +16,15 @@ type JobInProgressError struct {
 func (e *JobInProgressError) Error() string {
 	return e.s
 }
+
+// DeadlineExceededError is the error of the WorkResult of a job which was
+// not started before its Deadline; the job is not performed
+type DeadlineExceededError struct {
+	Key      string
+	Deadline time.Time
+}
+
+func (e *DeadlineExceededError) Error() string {
+	return fmt.Sprintf("job %s not started before its deadline %s",
+		e.Key, e.Deadline.Format(time.RFC3339))
+}
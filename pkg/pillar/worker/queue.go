@@ -0,0 +1,122 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package worker
+
+import (
+	"container/heap"
+	"fmt"
+)
+
+// Priority of a Work. Work with a higher priority is started before work
+// with a lower one; work with the same priority is started in order of
+// submission.
+type Priority int
+
+const (
+	// PriorityLow for background work e.g., garbage collection
+	PriorityLow Priority = -1
+	// PriorityNormal is the default
+	PriorityNormal Priority = 0
+	// PriorityHigh for work someone is waiting on
+	PriorityHigh Priority = 1
+)
+
+// String returns the name of the priority
+func (p Priority) String() string {
+	switch p {
+	case PriorityLow:
+		return "low"
+	case PriorityNormal:
+		return "normal"
+	case PriorityHigh:
+		return "high"
+	default:
+		return fmt.Sprintf("priority%d", int(p))
+	}
+}
+
+// queuedWork is a Work waiting in a workQueue
+type queuedWork struct {
+	work Work
+	seq  uint64
+}
+
+// workQueue is a priority queue of Work implementing heap.Interface;
+// use push, pop and remove
+type workQueue struct {
+	items   []queuedWork
+	nextSeq uint64
+}
+
+func (q *workQueue) Len() int {
+	return len(q.items)
+}
+
+func (q *workQueue) Less(i, j int) bool {
+	if q.items[i].work.Priority != q.items[j].work.Priority {
+		return q.items[i].work.Priority > q.items[j].work.Priority
+	}
+	return q.items[i].seq < q.items[j].seq
+}
+
+func (q *workQueue) Swap(i, j int) {
+	q.items[i], q.items[j] = q.items[j], q.items[i]
+}
+
+// Push is for heap.Interface
+func (q *workQueue) Push(x interface{}) {
+	q.items = append(q.items, x.(queuedWork))
+}
+
+// Pop is for heap.Interface
+func (q *workQueue) Pop() interface{} {
+	n := len(q.items)
+	item := q.items[n-1]
+	q.items = q.items[:n-1]
+	return item
+}
+
+// push adds work behind the work with the same priority
+func (q *workQueue) push(work Work) {
+	heap.Push(q, queuedWork{work: work, seq: q.nextSeq})
+	q.nextSeq++
+}
+
+// pop removes and returns the work to start next
+func (q *workQueue) pop() Work {
+	return heap.Pop(q).(queuedWork).work
+}
+
+// peek returns the work to start next without removing it
+func (q *workQueue) peek() Work {
+	return q.items[0].work
+}
+
+// remove removes the work with key and returns it
+func (q *workQueue) remove(key string) (Work, bool) {
+	for i, item := range q.items {
+		if item.work.Key == key {
+			heap.Remove(q, i)
+			return item.work, true
+		}
+	}
+	return Work{}, false
+}
+
+// lookup returns true if there is work with key
+func (q *workQueue) lookup(key string) bool {
+	for _, item := range q.items {
+		if item.work.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// countByPriority adds the number of queued work per priority to counts
+func (q *workQueue) countByPriority(counts map[Priority]int) {
+	for _, item := range q.items {
+		counts[item.work.Priority]++
+	}
+}
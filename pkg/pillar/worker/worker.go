@@ -22,6 +22,7 @@ type Logger interface {
 // Worker presenting the ability to interface with a single worker or pool of multiple
 type Worker interface {
 	NumPending() int
+	NumPendingByPriority() map[Priority]int
 	NumResults() int
 	MsgChan() <-chan Processor
 	C() <-chan Processor
@@ -42,15 +43,22 @@ type ResponseFunction func(ctx interface{}, res WorkResult) error
 // Single an implementation of Worker that captures the worker channels
 type Single struct {
 	// Private
-	requestChan chan<- Work
-	resultChan  <-chan Processor
+	length     int // Work which can be queued while a work is running
+	queue      workQueue
+	running    bool       // A work is being performed or its result sent
+	done       bool       // No more work accepted
+	changed    *sync.Cond // Signaled when queue, running or done change
+	resultChan <-chan Processor
 	sync.RWMutex
 	requestCount uint // Number of work items submitted
 	resultCount  uint // Number of work results processed
+	pending      map[Priority]int
 	workMap      map[string]bool
 	resultMap    map[string]WorkResult
 	handlers     map[string]Handler
 	log          Logger
+	// onIdle is called when done with a work, if set
+	onIdle func()
 }
 
 // Work is one work item
@@ -67,6 +75,17 @@ type Work struct {
 	Key string
 	// Description arbitrary structure, used to pass arbitrary data to the handler function(s).
 	Description interface{}
+	// Priority the queued work with the highest priority is started first
+	Priority Priority
+	// Deadline if set and passed before the job is started, the job is
+	// dropped from the queue without being performed; the Response of its
+	// handler gets a WorkResult with a DeadlineExceededError.
+	Deadline time.Time
+	// Resume is the checkpoint data saved with SaveCheckpoint by a previous
+	// attempt at the work with the same Key; only set by a Durable.
+	Resume []byte
+	// durable is set when the work was submitted to a Durable
+	durable *Durable
 }
 
 // WorkResult is output from doing Work
@@ -84,6 +103,7 @@ type WorkResult struct {
 type privateResult struct {
 	worker      *Single
 	kind        string
+	priority    Priority
 	key         string
 	error       error
 	errorTime   time.Time
@@ -101,20 +121,28 @@ type Handler struct {
 // NewWorker creates a new function for a specific function and context
 // function takes the context and the channels
 func NewWorker(log Logger, ctx interface{}, length int, handlers map[string]Handler) Worker {
-	requestChan := make(chan Work, length)
+	return newSingle(log, ctx, length, handlers, nil)
+}
+
+func newSingle(log Logger, ctx interface{}, length int, handlers map[string]Handler,
+	onIdle func()) *Single {
+
 	resultChan := make(chan Processor, length)
 
 	w := &Single{
-		requestChan: requestChan,
-		resultChan:  resultChan,
-		workMap:     map[string]bool{},
-		resultMap:   map[string]WorkResult{},
-		handlers:    handlers,
-		log:         log,
+		length:     length,
+		resultChan: resultChan,
+		pending:    map[Priority]int{},
+		workMap:    map[string]bool{},
+		resultMap:  map[string]WorkResult{},
+		handlers:   handlers,
+		log:        log,
+		onIdle:     onIdle,
 	}
+	w.changed = sync.NewCond(&w.RWMutex)
 
 	log.Tracef("Creating %s at %s", "w.processWork", agentlog.GetMyStack())
-	go w.processWork(log, ctx, requestChan, resultChan)
+	go w.processWork(log, ctx, resultChan)
 	return w
 }
 
@@ -127,6 +155,20 @@ func (w *Single) NumPending() int {
 	return int(w.requestCount) - int(w.resultCount)
 }
 
+// NumPendingByPriority returns the number of pending work items per
+// priority. Priorities without pending work are left out.
+func (w *Single) NumPendingByPriority() map[Priority]int {
+	w.RLock()
+	defer w.RUnlock()
+	counts := make(map[Priority]int)
+	for priority, count := range w.pending {
+		if count != 0 {
+			counts[priority] = count
+		}
+	}
+	return counts
+}
+
 // NumResults returns the number of results waiting to be processed.
 func (w *Single) NumResults() int {
 	w.RLock()
@@ -134,14 +176,35 @@ func (w *Single) NumResults() int {
 	return len(w.resultMap)
 }
 
-// processWork calls the fn for each work until the requestChan is closed
-func (w *Single) processWork(log Logger, ctx interface{}, requestChan <-chan Work, resultChan chan<- Processor) {
+// processWork calls the fn for each work in order of priority until Done
+func (w *Single) processWork(log Logger, ctx interface{}, resultChan chan<- Processor) {
 
 	log.Tracef("processWork starting for context %T", ctx)
-	for work := range requestChan {
+	for {
+		w.Lock()
+		for w.queue.Len() == 0 && !w.done {
+			w.changed.Wait()
+		}
+		if w.queue.Len() == 0 {
+			w.Unlock()
+			break
+		}
+		work := w.queue.pop()
+		w.running = true
+		w.changed.Broadcast()
+		w.Unlock()
+
 		var result WorkResult
 		// find the correct handler for it
-		if handler, ok := w.handlers[work.Kind]; ok {
+		if !work.Deadline.IsZero() && time.Now().After(work.Deadline) {
+			result = WorkResult{
+				Key: work.Key,
+				Error: &DeadlineExceededError{Key: work.Key,
+					Deadline: work.Deadline},
+				ErrorTime:   time.Now(),
+				Description: work.Description,
+			}
+		} else if handler, ok := w.handlers[work.Kind]; ok {
 			result = handler.Request(ctx, work)
 		} else {
 			result = WorkResult{
@@ -152,6 +215,7 @@ func (w *Single) processWork(log Logger, ctx interface{}, requestChan <-chan Wor
 
 		priv := privateResult{
 			kind:        work.Kind,
+			priority:    work.Priority,
 			key:         result.Key,
 			error:       result.Error,
 			errorTime:   result.ErrorTime,
@@ -165,7 +229,12 @@ func (w *Single) processWork(log Logger, ctx interface{}, requestChan <-chan Wor
 		// no longer pending
 		w.Lock()
 		w.deletePendingLocked(work.Key)
+		w.running = false
+		w.changed.Broadcast()
 		w.Unlock()
+		if w.onIdle != nil {
+			w.onIdle()
+		}
 	}
 	close(resultChan)
 	log.Tracef("processWork done for context %T", ctx)
@@ -183,8 +252,8 @@ func (w *Single) C() <-chan Processor {
 }
 
 // Submit will pass work to the worker.
-// Note that this will wait if the channel is busy hence
-// the user has to pick an appropriate length of the channel for NewWorker
+// Note that this will wait if the queue is full hence
+// the user has to pick an appropriate length of the queue for NewWorker
 // Use worker.Pool to avoid such blocking.
 // returns nil if the new job was submitted, JobInProgressError if a job with that
 // key already ins progress, and other errors if it cannot proceed.
@@ -193,7 +262,7 @@ func (w *Single) Submit(work Work) error {
 	return err
 }
 
-// TrySubmit will pass work to the worker if the channel/queue is not full.
+// TrySubmit will pass work to the worker if the queue is not full.
 // Returns true if work was submitted, otherwise false.
 // returns JobInProgressError if a job with that key already ins progress
 func (w *Single) TrySubmit(work Work) (bool, error) {
@@ -201,58 +270,73 @@ func (w *Single) TrySubmit(work Work) (bool, error) {
 }
 
 func (w *Single) submitImpl(work Work, wait bool) (bool, error) {
-	done := false
+	w.Lock()
+	defer w.Unlock()
 	// if this Key already exists and is being processed, do nothing
-	w.RLock()
 	if work.Key != "" && w.lookupPendingLocked(work.Key) {
-		w.RUnlock()
-		return done, &JobInProgressError{s: work.Key}
+		return false, &JobInProgressError{s: work.Key}
 	}
 	// Kind must be set to be handleable
 	if work.Kind == "" {
-		w.RUnlock()
-		return done, fmt.Errorf("cannot process a job with a blank Kind")
+		return false, fmt.Errorf("cannot process a job with a blank Kind")
 	}
 	if _, ok := w.handlers[work.Kind]; !ok {
-		w.RUnlock()
-		return done, fmt.Errorf("no registered handlers for a job of Kind '%s'",
+		return false, fmt.Errorf("no registered handlers for a job of Kind '%s'",
 			work.Kind)
 	}
-	w.RUnlock()
-	if wait {
-		w.requestChan <- work
-		done = true
-	} else {
-		select {
-		case w.requestChan <- work:
-			done = true
-		default:
-			// Do nothing
+	for !w.done && w.fullLocked() {
+		if !wait {
+			return false, nil
 		}
+		w.changed.Wait()
 	}
-	if done {
-		w.Lock()
-		w.requestCount++
-		if work.Key != "" {
-			w.addPendingLocked(work.Key)
-		}
-		w.Unlock()
+	if w.done {
+		return false, fmt.Errorf("worker is done")
+	}
+	w.queue.push(work)
+	w.changed.Broadcast()
+	w.requestCount++
+	w.pending[work.Priority]++
+	if work.Key != "" {
+		w.addPendingLocked(work.Key)
 	}
-	return done, nil
+	return true, nil
 }
 
-// Cancel cancels a pending job.
+// fullLocked returns true if no more work can be queued; there is room for
+// length work items in addition to the one being performed.
+// Assumes caller holds lock
+func (w *Single) fullLocked() bool {
+	queued := w.queue.Len()
+	if w.running {
+		queued++
+	}
+	return queued > w.length
+}
+
+// Cancel cancels a pending job. A job still in the queue is removed and
+// will not be performed.
 // It is idempotent, will return no errors if the job is not found,
 // which means it either never was submitted, or it already was processed.
 func (w *Single) Cancel(key string) {
 	w.Lock()
 	defer w.Unlock()
+	if key != "" {
+		if work, ok := w.queue.remove(key); ok {
+			w.requestCount--
+			w.pending[work.Priority]--
+			w.changed.Broadcast()
+		}
+	}
 	w.deletePendingLocked(key)
 }
 
-// Done will stop the worker
+// Done will stop the worker once the queued work is performed
 func (w *Single) Done() {
-	close(w.requestChan)
+	w.Lock()
+	w.done = true
+	w.changed.Broadcast()
+	w.Unlock()
 }
 
 // Pop get a result and remove it from the list
@@ -335,6 +419,7 @@ func (p Processor) Process(ctx interface{}, later bool) (err error) {
 	}
 	w.Lock()
 	w.resultCount++
+	w.pending[p.result.priority]--
 	if later {
 		w.addResultLocked(p.result.key, res)
 	}
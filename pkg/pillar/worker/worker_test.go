@@ -223,6 +223,104 @@ func TestLength(t *testing.T) {
 	assert.True(t, done)
 }
 
+// TestPriority verifies that queued work is started by priority and that
+// cancelled work is not performed
+func TestPriority(t *testing.T) {
+	ctx := dummyContext{contextName: "testContext"}
+	logger := logrus.StandardLogger()
+	logObject = base.NewSourceLogObject(logger, "test", 1234)
+	worker := NewWorker(
+		logObject,
+		&ctx, 4, map[string]Handler{
+			"test": {Request: dummyWorker},
+		})
+	// Keeps the worker busy while the others are queued
+	assert.NoError(t, worker.Submit(Work{Kind: "test", Key: "busy",
+		Description: sleep1}))
+	time.Sleep(100 * time.Millisecond)
+	assert.NoError(t, worker.Submit(Work{Kind: "test", Key: "low",
+		Description: sleep3, Priority: PriorityLow}))
+	assert.NoError(t, worker.Submit(Work{Kind: "test", Key: "normal",
+		Description: sleep3}))
+	assert.NoError(t, worker.Submit(Work{Kind: "test", Key: "cancelled",
+		Description: sleep3, Priority: PriorityHigh}))
+	assert.NoError(t, worker.Submit(Work{Kind: "test", Key: "high",
+		Description: sleep3, Priority: PriorityHigh}))
+	assert.Equal(t, map[Priority]int{PriorityLow: 1, PriorityNormal: 2,
+		PriorityHigh: 2}, worker.NumPendingByPriority())
+	worker.Cancel("cancelled")
+	assert.Equal(t, map[Priority]int{PriorityLow: 1, PriorityNormal: 2,
+		PriorityHigh: 1}, worker.NumPendingByPriority())
+	assert.Equal(t, 4, worker.NumPending())
+
+	var keys []string
+	for i := 0; i < 4; i++ {
+		proc := <-worker.MsgChan()
+		assert.NoError(t, proc.Process(ctx, true))
+		keys = append(keys, proc.result.key)
+	}
+	assert.Equal(t, []string{"busy", "high", "normal", "low"}, keys)
+	assert.Nil(t, worker.Pop("cancelled"))
+	res := worker.Pop("high")
+	assert.NoError(t, res.Error)
+	assert.True(t, res.Description.(dummyDescription).done)
+	assert.Empty(t, worker.NumPendingByPriority())
+
+	worker.Done()
+	_, ok := <-worker.MsgChan()
+	assert.False(t, ok)
+}
+
+// TestDeadline verifies that work not started before its deadline is not
+// performed and that its handler gets the deadline error
+func TestDeadline(t *testing.T) {
+	ctx := dummyContext{contextName: "testContext"}
+	logger := logrus.StandardLogger()
+	logObject = base.NewSourceLogObject(logger, "test", 1234)
+	var responses []WorkResult
+	worker := NewWorker(
+		logObject,
+		&ctx, 4, map[string]Handler{
+			"test": {Request: dummyWorker,
+				Response: func(ctx interface{}, r WorkResult) error {
+					responses = append(responses, r)
+					return nil
+				}},
+		})
+	// Keeps the worker busy past the deadline
+	assert.NoError(t, worker.Submit(Work{Kind: "test", Key: "busy",
+		Description: sleep1}))
+	time.Sleep(100 * time.Millisecond)
+	assert.NoError(t, worker.Submit(Work{Kind: "test", Key: "expired",
+		Description: sleep3, Priority: PriorityHigh,
+		Deadline: time.Now().Add(200 * time.Millisecond)}))
+	assert.NoError(t, worker.Submit(Work{Kind: "test", Key: "intime",
+		Description: dummyDescription{},
+		Deadline:    time.Now().Add(time.Minute)}))
+	assert.Equal(t, 3, worker.NumPending())
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		proc := <-worker.MsgChan()
+		assert.NoError(t, proc.Process(ctx, true))
+	}
+	// The expired work did not sleep
+	assert.Less(t, int64(time.Since(start)), int64(2*time.Second))
+	assert.Equal(t, 0, worker.NumPending())
+	if assert.Len(t, responses, 3) {
+		assert.Equal(t, "expired", responses[1].Key)
+		var deadlineErr *DeadlineExceededError
+		assert.True(t, errors.As(responses[1].Error, &deadlineErr))
+		assert.Equal(t, "expired", deadlineErr.Key)
+		assert.False(t, responses[1].Description.(dummyDescription).done)
+	}
+	assert.NoError(t, worker.Pop("intime").Error)
+
+	worker.Done()
+	_, ok := <-worker.MsgChan()
+	assert.False(t, ok)
+}
+
 type dummyContext struct {
 	contextName string
 }
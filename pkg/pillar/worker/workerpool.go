@@ -35,10 +35,13 @@ type Pool struct {
 	ctx            interface{}
 	handlers       map[string]Handler
 	stopTimer      chan struct{}
+	// Work waiting for a worker when there are maxWorkers busy workers
+	queue       workQueue
+	queueLength int
 }
 
 type myworker struct {
-	worker   Worker
+	worker   *Single
 	lastUsed time.Time // Last successful submit
 }
 
@@ -49,6 +52,15 @@ func NewPool(log Logger, ctx interface{}, maxWorkers int, handlers map[string]Ha
 		defaultPeriodicGCSeconds, defaultSubmitGCSeconds)
 }
 
+// NewPoolWithQueue constructs a pool which queues up to queueLength work
+// items when maxWorkers workers are busy; the queued work is started in
+// order of priority as workers become available.
+func NewPoolWithQueue(log Logger, ctx interface{}, maxWorkers int, queueLength int, handlers map[string]Handler) Worker {
+	wp := NewPool(log, ctx, maxWorkers, handlers).(*Pool)
+	wp.queueLength = queueLength
+	return wp
+}
+
 // NewPoolWithGC constructs a pool with non-default GC timers
 // If maxWorkers is set to zero it means unlimited
 func NewPoolWithGC(log Logger, ctx interface{}, maxWorkers int, handlers map[string]Handler, periodicGCSeconds int, submitGCSeconds int) Worker {
@@ -110,13 +122,28 @@ func (wp *Pool) mergeResult(w Worker) {
 func (wp *Pool) NumPending() int {
 	wp.workersLock.RLock()
 	defer wp.workersLock.RUnlock()
-	total := 0
+	total := wp.queue.Len()
 	for _, w := range wp.workers {
 		total += w.worker.NumPending()
 	}
 	return total
 }
 
+// NumPendingByPriority returns the current number of work items per
+// priority. Priorities without pending work are left out.
+func (wp *Pool) NumPendingByPriority() map[Priority]int {
+	wp.workersLock.RLock()
+	defer wp.workersLock.RUnlock()
+	counts := make(map[Priority]int)
+	wp.queue.countByPriority(counts)
+	for _, w := range wp.workers {
+		for priority, count := range w.worker.NumPendingByPriority() {
+			counts[priority] += count
+		}
+	}
+	return counts
+}
+
 // NumResults returns the number of results waiting to be processed.
 func (wp *Pool) NumResults() int {
 	wp.workersLock.RLock()
@@ -157,11 +184,31 @@ func (wp *Pool) Submit(work Work) error {
 
 // TrySubmit submits jobs to the WorkerPool. If it cannot find a worker in the pool
 // that can service it - i.e. both the number of workers is at the maximum and the
-// queues of all workers are full - returns false.
+// queues of all workers are full - it queues it if the pool has a queue with room
+// left, otherwise returns false.
 // returns JobInProgressError if a job with that key already in progress.
 func (wp *Pool) TrySubmit(work Work) (bool, error) {
 	wp.workersLock.Lock()
 	defer wp.workersLock.Unlock()
+	if wp.queue.Len() != 0 {
+		// Wait in line behind the work with the same or a higher priority
+		return wp.enqueueLocked(work)
+	}
+	done, err := wp.startLocked(work)
+	if done || err != nil {
+		return done, err
+	}
+	if wp.queueLength == 0 {
+		wp.log.Tracef("Would exceed maxWorkers of %d", wp.maxWorkers)
+		return false, fmt.Errorf("Would exceed maxWorkers of %d", wp.maxWorkers)
+	}
+	return wp.enqueueLocked(work)
+}
+
+// startLocked gives the work to an idle worker, creating one if needed.
+// Returns false without error if all maxWorkers workers are busy.
+// expect workersLock acquired
+func (wp *Pool) startLocked(work Work) (bool, error) {
 	for i, w := range wp.workers {
 		done, err := w.worker.TrySubmit(work)
 		if err != nil {
@@ -177,7 +224,7 @@ func (wp *Pool) TrySubmit(work Work) (bool, error) {
 	// Used all of them; can we create a new one?
 	if wp.maxWorkers == 0 || len(wp.workers) < wp.maxWorkers {
 		wp.log.Tracef("Creating new worker")
-		w := NewWorker(wp.log, wp.ctx, 0, wp.handlers)
+		w := newSingle(wp.log, wp.ctx, 0, wp.handlers, wp.dispatch)
 		neww := myworker{worker: w, lastUsed: time.Now()}
 		wp.workers = append(wp.workers, neww)
 		if len(wp.workers) > wp.maxWorkersUsed {
@@ -196,8 +243,65 @@ func (wp *Pool) TrySubmit(work Work) (bool, error) {
 		wp.log.Tracef("succeeded Submit for %d", len(wp.workers))
 		return true, nil
 	}
-	wp.log.Tracef("Would exceed maxWorkers of %d", wp.maxWorkers)
-	return false, fmt.Errorf("Would exceed maxWorkers of %d", wp.maxWorkers)
+	return false, nil
+}
+
+// enqueueLocked adds the work to the queue of the pool
+// expect workersLock acquired
+func (wp *Pool) enqueueLocked(work Work) (bool, error) {
+	// Kind must be set to be handleable
+	if work.Kind == "" {
+		return false, fmt.Errorf("cannot process a job with a blank Kind")
+	}
+	if _, ok := wp.handlers[work.Kind]; !ok {
+		return false, fmt.Errorf("no registered handlers for a job of Kind '%s'",
+			work.Kind)
+	}
+	if work.Key != "" {
+		if wp.queue.lookup(work.Key) {
+			return false, &JobInProgressError{s: work.Key}
+		}
+		for _, w := range wp.workers {
+			w.worker.RLock()
+			pending := w.worker.lookupPendingLocked(work.Key)
+			w.worker.RUnlock()
+			if pending {
+				return false, &JobInProgressError{s: work.Key}
+			}
+		}
+	}
+	if wp.queue.Len() >= wp.queueLength {
+		wp.log.Tracef("Would exceed queueLength of %d", wp.queueLength)
+		return false, fmt.Errorf("Would exceed maxWorkers of %d and queueLength of %d",
+			wp.maxWorkers, wp.queueLength)
+	}
+	wp.queue.push(work)
+	wp.dispatchLocked()
+	return true, nil
+}
+
+// dispatch starts queued work once a worker is done with its work
+func (wp *Pool) dispatch() {
+	wp.workersLock.Lock()
+	defer wp.workersLock.Unlock()
+	wp.dispatchLocked()
+}
+
+// dispatchLocked starts the queued work in order of priority as long as
+// there are idle workers or workers can be added
+// expect workersLock acquired
+func (wp *Pool) dispatchLocked() {
+	for wp.queue.Len() != 0 {
+		work := wp.queue.peek()
+		done, err := wp.startLocked(work)
+		if err != nil {
+			wp.log.Tracef("dispatch dropping %s: %s", work.Key, err)
+		} else if !done {
+			// All workers are busy
+			return
+		}
+		wp.queue.pop()
+	}
 }
 
 // MsgChan returns a channel to be used in a select loop.
@@ -212,12 +316,16 @@ func (wp *Pool) C() <-chan Processor {
 	return wp.resultChan
 }
 
-// Cancel cancels a pending job.
+// Cancel cancels a pending job. A job still in the queue of the pool is
+// removed and will not be performed.
 // It is idempotent, will return no errors if the job is not found,
 // which means it either never was submitted, or it already was processed.
 func (wp *Pool) Cancel(key string) {
-	wp.workersLock.RLock()
-	defer wp.workersLock.RUnlock()
+	wp.workersLock.Lock()
+	defer wp.workersLock.Unlock()
+	if key != "" {
+		wp.queue.remove(key)
+	}
 	for _, w := range wp.workers {
 		w.worker.Cancel(key)
 	}
@@ -231,6 +339,7 @@ func (wp *Pool) Done() {
 		w.worker.Done()
 	}
 	wp.workers = nil
+	wp.queue = workQueue{}
 	close(wp.stopTimer)
 }
 
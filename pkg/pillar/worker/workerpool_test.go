@@ -537,6 +537,57 @@ func TestNoGC(t *testing.T) {
 	}
 }
 
+// TestQueue verifies that the pool queues work when all workers are busy
+// and starts it by priority
+func TestQueue(t *testing.T) {
+	ctx := dummyContext{contextName: "testContext"}
+	var keys []string
+	dummyResponse := func(ctx interface{}, r worker.WorkResult) error {
+		keys = append(keys, r.Key)
+		return nil
+	}
+	logger := logrus.StandardLogger()
+	logObject = base.NewSourceLogObject(logger, "test", 1234)
+	wp := worker.NewPoolWithQueue(logObject, &ctx, 1, 3,
+		map[string]worker.Handler{
+			"test": {Request: dummyWorker, Response: dummyResponse},
+		})
+	for _, w := range []worker.Work{
+		{Kind: "test", Key: "busy", Description: sleep1},
+		{Kind: "test", Key: "low", Description: sleep1, Priority: worker.PriorityLow},
+		{Kind: "test", Key: "normal", Description: sleep1},
+		{Kind: "test", Key: "high", Description: sleep1, Priority: worker.PriorityHigh},
+	} {
+		done, err := wp.TrySubmit(w)
+		assert.True(t, done)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 4, wp.NumPending())
+	assert.Equal(t, map[worker.Priority]int{worker.PriorityLow: 1,
+		worker.PriorityNormal: 2, worker.PriorityHigh: 1}, wp.NumPendingByPriority())
+
+	// Queue is full
+	done, err := wp.TrySubmit(worker.Work{Kind: "test", Key: "more",
+		Description: sleep1})
+	assert.False(t, done)
+	assert.Error(t, err)
+	// Already queued
+	_, err = wp.TrySubmit(worker.Work{Kind: "test", Key: "low",
+		Description: sleep1})
+	assert.IsType(t, &worker.JobInProgressError{}, err)
+
+	// Not performed since cancelled while queued
+	wp.Cancel("normal")
+	assert.Equal(t, 3, wp.NumPending())
+	for i := 0; i < 3; i++ {
+		proc := <-wp.MsgChan()
+		assert.NoError(t, proc.Process(ctx, false))
+	}
+	assert.Equal(t, []string{"busy", "high", "low"}, keys)
+	assert.Equal(t, 0, wp.NumPending())
+	wp.Done()
+}
+
 type dummyContext struct {
 	contextName string
 }
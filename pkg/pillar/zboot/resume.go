@@ -0,0 +1,76 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package zboot
+
+import (
+	"fmt"
+	"io"
+)
+
+// partitionCheckpointSize is how many bytes are written to a partition
+// between two checkpoints of WriteToPartitionFrom; a variable for the tests
+var partitionCheckpointSize int64 = 64 * 1024 * 1024
+
+// syncWriter is a writer which can flush what was written to stable storage
+// e.g., an *os.File
+type syncWriter interface {
+	io.Writer
+	Sync() error
+}
+
+// resumeWriter writes a stream to dst, which is positioned at offset,
+// skipping the first offset bytes of the stream which an interrupted write
+// already wrote. Every partitionCheckpointSize bytes it syncs dst and calls
+// checkpoint with how many bytes of the stream are on stable storage.
+type resumeWriter struct {
+	dst        syncWriter
+	skip       int64 // bytes of the stream still to skip
+	written    int64 // bytes of the stream written, skipped ones included
+	lastSynced int64
+	checkpoint func(written int64)
+}
+
+func newResumeWriter(dst syncWriter, offset int64, checkpoint func(written int64)) *resumeWriter {
+	return &resumeWriter{
+		dst:        dst,
+		skip:       offset,
+		written:    offset,
+		lastSynced: offset,
+		checkpoint: checkpoint,
+	}
+}
+
+// Write writes what is past the skipped part of the stream
+func (w *resumeWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	if w.skip >= int64(n) {
+		w.skip -= int64(n)
+		return n, nil
+	}
+	p = p[w.skip:]
+	w.skip = 0
+	written, err := w.dst.Write(p)
+	w.written += int64(written)
+	if err != nil {
+		return n - len(p) + written, err
+	}
+	if w.checkpoint != nil && w.written-w.lastSynced >= partitionCheckpointSize {
+		if err := w.dst.Sync(); err != nil {
+			return n, fmt.Errorf("sync after %d bytes: %w", w.written, err)
+		}
+		w.lastSynced = w.written
+		w.checkpoint(w.written)
+	}
+	return n, nil
+}
+
+// done checks that the whole skipped part was in the stream, i.e. that it
+// is the stream the interrupted write was writing, and syncs dst
+func (w *resumeWriter) done() error {
+	if w.skip != 0 {
+		return fmt.Errorf("stream of %d bytes shorter than the %d bytes already written",
+			w.written-w.skip, w.written)
+	}
+	return w.dst.Sync()
+}
@@ -0,0 +1,86 @@
+// Copyright (c) 2023 Zededa, Inc.
+// SPDX-License-Identifier: Apache-2.0
+
+package zboot
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeStream writes data to w in chunks of size
+func writeStream(t *testing.T, w io.Writer, data []byte, size int) error {
+	for len(data) != 0 {
+		n := size
+		if n > len(data) {
+			n = len(data)
+		}
+		written, err := w.Write(data[:n])
+		if err != nil {
+			return err
+		}
+		assert.Equal(t, n, written)
+		data = data[n:]
+	}
+	return nil
+}
+
+func TestResumeWriter(t *testing.T) {
+	saved := partitionCheckpointSize
+	partitionCheckpointSize = 1024
+	defer func() { partitionCheckpointSize = saved }()
+
+	data := make([]byte, 10*1024+100)
+	rand.New(rand.NewSource(1)).Read(data)
+	path := filepath.Join(t.TempDir(), "partition")
+
+	// The first write is interrupted after 5000 bytes
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var checkpoints []int64
+	w := newResumeWriter(f, 0, func(written int64) {
+		checkpoints = append(checkpoints, written)
+	})
+	assert.NoError(t, writeStream(t, w, data[:5000], 300))
+	assert.NoError(t, f.Close())
+	assert.Equal(t, []int64{1200, 2400, 3600, 4800}, checkpoints)
+
+	// Whatever was written past the last checkpoint is written again;
+	// the skipped part is not written
+	offset := checkpoints[len(checkpoints)-1]
+	f, err = os.OpenFile(path, os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.Seek(offset, io.SeekStart)
+	assert.NoError(t, err)
+	checkpoints = nil
+	w = newResumeWriter(f, offset, func(written int64) {
+		checkpoints = append(checkpoints, written)
+	})
+	assert.NoError(t, writeStream(t, w, data, 700))
+	assert.NoError(t, w.done())
+	assert.NoError(t, f.Close())
+	assert.Equal(t, int64(6300), checkpoints[0])
+	written, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.True(t, bytes.Equal(data, written))
+
+	// A stream shorter than what was written is not the same one
+	f, err = os.OpenFile(path, os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w = newResumeWriter(f, int64(len(data)), nil)
+	assert.NoError(t, writeStream(t, w, data[:100], 100))
+	assert.Error(t, w.done())
+}
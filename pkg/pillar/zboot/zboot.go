@@ -9,6 +9,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"strconv"
@@ -382,6 +383,16 @@ func GetOtherPartitionDevName() string {
 
 // WriteToPartition write the image to partition partName
 func WriteToPartition(log *base.LogObject, image string, partName string) error {
+	return WriteToPartitionFrom(log, image, partName, 0, nil)
+}
+
+// WriteToPartitionFrom write the image to partition partName, resuming an
+// interrupted write of the same image which wrote its first offset bytes.
+// If checkpoint is set, it is called with the number of bytes written and
+// synced to the partition every so often, to be passed as offset if this
+// write is interrupted in turn.
+func WriteToPartitionFrom(log *base.LogObject, image string, partName string,
+	offset int64, checkpoint func(written int64)) error {
 
 	var (
 		casClient cas.CAS
@@ -401,7 +412,8 @@ func WriteToPartition(log *base.LogObject, image string, partName string) error
 		return errors.New(errStr)
 	}
 
-	log.Functionf("WriteToPartition %s, %s: %v\n", partName, devName, image)
+	log.Functionf("WriteToPartition %s, %s: %v from %d\n", partName, devName,
+		image, offset)
 
 	// use the edge-containers library to extract the data we need
 	puller := registry.Puller{
@@ -433,20 +445,38 @@ func WriteToPartition(log *base.LogObject, image string, partName string) error
 	}
 	// create a writer for the file where we want
 	// Avoid holding the lock since this can take a long time.
-	f, err := os.OpenFile(devName,
-		os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	flags := os.O_APPEND | os.O_CREATE | os.O_WRONLY
+	if offset != 0 {
+		// Write past what the interrupted write wrote
+		flags = os.O_CREATE | os.O_WRONLY
+	}
+	f, err := os.OpenFile(devName, flags, 0644)
 	if err != nil {
 		errStr := fmt.Sprintf("error writing to partition device at %s: %v", devName, err)
 		log.Error(errStr)
 		return errors.New(errStr)
 	}
 	defer f.Close()
+	if offset != 0 {
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			errStr := fmt.Sprintf("error seeking partition device at %s to %d: %v",
+				devName, offset, err)
+			log.Error(errStr)
+			return errors.New(errStr)
+		}
+	}
+	w := newResumeWriter(f, offset, checkpoint)
 
-	if _, _, err := puller.Pull(&registry.FilesTarget{Root: f, AcceptHash: true}, 0, false, os.Stderr, resolver); err != nil {
+	if _, _, err := puller.Pull(&registry.FilesTarget{Root: w, AcceptHash: true}, 0, false, os.Stderr, resolver); err != nil {
 		errStr := fmt.Sprintf("error pulling %s from containerd: %v", image, err)
 		log.Error(errStr)
 		return errors.New(errStr)
 	}
+	if err := w.done(); err != nil {
+		errStr := fmt.Sprintf("error writing %s to %s: %v", image, devName, err)
+		log.Error(errStr)
+		return errors.New(errStr)
+	}
 	return nil
 }
 
@@ -7,6 +7,8 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"net"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -44,11 +46,15 @@ import (
 	"github.com/lf-edge/eve/pkg/pillar/cmd/zedmanager"
 	"github.com/lf-edge/eve/pkg/pillar/cmd/zedrouter"
 	"github.com/lf-edge/eve/pkg/pillar/cmd/zfsmanager"
+	"github.com/lf-edge/eve/pkg/pillar/objdeps"
 	"github.com/lf-edge/eve/pkg/pillar/pubsub"
+	"github.com/lf-edge/eve/pkg/pillar/pubsub/kvdriver"
 	"github.com/lf-edge/eve/pkg/pillar/pubsub/reverse"
 	"github.com/lf-edge/eve/pkg/pillar/pubsub/socketdriver"
+	"github.com/lf-edge/eve/pkg/pillar/pubsub/trace"
 	_ "github.com/lf-edge/eve/pkg/pillar/rstats"
 	"github.com/lf-edge/eve/pkg/pillar/types"
+	logutils "github.com/lf-edge/eve/pkg/pillar/utils/logging"
 	"github.com/lf-edge/eve/pkg/pillar/zedcloud"
 	"github.com/sirupsen/logrus"
 )
@@ -57,8 +63,59 @@ const (
 	agentName   = "zedbox"
 	errorTime   = 3 * time.Minute
 	warningTime = 40 * time.Second
+	// The subscriptions of an agent are recorded if this directory
+	// has a subdirectory named after the agent
+	pubsubTraceDir     = types.PersistDir + "/pubsub-trace"
+	pubsubTraceMaxSize = 64 * 1024 * 1024
+	// How many trace files are kept per agent, the one being written
+	// included
+	pubsubTraceMaxFiles = 4
 )
 
+// pubsubTraceTopics are the topics whose subscriptions are recorded,
+// which covers the inputs of zedmanager and zedrouter among others.
+// The trace is in the clear under /persist, hence the fields carrying
+// credentials, cloud-init data or other sensitive information are redacted
+// with pubsubTraceRedactFields; a topic must only be added here once its
+// sensitive fields are listed there. Topics which are all sensitive, e.g.
+// DatastoreConfig or the cipher topics, must not be added.
+var pubsubTraceTopics = map[string]bool{
+	"AppInstanceConfig":     true,
+	"AppInstanceStatus":     true,
+	"AppNetworkConfig":      true,
+	"AppNetworkStatus":      true,
+	"AssignableAdapters":    true,
+	"BaseOsStatus":          true,
+	"ContentTreeConfig":     true,
+	"ContentTreeStatus":     true,
+	"DeviceNetworkStatus":   true,
+	"DevicePortConfig":      true,
+	"DomainMetric":          true,
+	"DomainStatus":          true,
+	"DownloaderStatus":      true,
+	"HostMemory":            true,
+	"NetworkInstanceConfig": true,
+	"VerifyImageStatus":     true,
+	"VolumeConfig":          true,
+	"VolumeRefConfig":       true,
+	"VolumeRefStatus":       true,
+	"VolumeStatus":          true,
+	"VolumesSnapshotStatus": true,
+	"ZedAgentStatus":        true,
+}
+
+// pubsubTraceRedactFields are the fields recorded as null in the values of
+// pubsubTraceTopics: the cloud-init user data, the encrypted data of the
+// cipher blocks, the VNC password and the legacy wireless credentials.
+var pubsubTraceRedactFields = map[string]bool{
+	"CloudInitUserData": true,
+	"CipherData":        true,
+	"ClearTextHash":     true,
+	"VncPasswd":         true,
+	"Identity":          true,
+	"Password":          true,
+}
+
 type zedboxInline uint8
 
 const (
@@ -71,6 +128,9 @@ const (
 type entrypoint struct {
 	f      func(*pubsub.PubSub, *logrus.Logger, *base.LogObject, []string) int
 	inline zedboxInline
+	// kvStore keeps the persistent publications of the agent in a single
+	// store file instead of one file per key
+	kvStore bool
 }
 
 var (
@@ -88,11 +148,11 @@ var (
 		"nim":              {f: nim.Run},
 		"nodeagent":        {f: nodeagent.Run},
 		"verifier":         {f: verifier.Run},
-		"volumemgr":        {f: volumemgr.Run},
+		"volumemgr":        {f: volumemgr.Run, kvStore: true},
 		"waitforaddr":      {f: waitforaddr.Run, inline: inlineAlways},
 		"zedagent":         {f: zedagent.Run},
 		"zedmanager":       {f: zedmanager.Run},
-		"zedrouter":        {f: zedrouter.Run},
+		"zedrouter":        {f: zedrouter.Run, kvStore: true},
 		"ipcmonitor":       {f: ipcmonitor.Run, inline: inlineAlways},
 		"baseosmgr":        {f: baseosmgr.Run},
 		"wstunnelclient":   {f: wstunnelclient.Run},
@@ -106,6 +166,9 @@ var (
 	}
 	logger *logrus.Logger
 	log    *base.LogObject
+	// registry has the publications and subscriptions of the agents
+	// started by zedbox
+	registry = pubsub.NewRegistry()
 )
 
 func main() {
@@ -148,10 +211,14 @@ func runService(serviceName string, sep entrypoint, inline bool) int {
 	if inline {
 		log.Functionf("Running inline command %s args: %+v",
 			serviceName, arguments)
-		ps := pubsub.New(
-			&socketdriver.SocketDriver{Logger: logger, Log: log},
-			logger, log)
-		return sep.f(ps, logger, log, arguments)
+		driver := newDriver(serviceName, sep, logger, log)
+		ps := pubsub.New(driver, logger, log)
+		if serviceName == agentName {
+			ps.SetRegistry(registry, agentName)
+		}
+		retval := sep.f(ps, logger, log, arguments)
+		closeDriver(driver, log)
+		return retval
 	}
 	// Notify zedbox binary to start the agent/service
 	sericeInitStatus := types.ServiceInitStatus{
@@ -182,6 +249,10 @@ func runZedbox(ps *pubsub.PubSub, logger *logrus.Logger, log *base.LogObject, ar
 	stillRunning := time.NewTicker(15 * time.Second)
 	ps.StillRunning(agentName, warningTime, errorTime)
 
+	if err := serveIntrospection(types.PubSubIntrospectSocket); err != nil {
+		log.Errorf("zedbox: no pubsub introspection: %v", err)
+	}
+
 	subChan := reverse.NewSubscriber(log, agentName,
 		types.ServiceInitStatus{})
 	for {
@@ -210,30 +281,96 @@ func runZedbox(ps *pubsub.PubSub, logger *logrus.Logger, log *base.LogObject, ar
 func handleService(serviceName string, cmdArgs []string) {
 
 	log.Functionf("zedbox: Received command = %s args = %v", serviceName, cmdArgs)
-	srvLogger, srvLog := agentlog.Init(serviceName)
-	srvPs := pubsub.New(
-		&socketdriver.SocketDriver{
-			Logger: srvLogger,
-			Log:    srvLog,
-		},
-		srvLogger, srvLog)
 	sep, ok := entrypoints[serviceName]
 	if !ok {
 		log.Fatalf("zedbox: Unknown package: %s",
 			serviceName)
 	}
+	srvLogger, srvLog := agentlog.Init(serviceName)
+	srvDriver := newDriver(serviceName, sep, srvLogger, srvLog)
+	srvPs := pubsub.New(srvDriver, srvLogger, srvLog)
+	srvPs.SetRegistry(registry, serviceName)
 	log.Functionf("zedbox: Starting %s", serviceName)
-	go startAgentAndDone(sep, serviceName, srvPs, srvLogger, srvLog, cmdArgs)
+	go startAgentAndDone(sep, serviceName, srvDriver, srvPs, srvLogger, srvLog,
+		cmdArgs)
 	log.Functionf("zedbox: Started %s",
 		serviceName)
 }
 
+// serveIntrospection serves the registry, and the blockers of the app
+// instances found from it, on a Unix socket only accessible by root
+func serveIntrospection(socketPath string) error {
+	if err := os.Remove(socketPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(socketPath, 0600); err != nil {
+		listener.Close()
+		return err
+	}
+	log.Functionf("Creating %s at %s", "http.Serve", logutils.GetMyStack())
+	go func() {
+		mux := http.NewServeMux()
+		mux.Handle("/", registry)
+		mux.Handle("/apps", objdeps.Handler{Source: registry})
+		err := http.Serve(listener, mux)
+		log.Errorf("serveIntrospection: %v", err)
+	}()
+	return nil
+}
+
+// newDriver returns the pubsub driver for the agent. All agents can load
+// from the stores of the agents which have kvStore set.
+func newDriver(serviceName string, sep entrypoint, logger *logrus.Logger,
+	log *base.LogObject) pubsub.Driver {
+
+	driver := &kvdriver.KVDriver{
+		SocketDriver: socketdriver.SocketDriver{
+			Logger: logger,
+			Log:    log,
+		},
+		PublishToStore: sep.kvStore,
+	}
+	traceDir := filepath.Join(pubsubTraceDir, serviceName)
+	if _, err := os.Stat(traceDir); err != nil {
+		return driver
+	}
+	f, err := trace.CreateFile(traceDir, pubsubTraceMaxFiles)
+	if err != nil {
+		log.Errorf("newDriver(%s): not recording pubsub: %v", serviceName, err)
+		return driver
+	}
+	log.Noticef("newDriver(%s): recording pubsub to %s", serviceName, f.Name())
+	return &trace.RecordingDriver{
+		Driver:       driver,
+		Writer:       trace.NewWriter(f, pubsubTraceMaxSize),
+		Topics:       pubsubTraceTopics,
+		RedactFields: pubsubTraceRedactFields,
+		Log:          log,
+	}
+}
+
+// closeDriver closes the pubsub driver of an agent which is done, if it
+// holds a trace file open
+func closeDriver(driver pubsub.Driver, log *base.LogObject) {
+	if recorder, ok := driver.(*trace.RecordingDriver); ok {
+		if err := recorder.Close(); err != nil {
+			log.Errorf("closeDriver: %v", err)
+		}
+	}
+}
+
 // startAgentAndDone starts the given agent. Writes the return/exit value to
 // <agentName>.done file should the agent return.
-func startAgentAndDone(sep entrypoint, agentName string, srvPs *pubsub.PubSub,
-	srvLogger *logrus.Logger, srvLog *base.LogObject, cmdArgs []string) {
+func startAgentAndDone(sep entrypoint, agentName string, srvDriver pubsub.Driver,
+	srvPs *pubsub.PubSub, srvLogger *logrus.Logger, srvLog *base.LogObject,
+	cmdArgs []string) {
 
 	retval := sep.f(srvPs, srvLogger, srvLog, cmdArgs)
+	closeDriver(srvDriver, srvLog)
 
 	ret := strconv.Itoa(retval)
 	if err := os.WriteFile(fmt.Sprintf("/run/%s.done", agentName),